@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloud-gov/s3-broker/auditlog"
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+const auditUsage = `usage: s3-broker -config FILE audit verify [-file FILE]`
+
+// runAuditCommand runs "audit verify", which checks the hash chain of the
+// audit log file and its rotated files, oldest first.
+func runAuditCommand(config broker.AuditLogConfig, args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New(auditUsage)
+	}
+
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	file := flags.String("file", config.File, "Audit log file")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("audit_log.file must be configured, or a file given with -file")
+	}
+
+	var names []string
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%d", *file, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		names = append([]string{name}, names...)
+	}
+	names = append(names, *file)
+
+	var last auditlog.Record
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		last, err = auditlog.Verify(f, []byte(config.HMACKey), last)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	fmt.Printf("Verified audit log through record %d\n", last.Sequence)
+	return nil
+}
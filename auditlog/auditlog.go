@@ -0,0 +1,133 @@
+// Package auditlog writes a tamper-evident audit trail. Each record carries
+// the hash of the record before it, so records cannot be changed, removed or
+// reordered without breaking the chain, which Verify checks. With a key, the
+// hashes are HMACs, so the chain cannot be rebuilt without the key either.
+package auditlog
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxRecordSize bounds the records that Verify and LastRecord read.
+const maxRecordSize = 1 << 20
+
+// Record is one entry of the trail, written as a line of JSON.
+type Record struct {
+	// Sequence numbers the records of a chain from 1.
+	Sequence uint64          `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Event    json.RawMessage `json:"event"`
+	// PrevHash is the Hash of the record before, which is empty for the
+	// first record of a chain.
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// Sink stores the lines of the trail.
+type Sink interface {
+	Write(ctx context.Context, line []byte) error
+}
+
+// Log appends events to a trail.
+type Log struct {
+	mu   sync.Mutex
+	sink Sink
+	key  []byte
+	last Record
+	now  func() time.Time
+}
+
+// New returns a Log that writes to sink, continuing the chain after last,
+// the last record that sink has, or starting a chain if last is the zero
+// Record.
+func New(sink Sink, key []byte, last Record) *Log {
+	return &Log{
+		sink: sink,
+		key:  key,
+		last: last,
+		now:  time.Now,
+	}
+}
+
+// Write appends event, as JSON, to the trail. A record that cannot be written
+// is not part of the chain, so the next record follows the last one written.
+func (l *Log) Write(ctx context.Context, event any) error {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	record := Record{
+		Sequence: l.last.Sequence + 1,
+		Time:     l.now().UTC(),
+		Event:    eventJSON,
+		PrevHash: l.last.Hash,
+	}
+	record.Hash = recordHash(l.key, record)
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := l.sink.Write(ctx, append(line, '\n')); err != nil {
+		return err
+	}
+	l.last = record
+	return nil
+}
+
+// recordHash hashes record without its Hash.
+func recordHash(key []byte, record Record) string {
+	record.Hash = ""
+	data, _ := json.Marshal(record)
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks the chain of the records in r, which follow after, the last
+// record of the part of the trail before r. With the zero Record, the chain
+// is checked from the first record in r, which need not start the chain, as
+// the oldest records of a rotated trail are removed. Verify returns the last
+// record, so that the files of a rotated trail can be verified in order.
+func Verify(r io.Reader, key []byte, after Record) (Record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordSize)
+	last, linked := after, after.Sequence > 0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return last, fmt.Errorf("record after %d is not valid JSON: %s", last.Sequence, err)
+		}
+		if linked && record.Sequence != last.Sequence+1 {
+			return last, fmt.Errorf("record %d follows record %d", record.Sequence, last.Sequence)
+		}
+		if linked && record.PrevHash != last.Hash {
+			return last, fmt.Errorf("record %d does not follow the hash of record %d", record.Sequence, last.Sequence)
+		}
+		if !hmac.Equal([]byte(record.Hash), []byte(recordHash(key, record))) {
+			return last, fmt.Errorf("record %d does not match its hash", record.Sequence)
+		}
+		last, linked = record, true
+	}
+	return last, scanner.Err()
+}
@@ -0,0 +1,116 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) Write(ctx context.Context, line []byte) error {
+	_, err := s.Buffer.Write(line)
+	return err
+}
+
+func writeTrail(t *testing.T, key []byte, events ...string) string {
+	t.Helper()
+	sink := &bufferSink{}
+	log := New(sink, key, Record{})
+	log.now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+	for _, event := range events {
+		if err := log.Write(context.Background(), map[string]string{"action": event}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	return sink.String()
+}
+
+func TestVerify(t *testing.T) {
+	key := []byte("secret")
+	trail := writeTrail(t, key, "provision", "bind", "unbind")
+	lines := strings.SplitAfter(trail, "\n")
+
+	testCases := map[string]struct {
+		trail      string
+		key        []byte
+		expectErr  string
+		expectLast uint64
+	}{
+		"intact": {
+			trail:      trail,
+			key:        key,
+			expectLast: 3,
+		},
+		"changed event": {
+			trail:     strings.Replace(trail, `"bind"`, `"provision"`, 1),
+			key:       key,
+			expectErr: "record 2 does not match its hash",
+		},
+		"removed record": {
+			trail:     lines[0] + lines[2],
+			key:       key,
+			expectErr: "record 3 follows record 1",
+		},
+		"reordered records": {
+			trail:     lines[0] + lines[2] + lines[1],
+			key:       key,
+			expectErr: "record 3 follows record 1",
+		},
+		"wrong key": {
+			trail:     trail,
+			key:       []byte("guess"),
+			expectErr: "record 1 does not match its hash",
+		},
+		"oldest records rotated away": {
+			trail:      lines[1] + lines[2],
+			key:        key,
+			expectLast: 3,
+		},
+		"not JSON": {
+			trail:     lines[0] + "garbage\n",
+			key:       key,
+			expectErr: "record after 1 is not valid JSON",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			last, err := Verify(strings.NewReader(tc.trail), tc.key, Record{})
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if last.Sequence != tc.expectLast {
+				t.Errorf("expected the last record to be %d, got %d", tc.expectLast, last.Sequence)
+			}
+		})
+	}
+}
+
+func TestWriteContinuesChain(t *testing.T) {
+	first := writeTrail(t, nil, "provision")
+	last, err := Verify(strings.NewReader(first), nil, Record{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	sink := &bufferSink{}
+	if err := New(sink, nil, last).Write(context.Background(), "deprovision"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := Verify(strings.NewReader(sink.String()), nil, last); err != nil {
+		t.Errorf("expected the new record to follow the last, got %s", err)
+	}
+	if _, err := Verify(strings.NewReader(sink.String()), nil, Record{Sequence: 1, Hash: "other"}); err == nil {
+		t.Error("expected a record that follows another chain to fail")
+	}
+}
@@ -0,0 +1,78 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type CloudWatchLogsClient interface {
+	CreateLogStreamWithContext(ctx aws.Context, input *cloudwatchlogs.CreateLogStreamInput, opts ...request.Option) (*cloudwatchlogs.CreateLogStreamOutput, error)
+	PutLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, opts ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error)
+}
+
+// CloudWatchSink sends the trail to a CloudWatch Logs log stream, one log
+// event per record.
+type CloudWatchSink struct {
+	mu     sync.Mutex
+	client CloudWatchLogsClient
+	group  string
+	stream string
+	retry  awsretry.Policy
+}
+
+// NewCloudWatchSink creates the log stream in group, if it does not exist.
+// Each chain should have a stream of its own, so that Verify can check the
+// stream's records from the first.
+func NewCloudWatchSink(ctx context.Context, client CloudWatchLogsClient, group, stream string, retry awsretry.Policy) (*CloudWatchSink, error) {
+	_, err := awsretry.Call(ctx, retry.For("CreateLogStream"), func() (*cloudwatchlogs.CreateLogStreamOutput, error) {
+		return client.CreateLogStreamWithContext(ctx, &cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  aws.String(group),
+			LogStreamName: aws.String(stream),
+		})
+	})
+	var awsErr awserr.Error
+	if err != nil && !(errors.As(err, &awsErr) && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException) {
+		return nil, err
+	}
+	return &CloudWatchSink{
+		client: client,
+		group:  group,
+		stream: stream,
+		retry:  retry,
+	}, nil
+}
+
+func (s *CloudWatchSink) Write(ctx context.Context, line []byte) error {
+	// Log events of a stream must be put one call at a time to keep
+	// their order.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	putInput := &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(s.group),
+		LogStreamName: aws.String(s.stream),
+		LogEvents: []*cloudwatchlogs.InputLogEvent{{
+			Message:   aws.String(string(bytes.TrimSuffix(line, []byte("\n")))),
+			Timestamp: aws.Int64(time.Now().UnixMilli()),
+		}},
+	}
+	putOutput, err := awsretry.Call(ctx, s.retry.For("PutLogEvents"), func() (*cloudwatchlogs.PutLogEventsOutput, error) {
+		return s.client.PutLogEventsWithContext(ctx, putInput)
+	})
+	if err != nil {
+		return err
+	}
+	if putOutput.RejectedLogEventsInfo != nil {
+		return errors.New("log event was rejected")
+	}
+	return nil
+}
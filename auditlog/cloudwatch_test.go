@@ -0,0 +1,73 @@
+package auditlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type mockCloudWatchLogsClient struct {
+	streamExists bool
+	reject       bool
+	messages     []string
+}
+
+func (c *mockCloudWatchLogsClient) CreateLogStreamWithContext(ctx aws.Context, input *cloudwatchlogs.CreateLogStreamInput, opts ...request.Option) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	if c.streamExists {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "exists", nil)
+	}
+	c.streamExists = true
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (c *mockCloudWatchLogsClient) PutLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, opts ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	if c.reject {
+		return &cloudwatchlogs.PutLogEventsOutput{
+			RejectedLogEventsInfo: &cloudwatchlogs.RejectedLogEventsInfo{TooOldLogEventEndIndex: aws.Int64(1)},
+		}, nil
+	}
+	for _, event := range input.LogEvents {
+		c.messages = append(c.messages, aws.StringValue(event.Message))
+	}
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+func TestCloudWatchSink(t *testing.T) {
+	testCases := map[string]struct {
+		streamExists bool
+		reject       bool
+		expectErr    bool
+	}{
+		"new stream":      {},
+		"existing stream": {streamExists: true},
+		"rejected event":  {reject: true, expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &mockCloudWatchLogsClient{streamExists: tc.streamExists, reject: tc.reject}
+			sink, err := NewCloudWatchSink(context.Background(), client, "audit", "s3-broker", awsretry.Policy{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			err = New(sink, nil, Record{}).Write(context.Background(), "provision")
+			if tc.expectErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(client.messages) != 1 {
+				t.Errorf("expected one log event, got %v", client.messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package auditlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// FileSink appends the trail to a file, which is rotated when it would grow
+// beyond a maximum size. Rotated files are named after the file with a
+// number appended, path.1 being the most recent.
+type FileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFileSink opens the trail at path. With a maxSize of 0 the file is never
+// rotated; otherwise maxBackups rotated files are kept.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	// The trail records who changed what, so only the owner may read it.
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(ctx context.Context, line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotating %s: %w", s.path, err)
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// rotate moves the file to path.1, and each rotated file to the next number,
+// removing the oldest.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	for i := s.maxBackups; i > 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.path, i-1), fmt.Sprintf("%s.%d", s.path, i))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	var err error
+	if s.maxBackups > 0 {
+		err = os.Rename(s.path, s.path+".1")
+	} else {
+		err = os.Remove(s.path)
+	}
+	if err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// LastRecord returns the last record of the trail at path, so that a new Log
+// continues its chain. The most recent rotated file is read if path has no
+// records. A trail that does not exist yet has no last record.
+func LastRecord(path string) (Record, error) {
+	for _, name := range []string{path, path + ".1"} {
+		record, err := lastRecord(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return Record{}, err
+		}
+		if record.Sequence > 0 {
+			return record, nil
+		}
+	}
+	return Record{}, nil
+}
+
+func lastRecord(path string) (Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Record{}, err
+	}
+	defer file.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxRecordSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil || last == nil {
+		return Record{}, err
+	}
+	var record Record
+	if err := json.Unmarshal(last, &record); err != nil {
+		return Record{}, fmt.Errorf("last record of %s: %w", path, err)
+	}
+	return record, nil
+}
@@ -0,0 +1,93 @@
+package auditlog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, 300, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	log := New(sink, nil, Record{})
+	for range 10 {
+		if err := log.Write(context.Background(), map[string]string{"action": "provision"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	sink.Close()
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files to be kept, got %v", err)
+	}
+	var last Record
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("expected %s to be rotated at 300 bytes, got %d", name, info.Size())
+		}
+		file, _ := os.Open(name)
+		last, err = Verify(file, nil, last)
+		file.Close()
+		if err != nil {
+			t.Fatalf("unexpected error verifying %s: %s", name, err)
+		}
+	}
+	if last.Sequence != 10 {
+		t.Errorf("expected the last record to be 10, got %d", last.Sequence)
+	}
+}
+
+func TestLastRecord(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+
+	record, err := LastRecord(path)
+	if err != nil || record.Sequence != 0 {
+		t.Fatalf("expected no last record of a new trail, got %+v, %v", record, err)
+	}
+
+	sink, err := NewFileSink(path, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	log := New(sink, nil, Record{})
+	log.Write(context.Background(), "provision")
+	log.Write(context.Background(), "bind")
+	sink.Close()
+
+	// A restarted broker continues the chain.
+	record, err = LastRecord(path)
+	if err != nil || record.Sequence != 2 {
+		t.Fatalf("expected the last record to be 2, got %+v, %v", record, err)
+	}
+	sink, err = NewFileSink(path, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	New(sink, nil, record).Write(context.Background(), "unbind")
+	sink.Close()
+	file, _ := os.Open(path)
+	defer file.Close()
+	if last, err := Verify(file, nil, Record{}); err != nil || last.Sequence != 3 {
+		t.Errorf("expected an intact chain of 3 records, got %d, %v", last.Sequence, err)
+	}
+
+	// Right after a rotation, the last record is in the rotated file.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := LastRecord(path); err != nil || record.Sequence != 3 {
+		t.Errorf("expected the last record to be 3, got %+v, %v", record, err)
+	}
+}
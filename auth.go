@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/tokenauth"
+)
+
+// authMiddleware returns the middleware that authenticates the broker API:
+// basic auth with the configured credentials, bearer tokens from the OAuth2
+// issuer, or either when both are configured.
+func authMiddleware(config *Config, credentials *brokerCredentials, logger lager.Logger) (func(http.Handler) http.Handler, error) {
+	var basic func(http.Handler) http.Handler
+	if !credentials.Empty() {
+		basic = credentials.Wrap
+	}
+	if !config.OAuth2.Enabled() {
+		return basic, nil
+	}
+	verifier, err := tokenauth.NewVerifier(config.OAuth2, logger)
+	if err != nil {
+		return nil, err
+	}
+	return verifier.Middleware(basic, logger), nil
+}
@@ -0,0 +1,118 @@
+package awscfn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// Drift detects whether the bucket or its policy differ from the bucket's
+// stack, and describes each difference. Tags and policy statements that the
+// broker adds to buckets outside of their stacks, such as those of bindings,
+// are not differences. Buckets without a stack have none.
+func (b *StackBucket) Drift(ctx context.Context, bucketName string) ([]string, error) {
+	details, err := b.Bucket.Describe(ctx, bucketName, "")
+	if err != nil {
+		return nil, err
+	}
+	client := b.client(details.Region)
+	stackName := b.stackName(bucketName)
+
+	detectDriftInput := &cloudformation.DetectStackDriftInput{
+		StackName: aws.String(stackName),
+	}
+	b.logger.Debug("detect-stack-drift", lager.Data{"input": detectDriftInput})
+	detectDriftOutput, err := client.DetectStackDriftWithContext(ctx, detectDriftInput)
+	if isStackNotFound(err) {
+		b.logger.Debug("detect-stack-drift.no-stack", lager.Data{"bucket": bucketName})
+		return nil, nil
+	}
+	if err != nil {
+		b.logger.Error("detect-stack-drift", err, lager.Data{"bucket": bucketName})
+		return nil, convertError(err)
+	}
+	if err := b.waitForDriftDetection(ctx, client, aws.StringValue(detectDriftOutput.StackDriftDetectionId)); err != nil {
+		b.logger.Error("detect-stack-drift", err, lager.Data{"bucket": bucketName})
+		return nil, err
+	}
+
+	var differences []string
+	describeDriftsInput := &cloudformation.DescribeStackResourceDriftsInput{
+		StackName: aws.String(stackName),
+		StackResourceDriftStatusFilters: aws.StringSlice([]string{
+			cloudformation.StackResourceDriftStatusModified,
+			cloudformation.StackResourceDriftStatusDeleted,
+		}),
+	}
+	err = client.DescribeStackResourceDriftsPagesWithContext(ctx, describeDriftsInput, func(page *cloudformation.DescribeStackResourceDriftsOutput, _ bool) bool {
+		for _, drift := range page.StackResourceDrifts {
+			logicalID := aws.StringValue(drift.LogicalResourceId)
+			if aws.StringValue(drift.StackResourceDriftStatus) == cloudformation.StackResourceDriftStatusDeleted {
+				differences = append(differences, logicalID+" deleted")
+				continue
+			}
+			for _, difference := range drift.PropertyDifferences {
+				path, differenceType := aws.StringValue(difference.PropertyPath), aws.StringValue(difference.DifferenceType)
+				if isBrokerAddition(logicalID, path, differenceType) {
+					continue
+				}
+				differences = append(differences, fmt.Sprintf("%s%s %s", logicalID, path, differenceType))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		b.logger.Error("describe-stack-resource-drifts", err, lager.Data{"bucket": bucketName})
+		return nil, convertError(err)
+	}
+	return differences, nil
+}
+
+// waitForDriftDetection waits until a drift detection has finished.
+func (b *StackBucket) waitForDriftDetection(ctx context.Context, client CloudFormationClient, detectionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	statusInput := &cloudformation.DescribeStackDriftDetectionStatusInput{
+		StackDriftDetectionId: aws.String(detectionID),
+	}
+	for {
+		statusOutput, err := client.DescribeStackDriftDetectionStatusWithContext(ctx, statusInput)
+		if err != nil {
+			return convertError(err)
+		}
+		switch aws.StringValue(statusOutput.DetectionStatus) {
+		case cloudformation.StackDriftDetectionStatusDetectionComplete:
+			return nil
+		case cloudformation.StackDriftDetectionStatusDetectionFailed:
+			return fmt.Errorf("drift detection failed: %s", aws.StringValue(statusOutput.DetectionStatusReason))
+		}
+
+		timer := time.NewTimer(b.pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isBrokerAddition reports whether a property difference is a tag or policy
+// statement that the broker added to a bucket outside of its stack.
+func isBrokerAddition(logicalID, path, differenceType string) bool {
+	if differenceType != cloudformation.DifferenceTypeAdd {
+		return false
+	}
+	switch logicalID {
+	case bucketResource:
+		return strings.HasPrefix(path, "/Tags/")
+	case bucketPolicyResource:
+		return strings.HasPrefix(path, "/PolicyDocument/Statement/")
+	}
+	return false
+}
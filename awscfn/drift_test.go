@@ -0,0 +1,112 @@
+package awscfn
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+func (c *mockCloudFormationClient) DetectStackDriftWithContext(aws.Context, *cloudformation.DetectStackDriftInput, ...request.Option) (*cloudformation.DetectStackDriftOutput, error) {
+	if !c.stackExists {
+		return nil, errStackNotFound
+	}
+	return &cloudformation.DetectStackDriftOutput{StackDriftDetectionId: aws.String("detection1")}, nil
+}
+
+func (c *mockCloudFormationClient) DescribeStackDriftDetectionStatusWithContext(aws.Context, *cloudformation.DescribeStackDriftDetectionStatusInput, ...request.Option) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error) {
+	status := c.detections[0]
+	if len(c.detections) > 1 {
+		c.detections = c.detections[1:]
+	}
+	return &cloudformation.DescribeStackDriftDetectionStatusOutput{DetectionStatus: aws.String(status), DetectionStatusReason: aws.String("stack is being updated")}, nil
+}
+
+func (c *mockCloudFormationClient) DescribeStackResourceDriftsPagesWithContext(_ aws.Context, _ *cloudformation.DescribeStackResourceDriftsInput, fn func(*cloudformation.DescribeStackResourceDriftsOutput, bool) bool, _ ...request.Option) error {
+	fn(&cloudformation.DescribeStackResourceDriftsOutput{StackResourceDrifts: c.drifts}, true)
+	return nil
+}
+
+func propertyDifference(path, differenceType string) *cloudformation.PropertyDifference {
+	return &cloudformation.PropertyDifference{PropertyPath: aws.String(path), DifferenceType: aws.String(differenceType)}
+}
+
+func TestDrift(t *testing.T) {
+	modified := aws.String(cloudformation.StackResourceDriftStatusModified)
+	testCases := map[string]struct {
+		stackExists bool
+		detections  []string
+		drifts      []*cloudformation.StackResourceDrift
+		expected    []string
+		expectErr   bool
+	}{
+		"bucket without a stack": {},
+		"no drift": {
+			stackExists: true,
+			detections:  []string{cloudformation.StackDriftDetectionStatusDetectionInProgress, cloudformation.StackDriftDetectionStatusDetectionComplete},
+		},
+		"drift": {
+			stackExists: true,
+			detections:  []string{cloudformation.StackDriftDetectionStatusDetectionComplete},
+			drifts: []*cloudformation.StackResourceDrift{
+				{LogicalResourceId: aws.String(bucketResource), StackResourceDriftStatus: modified, PropertyDifferences: []*cloudformation.PropertyDifference{
+					propertyDifference("/Tags/3", cloudformation.DifferenceTypeAdd),
+					propertyDifference("/VersioningConfiguration/Status", cloudformation.DifferenceTypeNotEqual),
+					propertyDifference("/Tags/0/Value", cloudformation.DifferenceTypeNotEqual),
+				}},
+				{LogicalResourceId: aws.String(bucketPolicyResource), StackResourceDriftStatus: modified, PropertyDifferences: []*cloudformation.PropertyDifference{
+					propertyDifference("/PolicyDocument/Statement/1", cloudformation.DifferenceTypeAdd),
+					propertyDifference("/PolicyDocument/Statement/0", cloudformation.DifferenceTypeRemove),
+				}},
+			},
+			expected: []string{"Bucket/VersioningConfiguration/Status NOT_EQUAL", "Bucket/Tags/0/Value NOT_EQUAL", "BucketPolicy/PolicyDocument/Statement/0 REMOVE"},
+		},
+		"deleted policy": {
+			stackExists: true,
+			detections:  []string{cloudformation.StackDriftDetectionStatusDetectionComplete},
+			drifts: []*cloudformation.StackResourceDrift{
+				{LogicalResourceId: aws.String(bucketPolicyResource), StackResourceDriftStatus: aws.String(cloudformation.StackResourceDriftStatusDeleted)},
+			},
+			expected: []string{"BucketPolicy deleted"},
+		},
+		"bindings only": {
+			stackExists: true,
+			detections:  []string{cloudformation.StackDriftDetectionStatusDetectionComplete},
+			drifts: []*cloudformation.StackResourceDrift{
+				{LogicalResourceId: aws.String(bucketPolicyResource), StackResourceDriftStatus: modified, PropertyDifferences: []*cloudformation.PropertyDifference{
+					propertyDifference("/PolicyDocument/Statement/1", cloudformation.DifferenceTypeAdd),
+				}},
+			},
+		},
+		"detection failed": {
+			stackExists: true,
+			detections:  []string{cloudformation.StackDriftDetectionStatusDetectionFailed},
+			expectErr:   true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &mockCloudFormationClient{stackExists: tc.stackExists, detections: tc.detections, drifts: tc.drifts}
+			b := newTestStackBucket(&mockBucket{current: awss3.BucketDetails{Region: "us-east-1"}}, client, nil)
+
+			differences, err := b.Drift(context.Background(), "bucket")
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !slices.Equal(differences, tc.expected) {
+				t.Errorf("expected differences %v, got %v", tc.expected, differences)
+			}
+		})
+	}
+}
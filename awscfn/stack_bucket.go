@@ -0,0 +1,408 @@
+// Package awscfn provisions buckets through CloudFormation, with a stack for
+// each bucket, so that a provision that fails part way is rolled back as a
+// whole and changes made to a bucket outside of the broker can be detected.
+package awscfn
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+const (
+	DefaultStackPrefix = "s3-broker"
+	// DefaultTimeout is how long the broker waits for a stack to be created,
+	// updated or deleted, or for its drift to be detected.
+	DefaultTimeout = 10 * time.Minute
+)
+
+// defaultPollInterval is how often the status of a stack, or of a drift
+// detection, is checked while the broker waits for it.
+const defaultPollInterval = 5 * time.Second
+
+var ErrStackFailed = errors.New("cloudformation stack failed")
+
+type CloudFormationClient interface {
+	CreateStackWithContext(ctx aws.Context, input *cloudformation.CreateStackInput, opts ...request.Option) (*cloudformation.CreateStackOutput, error)
+	UpdateStackWithContext(ctx aws.Context, input *cloudformation.UpdateStackInput, opts ...request.Option) (*cloudformation.UpdateStackOutput, error)
+	DeleteStackWithContext(ctx aws.Context, input *cloudformation.DeleteStackInput, opts ...request.Option) (*cloudformation.DeleteStackOutput, error)
+	DescribeStacksWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error)
+	DescribeStackEventsWithContext(ctx aws.Context, input *cloudformation.DescribeStackEventsInput, opts ...request.Option) (*cloudformation.DescribeStackEventsOutput, error)
+	WaitUntilStackCreateCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.WaiterOption) error
+	WaitUntilStackUpdateCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.WaiterOption) error
+	WaitUntilStackDeleteCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.WaiterOption) error
+	DetectStackDriftWithContext(ctx aws.Context, input *cloudformation.DetectStackDriftInput, opts ...request.Option) (*cloudformation.DetectStackDriftOutput, error)
+	DescribeStackDriftDetectionStatusWithContext(ctx aws.Context, input *cloudformation.DescribeStackDriftDetectionStatusInput, opts ...request.Option) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error)
+	DescribeStackResourceDriftsPagesWithContext(ctx aws.Context, input *cloudformation.DescribeStackResourceDriftsInput, fn func(*cloudformation.DescribeStackResourceDriftsOutput, bool) bool, opts ...request.Option) error
+}
+
+type Config struct {
+	// StackPrefix starts the name of each bucket's stack, which is followed
+	// by the bucket's name. Defaults to DefaultStackPrefix.
+	StackPrefix string
+	// Timeout defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Retry controls backoff for CloudFormation calls.
+	Retry awsretry.Policy
+	// Region is the region of buckets created without one.
+	Region string
+	// Client returns a CloudFormation client for a region. A bucket's stack
+	// is in the bucket's region.
+	Client func(region string) CloudFormationClient
+}
+
+// StackBucket creates, modifies and deletes buckets through CloudFormation
+// stacks, and manages them otherwise through the S3 API with the Bucket it
+// embeds. A stack has the bucket and its policy, which are retained when the
+// stack is deleted, so that the broker empties and deletes buckets itself
+// and buckets that are preserved on delete are kept.
+type StackBucket struct {
+	awss3.Bucket
+
+	stackPrefix  string
+	timeout      time.Duration
+	pollInterval time.Duration
+	retry        awsretry.Policy
+	region       string
+	newClient    func(region string) CloudFormationClient
+	logger       lager.Logger
+
+	mu      sync.Mutex
+	clients map[string]CloudFormationClient
+}
+
+func NewStackBucket(bucket awss3.Bucket, logger lager.Logger, config Config) *StackBucket {
+	return &StackBucket{
+		Bucket:       bucket,
+		stackPrefix:  cmp.Or(config.StackPrefix, DefaultStackPrefix),
+		timeout:      cmp.Or(config.Timeout, DefaultTimeout),
+		pollInterval: defaultPollInterval,
+		retry:        config.Retry,
+		region:       config.Region,
+		newClient:    config.Client,
+		logger:       logger.Session("stack-bucket"),
+		clients:      map[string]CloudFormationClient{},
+	}
+}
+
+// Create creates a stack with the bucket, configured as bucketDetails says,
+// and waits for it. If any part of the stack fails, the stack and the bucket
+// are deleted. Calling Create again for a bucket whose stack is still being
+// created waits for that stack.
+func (b *StackBucket) Create(ctx context.Context, bucketName string, bucketDetails awss3.BucketDetails) (string, error) {
+	var policy map[string]any
+	if bucketDetails.Policy != "" {
+		bucketDetails.BucketName = bucketName
+		rendered, err := awss3.RenderBucketPolicy(bucketDetails)
+		if err != nil {
+			b.logger.Error("render-bucket-policy", err)
+			return "", err
+		}
+		if err := json.Unmarshal([]byte(rendered), &policy); err != nil {
+			return "", fmt.Errorf("%w: %s", awss3.ErrPolicyInvalid, err)
+		}
+	}
+	template, err := renderTemplate(bucketName, bucketDetails, versioningStatus(bucketDetails.Versioning, false), policy)
+	if err != nil {
+		b.logger.Error("render-template", err, lager.Data{"bucket": bucketName})
+		return "", err
+	}
+
+	client := b.client(cmp.Or(bucketDetails.Region, b.region))
+	createStackInput := &cloudformation.CreateStackInput{
+		StackName:    aws.String(b.stackName(bucketName)),
+		TemplateBody: aws.String(template),
+		OnFailure:    aws.String(cloudformation.OnFailureDelete),
+	}
+	b.logger.Debug("create-stack", lager.Data{"input": createStackInput})
+	_, err = awsretry.Call(ctx, b.retry.For("CreateStack"), func() (*cloudformation.CreateStackOutput, error) {
+		return client.CreateStackWithContext(ctx, createStackInput)
+	})
+	if err != nil && !isErrorCode(err, cloudformation.ErrCodeAlreadyExistsException) {
+		b.logger.Error("create-stack", err, lager.Data{"bucket": bucketName})
+		return "", convertError(err)
+	}
+	if err := b.wait(ctx, client, bucketName, client.WaitUntilStackCreateCompleteWithContext); err != nil {
+		return "", err
+	}
+	return "/" + bucketName, nil
+}
+
+// Modify updates the bucket's stack as awss3.S3Bucket.Modify updates a
+// bucket: tags are merged into the bucket's tags, the plan's policy
+// statements replace the previous plan's while statements with a Sid are
+// kept, and versioning is suspended if the plan no longer enables it.
+// Buckets without a stack, such as those created before the broker used
+// CloudFormation, are modified directly.
+func (b *StackBucket) Modify(ctx context.Context, bucketName string, bucketDetails awss3.BucketDetails) error {
+	current, err := b.Bucket.DescribeConfiguration(ctx, bucketName, bucketDetails.AwsPartition)
+	if err != nil {
+		return err
+	}
+	client := b.client(current.Region)
+	stackName := b.stackName(bucketName)
+
+	exists, err := b.stackExists(ctx, client, stackName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		b.logger.Info("modify-bucket-without-stack", lager.Data{"bucket": bucketName})
+		return b.Bucket.Modify(ctx, bucketName, bucketDetails)
+	}
+
+	configured := bucketDetails
+	configured.Tags = maps.Clone(current.Tags)
+	maps.Copy(configured.Tags, bucketDetails.Tags)
+	configured.Encryption = cmp.Or(bucketDetails.Encryption, current.Encryption)
+	configured.ObjectOwnership = current.ObjectOwnership
+	if bucketDetails.CORSRules == nil {
+		configured.CORSRules = current.CORSRules
+	}
+	if bucketDetails.LifecycleRules == nil {
+		configured.LifecycleRules = current.LifecycleRules
+	}
+
+	policy, err := b.modifiedPolicy(bucketName, bucketDetails, current.Policy)
+	if err != nil {
+		return err
+	}
+	template, err := renderTemplate(bucketName, configured, versioningStatus(bucketDetails.Versioning, current.Versioning), policy)
+	if err != nil {
+		b.logger.Error("render-template", err, lager.Data{"bucket": bucketName})
+		return err
+	}
+
+	updateStackInput := &cloudformation.UpdateStackInput{
+		StackName:    aws.String(stackName),
+		TemplateBody: aws.String(template),
+	}
+	b.logger.Debug("update-stack", lager.Data{"input": updateStackInput})
+	_, err = awsretry.Call(ctx, b.retry.For("UpdateStack"), func() (*cloudformation.UpdateStackOutput, error) {
+		return client.UpdateStackWithContext(ctx, updateStackInput)
+	})
+	switch {
+	case isNoUpdates(err):
+		b.logger.Debug("update-stack.no-changes", lager.Data{"bucket": bucketName})
+	case err != nil:
+		b.logger.Error("update-stack", err, lager.Data{"bucket": bucketName})
+		return convertError(err)
+	default:
+		if err := b.wait(ctx, client, bucketName, client.WaitUntilStackUpdateCompleteWithContext); err != nil {
+			return err
+		}
+	}
+
+	// A policy dropped from the template is retained, like the stack's
+	// resources are when it is deleted, so a bucket left without policy
+	// statements has its policy deleted directly.
+	if policy == nil && current.Policy != "" {
+		return b.Bucket.Modify(ctx, bucketName, bucketDetails)
+	}
+	return nil
+}
+
+// modifiedPolicy returns the bucket policy of a bucket whose policy is
+// currentPolicy once the plan's statements in bucketDetails replace those of
+// the previous plan, or nil if it has no statements left.
+func (b *StackBucket) modifiedPolicy(bucketName string, bucketDetails awss3.BucketDetails, currentPolicy string) (map[string]any, error) {
+	policy := map[string]any{"Version": "2012-10-17"}
+	if currentPolicy != "" {
+		if err := json.Unmarshal([]byte(currentPolicy), &policy); err != nil {
+			return nil, err
+		}
+	}
+	var rendered string
+	if bucketDetails.Policy != "" {
+		bucketDetails.BucketName = bucketName
+		var err error
+		if rendered, err = awss3.RenderBucketPolicy(bucketDetails); err != nil {
+			b.logger.Error("render-bucket-policy", err)
+			return nil, err
+		}
+	}
+	statements, err := awss3.ReplacePlanStatements(policy, rendered)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", awss3.ErrPolicyInvalid, err)
+	}
+	if len(statements) == 0 {
+		return nil, nil
+	}
+	policy["Statement"] = statements
+	return policy, nil
+}
+
+// Delete deletes the bucket's stack, if it has one, and then the bucket.
+func (b *StackBucket) Delete(ctx context.Context, bucketName string, deleteObjects bool) error {
+	if err := b.deleteStack(ctx, bucketName); err != nil {
+		return err
+	}
+	return b.Bucket.Delete(ctx, bucketName, deleteObjects)
+}
+
+// DeleteWithProgress deletes the bucket's stack, if it has one, and then the
+// bucket and its objects.
+func (b *StackBucket) DeleteWithProgress(ctx context.Context, bucketName string, progress func(awss3.DeleteProgress)) error {
+	if err := b.deleteStack(ctx, bucketName); err != nil {
+		return err
+	}
+	return b.Bucket.DeleteWithProgress(ctx, bucketName, progress)
+}
+
+// Retain deletes the bucket's stack, if it has one, which keeps the bucket,
+// and then releases the bucket.
+func (b *StackBucket) Retain(ctx context.Context, bucketName string, tags map[string]string) error {
+	if err := b.deleteStack(ctx, bucketName); err != nil {
+		return err
+	}
+	return b.Bucket.Retain(ctx, bucketName, tags)
+}
+
+// deleteStack deletes the bucket's stack and waits for it. The bucket and its
+// policy are retained. Deleting a stack that does not exist succeeds.
+func (b *StackBucket) deleteStack(ctx context.Context, bucketName string) error {
+	details, err := b.Bucket.Describe(ctx, bucketName, "")
+	if errors.Is(err, awss3.ErrBucketNotFound) {
+		// The bucket, and so the region of its stack, is gone. Deleting
+		// the bucket reports it.
+		b.logger.Info("delete-stack.bucket-not-found", lager.Data{"bucket": bucketName})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	client := b.client(details.Region)
+
+	deleteStackInput := &cloudformation.DeleteStackInput{
+		StackName: aws.String(b.stackName(bucketName)),
+	}
+	b.logger.Debug("delete-stack", lager.Data{"input": deleteStackInput})
+	_, err = awsretry.Call(ctx, b.retry.For("DeleteStack"), func() (*cloudformation.DeleteStackOutput, error) {
+		return client.DeleteStackWithContext(ctx, deleteStackInput)
+	})
+	if err != nil {
+		b.logger.Error("delete-stack", err, lager.Data{"bucket": bucketName})
+		return convertError(err)
+	}
+	return b.wait(ctx, client, bucketName, client.WaitUntilStackDeleteCompleteWithContext)
+}
+
+// wait waits for the bucket's stack with waiter. If the stack fails, the
+// error has the reason its first resource failed.
+func (b *StackBucket) wait(ctx context.Context, client CloudFormationClient, bucketName string, waiter func(aws.Context, *cloudformation.DescribeStacksInput, ...request.WaiterOption) error) error {
+	stackName := b.stackName(bucketName)
+	err := waiter(ctx, &cloudformation.DescribeStacksInput{StackName: aws.String(stackName)},
+		request.WithWaiterDelay(request.ConstantWaiterDelay(b.pollInterval)),
+		request.WithWaiterMaxAttempts(int(b.timeout/b.pollInterval)+1),
+	)
+	if err == nil {
+		return nil
+	}
+	b.logger.Error("wait-stack", err, lager.Data{"bucket": bucketName, "stack": stackName})
+	if reason := b.failureReason(ctx, client, stackName); reason != "" {
+		return fmt.Errorf("%w: %s: %s", ErrStackFailed, stackName, reason)
+	}
+	return fmt.Errorf("%w: %s: %s", ErrStackFailed, stackName, convertError(err))
+}
+
+// failureReason returns why the first resource of the stack to fail in its
+// latest events failed, or an empty string if none did.
+func (b *StackBucket) failureReason(ctx context.Context, client CloudFormationClient, stackName string) string {
+	describeEventsInput := &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(stackName),
+	}
+	describeEventsOutput, err := awsretry.Call(ctx, b.retry.For("DescribeStackEvents"), func() (*cloudformation.DescribeStackEventsOutput, error) {
+		return client.DescribeStackEventsWithContext(ctx, describeEventsInput)
+	})
+	if err != nil {
+		b.logger.Error("describe-stack-events", err, lager.Data{"stack": stackName})
+		return ""
+	}
+	// Events are newest first, and resources that fail after the first are
+	// usually cancelled because of it.
+	var reason string
+	for _, event := range describeEventsOutput.StackEvents {
+		if strings.HasSuffix(aws.StringValue(event.ResourceStatus), "_FAILED") && aws.StringValue(event.ResourceStatusReason) != "" {
+			reason = aws.StringValue(event.LogicalResourceId) + ": " + aws.StringValue(event.ResourceStatusReason)
+		}
+	}
+	return reason
+}
+
+func (b *StackBucket) stackExists(ctx context.Context, client CloudFormationClient, stackName string) (bool, error) {
+	describeStacksInput := &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	}
+	_, err := awsretry.Call(ctx, b.retry.For("DescribeStacks"), func() (*cloudformation.DescribeStacksOutput, error) {
+		return client.DescribeStacksWithContext(ctx, describeStacksInput)
+	})
+	if isStackNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		b.logger.Error("describe-stacks", err, lager.Data{"stack": stackName})
+		return false, convertError(err)
+	}
+	return true, nil
+}
+
+// stackName returns the name of a bucket's stack. Stack names may not have
+// dots, which bucket names may.
+func (b *StackBucket) stackName(bucketName string) string {
+	return b.stackPrefix + "-" + strings.ReplaceAll(bucketName, ".", "-")
+}
+
+// client returns the CloudFormation client for region, creating it if
+// needed.
+func (b *StackBucket) client(region string) CloudFormationClient {
+	region = cmp.Or(region, b.region)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	client, ok := b.clients[region]
+	if !ok {
+		client = b.newClient(region)
+		b.clients[region] = client
+	}
+	return client
+}
+
+func isErrorCode(err error, code string) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == code
+}
+
+// isStackNotFound reports whether err is the error CloudFormation returns
+// for a stack that does not exist, which has no code of its own.
+func isStackNotFound(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == "ValidationError" && strings.Contains(awsErr.Message(), "does not exist")
+}
+
+// isNoUpdates reports whether err is the error CloudFormation returns for
+// an update that changes nothing.
+func isNoUpdates(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == "ValidationError" && strings.Contains(awsErr.Message(), "No updates are to be performed")
+}
+
+func convertError(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return errors.New(awsErr.Code() + ": " + awsErr.Message())
+	}
+	return err
+}
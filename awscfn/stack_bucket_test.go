@@ -0,0 +1,370 @@
+package awscfn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+var errStackNotFound = awserr.New("ValidationError", "Stack with id s3-broker-bucket does not exist", nil)
+
+type mockCloudFormationClient struct {
+	CloudFormationClient
+	stackExists bool
+	createErr   error
+	updateErr   error
+	waitErr     error
+	events      []*cloudformation.StackEvent
+	// detections are the statuses of a drift detection, in order.
+	detections []string
+	drifts     []*cloudformation.StackResourceDrift
+
+	created *cloudformation.CreateStackInput
+	updated *cloudformation.UpdateStackInput
+	deleted []string
+	waited  int
+}
+
+func (c *mockCloudFormationClient) CreateStackWithContext(_ aws.Context, input *cloudformation.CreateStackInput, _ ...request.Option) (*cloudformation.CreateStackOutput, error) {
+	c.created = input
+	return &cloudformation.CreateStackOutput{}, c.createErr
+}
+
+func (c *mockCloudFormationClient) UpdateStackWithContext(_ aws.Context, input *cloudformation.UpdateStackInput, _ ...request.Option) (*cloudformation.UpdateStackOutput, error) {
+	c.updated = input
+	return &cloudformation.UpdateStackOutput{}, c.updateErr
+}
+
+func (c *mockCloudFormationClient) DeleteStackWithContext(_ aws.Context, input *cloudformation.DeleteStackInput, _ ...request.Option) (*cloudformation.DeleteStackOutput, error) {
+	c.deleted = append(c.deleted, aws.StringValue(input.StackName))
+	return &cloudformation.DeleteStackOutput{}, nil
+}
+
+func (c *mockCloudFormationClient) DescribeStacksWithContext(aws.Context, *cloudformation.DescribeStacksInput, ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+	if !c.stackExists {
+		return nil, errStackNotFound
+	}
+	return &cloudformation.DescribeStacksOutput{}, nil
+}
+
+func (c *mockCloudFormationClient) DescribeStackEventsWithContext(aws.Context, *cloudformation.DescribeStackEventsInput, ...request.Option) (*cloudformation.DescribeStackEventsOutput, error) {
+	return &cloudformation.DescribeStackEventsOutput{StackEvents: c.events}, nil
+}
+
+func (c *mockCloudFormationClient) wait(aws.Context, *cloudformation.DescribeStacksInput, ...request.WaiterOption) error {
+	c.waited++
+	return c.waitErr
+}
+
+func (c *mockCloudFormationClient) WaitUntilStackCreateCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.WaiterOption) error {
+	return c.wait(ctx, input, opts...)
+}
+
+func (c *mockCloudFormationClient) WaitUntilStackUpdateCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.WaiterOption) error {
+	return c.wait(ctx, input, opts...)
+}
+
+func (c *mockCloudFormationClient) WaitUntilStackDeleteCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.WaiterOption) error {
+	return c.wait(ctx, input, opts...)
+}
+
+// mockBucket stands in for the S3 API engine that StackBucket embeds.
+type mockBucket struct {
+	awss3.Bucket
+	current     awss3.BucketDetails
+	describeErr error
+	calls       []string
+}
+
+func (b *mockBucket) Describe(context.Context, string, string) (awss3.BucketDetails, error) {
+	return awss3.BucketDetails{Region: b.current.Region}, b.describeErr
+}
+
+func (b *mockBucket) DescribeConfiguration(context.Context, string, string) (awss3.BucketDetails, error) {
+	return b.current, b.describeErr
+}
+
+func (b *mockBucket) Modify(context.Context, string, awss3.BucketDetails) error {
+	b.calls = append(b.calls, "modify")
+	return nil
+}
+
+func (b *mockBucket) Delete(context.Context, string, bool) error {
+	b.calls = append(b.calls, "delete")
+	return nil
+}
+
+func (b *mockBucket) Retain(context.Context, string, map[string]string) error {
+	b.calls = append(b.calls, "retain")
+	return nil
+}
+
+func newTestStackBucket(bucket awss3.Bucket, client *mockCloudFormationClient, regions *[]string) *StackBucket {
+	b := NewStackBucket(bucket, lager.NewLogger("stack-bucket-test"), Config{
+		Region: "us-east-1",
+		Retry:  awsretry.Policy{Config: awsretry.Config{MaxAttempts: 1}},
+		Client: func(region string) CloudFormationClient {
+			if regions != nil {
+				*regions = append(*regions, region)
+			}
+			return client
+		},
+	})
+	b.pollInterval = time.Millisecond
+	return b
+}
+
+// templateResources returns the resources of a rendered template.
+func templateResources(t *testing.T, body *string) map[string]struct{ Properties map[string]any } {
+	t.Helper()
+	var template struct {
+		Resources map[string]struct{ Properties map[string]any }
+	}
+	if err := json.Unmarshal([]byte(aws.StringValue(body)), &template); err != nil {
+		t.Fatalf("template is not valid JSON: %s", err)
+	}
+	return template.Resources
+}
+
+func TestStackBucketCreate(t *testing.T) {
+	testCases := map[string]struct {
+		details      awss3.BucketDetails
+		createErr    error
+		waitErr      error
+		events       []*cloudformation.StackEvent
+		expectErr    string
+		expectRegion string
+	}{
+		"new stack": {
+			details:      awss3.BucketDetails{Region: "us-west-2", Policy: `{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Principal": "*", "Action": ["s3:*"], "Resource": ["arn:aws:s3:::{{.BucketName}}/*"]}]}`},
+			expectRegion: "us-west-2",
+		},
+		"stack from a previous attempt": {
+			createErr:    awserr.New(cloudformation.ErrCodeAlreadyExistsException, "Stack already exists", nil),
+			expectRegion: "us-east-1",
+		},
+		"stack rolled back": {
+			waitErr: errors.New("ResourceNotReady: failed waiting for successful resource state"),
+			events: []*cloudformation.StackEvent{
+				{LogicalResourceId: aws.String("s3-broker-my-bucket"), ResourceStatus: aws.String(cloudformation.ResourceStatusDeleteComplete)},
+				{LogicalResourceId: aws.String(bucketPolicyResource), ResourceStatus: aws.String(cloudformation.ResourceStatusCreateFailed), ResourceStatusReason: aws.String("Resource creation cancelled")},
+				{LogicalResourceId: aws.String(bucketResource), ResourceStatus: aws.String(cloudformation.ResourceStatusCreateFailed), ResourceStatusReason: aws.String("my.bucket already exists")},
+			},
+			expectErr: "s3-broker-my-bucket: Bucket: my.bucket already exists",
+		},
+		"stack not created": {
+			createErr: awserr.New("AccessDenied", "not authorized to perform cloudformation:CreateStack", nil),
+			expectErr: "AccessDenied: not authorized",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &mockCloudFormationClient{createErr: tc.createErr, waitErr: tc.waitErr, events: tc.events}
+			var regions []string
+			b := newTestStackBucket(&mockBucket{}, client, &regions)
+
+			location, err := b.Create(context.Background(), "my.bucket", tc.details)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if location != "/my.bucket" {
+				t.Errorf("expected location /my.bucket, got %s", location)
+			}
+			if name := aws.StringValue(client.created.StackName); name != "s3-broker-my-bucket" {
+				t.Errorf("expected stack s3-broker-my-bucket, got %s", name)
+			}
+			if onFailure := aws.StringValue(client.created.OnFailure); onFailure != cloudformation.OnFailureDelete {
+				t.Errorf("expected the stack to be deleted on failure, got %s", onFailure)
+			}
+			if client.waited != 1 {
+				t.Errorf("expected to wait for the stack once, waited %d times", client.waited)
+			}
+			if !slices.Equal(regions, []string{tc.expectRegion}) {
+				t.Errorf("expected a client for %s, got %v", tc.expectRegion, regions)
+			}
+			resources := templateResources(t, client.created.TemplateBody)
+			if policy, ok := resources[bucketPolicyResource]; tc.details.Policy != "" && (!ok || !strings.Contains(fmt.Sprint(policy.Properties["PolicyDocument"]), "arn:aws:s3:::my.bucket/*")) {
+				t.Errorf("expected the rendered bucket policy, got %v", policy)
+			}
+		})
+	}
+
+	t.Run("stack failed", func(t *testing.T) {
+		client := &mockCloudFormationClient{waitErr: errors.New("ResourceNotReady")}
+		b := newTestStackBucket(&mockBucket{}, client, nil)
+		if _, err := b.Create(context.Background(), "bucket", awss3.BucketDetails{}); !errors.Is(err, ErrStackFailed) {
+			t.Errorf("expected ErrStackFailed, got %v", err)
+		}
+	})
+}
+
+func TestStackBucketModify(t *testing.T) {
+	bindingStatement := map[string]any{"Sid": "binding1", "Effect": "Allow", "Principal": map[string]any{"AWS": "arn:aws:iam::123456789012:user/binding1"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}
+	currentPolicy, _ := json.Marshal(map[string]any{
+		"Version":   "2012-10-17",
+		"Statement": []any{map[string]any{"Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket/*"}, bindingStatement},
+	})
+	current := awss3.BucketDetails{
+		Region:     "us-east-1",
+		Tags:       map[string]string{"Instance GUID": "instance1", "aws:cloudformation:stack-name": "s3-broker-bucket"},
+		Encryption: `{"Rules":[{"ApplyServerSideEncryptionByDefault":{"SSEAlgorithm":"AES256"}}]}`,
+		Versioning: true,
+		Policy:     string(currentPolicy),
+	}
+	testCases := map[string]struct {
+		current           awss3.BucketDetails
+		stackExists       bool
+		updateErr         error
+		details           awss3.BucketDetails
+		expectUpdated     bool
+		expectWaited      int
+		expectDirectCalls []string
+		expectStatements  int
+		expectTags        []any
+		expectVersioning  any
+	}{
+		"bucket without a stack": {
+			current:           current,
+			expectDirectCalls: []string{"modify"},
+		},
+		"new plan": {
+			current:          current,
+			stackExists:      true,
+			details:          awss3.BucketDetails{Tags: map[string]string{"Plan Name": "plan2"}, Policy: `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": ["s3:GetObject"], "Resource": ["arn:aws:s3:::bucket/*"]}]}`},
+			expectUpdated:    true,
+			expectWaited:     1,
+			expectStatements: 2,
+			expectTags:       []any{map[string]any{"Key": "Instance GUID", "Value": "instance1"}, map[string]any{"Key": "Plan Name", "Value": "plan2"}},
+			expectVersioning: map[string]any{"Status": "Suspended"},
+		},
+		"no changes": {
+			current:          current,
+			stackExists:      true,
+			updateErr:        awserr.New("ValidationError", "No updates are to be performed.", nil),
+			details:          awss3.BucketDetails{Versioning: true},
+			expectUpdated:    true,
+			expectStatements: 1,
+			expectTags:       []any{map[string]any{"Key": "Instance GUID", "Value": "instance1"}},
+			expectVersioning: map[string]any{"Status": "Enabled"},
+		},
+		"policy removed": {
+			current:           awss3.BucketDetails{Region: "us-east-1", Policy: `{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Principal": "*", "Action": ["s3:*"], "Resource": ["arn:aws:s3:::bucket/*"]}]}`},
+			stackExists:       true,
+			expectUpdated:     true,
+			expectWaited:      1,
+			expectDirectCalls: []string{"modify"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &mockCloudFormationClient{stackExists: tc.stackExists, updateErr: tc.updateErr}
+			bucket := &mockBucket{current: tc.current}
+			b := newTestStackBucket(bucket, client, nil)
+
+			if err := b.Modify(context.Background(), "bucket", tc.details); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !slices.Equal(bucket.calls, tc.expectDirectCalls) {
+				t.Errorf("expected direct calls %v, got %v", tc.expectDirectCalls, bucket.calls)
+			}
+			if (client.updated != nil) != tc.expectUpdated {
+				t.Fatalf("expected the stack to be updated to be %t", tc.expectUpdated)
+			}
+			if client.waited != tc.expectWaited {
+				t.Errorf("expected to wait %d times, waited %d times", tc.expectWaited, client.waited)
+			}
+			if !tc.expectUpdated {
+				return
+			}
+
+			resources := templateResources(t, client.updated.TemplateBody)
+			properties := resources[bucketResource].Properties
+			if tc.expectTags != nil && !slices.EqualFunc(properties["Tags"].([]any), tc.expectTags, func(x, y any) bool { return x.(map[string]any)["Key"] == y.(map[string]any)["Key"] }) {
+				t.Errorf("expected tags %v, got %v", tc.expectTags, properties["Tags"])
+			}
+			if tc.expectVersioning != nil && properties["VersioningConfiguration"].(map[string]any)["Status"] != tc.expectVersioning.(map[string]any)["Status"] {
+				t.Errorf("expected versioning %v, got %v", tc.expectVersioning, properties["VersioningConfiguration"])
+			}
+			policy, ok := resources[bucketPolicyResource]
+			if tc.expectStatements == 0 {
+				if ok {
+					t.Errorf("expected no bucket policy, got %v", policy)
+				}
+				return
+			}
+			statements := policy.Properties["PolicyDocument"].(map[string]any)["Statement"].([]any)
+			if len(statements) != tc.expectStatements {
+				t.Fatalf("expected %d policy statements, got %v", tc.expectStatements, statements)
+			}
+			if statements[0].(map[string]any)["Sid"] != "binding1" {
+				t.Errorf("expected the binding statement to be kept, got %v", statements)
+			}
+		})
+	}
+}
+
+func TestStackBucketDelete(t *testing.T) {
+	testCases := map[string]struct {
+		describeErr   error
+		retain        bool
+		expectDeleted []string
+		expectCalls   []string
+	}{
+		"delete": {
+			expectDeleted: []string{"s3-broker-bucket"},
+			expectCalls:   []string{"delete"},
+		},
+		"retain": {
+			retain:        true,
+			expectDeleted: []string{"s3-broker-bucket"},
+			expectCalls:   []string{"retain"},
+		},
+		"bucket not found": {
+			describeErr: awss3.ErrBucketNotFound,
+			expectCalls: []string{"delete"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &mockCloudFormationClient{}
+			bucket := &mockBucket{describeErr: tc.describeErr}
+			b := newTestStackBucket(bucket, client, nil)
+
+			var err error
+			if tc.retain {
+				err = b.Retain(context.Background(), "bucket", map[string]string{"Retained": "true"})
+			} else {
+				err = b.Delete(context.Background(), "bucket", true)
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !slices.Equal(client.deleted, tc.expectDeleted) {
+				t.Errorf("expected stacks %v to be deleted, got %v", tc.expectDeleted, client.deleted)
+			}
+			if !slices.Equal(bucket.calls, tc.expectCalls) {
+				t.Errorf("expected direct calls %v, got %v", tc.expectCalls, bucket.calls)
+			}
+		})
+	}
+}
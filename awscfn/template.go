@@ -0,0 +1,267 @@
+package awscfn
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+// Logical IDs of the resources in a bucket's stack.
+const (
+	bucketResource       = "Bucket"
+	bucketPolicyResource = "BucketPolicy"
+)
+
+const (
+	versioningEnabled   = "Enabled"
+	versioningSuspended = "Suspended"
+)
+
+type stackTemplate struct {
+	AWSTemplateFormatVersion string              `json:"AWSTemplateFormatVersion"`
+	Description              string              `json:"Description"`
+	Resources                map[string]resource `json:"Resources"`
+}
+
+type resource struct {
+	Type                string `json:"Type"`
+	DeletionPolicy      string `json:"DeletionPolicy,omitempty"`
+	UpdateReplacePolicy string `json:"UpdateReplacePolicy,omitempty"`
+	Properties          any    `json:"Properties"`
+}
+
+type bucketProperties struct {
+	BucketName                     string                          `json:"BucketName"`
+	Tags                           []tag                           `json:"Tags,omitempty"`
+	BucketEncryption               *bucketEncryption               `json:"BucketEncryption,omitempty"`
+	VersioningConfiguration        *versioningConfiguration        `json:"VersioningConfiguration,omitempty"`
+	OwnershipControls              *ownershipControls              `json:"OwnershipControls,omitempty"`
+	CorsConfiguration              *corsConfiguration              `json:"CorsConfiguration,omitempty"`
+	LifecycleConfiguration         *lifecycleConfiguration         `json:"LifecycleConfiguration,omitempty"`
+	PublicAccessBlockConfiguration *publicAccessBlockConfiguration `json:"PublicAccessBlockConfiguration,omitempty"`
+}
+
+type tag struct {
+	Key   string `json:"Key"`
+	Value string `json:"Value"`
+}
+
+type bucketEncryption struct {
+	ServerSideEncryptionConfiguration []encryptionRule `json:"ServerSideEncryptionConfiguration"`
+}
+
+type encryptionRule struct {
+	ServerSideEncryptionByDefault *encryptionByDefault `json:"ServerSideEncryptionByDefault,omitempty"`
+	BucketKeyEnabled              *bool                `json:"BucketKeyEnabled,omitempty"`
+}
+
+type encryptionByDefault struct {
+	SSEAlgorithm   string `json:"SSEAlgorithm"`
+	KMSMasterKeyID string `json:"KMSMasterKeyID,omitempty"`
+}
+
+// s3EncryptionConfiguration is the JSON form of the S3 API's
+// ServerSideEncryptionConfiguration, which plans set their encryption in.
+type s3EncryptionConfiguration struct {
+	Rules []struct {
+		ApplyServerSideEncryptionByDefault *encryptionByDefault
+		BucketKeyEnabled                   *bool
+	}
+}
+
+type versioningConfiguration struct {
+	Status string `json:"Status"`
+}
+
+type ownershipControls struct {
+	Rules []ownershipRule `json:"Rules"`
+}
+
+type ownershipRule struct {
+	ObjectOwnership string `json:"ObjectOwnership"`
+}
+
+type corsConfiguration struct {
+	CorsRules []corsRule `json:"CorsRules"`
+}
+
+type corsRule struct {
+	AllowedOrigins []string `json:"AllowedOrigins"`
+	AllowedMethods []string `json:"AllowedMethods"`
+	AllowedHeaders []string `json:"AllowedHeaders,omitempty"`
+	ExposedHeaders []string `json:"ExposedHeaders,omitempty"`
+	MaxAge         int64    `json:"MaxAge,omitempty"`
+}
+
+type lifecycleConfiguration struct {
+	Rules []lifecycleRule `json:"Rules"`
+}
+
+type lifecycleRule struct {
+	ID                             string                          `json:"Id"`
+	Prefix                         string                          `json:"Prefix,omitempty"`
+	Status                         string                          `json:"Status"`
+	ExpirationInDays               int64                           `json:"ExpirationInDays,omitempty"`
+	NoncurrentVersionExpiration    *noncurrentVersionExpiration    `json:"NoncurrentVersionExpiration,omitempty"`
+	AbortIncompleteMultipartUpload *abortIncompleteMultipartUpload `json:"AbortIncompleteMultipartUpload,omitempty"`
+}
+
+type noncurrentVersionExpiration struct {
+	NoncurrentDays int64 `json:"NoncurrentDays"`
+}
+
+type abortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int64 `json:"DaysAfterInitiation"`
+}
+
+type publicAccessBlockConfiguration struct {
+	BlockPublicAcls       bool `json:"BlockPublicAcls"`
+	BlockPublicPolicy     bool `json:"BlockPublicPolicy"`
+	IgnorePublicAcls      bool `json:"IgnorePublicAcls"`
+	RestrictPublicBuckets bool `json:"RestrictPublicBuckets"`
+}
+
+type bucketPolicyProperties struct {
+	Bucket         map[string]string `json:"Bucket"`
+	PolicyDocument map[string]any    `json:"PolicyDocument"`
+}
+
+// renderTemplate returns the template of a bucket's stack. The bucket is
+// configured as details says, with its versioning status set to versioning
+// unless that is empty. policy is the bucket policy, or nil for none. The
+// plan's bucket policy in details decides whether the bucket is public, as
+// it does for buckets that the broker creates directly.
+func renderTemplate(bucketName string, details awss3.BucketDetails, versioning string, policy map[string]any) (string, error) {
+	properties := bucketProperties{
+		BucketName: bucketName,
+		// Buckets are private unless their plan makes them public, as S3
+		// makes new buckets.
+		PublicAccessBlockConfiguration: &publicAccessBlockConfiguration{
+			BlockPublicAcls:       true,
+			BlockPublicPolicy:     true,
+			IgnorePublicAcls:      true,
+			RestrictPublicBuckets: true,
+		},
+	}
+	if details.Policy != "" {
+		public, err := awss3.IsPublicReadPolicy(details.Policy)
+		if err != nil {
+			return "", err
+		}
+		if public {
+			properties.PublicAccessBlockConfiguration = &publicAccessBlockConfiguration{}
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(details.Tags)) {
+		// Tags under aws: are set by AWS, such as those CloudFormation
+		// adds to the resources of stacks, and cannot be set by templates.
+		if strings.HasPrefix(key, "aws:") {
+			continue
+		}
+		properties.Tags = append(properties.Tags, tag{Key: key, Value: details.Tags[key]})
+	}
+
+	if details.Encryption != "" {
+		var encryption s3EncryptionConfiguration
+		if err := json.Unmarshal([]byte(details.Encryption), &encryption); err != nil {
+			return "", err
+		}
+		properties.BucketEncryption = &bucketEncryption{}
+		for _, rule := range encryption.Rules {
+			properties.BucketEncryption.ServerSideEncryptionConfiguration = append(properties.BucketEncryption.ServerSideEncryptionConfiguration, encryptionRule{
+				ServerSideEncryptionByDefault: rule.ApplyServerSideEncryptionByDefault,
+				BucketKeyEnabled:              rule.BucketKeyEnabled,
+			})
+		}
+	}
+
+	if versioning != "" {
+		properties.VersioningConfiguration = &versioningConfiguration{Status: versioning}
+	}
+
+	if details.ObjectOwnership != "" {
+		properties.OwnershipControls = &ownershipControls{Rules: []ownershipRule{{ObjectOwnership: details.ObjectOwnership}}}
+	}
+
+	if len(details.CORSRules) > 0 {
+		properties.CorsConfiguration = &corsConfiguration{}
+		for _, rule := range details.CORSRules {
+			properties.CorsConfiguration.CorsRules = append(properties.CorsConfiguration.CorsRules, corsRule{
+				AllowedOrigins: rule.AllowedOrigins,
+				AllowedMethods: rule.AllowedMethods,
+				AllowedHeaders: rule.AllowedHeaders,
+				ExposedHeaders: rule.ExposeHeaders,
+				MaxAge:         rule.MaxAgeSeconds,
+			})
+		}
+	}
+
+	if len(details.LifecycleRules) > 0 {
+		properties.LifecycleConfiguration = &lifecycleConfiguration{}
+		for _, rule := range details.LifecycleRules {
+			lifecycle := lifecycleRule{
+				ID:               rule.ID,
+				Prefix:           rule.Prefix,
+				Status:           "Enabled",
+				ExpirationInDays: rule.ExpirationDays,
+			}
+			if rule.NoncurrentVersionExpirationDays > 0 {
+				lifecycle.NoncurrentVersionExpiration = &noncurrentVersionExpiration{NoncurrentDays: rule.NoncurrentVersionExpirationDays}
+			}
+			if rule.AbortIncompleteMultipartUploadDays > 0 {
+				lifecycle.AbortIncompleteMultipartUpload = &abortIncompleteMultipartUpload{DaysAfterInitiation: rule.AbortIncompleteMultipartUploadDays}
+			}
+			properties.LifecycleConfiguration.Rules = append(properties.LifecycleConfiguration.Rules, lifecycle)
+		}
+	}
+
+	template := stackTemplate{
+		AWSTemplateFormatVersion: "2010-09-09",
+		Description:              "S3 bucket " + bucketName + ", managed by the S3 broker",
+		Resources: map[string]resource{
+			bucketResource: {
+				Type: "AWS::S3::Bucket",
+				// A bucket is deleted when its stack fails to create, so that
+				// failed provisions leave nothing behind.
+				DeletionPolicy:      "RetainExceptOnCreate",
+				UpdateReplacePolicy: "Retain",
+				Properties:          properties,
+			},
+		},
+	}
+	if policy != nil {
+		template.Resources[bucketPolicyResource] = resource{
+			Type:                "AWS::S3::BucketPolicy",
+			DeletionPolicy:      "Retain",
+			UpdateReplacePolicy: "Retain",
+			Properties: bucketPolicyProperties{
+				Bucket:         map[string]string{"Ref": bucketResource},
+				PolicyDocument: policy,
+			},
+		}
+	}
+
+	body, err := json.Marshal(template)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// versioningStatus returns the versioning status of a bucket's stack: enabled
+// if the plan enables it, suspended if the bucket was versioned, since
+// versioning cannot be turned off once enabled, and otherwise none.
+func versioningStatus(enabled, versioned bool) string {
+	switch {
+	case enabled:
+		return versioningEnabled
+	case versioned:
+		return versioningSuspended
+	default:
+		return ""
+	}
+}
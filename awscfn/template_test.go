@@ -0,0 +1,129 @@
+package awscfn
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	blocked := map[string]any{"BlockPublicAcls": true, "BlockPublicPolicy": true, "IgnorePublicAcls": true, "RestrictPublicBuckets": true}
+	testCases := map[string]struct {
+		details      awss3.BucketDetails
+		versioning   string
+		policy       map[string]any
+		expectBucket map[string]any
+		expectPolicy bool
+		expectErr    bool
+	}{
+		"plain bucket": {
+			expectBucket: map[string]any{"BucketName": "bucket", "PublicAccessBlockConfiguration": blocked},
+		},
+		"configured bucket": {
+			details: awss3.BucketDetails{
+				Tags:            map[string]string{"b": "2", "a": "1", "aws:cloudformation:stack-name": "s3-broker-bucket"},
+				Encryption:      `{"Rules":[{"ApplyServerSideEncryptionByDefault":{"SSEAlgorithm":"aws:kms","KMSMasterKeyID":"alias/bucket-key"},"BucketKeyEnabled":true}]}`,
+				ObjectOwnership: "BucketOwnerEnforced",
+				CORSRules:       []awss3.CORSRule{{AllowedOrigins: []string{"https://example.com"}, AllowedMethods: []string{"GET"}, ExposeHeaders: []string{"ETag"}, MaxAgeSeconds: 300}},
+				LifecycleRules:  []awss3.LifecycleRule{{ID: "expire", Prefix: "tmp/", ExpirationDays: 7, AbortIncompleteMultipartUploadDays: 1}},
+			},
+			versioning: versioningEnabled,
+			expectBucket: map[string]any{
+				"BucketName": "bucket",
+				"Tags":       []any{map[string]any{"Key": "a", "Value": "1"}, map[string]any{"Key": "b", "Value": "2"}},
+				"BucketEncryption": map[string]any{"ServerSideEncryptionConfiguration": []any{map[string]any{
+					"ServerSideEncryptionByDefault": map[string]any{"SSEAlgorithm": "aws:kms", "KMSMasterKeyID": "alias/bucket-key"},
+					"BucketKeyEnabled":              true,
+				}}},
+				"VersioningConfiguration": map[string]any{"Status": "Enabled"},
+				"OwnershipControls":       map[string]any{"Rules": []any{map[string]any{"ObjectOwnership": "BucketOwnerEnforced"}}},
+				"CorsConfiguration": map[string]any{"CorsRules": []any{map[string]any{
+					"AllowedOrigins": []any{"https://example.com"},
+					"AllowedMethods": []any{"GET"},
+					"ExposedHeaders": []any{"ETag"},
+					"MaxAge":         float64(300),
+				}}},
+				"LifecycleConfiguration": map[string]any{"Rules": []any{map[string]any{
+					"Id":                             "expire",
+					"Prefix":                         "tmp/",
+					"Status":                         "Enabled",
+					"ExpirationInDays":               float64(7),
+					"AbortIncompleteMultipartUpload": map[string]any{"DaysAfterInitiation": float64(1)},
+				}}},
+				"PublicAccessBlockConfiguration": blocked,
+			},
+		},
+		"public bucket": {
+			details: awss3.BucketDetails{Policy: `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": ["s3:GetObject"], "Resource": ["{{.ARN}}/*"]}]}`},
+			policy:  map[string]any{"Statement": []any{}},
+			expectBucket: map[string]any{
+				"BucketName":                     "bucket",
+				"PublicAccessBlockConfiguration": map[string]any{"BlockPublicAcls": false, "BlockPublicPolicy": false, "IgnorePublicAcls": false, "RestrictPublicBuckets": false},
+			},
+			expectPolicy: true,
+		},
+		"invalid encryption": {
+			details:   awss3.BucketDetails{Encryption: "AES256"},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			body, err := renderTemplate("bucket", tc.details, tc.versioning, tc.policy)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var template struct {
+				Resources map[string]struct {
+					Type           string
+					DeletionPolicy string
+					Properties     map[string]any
+				}
+			}
+			if err := json.Unmarshal([]byte(body), &template); err != nil {
+				t.Fatalf("template is not valid JSON: %s", err)
+			}
+			bucket := template.Resources[bucketResource]
+			if bucket.Type != "AWS::S3::Bucket" || bucket.DeletionPolicy != "RetainExceptOnCreate" {
+				t.Errorf("unexpected bucket resource %s with deletion policy %s", bucket.Type, bucket.DeletionPolicy)
+			}
+			if diff := cmp.Diff(tc.expectBucket, bucket.Properties); diff != "" {
+				t.Errorf("unexpected bucket properties (-want +got):\n%s", diff)
+			}
+			policy, ok := template.Resources[bucketPolicyResource]
+			if ok != tc.expectPolicy {
+				t.Fatalf("expected a bucket policy resource to be %t, got %t", tc.expectPolicy, ok)
+			}
+			if ok && !cmp.Equal(policy.Properties["Bucket"], map[string]any{"Ref": bucketResource}) {
+				t.Errorf("expected the policy to refer to the bucket, got %v", policy.Properties["Bucket"])
+			}
+		})
+	}
+}
+
+func TestVersioningStatus(t *testing.T) {
+	testCases := []struct {
+		enabled, versioned bool
+		expected           string
+	}{
+		{enabled: true, expected: versioningEnabled},
+		{enabled: true, versioned: true, expected: versioningEnabled},
+		{versioned: true, expected: versioningSuspended},
+		{expected: ""},
+	}
+	for _, tc := range testCases {
+		if status := versioningStatus(tc.enabled, tc.versioned); status != tc.expected {
+			t.Errorf("versioningStatus(%t, %t): expected %q, got %q", tc.enabled, tc.versioned, tc.expected, status)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package awsevents publishes the broker's events to an SNS topic or an
+// EventBridge event bus.
+package awsevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sns"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// DetailTypeAttribute is the SNS message attribute that holds an event's
+// detail type, so that subscriptions can filter on it.
+const DetailTypeAttribute = "detail_type"
+
+type SNSClient interface {
+	PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error)
+}
+
+type EventBridgeClient interface {
+	PutEventsWithContext(ctx aws.Context, input *eventbridge.PutEventsInput, opts ...request.Option) (*eventbridge.PutEventsOutput, error)
+}
+
+// Publisher publishes events, whose detail is a JSON object.
+type Publisher interface {
+	Publish(ctx context.Context, detailType string, detail []byte) error
+}
+
+// IsTopicARN reports whether targetARN is the ARN of an SNS topic.
+func IsTopicARN(targetARN string) bool {
+	parsed, err := arn.Parse(targetARN)
+	return err == nil && parsed.Service == "sns" && parsed.Resource != ""
+}
+
+// IsEventBusARN reports whether targetARN is the ARN of an EventBridge event
+// bus.
+func IsEventBusARN(targetARN string) bool {
+	parsed, err := arn.Parse(targetARN)
+	return err == nil && parsed.Service == "events" && strings.HasPrefix(parsed.Resource, "event-bus/")
+}
+
+// SNSPublisher publishes events as messages to an SNS topic, with the event's
+// detail as the message.
+type SNSPublisher struct {
+	snssvc   SNSClient
+	topicARN string
+	retry    awsretry.Policy
+	logger   lager.Logger
+}
+
+func NewSNSPublisher(snssvc SNSClient, topicARN string, logger lager.Logger, retry awsretry.Policy) *SNSPublisher {
+	return &SNSPublisher{
+		snssvc:   snssvc,
+		topicARN: topicARN,
+		retry:    retry,
+		logger:   logger.Session("sns-publisher"),
+	}
+}
+
+func (p *SNSPublisher) Publish(ctx context.Context, detailType string, detail []byte) error {
+	publishInput := &sns.PublishInput{
+		TopicArn: aws.String(p.topicARN),
+		Message:  aws.String(string(detail)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			DetailTypeAttribute: {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(detailType),
+			},
+		},
+	}
+	p.logger.Debug("publish", lager.Data{"topic": p.topicARN, "detail-type": detailType})
+	_, err := awsretry.Call(ctx, p.retry.For("Publish"), func() (*sns.PublishOutput, error) {
+		return p.snssvc.PublishWithContext(ctx, publishInput)
+	})
+	return err
+}
+
+// EventBridgePublisher puts events on an EventBridge event bus.
+type EventBridgePublisher struct {
+	client EventBridgeClient
+	busARN string
+	source string
+	retry  awsretry.Policy
+	logger lager.Logger
+}
+
+func NewEventBridgePublisher(client EventBridgeClient, busARN, source string, logger lager.Logger, retry awsretry.Policy) *EventBridgePublisher {
+	return &EventBridgePublisher{
+		client: client,
+		busARN: busARN,
+		source: source,
+		retry:  retry,
+		logger: logger.Session("eventbridge-publisher"),
+	}
+}
+
+// Publish puts the event on the bus. PutEvents reports events it could not
+// put in its output rather than as an error, so those are returned as errors.
+func (p *EventBridgePublisher) Publish(ctx context.Context, detailType string, detail []byte) error {
+	putEventsInput := &eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{{
+			EventBusName: aws.String(p.busARN),
+			Source:       aws.String(p.source),
+			DetailType:   aws.String(detailType),
+			Detail:       aws.String(string(detail)),
+		}},
+	}
+	p.logger.Debug("put-events", lager.Data{"event-bus": p.busARN, "detail-type": detailType})
+	putEventsOutput, err := awsretry.Call(ctx, p.retry.For("PutEvents"), func() (*eventbridge.PutEventsOutput, error) {
+		return p.client.PutEventsWithContext(ctx, putEventsInput)
+	})
+	if err != nil {
+		return err
+	}
+	if aws.Int64Value(putEventsOutput.FailedEntryCount) > 0 {
+		for _, entry := range putEventsOutput.Entries {
+			if entry.ErrorCode != nil {
+				return fmt.Errorf("event was not put: %s: %s", aws.StringValue(entry.ErrorCode), aws.StringValue(entry.ErrorMessage))
+			}
+		}
+		return errors.New("event was not put")
+	}
+	return nil
+}
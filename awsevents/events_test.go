@@ -0,0 +1,111 @@
+package awsevents
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sns"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+const (
+	testTopicARN = "arn:aws:sns:us-east-1:123456789012:s3-broker-events"
+	testBusARN   = "arn:aws:events:us-east-1:123456789012:event-bus/s3-broker"
+)
+
+type mockSNSClient struct {
+	input *sns.PublishInput
+}
+
+func (c *mockSNSClient) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	c.input = input
+	return &sns.PublishOutput{MessageId: aws.String("message-id")}, nil
+}
+
+type mockEventBridgeClient struct {
+	input     *eventbridge.PutEventsInput
+	errorCode string
+}
+
+func (c *mockEventBridgeClient) PutEventsWithContext(ctx aws.Context, input *eventbridge.PutEventsInput, opts ...request.Option) (*eventbridge.PutEventsOutput, error) {
+	c.input = input
+	if c.errorCode != "" {
+		return &eventbridge.PutEventsOutput{
+			FailedEntryCount: aws.Int64(1),
+			Entries:          []*eventbridge.PutEventsResultEntry{{ErrorCode: aws.String(c.errorCode), ErrorMessage: aws.String("failed")}},
+		}, nil
+	}
+	return &eventbridge.PutEventsOutput{
+		FailedEntryCount: aws.Int64(0),
+		Entries:          []*eventbridge.PutEventsResultEntry{{EventId: aws.String("event-id")}},
+	}, nil
+}
+
+func TestTargetARNs(t *testing.T) {
+	testCases := map[string]struct {
+		arn         string
+		expectTopic bool
+		expectBus   bool
+	}{
+		"topic":          {arn: testTopicARN, expectTopic: true},
+		"event bus":      {arn: testBusARN, expectBus: true},
+		"event rule":     {arn: "arn:aws:events:us-east-1:123456789012:rule/s3-broker"},
+		"queue":          {arn: "arn:aws:sqs:us-east-1:123456789012:s3-broker"},
+		"not an ARN":     {arn: "s3-broker-events"},
+		"govcloud topic": {arn: "arn:aws-us-gov:sns:us-gov-west-1:123456789012:events", expectTopic: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if IsTopicARN(tc.arn) != tc.expectTopic {
+				t.Errorf("expected IsTopicARN to be %t", tc.expectTopic)
+			}
+			if IsEventBusARN(tc.arn) != tc.expectBus {
+				t.Errorf("expected IsEventBusARN to be %t", tc.expectBus)
+			}
+		})
+	}
+}
+
+func TestSNSPublish(t *testing.T) {
+	client := &mockSNSClient{}
+	publisher := NewSNSPublisher(client, testTopicARN, lager.NewLogger("test"), awsretry.Policy{})
+	if err := publisher.Publish(context.Background(), "S3 Broker provision", []byte(`{"action":"provision"}`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if aws.StringValue(client.input.TopicArn) != testTopicARN || aws.StringValue(client.input.Message) != `{"action":"provision"}` {
+		t.Errorf("unexpected input %s", client.input)
+	}
+	if attribute := client.input.MessageAttributes[DetailTypeAttribute]; aws.StringValue(attribute.StringValue) != "S3 Broker provision" {
+		t.Errorf("unexpected detail type attribute %s", attribute)
+	}
+}
+
+func TestEventBridgePublish(t *testing.T) {
+	testCases := map[string]struct {
+		errorCode string
+		expectErr bool
+	}{
+		"put":     {},
+		"not put": {errorCode: "InternalFailure", expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &mockEventBridgeClient{errorCode: tc.errorCode}
+			publisher := NewEventBridgePublisher(client, testBusARN, "s3-broker", lager.NewLogger("test"), awsretry.Policy{})
+			err := publisher.Publish(context.Background(), "S3 Broker bind", []byte(`{"action":"bind"}`))
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			entry := client.input.Entries[0]
+			if aws.StringValue(entry.EventBusName) != testBusARN || aws.StringValue(entry.Source) != "s3-broker" ||
+				aws.StringValue(entry.DetailType) != "S3 Broker bind" || aws.StringValue(entry.Detail) != `{"action":"bind"}` {
+				t.Errorf("unexpected entry %s", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package awsiam
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// Group manages the IAM group of a service instance, which holds the
+// instance's policy so its binding users need none of their own.
+type Group interface {
+	Create(groupName, iamPath string) error
+	Delete(groupName string) error
+	PutGroupPolicy(groupName, policyName, policyTemplate string, resources []string) error
+	ListGroupPolicies(groupName string) ([]string, error)
+	DeleteGroupPolicy(groupName, policyName string) error
+	AddUser(groupName, userName string) error
+	RemoveUser(groupName, userName string) error
+	ListGroupsForUser(userName string) ([]string, error)
+}
+
+type IAMGroup struct {
+	iamsvc *iam.IAM
+	retry  awsretry.Policy
+	logger lager.Logger
+}
+
+func NewIAMGroup(
+	iamsvc *iam.IAM,
+	logger lager.Logger,
+	retry awsretry.Policy,
+) *IAMGroup {
+	return &IAMGroup{
+		iamsvc: iamsvc,
+		retry:  retry,
+		logger: logger.Session("iam-group"),
+	}
+}
+
+// Create creates the group, or does nothing if it already exists.
+func (g *IAMGroup) Create(groupName, iamPath string) error {
+	createGroupInput := &iam.CreateGroupInput{
+		GroupName: aws.String(groupName),
+		Path:      stringOrNil(iamPath),
+	}
+	g.logger.Debug("create-group", lager.Data{"input": createGroupInput})
+
+	_, err := awsretry.Call(context.Background(), g.retry.For("CreateGroup"), func() (*iam.CreateGroupOutput, error) {
+		return g.iamsvc.CreateGroup(createGroupInput)
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == iam.ErrCodeEntityAlreadyExistsException {
+			return nil
+		}
+		g.logger.Error("create-group.aws-iam-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (g *IAMGroup) Delete(groupName string) error {
+	deleteGroupInput := &iam.DeleteGroupInput{
+		GroupName: aws.String(groupName),
+	}
+	g.logger.Debug("delete-group", lager.Data{"input": deleteGroupInput})
+
+	_, err := awsretry.Call(context.Background(), g.retry.For("DeleteGroup"), func() (*iam.DeleteGroupOutput, error) {
+		return g.iamsvc.DeleteGroup(deleteGroupInput)
+	})
+	if err != nil {
+		g.logger.Error("delete-group.aws-iam-error", err)
+		return err
+	}
+	return nil
+}
+
+// PutGroupPolicy renders policyTemplate for resources and embeds it in the
+// group as an inline policy, replacing any policy of the same name.
+func (g *IAMGroup) PutGroupPolicy(groupName, policyName, policyTemplate string, resources []string) error {
+	policy, err := RenderPolicy(policyTemplate, resources, "")
+	if err != nil {
+		g.logger.Error("aws-iam-error", err)
+		return err
+	}
+
+	putGroupPolicyInput := &iam.PutGroupPolicyInput{
+		GroupName:      aws.String(groupName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(policy),
+	}
+	g.logger.Debug("put-group-policy", lager.Data{"input": putGroupPolicyInput})
+
+	_, err = awsretry.Call(context.Background(), g.retry.For("PutGroupPolicy"), func() (*iam.PutGroupPolicyOutput, error) {
+		return g.iamsvc.PutGroupPolicy(putGroupPolicyInput)
+	})
+	if err != nil {
+		g.logger.Error("aws-iam-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (g *IAMGroup) ListGroupPolicies(groupName string) ([]string, error) {
+	var policyNames []string
+
+	listGroupPoliciesInput := &iam.ListGroupPoliciesInput{
+		GroupName: aws.String(groupName),
+	}
+	g.logger.Debug("list-group-policies", lager.Data{"input": listGroupPoliciesInput})
+
+	_, err := awsretry.Do(context.Background(), g.retry.For("ListGroupPolicies"), awsretry.Never, func() error {
+		policyNames = nil
+		return g.iamsvc.ListGroupPoliciesPages(listGroupPoliciesInput, func(page *iam.ListGroupPoliciesOutput, lastPage bool) bool {
+			policyNames = append(policyNames, aws.StringValueSlice(page.PolicyNames)...)
+			return true
+		})
+	})
+	if err != nil {
+		g.logger.Error("aws-iam-error", err)
+		return policyNames, err
+	}
+
+	return policyNames, nil
+}
+
+func (g *IAMGroup) DeleteGroupPolicy(groupName, policyName string) error {
+	deleteGroupPolicyInput := &iam.DeleteGroupPolicyInput{
+		GroupName:  aws.String(groupName),
+		PolicyName: aws.String(policyName),
+	}
+	g.logger.Debug("delete-group-policy", lager.Data{"input": deleteGroupPolicyInput})
+
+	_, err := awsretry.Call(context.Background(), g.retry.For("DeleteGroupPolicy"), func() (*iam.DeleteGroupPolicyOutput, error) {
+		return g.iamsvc.DeleteGroupPolicy(deleteGroupPolicyInput)
+	})
+	if err != nil {
+		g.logger.Error("aws-iam-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (g *IAMGroup) AddUser(groupName, userName string) error {
+	addUserToGroupInput := &iam.AddUserToGroupInput{
+		GroupName: aws.String(groupName),
+		UserName:  aws.String(userName),
+	}
+	g.logger.Debug("add-user-to-group", lager.Data{"input": addUserToGroupInput})
+
+	_, err := awsretry.Call(context.Background(), g.retry.For("AddUserToGroup"), func() (*iam.AddUserToGroupOutput, error) {
+		return g.iamsvc.AddUserToGroup(addUserToGroupInput)
+	})
+	if err != nil {
+		g.logger.Error("aws-iam-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (g *IAMGroup) RemoveUser(groupName, userName string) error {
+	removeUserFromGroupInput := &iam.RemoveUserFromGroupInput{
+		GroupName: aws.String(groupName),
+		UserName:  aws.String(userName),
+	}
+	g.logger.Debug("remove-user-from-group", lager.Data{"input": removeUserFromGroupInput})
+
+	_, err := awsretry.Call(context.Background(), g.retry.For("RemoveUserFromGroup"), func() (*iam.RemoveUserFromGroupOutput, error) {
+		return g.iamsvc.RemoveUserFromGroup(removeUserFromGroupInput)
+	})
+	if err != nil {
+		g.logger.Error("aws-iam-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (g *IAMGroup) ListGroupsForUser(userName string) ([]string, error) {
+	var groupNames []string
+
+	listGroupsForUserInput := &iam.ListGroupsForUserInput{
+		UserName: aws.String(userName),
+	}
+	g.logger.Debug("list-groups-for-user", lager.Data{"input": listGroupsForUserInput})
+
+	_, err := awsretry.Do(context.Background(), g.retry.For("ListGroupsForUser"), awsretry.Never, func() error {
+		groupNames = nil
+		return g.iamsvc.ListGroupsForUserPages(listGroupsForUserInput, func(page *iam.ListGroupsForUserOutput, lastPage bool) bool {
+			for _, group := range page.Groups {
+				groupNames = append(groupNames, aws.StringValue(group.GroupName))
+			}
+			return true
+		})
+	})
+	if err != nil {
+		g.logger.Error("aws-iam-error", err)
+		return groupNames, err
+	}
+
+	return groupNames, nil
+}
@@ -0,0 +1,221 @@
+package awsiam_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awsretry"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+var _ = Describe("IAM Group", func() {
+	var (
+		groupName string
+		userName  string
+		iamPath   string
+
+		awsSession *session.Session
+		iamsvc     *iam.IAM
+		iamCall    func(r *request.Request)
+
+		testSink *lagertest.TestSink
+		logger   lager.Logger
+
+		group Group
+	)
+
+	BeforeEach(func() {
+		groupName = "iam-group"
+		userName = "iam-user"
+		iamPath = "/path/"
+	})
+
+	JustBeforeEach(func() {
+		awsSession = session.New(nil)
+		iamsvc = iam.New(awsSession)
+
+		logger = lager.NewLogger("iamgroup_test")
+		testSink = lagertest.NewTestSink()
+		logger.RegisterSink(testSink)
+
+		group = NewIAMGroup(iamsvc, logger, awsretry.Policy{})
+	})
+
+	var _ = Describe("Create", func() {
+		var createGroupError error
+
+		BeforeEach(func() {
+			createGroupError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("CreateGroup"))
+				Expect(r.Params).To(Equal(&iam.CreateGroupInput{
+					GroupName: aws.String(groupName),
+					Path:      aws.String(iamPath),
+				}))
+				r.Error = createGroupError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("creates the Group", func() {
+			err := group.Create(groupName, iamPath)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when the Group already exists", func() {
+			BeforeEach(func() {
+				createGroupError = awserr.New(iam.ErrCodeEntityAlreadyExistsException, "message", errors.New("operation failed"))
+			})
+
+			It("does not return an error", func() {
+				err := group.Create(groupName, iamPath)
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("when creating the Group fails", func() {
+			BeforeEach(func() {
+				createGroupError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				err := group.Create(groupName, iamPath)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+	})
+
+	var _ = Describe("PutGroupPolicy", func() {
+		var putGroupPolicyCall *iam.PutGroupPolicyInput
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("PutGroupPolicy"))
+				putGroupPolicyCall = r.Params.(*iam.PutGroupPolicyInput)
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("embeds the rendered Policy in the Group", func() {
+			err := group.PutGroupPolicy(groupName, "policy-name", `{"Resource": {{resources "/*"}}}`, []string{"arn:aws:s3:::bucket"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aws.StringValue(putGroupPolicyCall.GroupName)).To(Equal(groupName))
+			Expect(aws.StringValue(putGroupPolicyCall.PolicyName)).To(Equal("policy-name"))
+			Expect(aws.StringValue(putGroupPolicyCall.PolicyDocument)).To(MatchJSON(`{"Resource": ["arn:aws:s3:::bucket/*"]}`))
+		})
+	})
+
+	var _ = Describe("AddUser", func() {
+		var addUserToGroupError error
+
+		BeforeEach(func() {
+			addUserToGroupError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("AddUserToGroup"))
+				Expect(r.Params).To(Equal(&iam.AddUserToGroupInput{
+					GroupName: aws.String(groupName),
+					UserName:  aws.String(userName),
+				}))
+				r.Error = addUserToGroupError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("adds the User to the Group", func() {
+			err := group.AddUser(groupName, userName)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when adding the User fails", func() {
+			BeforeEach(func() {
+				addUserToGroupError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				err := group.AddUser(groupName, userName)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+	})
+
+	var _ = Describe("RemoveUser", func() {
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("RemoveUserFromGroup"))
+				Expect(r.Params).To(Equal(&iam.RemoveUserFromGroupInput{
+					GroupName: aws.String(groupName),
+					UserName:  aws.String(userName),
+				}))
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("removes the User from the Group", func() {
+			err := group.RemoveUser(groupName, userName)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	var _ = Describe("ListGroupsForUser", func() {
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("ListGroupsForUser"))
+				Expect(r.Params).To(Equal(&iam.ListGroupsForUserInput{UserName: aws.String(userName)}))
+				data := r.Data.(*iam.ListGroupsForUserOutput)
+				data.Groups = []*iam.Group{{GroupName: aws.String(groupName)}}
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("returns the names of the User's Groups", func() {
+			groupNames, err := group.ListGroupsForUser(userName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groupNames).To(Equal([]string{groupName}))
+		})
+	})
+
+	var _ = Describe("Delete", func() {
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("DeleteGroup"))
+				Expect(r.Params).To(Equal(&iam.DeleteGroupInput{GroupName: aws.String(groupName)}))
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("deletes the Group", func() {
+			err := group.Delete(groupName)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,376 @@
+package awsiam_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awsretry"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+var _ = Describe("IAM Role", func() {
+	var (
+		roleName            string
+		iamPath             string
+		permissionsBoundary string
+
+		awsSession *session.Session
+		iamsvc     *iam.IAM
+		iamCall    func(r *request.Request)
+
+		testSink *lagertest.TestSink
+		logger   lager.Logger
+
+		role Role
+	)
+
+	BeforeEach(func() {
+		roleName = "iam-role"
+		iamPath = "/path/"
+		permissionsBoundary = ""
+	})
+
+	JustBeforeEach(func() {
+		awsSession = session.New(nil)
+		iamsvc = iam.New(awsSession)
+
+		logger = lager.NewLogger("iamrole_test")
+		testSink = lagertest.NewTestSink()
+		logger.RegisterSink(testSink)
+
+		role = NewIAMRole(iamsvc, logger, awsretry.Policy{}, permissionsBoundary)
+	})
+
+	var _ = Describe("Exists", func() {
+		var getRoleError error
+
+		BeforeEach(func() {
+			getRoleError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("GetRole"))
+				Expect(r.Params).To(Equal(&iam.GetRoleInput{RoleName: aws.String(roleName)}))
+				data := r.Data.(*iam.GetRoleOutput)
+				data.Role = &iam.Role{Arn: aws.String("role-arn")}
+				r.Error = getRoleError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("returns true if the Role exists", func() {
+			exists, err := role.Exists(roleName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exists).To(BeTrue())
+		})
+
+		Context("when the Role does not exist", func() {
+			BeforeEach(func() {
+				getRoleError = awserr.New(iam.ErrCodeNoSuchEntityException, "message", errors.New("operation failed"))
+			})
+
+			It("returns false", func() {
+				exists, err := role.Exists(roleName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(exists).To(BeFalse())
+			})
+		})
+
+		Context("when getting the Role fails", func() {
+			BeforeEach(func() {
+				getRoleError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the error", func() {
+				_, err := role.Exists(roleName)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	var _ = Describe("Create", func() {
+		var (
+			trustPolicy     string
+			iamTags         []*iam.Tag
+			createRoleInput *iam.CreateRoleInput
+			createRoleError error
+		)
+
+		BeforeEach(func() {
+			trustPolicy = `{"Version": "2012-10-17"}`
+			iamTags = []*iam.Tag{{Key: aws.String("foo"), Value: aws.String("bar")}}
+			createRoleInput = &iam.CreateRoleInput{
+				RoleName:                 aws.String(roleName),
+				Path:                     aws.String(iamPath),
+				AssumeRolePolicyDocument: aws.String(trustPolicy),
+				Tags:                     iamTags,
+			}
+			createRoleError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("CreateRole"))
+				Expect(r.Params).To(Equal(createRoleInput))
+				data := r.Data.(*iam.CreateRoleOutput)
+				data.Role = &iam.Role{Arn: aws.String("role-arn")}
+				r.Error = createRoleError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("creates the Role and returns its ARN", func() {
+			roleARN, err := role.Create(roleName, iamPath, trustPolicy, iamTags)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(roleARN).To(Equal("role-arn"))
+		})
+
+		Context("with a permissions boundary", func() {
+			BeforeEach(func() {
+				permissionsBoundary = "arn:aws:iam::123456789012:policy/boundary"
+				createRoleInput.PermissionsBoundary = aws.String(permissionsBoundary)
+			})
+
+			It("creates the Role with the permissions boundary", func() {
+				_, err := role.Create(roleName, iamPath, trustPolicy, iamTags)
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("when creating the Role fails", func() {
+			BeforeEach(func() {
+				createRoleError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				_, err := role.Create(roleName, iamPath, trustPolicy, iamTags)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+	})
+
+	var _ = Describe("PutRolePolicy", func() {
+		var (
+			policyName        string
+			putRolePolicyCall *iam.PutRolePolicyInput
+		)
+
+		BeforeEach(func() {
+			policyName = "policy-name"
+			putRolePolicyCall = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("PutRolePolicy"))
+				putRolePolicyCall = r.Params.(*iam.PutRolePolicyInput)
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("embeds the rendered Policy in the Role", func() {
+			err := role.PutRolePolicy(roleName, policyName, `{"Resource": {{resources "/*"}}}`, []string{"arn:aws:s3:::bucket"}, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aws.StringValue(putRolePolicyCall.RoleName)).To(Equal(roleName))
+			Expect(aws.StringValue(putRolePolicyCall.PolicyName)).To(Equal(policyName))
+			Expect(aws.StringValue(putRolePolicyCall.PolicyDocument)).To(MatchJSON(`{"Resource": ["arn:aws:s3:::bucket/*"]}`))
+		})
+	})
+
+	var _ = Describe("ListRolePolicies", func() {
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("ListRolePolicies"))
+				data := r.Data.(*iam.ListRolePoliciesOutput)
+				data.PolicyNames = aws.StringSlice([]string{"policy-1", "policy-2"})
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("returns the inline Policy names", func() {
+			policyNames, err := role.ListRolePolicies(roleName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policyNames).To(Equal([]string{"policy-1", "policy-2"}))
+		})
+	})
+
+	var _ = Describe("DeleteRolePolicy", func() {
+		var deleteRolePolicyError error
+
+		BeforeEach(func() {
+			deleteRolePolicyError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("DeleteRolePolicy"))
+				Expect(r.Params).To(Equal(&iam.DeleteRolePolicyInput{
+					RoleName:   aws.String(roleName),
+					PolicyName: aws.String("policy-name"),
+				}))
+				r.Error = deleteRolePolicyError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("deletes the inline Policy", func() {
+			err := role.DeleteRolePolicy(roleName, "policy-name")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when deleting the inline Policy fails", func() {
+			BeforeEach(func() {
+				deleteRolePolicyError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				err := role.DeleteRolePolicy(roleName, "policy-name")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+	})
+
+	var _ = Describe("AttachRolePolicy", func() {
+		var attachRolePolicyError error
+
+		BeforeEach(func() {
+			attachRolePolicyError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("AttachRolePolicy"))
+				Expect(r.Params).To(Equal(&iam.AttachRolePolicyInput{
+					RoleName:  aws.String(roleName),
+					PolicyArn: aws.String("policy-arn"),
+				}))
+				r.Error = attachRolePolicyError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("attaches the Policy to the Role", func() {
+			err := role.AttachRolePolicy(roleName, "policy-arn")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when attaching the Policy fails", func() {
+			BeforeEach(func() {
+				attachRolePolicyError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				err := role.AttachRolePolicy(roleName, "policy-arn")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+	})
+
+	var _ = Describe("DetachRolePolicy", func() {
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("DetachRolePolicy"))
+				Expect(r.Params).To(Equal(&iam.DetachRolePolicyInput{
+					RoleName:  aws.String(roleName),
+					PolicyArn: aws.String("policy-arn"),
+				}))
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("detaches the Policy from the Role", func() {
+			err := role.DetachRolePolicy(roleName, "policy-arn")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	var _ = Describe("ListAttachedRolePolicies", func() {
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("ListAttachedRolePolicies"))
+				data := r.Data.(*iam.ListAttachedRolePoliciesOutput)
+				data.AttachedPolicies = []*iam.AttachedPolicy{{PolicyArn: aws.String("policy-arn")}}
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("returns the attached Policy ARNs", func() {
+			policyARNs, err := role.ListAttachedRolePolicies(roleName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policyARNs).To(Equal([]string{"policy-arn"}))
+		})
+	})
+
+	var _ = Describe("AccountTrustPolicy", func() {
+		It("trusts the principal only with the external ID", func() {
+			trustPolicy, err := AccountTrustPolicy("arn:aws:iam::123456789012:root", "external-id")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(trustPolicy).To(MatchJSON(`{
+				"Version": "2012-10-17",
+				"Statement": [{
+					"Effect": "Allow",
+					"Principal": {"AWS": "arn:aws:iam::123456789012:root"},
+					"Action": "sts:AssumeRole",
+					"Condition": {"StringEquals": {"sts:ExternalId": "external-id"}}
+				}]
+			}`))
+		})
+	})
+
+	var _ = Describe("WebIdentityTrustPolicy", func() {
+		It("trusts tokens from the provider issued to the subject", func() {
+			trustPolicy, err := WebIdentityTrustPolicy(
+				"arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-east-1.amazonaws.com/id/ABC",
+				"system:serviceaccount:default:app",
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(trustPolicy).To(MatchJSON(`{
+				"Version": "2012-10-17",
+				"Statement": [{
+					"Effect": "Allow",
+					"Principal": {"Federated": "arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-east-1.amazonaws.com/id/ABC"},
+					"Action": "sts:AssumeRoleWithWebIdentity",
+					"Condition": {"StringEquals": {
+						"oidc.eks.us-east-1.amazonaws.com/id/ABC:sub": "system:serviceaccount:default:app",
+						"oidc.eks.us-east-1.amazonaws.com/id/ABC:aud": "sts.amazonaws.com"
+					}}
+				}]
+			}`))
+		})
+
+		It("rejects ARNs that are not OIDC providers", func() {
+			_, err := WebIdentityTrustPolicy("arn:aws:iam::123456789012:role/app", "system:serviceaccount:default:app")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -1,29 +1,37 @@
 package awsiam
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"text/template"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/cloud-gov/s3-broker/awsretry"
 )
 
 type IAMUser struct {
-	iamsvc *iam.IAM
-	logger lager.Logger
+	iamsvc              *iam.IAM
+	retry               awsretry.Policy
+	permissionsBoundary string
+	logger              lager.Logger
 }
 
+// NewIAMUser returns a User backed by IAM. If permissionsBoundary is not
+// empty, it is set as the permissions boundary of every user created.
 func NewIAMUser(
 	iamsvc *iam.IAM,
 	logger lager.Logger,
+	retry awsretry.Policy,
+	permissionsBoundary string,
 ) *IAMUser {
 	return &IAMUser{
-		iamsvc: iamsvc,
-		logger: logger.Session("iam-user"),
+		iamsvc:              iamsvc,
+		retry:               retry,
+		permissionsBoundary: permissionsBoundary,
+		logger:              logger.Session("iam-user"),
 	}
 }
 
@@ -32,7 +40,9 @@ func (i *IAMUser) Exists(userName string) (bool, error) {
 		UserName: aws.String(userName),
 	}
 	i.logger.Debug("exists-user", lager.Data{"input": existsUserInput})
-	_, err := i.iamsvc.GetUser(existsUserInput)
+	_, err := awsretry.Call(context.Background(), i.retry.For("GetUser"), func() (*iam.GetUserOutput, error) {
+		return i.iamsvc.GetUser(existsUserInput)
+	})
 	if err != nil {
 		i.logger.Error("exists-user.aws-iam-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -55,7 +65,9 @@ func (i *IAMUser) Describe(userName string) (UserDetails, error) {
 	}
 	i.logger.Debug("describe-user", lager.Data{"input": getUserInput})
 
-	getUserOutput, err := i.iamsvc.GetUser(getUserInput)
+	getUserOutput, err := awsretry.Call(context.Background(), i.retry.For("GetUser"), func() (*iam.GetUserOutput, error) {
+		return i.iamsvc.GetUser(getUserInput)
+	})
 	if err != nil {
 		i.logger.Error("describe-user.aws-iam-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -67,6 +79,7 @@ func (i *IAMUser) Describe(userName string) (UserDetails, error) {
 
 	userDetails.UserARN = aws.StringValue(getUserOutput.User.Arn)
 	userDetails.UserID = aws.StringValue(getUserOutput.User.UserId)
+	userDetails.CreateDate = aws.TimeValue(getUserOutput.User.CreateDate)
 
 	return userDetails, nil
 }
@@ -77,13 +90,16 @@ func (i *IAMUser) Create(
 	iamTags []*iam.Tag,
 ) (string, error) {
 	createUserInput := &iam.CreateUserInput{
-		UserName: aws.String(userName),
-		Path:     stringOrNil(iamPath),
-		Tags:     iamTags,
+		UserName:            aws.String(userName),
+		Path:                stringOrNil(iamPath),
+		PermissionsBoundary: stringOrNil(i.permissionsBoundary),
+		Tags:                iamTags,
 	}
 	i.logger.Debug("create-user", lager.Data{"input": createUserInput})
 
-	createUserOutput, err := i.iamsvc.CreateUser(createUserInput)
+	createUserOutput, err := awsretry.Call(context.Background(), i.retry.For("CreateUser"), func() (*iam.CreateUserOutput, error) {
+		return i.iamsvc.CreateUser(createUserInput)
+	})
 	i.logger.Debug("create-user", lager.Data{"output": createUserOutput})
 
 	if err != nil {
@@ -103,7 +119,9 @@ func (i *IAMUser) Delete(userName string) error {
 	}
 	i.logger.Debug("delete-user", lager.Data{"input": deleteUserInput})
 
-	deleteUserOutput, err := i.iamsvc.DeleteUser(deleteUserInput)
+	deleteUserOutput, err := awsretry.Call(context.Background(), i.retry.For("DeleteUser"), func() (*iam.DeleteUserOutput, error) {
+		return i.iamsvc.DeleteUser(deleteUserInput)
+	})
 	if err != nil {
 		i.logger.Error("delete-user.aws-iam-error", err)
 		return err
@@ -113,24 +131,68 @@ func (i *IAMUser) Delete(userName string) error {
 	return nil
 }
 
+// ListUsers returns the names of all users under iamPath.
+func (i *IAMUser) ListUsers(iamPath string) ([]string, error) {
+	var userNames []string
+
+	listUsersInput := &iam.ListUsersInput{
+		PathPrefix: stringOrNil(iamPath),
+	}
+	i.logger.Debug("list-users", lager.Data{"input": listUsersInput})
+
+	_, err := awsretry.Do(context.Background(), i.retry.For("ListUsers"), awsretry.Never, func() error {
+		userNames = nil
+		return i.iamsvc.ListUsersPages(listUsersInput, func(page *iam.ListUsersOutput, lastPage bool) bool {
+			for _, user := range page.Users {
+				userNames = append(userNames, aws.StringValue(user.UserName))
+			}
+			return true
+		})
+	})
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		return userNames, err
+	}
+
+	return userNames, nil
+}
+
 func (i *IAMUser) ListAccessKeys(userName string) ([]string, error) {
 	var accessKeys []string
 
+	accessKeyDetails, err := i.DescribeAccessKeys(userName)
+	for _, accessKey := range accessKeyDetails {
+		accessKeys = append(accessKeys, accessKey.AccessKeyID)
+	}
+	return accessKeys, err
+}
+
+func (i *IAMUser) DescribeAccessKeys(userName string) ([]AccessKeyDetails, error) {
+	var accessKeys []AccessKeyDetails
+
 	listAccessKeysInput := &iam.ListAccessKeysInput{
 		UserName: aws.String(userName),
 	}
 	i.logger.Debug("list-access-keys", lager.Data{"input": listAccessKeysInput})
 
-	listAccessKeysOutput, err := i.iamsvc.ListAccessKeys(listAccessKeysInput)
+	_, err := awsretry.Do(context.Background(), i.retry.For("ListAccessKeys"), awsretry.Never, func() error {
+		accessKeys = nil
+		return i.iamsvc.ListAccessKeysPages(listAccessKeysInput, func(page *iam.ListAccessKeysOutput, lastPage bool) bool {
+			i.logger.Debug("list-access-keys", lager.Data{"output": page})
+			for _, accessKey := range page.AccessKeyMetadata {
+				accessKeys = append(accessKeys, AccessKeyDetails{
+					AccessKeyID: aws.StringValue(accessKey.AccessKeyId),
+					Status:      aws.StringValue(accessKey.Status),
+					CreateDate:  aws.TimeValue(accessKey.CreateDate),
+				})
+			}
+			return true
+		})
+	})
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		return accessKeys, err
 	}
-	i.logger.Debug("list-access-keys", lager.Data{"output": listAccessKeysOutput})
-
-	for _, accessKey := range listAccessKeysOutput.AccessKeyMetadata {
-		accessKeys = append(accessKeys, aws.StringValue(accessKey.AccessKeyId))
-	}
 
 	return accessKeys, nil
 }
@@ -141,7 +203,9 @@ func (i *IAMUser) CreateAccessKey(userName string) (string, string, error) {
 	}
 	i.logger.Debug("create-access-key", lager.Data{"input": createAccessKeyInput})
 
-	createAccessKeyOutput, err := i.iamsvc.CreateAccessKey(createAccessKeyInput)
+	createAccessKeyOutput, err := awsretry.Call(context.Background(), i.retry.For("CreateAccessKey"), func() (*iam.CreateAccessKeyOutput, error) {
+		return i.iamsvc.CreateAccessKey(createAccessKeyInput)
+	})
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -160,7 +224,9 @@ func (i *IAMUser) DeleteAccessKey(userName, accessKeyID string) error {
 	}
 	i.logger.Debug("delete-access-key", lager.Data{"input": deleteAccessKeyInput})
 
-	deleteAccessKeyOutput, err := i.iamsvc.DeleteAccessKey(deleteAccessKeyInput)
+	deleteAccessKeyOutput, err := awsretry.Call(context.Background(), i.retry.For("DeleteAccessKey"), func() (*iam.DeleteAccessKeyOutput, error) {
+		return i.iamsvc.DeleteAccessKey(deleteAccessKeyInput)
+	})
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -173,6 +239,52 @@ func (i *IAMUser) DeleteAccessKey(userName, accessKeyID string) error {
 	return nil
 }
 
+func (i *IAMUser) DeactivateAccessKey(userName, accessKeyID string) error {
+	updateAccessKeyInput := &iam.UpdateAccessKeyInput{
+		UserName:    aws.String(userName),
+		AccessKeyId: aws.String(accessKeyID),
+		Status:      aws.String(iam.StatusTypeInactive),
+	}
+	i.logger.Debug("deactivate-access-key", lager.Data{"input": updateAccessKeyInput})
+
+	_, err := awsretry.Call(context.Background(), i.retry.For("UpdateAccessKey"), func() (*iam.UpdateAccessKeyOutput, error) {
+		return i.iamsvc.UpdateAccessKey(updateAccessKeyInput)
+	})
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (i *IAMUser) AccessKeyLastUsed(accessKeyID string) (time.Time, error) {
+	getAccessKeyLastUsedInput := &iam.GetAccessKeyLastUsedInput{
+		AccessKeyId: aws.String(accessKeyID),
+	}
+	i.logger.Debug("get-access-key-last-used", lager.Data{"input": getAccessKeyLastUsedInput})
+
+	getAccessKeyLastUsedOutput, err := awsretry.Call(context.Background(), i.retry.For("GetAccessKeyLastUsed"), func() (*iam.GetAccessKeyLastUsedOutput, error) {
+		return i.iamsvc.GetAccessKeyLastUsed(getAccessKeyLastUsedInput)
+	})
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return time.Time{}, errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return time.Time{}, err
+	}
+	i.logger.Debug("get-access-key-last-used", lager.Data{"output": getAccessKeyLastUsedOutput})
+
+	if getAccessKeyLastUsedOutput.AccessKeyLastUsed == nil {
+		return time.Time{}, nil
+	}
+	return aws.TimeValue(getAccessKeyLastUsedOutput.AccessKeyLastUsed.LastUsedDate), nil
+}
+
 func (i *IAMUser) CreatePolicy(
 	policyName,
 	iamPath,
@@ -180,25 +292,7 @@ func (i *IAMUser) CreatePolicy(
 	resources []string,
 	iamTags []*iam.Tag,
 ) (string, error) {
-	tmpl, err := template.New("policy").Funcs(template.FuncMap{
-		"resources": func(suffix string) string {
-			resourcePaths := make([]string, len(resources))
-			for idx, resource := range resources {
-				resourcePaths[idx] = resource + suffix
-			}
-			marshaled, _ := json.Marshal(resourcePaths)
-			return string(marshaled)
-		},
-	}).Parse(policyTemplate)
-	if err != nil {
-		i.logger.Error("aws-iam-error", err)
-		return "", err
-	}
-	policy := bytes.Buffer{}
-	err = tmpl.Execute(&policy, map[string]interface{}{
-		"Resource":  resources[0],
-		"Resources": resources,
-	})
+	policy, err := renderPolicy(policyTemplate, resources, "")
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		return "", err
@@ -206,13 +300,15 @@ func (i *IAMUser) CreatePolicy(
 
 	createPolicyInput := &iam.CreatePolicyInput{
 		PolicyName:     aws.String(policyName),
-		PolicyDocument: aws.String(policy.String()),
+		PolicyDocument: aws.String(policy),
 		Path:           stringOrNil(iamPath),
 		Tags:           iamTags,
 	}
 	i.logger.Debug("create-policy", lager.Data{"input": createPolicyInput})
 
-	createPolicyOutput, err := i.iamsvc.CreatePolicy(createPolicyInput)
+	createPolicyOutput, err := awsretry.Call(context.Background(), i.retry.For("CreatePolicy"), func() (*iam.CreatePolicyOutput, error) {
+		return i.iamsvc.CreatePolicy(createPolicyInput)
+	})
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -231,7 +327,9 @@ func (i *IAMUser) DeletePolicy(policyARN string) error {
 	}
 	i.logger.Debug("delete-policy", lager.Data{"input": deletePolicyInput})
 
-	deletePolicyOutput, err := i.iamsvc.DeletePolicy(deletePolicyInput)
+	deletePolicyOutput, err := awsretry.Call(context.Background(), i.retry.For("DeletePolicy"), func() (*iam.DeletePolicyOutput, error) {
+		return i.iamsvc.DeletePolicy(deletePolicyInput)
+	})
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -253,7 +351,9 @@ func (i *IAMUser) ListAttachedUserPolicies(userName, iamPath string) ([]string,
 	}
 	i.logger.Debug("list-attached-user-policies", lager.Data{"input": listAttachedUserPoliciesInput})
 
-	listAttachedUserPoliciesOutput, err := i.iamsvc.ListAttachedUserPolicies(listAttachedUserPoliciesInput)
+	listAttachedUserPoliciesOutput, err := awsretry.Call(context.Background(), i.retry.For("ListAttachedUserPolicies"), func() (*iam.ListAttachedUserPoliciesOutput, error) {
+		return i.iamsvc.ListAttachedUserPolicies(listAttachedUserPoliciesInput)
+	})
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		return userPolicies, err
@@ -274,7 +374,9 @@ func (i *IAMUser) AttachUserPolicy(userName string, policyARN string) error {
 	}
 	i.logger.Debug("attach-user-policy", lager.Data{"input": attachUserPolicyInput})
 
-	attachUserPolicyOutput, err := i.iamsvc.AttachUserPolicy(attachUserPolicyInput)
+	attachUserPolicyOutput, err := awsretry.Call(context.Background(), i.retry.For("AttachUserPolicy"), func() (*iam.AttachUserPolicyOutput, error) {
+		return i.iamsvc.AttachUserPolicy(attachUserPolicyInput)
+	})
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -294,7 +396,9 @@ func (i *IAMUser) DetachUserPolicy(userName string, policyARN string) error {
 	}
 	i.logger.Debug("detach-user-policy", lager.Data{"input": detachUserPolicyInput})
 
-	detachUserPolicyOutput, err := i.iamsvc.DetachUserPolicy(detachUserPolicyInput)
+	detachUserPolicyOutput, err := awsretry.Call(context.Background(), i.retry.For("DetachUserPolicy"), func() (*iam.DetachUserPolicyOutput, error) {
+		return i.iamsvc.DetachUserPolicy(detachUserPolicyInput)
+	})
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -307,6 +411,85 @@ func (i *IAMUser) DetachUserPolicy(userName string, policyARN string) error {
 	return nil
 }
 
+// PutUserPolicy renders policyTemplate for resources and embeds it in the
+// user as an inline policy, so it is scoped to that user alone and cannot be
+// attached anywhere else. A non-empty pathPrefix confines object access and
+// listing to keys under that prefix.
+func (i *IAMUser) PutUserPolicy(userName, policyName, policyTemplate string, resources []string, pathPrefix string) error {
+	policy, err := RenderPolicy(policyTemplate, resources, pathPrefix)
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		return err
+	}
+
+	putUserPolicyInput := &iam.PutUserPolicyInput{
+		UserName:       aws.String(userName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(policy),
+	}
+	i.logger.Debug("put-user-policy", lager.Data{"input": putUserPolicyInput})
+
+	putUserPolicyOutput, err := awsretry.Call(context.Background(), i.retry.For("PutUserPolicy"), func() (*iam.PutUserPolicyOutput, error) {
+		return i.iamsvc.PutUserPolicy(putUserPolicyInput)
+	})
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return err
+	}
+	i.logger.Debug("put-user-policy", lager.Data{"output": putUserPolicyOutput})
+
+	return nil
+}
+
+func (i *IAMUser) ListUserPolicies(userName string) ([]string, error) {
+	var policyNames []string
+
+	listUserPoliciesInput := &iam.ListUserPoliciesInput{
+		UserName: aws.String(userName),
+	}
+	i.logger.Debug("list-user-policies", lager.Data{"input": listUserPoliciesInput})
+
+	_, err := awsretry.Do(context.Background(), i.retry.For("ListUserPolicies"), awsretry.Never, func() error {
+		policyNames = nil
+		return i.iamsvc.ListUserPoliciesPages(listUserPoliciesInput, func(page *iam.ListUserPoliciesOutput, lastPage bool) bool {
+			i.logger.Debug("list-user-policies", lager.Data{"output": page})
+			policyNames = append(policyNames, aws.StringValueSlice(page.PolicyNames)...)
+			return true
+		})
+	})
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		return policyNames, err
+	}
+
+	return policyNames, nil
+}
+
+func (i *IAMUser) DeleteUserPolicy(userName, policyName string) error {
+	deleteUserPolicyInput := &iam.DeleteUserPolicyInput{
+		UserName:   aws.String(userName),
+		PolicyName: aws.String(policyName),
+	}
+	i.logger.Debug("delete-user-policy", lager.Data{"input": deleteUserPolicyInput})
+
+	deleteUserPolicyOutput, err := awsretry.Call(context.Background(), i.retry.For("DeleteUserPolicy"), func() (*iam.DeleteUserPolicyOutput, error) {
+		return i.iamsvc.DeleteUserPolicy(deleteUserPolicyInput)
+	})
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return err
+	}
+	i.logger.Debug("delete-user-policy", lager.Data{"output": deleteUserPolicyOutput})
+
+	return nil
+}
+
 func stringOrNil(v string) *string {
 	if v != "" {
 		return &v
@@ -2,11 +2,13 @@ package awsiam_test
 
 import (
 	"errors"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awsretry"
 
 	"code.cloudfoundry.org/lager/v3"
 	"code.cloudfoundry.org/lager/v3/lagertest"
@@ -19,8 +21,9 @@ import (
 
 var _ = Describe("IAM User", func() {
 	var (
-		userName string
-		iamPath  string
+		userName            string
+		iamPath             string
+		permissionsBoundary string
 
 		awsSession *session.Session
 		iamsvc     *iam.IAM
@@ -35,6 +38,7 @@ var _ = Describe("IAM User", func() {
 	BeforeEach(func() {
 		userName = "iam-user"
 		iamPath = "/path/"
+		permissionsBoundary = ""
 	})
 
 	JustBeforeEach(func() {
@@ -45,7 +49,7 @@ var _ = Describe("IAM User", func() {
 		testSink = lagertest.NewTestSink()
 		logger.RegisterSink(testSink)
 
-		user = NewIAMUser(iamsvc, logger)
+		user = NewIAMUser(iamsvc, logger, awsretry.Policy{}, permissionsBoundary)
 	})
 	var _ = Describe("Exists", func() {
 		// Peter note to self: "Declare in container nodes, initialize in setup nodes"
@@ -122,14 +126,16 @@ var _ = Describe("IAM User", func() {
 
 		BeforeEach(func() {
 			properUserDetails = UserDetails{
-				UserName: userName,
-				UserARN:  "user-arn",
-				UserID:   "user-id",
+				UserName:   userName,
+				UserARN:    "user-arn",
+				UserID:     "user-id",
+				CreateDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
 			}
 
 			getUser = &iam.User{
-				Arn:    aws.String("user-arn"),
-				UserId: aws.String("user-id"),
+				Arn:        aws.String("user-arn"),
+				UserId:     aws.String("user-id"),
+				CreateDate: aws.Time(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
 			}
 			getUserInput = &iam.GetUserInput{
 				UserName: aws.String(userName),
@@ -231,6 +237,18 @@ var _ = Describe("IAM User", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		Context("with a permissions boundary", func() {
+			BeforeEach(func() {
+				permissionsBoundary = "arn:aws:iam::123456789012:policy/boundary"
+				createUserInput.PermissionsBoundary = aws.String(permissionsBoundary)
+			})
+
+			It("creates the User with the permissions boundary", func() {
+				_, err := user.Create(userName, iamPath, iamTags)
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
 		Context("when creating the User fails", func() {
 			BeforeEach(func() {
 				createUserError = errors.New("operation failed")
@@ -299,6 +317,37 @@ var _ = Describe("IAM User", func() {
 		})
 	})
 
+	var _ = Describe("ListUsers", func() {
+		var listUsersInput *iam.ListUsersInput
+
+		BeforeEach(func() {
+			listUsersInput = &iam.ListUsersInput{
+				PathPrefix: aws.String(iamPath),
+			}
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("ListUsers"))
+				Expect(r.Params).To(Equal(listUsersInput))
+				data := r.Data.(*iam.ListUsersOutput)
+				data.Users = []*iam.User{
+					{UserName: aws.String("user-1")},
+					{UserName: aws.String("user-2")},
+				}
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("lists the Users under the path", func() {
+			userNames, err := user.ListUsers(iamPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(userNames).To(Equal([]string{"user-1", "user-2"}))
+		})
+	})
+
 	var _ = Describe("ListAccessKeys", func() {
 		var (
 			listAccessKeysMetadata []*iam.AccessKeyMetadata
@@ -314,6 +363,8 @@ var _ = Describe("IAM User", func() {
 				},
 				&iam.AccessKeyMetadata{
 					AccessKeyId: aws.String("access-key-id-2"),
+					Status:      aws.String("Active"),
+					CreateDate:  aws.Time(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
 				},
 			}
 
@@ -343,6 +394,15 @@ var _ = Describe("IAM User", func() {
 			Expect(accessKeys).To(Equal([]string{"access-key-id-1", "access-key-id-2"}))
 		})
 
+		It("describes the User Access Keys", func() {
+			accessKeys, err := user.DescribeAccessKeys(userName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(accessKeys).To(Equal([]AccessKeyDetails{
+				{AccessKeyID: "access-key-id-1"},
+				{AccessKeyID: "access-key-id-2", Status: "Active", CreateDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			}))
+		})
+
 		Context("when listing the User Access Key fails", func() {
 			BeforeEach(func() {
 				listAccessKeysError = errors.New("operation failed")
@@ -483,6 +543,103 @@ var _ = Describe("IAM User", func() {
 		})
 	})
 
+	var _ = Describe("DeactivateAccessKey", func() {
+		var updateAccessKeyError error
+
+		BeforeEach(func() {
+			updateAccessKeyError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("UpdateAccessKey"))
+				Expect(r.Params).To(Equal(&iam.UpdateAccessKeyInput{
+					UserName:    aws.String(userName),
+					AccessKeyId: aws.String("access-key-id"),
+					Status:      aws.String("Inactive"),
+				}))
+				r.Error = updateAccessKeyError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("deactivates the User Access Key", func() {
+			err := user.DeactivateAccessKey(userName, "access-key-id")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when deactivating the User Access Key fails", func() {
+			BeforeEach(func() {
+				updateAccessKeyError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				err := user.DeactivateAccessKey(userName, "access-key-id")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+	})
+
+	var _ = Describe("AccessKeyLastUsed", func() {
+		var (
+			accessKeyLastUsed       *iam.AccessKeyLastUsed
+			getAccessKeyLastUsedErr error
+		)
+
+		BeforeEach(func() {
+			accessKeyLastUsed = &iam.AccessKeyLastUsed{LastUsedDate: aws.Time(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))}
+			getAccessKeyLastUsedErr = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("GetAccessKeyLastUsed"))
+				Expect(r.Params).To(Equal(&iam.GetAccessKeyLastUsedInput{
+					AccessKeyId: aws.String("access-key-id"),
+				}))
+				data := r.Data.(*iam.GetAccessKeyLastUsedOutput)
+				data.AccessKeyLastUsed = accessKeyLastUsed
+				r.Error = getAccessKeyLastUsedErr
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("returns when the Access Key was last used", func() {
+			lastUsed, err := user.AccessKeyLastUsed("access-key-id")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lastUsed).To(Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)))
+		})
+
+		Context("when the Access Key was never used", func() {
+			BeforeEach(func() {
+				accessKeyLastUsed = &iam.AccessKeyLastUsed{}
+			})
+
+			It("returns the zero time", func() {
+				lastUsed, err := user.AccessKeyLastUsed("access-key-id")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(lastUsed.IsZero()).To(BeTrue())
+			})
+		})
+
+		Context("when getting the last use fails", func() {
+			BeforeEach(func() {
+				getAccessKeyLastUsedErr = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				_, err := user.AccessKeyLastUsed("access-key-id")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+	})
+
 	var _ = Describe("CreatePolicy", func() {
 		var (
 			policyName string
@@ -827,4 +984,236 @@ var _ = Describe("IAM User", func() {
 			})
 		})
 	})
+
+	var _ = Describe("PutUserPolicy", func() {
+		var (
+			policyName string
+			template   string
+			resources  []string
+
+			putUserPolicyInput *iam.PutUserPolicyInput
+			putUserPolicyError error
+		)
+
+		BeforeEach(func() {
+			policyName = "policy-name"
+			template = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "effect",
+			"Action": "action",
+			"Resource": {{resources "/*"}}
+		}
+	]
+}`
+			resources = []string{"resource"}
+
+			putUserPolicyInput = &iam.PutUserPolicyInput{
+				UserName:   aws.String(userName),
+				PolicyName: aws.String(policyName),
+				PolicyDocument: aws.String(`{
+					"Version": "2012-10-17",
+					"Statement": [
+						{
+							"Effect": "effect",
+							"Action": "action",
+							"Resource": ["resource/*"]
+						}
+					]
+				}`),
+			}
+			putUserPolicyError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("PutUserPolicy"))
+				Expect(r.Params).To(BeAssignableToTypeOf(&iam.PutUserPolicyInput{}))
+				params := r.Params.(*iam.PutUserPolicyInput)
+				Expect(params.UserName).To(Equal(putUserPolicyInput.UserName))
+				Expect(params.PolicyName).To(Equal(putUserPolicyInput.PolicyName))
+				Expect(*params.PolicyDocument).To(MatchJSON(*putUserPolicyInput.PolicyDocument))
+				r.Error = putUserPolicyError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("puts the inline Policy", func() {
+			err := user.PutUserPolicy(userName, policyName, template, resources, "")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("with a path prefix", func() {
+			BeforeEach(func() {
+				template = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": ["s3:GetBucketLocation", "s3:ListBucket"],
+			"Resource": {{resources ""}}
+		},
+		{
+			"Effect": "Allow",
+			"Action": "s3:GetObject",
+			"Resource": {{resources "/*"}}
+		}
+	]
+}`
+				putUserPolicyInput.PolicyDocument = aws.String(`{
+					"Version": "2012-10-17",
+					"Statement": [
+						{
+							"Effect": "Allow",
+							"Action": ["s3:GetBucketLocation"],
+							"Resource": ["resource"]
+						},
+						{
+							"Effect": "Allow",
+							"Action": ["s3:ListBucket"],
+							"Resource": ["resource"],
+							"Condition": {"StringLike": {"s3:prefix": ["app/", "app/*"]}}
+						},
+						{
+							"Effect": "Allow",
+							"Action": "s3:GetObject",
+							"Resource": ["resource/app/*"]
+						}
+					]
+				}`)
+			})
+
+			It("confines the Policy to the prefix", func() {
+				err := user.PutUserPolicy(userName, policyName, template, resources, "app")
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("when the template is invalid", func() {
+			BeforeEach(func() {
+				template = "{{"
+			})
+
+			It("returns an error", func() {
+				err := user.PutUserPolicy(userName, policyName, template, resources, "")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("when putting the Policy fails", func() {
+			BeforeEach(func() {
+				putUserPolicyError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				err := user.PutUserPolicy(userName, policyName, template, resources, "")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+	})
+
+	var _ = Describe("ListUserPolicies", func() {
+		var (
+			pages [][]string
+
+			listUserPoliciesError error
+		)
+
+		BeforeEach(func() {
+			pages = [][]string{{"policy-1", "policy-2"}, {"policy-3"}}
+			listUserPoliciesError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			page := 0
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("ListUserPolicies"))
+				Expect(r.Params).To(BeAssignableToTypeOf(&iam.ListUserPoliciesInput{}))
+				Expect(r.Params.(*iam.ListUserPoliciesInput).UserName).To(Equal(aws.String(userName)))
+				r.Error = listUserPoliciesError
+				if r.Error != nil {
+					return
+				}
+				data := r.Data.(*iam.ListUserPoliciesOutput)
+				data.PolicyNames = aws.StringSlice(pages[page])
+				page++
+				if page < len(pages) {
+					data.IsTruncated = aws.Bool(true)
+					data.Marker = aws.String("marker")
+				}
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("lists the inline Policies across all pages", func() {
+			policyNames, err := user.ListUserPolicies(userName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policyNames).To(Equal([]string{"policy-1", "policy-2", "policy-3"}))
+		})
+
+		Context("when listing the inline Policies fails", func() {
+			BeforeEach(func() {
+				listUserPoliciesError = errors.New("operation failed")
+			})
+
+			It("returns the proper error", func() {
+				_, err := user.ListUserPolicies(userName)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("operation failed"))
+			})
+		})
+	})
+
+	var _ = Describe("DeleteUserPolicy", func() {
+		var (
+			policyName string
+
+			deleteUserPolicyInput *iam.DeleteUserPolicyInput
+			deleteUserPolicyError error
+		)
+
+		BeforeEach(func() {
+			policyName = "policy-name"
+
+			deleteUserPolicyInput = &iam.DeleteUserPolicyInput{
+				UserName:   aws.String(userName),
+				PolicyName: aws.String(policyName),
+			}
+			deleteUserPolicyError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("DeleteUserPolicy"))
+				Expect(r.Params).To(Equal(deleteUserPolicyInput))
+				r.Error = deleteUserPolicyError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("deletes the inline Policy", func() {
+			err := user.DeleteUserPolicy(userName, policyName)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when deleting the inline Policy fails", func() {
+			BeforeEach(func() {
+				deleteUserPolicyError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				err := user.DeleteUserPolicy(userName, policyName)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+	})
 })
@@ -0,0 +1,154 @@
+package awsiam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"text/template"
+)
+
+// prefixListActions are the bucket-level actions that accept the s3:prefix
+// condition key, so they can be limited to keys under a path prefix.
+var prefixListActions = map[string]bool{
+	"s3:ListBucket":         true,
+	"s3:ListBucketVersions": true,
+}
+
+// RenderPolicy renders a binding's policy template for resources. A non-empty
+// pathPrefix confines object access and listing to keys under that prefix.
+func RenderPolicy(policyTemplate string, resources []string, pathPrefix string) (string, error) {
+	policy, err := renderPolicy(policyTemplate, resources, pathPrefix)
+	if err != nil || pathPrefix == "" {
+		return policy, err
+	}
+	return scopePolicyToPrefix(policy, pathPrefix)
+}
+
+// renderPolicy executes a policy template. Templates see the first resource as
+// .Resource, all of them as .Resources, the path prefix as .PathPrefix, and
+// can call `resources "/*"` to get a JSON list of the resources with a suffix
+// appended. When pathPrefix is set, suffixes starting with "/" are placed
+// under the prefix, so "/*" becomes "/<prefix>/*".
+func renderPolicy(policyTemplate string, resources []string, pathPrefix string) (string, error) {
+	tmpl, err := template.New("policy").Funcs(template.FuncMap{
+		"resources": func(suffix string) string {
+			if pathPrefix != "" && strings.HasPrefix(suffix, "/") {
+				suffix = "/" + pathPrefix + suffix
+			}
+			resourcePaths := make([]string, len(resources))
+			for idx, resource := range resources {
+				resourcePaths[idx] = resource + suffix
+			}
+			marshaled, _ := json.Marshal(resourcePaths)
+			return string(marshaled)
+		},
+	}).Parse(policyTemplate)
+	if err != nil {
+		return "", err
+	}
+	policy := bytes.Buffer{}
+	err = tmpl.Execute(&policy, map[string]interface{}{
+		"Resource":   resources[0],
+		"Resources":  resources,
+		"PathPrefix": pathPrefix,
+	})
+	if err != nil {
+		return "", err
+	}
+	return policy.String(), nil
+}
+
+// scopePolicyToPrefix moves the list actions of each Allow statement into a
+// statement of their own that only matches requests for keys under
+// pathPrefix. Wildcard actions such as "s3:List*" are left untouched.
+func scopePolicyToPrefix(policy, pathPrefix string) (string, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return "", err
+	}
+
+	var statements []interface{}
+	switch statement := document["Statement"].(type) {
+	case []interface{}:
+		statements = statement
+	case map[string]interface{}:
+		statements = []interface{}{statement}
+	default:
+		return "", errors.New("policy has no Statement")
+	}
+
+	var scoped []interface{}
+	for _, s := range statements {
+		statement, ok := s.(map[string]interface{})
+		if !ok || statement["Effect"] != "Allow" {
+			scoped = append(scoped, s)
+			continue
+		}
+
+		var actions, listActions []interface{}
+		for _, action := range actionList(statement["Action"]) {
+			if name, ok := action.(string); ok && prefixListActions[name] {
+				listActions = append(listActions, action)
+			} else {
+				actions = append(actions, action)
+			}
+		}
+		if len(listActions) == 0 {
+			scoped = append(scoped, statement)
+			continue
+		}
+
+		if len(actions) > 0 {
+			statement["Action"] = actions
+			scoped = append(scoped, statement)
+		}
+		listStatement := map[string]interface{}{}
+		for key, value := range statement {
+			if key != "Sid" {
+				listStatement[key] = value
+			}
+		}
+		listStatement["Action"] = listActions
+		listStatement["Condition"] = withPrefixCondition(statement["Condition"], pathPrefix)
+		scoped = append(scoped, listStatement)
+	}
+	document["Statement"] = scoped
+
+	marshaled, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return string(marshaled), nil
+}
+
+// withPrefixCondition returns a copy of condition that also requires the
+// s3:prefix key to fall under pathPrefix.
+func withPrefixCondition(condition interface{}, pathPrefix string) map[string]interface{} {
+	merged := map[string]interface{}{}
+	if existing, ok := condition.(map[string]interface{}); ok {
+		for operator, keys := range existing {
+			merged[operator] = keys
+		}
+	}
+	stringLike := map[string]interface{}{}
+	if existing, ok := merged["StringLike"].(map[string]interface{}); ok {
+		for key, values := range existing {
+			stringLike[key] = values
+		}
+	}
+	stringLike["s3:prefix"] = []string{pathPrefix + "/", pathPrefix + "/*"}
+	merged["StringLike"] = stringLike
+	return merged
+}
+
+func actionList(action interface{}) []interface{} {
+	switch action := action.(type) {
+	case string:
+		return []interface{}{action}
+	case []interface{}:
+		return action
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,287 @@
+package awsiam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// Role manages IAM roles for bindings whose consumers bring their own AWS
+// identity and assume the role instead of receiving keys.
+type Role interface {
+	Exists(roleName string) (bool, error)
+	Create(roleName, iamPath, trustPolicy string, iamTags []*iam.Tag) (string, error)
+	Delete(roleName string) error
+	PutRolePolicy(roleName, policyName, policyTemplate string, resources []string, pathPrefix string) error
+	ListRolePolicies(roleName string) ([]string, error)
+	DeleteRolePolicy(roleName, policyName string) error
+	AttachRolePolicy(roleName, policyARN string) error
+	DetachRolePolicy(roleName, policyARN string) error
+	ListAttachedRolePolicies(roleName string) ([]string, error)
+}
+
+type IAMRole struct {
+	iamsvc              *iam.IAM
+	retry               awsretry.Policy
+	permissionsBoundary string
+	logger              lager.Logger
+}
+
+// NewIAMRole returns a Role backed by IAM. If permissionsBoundary is not
+// empty, it is set as the permissions boundary of every role created.
+func NewIAMRole(
+	iamsvc *iam.IAM,
+	logger lager.Logger,
+	retry awsretry.Policy,
+	permissionsBoundary string,
+) *IAMRole {
+	return &IAMRole{
+		iamsvc:              iamsvc,
+		retry:               retry,
+		permissionsBoundary: permissionsBoundary,
+		logger:              logger.Session("iam-role"),
+	}
+}
+
+func (r *IAMRole) Exists(roleName string) (bool, error) {
+	getRoleInput := &iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	}
+	r.logger.Debug("exists-role", lager.Data{"input": getRoleInput})
+	_, err := awsretry.Call(context.Background(), r.retry.For("GetRole"), func() (*iam.GetRoleOutput, error) {
+		return r.iamsvc.GetRole(getRoleInput)
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == iam.ErrCodeNoSuchEntityException {
+			return false, nil
+		}
+		r.logger.Error("exists-role.aws-iam-error", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *IAMRole) Create(roleName, iamPath, trustPolicy string, iamTags []*iam.Tag) (string, error) {
+	createRoleInput := &iam.CreateRoleInput{
+		RoleName:                 aws.String(roleName),
+		Path:                     stringOrNil(iamPath),
+		AssumeRolePolicyDocument: aws.String(trustPolicy),
+		PermissionsBoundary:      stringOrNil(r.permissionsBoundary),
+		Tags:                     iamTags,
+	}
+	r.logger.Debug("create-role", lager.Data{"input": createRoleInput})
+
+	createRoleOutput, err := awsretry.Call(context.Background(), r.retry.For("CreateRole"), func() (*iam.CreateRoleOutput, error) {
+		return r.iamsvc.CreateRole(createRoleInput)
+	})
+	if err != nil {
+		r.logger.Error("create-role.aws-iam-error", err)
+		return "", convertError(err)
+	}
+	r.logger.Debug("create-role", lager.Data{"output": createRoleOutput})
+
+	return aws.StringValue(createRoleOutput.Role.Arn), nil
+}
+
+func (r *IAMRole) Delete(roleName string) error {
+	deleteRoleInput := &iam.DeleteRoleInput{
+		RoleName: aws.String(roleName),
+	}
+	r.logger.Debug("delete-role", lager.Data{"input": deleteRoleInput})
+
+	_, err := awsretry.Call(context.Background(), r.retry.For("DeleteRole"), func() (*iam.DeleteRoleOutput, error) {
+		return r.iamsvc.DeleteRole(deleteRoleInput)
+	})
+	if err != nil {
+		r.logger.Error("delete-role.aws-iam-error", err)
+		return err
+	}
+	return nil
+}
+
+// PutRolePolicy renders policyTemplate for resources and embeds it in the
+// role as an inline policy.
+func (r *IAMRole) PutRolePolicy(roleName, policyName, policyTemplate string, resources []string, pathPrefix string) error {
+	policy, err := RenderPolicy(policyTemplate, resources, pathPrefix)
+	if err != nil {
+		r.logger.Error("aws-iam-error", err)
+		return err
+	}
+
+	putRolePolicyInput := &iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(policy),
+	}
+	r.logger.Debug("put-role-policy", lager.Data{"input": putRolePolicyInput})
+
+	_, err = awsretry.Call(context.Background(), r.retry.For("PutRolePolicy"), func() (*iam.PutRolePolicyOutput, error) {
+		return r.iamsvc.PutRolePolicy(putRolePolicyInput)
+	})
+	if err != nil {
+		r.logger.Error("aws-iam-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (r *IAMRole) ListRolePolicies(roleName string) ([]string, error) {
+	var policyNames []string
+
+	listRolePoliciesInput := &iam.ListRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	}
+	r.logger.Debug("list-role-policies", lager.Data{"input": listRolePoliciesInput})
+
+	_, err := awsretry.Do(context.Background(), r.retry.For("ListRolePolicies"), awsretry.Never, func() error {
+		policyNames = nil
+		return r.iamsvc.ListRolePoliciesPages(listRolePoliciesInput, func(page *iam.ListRolePoliciesOutput, lastPage bool) bool {
+			policyNames = append(policyNames, aws.StringValueSlice(page.PolicyNames)...)
+			return true
+		})
+	})
+	if err != nil {
+		r.logger.Error("aws-iam-error", err)
+		return policyNames, err
+	}
+
+	return policyNames, nil
+}
+
+func (r *IAMRole) DeleteRolePolicy(roleName, policyName string) error {
+	deleteRolePolicyInput := &iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(roleName),
+		PolicyName: aws.String(policyName),
+	}
+	r.logger.Debug("delete-role-policy", lager.Data{"input": deleteRolePolicyInput})
+
+	_, err := awsretry.Call(context.Background(), r.retry.For("DeleteRolePolicy"), func() (*iam.DeleteRolePolicyOutput, error) {
+		return r.iamsvc.DeleteRolePolicy(deleteRolePolicyInput)
+	})
+	if err != nil {
+		r.logger.Error("aws-iam-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (r *IAMRole) AttachRolePolicy(roleName, policyARN string) error {
+	attachRolePolicyInput := &iam.AttachRolePolicyInput{
+		RoleName:  aws.String(roleName),
+		PolicyArn: aws.String(policyARN),
+	}
+	r.logger.Debug("attach-role-policy", lager.Data{"input": attachRolePolicyInput})
+
+	_, err := awsretry.Call(context.Background(), r.retry.For("AttachRolePolicy"), func() (*iam.AttachRolePolicyOutput, error) {
+		return r.iamsvc.AttachRolePolicy(attachRolePolicyInput)
+	})
+	if err != nil {
+		r.logger.Error("aws-iam-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (r *IAMRole) DetachRolePolicy(roleName, policyARN string) error {
+	detachRolePolicyInput := &iam.DetachRolePolicyInput{
+		RoleName:  aws.String(roleName),
+		PolicyArn: aws.String(policyARN),
+	}
+	r.logger.Debug("detach-role-policy", lager.Data{"input": detachRolePolicyInput})
+
+	_, err := awsretry.Call(context.Background(), r.retry.For("DetachRolePolicy"), func() (*iam.DetachRolePolicyOutput, error) {
+		return r.iamsvc.DetachRolePolicy(detachRolePolicyInput)
+	})
+	if err != nil {
+		r.logger.Error("aws-iam-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (r *IAMRole) ListAttachedRolePolicies(roleName string) ([]string, error) {
+	var policyARNs []string
+
+	listAttachedRolePoliciesInput := &iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	}
+	r.logger.Debug("list-attached-role-policies", lager.Data{"input": listAttachedRolePoliciesInput})
+
+	_, err := awsretry.Do(context.Background(), r.retry.For("ListAttachedRolePolicies"), awsretry.Never, func() error {
+		policyARNs = nil
+		return r.iamsvc.ListAttachedRolePoliciesPages(listAttachedRolePoliciesInput, func(page *iam.ListAttachedRolePoliciesOutput, lastPage bool) bool {
+			for _, policy := range page.AttachedPolicies {
+				policyARNs = append(policyARNs, aws.StringValue(policy.PolicyArn))
+			}
+			return true
+		})
+	})
+	if err != nil {
+		r.logger.Error("aws-iam-error", err)
+		return policyARNs, err
+	}
+
+	return policyARNs, nil
+}
+
+// AccountTrustPolicy returns a trust policy that lets principalARN assume a
+// role, but only when it passes externalID.
+func AccountTrustPolicy(principalARN, externalID string) (string, error) {
+	return marshalTrustPolicy(map[string]interface{}{
+		"Effect":    "Allow",
+		"Principal": map[string]interface{}{"AWS": principalARN},
+		"Action":    "sts:AssumeRole",
+		"Condition": map[string]interface{}{
+			"StringEquals": map[string]interface{}{"sts:ExternalId": externalID},
+		},
+	})
+}
+
+// WebIdentityTrustPolicy returns a trust policy that lets tokens from an IAM
+// OIDC identity provider, such as an EKS cluster's, assume a role when issued
+// to subject for STS. With IRSA, subject is
+// system:serviceaccount:<namespace>:<name>.
+func WebIdentityTrustPolicy(providerARN, subject string) (string, error) {
+	_, issuer, ok := strings.Cut(providerARN, ":oidc-provider/")
+	if !ok || issuer == "" {
+		return "", fmt.Errorf("%q is not an OIDC provider ARN", providerARN)
+	}
+	return marshalTrustPolicy(map[string]interface{}{
+		"Effect":    "Allow",
+		"Principal": map[string]interface{}{"Federated": providerARN},
+		"Action":    "sts:AssumeRoleWithWebIdentity",
+		"Condition": map[string]interface{}{
+			"StringEquals": map[string]interface{}{
+				issuer + ":sub": subject,
+				issuer + ":aud": "sts.amazonaws.com",
+			},
+		},
+	})
+}
+
+func marshalTrustPolicy(statement map[string]interface{}) (string, error) {
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": []interface{}{statement},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(policy), nil
+}
+
+func convertError(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return errors.New(awsErr.Code() + ": " + awsErr.Message())
+	}
+	return err
+}
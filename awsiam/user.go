@@ -3,10 +3,12 @@ package awsiam
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/cloud-gov/s3-broker/awsretry"
 )
 
 type User interface {
@@ -14,29 +16,45 @@ type User interface {
 	Describe(userName string) (UserDetails, error)
 	Create(userName, iamPath string, iamTags []*iam.Tag) (string, error)
 	Delete(userName string) error
+	ListUsers(iamPath string) ([]string, error)
 	ListAccessKeys(userName string) ([]string, error)
+	DescribeAccessKeys(userName string) ([]AccessKeyDetails, error)
 	CreateAccessKey(userName string) (string, string, error)
 	DeleteAccessKey(userName, accessKeyID string) error
+	DeactivateAccessKey(userName, accessKeyID string) error
+	// AccessKeyLastUsed returns when an access key was last used, or the
+	// zero time if it never was.
+	AccessKeyLastUsed(accessKeyID string) (time.Time, error)
 	CreatePolicy(policyName, iamPath, policyTemplate string, resources []string, iamTags []*iam.Tag) (string, error)
 	DeletePolicy(policyARN string) error
 	ListAttachedUserPolicies(userName, iamPath string) ([]string, error)
 	AttachUserPolicy(userName, policyARN string) error
 	DetachUserPolicy(userName, policyARN string) error
+	PutUserPolicy(userName, policyName, policyTemplate string, resources []string, pathPrefix string) error
+	ListUserPolicies(userName string) ([]string, error)
+	DeleteUserPolicy(userName, policyName string) error
 }
 
 type UserDetails struct {
-	UserName string
-	UserARN  string
-	UserID   string
+	UserName   string
+	UserARN    string
+	UserID     string
+	CreateDate time.Time
+}
+
+type AccessKeyDetails struct {
+	AccessKeyID string
+	Status      string
+	CreateDate  time.Time
 }
 
 var (
 	ErrUserDoesNotExist = errors.New("iam user does not exist")
 )
 
-func NewUser(provider string, logger lager.Logger, awsSession *session.Session, endpoint string, insecureSkipVerify bool) (User, error) {
+func NewUser(provider string, logger lager.Logger, awsSession *session.Session, endpoint string, insecureSkipVerify bool, retry awsretry.Policy, permissionsBoundary string) (User, error) {
 	fmt.Printf("Setting up AWS IAM user provider...\n")
 	iamsvc := iam.New(awsSession)
-	user := NewIAMUser(iamsvc, logger)
+	user := NewIAMUser(iamsvc, logger, retry, permissionsBoundary)
 	return user, nil
 }
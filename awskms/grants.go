@@ -0,0 +1,175 @@
+// Package awskms manages KMS grants that let binding principals use the
+// customer-managed key encrypting their bucket.
+package awskms
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type KMSClient interface {
+	DescribeKeyWithContext(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error)
+	CreateGrantWithContext(ctx aws.Context, input *kms.CreateGrantInput, opts ...request.Option) (*kms.CreateGrantOutput, error)
+	ListGrantsPagesWithContext(ctx aws.Context, input *kms.ListGrantsInput, fn func(*kms.ListGrantsResponse, bool) bool, opts ...request.Option) error
+	RetireGrantWithContext(ctx aws.Context, input *kms.RetireGrantInput, opts ...request.Option) (*kms.RetireGrantOutput, error)
+}
+
+// Grants gives principals use of a KMS key through named grants. keyID may be
+// a key ID, key ARN, alias name or alias ARN.
+type Grants interface {
+	Create(ctx context.Context, keyID, granteePrincipal, name string) error
+	Retire(ctx context.Context, keyID, name string) error
+}
+
+// GrantOperations are what S3 needs to read and write objects encrypted with
+// SSE-KMS on the caller's behalf.
+var GrantOperations = []string{kms.GrantOperationDecrypt, kms.GrantOperationGenerateDataKey}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9:/_-]`)
+
+type KMSGrants struct {
+	kmssvc KMSClient
+	retry  awsretry.Policy
+	logger lager.Logger
+}
+
+func NewKMSGrants(kmssvc KMSClient, logger lager.Logger, retry awsretry.Policy) *KMSGrants {
+	return &KMSGrants{
+		kmssvc: kmssvc,
+		retry:  retry,
+		logger: logger.Session("kms-grants"),
+	}
+}
+
+// Create grants granteePrincipal GrantOperations on the key. Principals
+// created moments before may not be known to KMS yet, so an invalid ARN is
+// retried.
+func (g *KMSGrants) Create(ctx context.Context, keyID, granteePrincipal, name string) error {
+	keyARN, err := g.keyARN(ctx, keyID)
+	if err != nil {
+		return convertError(err)
+	}
+
+	createGrantInput := &kms.CreateGrantInput{
+		KeyId:            aws.String(keyARN),
+		GranteePrincipal: aws.String(granteePrincipal),
+		Operations:       aws.StringSlice(GrantOperations),
+		Name:             aws.String(grantName(name)),
+	}
+	g.logger.Debug("create-grant", lager.Data{"input": createGrantInput})
+
+	_, err = awsretry.Do(ctx, g.retry.For("CreateGrant"), isInvalidArnException, func() error {
+		_, err := g.kmssvc.CreateGrantWithContext(ctx, createGrantInput)
+		return err
+	})
+	if err != nil {
+		g.logger.Error("create-grant.aws-kms-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+// Retire retires every grant on the key with the given name. A key that no
+// longer exists has no grants to retire.
+func (g *KMSGrants) Retire(ctx context.Context, keyID, name string) error {
+	keyARN, err := g.keyARN(ctx, keyID)
+	if err != nil {
+		if isNotFoundException(err) {
+			return nil
+		}
+		return convertError(err)
+	}
+
+	var grantIDs []string
+	listGrantsInput := &kms.ListGrantsInput{
+		KeyId: aws.String(keyARN),
+	}
+	g.logger.Debug("list-grants", lager.Data{"input": listGrantsInput})
+
+	_, err = awsretry.Do(ctx, g.retry.For("ListGrants"), awsretry.Never, func() error {
+		grantIDs = nil
+		return g.kmssvc.ListGrantsPagesWithContext(ctx, listGrantsInput, func(page *kms.ListGrantsResponse, lastPage bool) bool {
+			for _, grant := range page.Grants {
+				if aws.StringValue(grant.Name) == grantName(name) {
+					grantIDs = append(grantIDs, aws.StringValue(grant.GrantId))
+				}
+			}
+			return true
+		})
+	})
+	if err != nil {
+		g.logger.Error("list-grants.aws-kms-error", err)
+		return convertError(err)
+	}
+
+	for _, grantID := range grantIDs {
+		retireGrantInput := &kms.RetireGrantInput{
+			KeyId:   aws.String(keyARN),
+			GrantId: aws.String(grantID),
+		}
+		g.logger.Debug("retire-grant", lager.Data{"input": retireGrantInput})
+
+		_, err := awsretry.Call(ctx, g.retry.For("RetireGrant"), func() (*kms.RetireGrantOutput, error) {
+			return g.kmssvc.RetireGrantWithContext(ctx, retireGrantInput)
+		})
+		if err != nil && !isNotFoundException(err) {
+			g.logger.Error("retire-grant.aws-kms-error", err)
+			return convertError(err)
+		}
+	}
+	return nil
+}
+
+// keyARN resolves keyID to the key's ARN, which grant operations require in
+// place of an alias. Errors are returned unconverted.
+func (g *KMSGrants) keyARN(ctx context.Context, keyID string) (string, error) {
+	describeKeyInput := &kms.DescribeKeyInput{
+		KeyId: aws.String(keyID),
+	}
+	g.logger.Debug("describe-key", lager.Data{"input": describeKeyInput})
+
+	describeKeyOutput, err := awsretry.Call(ctx, g.retry.For("DescribeKey"), func() (*kms.DescribeKeyOutput, error) {
+		return g.kmssvc.DescribeKeyWithContext(ctx, describeKeyInput)
+	})
+	if err != nil {
+		g.logger.Error("describe-key.aws-kms-error", err)
+		return "", err
+	}
+	return aws.StringValue(describeKeyOutput.KeyMetadata.Arn), nil
+}
+
+// grantName makes name acceptable to KMS, which allows fewer characters in
+// grant names than IAM does in user names.
+func grantName(name string) string {
+	name = invalidNameChars.ReplaceAllString(name, "-")
+	if len(name) > 256 {
+		name = name[len(name)-256:]
+	}
+	return name
+}
+
+func isInvalidArnException(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == kms.ErrCodeInvalidArnException
+}
+
+func isNotFoundException(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == kms.ErrCodeNotFoundException
+}
+
+func convertError(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return errors.New(awsErr.Code() + ": " + awsErr.Message())
+	}
+	return err
+}
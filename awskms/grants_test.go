@@ -0,0 +1,144 @@
+package awskms
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+const testKeyARN = "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+type mockKMSClient struct {
+	describeKeyErr  error
+	createGrantErrs []error
+	createGrantCall int
+	createGrant     *kms.CreateGrantInput
+	grants          []*kms.GrantListEntry
+	retired         []string
+}
+
+func (c *mockKMSClient) DescribeKeyWithContext(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+	if c.describeKeyErr != nil {
+		return nil, c.describeKeyErr
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{Arn: aws.String(testKeyARN)}}, nil
+}
+
+func (c *mockKMSClient) CreateGrantWithContext(ctx aws.Context, input *kms.CreateGrantInput, opts ...request.Option) (*kms.CreateGrantOutput, error) {
+	c.createGrant = input
+	c.createGrantCall++
+	if c.createGrantCall <= len(c.createGrantErrs) {
+		return nil, c.createGrantErrs[c.createGrantCall-1]
+	}
+	return &kms.CreateGrantOutput{GrantId: aws.String("grant-1")}, nil
+}
+
+func (c *mockKMSClient) ListGrantsPagesWithContext(ctx aws.Context, input *kms.ListGrantsInput, fn func(*kms.ListGrantsResponse, bool) bool, opts ...request.Option) error {
+	fn(&kms.ListGrantsResponse{Grants: c.grants}, true)
+	return nil
+}
+
+func (c *mockKMSClient) RetireGrantWithContext(ctx aws.Context, input *kms.RetireGrantInput, opts ...request.Option) (*kms.RetireGrantOutput, error) {
+	c.retired = append(c.retired, aws.StringValue(input.GrantId))
+	return &kms.RetireGrantOutput{}, nil
+}
+
+func testRetryPolicy() awsretry.Policy {
+	return awsretry.Policy{Config: awsretry.Config{MaxAttempts: 3, InitialDelay: 1, MaxDelay: 1}}
+}
+
+func TestCreate(t *testing.T) {
+	invalidArn := awserr.New(kms.ErrCodeInvalidArnException, "invalid principal", nil)
+	testCases := map[string]struct {
+		client    *mockKMSClient
+		expectErr string
+		expectRun int
+	}{
+		"creates grant": {
+			client:    &mockKMSClient{},
+			expectRun: 1,
+		},
+		"retries unknown principal": {
+			client:    &mockKMSClient{createGrantErrs: []error{invalidArn}},
+			expectRun: 2,
+		},
+		"gives up on unknown principal": {
+			client:    &mockKMSClient{createGrantErrs: []error{invalidArn, invalidArn, invalidArn}},
+			expectErr: "InvalidArnException: invalid principal",
+			expectRun: 3,
+		},
+		"key not found": {
+			client:    &mockKMSClient{describeKeyErr: awserr.New(kms.ErrCodeNotFoundException, "no key", errors.New("fail"))},
+			expectErr: "NotFoundException: no key",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			grants := NewKMSGrants(tc.client, lager.NewLogger("awskms-test"), testRetryPolicy())
+			err := grants.Create(context.Background(), "alias/bucket-key", "arn:aws:iam::123456789012:user/cf-binding", "cf+binding")
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.client.createGrantCall != tc.expectRun {
+				t.Errorf("expected %d CreateGrant calls, got %d", tc.expectRun, tc.client.createGrantCall)
+			}
+			if tc.expectErr == "" {
+				input := tc.client.createGrant
+				if aws.StringValue(input.KeyId) != testKeyARN {
+					t.Errorf("expected key %s, got %s", testKeyARN, aws.StringValue(input.KeyId))
+				}
+				if aws.StringValue(input.Name) != "cf-binding" {
+					t.Errorf("expected grant name cf-binding, got %s", aws.StringValue(input.Name))
+				}
+				if !slices.Equal(aws.StringValueSlice(input.Operations), GrantOperations) {
+					t.Errorf("expected operations %v, got %v", GrantOperations, aws.StringValueSlice(input.Operations))
+				}
+			}
+		})
+	}
+}
+
+func TestRetire(t *testing.T) {
+	testCases := map[string]struct {
+		client        *mockKMSClient
+		expectRetired []string
+	}{
+		"retires named grants": {
+			client: &mockKMSClient{grants: []*kms.GrantListEntry{
+				{GrantId: aws.String("grant-1"), Name: aws.String("cf-binding")},
+				{GrantId: aws.String("grant-2"), Name: aws.String("cf-other")},
+				{GrantId: aws.String("grant-3"), Name: aws.String("cf-binding")},
+			}},
+			expectRetired: []string{"grant-1", "grant-3"},
+		},
+		"key not found": {
+			client: &mockKMSClient{describeKeyErr: awserr.New(kms.ErrCodeNotFoundException, "no key", errors.New("fail"))},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			grants := NewKMSGrants(tc.client, lager.NewLogger("awskms-test"), testRetryPolicy())
+			if err := grants.Retire(context.Background(), "alias/bucket-key", "cf-binding"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !slices.Equal(tc.client.retired, tc.expectRetired) {
+				t.Errorf("expected retired grants %v, got %v", tc.expectRetired, tc.client.retired)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package awsmetrics
+
+import (
+	"context"
+	"fmt"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// alarmStorageTypes are the storage types whose BucketSizeBytes a storage
+// alarm sums. An alarm may have at most ten metrics and expressions, so the
+// small overheads that some storage classes report separately are left out.
+var alarmStorageTypes = []string{
+	"StandardStorage",
+	"IntelligentTieringFAStorage",
+	"IntelligentTieringIAStorage",
+	"IntelligentTieringAIAStorage",
+	"StandardIAStorage",
+	"OneZoneIAStorage",
+	"GlacierInstantRetrievalStorage",
+	"GlacierStorage",
+	"DeepArchiveStorage",
+}
+
+type AlarmClient interface {
+	PutMetricAlarmWithContext(ctx aws.Context, input *cloudwatch.PutMetricAlarmInput, opts ...request.Option) (*cloudwatch.PutMetricAlarmOutput, error)
+	DeleteAlarmsWithContext(ctx aws.Context, input *cloudwatch.DeleteAlarmsInput, opts ...request.Option) (*cloudwatch.DeleteAlarmsOutput, error)
+}
+
+// StorageAlarm is an alarm on the size of a bucket.
+type StorageAlarm struct {
+	Name        string
+	Description string
+	BucketName  string
+	// ThresholdBytes is the size above which the alarm fires.
+	ThresholdBytes float64
+}
+
+// StorageAlarms manages CloudWatch alarms on the storage metrics that S3
+// reports for buckets, which notify an SNS topic when they fire.
+type StorageAlarms struct {
+	client   AlarmClient
+	topicARN string
+	retry    awsretry.Policy
+	logger   lager.Logger
+}
+
+func NewStorageAlarms(client AlarmClient, topicARN string, logger lager.Logger, retry awsretry.Policy) *StorageAlarms {
+	return &StorageAlarms{
+		client:   client,
+		topicARN: topicARN,
+		retry:    retry,
+		logger:   logger.Session("s3-storage-alarms"),
+	}
+}
+
+// PutStorageAlarm creates the alarm, or replaces the alarm of the same name.
+// The alarm sums BucketSizeBytes over the storage types, filling in zero for
+// those the bucket does not use, and treats a bucket that S3 has not reported
+// yet as not breaching.
+func (a *StorageAlarms) PutStorageAlarm(ctx context.Context, alarm StorageAlarm) error {
+	metrics := []*cloudwatch.MetricDataQuery{{
+		Id:         aws.String("total"),
+		Expression: aws.String("SUM(FILL(METRICS(), 0))"),
+		Label:      aws.String("BucketSizeBytes"),
+		ReturnData: aws.Bool(true),
+	}}
+	for i, storageType := range alarmStorageTypes {
+		metrics = append(metrics, &cloudwatch.MetricDataQuery{
+			Id: aws.String(fmt.Sprintf("m%d", i)),
+			MetricStat: &cloudwatch.MetricStat{
+				Metric: &cloudwatch.Metric{
+					Namespace:  aws.String("AWS/S3"),
+					MetricName: aws.String("BucketSizeBytes"),
+					Dimensions: []*cloudwatch.Dimension{
+						{Name: aws.String("BucketName"), Value: aws.String(alarm.BucketName)},
+						{Name: aws.String("StorageType"), Value: aws.String(storageType)},
+					},
+				},
+				Period: aws.Int64(storageMetricsPeriod),
+				Stat:   aws.String(cloudwatch.StatisticAverage),
+			},
+			ReturnData: aws.Bool(false),
+		})
+	}
+
+	_, err := awsretry.Call(ctx, a.retry.For("PutMetricAlarm"), func() (*cloudwatch.PutMetricAlarmOutput, error) {
+		return a.client.PutMetricAlarmWithContext(ctx, &cloudwatch.PutMetricAlarmInput{
+			AlarmName:          aws.String(alarm.Name),
+			AlarmDescription:   aws.String(alarm.Description),
+			ActionsEnabled:     aws.Bool(true),
+			AlarmActions:       []*string{aws.String(a.topicARN)},
+			OKActions:          []*string{aws.String(a.topicARN)},
+			ComparisonOperator: aws.String(cloudwatch.ComparisonOperatorGreaterThanThreshold),
+			Threshold:          aws.Float64(alarm.ThresholdBytes),
+			EvaluationPeriods:  aws.Int64(1),
+			DatapointsToAlarm:  aws.Int64(1),
+			TreatMissingData:   aws.String("notBreaching"),
+			Metrics:            metrics,
+		})
+	})
+	if err != nil {
+		a.logger.Error("put-metric-alarm", err, lager.Data{"alarm": alarm.Name, "bucket": alarm.BucketName})
+		return err
+	}
+	return nil
+}
+
+// DeleteStorageAlarm deletes the alarm named name. Alarms that do not exist
+// are already deleted.
+func (a *StorageAlarms) DeleteStorageAlarm(ctx context.Context, name string) error {
+	_, err := awsretry.Call(ctx, a.retry.For("DeleteAlarms"), func() (*cloudwatch.DeleteAlarmsOutput, error) {
+		return a.client.DeleteAlarmsWithContext(ctx, &cloudwatch.DeleteAlarmsInput{
+			AlarmNames: []*string{aws.String(name)},
+		})
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatch.ErrCodeResourceNotFound {
+		return nil
+	}
+	if err != nil {
+		a.logger.Error("delete-alarms", err, lager.Data{"alarm": name})
+		return err
+	}
+	return nil
+}
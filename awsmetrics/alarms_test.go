@@ -0,0 +1,100 @@
+package awsmetrics
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type mockAlarmClient struct {
+	putInput    *cloudwatch.PutMetricAlarmInput
+	deleteInput *cloudwatch.DeleteAlarmsInput
+	deleteErr   error
+}
+
+func (c *mockAlarmClient) PutMetricAlarmWithContext(ctx aws.Context, input *cloudwatch.PutMetricAlarmInput, opts ...request.Option) (*cloudwatch.PutMetricAlarmOutput, error) {
+	c.putInput = input
+	return &cloudwatch.PutMetricAlarmOutput{}, nil
+}
+
+func (c *mockAlarmClient) DeleteAlarmsWithContext(ctx aws.Context, input *cloudwatch.DeleteAlarmsInput, opts ...request.Option) (*cloudwatch.DeleteAlarmsOutput, error) {
+	c.deleteInput = input
+	return &cloudwatch.DeleteAlarmsOutput{}, c.deleteErr
+}
+
+func TestPutStorageAlarm(t *testing.T) {
+	client := &mockAlarmClient{}
+	alarms := NewStorageAlarms(client, "arn:aws:sns:us-east-1:123456789012:alerts", lager.NewLogger("awsmetrics-test"), awsretry.Policy{})
+
+	err := alarms.PutStorageAlarm(context.Background(), StorageAlarm{
+		Name:           "s3-broker-instance1",
+		Description:    "Bucket bucket-1 is larger than 5 GB",
+		BucketName:     "bucket-1",
+		ThresholdBytes: 5 << 30,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	input := client.putInput
+	if aws.StringValue(input.AlarmName) != "s3-broker-instance1" || aws.Float64Value(input.Threshold) != 5<<30 {
+		t.Errorf("unexpected alarm %s with threshold %v", aws.StringValue(input.AlarmName), aws.Float64Value(input.Threshold))
+	}
+	if actions := aws.StringValueSlice(input.AlarmActions); len(actions) != 1 || actions[0] != "arn:aws:sns:us-east-1:123456789012:alerts" {
+		t.Errorf("expected the alarm to notify the topic, got %v", actions)
+	}
+	// Alarms may have at most ten metrics and expressions, of which only the
+	// sum is returned.
+	if len(input.Metrics) > 10 {
+		t.Errorf("expected at most 10 metrics, got %d", len(input.Metrics))
+	}
+	returned := 0
+	for _, metric := range input.Metrics {
+		if aws.BoolValue(metric.ReturnData) {
+			returned++
+			continue
+		}
+		dimensions := metric.MetricStat.Metric.Dimensions
+		if aws.StringValue(dimensions[0].Value) != "bucket-1" {
+			t.Errorf("expected metric %s to be of bucket-1, got %s", aws.StringValue(metric.Id), aws.StringValue(dimensions[0].Value))
+		}
+	}
+	if returned != 1 {
+		t.Errorf("expected one metric to be returned, got %d", returned)
+	}
+}
+
+func TestDeleteStorageAlarm(t *testing.T) {
+	testCases := map[string]struct {
+		deleteErr error
+		expectErr bool
+	}{
+		"deleted":   {},
+		"not found": {deleteErr: awserr.New(cloudwatch.ErrCodeResourceNotFound, "not found", nil)},
+		"denied": {
+			deleteErr: awserr.New("AccessDenied", "denied", nil),
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &mockAlarmClient{deleteErr: tc.deleteErr}
+			alarms := NewStorageAlarms(client, "arn:aws:sns:us-east-1:123456789012:alerts", lager.NewLogger("awsmetrics-test"), awsretry.Policy{})
+
+			err := alarms.DeleteStorageAlarm(context.Background(), "s3-broker-instance1")
+			if (err != nil) != tc.expectErr {
+				t.Errorf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if names := aws.StringValueSlice(client.deleteInput.AlarmNames); len(names) != 1 || names[0] != "s3-broker-instance1" {
+				t.Errorf("unexpected alarm names %v", names)
+			}
+		})
+	}
+}
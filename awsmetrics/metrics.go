@@ -0,0 +1,175 @@
+// Package awsmetrics puts the broker's operational metrics to CloudWatch,
+// reads the storage metrics that S3 reports there for buckets, and alarms on
+// them.
+package awsmetrics
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// maxMetricsPerPut is the most metrics that PutMetricData takes at once.
+const maxMetricsPerPut = 1000
+
+type CloudWatchClient interface {
+	PutMetricDataWithContext(ctx aws.Context, input *cloudwatch.PutMetricDataInput, opts ...request.Option) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// Recorder records metrics, each with dimensions that tell its series apart.
+type Recorder interface {
+	// Count adds one to a count.
+	Count(name string, dimensions map[string]string)
+	// Gauge sets a measurement, in unit, such as cloudwatch.StandardUnitPercent.
+	Gauge(name string, value float64, unit string, dimensions map[string]string)
+}
+
+// series is a metric with its dimensions.
+type series struct {
+	name string
+	// dimensions are the dimensions as name=value pairs, sorted and joined
+	// with newlines, so that series can be map keys.
+	dimensions string
+}
+
+// statistic aggregates the values of a series since the last put.
+type statistic struct {
+	unit                 string
+	count, sum, min, max float64
+}
+
+// CloudWatchRecorder aggregates metrics in memory and puts them to a
+// CloudWatch namespace every interval, so that a busy broker makes one
+// PutMetricData call an interval rather than one a metric.
+type CloudWatchRecorder struct {
+	mu         sync.Mutex
+	client     CloudWatchClient
+	namespace  string
+	dimensions map[string]string
+	interval   time.Duration
+	statistics map[series]*statistic
+	retry      awsretry.Policy
+	logger     lager.Logger
+	now        func() time.Time
+}
+
+// NewCloudWatchRecorder returns a recorder that adds dimensions to every
+// metric, such as the broker's environment.
+func NewCloudWatchRecorder(client CloudWatchClient, namespace string, dimensions map[string]string, interval time.Duration, logger lager.Logger, retry awsretry.Policy) *CloudWatchRecorder {
+	return &CloudWatchRecorder{
+		client:     client,
+		namespace:  namespace,
+		dimensions: dimensions,
+		interval:   interval,
+		statistics: make(map[series]*statistic),
+		retry:      retry,
+		logger:     logger.Session("cloudwatch-metrics"),
+		now:        time.Now,
+	}
+}
+
+func (r *CloudWatchRecorder) Count(name string, dimensions map[string]string) {
+	r.record(name, 1, cloudwatch.StandardUnitCount, dimensions)
+}
+
+func (r *CloudWatchRecorder) Gauge(name string, value float64, unit string, dimensions map[string]string) {
+	r.record(name, value, unit, dimensions)
+}
+
+func (r *CloudWatchRecorder) record(name string, value float64, unit string, dimensions map[string]string) {
+	all := maps.Clone(r.dimensions)
+	if all == nil {
+		all = make(map[string]string, len(dimensions))
+	}
+	maps.Copy(all, dimensions)
+	var pairs []string
+	for _, key := range slices.Sorted(maps.Keys(all)) {
+		pairs = append(pairs, key+"="+all[key])
+	}
+	key := series{name: name, dimensions: strings.Join(pairs, "\n")}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stat, ok := r.statistics[key]
+	if !ok {
+		r.statistics[key] = &statistic{unit: unit, count: 1, sum: value, min: value, max: value}
+		return
+	}
+	stat.count++
+	stat.sum += value
+	stat.min = min(stat.min, value)
+	stat.max = max(stat.max, value)
+}
+
+// Run puts the recorded metrics every interval until ctx is done. Metrics
+// recorded after that are put by a last call to Flush.
+func (r *CloudWatchRecorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				r.logger.Error("flush", err)
+			}
+		}
+	}
+}
+
+// Flush puts the metrics recorded since the last Flush. Metrics that cannot
+// be put are dropped, so that a CloudWatch outage does not grow the broker's
+// memory.
+func (r *CloudWatchRecorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	statistics := r.statistics
+	r.statistics = make(map[series]*statistic)
+	r.mu.Unlock()
+
+	timestamp := r.now()
+	var data []*cloudwatch.MetricDatum
+	for key, stat := range statistics {
+		datum := &cloudwatch.MetricDatum{
+			MetricName: aws.String(key.name),
+			Timestamp:  aws.Time(timestamp),
+			Unit:       aws.String(stat.unit),
+			StatisticValues: &cloudwatch.StatisticSet{
+				SampleCount: aws.Float64(stat.count),
+				Sum:         aws.Float64(stat.sum),
+				Minimum:     aws.Float64(stat.min),
+				Maximum:     aws.Float64(stat.max),
+			},
+		}
+		if key.dimensions != "" {
+			for _, pair := range strings.Split(key.dimensions, "\n") {
+				name, value, _ := strings.Cut(pair, "=")
+				datum.Dimensions = append(datum.Dimensions, &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(value)})
+			}
+		}
+		data = append(data, datum)
+	}
+
+	for batch := range slices.Chunk(data, maxMetricsPerPut) {
+		_, err := awsretry.Call(ctx, r.retry.For("PutMetricData"), func() (*cloudwatch.PutMetricDataOutput, error) {
+			return r.client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+				Namespace:  aws.String(r.namespace),
+				MetricData: batch,
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
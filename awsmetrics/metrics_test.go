@@ -0,0 +1,100 @@
+package awsmetrics
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type mockCloudWatchClient struct {
+	inputs []*cloudwatch.PutMetricDataInput
+}
+
+func (c *mockCloudWatchClient) PutMetricDataWithContext(ctx aws.Context, input *cloudwatch.PutMetricDataInput, opts ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	c.inputs = append(c.inputs, input)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestFlush(t *testing.T) {
+	client := &mockCloudWatchClient{}
+	recorder := NewCloudWatchRecorder(client, "S3Broker", map[string]string{"Environment": "production"}, time.Minute, lager.NewLogger("awsmetrics-test"), awsretry.Policy{})
+	timestamp := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return timestamp }
+
+	recorder.Count("Operations", map[string]string{"Action": "provision"})
+	recorder.Count("Operations", map[string]string{"Action": "provision"})
+	recorder.Gauge("QuotaUtilization", 40, cloudwatch.StandardUnitPercent, map[string]string{"Quota": "broker"})
+	recorder.Gauge("QuotaUtilization", 60, cloudwatch.StandardUnitPercent, map[string]string{"Quota": "broker"})
+	if err := recorder.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(client.inputs) != 1 {
+		t.Fatalf("expected one PutMetricData call, got %d", len(client.inputs))
+	}
+	if namespace := aws.StringValue(client.inputs[0].Namespace); namespace != "S3Broker" {
+		t.Errorf("expected namespace S3Broker, got %s", namespace)
+	}
+	got := make(map[string]*cloudwatch.MetricDatum)
+	for _, datum := range client.inputs[0].MetricData {
+		got[aws.StringValue(datum.MetricName)] = datum
+	}
+	expect := map[string]*cloudwatch.MetricDatum{
+		"Operations": {
+			MetricName: aws.String("Operations"),
+			Timestamp:  aws.Time(timestamp),
+			Unit:       aws.String(cloudwatch.StandardUnitCount),
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("Action"), Value: aws.String("provision")},
+				{Name: aws.String("Environment"), Value: aws.String("production")},
+			},
+			StatisticValues: &cloudwatch.StatisticSet{SampleCount: aws.Float64(2), Sum: aws.Float64(2), Minimum: aws.Float64(1), Maximum: aws.Float64(1)},
+		},
+		"QuotaUtilization": {
+			MetricName: aws.String("QuotaUtilization"),
+			Timestamp:  aws.Time(timestamp),
+			Unit:       aws.String(cloudwatch.StandardUnitPercent),
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("Environment"), Value: aws.String("production")},
+				{Name: aws.String("Quota"), Value: aws.String("broker")},
+			},
+			StatisticValues: &cloudwatch.StatisticSet{SampleCount: aws.Float64(2), Sum: aws.Float64(100), Minimum: aws.Float64(40), Maximum: aws.Float64(60)},
+		},
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("unexpected metric data (-want +got):\n%s", diff)
+	}
+
+	if err := recorder.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(client.inputs) != 1 {
+		t.Errorf("expected no PutMetricData call without new metrics, got %d calls", len(client.inputs))
+	}
+}
+
+func TestFlushBatches(t *testing.T) {
+	client := &mockCloudWatchClient{}
+	recorder := NewCloudWatchRecorder(client, "S3Broker", nil, time.Minute, lager.NewLogger("awsmetrics-test"), awsretry.Policy{})
+	for i := range maxMetricsPerPut + 1 {
+		recorder.Count("Operations", map[string]string{"Index": strconv.Itoa(i)})
+	}
+	if err := recorder.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(client.inputs) != 2 {
+		t.Fatalf("expected two PutMetricData calls, got %d", len(client.inputs))
+	}
+	if n := len(client.inputs[0].MetricData) + len(client.inputs[1].MetricData); n != maxMetricsPerPut+1 {
+		t.Errorf("expected %d metrics, got %d", maxMetricsPerPut+1, n)
+	}
+}
@@ -0,0 +1,126 @@
+package awsmetrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+const (
+	// storageMetricsPeriod is the period of S3's storage metrics, which it
+	// reports once a day.
+	storageMetricsPeriod = 24 * 60 * 60
+	// storageMetricsLookback is how far back BucketStorage looks for them,
+	// since S3 reports them a day or more late.
+	storageMetricsLookback = 3 * 24 * time.Hour
+)
+
+// ErrNoStorageMetrics is returned for buckets that S3 has reported no storage
+// metrics for lately, such as buckets created since its last report.
+var ErrNoStorageMetrics = errors.New("S3 has not reported storage metrics for the bucket")
+
+type StorageClient interface {
+	GetMetricDataWithContext(ctx aws.Context, input *cloudwatch.GetMetricDataInput, opts ...request.Option) (*cloudwatch.GetMetricDataOutput, error)
+}
+
+// BucketStorage is the storage that S3 reported for a bucket at Time.
+type BucketStorage struct {
+	// Bytes is the size of the bucket's objects in every storage class,
+	// noncurrent versions included.
+	Bytes   int64
+	Objects int64
+	Time    time.Time
+}
+
+// StorageReader reads the storage metrics that S3 reports to CloudWatch for
+// every bucket, free of charge, so that the broker need not list buckets to
+// measure them.
+type StorageReader struct {
+	client StorageClient
+	retry  awsretry.Policy
+	logger lager.Logger
+	now    func() time.Time
+}
+
+func NewStorageReader(client StorageClient, logger lager.Logger, retry awsretry.Policy) *StorageReader {
+	return &StorageReader{
+		client: client,
+		retry:  retry,
+		logger: logger.Session("s3-storage-metrics"),
+		now:    time.Now,
+	}
+}
+
+// BucketStorage returns the storage that S3 last reported for bucketName.
+// BucketSizeBytes is reported for each storage class, so it is summed over
+// all of them with a search expression.
+func (r *StorageReader) BucketStorage(ctx context.Context, bucketName string) (BucketStorage, error) {
+	now := r.now()
+	output, err := awsretry.Call(ctx, r.retry.For("GetMetricData"), func() (*cloudwatch.GetMetricDataOutput, error) {
+		return r.client.GetMetricDataWithContext(ctx, &cloudwatch.GetMetricDataInput{
+			StartTime: aws.Time(now.Add(-storageMetricsLookback)),
+			EndTime:   aws.Time(now),
+			ScanBy:    aws.String(cloudwatch.ScanByTimestampDescending),
+			MetricDataQueries: []*cloudwatch.MetricDataQuery{
+				{
+					Id: aws.String("bytes"),
+					Expression: aws.String(fmt.Sprintf(
+						`SUM(SEARCH('{AWS/S3,BucketName,StorageType} MetricName="BucketSizeBytes" BucketName="%s"', 'Average', %d))`,
+						bucketName, storageMetricsPeriod,
+					)),
+				},
+				{
+					Id: aws.String("objects"),
+					MetricStat: &cloudwatch.MetricStat{
+						Metric: &cloudwatch.Metric{
+							Namespace:  aws.String("AWS/S3"),
+							MetricName: aws.String("NumberOfObjects"),
+							Dimensions: []*cloudwatch.Dimension{
+								{Name: aws.String("BucketName"), Value: aws.String(bucketName)},
+								{Name: aws.String("StorageType"), Value: aws.String("AllStorageTypes")},
+							},
+						},
+						Period: aws.Int64(storageMetricsPeriod),
+						Stat:   aws.String(cloudwatch.StatisticAverage),
+					},
+				},
+			},
+		})
+	})
+	if err != nil {
+		r.logger.Error("get-metric-data", err, lager.Data{"bucket": bucketName})
+		return BucketStorage{}, err
+	}
+
+	var storage BucketStorage
+	found := false
+	for _, result := range output.MetricDataResults {
+		if len(result.Values) == 0 {
+			continue
+		}
+		// Results are newest first.
+		value := int64(aws.Float64Value(result.Values[0]))
+		switch aws.StringValue(result.Id) {
+		case "bytes":
+			storage.Bytes = value
+		case "objects":
+			storage.Objects = value
+		}
+		if timestamp := aws.TimeValue(result.Timestamps[0]); timestamp.After(storage.Time) {
+			storage.Time = timestamp
+		}
+		found = true
+	}
+	if !found {
+		return BucketStorage{}, ErrNoStorageMetrics
+	}
+	return storage, nil
+}
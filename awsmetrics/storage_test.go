@@ -0,0 +1,82 @@
+package awsmetrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type mockStorageClient struct {
+	input   *cloudwatch.GetMetricDataInput
+	results []*cloudwatch.MetricDataResult
+}
+
+func (c *mockStorageClient) GetMetricDataWithContext(ctx aws.Context, input *cloudwatch.GetMetricDataInput, opts ...request.Option) (*cloudwatch.GetMetricDataOutput, error) {
+	c.input = input
+	return &cloudwatch.GetMetricDataOutput{MetricDataResults: c.results}, nil
+}
+
+func TestBucketStorage(t *testing.T) {
+	now := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-36 * time.Hour)
+	earlier := now.Add(-60 * time.Hour)
+
+	testCases := map[string]struct {
+		results       []*cloudwatch.MetricDataResult
+		expectStorage BucketStorage
+		expectErr     error
+	}{
+		"latest": {
+			results: []*cloudwatch.MetricDataResult{
+				{Id: aws.String("bytes"), Values: aws.Float64Slice([]float64{4096, 2048}), Timestamps: aws.TimeSlice([]time.Time{yesterday, earlier})},
+				{Id: aws.String("objects"), Values: aws.Float64Slice([]float64{3, 2}), Timestamps: aws.TimeSlice([]time.Time{yesterday, earlier})},
+			},
+			expectStorage: BucketStorage{Bytes: 4096, Objects: 3, Time: yesterday},
+		},
+		"only objects": {
+			results: []*cloudwatch.MetricDataResult{
+				{Id: aws.String("bytes")},
+				{Id: aws.String("objects"), Values: aws.Float64Slice([]float64{0}), Timestamps: aws.TimeSlice([]time.Time{earlier})},
+			},
+			expectStorage: BucketStorage{Time: earlier},
+		},
+		"not reported": {
+			results: []*cloudwatch.MetricDataResult{
+				{Id: aws.String("bytes")},
+				{Id: aws.String("objects")},
+			},
+			expectErr: ErrNoStorageMetrics,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &mockStorageClient{results: tc.results}
+			reader := NewStorageReader(client, lager.NewLogger("awsmetrics-test"), awsretry.Policy{})
+			reader.now = func() time.Time { return now }
+
+			storage, err := reader.BucketStorage(context.Background(), "bucket-1")
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("expected %v, got %v", tc.expectErr, err)
+			}
+			if diff := cmp.Diff(tc.expectStorage, storage); diff != "" {
+				t.Errorf("unexpected storage (-want +got):\n%s", diff)
+			}
+			if expression := aws.StringValue(client.input.MetricDataQueries[0].Expression); !strings.Contains(expression, `BucketName="bucket-1"`) {
+				t.Errorf("expected the search to name the bucket, got %s", expression)
+			}
+			if start := aws.TimeValue(client.input.StartTime); !start.Equal(now.Add(-storageMetricsLookback)) {
+				t.Errorf("unexpected start time %s", start)
+			}
+		})
+	}
+}
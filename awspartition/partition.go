@@ -0,0 +1,119 @@
+// Package awspartition describes the AWS partitions that the broker can run
+// in: their regions, the domains of their service endpoints, and which S3
+// endpoint variants they have.
+package awspartition
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Partition is a group of AWS regions with its own ARNs and endpoint domain.
+type Partition struct {
+	// ID is the partition's name in ARNs, such as "aws" or "aws-us-gov".
+	ID string
+	// DNSSuffix is the domain of the partition's service endpoints.
+	DNSSuffix string
+
+	regions     *regexp.Regexp
+	fipsRegions *regexp.Regexp
+	dualStack   bool
+}
+
+var partitions = []Partition{
+	{
+		ID:          "aws",
+		DNSSuffix:   "amazonaws.com",
+		regions:     regexp.MustCompile(`^(us|eu|ap|sa|ca|me|af|il|mx)-\w+-\d+$`),
+		fipsRegions: regexp.MustCompile(`^(us|ca)-\w+-\d+$`),
+		dualStack:   true,
+	},
+	{
+		ID:          "aws-us-gov",
+		DNSSuffix:   "amazonaws.com",
+		regions:     regexp.MustCompile(`^us-gov-\w+-\d+$`),
+		fipsRegions: regexp.MustCompile(`^us-gov-\w+-\d+$`),
+		dualStack:   true,
+	},
+	{
+		ID:        "aws-cn",
+		DNSSuffix: "amazonaws.com.cn",
+		regions:   regexp.MustCompile(`^cn-\w+-\d+$`),
+		dualStack: true,
+	},
+	{
+		ID:        "aws-iso",
+		DNSSuffix: "c2s.ic.gov",
+		regions:   regexp.MustCompile(`^us-iso-\w+-\d+$`),
+	},
+	{
+		ID:        "aws-iso-b",
+		DNSSuffix: "sc2s.sgov.gov",
+		regions:   regexp.MustCompile(`^us-isob-\w+-\d+$`),
+	},
+}
+
+// Lookup returns the partition called id. Unknown partitions are returned
+// with the commercial partition's endpoint domain and no FIPS or dual-stack
+// endpoints, and ok is false.
+func Lookup(id string) (partition Partition, ok bool) {
+	for _, partition := range partitions {
+		if partition.ID == id {
+			return partition, true
+		}
+	}
+	return Partition{ID: id, DNSSuffix: "amazonaws.com"}, false
+}
+
+// HasRegion reports whether region is in the partition.
+func (p Partition) HasRegion(region string) bool {
+	return p.regions != nil && p.regions.MatchString(region)
+}
+
+// HasFIPS reports whether S3 has FIPS endpoints in region.
+func (p Partition) HasFIPS(region string) bool {
+	return p.fipsRegions != nil && p.fipsRegions.MatchString(region)
+}
+
+// HasDualStack reports whether S3 has dual-stack endpoints in the partition.
+func (p Partition) HasDualStack() bool {
+	return p.dualStack
+}
+
+// ARN returns the ARN of a resource of service. Region and accountID are
+// left empty for resources that have none, such as S3 buckets.
+func (p Partition) ARN(service, region, accountID, resource string) string {
+	return fmt.Sprintf("arn:%s:%s:%s:%s:%s", p.ID, service, region, accountID, resource)
+}
+
+// S3Endpoint returns the host of S3's endpoint in region.
+func (p Partition) S3Endpoint(region string) string {
+	return fmt.Sprintf("s3.%s.%s", region, p.DNSSuffix)
+}
+
+// S3FIPSEndpoint returns the host of S3's FIPS endpoint in region, or an
+// empty string if the region has none.
+func (p Partition) S3FIPSEndpoint(region string) string {
+	if !p.HasFIPS(region) {
+		return ""
+	}
+	return fmt.Sprintf("s3-fips.%s.%s", region, p.DNSSuffix)
+}
+
+// S3DualStackEndpoint returns the host of S3's dual-stack endpoint in
+// region, or an empty string if the partition has none.
+func (p Partition) S3DualStackEndpoint(region string) string {
+	if !p.dualStack {
+		return ""
+	}
+	return fmt.Sprintf("s3.dualstack.%s.%s", region, p.DNSSuffix)
+}
+
+// S3FIPSDualStackEndpoint returns the host of S3's dual-stack FIPS endpoint
+// in region, or an empty string if the region has none.
+func (p Partition) S3FIPSDualStackEndpoint(region string) string {
+	if !p.dualStack || !p.HasFIPS(region) {
+		return ""
+	}
+	return fmt.Sprintf("s3-fips.dualstack.%s.%s", region, p.DNSSuffix)
+}
@@ -0,0 +1,84 @@
+package awspartition
+
+import "testing"
+
+func TestHasRegion(t *testing.T) {
+	testCases := map[string]string{
+		"us-east-1":      "aws",
+		"eu-central-2":   "aws",
+		"us-gov-west-1":  "aws-us-gov",
+		"cn-northwest-1": "aws-cn",
+		"us-iso-east-1":  "aws-iso",
+		"us-isob-east-1": "aws-iso-b",
+	}
+	for region, expected := range testCases {
+		t.Run(region, func(t *testing.T) {
+			for _, partition := range partitions {
+				if partition.HasRegion(region) != (partition.ID == expected) {
+					t.Errorf("expected %s to be in partition %s only, HasRegion is %t for %s", region, expected, partition.HasRegion(region), partition.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestS3Endpoints(t *testing.T) {
+	testCases := map[string]struct {
+		partition string
+		region    string
+		regional  string
+		fips      string
+		dualStack string
+	}{
+		"commercial": {
+			partition: "aws",
+			region:    "us-west-2",
+			regional:  "s3.us-west-2.amazonaws.com",
+			fips:      "s3-fips.us-west-2.amazonaws.com",
+			dualStack: "s3.dualstack.us-west-2.amazonaws.com",
+		},
+		"commercial without FIPS": {
+			partition: "aws",
+			region:    "ap-southeast-2",
+			regional:  "s3.ap-southeast-2.amazonaws.com",
+			dualStack: "s3.dualstack.ap-southeast-2.amazonaws.com",
+		},
+		"govcloud": {
+			partition: "aws-us-gov",
+			region:    "us-gov-east-1",
+			regional:  "s3.us-gov-east-1.amazonaws.com",
+			fips:      "s3-fips.us-gov-east-1.amazonaws.com",
+			dualStack: "s3.dualstack.us-gov-east-1.amazonaws.com",
+		},
+		"china": {
+			partition: "aws-cn",
+			region:    "cn-north-1",
+			regional:  "s3.cn-north-1.amazonaws.com.cn",
+			dualStack: "s3.dualstack.cn-north-1.amazonaws.com.cn",
+		},
+		"iso": {
+			partition: "aws-iso",
+			region:    "us-iso-east-1",
+			regional:  "s3.us-iso-east-1.c2s.ic.gov",
+		},
+		"unknown": {
+			partition: "aws-new",
+			region:    "us-east-1",
+			regional:  "s3.us-east-1.amazonaws.com",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			partition, _ := Lookup(tc.partition)
+			if got := partition.S3Endpoint(tc.region); got != tc.regional {
+				t.Errorf("expected regional endpoint %q, got %q", tc.regional, got)
+			}
+			if got := partition.S3FIPSEndpoint(tc.region); got != tc.fips {
+				t.Errorf("expected FIPS endpoint %q, got %q", tc.fips, got)
+			}
+			if got := partition.S3DualStackEndpoint(tc.region); got != tc.dualStack {
+				t.Errorf("expected dual-stack endpoint %q, got %q", tc.dualStack, got)
+			}
+		})
+	}
+}
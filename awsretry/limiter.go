@@ -0,0 +1,183 @@
+package awsretry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// throttleDecrease is the factor that the rate is cut by when AWS
+	// throttles a call, as in the AWS SDKs' adaptive retry mode.
+	throttleDecrease = 0.7
+	// throttleCooldown is how long after a cut the rate is not cut again, so
+	// that the calls throttled together cut it once.
+	throttleCooldown = time.Second
+	defaultRecovery  = time.Minute
+)
+
+// RateLimitConfig paces the broker's AWS calls, to stay within the account's
+// API quotas, which its clusters and other tools share. The rate is cut when
+// AWS throttles a call and recovers while it does not.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the most calls a second, which the rate recovers
+	// to. Zero disables rate limiting.
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// MinRequestsPerSecond is the least that throttling cuts the rate to.
+	// Defaults to a tenth of RequestsPerSecond.
+	MinRequestsPerSecond float64 `yaml:"min_requests_per_second"`
+	// Recovery is how long the rate takes to climb from MinRequestsPerSecond
+	// to RequestsPerSecond. Defaults to a minute.
+	Recovery time.Duration `yaml:"recovery"`
+}
+
+func (c RateLimitConfig) Enabled() bool {
+	return c.RequestsPerSecond > 0
+}
+
+func (c RateLimitConfig) Validate() error {
+	if c.RequestsPerSecond < 0 || c.MinRequestsPerSecond < 0 || c.Recovery < 0 {
+		return errors.New("RateLimit must not be negative")
+	}
+	if c.MinRequestsPerSecond > c.RequestsPerSecond {
+		return errors.New("RateLimit MinRequestsPerSecond must not be more than RequestsPerSecond")
+	}
+	return nil
+}
+
+// Limiter is a token bucket whose rate adapts to throttling. Half of its
+// bucket is kept for calls that are not low priority, so that deprovisions and
+// background jobs cannot take all of the calls that provisions need.
+type Limiter struct {
+	mu           sync.Mutex
+	maxRate      float64
+	minRate      float64
+	increase     float64
+	burst        float64
+	rate         float64
+	tokens       float64
+	updated      time.Time
+	lastThrottle time.Time
+	now          func() time.Time
+}
+
+func NewLimiter(config RateLimitConfig) *Limiter {
+	minRate := config.MinRequestsPerSecond
+	if minRate == 0 {
+		minRate = config.RequestsPerSecond / 10
+	}
+	recovery := config.Recovery
+	if recovery == 0 {
+		recovery = defaultRecovery
+	}
+	// Low priority calls need a token beyond the reserved half, so the
+	// bucket holds at least two.
+	burst := math.Max(2, math.Ceil(config.RequestsPerSecond))
+	return &Limiter{
+		maxRate:  config.RequestsPerSecond,
+		minRate:  minRate,
+		increase: (config.RequestsPerSecond - minRate) / recovery.Seconds(),
+		burst:    burst,
+		rate:     config.RequestsPerSecond,
+		tokens:   burst,
+		updated:  time.Now(),
+		now:      time.Now,
+	}
+}
+
+// limiter paces Do's attempts if it is set.
+var limiter atomic.Pointer[Limiter]
+
+// SetLimiter makes every call that Do makes wait for l. A nil l stops
+// limiting.
+func SetLimiter(l *Limiter) {
+	limiter.Store(l)
+}
+
+// Rate returns the calls a second that l allows now.
+func (l *Limiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(l.now())
+	return l.rate
+}
+
+// Wait waits until l allows a call, or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, lowPriority bool) error {
+	for {
+		wait := l.reserve(lowPriority)
+		if wait == 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token, or returns how long until there is one for a call of
+// the priority.
+func (l *Limiter) reserve(lowPriority bool) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(l.now())
+	needed := 1.0
+	if lowPriority {
+		needed += l.burst / 2
+	}
+	if l.tokens < needed {
+		return time.Duration((needed - l.tokens) / l.rate * float64(time.Second))
+	}
+	l.tokens--
+	return 0
+}
+
+// Throttled cuts the rate, and empties the bucket so that the calls waiting
+// for it are spread out at the new rate.
+func (l *Limiter) Throttled() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.refill(now)
+	if now.Sub(l.lastThrottle) < throttleCooldown {
+		return
+	}
+	l.lastThrottle = now
+	l.rate = math.Max(l.minRate, l.rate*throttleDecrease)
+	l.tokens = math.Min(l.tokens, 0)
+}
+
+// refill raises the rate towards the maximum and adds the tokens earned since
+// the last refill.
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.updated).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	l.updated = now
+	l.rate = math.Min(l.maxRate, l.rate+l.increase*elapsed)
+	l.tokens = math.Min(l.burst, l.tokens+l.rate*elapsed)
+}
+
+type lowPriorityKey struct{}
+
+// WithLowPriority marks the calls made with ctx as low priority, so that they
+// wait while the limiter's reserve is in use. Calls of operations whose
+// names begin with Delete are low priority too, since the IAM clients do not
+// take a ctx.
+func WithLowPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, lowPriorityKey{}, true)
+}
+
+func isLowPriority(ctx context.Context, operation string) bool {
+	low, _ := ctx.Value(lowPriorityKey{}).(bool)
+	return low || strings.HasPrefix(operation, "Delete")
+}
@@ -0,0 +1,115 @@
+package awsretry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(config RateLimitConfig) (*Limiter, *time.Time) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(config)
+	l.now = func() time.Time { return now }
+	l.updated = now
+	return l, &now
+}
+
+func TestLimiterReserve(t *testing.T) {
+	testCases := map[string]struct {
+		tokens      float64
+		lowPriority bool
+		expectWait  time.Duration
+	}{
+		"full bucket": {
+			tokens: 10,
+		},
+		"full bucket, low priority": {
+			tokens:      10,
+			lowPriority: true,
+		},
+		"reserve left": {
+			tokens: 3,
+		},
+		"reserve left, low priority": {
+			tokens:      3,
+			lowPriority: true,
+			expectWait:  300 * time.Millisecond,
+		},
+		"empty bucket": {
+			tokens:     0,
+			expectWait: 100 * time.Millisecond,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			l, _ := newTestLimiter(RateLimitConfig{RequestsPerSecond: 10})
+			l.tokens = tc.tokens
+			if wait := l.reserve(tc.lowPriority); wait != tc.expectWait {
+				t.Errorf("expected to wait %s, got %s", tc.expectWait, wait)
+			}
+		})
+	}
+}
+
+func TestLimiterThrottled(t *testing.T) {
+	l, now := newTestLimiter(RateLimitConfig{RequestsPerSecond: 10, MinRequestsPerSecond: 4, Recovery: 6 * time.Second})
+
+	l.Throttled()
+	if rate := l.Rate(); rate != 7 {
+		t.Errorf("expected the rate to be cut to 7, got %v", rate)
+	}
+	l.Throttled()
+	if rate := l.Rate(); rate != 7 {
+		t.Errorf("expected a second throttle at once not to cut the rate, got %v", rate)
+	}
+
+	// The rate recovers between throttles, so it takes three more to reach
+	// the minimum.
+	for range 3 {
+		*now = now.Add(throttleCooldown)
+		l.Throttled()
+	}
+	if rate := l.Rate(); rate != 4 {
+		t.Errorf("expected the rate to be cut to the minimum of 4, got %v", rate)
+	}
+
+	*now = now.Add(3 * time.Second)
+	if rate := l.Rate(); rate != 7 {
+		t.Errorf("expected the rate to recover to 7, got %v", rate)
+	}
+	*now = now.Add(time.Hour)
+	if rate := l.Rate(); rate != 10 {
+		t.Errorf("expected the rate to recover to 10, got %v", rate)
+	}
+}
+
+func TestDoWaitsForLimiter(t *testing.T) {
+	l, _ := newTestLimiter(RateLimitConfig{RequestsPerSecond: 1})
+	l.tokens = 0
+	SetLimiter(l)
+	defer SetLimiter(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	attempts, err := Do(ctx, testConfig, Never, func() error {
+		calls++
+		return nil
+	})
+	if err != context.Canceled || attempts != 0 || calls != 0 {
+		t.Fatalf("expected no attempts and context.Canceled, got %d attempts and %v", attempts, err)
+	}
+}
+
+func TestIsLowPriority(t *testing.T) {
+	ctx := context.Background()
+	if isLowPriority(ctx, "CreateBucket") {
+		t.Error("expected CreateBucket not to be low priority")
+	}
+	if !isLowPriority(ctx, "DeleteBucket") {
+		t.Error("expected DeleteBucket to be low priority")
+	}
+	if !isLowPriority(WithLowPriority(ctx), "ListBuckets") {
+		t.Error("expected calls with a low priority ctx to be low priority")
+	}
+}
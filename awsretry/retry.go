@@ -0,0 +1,214 @@
+// Package awsretry provides the retry and backoff policy shared by the
+// broker's AWS clients.
+package awsretry
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Config controls how a single AWS operation is retried. Zero values select
+// the defaults.
+type Config struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int `yaml:"max_attempts"`
+	// InitialDelay is the upper bound of the first backoff interval.
+	InitialDelay time.Duration `yaml:"initial_delay"`
+	// MaxDelay caps the backoff interval between attempts.
+	MaxDelay time.Duration `yaml:"max_delay"`
+	// MaxElapsed is the deadline for all attempts together.
+	MaxElapsed time.Duration `yaml:"max_elapsed"`
+	// DisableThrottleRetries stops throttling errors (SlowDown, Throttling,
+	// RequestLimitExceeded, ...) from being retried.
+	DisableThrottleRetries bool `yaml:"disable_throttle_retries"`
+	// Operation is the name of the operation that For made the Config for,
+	// which OnExhausted reports. It is not configured.
+	Operation string `yaml:"-"`
+}
+
+// Policy is the default retry configuration plus per-operation overrides,
+// keyed by AWS API operation name (e.g. "PutBucketPolicy", "CreateUser").
+type Policy struct {
+	Config     `yaml:",inline"`
+	Operations map[string]Config `yaml:"operations,omitempty"`
+	RateLimit  RateLimitConfig   `yaml:"rate_limit,omitempty"`
+}
+
+const (
+	defaultMaxAttempts  = 11
+	defaultInitialDelay = 100 * time.Millisecond
+	defaultMaxDelay     = 5 * time.Second
+	defaultMaxElapsed   = 60 * time.Second
+)
+
+// For returns the configuration for an operation: the operation's overrides
+// on top of the policy default, with remaining zero values defaulted.
+func (p Policy) For(operation string) Config {
+	config := p.Config
+	if override, ok := p.Operations[operation]; ok {
+		if override.MaxAttempts > 0 {
+			config.MaxAttempts = override.MaxAttempts
+		}
+		if override.InitialDelay > 0 {
+			config.InitialDelay = override.InitialDelay
+		}
+		if override.MaxDelay > 0 {
+			config.MaxDelay = override.MaxDelay
+		}
+		if override.MaxElapsed > 0 {
+			config.MaxElapsed = override.MaxElapsed
+		}
+		if override.DisableThrottleRetries {
+			config.DisableThrottleRetries = true
+		}
+	}
+	config.Operation = operation
+	return config.WithDefaults()
+}
+
+// WithDefaults fills in zero values with the package defaults.
+func (c Config) WithDefaults() Config {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultMaxAttempts
+	}
+	if c.InitialDelay <= 0 {
+		c.InitialDelay = defaultInitialDelay
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = defaultMaxDelay
+	}
+	if c.MaxElapsed <= 0 {
+		c.MaxElapsed = defaultMaxElapsed
+	}
+	return c
+}
+
+// delay returns a randomized wait before the given retry (starting at 1),
+// using exponential backoff with full jitter.
+func (c Config) delay(retry int) time.Duration {
+	ceiling := c.InitialDelay << (retry - 1)
+	if ceiling <= 0 || ceiling > c.MaxDelay {
+		ceiling = c.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Never is a retryable func that rejects every error, so that only
+// throttling errors are retried.
+func Never(error) bool {
+	return false
+}
+
+// exhaustedFunc is called by Do when retries are exhausted.
+var exhaustedFunc atomic.Pointer[func(operation string)]
+
+// OnExhausted sets fn to be called with the operation's name whenever an
+// operation fails with an error that would have been retried but for running
+// out of attempts or time, so that exhausted retries can be counted. Configs
+// that For did not make have an empty name.
+func OnExhausted(fn func(operation string)) {
+	exhaustedFunc.Store(&fn)
+}
+
+// throttledFunc is called by Do when AWS throttles a call.
+var throttledFunc atomic.Pointer[func(operation string)]
+
+// OnThrottled sets fn to be called with the operation's name whenever AWS
+// throttles a call, whether or not it is retried, so that throttling can be
+// counted per API.
+func OnThrottled(fn func(operation string)) {
+	throttledFunc.Store(&fn)
+}
+
+// Do calls fn until it succeeds, returns an error that is neither accepted by
+// retryable nor a throttling error, runs out of attempts, or ctx is done. It
+// returns the number of attempts made and the last error from fn. Each
+// attempt first waits for the limiter, if one is set.
+func Do(ctx context.Context, config Config, retryable func(error) bool, fn func() error) (int, error) {
+	config = config.WithDefaults()
+	ctx, cancel := context.WithTimeout(ctx, config.MaxElapsed)
+	defer cancel()
+
+	attempts := 0
+	var err error
+	for {
+		if l := limiter.Load(); l != nil {
+			if waitErr := l.Wait(ctx, isLowPriority(ctx, config.Operation)); waitErr != nil {
+				if err == nil {
+					err = waitErr
+				}
+				if waitErr == context.DeadlineExceeded && attempts > 0 {
+					config.exhausted()
+				}
+				return attempts, err
+			}
+		}
+		attempts++
+		err = fn()
+		if err == nil {
+			return attempts, nil
+		}
+		if isThrottle(err) {
+			config.throttled()
+		}
+		if !retryable(err) && (config.DisableThrottleRetries || !isThrottle(err)) {
+			return attempts, err
+		}
+		if attempts >= config.MaxAttempts {
+			config.exhausted()
+			return attempts, err
+		}
+
+		timer := time.NewTimer(config.delay(attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if ctx.Err() == context.DeadlineExceeded {
+				config.exhausted()
+			}
+			return attempts, err
+		case <-timer.C:
+		}
+	}
+}
+
+func (c Config) exhausted() {
+	if fn := exhaustedFunc.Load(); fn != nil && *fn != nil {
+		(*fn)(c.Operation)
+	}
+}
+
+func (c Config) throttled() {
+	if l := limiter.Load(); l != nil {
+		l.Throttled()
+	}
+	if fn := throttledFunc.Load(); fn != nil && *fn != nil {
+		(*fn)(c.Operation)
+	}
+}
+
+// throttleErrorCodes recognizes throttling errors from aws-sdk-go-v2 clients.
+var throttleErrorCodes = retry.ThrottleErrorCode{Codes: retry.DefaultThrottleErrorCodes}
+
+// isThrottle reports whether err is a throttling error from either version of
+// the AWS SDK.
+func isThrottle(err error) bool {
+	return request.IsErrorThrottle(err) || throttleErrorCodes.IsErrorThrottle(err).Bool()
+}
+
+// Call invokes fn under config, retrying only throttling errors, and returns
+// its result. It is the wrapper used for plain AWS API calls.
+func Call[T any](ctx context.Context, config Config, fn func() (T, error)) (T, error) {
+	var out T
+	_, err := Do(ctx, config, Never, func() error {
+		var err error
+		out, err = fn()
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,197 @@
+package awsretry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/smithy-go"
+)
+
+var testConfig = Config{
+	InitialDelay: time.Millisecond,
+	MaxDelay:     time.Millisecond,
+}
+
+func TestDo(t *testing.T) {
+	retryableErr := errors.New("retryable")
+	fatalErr := errors.New("fatal")
+	throttleErr := awserr.New("Throttling", "rate exceeded", nil)
+	slowDownErr := &smithy.GenericAPIError{Code: "SlowDown", Message: "please reduce your request rate"}
+	isRetryable := func(err error) bool { return err == retryableErr }
+
+	testCases := map[string]struct {
+		config          Config
+		errs            []error
+		expectAttempts  int
+		expectErr       error
+		expectExhausted bool
+	}{
+		"succeeds first time": {
+			config:         testConfig,
+			expectAttempts: 1,
+		},
+		"succeeds after retries": {
+			config:         testConfig,
+			errs:           []error{retryableErr, retryableErr},
+			expectAttempts: 3,
+		},
+		"retries throttling errors": {
+			config:         testConfig,
+			errs:           []error{throttleErr, retryableErr},
+			expectAttempts: 3,
+		},
+		"retries aws-sdk-go-v2 throttling errors": {
+			config:         testConfig,
+			errs:           []error{slowDownErr, retryableErr},
+			expectAttempts: 3,
+		},
+		"throttle retries disabled": {
+			config: Config{
+				InitialDelay:           time.Millisecond,
+				MaxDelay:               time.Millisecond,
+				DisableThrottleRetries: true,
+			},
+			errs:           []error{throttleErr},
+			expectAttempts: 1,
+			expectErr:      throttleErr,
+		},
+		"stops on non-retryable error": {
+			config:         testConfig,
+			errs:           []error{retryableErr, fatalErr, retryableErr},
+			expectAttempts: 2,
+			expectErr:      fatalErr,
+		},
+		"stops at max attempts": {
+			config: Config{
+				MaxAttempts:  3,
+				InitialDelay: time.Millisecond,
+				MaxDelay:     time.Millisecond,
+			},
+			errs:            []error{retryableErr, retryableErr, retryableErr, retryableErr},
+			expectAttempts:  3,
+			expectErr:       retryableErr,
+			expectExhausted: true,
+		},
+		"stops at max elapsed": {
+			config: Config{
+				MaxAttempts:  100,
+				InitialDelay: time.Second,
+				MaxDelay:     time.Second,
+				MaxElapsed:   time.Millisecond,
+			},
+			errs:            []error{retryableErr, retryableErr, retryableErr},
+			expectErr:       retryableErr,
+			expectExhausted: true,
+		},
+	}
+
+	var exhausted []string
+	OnExhausted(func(operation string) { exhausted = append(exhausted, operation) })
+	defer OnExhausted(nil)
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			exhausted = nil
+			calls := 0
+			attempts, err := Do(context.Background(), test.config, isRetryable, func() error {
+				calls++
+				if calls <= len(test.errs) {
+					return test.errs[calls-1]
+				}
+				return nil
+			})
+			if err != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if test.expectAttempts != 0 && attempts != test.expectAttempts {
+				t.Errorf("expected %d attempts, got %d", test.expectAttempts, attempts)
+			}
+			if attempts != calls {
+				t.Errorf("reported %d attempts but fn was called %d times", attempts, calls)
+			}
+			if (len(exhausted) > 0) != test.expectExhausted {
+				t.Errorf("expected exhausted retries to be reported: %t, got %v", test.expectExhausted, exhausted)
+			}
+		})
+	}
+}
+
+func TestOnExhaustedOperation(t *testing.T) {
+	var exhausted []string
+	OnExhausted(func(operation string) { exhausted = append(exhausted, operation) })
+	defer OnExhausted(nil)
+
+	config := Policy{Config: Config{MaxAttempts: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}}.For("PutBucketPolicy")
+	Call(context.Background(), config, func() (any, error) {
+		return nil, awserr.New("Throttling", "rate exceeded", nil)
+	})
+	if len(exhausted) != 1 || exhausted[0] != "PutBucketPolicy" {
+		t.Errorf("expected PutBucketPolicy to be reported, got %v", exhausted)
+	}
+}
+
+func TestOnThrottledOperation(t *testing.T) {
+	var throttled []string
+	OnThrottled(func(operation string) { throttled = append(throttled, operation) })
+	defer OnThrottled(nil)
+
+	config := Policy{Config: testConfig}.For("CreateUser")
+	calls := 0
+	Call(context.Background(), config, func() (any, error) {
+		calls++
+		if calls <= 2 {
+			return nil, awserr.New("Throttling", "rate exceeded", nil)
+		}
+		return nil, nil
+	})
+	if len(throttled) != 2 || throttled[0] != "CreateUser" {
+		t.Errorf("expected CreateUser to be reported twice, got %v", throttled)
+	}
+}
+
+func TestDoHonorsCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	retryableErr := errors.New("retryable")
+	attempts, err := Do(ctx, Config{InitialDelay: time.Hour, MaxDelay: time.Hour}, func(error) bool { return true }, func() error {
+		return retryableErr
+	})
+	if err != retryableErr || attempts != 1 {
+		t.Fatalf("expected one attempt and the last error, got %d attempts and %v", attempts, err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	config := Config{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}.WithDefaults()
+	for retry := 1; retry <= 64; retry++ {
+		delay := config.delay(retry)
+		if delay < 0 || delay > config.MaxDelay {
+			t.Fatalf("delay %v for retry %d is outside [0, %v]", delay, retry, config.MaxDelay)
+		}
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	policy := Policy{
+		Config: Config{MaxAttempts: 5, MaxElapsed: time.Minute},
+		Operations: map[string]Config{
+			"PutBucketPolicy": {MaxAttempts: 20, DisableThrottleRetries: true},
+		},
+	}
+
+	config := policy.For("PutBucketPolicy")
+	if config.MaxAttempts != 20 || config.MaxElapsed != time.Minute || !config.DisableThrottleRetries {
+		t.Errorf("expected override merged onto default, got %+v", config)
+	}
+	if config.InitialDelay != defaultInitialDelay {
+		t.Errorf("expected default initial delay, got %v", config.InitialDelay)
+	}
+
+	config = policy.For("CreateUser")
+	if config.MaxAttempts != 5 || config.DisableThrottleRetries {
+		t.Errorf("expected policy default, got %+v", config)
+	}
+}
@@ -1,14 +1,32 @@
 package awss3
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 )
 
 type Bucket interface {
-	Describe(bucketName, partition string) (BucketDetails, error)
-	Create(bucketName string, details BucketDetails) (string, error)
-	Modify(bucketName string, details BucketDetails) error
-	Delete(bucketName string, deleteObjects bool) error
+	Describe(ctx context.Context, bucketName, partition string) (BucketDetails, error)
+	DescribeConfiguration(ctx context.Context, bucketName, partition string) (BucketDetails, error)
+	Create(ctx context.Context, bucketName string, details BucketDetails) (string, error)
+	Modify(ctx context.Context, bucketName string, details BucketDetails) error
+	Delete(ctx context.Context, bucketName string, deleteObjects bool) error
+	DeleteWithProgress(ctx context.Context, bucketName string, progress func(DeleteProgress)) error
+	Adopt(ctx context.Context, bucketName string, details BucketDetails) error
+	Release(ctx context.Context, bucketName string) error
+	FindAdopted(ctx context.Context, instanceID string) (string, error)
+	AddPolicyStatements(ctx context.Context, bucketName string, statements []PolicyStatement) error
+	RemovePolicyStatements(ctx context.Context, bucketName string, sids []string) error
+	PresignObjectURL(bucketName, key, method string, ttl time.Duration) (string, error)
+	Usage(ctx context.Context, bucketName string) (BucketUsage, error)
+	Tags(ctx context.Context, bucketName string) (map[string]string, error)
+	AddTags(ctx context.Context, bucketName string, tags map[string]string) error
+	Retain(ctx context.Context, bucketName string, tags map[string]string) error
+	CountBuckets(ctx context.Context, tags map[string][]string) (int, error)
+	FindBuckets(ctx context.Context, tags map[string][]string) (map[string]map[string]string, error)
+	CreateFolder(ctx context.Context, bucketName, prefix string) error
 }
 
 type BucketDetails struct {
@@ -19,10 +37,81 @@ type BucketDetails struct {
 	Encryption      string
 	AwsPartition    string
 	Tags            map[string]string
-	FIPSEndpoint    string
 	ObjectOwnership string
+	Versioning      bool
+
+	// CORSRules and LifecycleRules replace the bucket's CORS and lifecycle
+	// configuration. Nil leaves the configuration as it is, and an empty
+	// slice removes it.
+	CORSRules      []CORSRule
+	LifecycleRules []LifecycleRule
+
+	// Endpoints of the bucket's region, and the bucket's own URLs, as
+	// returned by Describe. Buckets in S3-compatible stores have only a
+	// regional endpoint.
+	RegionalEndpoint      string
+	DualStackEndpoint     string
+	FIPSEndpoint          string
+	FIPSDualStackEndpoint string
+	VirtualHostedURL      string
+	DualStackURL          string
+	URI                   string
+}
+
+// CORSRule allows browsers on AllowedOrigins to make cross-origin requests
+// to the bucket.
+type CORSRule struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods"`
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+	ExposeHeaders  []string `json:"expose_headers,omitempty"`
+	MaxAgeSeconds  int64    `json:"max_age_seconds,omitempty"`
+}
+
+// LifecycleRule expires objects under Prefix, or the whole bucket if Prefix
+// is empty, a number of days after they were written. Zero days disables an
+// action.
+type LifecycleRule struct {
+	ID                                 string `json:"id"`
+	Prefix                             string `json:"prefix,omitempty"`
+	ExpirationDays                     int64  `json:"expiration_days,omitempty"`
+	NoncurrentVersionExpirationDays    int64  `json:"noncurrent_version_expiration_days,omitempty"`
+	AbortIncompleteMultipartUploadDays int64  `json:"abort_incomplete_multipart_upload_days,omitempty"`
+}
+
+// DeleteProgress reports how many of a bucket's objects have been deleted.
+// Total is counted before deletion starts, so objects written meanwhile can
+// make Deleted exceed it. If Truncated is set, counting stopped early and the
+// bucket held more than Total objects.
+type DeleteProgress struct {
+	Deleted   int64
+	Total     int64
+	Truncated bool
 }
 
 var (
-	ErrBucketDoesNotExist = errors.New("s3 bucket does not exist")
+	// Deprecated: use ErrBucketNotFound.
+	ErrBucketDoesNotExist = ErrBucketNotFound
+	ErrBucketNotEmpty     = errors.New("s3 bucket is not empty")
 )
+
+// BucketNotEmptyError is returned by Delete when objects must be removed
+// before the bucket can be deleted. It matches ErrBucketNotEmpty with errors.Is.
+// If Truncated is set, counting stopped early and the bucket holds more than
+// ObjectCount objects.
+type BucketNotEmptyError struct {
+	BucketName  string
+	ObjectCount int64
+	Truncated   bool
+}
+
+func (e *BucketNotEmptyError) Error() string {
+	if e.Truncated {
+		return fmt.Sprintf("s3 bucket %s is not empty: it contains more than %d objects", e.BucketName, e.ObjectCount)
+	}
+	return fmt.Sprintf("s3 bucket %s is not empty: it contains %d objects", e.BucketName, e.ObjectCount)
+}
+
+func (e *BucketNotEmptyError) Is(target error) bool {
+	return target == ErrBucketNotEmpty
+}
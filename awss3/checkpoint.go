@@ -0,0 +1,150 @@
+package awss3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+// CreateStep identifies one of the AWS calls that make up bucket creation.
+type CreateStep string
+
+const (
+	CreateStepCreateBucket      CreateStep = "create-bucket"
+	CreateStepWaitBucketExists  CreateStep = "wait-bucket-exists"
+	CreateStepTagging           CreateStep = "put-bucket-tagging"
+	CreateStepEncryption        CreateStep = "put-bucket-encryption"
+	CreateStepVersioning        CreateStep = "put-bucket-versioning"
+	CreateStepCORS              CreateStep = "put-bucket-cors"
+	CreateStepLifecycle         CreateStep = "put-bucket-lifecycle"
+	CreateStepPublicAccessBlock CreateStep = "delete-public-access-block"
+	CreateStepPolicy            CreateStep = "put-bucket-policy"
+)
+
+// createSteps lists the steps of Create in the order they run.
+var createSteps = []CreateStep{
+	CreateStepCreateBucket,
+	CreateStepWaitBucketExists,
+	CreateStepTagging,
+	CreateStepEncryption,
+	CreateStepVersioning,
+	CreateStepCORS,
+	CreateStepLifecycle,
+	CreateStepPublicAccessBlock,
+	CreateStepPolicy,
+}
+
+// CheckpointStore records the last completed step of Create for each bucket,
+// so that a retried provision resumes where the previous attempt failed.
+type CheckpointStore interface {
+	GetCheckpoint(bucketName string) (CreateStep, error)
+	SaveCheckpoint(bucketName string, step CreateStep) error
+	DeleteCheckpoint(bucketName string) error
+}
+
+// CreateStepError reports the step at which Create failed, and whether the
+// partially configured bucket was deleted again. Orphaned is set if deleting
+// it failed, so that the bucket is left behind until the instance is deleted.
+type CreateStepError struct {
+	BucketName string
+	Step       CreateStep
+	Err        error
+	RolledBack bool
+	Orphaned   bool
+}
+
+func (e *CreateStepError) Error() string {
+	if e.RolledBack {
+		return fmt.Sprintf("creating bucket %s failed at step %s and was rolled back: %s", e.BucketName, e.Step, e.Err)
+	}
+	return fmt.Sprintf("creating bucket %s failed at step %s: %s", e.BucketName, e.Step, e.Err)
+}
+
+func (e *CreateStepError) Unwrap() error {
+	return e.Err
+}
+
+// MemoryCheckpointStore keeps checkpoints in process memory. Checkpoints are
+// lost on restart, in which case Create starts over and adopts the bucket.
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]CreateStep
+}
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{
+		checkpoints: make(map[string]CreateStep),
+	}
+}
+
+func (m *MemoryCheckpointStore) GetCheckpoint(bucketName string) (CreateStep, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checkpoints[bucketName], nil
+}
+
+func (m *MemoryCheckpointStore) SaveCheckpoint(bucketName string, step CreateStep) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkpoints[bucketName] = step
+	return nil
+}
+
+func (m *MemoryCheckpointStore) DeleteCheckpoint(bucketName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.checkpoints, bucketName)
+	return nil
+}
+
+// checkpointRecordKind is the kind of the state records that hold
+// checkpoints, keyed by bucket name.
+const checkpointRecordKind = "create-checkpoint"
+
+// StateCheckpointStore keeps checkpoints in a state store, so a provision
+// retried after a restart, or by another broker that shares the store,
+// resumes where the previous attempt failed.
+type StateCheckpointStore struct {
+	store state.Store
+}
+
+func NewStateCheckpointStore(store state.Store) *StateCheckpointStore {
+	return &StateCheckpointStore{store: store}
+}
+
+func (s *StateCheckpointStore) GetCheckpoint(bucketName string) (CreateStep, error) {
+	record, err := s.store.GetRecord(context.Background(), checkpointRecordKind, bucketName)
+	if errors.Is(err, state.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var step CreateStep
+	if err := json.Unmarshal(record.Data, &step); err != nil {
+		return "", err
+	}
+	return step, nil
+}
+
+func (s *StateCheckpointStore) SaveCheckpoint(bucketName string, step CreateStep) error {
+	data, err := json.Marshal(step)
+	if err != nil {
+		return err
+	}
+	return s.store.SaveRecord(context.Background(), state.Record{
+		Kind:      checkpointRecordKind,
+		Key:       bucketName,
+		Data:      data,
+		UpdatedAt: time.Now(),
+	})
+}
+
+func (s *StateCheckpointStore) DeleteCheckpoint(bucketName string) error {
+	return s.store.DeleteRecord(context.Background(), checkpointRecordKind, bucketName)
+}
@@ -0,0 +1,75 @@
+package awss3
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	taggingtypes "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+)
+
+var ErrCountingDisabled = errors.New("finding buckets by tag requires a tagging client")
+
+// CountBuckets returns how many buckets have all of the tag keys in tags,
+// with one of the key's values if any are given. The tagging API takes a
+// while to see new tags, so buckets created moments ago may not be counted.
+func (s *S3Bucket) CountBuckets(ctx context.Context, tags map[string][]string) (int, error) {
+	buckets, err := s.FindBuckets(ctx, tags)
+	return len(buckets), err
+}
+
+// FindBuckets returns the tags of the buckets that have all of the tag keys
+// in tags, with one of the key's values if any are given, by bucket name.
+// Buckets are found in every region that they may be in. Like CountBuckets,
+// it may miss buckets tagged moments ago.
+func (s *S3Bucket) FindBuckets(ctx context.Context, tags map[string][]string) (map[string]map[string]string, error) {
+	if s.tagging == nil {
+		return nil, ErrCountingDisabled
+	}
+
+	getResourcesInput := &resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: []string{"s3"},
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		filter := taggingtypes.TagFilter{Key: aws.String(key)}
+		if len(tags[key]) > 0 {
+			filter.Values = tags[key]
+		}
+		getResourcesInput.TagFilters = append(getResourcesInput.TagFilters, filter)
+	}
+	s.logger.Debug("get-resources", lager.Data{"input": getResourcesInput})
+
+	buckets := make(map[string]map[string]string)
+	for _, tagging := range s.taggingClients() {
+		paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(tagging, getResourcesInput)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				s.logger.Error("find-buckets", err)
+				return nil, convertError(err)
+			}
+			for _, mapping := range page.ResourceTagMappingList {
+				// Only find buckets, not access points or other S3 resources.
+				_, name, ok := strings.Cut(aws.ToString(mapping.ResourceARN), ":::")
+				if !ok || strings.Contains(name, "/") {
+					continue
+				}
+				bucketTags := make(map[string]string, len(mapping.Tags))
+				for _, tag := range mapping.Tags {
+					bucketTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+				}
+				buckets[name] = bucketTags
+			}
+		}
+	}
+	return buckets, nil
+}
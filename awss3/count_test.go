@@ -0,0 +1,85 @@
+package awss3
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	taggingtypes "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestCountBuckets(t *testing.T) {
+	tagging := &mockTaggingClient{arns: []string{"arn:aws:s3:::one", "arn:aws:s3:::two", "arn:aws:s3:us-east-1:111111111111:accesspoint/ap"}}
+	bucket := NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), Config{Tagging: tagging})
+	count, err := bucket.CountBuckets(context.Background(), map[string][]string{
+		"Service offering name": {"s3"},
+		"Instance GUID":         nil,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 buckets, got %d", count)
+	}
+	expectFilters := []taggingtypes.TagFilter{
+		{Key: aws.String("Instance GUID")},
+		{Key: aws.String("Service offering name"), Values: []string{"s3"}},
+	}
+	if diff := cmp.Diff(expectFilters, tagging.input.TagFilters, cmpopts.IgnoreUnexported(taggingtypes.TagFilter{})); diff != "" {
+		t.Errorf("unexpected tag filters (-want +got):\n%s", diff)
+	}
+
+	bucket = NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), Config{})
+	if _, err := bucket.CountBuckets(context.Background(), nil); !errors.Is(err, ErrCountingDisabled) {
+		t.Errorf("expected ErrCountingDisabled, got %v", err)
+	}
+}
+
+func TestFindBuckets(t *testing.T) {
+	tagging := &mockTaggingClient{
+		arns: []string{"arn:aws:s3:::one", "arn:aws:s3:us-east-1:111111111111:accesspoint/ap"},
+		tags: map[string]map[string]string{
+			"arn:aws:s3:::one": {"Instance GUID": "instance-1"},
+		},
+	}
+	bucket := NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), Config{Tagging: tagging})
+	buckets, err := bucket.FindBuckets(context.Background(), map[string][]string{"Instance GUID": nil})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectBuckets := map[string]map[string]string{"one": {"Instance GUID": "instance-1"}}
+	if diff := cmp.Diff(expectBuckets, buckets); diff != "" {
+		t.Errorf("unexpected buckets (-want +got):\n%s", diff)
+	}
+}
+
+func TestFindBucketsInRegions(t *testing.T) {
+	tagging := &mockTaggingClient{arns: []string{"arn:aws:s3:::home"}}
+	regionTagging := map[string]*mockTaggingClient{}
+	bucket := NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), Config{
+		Tagging: tagging,
+		Region:  "us-east-1",
+		Regions: []string{"us-east-1", "eu-west-1"},
+		RegionTagging: func(region string) TaggingClient {
+			regionTagging[region] = &mockTaggingClient{arns: []string{"arn:aws:s3:::" + region}}
+			return regionTagging[region]
+		},
+	})
+
+	for range 2 {
+		count, err := bucket.CountBuckets(context.Background(), map[string][]string{"Instance GUID": nil})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if count != 2 {
+			t.Errorf("expected the buckets of both regions to be counted, got %d", count)
+		}
+	}
+	if len(regionTagging) != 1 || regionTagging["eu-west-1"].calls != 2 {
+		t.Errorf("expected one reused tagging client for eu-west-1, got %v", regionTagging)
+	}
+}
@@ -0,0 +1,188 @@
+package awss3
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// DescribeConfiguration returns what Describe does, together with the
+// bucket's current configuration: its tags, encryption, versioning, object
+// ownership, policy, and CORS and lifecycle rules. It makes several more S3
+// calls than Describe, so bindings use Describe.
+func (s *S3Bucket) DescribeConfiguration(ctx context.Context, bucketName, partition string) (BucketDetails, error) {
+	details, err := s.Describe(ctx, bucketName, partition)
+	if err != nil {
+		return BucketDetails{}, err
+	}
+
+	if details.Tags, err = s.getBucketTags(ctx, bucketName); err != nil {
+		return BucketDetails{}, convertError(err)
+	}
+	if details.Encryption, err = s.getBucketEncryption(ctx, bucketName); err != nil {
+		return BucketDetails{}, convertError(err)
+	}
+	if details.Versioning, err = s.getBucketVersioning(ctx, bucketName); err != nil {
+		return BucketDetails{}, convertError(err)
+	}
+	if details.ObjectOwnership, err = s.getBucketOwnership(ctx, bucketName); err != nil {
+		return BucketDetails{}, convertError(err)
+	}
+	if details.CORSRules, err = s.getBucketCORS(ctx, bucketName); err != nil {
+		return BucketDetails{}, convertError(err)
+	}
+	if details.LifecycleRules, err = s.getBucketLifecycle(ctx, bucketName); err != nil {
+		return BucketDetails{}, convertError(err)
+	}
+
+	policy, err := s.getBucketPolicy(ctx, bucketName)
+	if err != nil {
+		return BucketDetails{}, convertError(err)
+	}
+	if len(policyStatements(policy)) > 0 {
+		policyJSON, err := json.Marshal(policy)
+		if err != nil {
+			return BucketDetails{}, err
+		}
+		details.Policy = string(policyJSON)
+	}
+
+	return details, nil
+}
+
+// isNotConfigured reports whether err is the error S3 returns for a bucket
+// setting that was never configured.
+func isNotConfigured(err error, code string) bool {
+	return errorCode(err) == code
+}
+
+// getBucketEncryption returns the bucket's default encryption as JSON, in the
+// form of a plan's encryption property.
+func (s *S3Bucket) getBucketEncryption(ctx context.Context, bucketName string) (string, error) {
+	getEncryptionInput := &s3.GetBucketEncryptionInput{
+		Bucket: aws.String(bucketName),
+	}
+	getEncryptionOutput, err := awsretry.Call(ctx, s.retry.For("GetBucketEncryption"), func() (*s3.GetBucketEncryptionOutput, error) {
+		return s.client(ctx, bucketName).GetBucketEncryption(ctx, getEncryptionInput)
+	})
+	if err != nil {
+		if isNotConfigured(err, "ServerSideEncryptionConfigurationNotFoundError") {
+			return "", nil
+		}
+		s.logger.Error("aws-s3-error", err)
+		return "", err
+	}
+	encryption, err := json.Marshal(getEncryptionOutput.ServerSideEncryptionConfiguration)
+	if err != nil {
+		return "", err
+	}
+	return string(encryption), nil
+}
+
+func (s *S3Bucket) getBucketVersioning(ctx context.Context, bucketName string) (bool, error) {
+	getVersioningInput := &s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+	}
+	getVersioningOutput, err := awsretry.Call(ctx, s.retry.For("GetBucketVersioning"), func() (*s3.GetBucketVersioningOutput, error) {
+		return s.client(ctx, bucketName).GetBucketVersioning(ctx, getVersioningInput)
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return false, err
+	}
+	return getVersioningOutput.Status == types.BucketVersioningStatusEnabled, nil
+}
+
+func (s *S3Bucket) getBucketOwnership(ctx context.Context, bucketName string) (string, error) {
+	getOwnershipInput := &s3.GetBucketOwnershipControlsInput{
+		Bucket: aws.String(bucketName),
+	}
+	getOwnershipOutput, err := awsretry.Call(ctx, s.retry.For("GetBucketOwnershipControls"), func() (*s3.GetBucketOwnershipControlsOutput, error) {
+		return s.client(ctx, bucketName).GetBucketOwnershipControls(ctx, getOwnershipInput)
+	})
+	if err != nil {
+		if isNotConfigured(err, "OwnershipControlsNotFoundError") {
+			return "", nil
+		}
+		s.logger.Error("aws-s3-error", err)
+		return "", err
+	}
+	if controls := getOwnershipOutput.OwnershipControls; controls != nil && len(controls.Rules) > 0 {
+		return string(controls.Rules[0].ObjectOwnership), nil
+	}
+	return "", nil
+}
+
+func (s *S3Bucket) getBucketCORS(ctx context.Context, bucketName string) ([]CORSRule, error) {
+	getCORSInput := &s3.GetBucketCorsInput{
+		Bucket: aws.String(bucketName),
+	}
+	getCORSOutput, err := awsretry.Call(ctx, s.retry.For("GetBucketCors"), func() (*s3.GetBucketCorsOutput, error) {
+		return s.client(ctx, bucketName).GetBucketCors(ctx, getCORSInput)
+	})
+	rules := []CORSRule{}
+	if err != nil {
+		if isNotConfigured(err, "NoSuchCORSConfiguration") {
+			return rules, nil
+		}
+		s.logger.Error("aws-s3-error", err)
+		return nil, err
+	}
+	for _, rule := range getCORSOutput.CORSRules {
+		corsRule := CORSRule{
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			MaxAgeSeconds:  int64(aws.ToInt32(rule.MaxAgeSeconds)),
+		}
+		if len(rule.AllowedHeaders) > 0 {
+			corsRule.AllowedHeaders = rule.AllowedHeaders
+		}
+		if len(rule.ExposeHeaders) > 0 {
+			corsRule.ExposeHeaders = rule.ExposeHeaders
+		}
+		rules = append(rules, corsRule)
+	}
+	return rules, nil
+}
+
+func (s *S3Bucket) getBucketLifecycle(ctx context.Context, bucketName string) ([]LifecycleRule, error) {
+	getLifecycleInput := &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucketName),
+	}
+	getLifecycleOutput, err := awsretry.Call(ctx, s.retry.For("GetBucketLifecycleConfiguration"), func() (*s3.GetBucketLifecycleConfigurationOutput, error) {
+		return s.client(ctx, bucketName).GetBucketLifecycleConfiguration(ctx, getLifecycleInput)
+	})
+	rules := []LifecycleRule{}
+	if err != nil {
+		if isNotConfigured(err, "NoSuchLifecycleConfiguration") {
+			return rules, nil
+		}
+		s.logger.Error("aws-s3-error", err)
+		return nil, err
+	}
+	for _, rule := range getLifecycleOutput.Rules {
+		lifecycleRule := LifecycleRule{
+			ID:     aws.ToString(rule.ID),
+			Prefix: aws.ToString(rule.Prefix),
+		}
+		if rule.Filter != nil && rule.Filter.Prefix != nil {
+			lifecycleRule.Prefix = aws.ToString(rule.Filter.Prefix)
+		}
+		if rule.Expiration != nil {
+			lifecycleRule.ExpirationDays = int64(aws.ToInt32(rule.Expiration.Days))
+		}
+		if rule.NoncurrentVersionExpiration != nil {
+			lifecycleRule.NoncurrentVersionExpirationDays = int64(aws.ToInt32(rule.NoncurrentVersionExpiration.NoncurrentDays))
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			lifecycleRule.AbortIncompleteMultipartUploadDays = int64(aws.ToInt32(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation))
+		}
+		rules = append(rules, lifecycleRule)
+	}
+	return rules, nil
+}
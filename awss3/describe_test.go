@@ -0,0 +1,82 @@
+package awss3
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDescribeConfiguration(t *testing.T) {
+	testCases := map[string]struct {
+		client *MockS3Client
+		expect BucketDetails
+	}{
+		"unconfigured bucket": {
+			client: &MockS3Client{},
+			expect: BucketDetails{
+				Tags:           map[string]string{},
+				CORSRules:      []CORSRule{},
+				LifecycleRules: []LifecycleRule{},
+			},
+		},
+		"configured bucket": {
+			client: &MockS3Client{
+				bucketTags:       map[string]string{"Service plan name": "basic"},
+				versioningStatus: string(types.BucketVersioningStatusEnabled),
+				encryption: &types.ServerSideEncryptionConfiguration{Rules: []types.ServerSideEncryptionRule{{
+					ApplyServerSideEncryptionByDefault: &types.ServerSideEncryptionByDefault{SSEAlgorithm: types.ServerSideEncryptionAes256},
+				}}},
+				objectOwnership: string(types.ObjectOwnershipBucketOwnerEnforced),
+				corsRules: []types.CORSRule{{
+					AllowedOrigins: []string{"https://example.gov"},
+					AllowedMethods: []string{"GET"},
+					MaxAgeSeconds:  aws.Int32(300),
+				}},
+				lifecycleRules: []types.LifecycleRule{{
+					ID:         aws.String("expire"),
+					Filter:     &types.LifecycleRuleFilter{Prefix: aws.String("tmp/")},
+					Expiration: &types.LifecycleExpiration{Days: aws.Int32(30)},
+				}},
+				bucketPolicy: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject"}]}`,
+			},
+			expect: BucketDetails{
+				Tags:            map[string]string{"Service plan name": "basic"},
+				Encryption:      `{"Rules":[{"ApplyServerSideEncryptionByDefault":{"SSEAlgorithm":"AES256","KMSMasterKeyID":null},"BlockedEncryptionTypes":null,"BucketKeyEnabled":null}]}`,
+				Versioning:      true,
+				ObjectOwnership: string(types.ObjectOwnershipBucketOwnerEnforced),
+				CORSRules: []CORSRule{{
+					AllowedOrigins: []string{"https://example.gov"},
+					AllowedMethods: []string{"GET"},
+					MaxAgeSeconds:  300,
+				}},
+				LifecycleRules: []LifecycleRule{{ID: "expire", Prefix: "tmp/", ExpirationDays: 30}},
+				Policy:         `{"Statement":[{"Action":"s3:GetObject","Effect":"Allow","Principal":"*"}],"Version":"2012-10-17"}`,
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := NewS3Bucket(tc.client, lager.NewLogger("test"), Config{})
+			details, err := b.DescribeConfiguration(context.Background(), "b", "aws")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			expected := b.buildBucketDetails("b", "us-east-1", "aws", nil)
+			expected.Tags = tc.expect.Tags
+			expected.Encryption = tc.expect.Encryption
+			expected.Versioning = tc.expect.Versioning
+			expected.ObjectOwnership = tc.expect.ObjectOwnership
+			expected.CORSRules = tc.expect.CORSRules
+			expected.LifecycleRules = tc.expect.LifecycleRules
+			expected.Policy = tc.expect.Policy
+			if diff := cmp.Diff(expected, details); diff != "" {
+				t.Errorf("unexpected details (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
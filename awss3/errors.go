@@ -0,0 +1,84 @@
+package awss3
+
+import (
+	"errors"
+
+	"github.com/aws/smithy-go"
+)
+
+var (
+	ErrBucketNotFound = errors.New("s3 bucket not found")
+	ErrAccessDenied   = errors.New("access to s3 denied")
+	ErrPolicyInvalid  = errors.New("s3 bucket policy is invalid")
+	ErrThrottled      = errors.New("s3 request throttled")
+	// ErrPolicyConflict is returned when other changes to a bucket policy
+	// kept overwriting a change to it.
+	ErrPolicyConflict = errors.New("s3 bucket policy kept being changed by other requests")
+)
+
+// Error is returned for failed S3 API calls. It matches one of the sentinel
+// errors above with errors.Is when the AWS error code is recognized, and
+// unwraps to the original AWS error.
+type Error struct {
+	Code    string
+	Message string
+	Err     error
+	OrigErr error
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	var errs []error
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	if e.OrigErr != nil {
+		errs = append(errs, e.OrigErr)
+	}
+	return errs
+}
+
+var errorCodes = map[string]error{
+	"NoSuchBucket":          ErrBucketNotFound,
+	"AccessDenied":          ErrAccessDenied,
+	"AllAccessDisabled":     ErrAccessDenied,
+	"MalformedPolicy":       ErrPolicyInvalid,
+	"InvalidPolicyDocument": ErrPolicyInvalid,
+	"SlowDown":              ErrThrottled,
+	"Throttling":            ErrThrottled,
+	"ThrottlingException":   ErrThrottled,
+	"RequestLimitExceeded":  ErrThrottled,
+	"TooManyRequests":       ErrThrottled,
+}
+
+// convertError turns AWS API errors into *Error. Other errors, including
+// errors that are already typed, are returned unchanged.
+func convertError(err error) error {
+	var apiErr smithy.APIError
+	if err == nil || !errors.As(err, &apiErr) {
+		return err
+	}
+	var typedErr *Error
+	if errors.As(err, &typedErr) {
+		return err
+	}
+	return &Error{
+		Code:    apiErr.ErrorCode(),
+		Message: apiErr.ErrorMessage(),
+		Err:     errorCodes[apiErr.ErrorCode()],
+		OrigErr: err,
+	}
+}
+
+// errorCode returns the code of the AWS API error in err's chain, or an
+// empty string if there is none.
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
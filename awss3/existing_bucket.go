@@ -0,0 +1,210 @@
+package awss3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	taggingtypes "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// TaggingClient finds adopted buckets by their tags, since the broker keeps
+// no record of which bucket an instance adopted.
+type TaggingClient interface {
+	GetResources(ctx context.Context, input *resourcegroupstaggingapi.GetResourcesInput, optFns ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetResourcesOutput, error)
+}
+
+// AdoptedTagKey marks a bucket that a service instance adopted instead of
+// creating. Such buckets are never deleted by the broker.
+const AdoptedTagKey = "Adopted by broker"
+
+var (
+	ErrBucketNotOwned   = errors.New("s3 bucket is not owned by this account")
+	ErrAdoptionDisabled = errors.New("adopting existing buckets requires a tagging client")
+
+	// brokerTagKeys are removed from an adopted bucket when it is released.
+	brokerTagKeys = []string{
+		AdoptedTagKey,
+		"Created at",
+		"Updated at",
+		brokertags.BrokerTagKey,
+		brokertags.ClientTagKey,
+		brokertags.EnvironmentTagKey,
+		brokertags.OrganizationGUIDTagKey,
+		brokertags.OrganizationNameTagKey,
+		brokertags.ServiceInstanceGUIDTagKey,
+		brokertags.ServiceNameTagKey,
+		brokertags.ServicePlanName,
+		brokertags.SpaceGUIDTagKey,
+		brokertags.SpaceNameTagKey,
+	}
+)
+
+// Adopt takes an existing bucket owned by this account into a service
+// instance by adding bucketDetails.Tags to its tags. Its other configuration
+// is left alone. A bucket that belongs to another instance, whether adopted
+// or created by the broker, cannot be adopted.
+func (s *S3Bucket) Adopt(ctx context.Context, bucketName string, bucketDetails BucketDetails) error {
+	owned, err := s.isOwnedBucket(ctx, bucketName)
+	if err != nil {
+		return convertError(err)
+	}
+	if !owned {
+		return ErrBucketNotOwned
+	}
+
+	tags, err := s.getBucketTags(ctx, bucketName)
+	if err != nil {
+		return convertError(err)
+	}
+
+	instanceGUID := bucketDetails.Tags[brokertags.ServiceInstanceGUIDTagKey]
+	if existing, ok := tags[brokertags.ServiceInstanceGUIDTagKey]; ok && existing != instanceGUID {
+		return fmt.Errorf("bucket %s already belongs to service instance %s", bucketName, existing)
+	}
+
+	for key, value := range bucketDetails.Tags {
+		tags[key] = value
+	}
+	tags[AdoptedTagKey] = "true"
+
+	s.logger.Info("adopt-existing-bucket", lager.Data{"bucket": bucketName, "instance": instanceGUID})
+	if err := s.putBucketTagging(ctx, bucketName, tags); err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+// Release removes the broker's tags from an adopted bucket, returning it to
+// its owner. A bucket that no longer exists is already released.
+func (s *S3Bucket) Release(ctx context.Context, bucketName string) error {
+	tags, err := s.getBucketTags(ctx, bucketName)
+	if err != nil {
+		if isNoSuchBucketError(err) {
+			return nil
+		}
+		return convertError(err)
+	}
+
+	for _, key := range brokerTagKeys {
+		delete(tags, key)
+	}
+
+	s.logger.Info("release-existing-bucket", lager.Data{"bucket": bucketName})
+	if len(tags) > 0 {
+		err = s.putBucketTagging(ctx, bucketName, tags)
+	} else {
+		deleteTaggingInput := &s3.DeleteBucketTaggingInput{
+			Bucket: aws.String(bucketName),
+		}
+		_, err = awsretry.Call(ctx, s.retry.For("DeleteBucketTagging"), func() (*s3.DeleteBucketTaggingOutput, error) {
+			return s.client(ctx, bucketName).DeleteBucketTagging(ctx, deleteTaggingInput)
+		})
+	}
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+// FindAdopted returns the name of the bucket that instanceID adopted, or
+// ErrBucketNotFound. Tags take a while to become visible to the tagging API,
+// so a bucket that is not found is looked up again until the retry policy
+// for GetResources gives up.
+func (s *S3Bucket) FindAdopted(ctx context.Context, instanceID string) (string, error) {
+	if s.tagging == nil {
+		return "", ErrAdoptionDisabled
+	}
+
+	getResourcesInput := &resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: []string{"s3"},
+		TagFilters: []taggingtypes.TagFilter{
+			{Key: aws.String(brokertags.ServiceInstanceGUIDTagKey), Values: []string{instanceID}},
+			{Key: aws.String(AdoptedTagKey), Values: []string{"true"}},
+		},
+	}
+	s.logger.Debug("get-resources", lager.Data{"input": getResourcesInput})
+
+	var bucketName string
+	_, err := awsretry.Do(ctx, s.retry.For("GetResources"), isBucketNotFound, func() error {
+		paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(s.tagging, getResourcesInput)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			for _, mapping := range page.ResourceTagMappingList {
+				// Bucket ARNs have no region or account: arn:aws:s3:::name.
+				if _, name, ok := strings.Cut(aws.ToString(mapping.ResourceARN), ":::"); ok {
+					bucketName = name
+					return nil
+				}
+			}
+		}
+		return ErrBucketNotFound
+	})
+	if err != nil {
+		s.logger.Error("find-adopted-bucket", err, lager.Data{"instance": instanceID})
+		return "", convertError(err)
+	}
+	return bucketName, nil
+}
+
+// isOwnedBucket reports whether this account owns the bucket. ListBuckets
+// only lists the caller's own buckets.
+func (s *S3Bucket) isOwnedBucket(ctx context.Context, bucketName string) (bool, error) {
+	listBucketsOutput, err := awsretry.Call(ctx, s.retry.For("ListBuckets"), func() (*s3.ListBucketsOutput, error) {
+		return s.s3svc.ListBuckets(ctx, &s3.ListBucketsInput{})
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return false, err
+	}
+	for _, bucket := range listBucketsOutput.Buckets {
+		if aws.ToString(bucket.Name) == bucketName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getBucketTags returns the bucket's tags, which are empty if it has none or
+// the store does not support bucket tags.
+func (s *S3Bucket) getBucketTags(ctx context.Context, bucketName string) (map[string]string, error) {
+	if s.skipBucketTagging {
+		return make(map[string]string), nil
+	}
+	getTaggingInput := &s3.GetBucketTaggingInput{
+		Bucket: aws.String(bucketName),
+	}
+	s.logger.Debug("get-bucket-tagging", lager.Data{"input": getTaggingInput})
+	getTaggingOutput, err := awsretry.Call(ctx, s.retry.For("GetBucketTagging"), func() (*s3.GetBucketTaggingOutput, error) {
+		return s.client(ctx, bucketName).GetBucketTagging(ctx, getTaggingInput)
+	})
+	tags := make(map[string]string)
+	if err != nil {
+		if errorCode(err) == "NoSuchTagSet" {
+			return tags, nil
+		}
+		s.logger.Error("aws-s3-error", err)
+		return nil, err
+	}
+	for _, tag := range getTaggingOutput.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+func isBucketNotFound(err error) bool {
+	return err == ErrBucketNotFound
+}
@@ -0,0 +1,171 @@
+package awss3
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	taggingtypes "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type mockTaggingClient struct {
+	arns  []string
+	calls int
+	input *resourcegroupstaggingapi.GetResourcesInput
+	// tags maps ARNs to their tags.
+	tags map[string]map[string]string
+}
+
+func (c *mockTaggingClient) GetResources(ctx context.Context, input *resourcegroupstaggingapi.GetResourcesInput, optFns ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetResourcesOutput, error) {
+	c.calls++
+	c.input = input
+	page := &resourcegroupstaggingapi.GetResourcesOutput{}
+	for _, arn := range c.arns {
+		mapping := taggingtypes.ResourceTagMapping{ResourceARN: aws.String(arn)}
+		for key, value := range c.tags[arn] {
+			mapping.Tags = append(mapping.Tags, taggingtypes.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		page.ResourceTagMappingList = append(page.ResourceTagMappingList, mapping)
+	}
+	return page, nil
+}
+
+func TestAdopt(t *testing.T) {
+	testCases := map[string]struct {
+		client     *MockS3Client
+		expectErr  string
+		expectTags map[string]string
+	}{
+		"adopts owned bucket": {
+			client: &MockS3Client{
+				ownedBuckets: []string{"other", "existing"},
+				bucketTags:   map[string]string{"team": "data"},
+			},
+			expectTags: map[string]string{
+				"team":                               "data",
+				brokertags.ServiceInstanceGUIDTagKey: "instance-1",
+				AdoptedTagKey:                        "true",
+			},
+		},
+		"readopts own bucket": {
+			client: &MockS3Client{
+				ownedBuckets: []string{"existing"},
+				bucketTags:   map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance-1"},
+			},
+			expectTags: map[string]string{
+				brokertags.ServiceInstanceGUIDTagKey: "instance-1",
+				AdoptedTagKey:                        "true",
+			},
+		},
+		"bucket of another account": {
+			client:    &MockS3Client{ownedBuckets: []string{"other"}},
+			expectErr: ErrBucketNotOwned.Error(),
+		},
+		"bucket of another instance": {
+			client: &MockS3Client{
+				ownedBuckets: []string{"existing"},
+				bucketTags:   map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance-2"},
+			},
+			expectErr: "bucket existing already belongs to service instance instance-2",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := NewS3Bucket(tc.client, lager.NewLogger("s3-bucket-test"), Config{Retry: testRetryConfig})
+			err := bucket.Adopt(context.Background(), "existing", BucketDetails{
+				Tags: map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance-1"},
+			})
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				if tc.client.putBucketTagsCalls != 0 {
+					t.Errorf("expected bucket tags to be unchanged")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !maps.Equal(tc.client.putBucketTags, tc.expectTags) {
+				t.Errorf("expected tags %v, got %v", tc.expectTags, tc.client.putBucketTags)
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	testCases := map[string]struct {
+		tags          map[string]string
+		expectTags    map[string]string
+		expectDeleted bool
+	}{
+		"keeps owner tags": {
+			tags: map[string]string{
+				"team":                               "data",
+				brokertags.ServiceInstanceGUIDTagKey: "instance-1",
+				brokertags.BrokerTagKey:              "S3 broker",
+				AdoptedTagKey:                        "true",
+			},
+			expectTags: map[string]string{"team": "data"},
+		},
+		"no owner tags": {
+			tags: map[string]string{
+				brokertags.ServiceInstanceGUIDTagKey: "instance-1",
+				AdoptedTagKey:                        "true",
+			},
+			expectDeleted: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &MockS3Client{bucketTags: tc.tags}
+			bucket := NewS3Bucket(client, lager.NewLogger("s3-bucket-test"), Config{Retry: testRetryConfig})
+			if err := bucket.Release(context.Background(), "existing"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !maps.Equal(client.putBucketTags, tc.expectTags) {
+				t.Errorf("expected tags %v, got %v", tc.expectTags, client.putBucketTags)
+			}
+			if client.bucketTagsDeleted != tc.expectDeleted {
+				t.Errorf("expected tags deleted %t, got %t", tc.expectDeleted, client.bucketTagsDeleted)
+			}
+			if client.deleteBucketCalled {
+				t.Errorf("expected bucket not to be deleted")
+			}
+		})
+	}
+}
+
+func TestFindAdopted(t *testing.T) {
+	retry := awsretry.Policy{Config: awsretry.Config{MaxAttempts: 2, InitialDelay: 1, MaxDelay: 1}}
+
+	tagging := &mockTaggingClient{arns: []string{"arn:aws:s3:::existing"}}
+	bucket := NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), Config{Retry: retry, Tagging: tagging})
+	bucketName, err := bucket.FindAdopted(context.Background(), "instance-1")
+	if err != nil || bucketName != "existing" {
+		t.Errorf("expected existing, got %q, %v", bucketName, err)
+	}
+
+	tagging = &mockTaggingClient{}
+	bucket = NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), Config{Retry: retry, Tagging: tagging})
+	if _, err := bucket.FindAdopted(context.Background(), "instance-1"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+	if tagging.calls != 2 {
+		t.Errorf("expected lookup to be retried, got %d calls", tagging.calls)
+	}
+
+	bucket = NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), Config{Retry: retry})
+	if _, err := bucket.FindAdopted(context.Background(), "instance-1"); err != ErrAdoptionDisabled {
+		t.Errorf("expected ErrAdoptionDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package awss3
+
+import (
+	"context"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// CreateFolder puts a zero-byte object named prefix followed by a slash, which
+// S3 consoles and tools show as an empty folder. An existing folder object is
+// overwritten, so creating a folder again is harmless.
+func (s *S3Bucket) CreateFolder(ctx context.Context, bucketName, prefix string) error {
+	key := strings.TrimSuffix(prefix, "/") + "/"
+	s.logger.Info("create-folder", lager.Data{"bucket": bucketName, "key": key})
+	_, err := s.client(ctx, bucketName).PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(""),
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return convertError(err)
+	}
+	return nil
+}
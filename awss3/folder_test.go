@@ -0,0 +1,32 @@
+package awss3
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCreateFolder(t *testing.T) {
+	testCases := map[string]struct {
+		prefix    string
+		expectKey string
+	}{
+		"prefix":                     {prefix: "my-app", expectKey: "my-app/"},
+		"nested prefix":              {prefix: "apps/my-app", expectKey: "apps/my-app/"},
+		"prefix with trailing slash": {prefix: "my-app/", expectKey: "my-app/"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &MockS3Client{}
+			b := NewS3Bucket(client, lager.NewLogger("test"), Config{})
+			if err := b.CreateFolder(context.Background(), "bucket", tc.prefix); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff([]string{tc.expectKey}, client.putObjects); diff != "" {
+				t.Errorf("unexpected objects (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package awss3
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// Modify applies a plan or parameter change to an existing bucket. Tags are
+// merged into the bucket's current tags. The statements of the plan's bucket
+// policy replace those of the previous plan, while statements with a Sid,
+// which the broker adds on behalf of bindings, are kept. Versioning is
+// suspended if the bucket had it and the plan does not enable it.
+func (s *S3Bucket) Modify(ctx context.Context, bucketName string, bucketDetails BucketDetails) error {
+	if len(bucketDetails.Tags) > 0 {
+		tags, err := s.getBucketTags(ctx, bucketName)
+		if err != nil {
+			return convertError(err)
+		}
+		maps.Copy(tags, bucketDetails.Tags)
+		if err := s.putBucketTagging(ctx, bucketName, tags); err != nil {
+			s.logger.Error("aws-s3-error", err)
+			return convertError(err)
+		}
+	}
+
+	if err := s.putBucketEncryption(ctx, bucketName, bucketDetails.Encryption); err != nil {
+		return err
+	}
+	if err := s.modifyBucketVersioning(ctx, bucketName, bucketDetails.Versioning); err != nil {
+		return convertError(err)
+	}
+	if err := s.putBucketCORS(ctx, bucketName, bucketDetails.CORSRules); err != nil {
+		return convertError(err)
+	}
+	if err := s.putBucketLifecycle(ctx, bucketName, bucketDetails.LifecycleRules); err != nil {
+		return convertError(err)
+	}
+	if err := s.replacePlanPolicyStatements(ctx, bucketName, bucketDetails); err != nil {
+		return convertError(err)
+	}
+	if err := s.checkDeletePublicAccessBlock(ctx, bucketDetails, bucketName); err != nil {
+		return convertError(err)
+	}
+	return nil
+}
+
+// putBucketVersioning enables versioning on a new bucket if the plan asks
+// for it.
+func (s *S3Bucket) putBucketVersioning(ctx context.Context, bucketName string, enabled bool) error {
+	if !enabled {
+		return nil
+	}
+	return s.setBucketVersioning(ctx, bucketName, types.BucketVersioningStatusEnabled)
+}
+
+// modifyBucketVersioning enables versioning, or suspends it if it is enabled.
+// Versioning cannot be turned off once enabled; suspending it keeps existing
+// versions but stops creating new ones.
+func (s *S3Bucket) modifyBucketVersioning(ctx context.Context, bucketName string, enabled bool) error {
+	if enabled {
+		return s.setBucketVersioning(ctx, bucketName, types.BucketVersioningStatusEnabled)
+	}
+
+	versioned, err := s.getBucketVersioning(ctx, bucketName)
+	if err != nil || !versioned {
+		return err
+	}
+	return s.setBucketVersioning(ctx, bucketName, types.BucketVersioningStatusSuspended)
+}
+
+func (s *S3Bucket) setBucketVersioning(ctx context.Context, bucketName string, status types.BucketVersioningStatus) error {
+	putVersioningInput := &s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+		VersioningConfiguration: &types.VersioningConfiguration{
+			Status: status,
+		},
+	}
+	s.logger.Debug("put-bucket-versioning", lager.Data{"input": putVersioningInput})
+	_, err := awsretry.Call(ctx, s.retry.For("PutBucketVersioning"), func() (*s3.PutBucketVersioningOutput, error) {
+		return s.client(ctx, bucketName).PutBucketVersioning(ctx, putVersioningInput)
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return err
+	}
+	return nil
+}
+
+// putBucketCORS replaces the bucket's CORS configuration. Nil rules leave it
+// as it is, and no rules remove it.
+func (s *S3Bucket) putBucketCORS(ctx context.Context, bucketName string, rules []CORSRule) error {
+	if rules == nil {
+		return nil
+	}
+
+	if len(rules) == 0 {
+		deleteCORSInput := &s3.DeleteBucketCorsInput{
+			Bucket: aws.String(bucketName),
+		}
+		s.logger.Debug("delete-bucket-cors", lager.Data{"input": deleteCORSInput})
+		_, err := awsretry.Call(ctx, s.retry.For("DeleteBucketCors"), func() (*s3.DeleteBucketCorsOutput, error) {
+			return s.client(ctx, bucketName).DeleteBucketCors(ctx, deleteCORSInput)
+		})
+		if err != nil {
+			s.logger.Error("aws-s3-error", err)
+			return err
+		}
+		return nil
+	}
+
+	var corsRules []types.CORSRule
+	for _, rule := range rules {
+		corsRule := types.CORSRule{
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+		}
+		if len(rule.AllowedHeaders) > 0 {
+			corsRule.AllowedHeaders = rule.AllowedHeaders
+		}
+		if len(rule.ExposeHeaders) > 0 {
+			corsRule.ExposeHeaders = rule.ExposeHeaders
+		}
+		if rule.MaxAgeSeconds > 0 {
+			corsRule.MaxAgeSeconds = aws.Int32(int32(rule.MaxAgeSeconds))
+		}
+		corsRules = append(corsRules, corsRule)
+	}
+	putCORSInput := &s3.PutBucketCorsInput{
+		Bucket:            aws.String(bucketName),
+		CORSConfiguration: &types.CORSConfiguration{CORSRules: corsRules},
+	}
+	s.logger.Debug("put-bucket-cors", lager.Data{"input": putCORSInput})
+	_, err := awsretry.Call(ctx, s.retry.For("PutBucketCors"), func() (*s3.PutBucketCorsOutput, error) {
+		return s.client(ctx, bucketName).PutBucketCors(ctx, putCORSInput)
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return err
+	}
+	return nil
+}
+
+// putBucketLifecycle replaces the bucket's lifecycle configuration. Nil
+// rules leave it as it is, and no rules remove it.
+func (s *S3Bucket) putBucketLifecycle(ctx context.Context, bucketName string, rules []LifecycleRule) error {
+	if rules == nil {
+		return nil
+	}
+
+	if len(rules) == 0 {
+		deleteLifecycleInput := &s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(bucketName),
+		}
+		s.logger.Debug("delete-bucket-lifecycle", lager.Data{"input": deleteLifecycleInput})
+		_, err := awsretry.Call(ctx, s.retry.For("DeleteBucketLifecycle"), func() (*s3.DeleteBucketLifecycleOutput, error) {
+			return s.client(ctx, bucketName).DeleteBucketLifecycle(ctx, deleteLifecycleInput)
+		})
+		if err != nil {
+			s.logger.Error("aws-s3-error", err)
+			return err
+		}
+		return nil
+	}
+
+	var lifecycleRules []types.LifecycleRule
+	for _, rule := range rules {
+		lifecycleRule := types.LifecycleRule{
+			ID:     aws.String(rule.ID),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilter{Prefix: aws.String(rule.Prefix)},
+		}
+		if rule.ExpirationDays > 0 {
+			lifecycleRule.Expiration = &types.LifecycleExpiration{Days: aws.Int32(int32(rule.ExpirationDays))}
+		}
+		if rule.NoncurrentVersionExpirationDays > 0 {
+			lifecycleRule.NoncurrentVersionExpiration = &types.NoncurrentVersionExpiration{
+				NoncurrentDays: aws.Int32(int32(rule.NoncurrentVersionExpirationDays)),
+			}
+		}
+		if rule.AbortIncompleteMultipartUploadDays > 0 {
+			lifecycleRule.AbortIncompleteMultipartUpload = &types.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: aws.Int32(int32(rule.AbortIncompleteMultipartUploadDays)),
+			}
+		}
+		lifecycleRules = append(lifecycleRules, lifecycleRule)
+	}
+	putLifecycleInput := &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucketName),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: lifecycleRules},
+	}
+	s.logger.Debug("put-bucket-lifecycle", lager.Data{"input": putLifecycleInput})
+	_, err := awsretry.Call(ctx, s.retry.For("PutBucketLifecycleConfiguration"), func() (*s3.PutBucketLifecycleConfigurationOutput, error) {
+		return s.client(ctx, bucketName).PutBucketLifecycleConfiguration(ctx, putLifecycleInput)
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return err
+	}
+	return nil
+}
+
+// replacePlanPolicyStatements swaps the statements of the previous plan's
+// bucket policy for those of the new plan. Plan statements are those without
+// a Sid, or with a Sid that the new plan's policy also uses.
+func (s *S3Bucket) replacePlanPolicyStatements(ctx context.Context, bucketName string, bucketDetails BucketDetails) error {
+	policy, err := s.getBucketPolicy(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+
+	var rendered string
+	if len(bucketDetails.Policy) > 0 {
+		if rendered, err = s.renderBucketPolicy(bucketName, bucketDetails); err != nil {
+			return err
+		}
+	}
+	statements, err := ReplacePlanStatements(policy, rendered)
+	if err != nil {
+		return err
+	}
+
+	if len(statements) == 0 {
+		if len(policyStatements(policy)) == 0 {
+			return nil
+		}
+		return s.deleteBucketPolicy(ctx, bucketName)
+	}
+	policy["Statement"] = statements
+	return s.putBucketPolicy(ctx, bucketName, policy)
+}
+
+// ReplacePlanStatements returns the statements of policy with those of a
+// previous plan replaced by the statements of rendered, the new plan's
+// rendered bucket policy, which may be empty. Plan statements are those
+// without a Sid, or with a Sid that the new plan's policy also uses.
+func ReplacePlanStatements(policy map[string]any, rendered string) ([]any, error) {
+	var planStatements []any
+	if rendered != "" {
+		var planPolicy map[string]any
+		if err := json.Unmarshal([]byte(rendered), &planPolicy); err != nil {
+			return nil, err
+		}
+		planStatements = policyStatements(planPolicy)
+	}
+	var planSids []string
+	for _, statement := range planStatements {
+		if fields, ok := statement.(map[string]any); ok {
+			if sid, _ := fields["Sid"].(string); sid != "" {
+				planSids = append(planSids, sid)
+			}
+		}
+	}
+
+	var statements []any
+	for _, statement := range policyStatements(policy) {
+		fields, ok := statement.(map[string]any)
+		if !ok {
+			continue
+		}
+		if sid, _ := fields["Sid"].(string); sid != "" && !slices.Contains(planSids, sid) {
+			statements = append(statements, statement)
+		}
+	}
+	return append(statements, planStatements...), nil
+}
@@ -0,0 +1,133 @@
+package awss3
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestModify(t *testing.T) {
+	publicPolicy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":["s3:GetObject"],"Resource":["arn:aws:s3:::{{.BucketName}}/*"]}]}`
+	bindingStatement := `{"Sid":"Bindingbinding1","Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111111111111:root"},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::b/*"]}`
+
+	testCases := map[string]struct {
+		client            *MockS3Client
+		details           BucketDetails
+		expectVersioning  string
+		expectPolicySids  []string
+		expectPolicyCount int
+		expectPolicyGone  bool
+		expectCORSDeleted bool
+		expectLifecycle   int
+	}{
+		"enables versioning and adds CORS and lifecycle rules": {
+			client: &MockS3Client{},
+			details: BucketDetails{
+				Versioning: true,
+				CORSRules:  []CORSRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}},
+				LifecycleRules: []LifecycleRule{
+					{ID: "expire", ExpirationDays: 30},
+					{ID: "uploads", AbortIncompleteMultipartUploadDays: 7},
+				},
+			},
+			expectVersioning: string(types.BucketVersioningStatusEnabled),
+			expectLifecycle:  2,
+		},
+		"suspends versioning and removes CORS rules": {
+			client:            &MockS3Client{versioningStatus: string(types.BucketVersioningStatusEnabled)},
+			details:           BucketDetails{CORSRules: []CORSRule{}},
+			expectVersioning:  string(types.BucketVersioningStatusSuspended),
+			expectCORSDeleted: true,
+		},
+		"does not suspend unversioned buckets": {
+			client:  &MockS3Client{},
+			details: BucketDetails{},
+		},
+		"replaces plan statements and keeps binding statements": {
+			client: &MockS3Client{
+				bucketPolicy: `{"Version":"2012-10-17","Statement":[` + bindingStatement + `,{"Effect":"Allow","Principal":"*","Action":["s3:ListBucket"],"Resource":["arn:aws:s3:::b"]}]}`,
+			},
+			details:           BucketDetails{Policy: publicPolicy},
+			expectPolicySids:  []string{"Bindingbinding1", ""},
+			expectPolicyCount: 2,
+		},
+		"deletes the policy of a plan without one": {
+			client: &MockS3Client{
+				bucketPolicy: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":["s3:GetObject"],"Resource":["arn:aws:s3:::b/*"]}]}`,
+			},
+			details:          BucketDetails{},
+			expectPolicyGone: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := NewS3Bucket(tc.client, lager.NewLogger("test"), Config{})
+			if err := b.Modify(context.Background(), "b", tc.details); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if tc.client.versioningStatus != tc.expectVersioning {
+				t.Errorf("expected versioning %q, got %q", tc.expectVersioning, tc.client.versioningStatus)
+			}
+			if tc.client.corsDeleted != tc.expectCORSDeleted {
+				t.Errorf("expected CORS deleted %t, got %t", tc.expectCORSDeleted, tc.client.corsDeleted)
+			}
+			if tc.details.CORSRules != nil && len(tc.details.CORSRules) != len(tc.client.corsRules) {
+				t.Errorf("expected %d CORS rules, got %d", len(tc.details.CORSRules), len(tc.client.corsRules))
+			}
+			if len(tc.client.lifecycleRules) != tc.expectLifecycle {
+				t.Errorf("expected %d lifecycle rules, got %d", tc.expectLifecycle, len(tc.client.lifecycleRules))
+			}
+			if tc.client.bucketPolicyDeleted != tc.expectPolicyGone {
+				t.Errorf("expected policy deleted %t, got %t", tc.expectPolicyGone, tc.client.bucketPolicyDeleted)
+			}
+			if tc.expectPolicyCount == 0 {
+				return
+			}
+
+			var policy struct {
+				Statement []struct {
+					Sid    string
+					Action []string
+				}
+			}
+			if err := json.Unmarshal([]byte(tc.client.bucketPolicy), &policy); err != nil {
+				t.Fatal(err)
+			}
+			if len(policy.Statement) != tc.expectPolicyCount {
+				t.Fatalf("expected %d statements, got %s", tc.expectPolicyCount, tc.client.bucketPolicy)
+			}
+			for i, sid := range tc.expectPolicySids {
+				if policy.Statement[i].Sid != sid {
+					t.Errorf("expected statement %d to have Sid %q, got %q", i, sid, policy.Statement[i].Sid)
+				}
+			}
+			if !tc.client.deletePublicAccessBlockCalled {
+				t.Errorf("expected the public access block to be deleted for a public plan")
+			}
+		})
+	}
+}
+
+func TestModifyMergesTags(t *testing.T) {
+	client := &MockS3Client{bucketTags: map[string]string{"Created at": "yesterday", "Plan name": "basic"}}
+	b := NewS3Bucket(client, lager.NewLogger("test"), Config{})
+
+	err := b.Modify(context.Background(), "b", BucketDetails{Tags: map[string]string{"Updated at": "today", "Plan name": "basic-versioned"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]string{"Created at": "yesterday", "Updated at": "today", "Plan name": "basic-versioned"}
+	for key, value := range expected {
+		if client.putBucketTags[key] != value {
+			t.Errorf("expected tag %s=%s, got %v", key, value, client.putBucketTags)
+		}
+	}
+	if len(client.putBucketTags) != len(expected) {
+		t.Errorf("expected tags %v, got %v", expected, client.putBucketTags)
+	}
+}
@@ -0,0 +1,194 @@
+package awss3
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// PolicyStatement is a bucket policy statement that the broker manages on
+// behalf of a binding. Sid identifies it, so it can be replaced or removed
+// without touching the rest of the policy.
+type PolicyStatement struct {
+	Sid       string                       `json:"Sid"`
+	Effect    string                       `json:"Effect"`
+	Principal map[string]string            `json:"Principal"`
+	Action    []string                     `json:"Action"`
+	Resource  []string                     `json:"Resource"`
+	Condition map[string]map[string]string `json:"Condition,omitempty"`
+}
+
+// maxPolicyAttempts bounds how many times a change to a bucket policy is
+// made again after another change overwrote it.
+const maxPolicyAttempts = 5
+
+// AddPolicyStatements adds statements to the bucket's policy, replacing any
+// statements with the same Sid.
+func (s *S3Bucket) AddPolicyStatements(ctx context.Context, bucketName string, statements []PolicyStatement) error {
+	sids := make([]string, len(statements))
+	for i, statement := range statements {
+		sids[i] = statement.Sid
+	}
+	policy, err := s.getBucketPolicy(ctx, bucketName)
+	if err != nil {
+		return convertError(err)
+	}
+	return s.changePolicy(ctx, bucketName, policy, func(policy map[string]any) []any {
+		existing := withoutStatements(policy, sids)
+		for _, statement := range statements {
+			existing = append(existing, statement)
+		}
+		return existing
+	}, func(policy map[string]any) bool {
+		return len(policyStatements(policy))-len(withoutStatements(policy, sids)) == len(sids)
+	})
+}
+
+// RemovePolicyStatements removes the statements with the given Sids from the
+// bucket's policy, deleting the policy if nothing else is left in it. Missing
+// statements, policies and buckets are not errors.
+func (s *S3Bucket) RemovePolicyStatements(ctx context.Context, bucketName string, sids []string) error {
+	policy, err := s.getBucketPolicy(ctx, bucketName)
+	if err != nil {
+		if isNoSuchBucketError(err) {
+			return nil
+		}
+		return convertError(err)
+	}
+	removed := func(policy map[string]any) bool {
+		return len(withoutStatements(policy, sids)) == len(policyStatements(policy))
+	}
+	if removed(policy) {
+		return nil
+	}
+	return s.changePolicy(ctx, bucketName, policy, func(policy map[string]any) []any {
+		return withoutStatements(policy, sids)
+	}, removed)
+}
+
+// changePolicy writes the statements that change makes of the bucket's
+// policy, deleting the policy if there are none, and reads the policy back
+// until done reports that it holds the change. Bucket policies are read,
+// changed and written back whole, so a change to the same bucket that another
+// request or broker made at the same time can overwrite this one; it is then
+// made again on top of the other.
+func (s *S3Bucket) changePolicy(ctx context.Context, bucketName string, policy map[string]any, change func(policy map[string]any) []any, done func(policy map[string]any) bool) error {
+	for attempt := 1; ; attempt++ {
+		var err error
+		if statements := change(policy); len(statements) > 0 {
+			policy["Statement"] = statements
+			err = s.putBucketPolicy(ctx, bucketName, policy)
+		} else {
+			err = s.deleteBucketPolicy(ctx, bucketName)
+		}
+		if err != nil {
+			return err
+		}
+
+		policy, err = s.getBucketPolicy(ctx, bucketName)
+		if err != nil {
+			return convertError(err)
+		}
+		if done(policy) {
+			return nil
+		}
+		if attempt == maxPolicyAttempts {
+			return ErrPolicyConflict
+		}
+		s.logger.Info("bucket-policy-overwritten", lager.Data{"bucket": bucketName, "attempt": attempt})
+	}
+}
+
+// getBucketPolicy returns the bucket's policy as generic JSON, so that parts
+// the broker does not manage survive a round trip. A bucket without a policy
+// has an empty one.
+func (s *S3Bucket) getBucketPolicy(ctx context.Context, bucketName string) (map[string]any, error) {
+	getBucketPolicyInput := &s3.GetBucketPolicyInput{
+		Bucket: aws.String(bucketName),
+	}
+	s.logger.Debug("get-bucket-policy", lager.Data{"input": getBucketPolicyInput})
+	getBucketPolicyOutput, err := awsretry.Call(ctx, s.retry.For("GetBucketPolicy"), func() (*s3.GetBucketPolicyOutput, error) {
+		return s.client(ctx, bucketName).GetBucketPolicy(ctx, getBucketPolicyInput)
+	})
+	if err != nil {
+		if errorCode(err) == "NoSuchBucketPolicy" {
+			return map[string]any{"Version": "2012-10-17"}, nil
+		}
+		s.logger.Error("aws-s3-error", err)
+		return nil, err
+	}
+
+	var policy map[string]any
+	if err := json.Unmarshal([]byte(aws.ToString(getBucketPolicyOutput.Policy)), &policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (s *S3Bucket) deleteBucketPolicy(ctx context.Context, bucketName string) error {
+	deleteBucketPolicyInput := &s3.DeleteBucketPolicyInput{
+		Bucket: aws.String(bucketName),
+	}
+	s.logger.Debug("delete-bucket-policy", lager.Data{"input": deleteBucketPolicyInput})
+	_, err := awsretry.Call(ctx, s.retry.For("DeleteBucketPolicy"), func() (*s3.DeleteBucketPolicyOutput, error) {
+		return s.client(ctx, bucketName).DeleteBucketPolicy(ctx, deleteBucketPolicyInput)
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func (s *S3Bucket) putBucketPolicy(ctx context.Context, bucketName string, policy map[string]any) error {
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	putBucketPolicyInput := &s3.PutBucketPolicyInput{
+		Bucket: aws.String(bucketName),
+		Policy: aws.String(string(policyJSON)),
+	}
+	s.logger.Debug("put-bucket-policy", lager.Data{"input": putBucketPolicyInput})
+	_, err = awsretry.Call(ctx, s.retry.For("PutBucketPolicy"), func() (*s3.PutBucketPolicyOutput, error) {
+		return s.client(ctx, bucketName).PutBucketPolicy(ctx, putBucketPolicyInput)
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+// policyStatements returns the policy's statements. A policy may hold a
+// single statement instead of a list.
+func policyStatements(policy map[string]any) []any {
+	switch statements := policy["Statement"].(type) {
+	case []any:
+		return statements
+	case map[string]any:
+		return []any{statements}
+	default:
+		return nil
+	}
+}
+
+func withoutStatements(policy map[string]any, sids []string) []any {
+	var remaining []any
+	for _, statement := range policyStatements(policy) {
+		if fields, ok := statement.(map[string]any); ok {
+			if sid, _ := fields["Sid"].(string); slices.Contains(sids, sid) {
+				continue
+			}
+		}
+		remaining = append(remaining, statement)
+	}
+	return remaining
+}
@@ -0,0 +1,144 @@
+package awss3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+func statementSids(t *testing.T, policy string) []string {
+	t.Helper()
+	var parsed struct {
+		Statement []struct{ Sid string }
+	}
+	if err := json.Unmarshal([]byte(policy), &parsed); err != nil {
+		t.Fatalf("invalid policy %q: %s", policy, err)
+	}
+	var sids []string
+	for _, statement := range parsed.Statement {
+		sids = append(sids, statement.Sid)
+	}
+	return sids
+}
+
+func TestAddPolicyStatements(t *testing.T) {
+	testCases := map[string]struct {
+		policy     string
+		expectSids []string
+	}{
+		"no policy": {
+			expectSids: []string{"Binding1"},
+		},
+		"appends to policy": {
+			policy:     `{"Version": "2012-10-17", "Statement": [{"Sid": "Public", "Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}]}`,
+			expectSids: []string{"Public", "Binding1"},
+		},
+		"single statement": {
+			policy:     `{"Version": "2012-10-17", "Statement": {"Sid": "Public", "Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}}`,
+			expectSids: []string{"Public", "Binding1"},
+		},
+		"replaces statement": {
+			policy:     `{"Version": "2012-10-17", "Statement": [{"Sid": "Binding1", "Effect": "Allow"}, {"Sid": "Public", "Effect": "Allow"}]}`,
+			expectSids: []string{"Public", "Binding1"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &MockS3Client{bucketPolicy: tc.policy}
+			bucket := NewS3Bucket(client, lager.NewLogger("s3-bucket-test"), Config{Retry: testRetryConfig})
+			err := bucket.AddPolicyStatements(context.Background(), "bucket", []PolicyStatement{{
+				Sid:       "Binding1",
+				Effect:    "Allow",
+				Principal: map[string]string{"AWS": "arn:aws:iam::123456789012:root"},
+				Action:    []string{"s3:GetObject"},
+				Resource:  []string{"arn:aws:s3:::bucket/*"},
+			}})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			sids := statementSids(t, client.bucketPolicy)
+			if len(sids) != len(tc.expectSids) {
+				t.Fatalf("expected statements %v, got %v", tc.expectSids, sids)
+			}
+			for i := range sids {
+				if sids[i] != tc.expectSids[i] {
+					t.Errorf("expected statements %v, got %v", tc.expectSids, sids)
+				}
+			}
+		})
+	}
+}
+
+func TestAddPolicyStatementsOverwritten(t *testing.T) {
+	other := `{"Version": "2012-10-17", "Statement": [{"Sid": "Binding2", "Effect": "Allow"}]}`
+	testCases := map[string]struct {
+		concurrent []string
+		expectErr  error
+		expectSids []string
+	}{
+		"made again on top of the other change": {
+			concurrent: []string{other},
+			expectSids: []string{"Binding2", "Binding1"},
+		},
+		"always overwritten": {
+			concurrent: slices.Repeat([]string{other}, maxPolicyAttempts),
+			expectErr:  ErrPolicyConflict,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &MockS3Client{concurrentBucketPolicies: tc.concurrent}
+			bucket := NewS3Bucket(client, lager.NewLogger("s3-bucket-test"), Config{Retry: testRetryConfig})
+			err := bucket.AddPolicyStatements(context.Background(), "bucket", []PolicyStatement{{Sid: "Binding1", Effect: "Allow"}})
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if tc.expectErr != nil {
+				return
+			}
+			if sids := statementSids(t, client.bucketPolicy); !slices.Equal(sids, tc.expectSids) {
+				t.Errorf("expected statements %v, got %v", tc.expectSids, sids)
+			}
+		})
+	}
+}
+
+func TestRemovePolicyStatements(t *testing.T) {
+	testCases := map[string]struct {
+		policy        string
+		expectSids    []string
+		expectDeleted bool
+	}{
+		"no policy": {},
+		"keeps other statements": {
+			policy:     `{"Version": "2012-10-17", "Statement": [{"Sid": "Binding1", "Effect": "Allow"}, {"Sid": "Public", "Effect": "Allow"}]}`,
+			expectSids: []string{"Public"},
+		},
+		"deletes empty policy": {
+			policy:        `{"Version": "2012-10-17", "Statement": [{"Sid": "Binding1", "Effect": "Allow"}]}`,
+			expectDeleted: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &MockS3Client{bucketPolicy: tc.policy}
+			bucket := NewS3Bucket(client, lager.NewLogger("s3-bucket-test"), Config{Retry: testRetryConfig})
+			if err := bucket.RemovePolicyStatements(context.Background(), "bucket", []string{"Binding1"}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if client.bucketPolicyDeleted != tc.expectDeleted {
+				t.Errorf("expected policy deleted %t, got %t", tc.expectDeleted, client.bucketPolicyDeleted)
+			}
+			if tc.expectSids != nil {
+				sids := statementSids(t, client.bucketPolicy)
+				if len(sids) != 1 || sids[0] != tc.expectSids[0] {
+					t.Errorf("expected statements %v, got %v", tc.expectSids, sids)
+				}
+			}
+		})
+	}
+}
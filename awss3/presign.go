@@ -0,0 +1,54 @@
+package awss3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var ErrPresignDisabled = errors.New("presigning object URLs requires a presigner")
+
+// PresignObjectURL returns a URL that lets its holder GET or PUT one object
+// until ttl passes, without AWS credentials of their own. The URL is signed
+// with the broker's credentials, so it stops working early if they expire.
+func (s *S3Bucket) PresignObjectURL(bucketName, key, method string, ttl time.Duration) (string, error) {
+	if s.presigner == nil {
+		return "", ErrPresignDisabled
+	}
+
+	// Presigning is done locally, apart from refreshing credentials and finding
+	// the region of a bucket not seen before.
+	ctx := context.Background()
+	optFns := append(s.presignRegion(ctx, bucketName), s3.WithPresignExpires(ttl))
+
+	var req *v4.PresignedHTTPRequest
+	var err error
+	switch method {
+	case http.MethodGet:
+		req, err = s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+		}, optFns...)
+	case http.MethodPut:
+		req, err = s.presigner.PresignPutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+		}, optFns...)
+	default:
+		return "", fmt.Errorf("cannot presign %s requests", method)
+	}
+
+	s.logger.Debug("presign-object-url", lager.Data{"bucket": bucketName, "key": key, "method": method, "ttl": ttl})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return "", convertError(err)
+	}
+	return req.URL, nil
+}
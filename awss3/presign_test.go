@@ -0,0 +1,54 @@
+package awss3
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+func TestPresignObjectURL(t *testing.T) {
+	testCases := map[string]struct {
+		method    string
+		expectErr bool
+	}{
+		"get":    {method: http.MethodGet},
+		"put":    {method: http.MethodPut},
+		"delete": {method: http.MethodDelete, expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), Config{Retry: testRetryConfig, Presigner: presignClient})
+			presigned, err := bucket.PresignObjectURL("bucket", "reports/2024.csv", tc.method, 15*time.Minute)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got URL %s", presigned)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			u, err := url.Parse(presigned)
+			if err != nil {
+				t.Fatalf("invalid URL %q: %s", presigned, err)
+			}
+			if object := u.Host + u.Path; object != "bucket.s3.us-east-1.amazonaws.com/reports/2024.csv" {
+				t.Errorf("expected URL for bucket.s3.us-east-1.amazonaws.com/reports/2024.csv, got %s", presigned)
+			}
+			if expires := u.Query().Get("X-Amz-Expires"); expires != "900" {
+				t.Errorf("expected X-Amz-Expires 900, got %q", expires)
+			}
+		})
+	}
+}
+
+func TestPresignObjectURLWithoutPresigner(t *testing.T) {
+	bucket := NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), Config{})
+	if _, err := bucket.PresignObjectURL("bucket", "key", http.MethodGet, time.Minute); !errors.Is(err, ErrPresignDisabled) {
+		t.Errorf("expected ErrPresignDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package awss3
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// regionPool holds the S3 and tagging clients for the regions that buckets
+// are in, and the regions of the buckets seen so far. Buckets must be managed
+// through a client for their own region, and the tagging API only finds the
+// buckets in its own region.
+type regionPool struct {
+	region     string
+	regions    []string
+	newClient  func(region string) S3Client
+	newTagging func(region string) TaggingClient
+
+	mu             sync.Mutex
+	clients        map[string]S3Client
+	taggingClients map[string]TaggingClient
+	bucketRegions  map[string]string
+}
+
+// client returns the S3 client for the region of bucketName. Without a
+// regional client constructor, or if the bucket's region cannot be found,
+// the S3 client for the broker's region is returned, which reports errors
+// such as a missing bucket as they would otherwise be.
+func (s *S3Bucket) client(ctx context.Context, bucketName string) S3Client {
+	if s.regions.newClient == nil {
+		return s.s3svc
+	}
+	region, ok := s.bucketRegion(ctx, bucketName)
+	if !ok {
+		return s.s3svc
+	}
+	return s.regionClient(region)
+}
+
+// regionClient returns the S3 client for region, creating it if needed.
+func (s *S3Bucket) regionClient(region string) S3Client {
+	if s.regions.newClient == nil || region == "" || region == s.regions.region {
+		return s.s3svc
+	}
+	s.regions.mu.Lock()
+	defer s.regions.mu.Unlock()
+	client, ok := s.regions.clients[region]
+	if !ok {
+		client = s.regions.newClient(region)
+		s.regions.clients[region] = client
+	}
+	return client
+}
+
+// taggingClients returns the tagging clients for the broker's region and
+// each of the other regions that buckets may be in, creating them if needed.
+func (s *S3Bucket) taggingClients() []TaggingClient {
+	clients := []TaggingClient{s.tagging}
+	if s.regions.newTagging == nil {
+		return clients
+	}
+	s.regions.mu.Lock()
+	defer s.regions.mu.Unlock()
+	for _, region := range s.regions.regions {
+		if region == "" || region == s.regions.region {
+			continue
+		}
+		client, ok := s.regions.taggingClients[region]
+		if !ok {
+			client = s.regions.newTagging(region)
+			s.regions.taggingClients[region] = client
+		}
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// bucketRegion returns the region of bucketName, looking it up the first
+// time the bucket is seen.
+func (s *S3Bucket) bucketRegion(ctx context.Context, bucketName string) (string, bool) {
+	s.regions.mu.Lock()
+	region, ok := s.regions.bucketRegions[bucketName]
+	s.regions.mu.Unlock()
+	if ok {
+		return region, true
+	}
+
+	output, err := s.s3svc.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		s.logger.Error("bucket-region", err, lager.Data{"bucket": bucketName})
+		return "", false
+	}
+	region = locationRegion(output)
+	s.setBucketRegion(bucketName, region)
+	return region, true
+}
+
+// setBucketRegion records the region of bucketName, or forgets it if region
+// is empty.
+func (s *S3Bucket) setBucketRegion(bucketName, region string) {
+	if s.regions.newClient == nil {
+		return
+	}
+	s.regions.mu.Lock()
+	defer s.regions.mu.Unlock()
+	if region == "" {
+		delete(s.regions.bucketRegions, bucketName)
+		return
+	}
+	s.regions.bucketRegions[bucketName] = region
+}
+
+// presignRegion returns the presign option that signs URLs for the region of
+// bucketName, if it is not the broker's region.
+func (s *S3Bucket) presignRegion(ctx context.Context, bucketName string) []func(*s3.PresignOptions) {
+	if s.regions.newClient == nil {
+		return nil
+	}
+	region, ok := s.bucketRegion(ctx, bucketName)
+	if !ok || region == s.regions.region {
+		return nil
+	}
+	return []func(*s3.PresignOptions){
+		s3.WithPresignClientFromClientOptions(func(o *s3.Options) { o.Region = region }),
+	}
+}
+
+// locationRegion returns the region that a GetBucketLocation output names.
+// Buckets in us-east-1 have no location constraint.
+func locationRegion(output *s3.GetBucketLocationOutput) string {
+	region := string(output.LocationConstraint)
+	if region == "" {
+		region = "us-east-1"
+	}
+	return region
+}
@@ -0,0 +1,106 @@
+package awss3
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestRegionClient(t *testing.T) {
+	cases := []struct {
+		Name           string
+		BucketLocation types.BucketLocationConstraint
+		RegionClients  bool
+		ExpectRegional bool
+	}{
+		{
+			Name:           "bucket in the broker's region",
+			BucketLocation: "",
+			RegionClients:  true,
+			ExpectRegional: false,
+		},
+		{
+			Name:           "bucket in another region",
+			BucketLocation: "eu-west-1",
+			RegionClients:  true,
+			ExpectRegional: true,
+		},
+		{
+			Name:           "without regional clients",
+			BucketLocation: "eu-west-1",
+			RegionClients:  false,
+			ExpectRegional: false,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Name, func(t *testing.T) {
+			brokerClient := &MockS3Client{bucketLocation: test.BucketLocation}
+			regionClients := map[string]*MockS3Client{}
+			config := Config{Region: "us-east-1"}
+			if test.RegionClients {
+				config.RegionClient = func(region string) S3Client {
+					regionClients[region] = &MockS3Client{}
+					return regionClients[region]
+				}
+			}
+			b := NewS3Bucket(brokerClient, lager.NewLogger("test"), config)
+
+			for range 2 {
+				if err := b.putBucketTagging(context.Background(), "b", map[string]string{"k": "v"}); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			regionClient := regionClients[string(test.BucketLocation)]
+			if test.ExpectRegional {
+				if len(regionClients) != 1 || regionClient == nil {
+					t.Fatalf("expected one client for %s, got %v", test.BucketLocation, regionClients)
+				}
+				if regionClient.putBucketTagsCalls != 2 || brokerClient.putBucketTagsCalls != 0 {
+					t.Error("expected the bucket to be tagged through the regional client")
+				}
+			} else {
+				if len(regionClients) != 0 {
+					t.Errorf("expected no regional clients, got %v", regionClients)
+				}
+				if brokerClient.putBucketTagsCalls != 2 {
+					t.Error("expected the bucket to be tagged through the broker's client")
+				}
+			}
+			if test.RegionClients && brokerClient.getBucketLocationCalls != 1 {
+				t.Errorf("expected the bucket's region to be looked up once, got %d lookups", brokerClient.getBucketLocationCalls)
+			}
+		})
+	}
+}
+
+func TestCreateInRegion(t *testing.T) {
+	brokerClient := &MockS3Client{}
+	regionClients := map[string]*MockS3Client{}
+	b := NewS3Bucket(brokerClient, lager.NewLogger("test"), Config{
+		Region: "us-east-1",
+		RegionClient: func(region string) S3Client {
+			regionClients[region] = &MockS3Client{}
+			return regionClients[region]
+		},
+	})
+
+	if _, err := b.Create(context.Background(), "b", BucketDetails{Region: "eu-west-1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	regionClient := regionClients["eu-west-1"]
+	if regionClient == nil || regionClient.createBucketCalls != 1 {
+		t.Fatalf("expected the bucket to be created through the eu-west-1 client, got %v", regionClients)
+	}
+	configuration := regionClient.createBucketInput.CreateBucketConfiguration
+	if configuration == nil || configuration.LocationConstraint != "eu-west-1" {
+		t.Errorf("expected location constraint eu-west-1, got %+v", configuration)
+	}
+	if brokerClient.createBucketCalls != 0 || brokerClient.getBucketLocationCalls != 0 {
+		t.Error("expected the broker's client not to be used")
+	}
+}
@@ -2,34 +2,118 @@ package awss3
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"text/template"
-	"time"
 
 	"code.cloudfoundry.org/lager/v3"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/cloud-gov/s3-broker/awspartition"
+	"github.com/cloud-gov/s3-broker/awsretry"
 	"golang.org/x/exp/slices"
 )
 
 type S3Client interface {
-	GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error)
-	CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error)
-	PutBucketTagging(input *s3.PutBucketTaggingInput) (*s3.PutBucketTaggingOutput, error)
-	PutBucketEncryption(input *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error)
-	PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutBucketPolicyOutput, error)
-	DeletePublicAccessBlock(input *s3.DeletePublicAccessBlockInput) (*s3.DeletePublicAccessBlockOutput, error)
-	DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error)
-	GetPublicAccessBlock(input *s3.GetPublicAccessBlockInput) (*s3.GetPublicAccessBlockOutput, error)
+	GetBucketLocation(ctx context.Context, input *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error)
+	CreateBucket(ctx context.Context, input *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error)
+	HeadBucket(ctx context.Context, input *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	GetBucketTagging(ctx context.Context, input *s3.GetBucketTaggingInput, optFns ...func(*s3.Options)) (*s3.GetBucketTaggingOutput, error)
+	PutBucketTagging(ctx context.Context, input *s3.PutBucketTaggingInput, optFns ...func(*s3.Options)) (*s3.PutBucketTaggingOutput, error)
+	PutBucketEncryption(ctx context.Context, input *s3.PutBucketEncryptionInput, optFns ...func(*s3.Options)) (*s3.PutBucketEncryptionOutput, error)
+	PutBucketPolicy(ctx context.Context, input *s3.PutBucketPolicyInput, optFns ...func(*s3.Options)) (*s3.PutBucketPolicyOutput, error)
+	DeletePublicAccessBlock(ctx context.Context, input *s3.DeletePublicAccessBlockInput, optFns ...func(*s3.Options)) (*s3.DeletePublicAccessBlockOutput, error)
+	DeleteBucket(ctx context.Context, input *s3.DeleteBucketInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketOutput, error)
+	GetPublicAccessBlock(ctx context.Context, input *s3.GetPublicAccessBlockInput, optFns ...func(*s3.Options)) (*s3.GetPublicAccessBlockOutput, error)
+	ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	ListObjectVersions(ctx context.Context, input *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+	DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	ListBuckets(ctx context.Context, input *s3.ListBucketsInput, optFns ...func(*s3.Options)) (*s3.ListBucketsOutput, error)
+	DeleteBucketTagging(ctx context.Context, input *s3.DeleteBucketTaggingInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketTaggingOutput, error)
+	GetBucketPolicy(ctx context.Context, input *s3.GetBucketPolicyInput, optFns ...func(*s3.Options)) (*s3.GetBucketPolicyOutput, error)
+	DeleteBucketPolicy(ctx context.Context, input *s3.DeleteBucketPolicyInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketPolicyOutput, error)
+	GetBucketEncryption(ctx context.Context, input *s3.GetBucketEncryptionInput, optFns ...func(*s3.Options)) (*s3.GetBucketEncryptionOutput, error)
+	GetBucketOwnershipControls(ctx context.Context, input *s3.GetBucketOwnershipControlsInput, optFns ...func(*s3.Options)) (*s3.GetBucketOwnershipControlsOutput, error)
+	GetBucketCors(ctx context.Context, input *s3.GetBucketCorsInput, optFns ...func(*s3.Options)) (*s3.GetBucketCorsOutput, error)
+	GetBucketLifecycleConfiguration(ctx context.Context, input *s3.GetBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	GetBucketVersioning(ctx context.Context, input *s3.GetBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error)
+	PutBucketVersioning(ctx context.Context, input *s3.PutBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.PutBucketVersioningOutput, error)
+	PutBucketCors(ctx context.Context, input *s3.PutBucketCorsInput, optFns ...func(*s3.Options)) (*s3.PutBucketCorsOutput, error)
+	DeleteBucketCors(ctx context.Context, input *s3.DeleteBucketCorsInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketCorsOutput, error)
+	PutBucketLifecycleConfiguration(ctx context.Context, input *s3.PutBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error)
+	DeleteBucketLifecycle(ctx context.Context, input *s3.DeleteBucketLifecycleInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketLifecycleOutput, error)
+	PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// Presigner signs object requests for PresignObjectURL. *s3.PresignClient
+// implements it.
+type Presigner interface {
+	PresignGetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+	PresignPutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
 }
 
 type S3Bucket struct {
-	s3svc  S3Client
-	logger lager.Logger
+	s3svc               S3Client
+	presigner           Presigner
+	tagging             TaggingClient
+	checkpoints         CheckpointStore
+	retainFailedBuckets bool
+	retry               awsretry.Policy
+	endpoint            *url.URL
+	pathStyle           bool
+	skipBucketTagging   bool
+	regions             *regionPool
+	logger              lager.Logger
+}
+
+// Config holds optional settings for S3Bucket. The zero value is usable.
+type Config struct {
+	// CheckpointStore persists Create progress. Defaults to an in-memory store.
+	CheckpointStore CheckpointStore
+	// RetainFailedBuckets keeps a bucket whose configuration failed during
+	// Create instead of deleting it, so a retried provision can resume.
+	RetainFailedBuckets bool
+	// Retry controls backoff for S3 calls, including calls that fail while a
+	// new bucket propagates.
+	Retry awsretry.Policy
+	// Tagging finds buckets adopted by service instances. FindAdopted fails
+	// without it.
+	Tagging TaggingClient
+	// Presigner signs object URLs. Defaults to a presign client for the S3
+	// client if it is an *s3.Client.
+	Presigner Presigner
+	// Endpoint is the URL of an S3-compatible store that the S3 client
+	// talks to instead of AWS. Describe reports it in place of the AWS
+	// endpoints, and public access blocks, which such stores do not have,
+	// are left alone.
+	Endpoint *url.URL
+	// PathStyle reports bucket URLs as paths of the endpoint rather than as
+	// subdomains of it.
+	PathStyle bool
+	// SkipBucketTagging leaves buckets untagged, for S3-compatible stores
+	// that do not support bucket tags. Buckets then read as having no tags,
+	// so a bucket left by a failed provision is adopted without checking
+	// which instance it was created for.
+	SkipBucketTagging bool
+	// Region is the region of the S3 client.
+	Region string
+	// RegionClient returns an S3 client for a region other than Region.
+	// Buckets in other regions are managed through a client for their
+	// region, which is created the first time it is needed. Without it,
+	// every bucket is managed through the S3 client.
+	RegionClient func(region string) S3Client
+	// Regions are the other regions that buckets may be in.
+	// CountBuckets and FindBuckets query the tagging API in each of them
+	// with a client from RegionTagging, as well as Tagging.
+	Regions       []string
+	RegionTagging func(region string) TaggingClient
 }
 
 type bucketPolicyStatement struct {
@@ -47,117 +131,287 @@ type bucketPolicy struct {
 func NewS3Bucket(
 	s3svc S3Client,
 	logger lager.Logger,
+	config Config,
 ) *S3Bucket {
+	checkpoints := config.CheckpointStore
+	if checkpoints == nil {
+		checkpoints = NewMemoryCheckpointStore()
+	}
+	presigner := config.Presigner
+	if client, ok := s3svc.(*s3.Client); ok && presigner == nil {
+		presigner = s3.NewPresignClient(client)
+	}
 	return &S3Bucket{
-		s3svc:  s3svc,
+		s3svc:               s3svc,
+		presigner:           presigner,
+		tagging:             config.Tagging,
+		checkpoints:         checkpoints,
+		retainFailedBuckets: config.RetainFailedBuckets,
+		retry:               config.Retry,
+		endpoint:            config.Endpoint,
+		pathStyle:           config.PathStyle,
+		skipBucketTagging:   config.SkipBucketTagging,
+		regions: &regionPool{
+			region:         config.Region,
+			regions:        config.Regions,
+			newClient:      config.RegionClient,
+			newTagging:     config.RegionTagging,
+			clients:        map[string]S3Client{},
+			taggingClients: map[string]TaggingClient{},
+			bucketRegions:  map[string]string{},
+		},
 		logger: logger.Session("s3-bucket"),
 	}
 }
 
-func (s *S3Bucket) Describe(bucketName, partition string) (BucketDetails, error) {
+func (s *S3Bucket) Describe(ctx context.Context, bucketName, partition string) (BucketDetails, error) {
 	getLocationInput := &s3.GetBucketLocationInput{
 		Bucket: aws.String(bucketName),
 	}
 	s.logger.Debug("get-bucket-location", lager.Data{"input": getLocationInput})
 
-	getLocationOutput, err := s.s3svc.GetBucketLocation(getLocationInput)
+	getLocationOutput, err := awsretry.Call(ctx, s.retry.For("GetBucketLocation"), func() (*s3.GetBucketLocationOutput, error) {
+		return s.s3svc.GetBucketLocation(ctx, getLocationInput)
+	})
 	if err != nil {
 		s.logger.Error("aws-s3-error", err)
-		if awsErr, ok := err.(awserr.Error); ok {
-			return BucketDetails{}, errors.New(awsErr.Code() + ": " + awsErr.Message())
-		}
-		return BucketDetails{}, err
+		return BucketDetails{}, convertError(err)
 	}
 	s.logger.Debug("get-bucket-location", lager.Data{"output": getLocationOutput})
 
-	region := getLocationOutput.LocationConstraint
-	if region == nil {
-		region = aws.String("us-east-1")
-	}
+	region := locationRegion(getLocationOutput)
+	s.setBucketRegion(bucketName, region)
 
-	return s.buildBucketDetails(bucketName, *region, partition, nil), nil
+	return s.buildBucketDetails(bucketName, region, partition, nil), nil
 }
 
 // Create attempts to create an S3 bucket. If successful, it returns the bucket's location
-// and a nil error. If not, it returns an empty string and an error.
-func (s *S3Bucket) Create(bucketName string, bucketDetails BucketDetails) (string, error) {
+// and a nil error. If not, it returns an empty string and a *CreateStepError naming the
+// step that failed. Completed steps are checkpointed, so calling Create again for the
+// same bucket resumes from the failed step.
+func (s *S3Bucket) Create(ctx context.Context, bucketName string, bucketDetails BucketDetails) (string, error) {
+	location := "/" + bucketName
+
+	completed, err := s.checkpoints.GetCheckpoint(bucketName)
+	if err != nil {
+		s.logger.Error("get-checkpoint", err, lager.Data{"bucket": bucketName})
+		completed = ""
+	}
+	if completed != "" {
+		s.logger.Info("resume-create-bucket", lager.Data{"bucket": bucketName, "completed-step": completed})
+	}
+	// The bucket is managed through a client for the region it is created in.
+	s.setBucketRegion(bucketName, bucketDetails.Region)
+
+	for idx, step := range createSteps {
+		if completed != "" && idx <= slices.Index(createSteps, completed) {
+			continue
+		}
+
+		var err error
+		switch step {
+		case CreateStepCreateBucket:
+			location, err = s.createBucket(ctx, bucketName, bucketDetails)
+		case CreateStepWaitBucketExists:
+			err = s.waitUntilBucketExists(ctx, bucketName)
+		case CreateStepTagging:
+			err = s.putBucketTagging(ctx, bucketName, bucketDetails.Tags)
+		case CreateStepEncryption:
+			err = s.putBucketEncryption(ctx, bucketName, bucketDetails.Encryption)
+		case CreateStepVersioning:
+			err = s.putBucketVersioning(ctx, bucketName, bucketDetails.Versioning)
+		case CreateStepCORS:
+			err = s.putBucketCORS(ctx, bucketName, bucketDetails.CORSRules)
+		case CreateStepLifecycle:
+			err = s.putBucketLifecycle(ctx, bucketName, bucketDetails.LifecycleRules)
+		case CreateStepPublicAccessBlock:
+			err = s.checkDeletePublicAccessBlock(ctx, bucketDetails, bucketName)
+		case CreateStepPolicy:
+			err = s.putBucketPolicyWithRetries(ctx, bucketDetails, bucketName)
+		}
+		if err != nil {
+			stepErr := &CreateStepError{BucketName: bucketName, Step: step, Err: convertError(err)}
+			if step != CreateStepCreateBucket && !s.retainFailedBuckets {
+				stepErr.RolledBack = s.rollbackCreate(ctx, bucketName)
+				stepErr.Orphaned = !stepErr.RolledBack
+			}
+			return "", stepErr
+		}
+
+		if err := s.checkpoints.SaveCheckpoint(bucketName, step); err != nil {
+			s.logger.Error("save-checkpoint", err, lager.Data{"bucket": bucketName, "step": step})
+		}
+	}
+
+	if err := s.checkpoints.DeleteCheckpoint(bucketName); err != nil {
+		s.logger.Error("delete-checkpoint", err, lager.Data{"bucket": bucketName})
+	}
+
+	return location, nil
+}
+
+// rollbackCreate deletes a bucket whose configuration failed part way through
+// Create, so failed provisions do not leak buckets. Buckets that already hold
+// objects are never removed. It reports whether the bucket was deleted.
+// Rollback runs even if ctx was cancelled, since cancellation is often the
+// reason Create failed.
+func (s *S3Bucket) rollbackCreate(ctx context.Context, bucketName string) bool {
+	s.logger.Info("rollback-create-bucket", lager.Data{"bucket": bucketName})
+	if err := s.Delete(context.WithoutCancel(ctx), bucketName, false); err != nil {
+		// The checkpoint is kept so a retried provision resumes, and a
+		// deprovision from the platform's orphan mitigation removes the bucket.
+		s.logger.Error("rollback-create-bucket.orphaned", err, lager.Data{"bucket": bucketName})
+		return false
+	}
+	return true
+}
+
+func (s *S3Bucket) createBucket(ctx context.Context, bucketName string, bucketDetails BucketDetails) (string, error) {
 	createBucketInput := s.buildCreateBucketInput(bucketName, bucketDetails)
 	s.logger.Debug("create-bucket", lager.Data{"input": createBucketInput})
 
-	createBucketOutput, err := s.s3svc.CreateBucket(createBucketInput)
+	createBucketOutput, err := awsretry.Call(ctx, s.retry.For("CreateBucket"), func() (*s3.CreateBucketOutput, error) {
+		return s.client(ctx, bucketName).CreateBucket(ctx, createBucketInput)
+	})
 	if err != nil {
 		s.logger.Error("aws-s3-error", err)
-		if awsErr, ok := err.(awserr.Error); ok {
-			return "", errors.New(awsErr.Code() + ": " + awsErr.Message())
+		if !isBucketAlreadyOwnedByYouError(err) {
+			return "", convertError(err)
 		}
-		return "", err
+		// A previous provision of this instance created the bucket but failed
+		// before finishing; adopt it and reconcile its configuration.
+		if err := s.verifyAdoptableBucket(ctx, bucketName, bucketDetails); err != nil {
+			return "", err
+		}
+		return "/" + bucketName, nil
 	}
 	s.logger.Debug("create-bucket", lager.Data{"output": createBucketOutput})
 
-	var tags []*s3.Tag
-	for key, value := range bucketDetails.Tags {
-		tags = append(tags, &s3.Tag{Key: aws.String(key), Value: aws.String(value)})
+	return aws.ToString(createBucketOutput.Location), nil
+}
+
+// waitUntilBucketExists polls HeadBucket until a newly created bucket is
+// visible, so that the configuration calls that follow do not race S3's
+// eventual consistency.
+func (s *S3Bucket) waitUntilBucketExists(ctx context.Context, bucketName string) error {
+	retryConfig := s.retry.For("WaitUntilBucketExists")
+
+	headBucketInput := &s3.HeadBucketInput{
+		Bucket: aws.String(bucketName),
 	}
-	if _, err := s.s3svc.PutBucketTagging(&s3.PutBucketTaggingInput{
+	s.logger.Debug("wait-until-bucket-exists", lager.Data{"input": headBucketInput})
+	waiter := s3.NewBucketExistsWaiter(s.client(ctx, bucketName), func(options *s3.BucketExistsWaiterOptions) {
+		options.MinDelay = min(retryConfig.InitialDelay, retryConfig.MaxDelay)
+		options.MaxDelay = retryConfig.MaxDelay
+	})
+	err := waiter.Wait(ctx, headBucketInput, retryConfig.MaxElapsed)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return err
+	}
+	return nil
+}
+
+func (s *S3Bucket) putBucketTagging(ctx context.Context, bucketName string, bucketTags map[string]string) error {
+	if s.skipBucketTagging {
+		return nil
+	}
+	var tags []types.Tag
+	for key, value := range bucketTags {
+		// Tags under aws: are set by AWS, such as those CloudFormation adds
+		// to the buckets of stacks, and cannot be put.
+		if strings.HasPrefix(key, "aws:") {
+			continue
+		}
+		tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	putTaggingInput := &s3.PutBucketTaggingInput{
 		Bucket: aws.String(bucketName),
-		Tagging: &s3.Tagging{
+		Tagging: &types.Tagging{
 			TagSet: tags,
 		},
-	}); err != nil {
-		return "", err
 	}
+	_, err := awsretry.Call(ctx, s.retry.For("PutBucketTagging"), func() (*s3.PutBucketTaggingOutput, error) {
+		return s.client(ctx, bucketName).PutBucketTagging(ctx, putTaggingInput)
+	})
+	return err
+}
 
-	if len(bucketDetails.Encryption) > 0 {
-		var encryptionConfig s3.ServerSideEncryptionConfiguration
-		if err := json.Unmarshal([]byte(bucketDetails.Encryption), &encryptionConfig); err != nil {
-			return "", err
-		}
-		putEncryptionInput := &s3.PutBucketEncryptionInput{
-			Bucket:                            aws.String(bucketName),
-			ServerSideEncryptionConfiguration: &encryptionConfig,
-		}
-		s.logger.Debug("put-bucket-encryption", lager.Data{"input": putEncryptionInput})
-		putEncryptionOutput, err := s.s3svc.PutBucketEncryption(putEncryptionInput)
-		if err != nil {
-			s.logger.Error("aws-s3-error", err)
-			if awsErr, ok := err.(awserr.Error); ok {
-				return "", errors.New(awsErr.Code() + ": " + awsErr.Message())
-			}
-			return "", err
-		}
-		s.logger.Debug("put-bucket-encryption", lager.Data{"output": putEncryptionOutput})
+func (s *S3Bucket) putBucketEncryption(ctx context.Context, bucketName, encryption string) error {
+	if len(encryption) == 0 {
+		return nil
 	}
 
-	if err = s.checkDeletePublicAccessBlock(bucketDetails, bucketName); err != nil {
-		return "", err
+	var encryptionConfig types.ServerSideEncryptionConfiguration
+	if err := json.Unmarshal([]byte(encryption), &encryptionConfig); err != nil {
+		return err
 	}
-
-	if err = s.putBucketPolicyWithRetries(bucketDetails, bucketName); err != nil {
-		return "", err
+	putEncryptionInput := &s3.PutBucketEncryptionInput{
+		Bucket:                            aws.String(bucketName),
+		ServerSideEncryptionConfiguration: &encryptionConfig,
+	}
+	s.logger.Debug("put-bucket-encryption", lager.Data{"input": putEncryptionInput})
+	putEncryptionOutput, err := awsretry.Call(ctx, s.retry.For("PutBucketEncryption"), func() (*s3.PutBucketEncryptionOutput, error) {
+		return s.client(ctx, bucketName).PutBucketEncryption(ctx, putEncryptionInput)
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return convertError(err)
 	}
+	s.logger.Debug("put-bucket-encryption", lager.Data{"output": putEncryptionOutput})
 
-	return aws.StringValue(createBucketOutput.Location), nil
+	return nil
 }
 
-// checkDeletePublicAccessBlock checks the Policy of bucketDetails to see if the bucket
-// is intended to be public. If so, it deletes the Public Access Block that is set on all
-// new S3 buckets by default as of April 2023.
-func (s *S3Bucket) checkDeletePublicAccessBlock(bucketDetails BucketDetails, bucketName string) error {
-	// buckets with no policy are private by default.
-	if bucketDetails.Policy == "" {
+// verifyAdoptableBucket checks that an existing bucket we own was created for
+// the same service instance. A bucket without an instance tag is assumed to be
+// left over from a provision that failed before tagging.
+func (s *S3Bucket) verifyAdoptableBucket(ctx context.Context, bucketName string, bucketDetails BucketDetails) error {
+	if s.skipBucketTagging {
+		s.logger.Info("adopt-bucket", lager.Data{"bucket": bucketName, "reason": "store has no bucket tags"})
 		return nil
 	}
+	instanceGUID := bucketDetails.Tags[brokertags.ServiceInstanceGUIDTagKey]
 
-	var policy bucketPolicy
-	err := json.Unmarshal([]byte(bucketDetails.Policy), &policy)
+	getTaggingInput := &s3.GetBucketTaggingInput{
+		Bucket: aws.String(bucketName),
+	}
+	s.logger.Debug("get-bucket-tagging", lager.Data{"input": getTaggingInput})
+	getTaggingOutput, err := awsretry.Call(ctx, s.retry.For("GetBucketTagging"), func() (*s3.GetBucketTaggingOutput, error) {
+		return s.client(ctx, bucketName).GetBucketTagging(ctx, getTaggingInput)
+	})
 	if err != nil {
+		if errorCode(err) == "NoSuchTagSet" {
+			s.logger.Info("adopt-bucket", lager.Data{"bucket": bucketName, "reason": "bucket has no tags"})
+			return nil
+		}
 		s.logger.Error("aws-s3-error", err)
 		return err
 	}
-	if len(policy.Statement) > 1 {
-		err = fmt.Errorf("expected 1 policy statement, got %v", len(policy.Statement))
-		s.logger.Error("aws-s3-error", err)
-		return err
+
+	for _, tag := range getTaggingOutput.TagSet {
+		if aws.ToString(tag.Key) != brokertags.ServiceInstanceGUIDTagKey {
+			continue
+		}
+		if existing := aws.ToString(tag.Value); instanceGUID != "" && existing != instanceGUID {
+			return fmt.Errorf("bucket %s already exists for service instance %s", bucketName, existing)
+		}
+	}
+
+	s.logger.Info("adopt-bucket", lager.Data{"bucket": bucketName})
+	return nil
+}
+
+// IsPublicReadPolicy reports whether a bucket policy of one statement lets
+// anyone get the bucket's objects, which the public access block that S3
+// sets on new buckets would otherwise prevent.
+func IsPublicReadPolicy(policy string) (bool, error) {
+	var parsed bucketPolicy
+	if err := json.Unmarshal([]byte(policy), &parsed); err != nil {
+		return false, err
+	}
+	if len(parsed.Statement) > 1 {
+		return false, fmt.Errorf("expected 1 policy statement, got %v", len(parsed.Statement))
 	}
 
 	publicAccessPolicy := bucketPolicyStatement{
@@ -165,55 +419,69 @@ func (s *S3Bucket) checkDeletePublicAccessBlock(bucketDetails BucketDetails, buc
 		Principal: "*",
 		Action:    []string{"s3:GetObject"},
 	}
-	if slices.ContainsFunc(policy.Statement, func(statement bucketPolicyStatement) bool {
+	return slices.ContainsFunc(parsed.Statement, func(statement bucketPolicyStatement) bool {
 		return statement.Effect == publicAccessPolicy.Effect &&
 			statement.Principal == publicAccessPolicy.Principal &&
 			slices.Equal(statement.Action, publicAccessPolicy.Action)
-	}) {
+	}), nil
+}
+
+// checkDeletePublicAccessBlock checks the Policy of bucketDetails to see if the bucket
+// is intended to be public. If so, it deletes the Public Access Block that is set on all
+// new S3 buckets by default as of April 2023.
+func (s *S3Bucket) checkDeletePublicAccessBlock(ctx context.Context, bucketDetails BucketDetails, bucketName string) error {
+	// buckets with no policy are private by default, and S3-compatible
+	// stores have no public access block.
+	if bucketDetails.Policy == "" || s.endpoint != nil {
+		return nil
+	}
+
+	public, err := IsPublicReadPolicy(bucketDetails.Policy)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return err
+	}
+	if public {
 		deletePublicAccessBlockInput := &s3.DeletePublicAccessBlockInput{
 			Bucket: aws.String(bucketName),
 		}
 		s.logger.Debug("delete-public-access-block", lager.Data{"input": deletePublicAccessBlockInput})
-		_, err := s.s3svc.DeletePublicAccessBlock(deletePublicAccessBlockInput)
+		_, err := awsretry.Call(ctx, s.retry.For("DeletePublicAccessBlock"), func() (*s3.DeletePublicAccessBlockOutput, error) {
+			return s.client(ctx, bucketName).DeletePublicAccessBlock(ctx, deletePublicAccessBlockInput)
+		})
 		if err != nil {
 			s.logger.Error("failed to delete public access block", err)
 			return err
 		}
 
-		isDeleted, err := s.checkIsPublicAccessBlockDeleted(bucketName)
-		if err != nil {
-			s.logger.Error("failed to get public access block", err)
-			return err
-		}
-		retries := 0
-		maxRetries := 10
-		for !isDeleted && retries < maxRetries {
-			retries += 1
-
-			time.Sleep(1 * time.Second)
-
-			isDeleted, err = s.checkIsPublicAccessBlockDeleted(bucketName)
-
+		attempts, err := awsretry.Do(ctx, s.retry.For("GetPublicAccessBlock"), isPublicAccessBlockPresent, func() error {
+			isDeleted, err := s.checkIsPublicAccessBlockDeleted(ctx, bucketName)
 			if err != nil {
-				s.logger.Error("failed to get public access block", err)
 				return err
 			}
-		}
-		if retries == maxRetries {
-			s.logger.Info(fmt.Sprintf("could not verify that public access block was deleted for bucket %s, gave up after %d retries", bucketName, retries))
+			if !isDeleted {
+				return errPublicAccessBlockPresent
+			}
+			return nil
+		})
+		if err == errPublicAccessBlockPresent {
+			s.logger.Info(fmt.Sprintf("could not verify that public access block was deleted for bucket %s, gave up after %d attempts", bucketName, attempts))
+		} else if err != nil {
+			s.logger.Error("failed to get public access block", err)
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (s *S3Bucket) checkIsPublicAccessBlockDeleted(bucketName string) (bool, error) {
+func (s *S3Bucket) checkIsPublicAccessBlockDeleted(ctx context.Context, bucketName string) (bool, error) {
 	getPublicAccessBlockInput := &s3.GetPublicAccessBlockInput{
 		Bucket: aws.String(bucketName),
 	}
-	_, err := s.s3svc.GetPublicAccessBlock(getPublicAccessBlockInput)
-	if awsErr, ok := err.(awserr.Error); ok {
-		if awsErr.Code() == "NoSuchPublicAccessBlockConfiguration" {
+	_, err := s.client(ctx, bucketName).GetPublicAccessBlock(ctx, getPublicAccessBlockInput)
+	if err != nil {
+		if errorCode(err) == "NoSuchPublicAccessBlockConfiguration" {
 			return true, nil
 		}
 		return false, err
@@ -221,66 +489,254 @@ func (s *S3Bucket) checkIsPublicAccessBlockDeleted(bucketName string) (bool, err
 	return false, nil
 }
 
-func (s *S3Bucket) Modify(bucketName string, bucketDetails BucketDetails) error {
-	// TODO Implement modify
-	return nil
+func (s *S3Bucket) Delete(ctx context.Context, bucketName string, deleteObjects bool) error {
+	if deleteObjects {
+		contentDeleteErr := s.deleteBucketContents(ctx, bucketName, nil)
+		if contentDeleteErr != nil {
+			return convertError(contentDeleteErr)
+		}
+	} else {
+		if err := s.checkBucketEmpty(ctx, bucketName); err != nil {
+			return convertError(err)
+		}
+	}
+	return s.deleteBucket(ctx, bucketName)
 }
 
-func (s *S3Bucket) Delete(bucketName string, deleteObjects bool) error {
+// DeleteWithProgress deletes the bucket and all of its objects like Delete,
+// first counting the objects and then calling progress after each batch is
+// deleted. Counting stops after maxCountedObjects, so that a large bucket's
+// deletion does not wait on listing all of it first.
+func (s *S3Bucket) DeleteWithProgress(ctx context.Context, bucketName string, progress func(DeleteProgress)) error {
+	total, truncated, err := s.countObjects(ctx, bucketName)
+	if err != nil {
+		if err := handleDeleteError(err); err != nil {
+			s.logger.Error("aws-s3-count-objects-error", err)
+			return convertError(err)
+		}
+	}
+	deleted := DeleteProgress{Total: total, Truncated: truncated}
+	progress(deleted)
+
+	if err := s.deleteBucketContents(ctx, bucketName, func(count int) {
+		deleted.Deleted += int64(count)
+		progress(deleted)
+	}); err != nil {
+		return convertError(err)
+	}
+	return s.deleteBucket(ctx, bucketName)
+}
+
+func (s *S3Bucket) deleteBucket(ctx context.Context, bucketName string) error {
 	deleteBucketInput := &s3.DeleteBucketInput{
 		Bucket: aws.String(bucketName),
 	}
 	s.logger.Debug("delete-bucket", lager.Data{"input": deleteBucketInput})
-	if deleteObjects {
-		contentDeleteErr := s.deleteBucketContents(bucketName)
-		if contentDeleteErr != nil {
-			return contentDeleteErr
-		}
-	}
-	deleteBucketOutput, err := s.s3svc.DeleteBucket(deleteBucketInput)
+	deleteBucketOutput, err := awsretry.Call(ctx, s.retry.For("DeleteBucket"), func() (*s3.DeleteBucketOutput, error) {
+		return s.client(ctx, bucketName).DeleteBucket(ctx, deleteBucketInput)
+	})
 	if err != nil {
 		s.logger.Error("aws-s3-delete-bucket-error", err)
+		if isBucketNotEmptyError(err) {
+			// Objects were written between the check and the delete.
+			count, truncated, _ := s.countObjects(ctx, bucketName)
+			return &BucketNotEmptyError{BucketName: bucketName, ObjectCount: count, Truncated: truncated}
+		}
 		if err := handleDeleteError(err); err != nil {
-			return err
+			return convertError(err)
 		}
 	}
 	s.logger.Debug("delete-bucket", lager.Data{"output": deleteBucketOutput})
+	s.setBucketRegion(bucketName, "")
+
+	if err := s.checkpoints.DeleteCheckpoint(bucketName); err != nil {
+		s.logger.Error("delete-checkpoint", err, lager.Data{"bucket": bucketName})
+	}
 
 	return nil
 }
 
-func (s *S3Bucket) deleteBucketContents(bucketName string) error {
-	iter := s3manager.NewDeleteListIterator(s.s3svc.(*s3.S3), &s3.ListObjectsInput{
-		Bucket: aws.String(bucketName),
+// checkBucketEmpty returns a BucketNotEmptyError if the bucket still holds objects.
+// A missing bucket is not an error here; DeleteBucket will report it.
+func (s *S3Bucket) checkBucketEmpty(ctx context.Context, bucketName string) error {
+	count, truncated, err := s.countObjects(ctx, bucketName)
+	if err != nil {
+		if isNoSuchBucketError(err) {
+			return nil
+		}
+		s.logger.Error("aws-s3-count-objects-error", err)
+		return err
+	}
+	if count > 0 {
+		return &BucketNotEmptyError{BucketName: bucketName, ObjectCount: count, Truncated: truncated}
+	}
+	return nil
+}
+
+// maxCountedObjects is how many object versions countObjects counts before it
+// stops listing, so that counting a large bucket takes about ten
+// ListObjectVersions calls rather than one per thousand versions.
+const maxCountedObjects = 10_000
+
+// errCountedObjects stops listing once countObjects has counted
+// maxCountedObjects.
+var errCountedObjects = errors.New("counted enough object versions")
+
+// countObjects counts the bucket's object versions and delete markers, which
+// all keep S3 from deleting it. Unversioned buckets have one version of each
+// object. It reports truncated if it stopped counting at maxCountedObjects
+// with more left to count.
+func (s *S3Bucket) countObjects(ctx context.Context, bucketName string) (count int64, truncated bool, err error) {
+	err = s.listObjectVersions(ctx, bucketName, func(objects []types.ObjectIdentifier) error {
+		if count >= maxCountedObjects {
+			return errCountedObjects
+		}
+		count += int64(len(objects))
+		return nil
 	})
+	if errors.Is(err, errCountedObjects) {
+		return count, true, nil
+	}
+	return count, false, err
+}
 
-	if err := s3manager.NewBatchDeleteWithClient(s.s3svc.(*s3.S3)).Delete(aws.BackgroundContext(), iter); err != nil {
-		s.logger.Error("aws-s3-delete-bucket-contents-error", err)
-		if err := handleDeleteError(err); err != nil {
+// listObjectVersions calls page with each page of the bucket's object
+// versions and delete markers, up to 1000 of them, identified by key and
+// version ID. Pages are listed after page returns, so it may delete them.
+func (s *S3Bucket) listObjectVersions(ctx context.Context, bucketName string, page func([]types.ObjectIdentifier) error) error {
+	listObjectVersionsInput := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucketName),
+	}
+	for {
+		s.logger.Debug("list-object-versions", lager.Data{"input": listObjectVersionsInput})
+		output, err := awsretry.Call(ctx, s.retry.For("ListObjectVersions"), func() (*s3.ListObjectVersionsOutput, error) {
+			return s.client(ctx, bucketName).ListObjectVersions(ctx, listObjectVersionsInput)
+		})
+		if err != nil {
+			return err
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, len(output.Versions)+len(output.DeleteMarkers))
+		for _, version := range output.Versions {
+			objects = append(objects, types.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+		}
+		for _, marker := range output.DeleteMarkers {
+			objects = append(objects, types.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+		}
+		if len(objects) > 0 {
+			if err := page(objects); err != nil {
+				return err
+			}
+		}
+
+		if !aws.ToBool(output.IsTruncated) {
+			return nil
+		}
+		listObjectVersionsInput.KeyMarker = output.NextKeyMarker
+		listObjectVersionsInput.VersionIdMarker = output.NextVersionIdMarker
+	}
+}
+
+// deleteBucketContents deletes every object version and delete marker in the
+// bucket, so that versioned buckets can be deleted too, in batches of up to
+// 1000, the maximum accepted by DeleteObjects. If deleted is not nil, it is
+// called with the size of each deleted batch.
+func (s *S3Bucket) deleteBucketContents(ctx context.Context, bucketName string, deleted func(int)) error {
+	err := s.listObjectVersions(ctx, bucketName, func(objects []types.ObjectIdentifier) error {
+		if err := s.deleteObjects(ctx, bucketName, objects); err != nil {
 			return err
 		}
+		if deleted != nil {
+			deleted(len(objects))
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("aws-s3-delete-bucket-contents-error", err)
+		return handleDeleteError(err)
+	}
+	return nil
+}
+
+func (s *S3Bucket) deleteObjects(ctx context.Context, bucketName string, objects []types.ObjectIdentifier) error {
+	deleteObjectsInput := &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucketName),
+		Delete: &types.Delete{
+			Objects: objects,
+			Quiet:   aws.Bool(true),
+		},
+	}
+	s.logger.Debug("delete-objects", lager.Data{"bucket": bucketName, "count": len(objects)})
+
+	deleteObjectsOutput, err := awsretry.Call(ctx, s.retry.For("DeleteObjects"), func() (*s3.DeleteObjectsOutput, error) {
+		return s.client(ctx, bucketName).DeleteObjects(ctx, deleteObjectsInput)
+	})
+	if err != nil {
+		return err
+	}
+	if len(deleteObjectsOutput.Errors) > 0 {
+		failed := deleteObjectsOutput.Errors[0]
+		return fmt.Errorf(
+			"failed to delete %d objects from bucket %s: %s: %s",
+			len(deleteObjectsOutput.Errors),
+			bucketName,
+			aws.ToString(failed.Code),
+			aws.ToString(failed.Message),
+		)
 	}
 	return nil
 }
 
 func (s3 *S3Bucket) buildBucketDetails(bucketName, region, partition string, attributes map[string]string) BucketDetails {
-	return BucketDetails{
-		BucketName:   bucketName,
-		Region:       region,
-		ARN:          fmt.Sprintf("arn:%s:s3:::%s", partition, bucketName),
-		FIPSEndpoint: fmt.Sprintf("s3-fips.%s.amazonaws.com", region),
+	if endpoint := s3.endpoint; endpoint != nil {
+		bucketURL := endpoint.Scheme + "://" + bucketName + "." + endpoint.Host
+		if s3.pathStyle {
+			bucketURL = endpoint.Scheme + "://" + endpoint.Host + "/" + bucketName
+		}
+		return BucketDetails{
+			BucketName:       bucketName,
+			Region:           region,
+			ARN:              fmt.Sprintf("arn:%s:s3:::%s", partition, bucketName),
+			RegionalEndpoint: endpoint.Host,
+			VirtualHostedURL: bucketURL,
+			URI:              fmt.Sprintf("s3://%s", bucketName),
+		}
+	}
+
+	awsPartition, _ := awspartition.Lookup(partition)
+	details := BucketDetails{
+		BucketName:            bucketName,
+		Region:                region,
+		ARN:                   awsPartition.ARN("s3", "", "", bucketName),
+		RegionalEndpoint:      awsPartition.S3Endpoint(region),
+		DualStackEndpoint:     awsPartition.S3DualStackEndpoint(region),
+		FIPSEndpoint:          awsPartition.S3FIPSEndpoint(region),
+		FIPSDualStackEndpoint: awsPartition.S3FIPSDualStackEndpoint(region),
+		VirtualHostedURL:      fmt.Sprintf("https://%s.%s", bucketName, awsPartition.S3Endpoint(region)),
+		URI:                   fmt.Sprintf("s3://%s", bucketName),
 	}
+	if details.DualStackEndpoint != "" {
+		details.DualStackURL = fmt.Sprintf("https://%s.%s", bucketName, details.DualStackEndpoint)
+	}
+	return details
 }
 
 func (s *S3Bucket) buildCreateBucketInput(bucketName string, bucketDetails BucketDetails) *s3.CreateBucketInput {
 	createBucketInput := &s3.CreateBucketInput{
 		Bucket:          aws.String(bucketName),
-		ObjectOwnership: aws.String(bucketDetails.ObjectOwnership),
+		ObjectOwnership: types.ObjectOwnership(bucketDetails.ObjectOwnership),
+	}
+	// Buckets in us-east-1 are created without a location constraint.
+	if bucketDetails.Region != "" && bucketDetails.Region != "us-east-1" {
+		createBucketInput.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(bucketDetails.Region),
+		}
 	}
 	return createBucketInput
 }
 
 func (s *S3Bucket) putBucketPolicyWithRetries(
+	ctx context.Context,
 	bucketDetails BucketDetails,
 	bucketName string,
 ) error {
@@ -288,45 +744,61 @@ func (s *S3Bucket) putBucketPolicyWithRetries(
 		return nil
 	}
 
-	bucketDetails.BucketName = bucketName
-	tmpl, err := template.New("policy").Parse(bucketDetails.Policy)
+	policy, err := s.renderBucketPolicy(bucketName, bucketDetails)
 	if err != nil {
-		s.logger.Error("aws-s3-error", err)
-		return err
-	}
-
-	policy := bytes.Buffer{}
-	err = tmpl.Execute(&policy, bucketDetails)
-	if err != nil {
-		s.logger.Error("aws-s3-error", err)
 		return err
 	}
 
 	putPolicyInput := &s3.PutBucketPolicyInput{
-		Bucket: aws.String(bucketDetails.BucketName),
-		Policy: aws.String(policy.String()),
+		Bucket: aws.String(bucketName),
+		Policy: aws.String(policy),
 	}
 	s.logger.Debug("put-bucket-policy", lager.Data{"input": putPolicyInput})
 
-	putPolicyOutput, err := s.s3svc.PutBucketPolicy(putPolicyInput)
-	retries := 0
-	maxRetries := 10
-	for err != nil && retries < maxRetries {
-		retries += 1
+	var putPolicyOutput *s3.PutBucketPolicyOutput
+	attempts, err := awsretry.Do(ctx, s.retry.For("PutBucketPolicy"), isAccessDeniedException, func() error {
+		var err error
+		putPolicyOutput, err = s.client(ctx, bucketName).PutBucketPolicy(ctx, putPolicyInput)
+		if err != nil {
+			s.logger.Error("aws-s3-error putting bucket policy", err)
+		}
+		return err
+	})
+	if err != nil {
+		if isAccessDeniedException(err) {
+			s.logger.Info(fmt.Sprintf("could not put policy for bucket %s, gave up after %d attempts", bucketName, attempts))
+		}
+		return err
+	}
 
-		s.logger.Error("aws-s3-error putting bucket policy", err)
+	s.logger.Debug("put-bucket-policy", lager.Data{"output": putPolicyOutput})
+	return nil
+}
 
-		if !isAccessDeniedException(err) {
-			return err
-		}
-		putPolicyOutput, err = s.s3svc.PutBucketPolicy(putPolicyInput)
+// renderBucketPolicy executes the bucket policy template of bucketDetails.
+func (s *S3Bucket) renderBucketPolicy(bucketName string, bucketDetails BucketDetails) (string, error) {
+	bucketDetails.BucketName = bucketName
+	policy, err := RenderBucketPolicy(bucketDetails)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return "", err
 	}
-	if retries == maxRetries {
-		s.logger.Info(fmt.Sprintf("could not put policy for bucket %s, gave up after %d retries", bucketName, retries))
+	return policy, nil
+}
+
+// RenderBucketPolicy executes the bucket policy template of bucketDetails,
+// which sees the bucket's details as its data.
+func RenderBucketPolicy(bucketDetails BucketDetails) (string, error) {
+	tmpl, err := template.New("policy").Parse(bucketDetails.Policy)
+	if err != nil {
+		return "", err
 	}
 
-	s.logger.Debug("put-bucket-policy", lager.Data{"output": putPolicyOutput})
-	return err
+	policy := bytes.Buffer{}
+	if err := tmpl.Execute(&policy, bucketDetails); err != nil {
+		return "", err
+	}
+	return policy.String(), nil
 }
 
 func handleDeleteError(err error) error {
@@ -336,33 +808,26 @@ func handleDeleteError(err error) error {
 	return err
 }
 
-func isBatchDeleteNoBucketError(batchErr awserr.Error) bool {
-	origErr := batchErr.OrigErr()
-	if origBatchErrs, origAwsOk := origErr.(s3manager.Errors); origAwsOk {
-		if len(origBatchErrs) > 1 {
-			return false
-		}
-		origBatchErr := origBatchErrs[0]
-		if origBatchErr.OrigErr != nil {
-			return isNoSuchBucketError(origBatchErr.OrigErr)
-		}
-	}
-	return false
+func isNoSuchBucketError(err error) bool {
+	return errorCode(err) == "NoSuchBucket"
 }
 
-func isNoSuchBucketError(err error) bool {
-	if awsErr, ok := err.(awserr.Error); ok {
-		if _, batchOk := awsErr.(*s3manager.BatchError); batchOk {
-			return isBatchDeleteNoBucketError(awsErr)
-		}
-		return awsErr.Code() == "NoSuchBucket"
-	}
-	return false
+// errPublicAccessBlockPresent signals that a deleted public access block is
+// still being reported by S3.
+var errPublicAccessBlockPresent = errors.New("public access block still present")
+
+func isPublicAccessBlockPresent(err error) bool {
+	return err == errPublicAccessBlockPresent
+}
+
+func isBucketAlreadyOwnedByYouError(err error) bool {
+	return errorCode(err) == "BucketAlreadyOwnedByYou"
+}
+
+func isBucketNotEmptyError(err error) bool {
+	return errorCode(err) == "BucketNotEmpty"
 }
 
 func isAccessDeniedException(err error) bool {
-	if awsErr, ok := err.(awserr.Error); ok {
-		return awsErr.Code() == "AccessDenied"
-	}
-	return false
+	return errorCode(err) == "AccessDenied"
 }
@@ -1,65 +1,354 @@
 package awss3
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
 	"testing"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/cloud-gov/s3-broker/awsretry"
+	"github.com/cloud-gov/s3-broker/state"
 )
 
 type MockS3Client struct {
+	bucketLocation          types.BucketLocationConstraint
+	getBucketLocationCalls  int
+	createBucketCalls       int
+	createBucketInput       *s3.CreateBucketInput
+	waitBucketExistsCalls   int
+	waitBucketExistsErr     error
+	createBucketErr         error
+	putBucketEncryptionErrs []error
+	bucketTags              map[string]string
+	getBucketTagsErr        error
+	putBucketTagsCalls      int
+	putBucketTags           map[string]string
+	bucketTagsDeleted       bool
+	ownedBuckets            []string
+
 	deletePublicAccessBlockCalled    bool
 	numPutBucketPolicyCalls          int
 	numPutBucketPolicyCallsShouldErr int
 	putBucketPolicyErr               error
+	bucketPolicy                     string
+	bucketPolicyDeleted              bool
+	// concurrentBucketPolicies are written over the policy that each
+	// PutBucketPolicy puts, in turn, as by another request at the same time.
+	concurrentBucketPolicies []string
+
+	objects        []string
+	putObjects     []string
+	deletedObjects []string
+	// noncurrentVersions and deleteMarkers are the keys of a versioned
+	// bucket's older versions and delete markers, which ListObjectVersions
+	// lists with objects. DeleteBucket fails until every version that it
+	// lists has been deleted.
+	noncurrentVersions []string
+	deleteMarkers      []string
+	deletedVersions    map[string]bool
+	deleteBucketCalled bool
+	listObjectsErr     error
+	deleteObjectsErr   error
+	deleteObjectsFails bool
+
+	versioningStatus string
+	encryption       *types.ServerSideEncryptionConfiguration
+	objectOwnership  string
+	corsRules        []types.CORSRule
+	corsDeleted      bool
+	lifecycleRules   []types.LifecycleRule
+	lifecycleDeleted bool
 }
 
-func (c *MockS3Client) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
-	return nil, nil
+func (c *MockS3Client) GetBucketLocation(ctx context.Context, input *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	c.getBucketLocationCalls++
+	return &s3.GetBucketLocationOutput{LocationConstraint: c.bucketLocation}, nil
 }
 
-func (c *MockS3Client) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+func (c *MockS3Client) CreateBucket(ctx context.Context, input *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
+	c.createBucketCalls++
+	c.createBucketInput = input
+	if c.createBucketErr != nil {
+		return nil, c.createBucketErr
+	}
 	location := fmt.Sprint("/", *input.Bucket)
 	return &s3.CreateBucketOutput{
 		Location: &location,
 	}, nil
 }
 
-func (c *MockS3Client) PutBucketTagging(input *s3.PutBucketTaggingInput) (*s3.PutBucketTaggingOutput, error) {
+func (c *MockS3Client) GetBucketTagging(ctx context.Context, input *s3.GetBucketTaggingInput, optFns ...func(*s3.Options)) (*s3.GetBucketTaggingOutput, error) {
+	if c.getBucketTagsErr != nil {
+		return nil, c.getBucketTagsErr
+	}
+	output := &s3.GetBucketTaggingOutput{}
+	for key, value := range c.bucketTags {
+		output.TagSet = append(output.TagSet, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return output, nil
+}
+
+func (c *MockS3Client) HeadBucket(ctx context.Context, input *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	c.waitBucketExistsCalls++
+	if c.waitBucketExistsErr != nil {
+		return nil, c.waitBucketExistsErr
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (c *MockS3Client) PutBucketTagging(ctx context.Context, input *s3.PutBucketTaggingInput, optFns ...func(*s3.Options)) (*s3.PutBucketTaggingOutput, error) {
+	c.putBucketTagsCalls++
+	c.putBucketTags = make(map[string]string)
+	for _, tag := range input.Tagging.TagSet {
+		c.putBucketTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
 	return &s3.PutBucketTaggingOutput{}, nil
 }
 
-func (c *MockS3Client) PutBucketEncryption(input *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
+func (c *MockS3Client) DeleteBucketTagging(ctx context.Context, input *s3.DeleteBucketTaggingInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketTaggingOutput, error) {
+	c.bucketTagsDeleted = true
+	return &s3.DeleteBucketTaggingOutput{}, nil
+}
+
+func (c *MockS3Client) ListBuckets(ctx context.Context, input *s3.ListBucketsInput, optFns ...func(*s3.Options)) (*s3.ListBucketsOutput, error) {
+	output := &s3.ListBucketsOutput{}
+	for _, name := range c.ownedBuckets {
+		output.Buckets = append(output.Buckets, types.Bucket{Name: aws.String(name)})
+	}
+	return output, nil
+}
+
+func (c *MockS3Client) PutBucketEncryption(ctx context.Context, input *s3.PutBucketEncryptionInput, optFns ...func(*s3.Options)) (*s3.PutBucketEncryptionOutput, error) {
+	if len(c.putBucketEncryptionErrs) > 0 {
+		err := c.putBucketEncryptionErrs[0]
+		c.putBucketEncryptionErrs = c.putBucketEncryptionErrs[1:]
+		return nil, err
+	}
 	return &s3.PutBucketEncryptionOutput{}, nil
 }
 
-func (c *MockS3Client) PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutBucketPolicyOutput, error) {
+func (c *MockS3Client) PutBucketPolicy(ctx context.Context, input *s3.PutBucketPolicyInput, optFns ...func(*s3.Options)) (*s3.PutBucketPolicyOutput, error) {
 	c.numPutBucketPolicyCalls++
 	if c.numPutBucketPolicyCalls <= c.numPutBucketPolicyCallsShouldErr {
 		return nil, c.putBucketPolicyErr
 	}
+	c.bucketPolicy = aws.ToString(input.Policy)
+	if len(c.concurrentBucketPolicies) > 0 {
+		c.bucketPolicy = c.concurrentBucketPolicies[0]
+		c.concurrentBucketPolicies = c.concurrentBucketPolicies[1:]
+	}
 	return &s3.PutBucketPolicyOutput{}, nil
 }
 
-func (c *MockS3Client) DeletePublicAccessBlock(input *s3.DeletePublicAccessBlockInput) (*s3.DeletePublicAccessBlockOutput, error) {
+func (c *MockS3Client) GetBucketPolicy(ctx context.Context, input *s3.GetBucketPolicyInput, optFns ...func(*s3.Options)) (*s3.GetBucketPolicyOutput, error) {
+	if c.bucketPolicy == "" {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchBucketPolicy", Message: "The bucket policy does not exist"}
+	}
+	return &s3.GetBucketPolicyOutput{Policy: aws.String(c.bucketPolicy)}, nil
+}
+
+func (c *MockS3Client) DeleteBucketPolicy(ctx context.Context, input *s3.DeleteBucketPolicyInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketPolicyOutput, error) {
+	c.bucketPolicy = ""
+	c.bucketPolicyDeleted = true
+	return &s3.DeleteBucketPolicyOutput{}, nil
+}
+
+func (c *MockS3Client) GetBucketEncryption(ctx context.Context, input *s3.GetBucketEncryptionInput, optFns ...func(*s3.Options)) (*s3.GetBucketEncryptionOutput, error) {
+	if c.encryption == nil {
+		return nil, &smithy.GenericAPIError{Code: "ServerSideEncryptionConfigurationNotFoundError", Message: "The server side encryption configuration was not found"}
+	}
+	return &s3.GetBucketEncryptionOutput{ServerSideEncryptionConfiguration: c.encryption}, nil
+}
+
+func (c *MockS3Client) GetBucketOwnershipControls(ctx context.Context, input *s3.GetBucketOwnershipControlsInput, optFns ...func(*s3.Options)) (*s3.GetBucketOwnershipControlsOutput, error) {
+	if c.objectOwnership == "" {
+		return nil, &smithy.GenericAPIError{Code: "OwnershipControlsNotFoundError", Message: "The bucket ownership controls were not found"}
+	}
+	return &s3.GetBucketOwnershipControlsOutput{OwnershipControls: &types.OwnershipControls{
+		Rules: []types.OwnershipControlsRule{{ObjectOwnership: types.ObjectOwnership(c.objectOwnership)}},
+	}}, nil
+}
+
+func (c *MockS3Client) GetBucketCors(ctx context.Context, input *s3.GetBucketCorsInput, optFns ...func(*s3.Options)) (*s3.GetBucketCorsOutput, error) {
+	if c.corsRules == nil {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchCORSConfiguration", Message: "The CORS configuration does not exist"}
+	}
+	return &s3.GetBucketCorsOutput{CORSRules: c.corsRules}, nil
+}
+
+func (c *MockS3Client) GetBucketLifecycleConfiguration(ctx context.Context, input *s3.GetBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	if c.lifecycleRules == nil {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchLifecycleConfiguration", Message: "The lifecycle configuration does not exist"}
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: c.lifecycleRules}, nil
+}
+
+func (c *MockS3Client) GetBucketVersioning(ctx context.Context, input *s3.GetBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	return &s3.GetBucketVersioningOutput{Status: types.BucketVersioningStatus(c.versioningStatus)}, nil
+}
+
+func (c *MockS3Client) PutBucketVersioning(ctx context.Context, input *s3.PutBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.PutBucketVersioningOutput, error) {
+	c.versioningStatus = string(input.VersioningConfiguration.Status)
+	return &s3.PutBucketVersioningOutput{}, nil
+}
+
+func (c *MockS3Client) PutBucketCors(ctx context.Context, input *s3.PutBucketCorsInput, optFns ...func(*s3.Options)) (*s3.PutBucketCorsOutput, error) {
+	c.corsRules = input.CORSConfiguration.CORSRules
+	return &s3.PutBucketCorsOutput{}, nil
+}
+
+func (c *MockS3Client) DeleteBucketCors(ctx context.Context, input *s3.DeleteBucketCorsInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketCorsOutput, error) {
+	c.corsRules = nil
+	c.corsDeleted = true
+	return &s3.DeleteBucketCorsOutput{}, nil
+}
+
+func (c *MockS3Client) PutBucketLifecycleConfiguration(ctx context.Context, input *s3.PutBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	c.lifecycleRules = input.LifecycleConfiguration.Rules
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+func (c *MockS3Client) DeleteBucketLifecycle(ctx context.Context, input *s3.DeleteBucketLifecycleInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketLifecycleOutput, error) {
+	c.lifecycleRules = nil
+	c.lifecycleDeleted = true
+	return &s3.DeleteBucketLifecycleOutput{}, nil
+}
+
+// presignClient signs requests offline with static credentials.
+var presignClient = s3.NewPresignClient(s3.New(s3.Options{
+	Region:      "us-east-1",
+	Credentials: credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", "SECRET", ""),
+}))
+
+func (c *MockS3Client) PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	c.putObjects = append(c.putObjects, aws.ToString(input.Key))
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (c *MockS3Client) DeletePublicAccessBlock(ctx context.Context, input *s3.DeletePublicAccessBlockInput, optFns ...func(*s3.Options)) (*s3.DeletePublicAccessBlockOutput, error) {
 	c.deletePublicAccessBlockCalled = true
 	return &s3.DeletePublicAccessBlockOutput{}, nil
 }
 
-func (c *MockS3Client) DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+func (c *MockS3Client) DeleteBucket(ctx context.Context, input *s3.DeleteBucketInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketOutput, error) {
+	c.deleteBucketCalled = true
+	for _, version := range c.objectVersions() {
+		if !c.deletedVersions[aws.ToString(version.Key)+"@"+aws.ToString(version.VersionId)] {
+			return nil, &smithy.GenericAPIError{Code: "BucketNotEmpty", Message: "The bucket you tried to delete is not empty"}
+		}
+	}
 	return nil, nil
 }
 
-func (c *MockS3Client) GetPublicAccessBlock(input *s3.GetPublicAccessBlockInput) (*s3.GetPublicAccessBlockOutput, error) {
-	noPublicAccessBlockErr := awserr.New("NoSuchPublicAccessBlockConfiguration", "The public access block configuration was not found", errors.New("fail"))
+func (c *MockS3Client) GetPublicAccessBlock(ctx context.Context, input *s3.GetPublicAccessBlockInput, optFns ...func(*s3.Options)) (*s3.GetPublicAccessBlockOutput, error) {
+	noPublicAccessBlockErr := &smithy.GenericAPIError{Code: "NoSuchPublicAccessBlockConfiguration", Message: "The public access block configuration was not found"}
 	return &s3.GetPublicAccessBlockOutput{}, noPublicAccessBlockErr
 }
 
+func (c *MockS3Client) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if c.listObjectsErr != nil {
+		return nil, c.listObjectsErr
+	}
+	// Like S3, list up to 1000 objects per page. Each object's size is the
+	// length of its key, and the continuation token is the index of the
+	// page's first object.
+	const pageSize = 1000
+	start, _ := strconv.Atoi(aws.ToString(input.ContinuationToken))
+	end := min(start+pageSize, len(c.objects))
+	page := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(end < len(c.objects))}
+	for _, key := range c.objects[start:end] {
+		page.Contents = append(page.Contents, types.Object{Key: aws.String(key), Size: aws.Int64(int64(len(key)))})
+	}
+	if end < len(c.objects) {
+		page.NextContinuationToken = aws.String(strconv.Itoa(end))
+	}
+	return page, nil
+}
+
+// objectVersions returns the bucket's objects, noncurrent versions and delete
+// markers, with the version IDs "current", "noncurrent" and "marker".
+func (c *MockS3Client) objectVersions() []types.ObjectIdentifier {
+	var versions []types.ObjectIdentifier
+	for _, key := range c.objects {
+		versions = append(versions, types.ObjectIdentifier{Key: aws.String(key), VersionId: aws.String("current")})
+	}
+	for _, key := range c.noncurrentVersions {
+		versions = append(versions, types.ObjectIdentifier{Key: aws.String(key), VersionId: aws.String("noncurrent")})
+	}
+	for _, key := range c.deleteMarkers {
+		versions = append(versions, types.ObjectIdentifier{Key: aws.String(key), VersionId: aws.String("marker")})
+	}
+	return versions
+}
+
+func (c *MockS3Client) ListObjectVersions(ctx context.Context, input *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	if c.listObjectsErr != nil {
+		return nil, c.listObjectsErr
+	}
+	// Like S3, list up to 1000 versions and delete markers per page. The key
+	// marker is the index of the page's first version.
+	const pageSize = 1000
+	versions := c.objectVersions()
+	start, _ := strconv.Atoi(aws.ToString(input.KeyMarker))
+	end := min(start+pageSize, len(versions))
+	page := &s3.ListObjectVersionsOutput{IsTruncated: aws.Bool(end < len(versions))}
+	for _, version := range versions[start:end] {
+		if aws.ToString(version.VersionId) == "marker" {
+			page.DeleteMarkers = append(page.DeleteMarkers, types.DeleteMarkerEntry{Key: version.Key, VersionId: version.VersionId})
+			continue
+		}
+		page.Versions = append(page.Versions, types.ObjectVersion{Key: version.Key, VersionId: version.VersionId})
+	}
+	if end < len(versions) {
+		page.NextKeyMarker = aws.String(strconv.Itoa(end))
+		page.NextVersionIdMarker = aws.String("next")
+	}
+	return page, nil
+}
+
+func (c *MockS3Client) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	if c.deleteObjectsErr != nil {
+		return nil, c.deleteObjectsErr
+	}
+	output := &s3.DeleteObjectsOutput{}
+	for _, object := range input.Delete.Objects {
+		if c.deleteObjectsFails {
+			output.Errors = append(output.Errors, types.Error{
+				Key:     object.Key,
+				Code:    aws.String("AccessDenied"),
+				Message: aws.String("access denied"),
+			})
+			continue
+		}
+		c.deletedObjects = append(c.deletedObjects, aws.ToString(object.Key))
+		if c.deletedVersions == nil {
+			c.deletedVersions = map[string]bool{}
+		}
+		c.deletedVersions[aws.ToString(object.Key)+"@"+aws.ToString(object.VersionId)] = true
+	}
+	return output, nil
+}
+
+// testRetryConfig keeps backoff short so retry tests run quickly.
+var testRetryConfig = awsretry.Policy{
+	Config: awsretry.Config{
+		InitialDelay: time.Millisecond,
+		MaxDelay:     time.Millisecond,
+	},
+}
+
 var publicPolicy = `{
   "Version": "2012-10-17",
   "Statement": [
@@ -77,6 +366,7 @@ func TestCreate(t *testing.T) {
 		Name                                string
 		BucketName                          string
 		BucketDetails                       BucketDetails
+		Config                              Config
 		Location                            string
 		Error                               error
 		expectDeletePublicAccessBlockCalled bool
@@ -100,19 +390,32 @@ func TestCreate(t *testing.T) {
 			Error:                               nil,
 			expectDeletePublicAccessBlockCalled: true,
 		},
+		{
+			Name:       "public bucket in an S3-compatible store",
+			BucketName: "b",
+			BucketDetails: BucketDetails{
+				Policy: publicPolicy,
+			},
+			Config:   Config{Endpoint: &url.URL{Scheme: "https", Host: "minio.example.com:9000"}},
+			Location: "/b",
+			Error:    nil,
+		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.Name, func(t *testing.T) {
 			mocks3Client := &MockS3Client{}
-			b := NewS3Bucket(mocks3Client, lager.NewLogger("test"))
-			location, err := b.Create(tc.BucketName, tc.BucketDetails)
+			b := NewS3Bucket(mocks3Client, lager.NewLogger("test"), tc.Config)
+			location, err := b.Create(context.Background(), tc.BucketName, tc.BucketDetails)
 			if location != tc.Location {
 				t.Errorf("expected location %v, got %v", tc.Location, location)
 			}
 			if !errors.Is(err, tc.Error) {
 				t.Errorf("expected return error %v, got %v", tc.Error, err)
 			}
+			if mocks3Client.waitBucketExistsCalls != 1 {
+				t.Errorf("expected to wait for the bucket once, got %d", mocks3Client.waitBucketExistsCalls)
+			}
 			if tc.expectDeletePublicAccessBlockCalled != mocks3Client.deletePublicAccessBlockCalled {
 				t.Errorf("expected public access called: %v, got: %v", tc.expectDeletePublicAccessBlockCalled, mocks3Client.deletePublicAccessBlockCalled)
 			}
@@ -120,8 +423,373 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestCreateResumesFromCheckpoint(t *testing.T) {
+	encryptionErr := errors.New("encryption failure")
+	mocks3Client := &MockS3Client{
+		putBucketEncryptionErrs: []error{encryptionErr},
+	}
+	checkpoints := NewMemoryCheckpointStore()
+	b := NewS3Bucket(mocks3Client, lager.NewLogger("test"), Config{
+		CheckpointStore:     checkpoints,
+		RetainFailedBuckets: true,
+	})
+	details := BucketDetails{Encryption: "{}"}
+
+	_, err := b.Create(context.Background(), "b", details)
+	var stepErr *CreateStepError
+	if !errors.As(err, &stepErr) {
+		t.Fatalf("expected CreateStepError, got %v", err)
+	}
+	if stepErr.Step != CreateStepEncryption {
+		t.Errorf("expected failed step %s, got %s", CreateStepEncryption, stepErr.Step)
+	}
+	if !errors.Is(err, encryptionErr) {
+		t.Errorf("expected error to wrap %v, got %v", encryptionErr, err)
+	}
+	if step, _ := checkpoints.GetCheckpoint("b"); step != CreateStepTagging {
+		t.Errorf("expected checkpoint %s, got %s", CreateStepTagging, step)
+	}
+
+	location, err := b.Create(context.Background(), "b", details)
+	if err != nil {
+		t.Fatalf("expected resumed create to succeed, got %v", err)
+	}
+	if location != "/b" {
+		t.Errorf("expected location /b, got %s", location)
+	}
+	if mocks3Client.createBucketCalls != 1 {
+		t.Errorf("expected CreateBucket to be called once, got %d", mocks3Client.createBucketCalls)
+	}
+	if mocks3Client.putBucketTagsCalls != 1 {
+		t.Errorf("expected PutBucketTagging to be called once, got %d", mocks3Client.putBucketTagsCalls)
+	}
+	if step, _ := checkpoints.GetCheckpoint("b"); step != "" {
+		t.Errorf("expected checkpoint to be cleared, got %s", step)
+	}
+}
+
+func TestCreateResumesFromStateCheckpoint(t *testing.T) {
+	mocks3Client := &MockS3Client{
+		putBucketEncryptionErrs: []error{errors.New("encryption failure")},
+	}
+	store := state.NewMemoryStore()
+	config := Config{CheckpointStore: NewStateCheckpointStore(store), RetainFailedBuckets: true}
+	details := BucketDetails{Encryption: "{}"}
+
+	if _, err := NewS3Bucket(mocks3Client, lager.NewLogger("test"), config).Create(context.Background(), "b", details); err == nil {
+		t.Fatal("expected create to fail")
+	}
+
+	// A broker started again with the same state resumes the create.
+	config.CheckpointStore = NewStateCheckpointStore(store)
+	if _, err := NewS3Bucket(mocks3Client, lager.NewLogger("test"), config).Create(context.Background(), "b", details); err != nil {
+		t.Fatalf("expected resumed create to succeed, got %v", err)
+	}
+	if mocks3Client.createBucketCalls != 1 || mocks3Client.putBucketTagsCalls != 1 {
+		t.Errorf("expected the completed steps to be skipped, got %d CreateBucket and %d PutBucketTagging calls", mocks3Client.createBucketCalls, mocks3Client.putBucketTagsCalls)
+	}
+	if _, err := store.GetRecord(context.Background(), checkpointRecordKind, "b"); !errors.Is(err, state.ErrRecordNotFound) {
+		t.Errorf("expected checkpoint to be cleared, got %v", err)
+	}
+}
+
+func TestCreateWaitsForBucket(t *testing.T) {
+	waitErr := &smithy.GenericAPIError{Code: "Forbidden", Message: "forbidden"}
+	mocks3Client := &MockS3Client{waitBucketExistsErr: waitErr}
+	b := NewS3Bucket(mocks3Client, lager.NewLogger("test"), Config{})
+
+	_, err := b.Create(context.Background(), "b", BucketDetails{})
+	var stepErr *CreateStepError
+	if !errors.As(err, &stepErr) || stepErr.Step != CreateStepWaitBucketExists {
+		t.Fatalf("expected failure at step %s, got %v", CreateStepWaitBucketExists, err)
+	}
+	if mocks3Client.putBucketTagsCalls != 0 {
+		t.Errorf("expected no configuration calls before the bucket exists")
+	}
+}
+
+func TestCreateRollsBackOnFailure(t *testing.T) {
+	encryptionErr := errors.New("encryption failure")
+
+	cases := map[string]struct {
+		s3Client         *MockS3Client
+		retain           bool
+		expectRolledBack bool
+		expectOrphaned   bool
+	}{
+		"deletes the new bucket": {
+			s3Client:         &MockS3Client{putBucketEncryptionErrs: []error{encryptionErr}},
+			expectRolledBack: true,
+		},
+		"keeps a bucket that holds objects": {
+			s3Client: &MockS3Client{
+				putBucketEncryptionErrs: []error{encryptionErr},
+				objects:                 []string{"a"},
+			},
+			expectRolledBack: false,
+			expectOrphaned:   true,
+		},
+		"retains the bucket": {
+			s3Client:         &MockS3Client{putBucketEncryptionErrs: []error{encryptionErr}},
+			retain:           true,
+			expectRolledBack: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			checkpoints := NewMemoryCheckpointStore()
+			b := NewS3Bucket(tc.s3Client, lager.NewLogger("test"), Config{CheckpointStore: checkpoints, RetainFailedBuckets: tc.retain})
+			_, err := b.Create(context.Background(), "b", BucketDetails{Encryption: "{}"})
+
+			var stepErr *CreateStepError
+			if !errors.As(err, &stepErr) {
+				t.Fatalf("expected CreateStepError, got %v", err)
+			}
+			if stepErr.RolledBack != tc.expectRolledBack {
+				t.Errorf("expected rolled back: %v, got: %v", tc.expectRolledBack, stepErr.RolledBack)
+			}
+			if stepErr.Orphaned != tc.expectOrphaned {
+				t.Errorf("expected orphaned: %v, got: %v", tc.expectOrphaned, stepErr.Orphaned)
+			}
+			if tc.s3Client.deleteBucketCalled != tc.expectRolledBack {
+				t.Errorf("expected delete bucket called: %v, got: %v", tc.expectRolledBack, tc.s3Client.deleteBucketCalled)
+			}
+			step, _ := checkpoints.GetCheckpoint("b")
+			if tc.expectRolledBack && step != "" {
+				t.Errorf("expected checkpoint to be cleared after rollback, got %s", step)
+			}
+			if !tc.expectRolledBack && step != CreateStepTagging {
+				t.Errorf("expected checkpoint %s to be kept, got %s", CreateStepTagging, step)
+			}
+		})
+	}
+}
+
+func TestCreateAdoptsExistingBucket(t *testing.T) {
+	ownedErr := &smithy.GenericAPIError{Code: "BucketAlreadyOwnedByYou", Message: "already owned"}
+	details := BucketDetails{
+		Tags: map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance-1"},
+	}
+
+	cases := map[string]struct {
+		s3Client       *MockS3Client
+		expectErr      bool
+		expectLocation string
+	}{
+		"adopts bucket tagged for the same instance": {
+			s3Client: &MockS3Client{
+				createBucketErr: ownedErr,
+				bucketTags:      map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance-1"},
+			},
+			expectLocation: "/b",
+		},
+		"adopts untagged bucket": {
+			s3Client: &MockS3Client{
+				createBucketErr:  ownedErr,
+				getBucketTagsErr: &smithy.GenericAPIError{Code: "NoSuchTagSet", Message: "no tags"},
+			},
+			expectLocation: "/b",
+		},
+		"refuses bucket tagged for another instance": {
+			s3Client: &MockS3Client{
+				createBucketErr: ownedErr,
+				bucketTags:      map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance-2"},
+			},
+			expectErr: true,
+		},
+		"fails on other create errors": {
+			s3Client: &MockS3Client{
+				createBucketErr: &smithy.GenericAPIError{Code: "BucketAlreadyExists", Message: "taken"},
+			},
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			b := NewS3Bucket(tc.s3Client, lager.NewLogger("test"), Config{})
+			location, err := b.Create(context.Background(), "b", details)
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if location != tc.expectLocation {
+				t.Errorf("expected location %q, got %q", tc.expectLocation, location)
+			}
+			if !tc.expectErr && tc.s3Client.putBucketTagsCalls != 1 {
+				t.Errorf("expected adopted bucket to be re-tagged")
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	noSuchBucketErr := &smithy.GenericAPIError{Code: "NoSuchBucket", Message: "no such bucket"}
+	listErr := errors.New("list failure")
+
+	cases := map[string]struct {
+		deleteObjects        bool
+		s3Client             *MockS3Client
+		expectErr            bool
+		expectDeletedObjects []string
+		expectDeleteBucket   bool
+		expectNotEmpty       int64
+	}{
+		"empty bucket": {
+			deleteObjects:      true,
+			s3Client:           &MockS3Client{},
+			expectDeleteBucket: true,
+		},
+		"deletes contents": {
+			deleteObjects:        true,
+			s3Client:             &MockS3Client{objects: []string{"a", "b"}},
+			expectDeletedObjects: []string{"a", "b"},
+			expectDeleteBucket:   true,
+		},
+		"refuses non-empty bucket when deleteObjects is false": {
+			deleteObjects:  false,
+			s3Client:       &MockS3Client{objects: []string{"a", "b"}},
+			expectErr:      true,
+			expectNotEmpty: 2,
+		},
+		"deletes empty bucket when deleteObjects is false": {
+			deleteObjects:      false,
+			s3Client:           &MockS3Client{},
+			expectDeleteBucket: true,
+		},
+		"bucket already gone": {
+			deleteObjects:      true,
+			s3Client:           &MockS3Client{listObjectsErr: noSuchBucketErr},
+			expectDeleteBucket: true,
+		},
+		"list failure": {
+			deleteObjects: true,
+			s3Client:      &MockS3Client{listObjectsErr: listErr},
+			expectErr:     true,
+		},
+		"per-object delete failure": {
+			deleteObjects: true,
+			s3Client:      &MockS3Client{objects: []string{"a"}, deleteObjectsFails: true},
+			expectErr:     true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			b := NewS3Bucket(tc.s3Client, lager.NewLogger("test"), Config{})
+			err := b.Delete(context.Background(), "b", tc.deleteObjects)
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectErr, err)
+			}
+			if !slices.Equal(tc.s3Client.deletedObjects, tc.expectDeletedObjects) {
+				t.Errorf("expected deleted objects %v, got %v", tc.expectDeletedObjects, tc.s3Client.deletedObjects)
+			}
+			if tc.expectNotEmpty > 0 {
+				var notEmptyErr *BucketNotEmptyError
+				if !errors.As(err, &notEmptyErr) || !errors.Is(err, ErrBucketNotEmpty) {
+					t.Fatalf("expected BucketNotEmptyError, got %v", err)
+				}
+				if notEmptyErr.ObjectCount != tc.expectNotEmpty {
+					t.Errorf("expected object count %d, got %d", tc.expectNotEmpty, notEmptyErr.ObjectCount)
+				}
+			}
+			if tc.s3Client.deleteBucketCalled != tc.expectDeleteBucket {
+				t.Errorf("expected delete bucket called: %v, got: %v", tc.expectDeleteBucket, tc.s3Client.deleteBucketCalled)
+			}
+		})
+	}
+}
+
+func TestDeleteWithProgress(t *testing.T) {
+	client := &MockS3Client{objects: []string{"a", "b", "c"}}
+	b := NewS3Bucket(client, lager.NewLogger("test"), Config{})
+
+	var progress []DeleteProgress
+	err := b.DeleteWithProgress(context.Background(), "b", func(p DeleteProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []DeleteProgress{{Deleted: 0, Total: 3}, {Deleted: 3, Total: 3}}
+	if !slices.Equal(progress, expected) {
+		t.Errorf("expected progress %v, got %v", expected, progress)
+	}
+	if !client.deleteBucketCalled {
+		t.Errorf("expected the bucket to be deleted")
+	}
+}
+
+func TestDeleteVersionedBucket(t *testing.T) {
+	client := &MockS3Client{}
+	b := NewS3Bucket(client, lager.NewLogger("test"), Config{})
+	if _, err := b.Create(context.Background(), "b", BucketDetails{Versioning: true}); err != nil {
+		t.Fatalf("unexpected create error: %s", err)
+	}
+	if client.versioningStatus != string(types.BucketVersioningStatusEnabled) {
+		t.Fatalf("expected versioning to be enabled, got %q", client.versioningStatus)
+	}
+
+	// Overwriting a and deleting b leave a noncurrent version of each and a
+	// delete marker for b, and many versions take several pages to list.
+	client.objects = []string{"a"}
+	client.noncurrentVersions = []string{"a", "b"}
+	client.deleteMarkers = []string{"b"}
+	for i := range 1500 {
+		client.noncurrentVersions = append(client.noncurrentVersions, fmt.Sprintf("old-%d", i))
+	}
+
+	if err := b.Delete(context.Background(), "b", true); err != nil {
+		t.Fatalf("unexpected delete error: %s", err)
+	}
+	for _, version := range client.objectVersions() {
+		if name := aws.ToString(version.Key) + "@" + aws.ToString(version.VersionId); !client.deletedVersions[name] {
+			t.Errorf("expected version %s to be deleted", name)
+		}
+	}
+}
+
+func TestDeleteVersionedBucketWithProgress(t *testing.T) {
+	client := &MockS3Client{objects: []string{"a"}, noncurrentVersions: []string{"a"}, deleteMarkers: []string{"b"}}
+	b := NewS3Bucket(client, lager.NewLogger("test"), Config{})
+
+	var progress []DeleteProgress
+	err := b.DeleteWithProgress(context.Background(), "b", func(p DeleteProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []DeleteProgress{{Deleted: 0, Total: 3}, {Deleted: 3, Total: 3}}
+	if !slices.Equal(progress, expected) {
+		t.Errorf("expected progress %v, got %v", expected, progress)
+	}
+}
+
+func TestDeleteWithProgressCountLimit(t *testing.T) {
+	client := &MockS3Client{}
+	for i := range maxCountedObjects + 500 {
+		client.objects = append(client.objects, fmt.Sprintf("object-%d", i))
+	}
+	b := NewS3Bucket(client, lager.NewLogger("test"), Config{})
+
+	var progress []DeleteProgress
+	err := b.DeleteWithProgress(context.Background(), "b", func(p DeleteProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first := progress[0]; first != (DeleteProgress{Total: maxCountedObjects, Truncated: true}) {
+		t.Errorf("expected counting to stop at %d objects, got %+v", maxCountedObjects, first)
+	}
+	if last := progress[len(progress)-1]; last.Deleted != maxCountedObjects+500 {
+		t.Errorf("expected all %d objects to be deleted, got %+v", maxCountedObjects+500, last)
+	}
+}
+
 func TestPutBucketPolicyWithRetries(t *testing.T) {
-	accessDeniedErr := awserr.New("AccessDenied", "access denied", errors.New("original error"))
+	accessDeniedErr := &smithy.GenericAPIError{Code: "AccessDenied", Message: "access denied"}
 	unexpectedErr := errors.New("failure")
 
 	cases := []struct {
@@ -154,7 +822,7 @@ func TestPutBucketPolicyWithRetries(t *testing.T) {
 			Error:                           nil,
 			expectedNumPutBucketPolicyCalls: 11,
 			s3Client: &MockS3Client{
-				putBucketPolicyErr:               awserr.New("AccessDenied", "access denied", errors.New("original error")),
+				putBucketPolicyErr:               &smithy.GenericAPIError{Code: "AccessDenied", Message: "access denied"},
 				numPutBucketPolicyCallsShouldErr: 10,
 			},
 		},
@@ -190,8 +858,8 @@ func TestPutBucketPolicyWithRetries(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.Name, func(t *testing.T) {
-			b := NewS3Bucket(tc.s3Client, lager.NewLogger("test"))
-			err := b.putBucketPolicyWithRetries(tc.BucketDetails, tc.BucketName)
+			b := NewS3Bucket(tc.s3Client, lager.NewLogger("test"), Config{Retry: testRetryConfig})
+			err := b.putBucketPolicyWithRetries(context.Background(), tc.BucketDetails, tc.BucketName)
 			if !errors.Is(err, tc.Error) {
 				t.Fatalf("expected return error %v, got %v", tc.Error, err)
 			}
@@ -202,8 +870,148 @@ func TestPutBucketPolicyWithRetries(t *testing.T) {
 	}
 }
 
+func TestIsPublicReadPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		policy    string
+		expected  bool
+		expectErr bool
+	}{
+		"public read": {
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": ["s3:GetObject"], "Resource": ["arn:aws:s3:::bucket/*"]}]}`,
+			expected: true,
+		},
+		"private": {
+			policy: `{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": ["s3:*"], "Resource": ["arn:aws:s3:::bucket/*"]}]}`,
+		},
+		"several statements": {
+			policy:    `{"Statement": [{"Effect": "Allow"}, {"Effect": "Deny"}]}`,
+			expectErr: true,
+		},
+		"invalid JSON": {
+			policy:    `{"Statement": `,
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			public, err := IsPublicReadPolicy(tc.policy)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error to be %t, got %v", tc.expectErr, err)
+			}
+			if public != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, public)
+			}
+		})
+	}
+}
+
+func TestBuildBucketDetails(t *testing.T) {
+	testCases := map[string]struct {
+		region    string
+		partition string
+		config    Config
+		expected  BucketDetails
+	}{
+		"commercial": {
+			region:    "us-east-1",
+			partition: "aws",
+			expected: BucketDetails{
+				BucketName:            "bucket1",
+				Region:                "us-east-1",
+				ARN:                   "arn:aws:s3:::bucket1",
+				RegionalEndpoint:      "s3.us-east-1.amazonaws.com",
+				DualStackEndpoint:     "s3.dualstack.us-east-1.amazonaws.com",
+				FIPSEndpoint:          "s3-fips.us-east-1.amazonaws.com",
+				VirtualHostedURL:      "https://bucket1.s3.us-east-1.amazonaws.com",
+				FIPSDualStackEndpoint: "s3-fips.dualstack.us-east-1.amazonaws.com",
+				DualStackURL:          "https://bucket1.s3.dualstack.us-east-1.amazonaws.com",
+				URI:                   "s3://bucket1",
+			},
+		},
+		"china": {
+			region:    "cn-north-1",
+			partition: "aws-cn",
+			expected: BucketDetails{
+				BucketName:        "bucket1",
+				Region:            "cn-north-1",
+				ARN:               "arn:aws-cn:s3:::bucket1",
+				RegionalEndpoint:  "s3.cn-north-1.amazonaws.com.cn",
+				DualStackEndpoint: "s3.dualstack.cn-north-1.amazonaws.com.cn",
+				VirtualHostedURL:  "https://bucket1.s3.cn-north-1.amazonaws.com.cn",
+				DualStackURL:      "https://bucket1.s3.dualstack.cn-north-1.amazonaws.com.cn",
+				URI:               "s3://bucket1",
+			},
+		},
+		"govcloud": {
+			region:    "us-gov-west-1",
+			partition: "aws-us-gov",
+			expected: BucketDetails{
+				BucketName:            "bucket1",
+				Region:                "us-gov-west-1",
+				ARN:                   "arn:aws-us-gov:s3:::bucket1",
+				RegionalEndpoint:      "s3.us-gov-west-1.amazonaws.com",
+				DualStackEndpoint:     "s3.dualstack.us-gov-west-1.amazonaws.com",
+				FIPSEndpoint:          "s3-fips.us-gov-west-1.amazonaws.com",
+				VirtualHostedURL:      "https://bucket1.s3.us-gov-west-1.amazonaws.com",
+				FIPSDualStackEndpoint: "s3-fips.dualstack.us-gov-west-1.amazonaws.com",
+				DualStackURL:          "https://bucket1.s3.dualstack.us-gov-west-1.amazonaws.com",
+				URI:                   "s3://bucket1",
+			},
+		},
+		"commercial region without FIPS endpoints": {
+			region:    "eu-west-1",
+			partition: "aws",
+			expected: BucketDetails{
+				BucketName:        "bucket1",
+				Region:            "eu-west-1",
+				ARN:               "arn:aws:s3:::bucket1",
+				RegionalEndpoint:  "s3.eu-west-1.amazonaws.com",
+				DualStackEndpoint: "s3.dualstack.eu-west-1.amazonaws.com",
+				VirtualHostedURL:  "https://bucket1.s3.eu-west-1.amazonaws.com",
+				DualStackURL:      "https://bucket1.s3.dualstack.eu-west-1.amazonaws.com",
+				URI:               "s3://bucket1",
+			},
+		},
+		"custom endpoint": {
+			region:    "us-east-1",
+			partition: "aws",
+			config:    Config{Endpoint: &url.URL{Scheme: "http", Host: "minio.example.com:9000"}},
+			expected: BucketDetails{
+				BucketName:       "bucket1",
+				Region:           "us-east-1",
+				ARN:              "arn:aws:s3:::bucket1",
+				RegionalEndpoint: "minio.example.com:9000",
+				VirtualHostedURL: "http://bucket1.minio.example.com:9000",
+				URI:              "s3://bucket1",
+			},
+		},
+		"custom endpoint with path-style addressing": {
+			region:    "us-east-1",
+			partition: "aws",
+			config:    Config{Endpoint: &url.URL{Scheme: "https", Host: "rgw.example.com"}, PathStyle: true},
+			expected: BucketDetails{
+				BucketName:       "bucket1",
+				Region:           "us-east-1",
+				ARN:              "arn:aws:s3:::bucket1",
+				RegionalEndpoint: "rgw.example.com",
+				VirtualHostedURL: "https://rgw.example.com/bucket1",
+				URI:              "s3://bucket1",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s3Bucket := NewS3Bucket(&MockS3Client{}, lager.NewLogger("s3-bucket-test"), tc.config)
+			details := s3Bucket.buildBucketDetails("bucket1", tc.region, tc.partition, nil)
+			if !reflect.DeepEqual(details, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, details)
+			}
+		})
+	}
+}
+
 func TestIsAccessDeniedException(t *testing.T) {
-	isAccessDenied := isAccessDeniedException(awserr.New("AccessDenied", "access denied", errors.New("original error")))
+	isAccessDenied := isAccessDeniedException(&smithy.GenericAPIError{Code: "AccessDenied", Message: "access denied"})
 	if !isAccessDenied {
 		t.Fatal("expected isAccessDeniedException() to return true")
 	}
@@ -223,19 +1031,15 @@ func TestIsNoSuchBucketError(t *testing.T) {
 			expectIsNoSuchBucketErr: false,
 		},
 		"AWS NoSuchBucket error": {
-			inputErr:                awserr.New("NoSuchBucket", "no such bucket", errors.New("original error")),
+			inputErr:                &smithy.GenericAPIError{Code: "NoSuchBucket", Message: "no such bucket"},
 			expectIsNoSuchBucketErr: true,
 		},
 		"AWS random error": {
-			inputErr:                awserr.New("RandomError", "access denied", errors.New("original error")),
+			inputErr:                &smithy.GenericAPIError{Code: "RandomError", Message: "access denied"},
 			expectIsNoSuchBucketErr: false,
 		},
-		"AWS request failure error": {
-			inputErr: awserr.NewRequestFailure(
-				awserr.New("NoSuchBucket", "no such bucket", errors.New("original error")),
-				404,
-				"req-1",
-			),
+		"wrapped AWS operation error": {
+			inputErr:                &smithy.OperationError{ServiceID: "S3", OperationName: "DeleteBucket", Err: &smithy.GenericAPIError{Code: "NoSuchBucket", Message: "no such bucket"}},
 			expectIsNoSuchBucketErr: true,
 		},
 	}
@@ -249,124 +1053,118 @@ func TestIsNoSuchBucketError(t *testing.T) {
 	}
 }
 
-func TestIsBatchDeleteNoBucketError(t *testing.T) {
-	batchDeleteNoSuchBucketErr := s3manager.NewBatchError(
-		"BatchedDeleteIncomplete",
-		"some objects have failed to be deleted.",
-		[]s3manager.Error{
-			{
-				OrigErr: awserr.NewRequestFailure(awserr.New("NoSuchBucket", "specified bucket does not exist", nil), 404, "req-1"),
-				Bucket:  aws.String("bucket"),
-				Key:     aws.String("key"),
-			},
-		},
-	)
-	batchDeleteNoSuchBucketErr2 := s3manager.NewBatchError(
-		"BatchedDeleteIncomplete",
-		"some objects have failed to be deleted.",
-		[]s3manager.Error{
-			{
-				OrigErr: awserr.NewRequestFailure(awserr.New("OtherError", "this is a random error", nil), 500, "req-1"),
-				Bucket:  aws.String("bucket"),
-				Key:     aws.String("key"),
-			},
-			{
-				OrigErr: awserr.NewRequestFailure(awserr.New("NoSuchBucket", "specified bucket does not exist", nil), 404, "req-1"),
-				Bucket:  aws.String("bucket"),
-				Key:     aws.String("key"),
-			},
-		},
-	)
-	batchDeleteOtherErr := s3manager.NewBatchError(
-		"BatchedDeleteIncomplete",
-		"some objects have failed to be deleted.",
-		[]s3manager.Error{
-			{
-				OrigErr: awserr.NewRequestFailure(awserr.New("OtherError", "this is a random error", nil), 500, "req-1"),
-				Bucket:  aws.String("bucket"),
-				Key:     aws.String("key"),
-			},
-		},
-	)
+func TestHandleDeleteError(t *testing.T) {
+	noSuchBucketErr := &smithy.GenericAPIError{Code: "NoSuchBucket", Message: "no such bucket"}
+	awsOtherErr := &smithy.GenericAPIError{Code: "OtherError", Message: "other error"}
+	nonAwsErr := errors.New("random error")
+	operationErr := &smithy.OperationError{ServiceID: "S3", OperationName: "DeleteBucket", Err: &smithy.GenericAPIError{Code: "NoSuchBucket", Message: "no such bucket"}}
 
 	testCases := map[string]struct {
-		inputErr                awserr.Error
-		expectIsNoSuchBucketErr bool
+		inputErr    error
+		expectedErr error
 	}{
-		"batch delete NoSuchBucket first error, expect true": {
-			inputErr:                batchDeleteNoSuchBucketErr,
-			expectIsNoSuchBucketErr: true,
+		"NoSuchBucket error, expect nil": {
+			inputErr: noSuchBucketErr,
 		},
-		"batch delete NoSuchBucket second error, expect false": {
-			inputErr:                batchDeleteNoSuchBucketErr2,
-			expectIsNoSuchBucketErr: false,
+		"other AWS error, expect error": {
+			inputErr:    awsOtherErr,
+			expectedErr: awsOtherErr,
 		},
-		"batch delete other error, expect error": {
-			inputErr:                batchDeleteOtherErr,
-			expectIsNoSuchBucketErr: false,
+		"non-AWS error, expect error": {
+			inputErr:    nonAwsErr,
+			expectedErr: nonAwsErr,
+		},
+		"wrapped operation error, expect nil": {
+			inputErr: operationErr,
 		},
 	}
 
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			isNoSuchBucket := isBatchDeleteNoBucketError(test.inputErr)
-			if isNoSuchBucket != test.expectIsNoSuchBucketErr {
-				t.Fatalf("expected isNoSuchBucketError() to return %t, got: %t", test.expectIsNoSuchBucketErr, isNoSuchBucket)
+			err := handleDeleteError(test.inputErr)
+			if !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected return error %v, got %v", test.expectedErr, err)
 			}
 		})
 	}
 }
 
-func TestHandleDeleteError(t *testing.T) {
-	noSuchBucketErr := awserr.New("NoSuchBucket", "no such bucket", errors.New("original error"))
-	awsOtherErr := awserr.New("OtherError", "other error", errors.New("original error"))
-	nonAwsErr := errors.New("random error")
-	requestFailureErr := awserr.NewRequestFailure(
-		awserr.New("NoSuchBucket", "failed to perform batch operation", errors.New("fail")),
-		404,
-		"req-1",
-	)
-	batchDeleteNoSuchBucketErr := s3manager.NewBatchError(
-		"BatchedDeleteIncomplete",
-		"some objects have failed to be deleted.",
-		[]s3manager.Error{
-			{
-				OrigErr: awserr.NewRequestFailure(awserr.New("NoSuchBucket", "specified bucket does not exist", nil), 404, "req-1"),
-				Bucket:  aws.String("bucket"),
-				Key:     aws.String("key"),
-			},
-		},
-	)
-
+func TestConvertError(t *testing.T) {
 	testCases := map[string]struct {
 		inputErr    error
 		expectedErr error
+		expectCode  string
 	}{
-		"NoSuchBucket error, expect nil": {
-			inputErr: noSuchBucketErr,
+		"NoSuchBucket": {
+			inputErr:    &smithy.GenericAPIError{Code: "NoSuchBucket", Message: "no such bucket"},
+			expectedErr: ErrBucketNotFound,
+			expectCode:  "NoSuchBucket",
 		},
-		"other AWS error, expect error": {
-			inputErr:    awsOtherErr,
-			expectedErr: awsOtherErr,
+		"AccessDenied": {
+			inputErr:    &smithy.OperationError{ServiceID: "S3", OperationName: "DeleteBucket", Err: &smithy.GenericAPIError{Code: "AccessDenied", Message: "access denied"}},
+			expectedErr: ErrAccessDenied,
+			expectCode:  "AccessDenied",
 		},
-		"non-AWS error, expect error": {
-			inputErr:    nonAwsErr,
-			expectedErr: nonAwsErr,
+		"MalformedPolicy": {
+			inputErr:    &smithy.GenericAPIError{Code: "MalformedPolicy", Message: "bad policy"},
+			expectedErr: ErrPolicyInvalid,
+			expectCode:  "MalformedPolicy",
 		},
-		"request failure wrapped error, expect nil": {
-			inputErr: requestFailureErr,
+		"SlowDown": {
+			inputErr:    &smithy.GenericAPIError{Code: "SlowDown", Message: "slow down"},
+			expectedErr: ErrThrottled,
+			expectCode:  "SlowDown",
 		},
-		"batch delete NoSuchBucket error, expect nil": {
-			inputErr: batchDeleteNoSuchBucketErr,
+		"unrecognized AWS error": {
+			inputErr:   &smithy.GenericAPIError{Code: "OtherError", Message: "other"},
+			expectCode: "OtherError",
+		},
+		"non-AWS error": {
+			inputErr:    errors.New("random error"),
+			expectedErr: nil,
 		},
 	}
 
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			err := handleDeleteError(test.inputErr)
-			if !errors.Is(err, test.expectedErr) {
-				t.Errorf("expected return error %v, got %v", test.expectedErr, err)
+			err := convertError(test.inputErr)
+			if !errors.Is(err, test.inputErr) {
+				t.Errorf("expected converted error to wrap %v", test.inputErr)
+			}
+			if test.expectedErr != nil && !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected %v to match %v", err, test.expectedErr)
+			}
+			var typedErr *Error
+			if errors.As(err, &typedErr) != (test.expectCode != "") {
+				t.Fatalf("unexpected conversion of %v", test.inputErr)
+			}
+			if typedErr != nil && typedErr.Code != test.expectCode {
+				t.Errorf("expected code %s, got %s", test.expectCode, typedErr.Code)
 			}
 		})
 	}
 }
+
+func TestCreateStopsRetryingWhenContextCancelled(t *testing.T) {
+	s3Client := &MockS3Client{
+		numPutBucketPolicyCallsShouldErr: 100,
+		putBucketPolicyErr:               &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"},
+	}
+	b := NewS3Bucket(s3Client, lager.NewLogger("test"), Config{
+		RetainFailedBuckets: true,
+		Retry: awsretry.Policy{
+			Config: awsretry.Config{InitialDelay: time.Hour, MaxDelay: time.Hour},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := b.Create(ctx, "b", BucketDetails{Policy: publicPolicy})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if s3Client.numPutBucketPolicyCalls != 1 {
+		t.Errorf("expected a single PutBucketPolicy attempt, got %d", s3Client.numPutBucketPolicyCalls)
+	}
+}
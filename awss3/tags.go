@@ -0,0 +1,57 @@
+package awss3
+
+import (
+	"context"
+	"maps"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// Tags returns the bucket's tags.
+func (s *S3Bucket) Tags(ctx context.Context, bucketName string) (map[string]string, error) {
+	tags, err := s.getBucketTags(ctx, bucketName)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return tags, nil
+}
+
+// AddTags merges tags into the bucket's tags, replacing the values of keys it
+// already has. The bucket is not tagged again if it already has them all.
+func (s *S3Bucket) AddTags(ctx context.Context, bucketName string, tags map[string]string) error {
+	bucketTags, err := s.getBucketTags(ctx, bucketName)
+	if err != nil {
+		return convertError(err)
+	}
+	merged := maps.Clone(bucketTags)
+	maps.Copy(merged, tags)
+	if maps.Equal(merged, bucketTags) {
+		return nil
+	}
+	if err := s.putBucketTagging(ctx, bucketName, merged); err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+// Retain releases a bucket that the broker created without deleting it or
+// its objects. The broker's tags are replaced with tags, which record what
+// the bucket was, so that the broker no longer treats it as an instance's.
+func (s *S3Bucket) Retain(ctx context.Context, bucketName string, tags map[string]string) error {
+	bucketTags, err := s.getBucketTags(ctx, bucketName)
+	if err != nil {
+		return convertError(err)
+	}
+	for _, key := range brokerTagKeys {
+		delete(bucketTags, key)
+	}
+	maps.Copy(bucketTags, tags)
+
+	s.logger.Info("retain-bucket", lager.Data{"bucket": bucketName})
+	if err := s.putBucketTagging(ctx, bucketName, bucketTags); err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return convertError(err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package awss3
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/smithy-go"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddTags(t *testing.T) {
+	testCases := map[string]struct {
+		client     *MockS3Client
+		config     Config
+		tags       map[string]string
+		expectPuts int
+		expectTags map[string]string
+	}{
+		"adds tags to existing tags": {
+			client:     &MockS3Client{bucketTags: map[string]string{"Space GUID": "space-1"}},
+			tags:       map[string]string{"Shared space space-2": "org-1"},
+			expectPuts: 1,
+			expectTags: map[string]string{"Space GUID": "space-1", "Shared space space-2": "org-1"},
+		},
+		"replaces values": {
+			client:     &MockS3Client{bucketTags: map[string]string{"key": "old"}},
+			tags:       map[string]string{"key": "new"},
+			expectPuts: 1,
+			expectTags: map[string]string{"key": "new"},
+		},
+		"tags an untagged bucket": {
+			client:     &MockS3Client{getBucketTagsErr: &smithy.GenericAPIError{Code: "NoSuchTagSet", Message: "no tags"}},
+			tags:       map[string]string{"key": "value"},
+			expectPuts: 1,
+			expectTags: map[string]string{"key": "value"},
+		},
+		"store without bucket tags": {
+			client: &MockS3Client{getBucketTagsErr: &smithy.GenericAPIError{Code: "NotImplemented", Message: "not implemented"}},
+			config: Config{SkipBucketTagging: true},
+			tags:   map[string]string{"key": "value"},
+		},
+		"does not retag a bucket that has the tags": {
+			client: &MockS3Client{bucketTags: map[string]string{"key": "value", "other": "value"}},
+			tags:   map[string]string{"key": "value"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := NewS3Bucket(tc.client, lager.NewLogger("test"), tc.config)
+			if err := b.AddTags(context.Background(), "bucket", tc.tags); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.client.putBucketTagsCalls != tc.expectPuts {
+				t.Errorf("expected %d PutBucketTagging calls, got %d", tc.expectPuts, tc.client.putBucketTagsCalls)
+			}
+			if diff := cmp.Diff(tc.expectTags, tc.client.putBucketTags); diff != "" {
+				t.Errorf("unexpected tags (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRetain(t *testing.T) {
+	client := &MockS3Client{bucketTags: map[string]string{
+		brokertags.ServiceInstanceGUIDTagKey: "instance-1",
+		brokertags.ServiceNameTagKey:         "s3",
+		brokertags.ServicePlanName:           "basic",
+		"Created at":                         "2026-01-01T00:00:00Z",
+		"team":                               "research",
+		"aws:cloudformation:stack-name":      "s3-broker-bucket",
+	}}
+	b := NewS3Bucket(client, lager.NewLogger("test"), Config{})
+	if err := b.Retain(context.Background(), "bucket", map[string]string{"Released at": "2026-02-01T00:00:00Z"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectTags := map[string]string{"team": "research", "Released at": "2026-02-01T00:00:00Z"}
+	if diff := cmp.Diff(expectTags, client.putBucketTags); diff != "" {
+		t.Errorf("unexpected tags (-want +got):\n%s", diff)
+	}
+}
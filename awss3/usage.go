@@ -0,0 +1,45 @@
+package awss3
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxUsageObjects is how many objects Usage counts before it stops listing,
+// so that looking at a large bucket takes at most ten ListObjectsV2 calls.
+const maxUsageObjects = 10_000
+
+// BucketUsage is the number and total size of the current objects in a
+// bucket. If Truncated is set, the bucket holds more objects than were
+// counted.
+type BucketUsage struct {
+	Objects   int64
+	Bytes     int64
+	Truncated bool
+}
+
+// Usage lists the bucket's objects to count them and add up their sizes.
+// Noncurrent versions are not included.
+func (s *S3Bucket) Usage(ctx context.Context, bucketName string) (BucketUsage, error) {
+	var usage BucketUsage
+	paginator := s3.NewListObjectsV2Paginator(s.client(ctx, bucketName), &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return BucketUsage{}, convertError(err)
+		}
+		for _, object := range page.Contents {
+			usage.Objects++
+			usage.Bytes += aws.ToInt64(object.Size)
+		}
+		if usage.Objects >= maxUsageObjects && paginator.HasMorePages() {
+			usage.Truncated = true
+			break
+		}
+	}
+	return usage, nil
+}
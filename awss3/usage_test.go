@@ -0,0 +1,59 @@
+package awss3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUsage(t *testing.T) {
+	manyObjects := make([]string, maxUsageObjects+1500)
+	for i := range manyObjects {
+		manyObjects[i] = fmt.Sprintf("%05d", i)
+	}
+
+	testCases := map[string]struct {
+		client    *MockS3Client
+		expect    BucketUsage
+		expectErr error
+	}{
+		"empty bucket": {
+			client: &MockS3Client{},
+		},
+		"objects": {
+			client: &MockS3Client{objects: []string{"a", "bb", "ccc"}},
+			expect: BucketUsage{Objects: 3, Bytes: 6},
+		},
+		"more objects than are counted": {
+			client: &MockS3Client{objects: manyObjects},
+			expect: BucketUsage{Objects: maxUsageObjects, Bytes: 5 * maxUsageObjects, Truncated: true},
+		},
+		"missing bucket": {
+			client:    &MockS3Client{listObjectsErr: &smithy.GenericAPIError{Code: "NoSuchBucket", Message: "no such bucket"}},
+			expectErr: ErrBucketNotFound,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := NewS3Bucket(tc.client, lager.NewLogger("test"), Config{})
+			usage, err := b.Usage(context.Background(), "bucket")
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(tc.expect, usage); diff != "" {
+				t.Errorf("unexpected usage (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Package awssecrets delivers binding credentials through AWS Secrets
+// Manager instead of returning them to the platform.
+package awssecrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type SecretsManagerClient interface {
+	CreateSecretWithContext(ctx aws.Context, input *secretsmanager.CreateSecretInput, opts ...request.Option) (*secretsmanager.CreateSecretOutput, error)
+	DescribeSecretWithContext(ctx aws.Context, input *secretsmanager.DescribeSecretInput, opts ...request.Option) (*secretsmanager.DescribeSecretOutput, error)
+	PutSecretValueWithContext(ctx aws.Context, input *secretsmanager.PutSecretValueInput, opts ...request.Option) (*secretsmanager.PutSecretValueOutput, error)
+	PutResourcePolicyWithContext(ctx aws.Context, input *secretsmanager.PutResourcePolicyInput, opts ...request.Option) (*secretsmanager.PutResourcePolicyOutput, error)
+	ListSecretsPagesWithContext(ctx aws.Context, input *secretsmanager.ListSecretsInput, fn func(*secretsmanager.ListSecretsOutput, bool) bool, opts ...request.Option) error
+	DeleteSecretWithContext(ctx aws.Context, input *secretsmanager.DeleteSecretInput, opts ...request.Option) (*secretsmanager.DeleteSecretOutput, error)
+}
+
+// Secrets stores secrets that only the given readers may read, updates
+// their values, and deletes them again by tag.
+type Secrets interface {
+	Create(ctx context.Context, name, value string, tags map[string]string, ownerTagKey string, readerARNs []string) (string, error)
+	Update(ctx context.Context, secretARN, value string) error
+	DeleteTagged(ctx context.Context, tagKey, tagValue string) error
+}
+
+// ErrSecretExists is returned by Create for a secret that belongs to
+// someone else.
+var ErrSecretExists = errors.New("a secret with this name already exists")
+
+type SecretsManagerSecrets struct {
+	secretssvc SecretsManagerClient
+	retry      awsretry.Policy
+	logger     lager.Logger
+}
+
+func NewSecretsManagerSecrets(secretssvc SecretsManagerClient, logger lager.Logger, retry awsretry.Policy) *SecretsManagerSecrets {
+	return &SecretsManagerSecrets{
+		secretssvc: secretssvc,
+		retry:      retry,
+		logger:     logger.Session("secrets-manager"),
+	}
+}
+
+type resourcePolicyStatement struct {
+	Effect    string              `json:"Effect"`
+	Principal map[string][]string `json:"Principal"`
+	Action    []string            `json:"Action"`
+	Resource  string              `json:"Resource"`
+}
+
+type resourcePolicy struct {
+	Version   string                    `json:"Version"`
+	Statement []resourcePolicyStatement `json:"Statement"`
+}
+
+// Create stores value as the secret called name and lets readerARNs read it,
+// returning the secret's ARN. An existing secret is only overwritten if its
+// ownerTagKey tag matches tags, so that callers cannot take over each
+// other's secrets. If the secret cannot be shared with its readers, a newly
+// created secret is deleted again.
+func (s *SecretsManagerSecrets) Create(ctx context.Context, name, value string, tags map[string]string, ownerTagKey string, readerARNs []string) (string, error) {
+	secretARN, created, err := s.putSecret(ctx, name, value, tags, ownerTagKey)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.putResourcePolicy(ctx, secretARN, readerARNs); err != nil {
+		if created {
+			if derr := s.deleteSecret(ctx, secretARN); derr != nil {
+				s.logger.Error("create-secret.cleanup", derr, lager.Data{"secret": secretARN})
+			}
+		}
+		return "", err
+	}
+	return secretARN, nil
+}
+
+func (s *SecretsManagerSecrets) putSecret(ctx context.Context, name, value string, tags map[string]string, ownerTagKey string) (string, bool, error) {
+	createSecretInput := &secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		SecretString: aws.String(value),
+		Tags:         secretTags(tags),
+	}
+	s.logger.Debug("create-secret", lager.Data{"name": name})
+	createSecretOutput, err := awsretry.Call(ctx, s.retry.For("CreateSecret"), func() (*secretsmanager.CreateSecretOutput, error) {
+		return s.secretssvc.CreateSecretWithContext(ctx, createSecretInput)
+	})
+	if err == nil {
+		return aws.StringValue(createSecretOutput.ARN), true, nil
+	}
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != secretsmanager.ErrCodeResourceExistsException {
+		s.logger.Error("create-secret.aws-secretsmanager-error", err)
+		return "", false, convertError(err)
+	}
+
+	// The secret exists. Overwrite it only if it is the caller's own, as
+	// when a bind is retried.
+	describeSecretOutput, err := awsretry.Call(ctx, s.retry.For("DescribeSecret"), func() (*secretsmanager.DescribeSecretOutput, error) {
+		return s.secretssvc.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	})
+	if err != nil {
+		s.logger.Error("describe-secret.aws-secretsmanager-error", err)
+		return "", false, convertError(err)
+	}
+	if !hasTag(describeSecretOutput.Tags, ownerTagKey, tags[ownerTagKey]) {
+		return "", false, ErrSecretExists
+	}
+
+	putSecretValueInput := &secretsmanager.PutSecretValueInput{
+		SecretId:     describeSecretOutput.ARN,
+		SecretString: aws.String(value),
+	}
+	s.logger.Debug("put-secret-value", lager.Data{"name": name})
+	_, err = awsretry.Call(ctx, s.retry.For("PutSecretValue"), func() (*secretsmanager.PutSecretValueOutput, error) {
+		return s.secretssvc.PutSecretValueWithContext(ctx, putSecretValueInput)
+	})
+	if err != nil {
+		s.logger.Error("put-secret-value.aws-secretsmanager-error", err)
+		return "", false, convertError(err)
+	}
+	return aws.StringValue(describeSecretOutput.ARN), false, nil
+}
+
+// putResourcePolicy lets readerARNs read the secret. Principals created
+// moments before are not known to Secrets Manager yet, so a malformed policy
+// is retried.
+func (s *SecretsManagerSecrets) putResourcePolicy(ctx context.Context, secretARN string, readerARNs []string) error {
+	policy, err := json.Marshal(resourcePolicy{
+		Version: "2012-10-17",
+		Statement: []resourcePolicyStatement{{
+			Effect:    "Allow",
+			Principal: map[string][]string{"AWS": readerARNs},
+			Action:    []string{"secretsmanager:DescribeSecret", "secretsmanager:GetSecretValue"},
+			Resource:  "*",
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	putResourcePolicyInput := &secretsmanager.PutResourcePolicyInput{
+		SecretId:          aws.String(secretARN),
+		ResourcePolicy:    aws.String(string(policy)),
+		BlockPublicPolicy: aws.Bool(true),
+	}
+	s.logger.Debug("put-resource-policy", lager.Data{"input": putResourcePolicyInput})
+	_, err = awsretry.Do(ctx, s.retry.For("PutResourcePolicy"), isMalformedPolicyDocument, func() error {
+		_, err := s.secretssvc.PutResourcePolicyWithContext(ctx, putResourcePolicyInput)
+		return err
+	})
+	if err != nil {
+		s.logger.Error("put-resource-policy.aws-secretsmanager-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+// Update replaces the value of the secret with ARN secretARN, keeping its
+// readers.
+func (s *SecretsManagerSecrets) Update(ctx context.Context, secretARN, value string) error {
+	putSecretValueInput := &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(secretARN),
+		SecretString: aws.String(value),
+	}
+	s.logger.Debug("put-secret-value", lager.Data{"secret": secretARN})
+	_, err := awsretry.Call(ctx, s.retry.For("PutSecretValue"), func() (*secretsmanager.PutSecretValueOutput, error) {
+		return s.secretssvc.PutSecretValueWithContext(ctx, putSecretValueInput)
+	})
+	if err != nil {
+		s.logger.Error("put-secret-value.aws-secretsmanager-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+// DeleteTagged deletes every secret tagged tagKey=tagValue without a recovery
+// window.
+func (s *SecretsManagerSecrets) DeleteTagged(ctx context.Context, tagKey, tagValue string) error {
+	var secretARNs []string
+	listSecretsInput := &secretsmanager.ListSecretsInput{
+		Filters: []*secretsmanager.Filter{
+			{Key: aws.String(secretsmanager.FilterNameStringTypeTagKey), Values: aws.StringSlice([]string{tagKey})},
+			{Key: aws.String(secretsmanager.FilterNameStringTypeTagValue), Values: aws.StringSlice([]string{tagValue})},
+		},
+	}
+	s.logger.Debug("list-secrets", lager.Data{"input": listSecretsInput})
+	_, err := awsretry.Do(ctx, s.retry.For("ListSecrets"), awsretry.Never, func() error {
+		secretARNs = nil
+		return s.secretssvc.ListSecretsPagesWithContext(ctx, listSecretsInput, func(page *secretsmanager.ListSecretsOutput, lastPage bool) bool {
+			for _, secret := range page.SecretList {
+				// The filters match the key and value separately.
+				if hasTag(secret.Tags, tagKey, tagValue) {
+					secretARNs = append(secretARNs, aws.StringValue(secret.ARN))
+				}
+			}
+			return true
+		})
+	})
+	if err != nil {
+		s.logger.Error("list-secrets.aws-secretsmanager-error", err)
+		return convertError(err)
+	}
+
+	for _, secretARN := range secretARNs {
+		if err := s.deleteSecret(ctx, secretARN); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SecretsManagerSecrets) deleteSecret(ctx context.Context, secretARN string) error {
+	deleteSecretInput := &secretsmanager.DeleteSecretInput{
+		SecretId:                   aws.String(secretARN),
+		ForceDeleteWithoutRecovery: aws.Bool(true),
+	}
+	s.logger.Debug("delete-secret", lager.Data{"input": deleteSecretInput})
+	_, err := awsretry.Call(ctx, s.retry.For("DeleteSecret"), func() (*secretsmanager.DeleteSecretOutput, error) {
+		return s.secretssvc.DeleteSecretWithContext(ctx, deleteSecretInput)
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return nil
+		}
+		s.logger.Error("delete-secret.aws-secretsmanager-error", err)
+		return convertError(err)
+	}
+	return nil
+}
+
+func secretTags(tags map[string]string) []*secretsmanager.Tag {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	secretTags := make([]*secretsmanager.Tag, 0, len(tags))
+	for _, key := range keys {
+		secretTags = append(secretTags, &secretsmanager.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return secretTags
+}
+
+func hasTag(tags []*secretsmanager.Tag, key, value string) bool {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key && aws.StringValue(tag.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isMalformedPolicyDocument(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == secretsmanager.ErrCodeMalformedPolicyDocumentException
+}
+
+func convertError(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return errors.New(awsErr.Code() + ": " + awsErr.Message())
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+package awssecrets
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+const testSecretARN = "arn:aws:secretsmanager:us-east-1:123456789012:secret:cf/my-secret-AbCdEf"
+
+type mockSecretsManagerClient struct {
+	existingTags      []*secretsmanager.Tag
+	putPolicyErrs     []error
+	putPolicyCalls    int
+	resourcePolicy    string
+	secretValue       string
+	created           bool
+	secrets           []*secretsmanager.SecretListEntry
+	deleted           []string
+	deleteNotFoundErr bool
+}
+
+func (c *mockSecretsManagerClient) CreateSecretWithContext(ctx aws.Context, input *secretsmanager.CreateSecretInput, opts ...request.Option) (*secretsmanager.CreateSecretOutput, error) {
+	if c.existingTags != nil {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceExistsException, "secret exists", nil)
+	}
+	c.created = true
+	c.secretValue = aws.StringValue(input.SecretString)
+	return &secretsmanager.CreateSecretOutput{ARN: aws.String(testSecretARN)}, nil
+}
+
+func (c *mockSecretsManagerClient) DescribeSecretWithContext(ctx aws.Context, input *secretsmanager.DescribeSecretInput, opts ...request.Option) (*secretsmanager.DescribeSecretOutput, error) {
+	return &secretsmanager.DescribeSecretOutput{ARN: aws.String(testSecretARN), Tags: c.existingTags}, nil
+}
+
+func (c *mockSecretsManagerClient) PutSecretValueWithContext(ctx aws.Context, input *secretsmanager.PutSecretValueInput, opts ...request.Option) (*secretsmanager.PutSecretValueOutput, error) {
+	c.secretValue = aws.StringValue(input.SecretString)
+	return &secretsmanager.PutSecretValueOutput{}, nil
+}
+
+func (c *mockSecretsManagerClient) PutResourcePolicyWithContext(ctx aws.Context, input *secretsmanager.PutResourcePolicyInput, opts ...request.Option) (*secretsmanager.PutResourcePolicyOutput, error) {
+	c.putPolicyCalls++
+	if c.putPolicyCalls <= len(c.putPolicyErrs) {
+		return nil, c.putPolicyErrs[c.putPolicyCalls-1]
+	}
+	c.resourcePolicy = aws.StringValue(input.ResourcePolicy)
+	return &secretsmanager.PutResourcePolicyOutput{}, nil
+}
+
+func (c *mockSecretsManagerClient) ListSecretsPagesWithContext(ctx aws.Context, input *secretsmanager.ListSecretsInput, fn func(*secretsmanager.ListSecretsOutput, bool) bool, opts ...request.Option) error {
+	fn(&secretsmanager.ListSecretsOutput{SecretList: c.secrets}, true)
+	return nil
+}
+
+func (c *mockSecretsManagerClient) DeleteSecretWithContext(ctx aws.Context, input *secretsmanager.DeleteSecretInput, opts ...request.Option) (*secretsmanager.DeleteSecretOutput, error) {
+	if c.deleteNotFoundErr {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "no secret", nil)
+	}
+	c.deleted = append(c.deleted, aws.StringValue(input.SecretId))
+	return &secretsmanager.DeleteSecretOutput{}, nil
+}
+
+func testRetryPolicy() awsretry.Policy {
+	return awsretry.Policy{Config: awsretry.Config{MaxAttempts: 3, InitialDelay: 1, MaxDelay: 1}}
+}
+
+func TestCreate(t *testing.T) {
+	malformed := awserr.New(secretsmanager.ErrCodeMalformedPolicyDocumentException, "invalid principal", nil)
+	ownTags := []*secretsmanager.Tag{{Key: aws.String("Binding GUID"), Value: aws.String("binding1")}}
+	otherTags := []*secretsmanager.Tag{{Key: aws.String("Binding GUID"), Value: aws.String("binding2")}}
+	testCases := map[string]struct {
+		client        *mockSecretsManagerClient
+		expectErr     string
+		expectDeleted bool
+	}{
+		"creates secret": {
+			client: &mockSecretsManagerClient{},
+		},
+		"retries unknown reader": {
+			client: &mockSecretsManagerClient{putPolicyErrs: []error{malformed}},
+		},
+		"deletes secret when reader stays unknown": {
+			client:        &mockSecretsManagerClient{putPolicyErrs: []error{malformed, malformed, malformed}},
+			expectErr:     "MalformedPolicyDocumentException: invalid principal",
+			expectDeleted: true,
+		},
+		"overwrites own secret": {
+			client: &mockSecretsManagerClient{existingTags: ownTags},
+		},
+		"refuses secret of another binding": {
+			client:    &mockSecretsManagerClient{existingTags: otherTags},
+			expectErr: ErrSecretExists.Error(),
+		},
+		"refuses untagged secret": {
+			client:    &mockSecretsManagerClient{existingTags: []*secretsmanager.Tag{}},
+			expectErr: ErrSecretExists.Error(),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			secrets := NewSecretsManagerSecrets(tc.client, lager.NewLogger("awssecrets-test"), testRetryPolicy())
+			secretARN, err := secrets.Create(
+				context.Background(),
+				"cf/my-secret",
+				`{"access_key_id": "key"}`,
+				map[string]string{"Binding GUID": "binding1"},
+				"Binding GUID",
+				[]string{"arn:aws:iam::123456789012:user/cf-binding1"},
+			)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				if deleted := len(tc.client.deleted) > 0; deleted != tc.expectDeleted {
+					t.Errorf("expected secret deleted %t, got %v", tc.expectDeleted, tc.client.deleted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if secretARN != testSecretARN {
+				t.Errorf("expected ARN %s, got %s", testSecretARN, secretARN)
+			}
+			if tc.client.secretValue != `{"access_key_id": "key"}` {
+				t.Errorf("unexpected secret value %q", tc.client.secretValue)
+			}
+
+			var policy resourcePolicy
+			if err := json.Unmarshal([]byte(tc.client.resourcePolicy), &policy); err != nil {
+				t.Fatalf("invalid resource policy %q: %s", tc.client.resourcePolicy, err)
+			}
+			if readers := policy.Statement[0].Principal["AWS"]; !slices.Equal(readers, []string{"arn:aws:iam::123456789012:user/cf-binding1"}) {
+				t.Errorf("unexpected readers %v", readers)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	client := &mockSecretsManagerClient{}
+	secrets := NewSecretsManagerSecrets(client, lager.NewLogger("awssecrets-test"), testRetryPolicy())
+	if err := secrets.Update(context.Background(), testSecretARN, `{"access_key_id": "key2"}`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if client.secretValue != `{"access_key_id": "key2"}` {
+		t.Errorf("expected the secret's value to be replaced, got %s", client.secretValue)
+	}
+}
+
+func TestDeleteTagged(t *testing.T) {
+	testCases := map[string]struct {
+		client        *mockSecretsManagerClient
+		expectDeleted []string
+	}{
+		"deletes tagged secrets": {
+			client: &mockSecretsManagerClient{secrets: []*secretsmanager.SecretListEntry{
+				{ARN: aws.String("secret-1"), Tags: []*secretsmanager.Tag{{Key: aws.String("Binding GUID"), Value: aws.String("binding1")}}},
+				{ARN: aws.String("secret-2"), Tags: []*secretsmanager.Tag{
+					{Key: aws.String("Binding GUID"), Value: aws.String("binding2")},
+					{Key: aws.String("Other"), Value: aws.String("binding1")},
+				}},
+			}},
+			expectDeleted: []string{"secret-1"},
+		},
+		"secret already deleted": {
+			client: &mockSecretsManagerClient{
+				secrets: []*secretsmanager.SecretListEntry{
+					{ARN: aws.String("secret-1"), Tags: []*secretsmanager.Tag{{Key: aws.String("Binding GUID"), Value: aws.String("binding1")}}},
+				},
+				deleteNotFoundErr: true,
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			secrets := NewSecretsManagerSecrets(tc.client, lager.NewLogger("awssecrets-test"), testRetryPolicy())
+			if err := secrets.DeleteTagged(context.Background(), "Binding GUID", "binding1"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !slices.Equal(tc.client.deleted, tc.expectDeleted) {
+				t.Errorf("expected deleted secrets %v, got %v", tc.expectDeleted, tc.client.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+// Package awssts issues short-lived credentials for bindings that should not
+// receive long-lived IAM access keys.
+package awssts
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type STSClient interface {
+	GetFederationTokenWithContext(ctx aws.Context, input *sts.GetFederationTokenInput, opts ...request.Option) (*sts.GetFederationTokenOutput, error)
+	AssumeRoleWithContext(ctx aws.Context, input *sts.AssumeRoleInput, opts ...request.Option) (*sts.AssumeRoleOutput, error)
+}
+
+// Credentials are temporary AWS credentials. They stop working at Expiration.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// CredentialIssuer issues temporary credentials whose permissions are limited
+// to policy. name and the session tags identify the session in CloudTrail.
+type CredentialIssuer interface {
+	Issue(ctx context.Context, name, policy string, tags map[string]string, ttl time.Duration) (Credentials, error)
+}
+
+const (
+	MethodFederationToken = "federation-token"
+	MethodAssumeRole      = "assume-role"
+)
+
+var (
+	ErrUnknownMethod = errors.New("unknown temporary credentials method")
+
+	invalidNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+)
+
+// NewCredentialIssuer returns the issuer for method. roleARN is only used by
+// MethodAssumeRole.
+func NewCredentialIssuer(method string, stssvc STSClient, roleARN string, retry awsretry.Policy, logger lager.Logger) (CredentialIssuer, error) {
+	switch method {
+	case "", MethodFederationToken:
+		return &FederationTokenIssuer{stssvc: stssvc, retry: retry, logger: logger.Session("sts-federation-token")}, nil
+	case MethodAssumeRole:
+		return &AssumeRoleIssuer{stssvc: stssvc, roleARN: roleARN, retry: retry, logger: logger.Session("sts-assume-role")}, nil
+	default:
+		return nil, ErrUnknownMethod
+	}
+}
+
+// FederationTokenIssuer calls GetFederationToken. The broker must run with
+// IAM user credentials, and the issued credentials can do no more than both
+// the broker user and the session policy allow.
+type FederationTokenIssuer struct {
+	stssvc STSClient
+	retry  awsretry.Policy
+	logger lager.Logger
+}
+
+func (f *FederationTokenIssuer) Issue(ctx context.Context, name, policy string, tags map[string]string, ttl time.Duration) (Credentials, error) {
+	getFederationTokenInput := &sts.GetFederationTokenInput{
+		Name:            aws.String(sessionName(name, 32)),
+		Policy:          aws.String(policy),
+		DurationSeconds: aws.Int64(int64(ttl.Seconds())),
+		Tags:            sessionTags(tags),
+	}
+	f.logger.Debug("get-federation-token", lager.Data{"name": aws.StringValue(getFederationTokenInput.Name), "duration": ttl.String()})
+
+	getFederationTokenOutput, err := awsretry.Call(ctx, f.retry.For("GetFederationToken"), func() (*sts.GetFederationTokenOutput, error) {
+		return f.stssvc.GetFederationTokenWithContext(ctx, getFederationTokenInput)
+	})
+	if err != nil {
+		f.logger.Error("aws-sts-error", err)
+		return Credentials{}, convertError(err)
+	}
+	return fromSTS(getFederationTokenOutput.Credentials), nil
+}
+
+// AssumeRoleIssuer assumes a role shared by all temporary bindings, scoping
+// each session down with the binding's policy.
+type AssumeRoleIssuer struct {
+	stssvc  STSClient
+	roleARN string
+	retry   awsretry.Policy
+	logger  lager.Logger
+}
+
+func (a *AssumeRoleIssuer) Issue(ctx context.Context, name, policy string, tags map[string]string, ttl time.Duration) (Credentials, error) {
+	assumeRoleInput := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(a.roleARN),
+		RoleSessionName: aws.String(sessionName(name, 64)),
+		Policy:          aws.String(policy),
+		DurationSeconds: aws.Int64(int64(ttl.Seconds())),
+		Tags:            sessionTags(tags),
+	}
+	a.logger.Debug("assume-role", lager.Data{"role": a.roleARN, "session": aws.StringValue(assumeRoleInput.RoleSessionName), "duration": ttl.String()})
+
+	assumeRoleOutput, err := awsretry.Call(ctx, a.retry.For("AssumeRole"), func() (*sts.AssumeRoleOutput, error) {
+		return a.stssvc.AssumeRoleWithContext(ctx, assumeRoleInput)
+	})
+	if err != nil {
+		a.logger.Error("aws-sts-error", err)
+		return Credentials{}, convertError(err)
+	}
+	return fromSTS(assumeRoleOutput.Credentials), nil
+}
+
+// sessionName makes name acceptable to STS: allowed characters only and at
+// most max characters, keeping the end where GUIDs differ.
+func sessionName(name string, max int) string {
+	name = invalidNameChars.ReplaceAllString(name, "")
+	if len(name) > max {
+		name = name[len(name)-max:]
+	}
+	return name
+}
+
+// sessionTags converts tags to STS session tags in key order. Passing session
+// tags requires sts:TagSession, so no tags are passed when there are none.
+func sessionTags(tags map[string]string) []*sts.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	stsTags := make([]*sts.Tag, len(keys))
+	for i, key := range keys {
+		stsTags[i] = &sts.Tag{Key: aws.String(key), Value: aws.String(tags[key])}
+	}
+	return stsTags
+}
+
+func fromSTS(credentials *sts.Credentials) Credentials {
+	if credentials == nil {
+		return Credentials{}
+	}
+	return Credentials{
+		AccessKeyID:     aws.StringValue(credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(credentials.SessionToken),
+		Expiration:      aws.TimeValue(credentials.Expiration),
+	}
+}
+
+func convertError(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return errors.New(awsErr.Code() + ": " + awsErr.Message())
+	}
+	return err
+}
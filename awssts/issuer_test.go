@@ -0,0 +1,162 @@
+package awssts
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type mockSTSClient struct {
+	federationTokenInput *sts.GetFederationTokenInput
+	assumeRoleInput      *sts.AssumeRoleInput
+	err                  error
+}
+
+var testSTSCredentials = &sts.Credentials{
+	AccessKeyId:     aws.String("ASIAEXAMPLE"),
+	SecretAccessKey: aws.String("secret"),
+	SessionToken:    aws.String("token"),
+	Expiration:      aws.Time(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)),
+}
+
+func (c *mockSTSClient) GetFederationTokenWithContext(ctx aws.Context, input *sts.GetFederationTokenInput, opts ...request.Option) (*sts.GetFederationTokenOutput, error) {
+	c.federationTokenInput = input
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &sts.GetFederationTokenOutput{Credentials: testSTSCredentials}, nil
+}
+
+func (c *mockSTSClient) AssumeRoleWithContext(ctx aws.Context, input *sts.AssumeRoleInput, opts ...request.Option) (*sts.AssumeRoleOutput, error) {
+	c.assumeRoleInput = input
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &sts.AssumeRoleOutput{Credentials: testSTSCredentials}, nil
+}
+
+func TestIssue(t *testing.T) {
+	bindingName := "cf-6a1b2c3d-4e5f-6789-abcd-ef0123456789-extra-long-suffix"
+	testCases := map[string]struct {
+		method       string
+		tags         map[string]string
+		err          error
+		expectErr    string
+		expectCalled func(*mockSTSClient) bool
+	}{
+		"federation token": {
+			method: MethodFederationToken,
+			expectCalled: func(c *mockSTSClient) bool {
+				return c.federationTokenInput != nil &&
+					aws.Int64Value(c.federationTokenInput.DurationSeconds) == 3600 &&
+					aws.StringValue(c.federationTokenInput.Name) == bindingName[len(bindingName)-32:] &&
+					c.federationTokenInput.Tags == nil
+			},
+		},
+		"federation token with session tags": {
+			method: MethodFederationToken,
+			tags:   map[string]string{"Space GUID": "space1", "App GUID": "app1"},
+			expectCalled: func(c *mockSTSClient) bool {
+				tags := c.federationTokenInput.Tags
+				return len(tags) == 2 &&
+					aws.StringValue(tags[0].Key) == "App GUID" && aws.StringValue(tags[0].Value) == "app1" &&
+					aws.StringValue(tags[1].Key) == "Space GUID" && aws.StringValue(tags[1].Value) == "space1"
+			},
+		},
+		"assume role": {
+			method: MethodAssumeRole,
+			expectCalled: func(c *mockSTSClient) bool {
+				return c.assumeRoleInput != nil &&
+					aws.StringValue(c.assumeRoleInput.RoleArn) == "arn:aws:iam::123456789012:role/bindings" &&
+					aws.StringValue(c.assumeRoleInput.RoleSessionName) == bindingName
+			},
+		},
+		"assume role with session tags": {
+			method: MethodAssumeRole,
+			tags:   map[string]string{"App GUID": "app1"},
+			expectCalled: func(c *mockSTSClient) bool {
+				tags := c.assumeRoleInput.Tags
+				return len(tags) == 1 && aws.StringValue(tags[0].Key) == "App GUID" && aws.StringValue(tags[0].Value) == "app1"
+			},
+		},
+		"aws error": {
+			method:    MethodFederationToken,
+			err:       awserr.New("AccessDenied", "not allowed", errors.New("fail")),
+			expectErr: "AccessDenied: not allowed",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &mockSTSClient{err: tc.err}
+			issuer, err := NewCredentialIssuer(tc.method, client, "arn:aws:iam::123456789012:role/bindings", awsretry.Policy{}, lager.NewLogger("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			creds, err := issuer.Issue(context.Background(), bindingName, `{"Version":"2012-10-17"}`, tc.tags, time.Hour)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if creds.SessionToken != "token" || creds.AccessKeyID != "ASIAEXAMPLE" || creds.Expiration.IsZero() {
+				t.Errorf("unexpected credentials %+v", creds)
+			}
+			if !tc.expectCalled(client) {
+				t.Errorf("STS was not called as expected")
+			}
+		})
+	}
+}
+
+func TestNewCredentialIssuerUnknownMethod(t *testing.T) {
+	_, err := NewCredentialIssuer("magic", &mockSTSClient{}, "", awsretry.Policy{}, lager.NewLogger("test"))
+	if err != ErrUnknownMethod {
+		t.Fatalf("expected ErrUnknownMethod, got %v", err)
+	}
+}
+
+func TestSessionName(t *testing.T) {
+	testCases := map[string]struct {
+		name   string
+		max    int
+		expect string
+	}{
+		"unchanged": {
+			name:   "cf-binding-1",
+			max:    32,
+			expect: "cf-binding-1",
+		},
+		"strips invalid characters": {
+			name:   "cf binding/1",
+			max:    32,
+			expect: "cfbinding1",
+		},
+		"keeps the end": {
+			name:   "prefix-0123456789",
+			max:    10,
+			expect: "0123456789",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := sessionName(tc.name, tc.max); got != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, got)
+			}
+		})
+	}
+}
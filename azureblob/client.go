@@ -0,0 +1,450 @@
+// Package azureblob provisions containers in Azure Blob Storage. Each binding
+// has a stored access policy on its container, and a shared access signature
+// that refers to it, so that deleting the policy revokes the signature.
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/objectstore"
+)
+
+// DefaultCredentialLifetime is how long binding credentials are valid for.
+const DefaultCredentialLifetime = 5 * 365 * 24 * time.Hour
+
+const (
+	// apiVersion is the version of the Blob service API that requests and
+	// shared access signatures use.
+	apiVersion = "2021-08-06"
+
+	// maxAccessPolicies is how many stored access policies a container can
+	// have.
+	maxAccessPolicies = 5
+
+	metadataHeaderPrefix = "x-ms-meta-"
+
+	policyTimeFormat = "2006-01-02T15:04:05Z"
+)
+
+// accessPermissions are the permissions of a stored access policy that give
+// each access to a container's blobs.
+var accessPermissions = map[objectstore.Access]string{
+	objectstore.ReadWrite: "racwdl",
+	objectstore.ReadOnly:  "rl",
+	objectstore.WriteOnly: "acw",
+}
+
+type Config struct {
+	// AccountName is the storage account that containers are created in.
+	// Azure plans are disabled when it is empty.
+	AccountName string `yaml:"account_name"`
+	// AccountKey is a base64 access key of the storage account.
+	AccountKey string `yaml:"account_key"`
+	// Endpoint is the URL of the account's Blob service. Defaults to
+	// https://<account>.blob.core.windows.net.
+	Endpoint string `yaml:"endpoint"`
+	// CredentialLifetime is how long binding credentials are valid for,
+	// after which the binding must be recreated. Defaults to
+	// DefaultCredentialLifetime.
+	CredentialLifetime time.Duration `yaml:"credential_lifetime"`
+}
+
+func (c Config) Enabled() bool {
+	return c.AccountName != ""
+}
+
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.AccountKey == "" {
+		return errors.New("Must provide a non-empty AccountKey")
+	}
+	if _, err := base64.StdEncoding.DecodeString(c.AccountKey); err != nil {
+		return fmt.Errorf("AccountKey must be base64: %s", err)
+	}
+	if c.Endpoint != "" {
+		if u, err := url.Parse(c.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Endpoint must be an absolute URL, got %q", c.Endpoint)
+		}
+	}
+	if c.CredentialLifetime < 0 {
+		return errors.New("CredentialLifetime must not be negative")
+	}
+	return nil
+}
+
+// Client implements objectstore.BucketProvider with the Blob service REST API,
+// authorized with the storage account's shared key.
+type Client struct {
+	accountName        string
+	accountKey         []byte
+	endpoint           string
+	credentialLifetime time.Duration
+	httpClient         *http.Client
+	logger             lager.Logger
+	now                func() time.Time
+}
+
+var _ objectstore.BucketProvider = (*Client)(nil)
+
+func NewClient(config Config, logger lager.Logger) (*Client, error) {
+	key, err := base64.StdEncoding.DecodeString(config.AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("AccountKey must be base64: %s", err)
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + config.AccountName + ".blob.core.windows.net"
+	}
+	credentialLifetime := config.CredentialLifetime
+	if credentialLifetime == 0 {
+		credentialLifetime = DefaultCredentialLifetime
+	}
+	return &Client{
+		accountName:        config.AccountName,
+		accountKey:         key,
+		endpoint:           strings.TrimSuffix(endpoint, "/"),
+		credentialLifetime: credentialLifetime,
+		httpClient:         http.DefaultClient,
+		logger:             logger.Session("azureblob"),
+		now:                time.Now,
+	}, nil
+}
+
+func (c *Client) CreateBucket(ctx context.Context, bucketName string, labels map[string]string) error {
+	header := make(http.Header)
+	for name, value := range toMetadata(labels) {
+		header.Set(metadataHeaderPrefix+name, value)
+	}
+	c.logger.Debug("create-container", lager.Data{"container": bucketName})
+	status, err := c.do(ctx, http.MethodPut, bucketName, "restype=container", header, nil, nil, http.StatusCreated, http.StatusConflict)
+	if status == http.StatusConflict {
+		return objectstore.ErrBucketExists
+	}
+	return err
+}
+
+// SetLabels merges labels into the container's metadata, which Azure only
+// replaces as a whole.
+func (c *Client) SetLabels(ctx context.Context, bucketName string, labels map[string]string) error {
+	c.logger.Debug("set-metadata", lager.Data{"container": bucketName})
+	resp, err := c.request(ctx, http.MethodHead, bucketName, "restype=container", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return objectstore.ErrBucketNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Azure HEAD %s returned %d", bucketName, resp.StatusCode)
+	}
+
+	header := make(http.Header)
+	for name, values := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(name), metadataHeaderPrefix) {
+			header[name] = values
+		}
+	}
+	for name, value := range toMetadata(labels) {
+		header.Set(metadataHeaderPrefix+name, value)
+	}
+	_, err = c.do(ctx, http.MethodPut, bucketName, "restype=container&comp=metadata", header, nil, nil, http.StatusOK)
+	return err
+}
+
+func (c *Client) DeleteBucket(ctx context.Context, bucketName string) error {
+	var list struct {
+		Blobs []struct {
+			Name string `xml:"Name"`
+		} `xml:"Blobs>Blob"`
+	}
+	c.logger.Debug("delete-container", lager.Data{"container": bucketName})
+	status, err := c.do(ctx, http.MethodGet, bucketName, "restype=container&comp=list&maxresults=1", nil, nil, &list, http.StatusOK, http.StatusNotFound)
+	if status == http.StatusNotFound {
+		return objectstore.ErrBucketNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if len(list.Blobs) > 0 {
+		return objectstore.ErrBucketNotEmpty
+	}
+
+	status, err = c.do(ctx, http.MethodDelete, bucketName, "restype=container", nil, nil, nil, http.StatusAccepted, http.StatusNotFound)
+	if status == http.StatusNotFound {
+		return objectstore.ErrBucketNotFound
+	}
+	return err
+}
+
+// CreateCredentials adds a stored access policy for the binding to the
+// container, replacing any the binding already has, and returns a shared
+// access signature for it.
+func (c *Client) CreateCredentials(ctx context.Context, bucketName, bindingID string, access objectstore.Access) (objectstore.Credentials, error) {
+	permissions, ok := accessPermissions[access]
+	if !ok {
+		return objectstore.Credentials{}, fmt.Errorf("unknown access %q", access)
+	}
+	now := c.now().UTC()
+	identifier := signedIdentifier{ID: bindingID}
+	// The policy starts a little in the past, so that clocks that are
+	// behind Azure's can use it straight away.
+	identifier.AccessPolicy.Start = now.Add(-5 * time.Minute).Format(policyTimeFormat)
+	identifier.AccessPolicy.Expiry = now.Add(c.credentialLifetime).Format(policyTimeFormat)
+	identifier.AccessPolicy.Permission = permissions
+
+	err := c.updateAccessPolicies(ctx, bucketName, func(identifiers []signedIdentifier) ([]signedIdentifier, error) {
+		identifiers = slices.DeleteFunc(identifiers, func(i signedIdentifier) bool { return i.ID == bindingID })
+		if len(identifiers) >= maxAccessPolicies {
+			return nil, objectstore.ErrTooManyCredentials
+		}
+		return append(identifiers, identifier), nil
+	})
+	if err != nil {
+		return objectstore.Credentials{}, err
+	}
+
+	token := c.sasToken(bucketName, bindingID)
+	bucketURL := c.endpoint + "/" + bucketName
+	endpoint, _ := url.Parse(c.endpoint)
+	return objectstore.Credentials{
+		Endpoint:  endpoint.Host,
+		Bucket:    bucketName,
+		BucketURL: bucketURL,
+		URI:       bucketURL + "?" + token,
+		SASToken:  token,
+	}, nil
+}
+
+// DeleteCredentials removes the binding's stored access policy, which revokes
+// its shared access signature.
+func (c *Client) DeleteCredentials(ctx context.Context, bucketName, bindingID string) error {
+	found := false
+	err := c.updateAccessPolicies(ctx, bucketName, func(identifiers []signedIdentifier) ([]signedIdentifier, error) {
+		remaining := slices.DeleteFunc(identifiers, func(i signedIdentifier) bool { return i.ID == bindingID })
+		found = len(remaining) < len(identifiers)
+		return remaining, nil
+	})
+	if errors.Is(err, objectstore.ErrBucketNotFound) || (err == nil && !found) {
+		return objectstore.ErrCredentialsNotFound
+	}
+	return err
+}
+
+type signedIdentifiers struct {
+	XMLName     xml.Name           `xml:"SignedIdentifiers"`
+	Identifiers []signedIdentifier `xml:"SignedIdentifier"`
+}
+
+type signedIdentifier struct {
+	ID           string `xml:"Id"`
+	AccessPolicy struct {
+		Start      string `xml:"Start"`
+		Expiry     string `xml:"Expiry"`
+		Permission string `xml:"Permission"`
+	} `xml:"AccessPolicy"`
+}
+
+// updateAccessPolicies changes the container's stored access policies with
+// change. Writing the policies keeps the container private.
+func (c *Client) updateAccessPolicies(ctx context.Context, bucketName string, change func([]signedIdentifier) ([]signedIdentifier, error)) error {
+	var policies signedIdentifiers
+	status, err := c.do(ctx, http.MethodGet, bucketName, "restype=container&comp=acl", nil, nil, &policies, http.StatusOK, http.StatusNotFound)
+	if status == http.StatusNotFound {
+		return objectstore.ErrBucketNotFound
+	}
+	if err != nil {
+		return err
+	}
+	policies.Identifiers, err = change(policies.Identifiers)
+	if err != nil {
+		return err
+	}
+	body, err := xml.Marshal(policies)
+	if err != nil {
+		return err
+	}
+	c.logger.Debug("set-access-policies", lager.Data{"container": bucketName, "policies": len(policies.Identifiers)})
+	_, err = c.do(ctx, http.MethodPut, bucketName, "restype=container&comp=acl", nil, append([]byte(xml.Header), body...), nil, http.StatusOK)
+	return err
+}
+
+// sasToken returns a service shared access signature for a container that
+// takes its permissions and lifetime from the stored access policy called
+// identifier.
+func (c *Client) sasToken(bucketName, identifier string) string {
+	const signedResource = "c"
+	// Permissions, start and expiry come from the stored access policy,
+	// and the IP range, protocol, snapshot time, encryption scope and
+	// response headers are not set.
+	stringToSign := strings.Join([]string{
+		"", "", "",
+		"/blob/" + c.accountName + "/" + bucketName,
+		identifier,
+		"", "",
+		apiVersion,
+		signedResource,
+		"", "",
+		"", "", "", "", "",
+	}, "\n")
+	query := url.Values{
+		"sv":  {apiVersion},
+		"sr":  {signedResource},
+		"si":  {identifier},
+		"sig": {c.sign(stringToSign)},
+	}
+	return query.Encode()
+}
+
+func (c *Client) sign(stringToSign string) string {
+	mac := hmac.New(sha256.New, c.accountKey)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// request sends a request for a container, authorized with the account's
+// shared key.
+func (c *Client) request(ctx context.Context, method, bucketName, query string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+"/"+bucketName+"?"+query, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	req.Header.Set("x-ms-date", c.now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("Authorization", "SharedKey "+c.accountName+":"+c.sign(c.stringToSign(req)))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logger.Error("azure-error", err)
+	}
+	return resp, err
+}
+
+// stringToSign returns the string that a request's shared key signs.
+func (c *Client) stringToSign(req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, which x-ms-date takes the place of
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	var headers []string
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(headers)
+	lines = append(lines, headers...)
+
+	resource := "/" + c.accountName + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	lines = append(lines, resource)
+	return strings.Join(lines, "\n")
+}
+
+// do sends a request and returns the response status if it is one of
+// expectStatus, decoding the XML response into result if it is not nil.
+func (c *Client) do(ctx context.Context, method, bucketName, query string, header http.Header, body []byte, result any, expectStatus ...int) (int, error) {
+	resp, err := c.request(ctx, method, bucketName, query, header, body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if slices.Contains(expectStatus, resp.StatusCode) {
+		if result != nil && resp.StatusCode < http.StatusMultipleChoices {
+			if err := xml.NewDecoder(resp.Body).Decode(result); err != nil && err != io.EOF {
+				return resp.StatusCode, err
+			}
+		}
+		return resp.StatusCode, nil
+	}
+
+	var azureErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&azureErr)
+	err = fmt.Errorf("Azure %s %s returned %d: %s %s", method, bucketName, resp.StatusCode, azureErr.Code, azureErr.Message)
+	c.logger.Error("azure-error", err)
+	return resp.StatusCode, err
+}
+
+// toMetadata adapts tags to the rules of Azure metadata, whose names are C#
+// identifiers and whose values are ASCII. Names are lowercased, as Azure
+// compares them without case and would otherwise keep duplicates apart.
+func toMetadata(tags map[string]string) map[string]string {
+	metadata := make(map[string]string, len(tags))
+	for key, value := range tags {
+		name := strings.Map(func(r rune) rune {
+			if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return unicode.ToLower(r)
+			}
+			return '_'
+		}, key)
+		if name == "" {
+			continue
+		}
+		if unicode.IsDigit(rune(name[0])) {
+			name = "_" + name
+		}
+		metadata[name] = strings.Map(func(r rune) rune {
+			if r < ' ' || r >= unicode.MaxASCII {
+				return '_'
+			}
+			return r
+		}, value)
+	}
+	return metadata
+}
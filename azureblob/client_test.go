@@ -0,0 +1,271 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cloud-gov/s3-broker/objectstore"
+)
+
+var accountKey = base64.StdEncoding.EncodeToString([]byte("account-key"))
+
+type fakeAzure struct {
+	containers map[string]map[string]string
+	blobs      map[string]int
+	policies   map[string][]signedIdentifier
+}
+
+func newFakeAzure(t *testing.T) (*fakeAzure, *Client) {
+	fake := &fakeAzure{
+		containers: make(map[string]map[string]string),
+		blobs:      make(map[string]int),
+		policies:   make(map[string][]signedIdentifier),
+	}
+
+	var client *Client
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server checks the shared key against the request it
+		// received.
+		if r.Header.Get("Authorization") != "SharedKey account:"+client.sign(client.stringToSign(r)) || r.Header.Get("x-ms-version") != apiVersion {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>AuthenticationFailed</Code></Error>")
+			return
+		}
+		container := strings.TrimPrefix(r.URL.Path, "/")
+		metadata, exists := fake.containers[container]
+		query := r.URL.Query()
+		if query.Get("restype") != "container" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !exists && !(r.Method == http.MethodPut && query.Get("comp") == "") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch comp := query.Get("comp"); {
+		case r.Method == http.MethodPut && comp == "":
+			if exists {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			fake.containers[container] = requestMetadata(r)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodHead:
+			for name, value := range metadata {
+				w.Header().Set(metadataHeaderPrefix+name, value)
+			}
+		case r.Method == http.MethodPut && comp == "metadata":
+			fake.containers[container] = requestMetadata(r)
+		case r.Method == http.MethodGet && comp == "list":
+			fmt.Fprint(w, "<EnumerationResults><Blobs>")
+			for i := 0; i < fake.blobs[container]; i++ {
+				fmt.Fprintf(w, "<Blob><Name>blob-%d</Name></Blob>", i)
+			}
+			fmt.Fprint(w, "</Blobs></EnumerationResults>")
+		case r.Method == http.MethodDelete:
+			delete(fake.containers, container)
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodGet && comp == "acl":
+			xml.NewEncoder(w).Encode(signedIdentifiers{Identifiers: fake.policies[container]})
+		case r.Method == http.MethodPut && comp == "acl":
+			var policies signedIdentifiers
+			if err := xml.NewDecoder(r.Body).Decode(&policies); err != nil || len(policies.Identifiers) > maxAccessPolicies {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fake.policies[container] = policies.Identifiers
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(Config{AccountName: "account", AccountKey: accountKey, Endpoint: server.URL}, lager.NewLogger("test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	client.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return fake, client
+}
+
+func requestMetadata(r *http.Request) map[string]string {
+	metadata := make(map[string]string)
+	for name := range r.Header {
+		if after, ok := strings.CutPrefix(strings.ToLower(name), metadataHeaderPrefix); ok {
+			metadata[after] = r.Header.Get(name)
+		}
+	}
+	return metadata
+}
+
+func TestContainers(t *testing.T) {
+	fake, client := newFakeAzure(t)
+	ctx := context.Background()
+
+	if err := client.CreateBucket(ctx, "container", map[string]string{"Instance GUID": "abc", "1st": "é"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(map[string]string{"instance_guid": "abc", "_1st": "_"}, fake.containers["container"]); diff != "" {
+		t.Errorf("unexpected metadata (-want +got):\n%s", diff)
+	}
+	if err := client.CreateBucket(ctx, "container", nil); !errors.Is(err, objectstore.ErrBucketExists) {
+		t.Errorf("expected ErrBucketExists, got %v", err)
+	}
+
+	if err := client.SetLabels(ctx, "container", map[string]string{"Instance GUID": "def", "Updated at": "now"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(map[string]string{"instance_guid": "def", "_1st": "_", "updated_at": "now"}, fake.containers["container"]); diff != "" {
+		t.Errorf("unexpected metadata (-want +got):\n%s", diff)
+	}
+
+	fake.blobs["container"] = 1
+	if err := client.DeleteBucket(ctx, "container"); !errors.Is(err, objectstore.ErrBucketNotEmpty) {
+		t.Errorf("expected ErrBucketNotEmpty, got %v", err)
+	}
+	fake.blobs["container"] = 0
+	if err := client.DeleteBucket(ctx, "container"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := client.DeleteBucket(ctx, "container"); !errors.Is(err, objectstore.ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	testCases := map[string]struct {
+		access            objectstore.Access
+		existing          []string
+		expectPermissions string
+		expectErr         error
+	}{
+		"read-write": {
+			access:            objectstore.ReadWrite,
+			expectPermissions: "racwdl",
+		},
+		"read-only": {
+			access:            objectstore.ReadOnly,
+			expectPermissions: "rl",
+		},
+		"write-only": {
+			access:            objectstore.WriteOnly,
+			expectPermissions: "acw",
+		},
+		"replaces the binding's policy": {
+			access:            objectstore.ReadWrite,
+			existing:          []string{"a", "b", "c", "d", "binding-id"},
+			expectPermissions: "racwdl",
+		},
+		"too many policies": {
+			access:    objectstore.ReadWrite,
+			existing:  []string{"a", "b", "c", "d", "e"},
+			expectErr: objectstore.ErrTooManyCredentials,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake, client := newFakeAzure(t)
+			ctx := context.Background()
+			if err := client.CreateBucket(ctx, "container", nil); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for _, id := range tc.existing {
+				fake.policies["container"] = append(fake.policies["container"], signedIdentifier{ID: id})
+			}
+
+			credentials, err := client.CreateCredentials(ctx, "container", "binding-id", tc.access)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			token, err := url.ParseQuery(credentials.SASToken)
+			if err != nil {
+				t.Fatalf("SAS token is not a query string: %s", err)
+			}
+			if token.Get("si") != "binding-id" || token.Get("sr") != "c" || token.Get("sv") != apiVersion || token.Get("sig") == "" {
+				t.Errorf("unexpected SAS token %s", credentials.SASToken)
+			}
+			if credentials.URI != credentials.BucketURL+"?"+credentials.SASToken || !strings.HasSuffix(credentials.BucketURL, "/container") {
+				t.Errorf("unexpected URLs %s and %s", credentials.BucketURL, credentials.URI)
+			}
+
+			policies := fake.policies["container"]
+			policy := policies[len(policies)-1]
+			if policy.ID != "binding-id" || policy.AccessPolicy.Permission != tc.expectPermissions {
+				t.Errorf("expected a policy for the binding with permissions %s, got %+v", tc.expectPermissions, policy)
+			}
+			if policy.AccessPolicy.Start != "2024-01-02T02:59:05Z" || policy.AccessPolicy.Expiry != "2028-12-31T03:04:05Z" {
+				t.Errorf("unexpected policy lifetime %s to %s", policy.AccessPolicy.Start, policy.AccessPolicy.Expiry)
+			}
+
+			if err := client.DeleteCredentials(ctx, "container", "binding-id"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var remaining []string
+			for _, policy := range fake.policies["container"] {
+				remaining = append(remaining, policy.ID)
+			}
+			expectRemaining := slices.DeleteFunc(slices.Clone(tc.existing), func(id string) bool { return id == "binding-id" })
+			if !slices.Equal(expectRemaining, remaining) {
+				t.Errorf("expected policies %v to remain, got %v", expectRemaining, remaining)
+			}
+			if err := client.DeleteCredentials(ctx, "container", "binding-id"); !errors.Is(err, objectstore.ErrCredentialsNotFound) {
+				t.Errorf("expected ErrCredentialsNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSASToken(t *testing.T) {
+	client, err := NewClient(Config{AccountName: "account", AccountKey: accountKey}, lager.NewLogger("test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stringToSign := "\n\n\n/blob/account/container\nbinding-id\n\n\n" + apiVersion + "\nc\n\n\n\n\n\n\n"
+	expected := url.Values{"sv": {apiVersion}, "sr": {"c"}, "si": {"binding-id"}, "sig": {client.sign(stringToSign)}}.Encode()
+	if token := client.sasToken("container", "binding-id"); token != expected {
+		t.Errorf("expected %s, got %s", expected, token)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		config    Config
+		expectErr bool
+	}{
+		"disabled":          {},
+		"valid":             {config: Config{AccountName: "account", AccountKey: accountKey}},
+		"no key":            {config: Config{AccountName: "account"}, expectErr: true},
+		"key is not base64": {config: Config{AccountName: "account", AccountKey: "not base64!"}, expectErr: true},
+		"relative endpoint": {config: Config{AccountName: "account", AccountKey: accountKey, Endpoint: "blob"}, expectErr: true},
+		"negative lifetime": {config: Config{AccountName: "account", AccountKey: accountKey, CredentialLifetime: -time.Hour}, expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
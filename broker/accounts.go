@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awskms"
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/awssecrets"
+	"github.com/cloud-gov/s3-broker/awssts"
+)
+
+var ErrAccountPlanChange = apiresponses.NewFailureResponse(
+	errors.New("Instances cannot change between plans in different AWS accounts."),
+	http.StatusBadRequest,
+	"account-plan-change",
+)
+
+// AccountConfig is an AWS account that plans can provision into instead of
+// the broker's own, through a role that the broker assumes to manage the
+// account's buckets, IAM users and roles.
+type AccountConfig struct {
+	RoleConfig `yaml:",inline"`
+}
+
+// Account holds the clients for an account that plans can provision into.
+// Nil Role, Secrets and CredentialIssuer disable the bindings that need them,
+// as they do for the broker's own account.
+type Account struct {
+	Bucket           awss3.Bucket
+	User             awsiam.User
+	Role             awsiam.Role
+	Group            awsiam.Group
+	Grants           awskms.Grants
+	Secrets          awssecrets.Secrets
+	CredentialIssuer awssts.CredentialIssuer
+	// Storage reads the account's storage metrics, if usage is measured
+	// from CloudWatch.
+	Storage StorageReader
+	// Alarms manages the account's storage alarms, if alerts are enabled.
+	Alarms StorageAlarms
+}
+
+// SetAccounts gives the broker the clients of the accounts that plans name.
+// It must be called before the broker serves requests.
+func (b *S3Broker) SetAccounts(accounts map[string]Account) {
+	b.accounts = map[string]*S3Broker{"": b}
+	for name, account := range accounts {
+		// Every account's broker shares the stores and locks of this one,
+		// and differs only in its clients.
+		accountBroker := *b
+		accountBroker.account = name
+		accountBroker.bucket = account.Bucket
+		accountBroker.user = account.User
+		accountBroker.role = account.Role
+		accountBroker.group = account.Group
+		accountBroker.grants = account.Grants
+		accountBroker.secrets = account.Secrets
+		accountBroker.credentialIssuer = account.CredentialIssuer
+		accountBroker.storage = account.Storage
+		accountBroker.alarms = account.Alarms
+		b.accounts[name] = &accountBroker
+	}
+}
+
+// forPlan returns the broker for the account that a plan provisions into.
+func (b *S3Broker) forPlan(servicePlan ServicePlan) *S3Broker {
+	return b.forAccount(servicePlan.S3Properties.Account)
+}
+
+// forPlanID is forPlan for a plan ID, which may not be known.
+func (b *S3Broker) forPlanID(planID string) *S3Broker {
+	if len(b.accounts) == 0 {
+		return b
+	}
+	servicePlan, _ := b.catalog.FindServicePlan(planID)
+	return b.forPlan(servicePlan)
+}
+
+// forAccount returns the broker for a named account, or the broker's own
+// account if the name is empty.
+func (b *S3Broker) forAccount(name string) *S3Broker {
+	if accountBroker, ok := b.accounts[name]; ok {
+		return accountBroker.withRequestID(b.requestID)
+	}
+	return b
+}
+
+// forInstance returns the broker for the account of an instance of a plan.
+// If the plan is not known, the plan recorded in the state store is used.
+func (b *S3Broker) forInstance(ctx context.Context, instanceID string, servicePlan ServicePlan) *S3Broker {
+	if servicePlan.ID == "" {
+		if instance, ok := b.instanceState(ctx, instanceID); ok {
+			servicePlan, _ = b.catalog.FindServicePlan(instance.PlanID)
+		}
+	}
+	return b.forPlan(servicePlan)
+}
+
+// accountBrokers returns the broker of the broker's own account followed by
+// those of the other accounts, in order of their names.
+func (b *S3Broker) accountBrokers() []*S3Broker {
+	accountBrokers := []*S3Broker{b.forAccount("")}
+	for _, name := range slices.Sorted(maps.Keys(b.accounts)) {
+		if name != "" {
+			accountBrokers = append(accountBrokers, b.accounts[name])
+		}
+	}
+	return accountBrokers
+}
+
+// planAccount returns the name of the account that a plan provisions into,
+// or an empty name for the broker's own account or an unknown plan.
+func (b *S3Broker) planAccount(planID string) string {
+	servicePlan, _ := b.catalog.FindServicePlan(planID)
+	return servicePlan.S3Properties.Account
+}
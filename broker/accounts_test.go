@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/auditlog"
+)
+
+func TestAccountRouting(t *testing.T) {
+	plans := map[string]ServicePlan{
+		"own": {ID: "own", Name: "basic", S3Properties: S3Properties{IamPolicy: "{}"}},
+		"dev": {ID: "dev", Name: "sandbox", S3Properties: S3Properties{IamPolicy: "{}", Account: "dev"}},
+	}
+
+	testCases := map[string]struct {
+		planID        string
+		expectAccount string
+	}{
+		"plan in the broker's account": {
+			planID:        "own",
+			expectAccount: "",
+		},
+		"plan in another account": {
+			planID:        "dev",
+			expectAccount: "dev",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			buckets := map[string]*mockBucket{"": {}, "dev": {}}
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-accounts"),
+				bucketPrefix: "prefix",
+				bucket:       buckets[""],
+				user:         &mockUser{},
+				catalog:      &mockCatalog{serviceName: "s3", plans: plans},
+				tagManager:   &mockTagGenerator{},
+			}
+			b.SetAccounts(map[string]Account{"dev": {Bucket: buckets["dev"], User: &mockUser{}}})
+
+			if _, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{
+				ServiceID: "service1",
+				PlanID:    tc.planID,
+			}, false); err != nil {
+				t.Fatalf("unexpected provision error: %s", err)
+			}
+			if _, err := b.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{
+				PlanID: tc.planID,
+			}, false); err != nil {
+				t.Fatalf("unexpected deprovision error: %s", err)
+			}
+
+			for account, bucket := range buckets {
+				used := account == tc.expectAccount
+				if (bucket.name != "") != used || bucket.deleted != used {
+					t.Errorf("expected account %q to be used: %t, got created %q and deleted %t", account, used, bucket.name, bucket.deleted)
+				}
+			}
+		})
+	}
+}
+
+func TestAccountBrokerSinks(t *testing.T) {
+	publisher := &mockEventPublisher{}
+	sink := &mockAuditSink{}
+	b := &S3Broker{
+		logger:       lager.NewLogger("broker-unit-test-accounts"),
+		bucketPrefix: "prefix",
+		bucket:       &mockBucket{},
+		user:         &mockUser{},
+		catalog: &mockCatalog{serviceName: "s3", plans: map[string]ServicePlan{
+			"dev": {ID: "dev", Name: "sandbox", S3Properties: S3Properties{IamPolicy: "{}", Account: "dev"}},
+		}},
+		tagManager: &mockTagGenerator{},
+		operations: NewMemoryOperationStore(),
+	}
+	b.SetEventPublisher(publisher)
+	b.SetAuditLog(auditlog.New(sink, nil, auditlog.Record{}))
+	b.SetAccounts(map[string]Account{"dev": {Bucket: &mockBucket{}, User: &mockUser{}}})
+
+	if _, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "dev"}, false); err != nil {
+		t.Fatalf("unexpected provision error: %s", err)
+	}
+	if len(publisher.events) != 1 || publisher.events[0].Action != "provision" {
+		t.Errorf("expected the account's broker to publish a provision event, got %+v", publisher.events)
+	}
+	if !strings.Contains(sink.String(), `"action":"provision"`) {
+		t.Errorf("expected the account's broker to write a provision audit record, got %s", sink.String())
+	}
+}
@@ -0,0 +1,111 @@
+package broker
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/awsevents"
+	"github.com/cloud-gov/s3-broker/awsmetrics"
+)
+
+// DefaultAlarmPrefix begins the names of the alarms on instances' storage,
+// which are followed by the instance ID.
+const DefaultAlarmPrefix = "s3-broker-"
+
+// AlertThresholdTagKey records an instance's alert_threshold_gb parameter on
+// its bucket.
+const AlertThresholdTagKey = "Alert threshold GB"
+
+// bytesPerGB is the size of the gigabytes of alert_threshold_gb, which are
+// the binary gigabytes that AWS bills storage in.
+const bytesPerGB = 1 << 30
+
+type AlertsConfig struct {
+	// TopicARN is the SNS topic that alarms on instances' storage notify.
+	// The alert_threshold_gb parameter is not accepted without it.
+	TopicARN string `yaml:"topic_arn"`
+	// AlarmPrefix begins the names of the alarms. Defaults to
+	// DefaultAlarmPrefix.
+	AlarmPrefix string `yaml:"alarm_prefix"`
+}
+
+func (c AlertsConfig) Enabled() bool {
+	return c.TopicARN != ""
+}
+
+func (c AlertsConfig) Validate() error {
+	if !c.Enabled() {
+		if c.AlarmPrefix != "" {
+			return errors.New("Must provide a TopicARN with an AlarmPrefix")
+		}
+		return nil
+	}
+	if !awsevents.IsTopicARN(c.TopicARN) {
+		return fmt.Errorf("TopicARN must be an SNS topic ARN, got %q", c.TopicARN)
+	}
+	return nil
+}
+
+// StorageAlarms manages the alarms that notify operators when a bucket grows
+// past its instance's alert threshold.
+type StorageAlarms interface {
+	PutStorageAlarm(ctx context.Context, alarm awsmetrics.StorageAlarm) error
+	DeleteStorageAlarm(ctx context.Context, name string) error
+}
+
+// SetStorageAlarms gives the broker the client of its own account's storage
+// alarms. It must be called before SetAccounts.
+func (b *S3Broker) SetStorageAlarms(alarms StorageAlarms) {
+	b.alarms = alarms
+}
+
+// addAlertThresholdTag records thresholdGB in tags, if it was given, and
+// returns tags.
+func addAlertThresholdTag(tags map[string]string, thresholdGB *float64) map[string]string {
+	if thresholdGB == nil {
+		return tags
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags[AlertThresholdTagKey] = strconv.FormatFloat(*thresholdGB, 'f', -1, 64)
+	return tags
+}
+
+// alarmName returns the name of the alarm on an instance's storage.
+func (b *S3Broker) alarmName(instanceID string) string {
+	return cmp.Or(b.alerts.AlarmPrefix, DefaultAlarmPrefix) + instanceID
+}
+
+// setAlertThreshold puts the alarm on an instance's bucket at thresholdGB,
+// or deletes it if thresholdGB is zero. The alarm is kept as it is if
+// thresholdGB was not given.
+func (b *S3Broker) setAlertThreshold(ctx context.Context, instanceID, bucketName string, thresholdGB *float64) error {
+	if thresholdGB == nil || b.alarms == nil {
+		return nil
+	}
+	if *thresholdGB == 0 {
+		return b.deleteStorageAlarm(ctx, instanceID)
+	}
+	b.logger.Info("put-storage-alarm", lager.Data{instanceIDLogKey: instanceID, "threshold-gb": *thresholdGB})
+	return b.alarms.PutStorageAlarm(ctx, awsmetrics.StorageAlarm{
+		Name:           b.alarmName(instanceID),
+		Description:    fmt.Sprintf("Bucket %s of service instance %s is larger than %v GB", bucketName, instanceID, *thresholdGB),
+		BucketName:     bucketName,
+		ThresholdBytes: *thresholdGB * bytesPerGB,
+	})
+}
+
+// deleteStorageAlarm deletes the alarm on an instance's storage, if alerts
+// are enabled. Instances without one have nothing to delete.
+func (b *S3Broker) deleteStorageAlarm(ctx context.Context, instanceID string) error {
+	if b.alarms == nil {
+		return nil
+	}
+	return b.alarms.DeleteStorageAlarm(ctx, b.alarmName(instanceID))
+}
@@ -0,0 +1,187 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awsmetrics"
+)
+
+type mockStorageAlarms struct {
+	// alarms maps alarm names to their alarms.
+	alarms map[string]awsmetrics.StorageAlarm
+}
+
+func (a *mockStorageAlarms) PutStorageAlarm(ctx context.Context, alarm awsmetrics.StorageAlarm) error {
+	if a.alarms == nil {
+		a.alarms = map[string]awsmetrics.StorageAlarm{}
+	}
+	a.alarms[alarm.Name] = alarm
+	return nil
+}
+
+func (a *mockStorageAlarms) DeleteStorageAlarm(ctx context.Context, name string) error {
+	delete(a.alarms, name)
+	return nil
+}
+
+func TestAlertsConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		config    AlertsConfig
+		expectErr string
+	}{
+		"disabled": {},
+		"topic": {
+			config: AlertsConfig{TopicARN: "arn:aws:sns:us-east-1:123456789012:alerts", AlarmPrefix: "s3-"},
+		},
+		"not a topic": {
+			config:    AlertsConfig{TopicARN: "arn:aws:events:us-east-1:123456789012:event-bus/default"},
+			expectErr: `TopicARN must be an SNS topic ARN, got "arn:aws:events:us-east-1:123456789012:event-bus/default"`,
+		},
+		"prefix without topic": {
+			config:    AlertsConfig{AlarmPrefix: "s3-"},
+			expectErr: "Must provide a TopicARN with an AlarmPrefix",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.expectErr {
+				t.Errorf("expected error %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func withAlerts(b *S3Broker) {
+	b.bucketPrefix = "prefix"
+	b.allowUserProvisionParameters = true
+	b.allowUserUpdateParameters = true
+	b.alerts = AlertsConfig{TopicARN: "arn:aws:sns:us-east-1:123456789012:alerts"}
+}
+
+func TestAlertThreshold(t *testing.T) {
+	alarms := &mockStorageAlarms{}
+	b := newTestBroker(withAlerts)
+	b.alarms = alarms
+	ctx := context.Background()
+
+	_, err := b.Provision(ctx, "instance1", domain.ProvisionDetails{
+		ServiceID:     "service1",
+		PlanID:        "plan1",
+		RawParameters: json.RawMessage(`{"alert_threshold_gb": 1.5}`),
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected provision error: %s", err)
+	}
+	alarm, ok := alarms.alarms["s3-broker-instance1"]
+	if !ok {
+		t.Fatalf("expected an alarm, got %v", alarms.alarms)
+	}
+	if alarm.BucketName != "prefix-instance1" || alarm.ThresholdBytes != 1.5*(1<<30) {
+		t.Errorf("unexpected alarm %+v", alarm)
+	}
+
+	// Updates that leave the threshold out keep the alarm.
+	if _, err := b.Update(ctx, "instance1", domain.UpdateDetails{ServiceID: "service1", PlanID: "plan1"}, false); err != nil {
+		t.Fatalf("unexpected update error: %s", err)
+	}
+	if _, ok := alarms.alarms["s3-broker-instance1"]; !ok {
+		t.Fatal("expected the alarm to be kept")
+	}
+	_, err = b.Update(ctx, "instance1", domain.UpdateDetails{
+		ServiceID:     "service1",
+		PlanID:        "plan1",
+		RawParameters: json.RawMessage(`{"alert_threshold_gb": 0}`),
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected update error: %s", err)
+	}
+	if len(alarms.alarms) != 0 {
+		t.Errorf("expected a zero threshold to delete the alarm, got %v", alarms.alarms)
+	}
+}
+
+func TestAlertThresholdDeletedWithInstance(t *testing.T) {
+	alarms := &mockStorageAlarms{}
+	b := newTestBroker(withAlerts)
+	b.alarms = alarms
+	b.alerts.AlarmPrefix = "s3-"
+	ctx := context.Background()
+
+	_, err := b.Provision(ctx, "instance1", domain.ProvisionDetails{
+		ServiceID:     "service1",
+		PlanID:        "plan1",
+		RawParameters: json.RawMessage(`{"alert_threshold_gb": 10}`),
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected provision error: %s", err)
+	}
+	if _, ok := alarms.alarms["s3-instance1"]; !ok {
+		t.Fatalf("expected an alarm with the configured prefix, got %v", alarms.alarms)
+	}
+	if _, err := b.Deprovision(ctx, "instance1", domain.DeprovisionDetails{PlanID: "plan1"}, false); err != nil {
+		t.Fatalf("unexpected deprovision error: %s", err)
+	}
+	if len(alarms.alarms) != 0 {
+		t.Errorf("expected deprovisioning to delete the alarm, got %v", alarms.alarms)
+	}
+}
+
+func TestAlertThresholdParameter(t *testing.T) {
+	testCases := map[string]struct {
+		alerts    AlertsConfig
+		raw       string
+		expectErr string
+	}{
+		"negative": {
+			alerts:    AlertsConfig{TopicARN: "arn:aws:sns:us-east-1:123456789012:alerts"},
+			raw:       `{"alert_threshold_gb": -1}`,
+			expectErr: "alert_threshold_gb: must be at least 0",
+		},
+		"not a number": {
+			alerts:    AlertsConfig{TopicARN: "arn:aws:sns:us-east-1:123456789012:alerts"},
+			raw:       `{"alert_threshold_gb": "5"}`,
+			expectErr: "alert_threshold_gb: must be a number",
+		},
+		"alerts disabled": {
+			raw:       `{"alert_threshold_gb": 5}`,
+			expectErr: "alert_threshold_gb",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withAlerts)
+			b.alerts = tc.alerts
+			err := validateParameters(b.provisionSchema(ServicePlan{Name: "plan1"}), json.RawMessage(tc.raw))
+			if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+				t.Errorf("expected error containing %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestAlertThresholdTag(t *testing.T) {
+	b := newTestBroker(withAlerts)
+	var parameters ProvisionParameters
+	if err := json.Unmarshal([]byte(`{"alert_threshold_gb": 2.5}`), &parameters); err != nil {
+		t.Fatal(err)
+	}
+	bucketDetails, err := b.createBucket(context.Background(), "instance1", ServicePlan{Name: "plan1"}, parameters, domain.ProvisionDetails{ServiceID: "service1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if bucketDetails.Tags[AlertThresholdTagKey] != "2.5" {
+		t.Errorf("expected bucket to be tagged with its alert threshold, got %v", bucketDetails.Tags)
+	}
+}
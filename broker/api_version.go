@@ -0,0 +1,86 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// APIVersionHeader is the header in which platforms send the OSB API version
+// of their requests.
+const APIVersionHeader = "X-Broker-API-Version"
+
+// APIVersion is a version of the OSB API, such as 2.16.
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+// The OSB API versions that introduced response fields the broker sends.
+var (
+	apiVersionMaintenanceInfo  = APIVersion{Major: 2, Minor: 15}
+	apiVersionInstanceMetadata = APIVersion{Major: 2, Minor: 16}
+)
+
+// ParseAPIVersion parses a version of the form major.minor.
+func ParseAPIVersion(version string) (APIVersion, error) {
+	var v APIVersion
+	var rest string
+	if n, _ := fmt.Sscanf(version, "%d.%d%s", &v.Major, &v.Minor, &rest); n != 2 || v.Major < 0 || v.Minor < 0 {
+		return APIVersion{}, fmt.Errorf("OSB API version must be of the form major.minor, such as 2.16, got %q", version)
+	}
+	return v, nil
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Before reports whether v is an older version than other.
+func (v APIVersion) Before(other APIVersion) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
+
+type apiVersionContextKey struct{}
+
+// CheckAPIVersion wraps the OSB API handler. It rejects requests whose
+// X-Broker-API-Version is older than the configured minimum with 412
+// Precondition Failed, and records the version of other requests so that
+// responses leave out fields that their version does not define. Requests
+// without a valid version are passed on for brokerapi to reject.
+func (b *S3Broker) CheckAPIVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, err := ParseAPIVersion(r.Header.Get(APIVersionHeader))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if version.Before(b.minAPIVersion) {
+			b.logger.Info("unsupported-api-version", lager.Data{"version": version.String(), "minimum": b.minAPIVersion.String()})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			json.NewEncoder(w).Encode(apiresponses.ErrorResponse{
+				Description: fmt.Sprintf("%s %s is not supported. This broker requires OSB API version %s or later; upgrade the platform or ask the broker's operator to lower min_api_version.", APIVersionHeader, version, b.minAPIVersion),
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionContextKey{}, version)))
+	})
+}
+
+// supportsAPIVersion reports whether the request that ctx belongs to was sent
+// with at least version. Requests that did not come through CheckAPIVersion
+// support every version.
+func supportsAPIVersion(ctx context.Context, version APIVersion) bool {
+	requested, ok := ctx.Value(apiVersionContextKey{}).(APIVersion)
+	return !ok || !requested.Before(version)
+}
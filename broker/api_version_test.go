@@ -0,0 +1,136 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+func TestParseAPIVersion(t *testing.T) {
+	testCases := map[string]struct {
+		version       string
+		expectVersion APIVersion
+		expectErr     bool
+	}{
+		"version":          {version: "2.16", expectVersion: APIVersion{Major: 2, Minor: 16}},
+		"major version":    {version: "2", expectErr: true},
+		"patch version":    {version: "2.16.1", expectErr: true},
+		"trailing text":    {version: "2.16-rc1", expectErr: true},
+		"negative version": {version: "2.-1", expectErr: true},
+		"empty":            {expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			version, err := ParseAPIVersion(tc.version)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if version != tc.expectVersion {
+				t.Errorf("expected %s, got %s", tc.expectVersion, version)
+			}
+		})
+	}
+}
+
+func TestCheckAPIVersion(t *testing.T) {
+	testCases := map[string]struct {
+		header        string
+		expectStatus  int
+		expectVersion bool
+	}{
+		"newer version": {
+			header:        "2.17",
+			expectStatus:  http.StatusOK,
+			expectVersion: true,
+		},
+		"minimum version": {
+			header:        "2.14",
+			expectStatus:  http.StatusOK,
+			expectVersion: true,
+		},
+		"older version": {
+			header:       "2.13",
+			expectStatus: http.StatusPreconditionFailed,
+		},
+		"no version": {
+			expectStatus: http.StatusOK,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger:        lager.NewLogger("broker-unit-test-api-version"),
+				minAPIVersion: APIVersion{Major: 2, Minor: 14},
+			}
+			var gotVersion bool
+			handler := b.CheckAPIVersion(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, gotVersion = r.Context().Value(apiVersionContextKey{}).(APIVersion)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+			if tc.header != "" {
+				req.Header.Set(APIVersionHeader, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectStatus {
+				t.Fatalf("expected status %d, got %d", tc.expectStatus, rec.Code)
+			}
+			if gotVersion != tc.expectVersion {
+				t.Errorf("expected version in context %t, got %t", tc.expectVersion, gotVersion)
+			}
+			if rec.Code == http.StatusPreconditionFailed {
+				var response apiresponses.ErrorResponse
+				if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+					t.Fatalf("invalid response: %s", err)
+				}
+				if !strings.Contains(response.Description, "2.14 or later") {
+					t.Errorf("expected description to name the minimum version, got %q", response.Description)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadataForAPIVersion(t *testing.T) {
+	testCases := map[string]struct {
+		version        APIVersion
+		expectMetadata bool
+	}{
+		"version with instance metadata":    {version: APIVersion{Major: 2, Minor: 16}, expectMetadata: true},
+		"version without instance metadata": {version: APIVersion{Major: 2, Minor: 15}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-api-version"),
+				bucket:       &mockBucket{},
+				catalog:      &mockCatalog{planName: "plan1", serviceName: "service1"},
+				tagManager:   &mockTagGenerator{},
+				bucketPrefix: "prefix",
+			}
+			ctx := context.WithValue(context.Background(), apiVersionContextKey{}, tc.version)
+			spec, err := b.Provision(ctx, "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if hasMetadata := !spec.Metadata.IsEmpty(); hasMetadata != tc.expectMetadata {
+				t.Errorf("expected metadata %t, got %+v", tc.expectMetadata, spec.Metadata)
+			}
+		})
+	}
+}
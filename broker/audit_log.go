@@ -0,0 +1,152 @@
+package broker
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/auditlog"
+)
+
+const (
+	DefaultAuditLogMaxSizeMB       = 100
+	DefaultAuditLogMaxBackups      = 10
+	DefaultAuditLogLogStreamPrefix = "s3-broker"
+)
+
+// AuditLogConfig configures the audit trail, which records every operation
+// apart from the broker's logs. It is written to a file or to CloudWatch
+// Logs.
+type AuditLogConfig struct {
+	// File is the file that the trail is appended to.
+	File string `yaml:"file"`
+	// MaxSizeMB is the size that File is rotated at. Defaults to
+	// DefaultAuditLogMaxSizeMB.
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxBackups is the number of rotated files that are kept. Defaults to
+	// DefaultAuditLogMaxBackups.
+	MaxBackups int `yaml:"max_backups"`
+	// LogGroup is the CloudWatch Logs log group that the trail is sent to,
+	// in a new log stream each time the broker starts.
+	LogGroup string `yaml:"log_group"`
+	// LogStreamPrefix begins the names of the log streams. Defaults to
+	// DefaultAuditLogLogStreamPrefix.
+	LogStreamPrefix string `yaml:"log_stream_prefix"`
+	// HMACKey keys the hashes that chain the records, so that the trail
+	// cannot be rewritten without it.
+	HMACKey string `yaml:"hmac_key"`
+}
+
+func (c AuditLogConfig) Enabled() bool {
+	return c.File != "" || c.LogGroup != ""
+}
+
+func (c AuditLogConfig) Validate() error {
+	if c.File != "" && c.LogGroup != "" {
+		return errors.New("Must provide only one of File and LogGroup")
+	}
+	if c.MaxSizeMB < 0 {
+		return errors.New("MaxSizeMB must not be negative")
+	}
+	if c.MaxBackups < 0 {
+		return errors.New("MaxBackups must not be negative")
+	}
+	if !c.Enabled() && (c.HMACKey != "" || c.LogStreamPrefix != "") {
+		return errors.New("Must provide a File or LogGroup to write the audit log to")
+	}
+	if c.File != "" && c.LogStreamPrefix != "" {
+		return errors.New("LogStreamPrefix can only be set with a LogGroup")
+	}
+	return nil
+}
+
+// AuditEvent is the event of an audit record: the operation's event, with
+// the parameters of the request and the credentials that a bind created.
+type AuditEvent struct {
+	Event
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	AccessKeyID string          `json:"access_key_id,omitempty"`
+	RoleARN     string          `json:"role_arn,omitempty"`
+	// Details are what an action outside the OSB API did beyond its event,
+	// such as the access keys that a quarantine deactivated.
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// auditDetails are what the audit trail records of a request beyond its
+// event.
+type auditDetails struct {
+	parameters  json.RawMessage
+	credentials Credentials
+}
+
+// SetAuditLog makes the broker write an audit record for every operation. It
+// must be called before SetAccounts.
+func (b *S3Broker) SetAuditLog(log *auditlog.Log) {
+	b.audit = log
+}
+
+// writeAuditRecord writes the outcome of an operation to the audit trail.
+// The operation has happened by now, so failing to write it does not fail
+// the request.
+func (b *S3Broker) writeAuditRecord(ctx context.Context, event Event, details auditDetails) {
+	if b.audit == nil {
+		return
+	}
+	auditEvent := AuditEvent{
+		Event:       event,
+		Parameters:  details.parameters,
+		AccessKeyID: details.credentials.AccessKeyID,
+		RoleARN:     details.credentials.RoleARN,
+	}
+	// Parameters that are not JSON were rejected, and are recorded as
+	// they were sent.
+	if len(auditEvent.Parameters) > 0 && !json.Valid(auditEvent.Parameters) {
+		auditEvent.Parameters, _ = json.Marshal(string(details.parameters))
+	}
+	b.writeAuditEvent(ctx, auditEvent)
+}
+
+// AuditAction writes an action taken outside the OSB API, by an operator
+// through the admin API or by one of the broker's background jobs, to the
+// audit trail, with err as its outcome. Its user is the platform user of ctx,
+// or else the broker username that an admin request authenticated with.
+func (b *S3Broker) AuditAction(ctx context.Context, event AuditEvent, err error) {
+	if b.audit == nil {
+		return
+	}
+	event.Time = time.Now()
+	event.RequestID = RequestID(ctx)
+	if event.User == "" {
+		event.User = cmp.Or(b.originatingIdentity(ctx).String(), adminUser(ctx))
+	}
+	event.Succeeded = err == nil
+	if err != nil {
+		event.Error = err.Error()
+	}
+	b.writeAuditEvent(ctx, event)
+}
+
+func (b *S3Broker) writeAuditEvent(ctx context.Context, event AuditEvent) {
+	if err := b.audit.Write(context.WithoutCancel(ctx), event); err != nil {
+		b.logger.Error("write-audit-record", err, lager.Data{instanceIDLogKey: event.InstanceID, "action": event.Action})
+	}
+}
+
+type adminUserContextKey struct{}
+
+// withAdminUser returns the context of r, an admin API request, with the
+// broker username that r authenticated with, for AuditAction.
+func withAdminUser(r *http.Request) context.Context {
+	username, _, _ := r.BasicAuth()
+	return context.WithValue(r.Context(), adminUserContextKey{}, username)
+}
+
+func adminUser(ctx context.Context) string {
+	username, _ := ctx.Value(adminUserContextKey{}).(string)
+	return username
+}
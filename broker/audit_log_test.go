@@ -0,0 +1,228 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/cloud-gov/s3-broker/auditlog"
+)
+
+type mockAuditSink struct {
+	bytes.Buffer
+	err error
+}
+
+func (s *mockAuditSink) Write(ctx context.Context, line []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	_, err := s.Buffer.Write(line)
+	return err
+}
+
+func TestWriteAuditRecord(t *testing.T) {
+	testCases := map[string]struct {
+		bindingID string
+		action    string
+		details   auditDetails
+		expect    AuditEvent
+	}{
+		"provision": {
+			action:  "provision",
+			details: auditDetails{parameters: json.RawMessage(`{"versioning": true}`)},
+			expect: AuditEvent{
+				Event: Event{
+					Action:     "provision",
+					InstanceID: "instance1",
+					PlanID:     "plan1",
+					Bucket:     "prefix-instance1",
+					BucketARN:  "arn:aws:s3:::prefix-instance1",
+					Succeeded:  true,
+				},
+				Parameters: json.RawMessage(`{"versioning":true}`),
+			},
+		},
+		"bind": {
+			bindingID: "binding1",
+			action:    "bind",
+			details: auditDetails{credentials: Credentials{
+				AccessKeyID:     "AKIAEXAMPLE",
+				SecretAccessKey: "secret",
+				RoleARN:         "arn:aws:iam::123456789012:role/binding1",
+			}},
+			expect: AuditEvent{
+				Event: Event{
+					Action:     "bind",
+					InstanceID: "instance1",
+					BindingID:  "binding1",
+					PlanID:     "plan1",
+					Bucket:     "prefix-instance1",
+					BucketARN:  "arn:aws:s3:::prefix-instance1",
+					Succeeded:  true,
+				},
+				AccessKeyID: "AKIAEXAMPLE",
+				RoleARN:     "arn:aws:iam::123456789012:role/binding1",
+			},
+		},
+		"parameters that are not JSON": {
+			action:  "update",
+			details: auditDetails{parameters: json.RawMessage(`{"versioning"`)},
+			expect: AuditEvent{
+				Event: Event{
+					Action:     "update",
+					InstanceID: "instance1",
+					PlanID:     "plan1",
+					Bucket:     "prefix-instance1",
+					BucketARN:  "arn:aws:s3:::prefix-instance1",
+					Succeeded:  true,
+				},
+				Parameters: json.RawMessage(`"{\"versioning\""`),
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			sink := &mockAuditSink{}
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-audit-log"),
+				bucketPrefix: "prefix",
+				awsPartition: "aws",
+				catalog:      &mockCatalog{plans: map[string]ServicePlan{"plan1": {ID: "plan1"}}},
+				audit:        auditlog.New(sink, nil, auditlog.Record{}),
+			}
+			b.recordOperation(context.Background(), "instance1", tc.bindingID, "plan1", tc.action, tc.details, nil)
+
+			var record struct {
+				Event AuditEvent `json:"event"`
+			}
+			if err := json.Unmarshal(sink.Bytes(), &record); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(tc.expect, record.Event, cmpopts.IgnoreFields(Event{}, "Time")); diff != "" {
+				t.Errorf("unexpected audit event (-want +got):\n%s", diff)
+			}
+			if bytes.Contains(sink.Bytes(), []byte("secret")) {
+				t.Error("expected the audit record to leave out the secret access key")
+			}
+		})
+	}
+}
+
+func TestWriteAuditRecordFailure(t *testing.T) {
+	operations := NewMemoryOperationStore()
+	b := &S3Broker{
+		logger:     lager.NewLogger("broker-unit-test-audit-log"),
+		catalog:    &mockCatalog{},
+		operations: operations,
+		audit:      auditlog.New(&mockAuditSink{err: errors.New("disk full")}, nil, auditlog.Record{}),
+	}
+	b.recordOperation(context.Background(), "instance1", "", "plan1", "provision", auditDetails{}, nil)
+
+	recorded, _ := operations.ListOperations("instance1", 1)
+	if len(recorded) != 1 {
+		t.Errorf("expected the operation to be recorded even though its audit record failed, got %v", recorded)
+	}
+}
+
+func TestAuditAction(t *testing.T) {
+	testCases := map[string]struct {
+		method       string
+		path         string
+		handler      func(*S3Broker) http.HandlerFunc
+		user         *mockUser
+		expectStatus int
+		expect       []AuditEvent
+	}{
+		"rotation revokes leftover keys": {
+			method:       http.MethodPost,
+			path:         "/bindings/binding1/rotate",
+			handler:      func(b *S3Broker) http.HandlerFunc { return b.ServeRotate },
+			expectStatus: http.StatusOK,
+			expect: []AuditEvent{
+				{Event: Event{Action: "revoke-access-key", BindingID: "binding1", User: "operator", Succeeded: true}, AccessKeyID: "-binding1-0"},
+				{Event: Event{Action: "rotate-access-key", BindingID: "binding1", User: "operator", Succeeded: true}, AccessKeyID: "-binding1-2"},
+			},
+		},
+		"failed rotation": {
+			method:       http.MethodPost,
+			path:         "/bindings/binding1/rotate",
+			handler:      func(b *S3Broker) http.HandlerFunc { return b.ServeRotate },
+			user:         &mockUser{listAccessKeysErr: awserr.New(iam.ErrCodeNoSuchEntityException, "not found", nil)},
+			expectStatus: http.StatusNotFound,
+			expect: []AuditEvent{
+				{Event: Event{Action: "rotate-access-key", BindingID: "binding1", User: "operator", Error: ErrBindingNotRotatable.Error()}},
+			},
+		},
+		"lifted quarantine": {
+			method:       http.MethodDelete,
+			path:         "/instances/instance1/bindings/binding1/quarantine",
+			handler:      func(b *S3Broker) http.HandlerFunc { return b.ServeQuarantine },
+			expectStatus: http.StatusNoContent,
+			expect: []AuditEvent{
+				{Event: Event{Action: "lift-quarantine", InstanceID: "instance1", BindingID: "binding1", Bucket: "cg-instance1", User: "operator", Succeeded: true}},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			user := tc.user
+			if user == nil {
+				user = &mockUser{
+					accessKeys: map[string][]string{"-binding1": {"-binding1-1", "-binding1-0"}},
+					accessKeyCreateDates: map[string]time.Time{
+						"-binding1-0": time.Now().Add(-48 * time.Hour),
+						"-binding1-1": time.Now().Add(-2 * time.Hour),
+					},
+				}
+			}
+			sink := &mockAuditSink{}
+			b := &S3Broker{
+				logger:                 lager.NewLogger("broker-unit-test-audit-log"),
+				user:                   user,
+				bucket:                 &mockBucket{},
+				catalog:                &mockCatalog{},
+				bucketPrefix:           "cg",
+				keyRotationGracePeriod: time.Hour,
+				audit:                  auditlog.New(sink, nil, auditlog.Record{}),
+			}
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /bindings/{binding_id}/rotate", tc.handler(b))
+			mux.HandleFunc("DELETE /instances/{instance_id}/bindings/{binding_id}/quarantine", tc.handler(b))
+
+			request := httptest.NewRequest(tc.method, tc.path, nil)
+			request.SetBasicAuth("operator", "secret")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, request)
+			if rec.Code != tc.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectStatus, rec.Code, rec.Body)
+			}
+
+			var audited []AuditEvent
+			decoder := json.NewDecoder(&sink.Buffer)
+			for decoder.More() {
+				var record struct {
+					Event AuditEvent `json:"event"`
+				}
+				if err := decoder.Decode(&record); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				audited = append(audited, record.Event)
+			}
+			if diff := cmp.Diff(tc.expect, audited, cmpopts.IgnoreFields(Event{}, "Time")); diff != "" {
+				t.Errorf("unexpected audit events (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
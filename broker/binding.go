@@ -0,0 +1,278 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// redactedValue replaces secrets in fetched bindings if the operator asks for
+// redaction.
+const redactedValue = "REDACTED"
+
+var (
+	ErrBindingRetrievalDisabled = apiresponses.NewFailureResponse(
+		errors.New("This broker is not configured to return existing bindings. Contact your Cloud Foundry operator for details."),
+		http.StatusBadRequest,
+		"binding-retrieval-disabled",
+	)
+	ErrBindingCredentialsUnavailable = apiresponses.NewFailureResponse(
+		errors.New("The broker does not have the credentials of this binding. If the binding exists, unbind and bind again to get new credentials."),
+		http.StatusNotFound,
+		"binding-credentials-unavailable",
+	)
+	ErrStoredBindingNotFound = errors.New("stored binding not found")
+)
+
+// StoredBinding is what the broker keeps about a binding so that platforms
+// can fetch it again, and so that replayed binds get the same response.
+type StoredBinding struct {
+	InstanceID string
+	BindingID  string
+	// Account is the account the binding's principal is in, if it is not
+	// the broker's own.
+	Account string
+	// Credentials are the binding's credentials before they were rendered in
+	// CredentialFormat.
+	Credentials      Credentials
+	CredentialFormat string
+	// CredHubRef is the name the credentials were stored under in CredHub,
+	// if the binding returned a reference to them.
+	CredHubRef string
+	// ObjectStore is the object store the binding's credentials are for, if
+	// it is not S3.
+	ObjectStore string
+}
+
+// BindingStore persists bindings between Bind, replays of it, GetBinding,
+// key rotation and Unbind.
+type BindingStore interface {
+	GetBinding(bindingID string) (StoredBinding, error)
+	SaveBinding(binding StoredBinding) error
+	DeleteBinding(bindingID string) error
+}
+
+// MemoryBindingStore keeps bindings in process memory. They are lost on
+// restart, after which only bindings with credentials in CredHub can be
+// fetched.
+type MemoryBindingStore struct {
+	mu       sync.Mutex
+	bindings map[string]StoredBinding
+}
+
+func NewMemoryBindingStore() *MemoryBindingStore {
+	return &MemoryBindingStore{
+		bindings: make(map[string]StoredBinding),
+	}
+}
+
+func (m *MemoryBindingStore) GetBinding(bindingID string) (StoredBinding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	binding, ok := m.bindings[bindingID]
+	if !ok {
+		return StoredBinding{}, ErrStoredBindingNotFound
+	}
+	return binding, nil
+}
+
+func (m *MemoryBindingStore) SaveBinding(binding StoredBinding) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bindings[binding.BindingID] = binding
+	return nil
+}
+
+func (m *MemoryBindingStore) DeleteBinding(bindingID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bindings, bindingID)
+	return nil
+}
+
+// saveBinding keeps the credentials that Bind returned, along with the format
+// they were rendered in, so GetBinding can return them again.
+func (b *S3Broker) saveBinding(
+	instanceID, bindingID string,
+	details domain.BindDetails,
+	credentials Credentials,
+	binding domain.Binding,
+) error {
+	var bindParameters BindParameters
+	if len(details.RawParameters) > 0 {
+		if err := json.Unmarshal(details.RawParameters, &bindParameters); err != nil {
+			return err
+		}
+	}
+	servicePlan, _ := b.catalog.FindServicePlan(details.PlanID)
+
+	stored := StoredBinding{
+		InstanceID:       instanceID,
+		BindingID:        bindingID,
+		Account:          b.account,
+		Credentials:      credentials,
+		CredentialFormat: credentialFormatName(servicePlan, bindParameters),
+		ObjectStore:      servicePlan.S3Properties.ObjectStore,
+	}
+	if rendered, ok := binding.Credentials.(map[string]string); ok {
+		stored.CredHubRef = rendered[credhubRefKey]
+	}
+	if err := b.bindings.SaveBinding(stored); err != nil {
+		b.logger.Error("bind: error saving binding", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+		})
+		return err
+	}
+	return nil
+}
+
+// GetBinding returns the credentials of a binding as Bind returned them, or
+// with their secrets redacted if the operator configured redaction.
+// Credentials stored in CredHub are returned as their reference.
+func (b *S3Broker) GetBinding(
+	ctx context.Context,
+	instanceID,
+	bindingID string,
+	details domain.FetchBindingDetails,
+) (domain.GetBindingSpec, error) {
+	b = b.forRequest(ctx)
+	b.logger.Debug("get-binding", lager.Data{
+		instanceIDLogKey: instanceID,
+		bindingIDLogKey:  bindingID,
+		detailsLogKey:    details,
+	})
+
+	if !b.bindingsRetrievable || b.bindings == nil {
+		return domain.GetBindingSpec{}, ErrBindingRetrievalDisabled
+	}
+
+	stored, err := b.bindings.GetBinding(bindingID)
+	if errors.Is(err, ErrStoredBindingNotFound) {
+		return b.credhubBinding(ctx, bindingID, details)
+	}
+	if err != nil {
+		return domain.GetBindingSpec{}, err
+	}
+	if stored.InstanceID != instanceID {
+		return domain.GetBindingSpec{}, apiresponses.ErrBindingDoesNotExist
+	}
+
+	credentials, err := b.renderStoredBinding(stored, b.redactBindings)
+	if err != nil {
+		return domain.GetBindingSpec{}, err
+	}
+	return domain.GetBindingSpec{Credentials: credentials}, nil
+}
+
+// renderStoredBinding returns a stored binding's credentials in the form Bind
+// returned them, optionally with their secrets redacted.
+func (b *S3Broker) renderStoredBinding(stored StoredBinding, redact bool) (any, error) {
+	if stored.CredHubRef != "" {
+		return map[string]string{credhubRefKey: stored.CredHubRef}, nil
+	}
+
+	credentials := stored.Credentials
+	if redact {
+		credentials = b.redactCredentials(credentials)
+	}
+	return b.renderCredentials(stored, credentials)
+}
+
+// renderCredentials renders credentials in the format of a stored binding.
+func (b *S3Broker) renderCredentials(stored StoredBinding, credentials Credentials) (any, error) {
+	format, ok := b.findCredentialFormat(stored.CredentialFormat)
+	if !ok {
+		return nil, fmt.Errorf("credential format %q of binding %s is no longer configured", stored.CredentialFormat, stored.BindingID)
+	}
+	if format == nil {
+		return credentials, nil
+	}
+	return format.Render(credentials)
+}
+
+// credhubBinding re-derives the CredHub reference of a binding that the store
+// does not have, for example because the broker restarted since Bind.
+func (b *S3Broker) credhubBinding(ctx context.Context, bindingID string, details domain.FetchBindingDetails) (domain.GetBindingSpec, error) {
+	if b.credhub == nil || details.ServiceID == "" {
+		return domain.GetBindingSpec{}, ErrBindingCredentialsUnavailable
+	}
+
+	name := b.credhubName(details.ServiceID, bindingID)
+	exists, err := b.credhub.Exists(ctx, name)
+	if err != nil {
+		return domain.GetBindingSpec{}, err
+	}
+	if !exists {
+		return domain.GetBindingSpec{}, ErrBindingCredentialsUnavailable
+	}
+	return domain.GetBindingSpec{Credentials: map[string]string{credhubRefKey: name}}, nil
+}
+
+// redactCredentials replaces the secrets in credentials with redactedValue.
+// Access key IDs, role ARNs and external IDs are not secret and are kept.
+func (b *S3Broker) redactCredentials(credentials Credentials) Credentials {
+	redact := func(value *string) {
+		if *value != "" {
+			*value = redactedValue
+		}
+	}
+	redact(&credentials.SecretAccessKey)
+	redact(&credentials.SessionToken)
+	redact(&credentials.RefreshToken)
+	redact(&credentials.PresignToken)
+	if credentials.URI != "" {
+		credentials.URI = b.GetBucketURI(credentials)
+	}
+	return credentials
+}
+
+// updateStoredAccessKey replaces the access key of a stored binding after the
+// key was rotated, along with the copy of its credentials in its Secrets
+// Manager secret or CredHub credential.
+func (b *S3Broker) updateStoredAccessKey(ctx context.Context, stored StoredBinding, accessKeyID, secretAccessKey string) error {
+	credentials := stored.Credentials
+	credentials.AccessKeyID = accessKeyID
+	credentials.SecretAccessKey = secretAccessKey
+
+	// The secret holds the credentials as Bind made them, and the stored
+	// binding only their secret's ARN.
+	if secretARN := stored.Credentials.SecretARN; secretARN != "" {
+		if b.secrets == nil {
+			return ErrSecretsManagerDisabled
+		}
+		credentials.SecretARN, credentials.SecretName = "", ""
+		credentials.URI = b.GetBucketURI(credentials)
+		value, err := json.Marshal(credentials)
+		if err != nil {
+			return err
+		}
+		return b.secrets.Update(ctx, secretARN, string(value))
+	}
+	if stored.Credentials.AccessKeyID == "" {
+		return nil
+	}
+
+	credentials.URI = b.GetBucketURI(credentials)
+	stored.Credentials = credentials
+	if stored.CredHubRef != "" {
+		if b.credhub == nil {
+			return fmt.Errorf("binding %s has credentials in CredHub, which is not configured", stored.BindingID)
+		}
+		rendered, err := b.renderCredentials(stored, credentials)
+		if err != nil {
+			return err
+		}
+		if err := b.credhub.Put(ctx, stored.CredHubRef, rendered); err != nil {
+			return err
+		}
+	}
+	return b.bindings.SaveBinding(stored)
+}
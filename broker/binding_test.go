@@ -0,0 +1,266 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+func TestGetBinding(t *testing.T) {
+	testCases := map[string]struct {
+		disabled      bool
+		redact        bool
+		credhub       *mockCredHub
+		appBinding    bool
+		parameters    string
+		restart       bool
+		instanceID    string
+		expectErr     error
+		expectToken   string
+		expectRef     bool
+		expectFormat  bool
+		expectRetired bool
+	}{
+		"returns stored credentials": {
+			parameters: `{"credential_type": "presigned-url"}`,
+		},
+		"redacts secrets": {
+			parameters:  `{"credential_type": "presigned-url"}`,
+			redact:      true,
+			expectToken: redactedValue,
+		},
+		"renders redacted credentials in the binding's format": {
+			parameters:   `{"credential_type": "presigned-url", "credential_format": "presign"}`,
+			redact:       true,
+			expectFormat: true,
+		},
+		"returns credhub references": {
+			parameters: `{"credential_type": "presigned-url"}`,
+			credhub:    &mockCredHub{},
+			appBinding: true,
+			expectRef:  true,
+		},
+		"re-derives credhub references after a restart": {
+			parameters: `{"credential_type": "presigned-url"}`,
+			credhub:    &mockCredHub{},
+			appBinding: true,
+			restart:    true,
+			expectRef:  true,
+		},
+		"credentials lost in a restart": {
+			parameters: `{"credential_type": "presigned-url"}`,
+			restart:    true,
+			expectErr:  ErrBindingCredentialsUnavailable,
+		},
+		"binding of another instance": {
+			parameters: `{"credential_type": "presigned-url"}`,
+			instanceID: "instance2",
+			expectErr:  apiresponses.ErrBindingDoesNotExist,
+		},
+		"disabled": {
+			parameters: `{"credential_type": "presigned-url"}`,
+			disabled:   true,
+			expectErr:  ErrBindingRetrievalDisabled,
+		},
+		"unbound": {
+			parameters:    `{"credential_type": "presigned-url"}`,
+			expectRetired: true,
+			expectErr:     ErrBindingCredentialsUnavailable,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withPresignBindings)
+			b.bindings = NewMemoryBindingStore()
+			b.bindingsRetrievable = true
+			b.redactBindings = tc.redact
+			b.credentialFormats = map[string]CredentialFormat{"presign": {"token": "{{.PresignToken}}"}}
+			if tc.credhub != nil {
+				b.credhub = tc.credhub
+				b.credhubClientID = "s3-broker"
+			}
+
+			details := domain.BindDetails{
+				PlanID:        "plan1",
+				ServiceID:     "service1",
+				RawParameters: json.RawMessage(tc.parameters),
+			}
+			if tc.appBinding {
+				details.BindResource = &domain.BindResource{AppGuid: "app1"}
+			}
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", details, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if tc.restart {
+				b.bindings = NewMemoryBindingStore()
+			}
+			if tc.disabled {
+				b.bindingsRetrievable = false
+			}
+			if tc.expectRetired {
+				if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{PlanID: "plan1", ServiceID: "service1"}, false); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+			instanceID := tc.instanceID
+			if instanceID == "" {
+				instanceID = "instance1"
+			}
+
+			spec, err := b.GetBinding(context.Background(), instanceID, "binding1", domain.FetchBindingDetails{PlanID: "plan1", ServiceID: "service1"})
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			switch {
+			case tc.expectRef:
+				ref, ok := spec.Credentials.(map[string]string)
+				if !ok || ref[credhubRefKey] != "/c/s3-broker/service1/binding1/credentials" {
+					t.Errorf("expected a credhub reference, got %+v", spec.Credentials)
+				}
+			case tc.expectFormat:
+				rendered, ok := spec.Credentials.(map[string]string)
+				if !ok || rendered["token"] != redactedValue {
+					t.Errorf("expected redacted presign credentials, got %+v", spec.Credentials)
+				}
+			default:
+				credentials, ok := spec.Credentials.(Credentials)
+				if !ok {
+					t.Fatalf("expected credentials, got %+v", spec.Credentials)
+				}
+				expectToken := tc.expectToken
+				if expectToken == "" {
+					expectToken = binding.Credentials.(Credentials).PresignToken
+				}
+				if credentials.PresignToken != expectToken {
+					t.Errorf("expected presign token %q, got %q", expectToken, credentials.PresignToken)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactCredentials(t *testing.T) {
+	b := &S3Broker{}
+	credentials := Credentials{
+		AccessKeyID:     "AKIA123",
+		SecretAccessKey: "secret",
+		SessionToken:    "session",
+		FIPSEndpoint:    "s3-fips.us-gov-west-1.amazonaws.com",
+		Bucket:          "bucket1",
+		RoleARN:         "arn:aws:iam::123456789012:role/binding1",
+	}
+	credentials.URI = b.GetBucketURI(credentials)
+
+	redacted := b.redactCredentials(credentials)
+	expected := Credentials{
+		AccessKeyID:     "AKIA123",
+		SecretAccessKey: redactedValue,
+		SessionToken:    redactedValue,
+		FIPSEndpoint:    "s3-fips.us-gov-west-1.amazonaws.com",
+		Bucket:          "bucket1",
+		RoleARN:         "arn:aws:iam::123456789012:role/binding1",
+		URI:             "s3://AKIA123:REDACTED@s3-fips.us-gov-west-1.amazonaws.com/bucket1",
+	}
+	if redacted.URI != expected.URI || redacted.SecretAccessKey != expected.SecretAccessKey ||
+		redacted.SessionToken != expected.SessionToken || redacted.RefreshToken != "" ||
+		redacted.AccessKeyID != expected.AccessKeyID || redacted.RoleARN != expected.RoleARN {
+		t.Errorf("expected %+v, got %+v", expected, redacted)
+	}
+}
+
+func TestBindEndpoints(t *testing.T) {
+	govDetails := awss3.BucketDetails{
+		BucketName:            "-instance1",
+		Region:                "us-gov-west-1",
+		RegionalEndpoint:      "s3.us-gov-west-1.amazonaws.com",
+		DualStackEndpoint:     "s3.dualstack.us-gov-west-1.amazonaws.com",
+		FIPSEndpoint:          "s3-fips.us-gov-west-1.amazonaws.com",
+		FIPSDualStackEndpoint: "s3-fips.dualstack.us-gov-west-1.amazonaws.com",
+		VirtualHostedURL:      "https://-instance1.s3.us-gov-west-1.amazonaws.com",
+		DualStackURL:          "https://-instance1.s3.dualstack.us-gov-west-1.amazonaws.com",
+	}
+	euDetails := awss3.BucketDetails{
+		BucketName:        "-instance1",
+		Region:            "eu-west-1",
+		RegionalEndpoint:  "s3.eu-west-1.amazonaws.com",
+		DualStackEndpoint: "s3.dualstack.eu-west-1.amazonaws.com",
+		VirtualHostedURL:  "https://-instance1.s3.eu-west-1.amazonaws.com",
+		DualStackURL:      "https://-instance1.s3.dualstack.eu-west-1.amazonaws.com",
+	}
+
+	testCases := map[string]struct {
+		details         awss3.BucketDetails
+		brokerDualStack bool
+		planDualStack   bool
+		expectEndpoint  string
+		expectURL       string
+	}{
+		"FIPS endpoint": {
+			details:        govDetails,
+			expectEndpoint: "s3-fips.us-gov-west-1.amazonaws.com",
+			expectURL:      "https://-instance1.s3.us-gov-west-1.amazonaws.com",
+		},
+		"regional endpoint without FIPS": {
+			details:        euDetails,
+			expectEndpoint: "s3.eu-west-1.amazonaws.com",
+			expectURL:      "https://-instance1.s3.eu-west-1.amazonaws.com",
+		},
+		"dual-stack plan": {
+			details:        govDetails,
+			planDualStack:  true,
+			expectEndpoint: "s3-fips.dualstack.us-gov-west-1.amazonaws.com",
+			expectURL:      "https://-instance1.s3.dualstack.us-gov-west-1.amazonaws.com",
+		},
+		"dual-stack broker without FIPS": {
+			details:         euDetails,
+			brokerDualStack: true,
+			expectEndpoint:  "s3.dualstack.eu-west-1.amazonaws.com",
+			expectURL:       "https://-instance1.s3.dualstack.eu-west-1.amazonaws.com",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger:                lager.NewLogger("broker-unit-test-bind-endpoints"),
+				bucket:                &mockBucket{describeDetails: tc.details},
+				catalog:               &mockCatalog{planName: "plan1", serviceName: "service1", s3Properties: S3Properties{DualStack: tc.planDualStack}},
+				tagManager:            &mockTagGenerator{},
+				user:                  &mockUser{},
+				awsPartition:          "aws",
+				useDualStackEndpoints: tc.brokerDualStack,
+			}
+
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				PlanID:    "planid1",
+				ServiceID: "serviceid1",
+			}, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			credentials := binding.Credentials.(Credentials)
+			if credentials.Endpoint != tc.expectEndpoint {
+				t.Errorf("expected endpoint %q, got %q", tc.expectEndpoint, credentials.Endpoint)
+			}
+			if credentials.BucketURL != tc.expectURL {
+				t.Errorf("expected bucket URL %q, got %q", tc.expectURL, credentials.BucketURL)
+			}
+		})
+	}
+}
@@ -1,11 +1,17 @@
 package broker
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -16,8 +22,18 @@ import (
 	"github.com/pivotal-cf/brokerapi/v10/domain"
 	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
 
+	"github.com/cloud-gov/s3-broker/auditlog"
+	"github.com/cloud-gov/s3-broker/awsevents"
 	"github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awskms"
+	"github.com/cloud-gov/s3-broker/awsmetrics"
+	"github.com/cloud-gov/s3-broker/awsretry"
 	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/awssecrets"
+	"github.com/cloud-gov/s3-broker/awssts"
+	"github.com/cloud-gov/s3-broker/credhub"
+	"github.com/cloud-gov/s3-broker/objectstore"
+	"github.com/cloud-gov/s3-broker/state"
 
 	brokertags "github.com/cloud-gov/go-broker-tags"
 )
@@ -36,14 +52,70 @@ type S3Broker struct {
 	iamPath                      string
 	userPrefix                   string
 	policyPrefix                 string
+	userNameTemplate             string
+	policyNameTemplate           string
 	bucketPrefix                 string
 	awsPartition                 string
+	region                       string
+	useDualStackEndpoints        bool
+	regions                      []string
+	unsupportedFeatures          []string
+	events                       awsevents.Publisher
+	audit                        *auditlog.Log
+	metrics                      awsmetrics.Recorder
+	storage                      StorageReader
+	alarms                       StorageAlarms
+	requestID                    string
 	allowUserProvisionParameters bool
 	allowUserUpdateParameters    bool
 	allowUserBindParameters      bool
 	catalog                      Catalog
+	account                      string
+	accounts                     map[string]*S3Broker
 	bucket                       awss3.Bucket
+	objectStores                 map[string]objectstore.BucketProvider
 	user                         awsiam.User
+	role                         awsiam.Role
+	group                        awsiam.Group
+	useInstanceGroups            bool
+	allowBucketPolicyBindings    bool
+	restrictSharedBindings       bool
+	quotas                       *atomic.Pointer[QuotasConfig]
+	operatorPolicy               OperatorPolicy
+	minAPIVersion                APIVersion
+	grants                       awskms.Grants
+	secrets                      awssecrets.Secrets
+	secretNamePrefix             string
+	credentialFormats            map[string]CredentialFormat
+	credentialIssuer             awssts.CredentialIssuer
+	temporaryBindings            TemporaryBindingStore
+	deprovisions                 DeprovisionStore
+	instanceLocks                *InstanceLocks
+	lockOwner                    string
+	temporaryCredentialsTTL      time.Duration
+	refreshURL                   string
+	temporarySessionTags         bool
+	credhub                      credhub.Store
+	credhubClientID              string
+	presignBindings              PresignBindingStore
+	presignURL                   string
+	presignMaxTTL                time.Duration
+	keyRotationGracePeriod       time.Duration
+	staleAccessKeys              StaleAccessKeysConfig
+	reconcile                    ReconcileConfig
+	garbageCollection            GarbageCollectionConfig
+	usage                        UsageConfig
+	alerts                       AlertsConfig
+	leaderElection               LeaderElectionConfig
+	leader                       *atomic.Bool
+	bindings                     BindingStore
+	bindingsRetrievable          bool
+	redactBindings               bool
+	requests                     RequestStore
+	operations                   OperationStore
+	state                        state.Store
+	dashboardURL                 string
+	dashboardSecret              []byte
 	cf                           *cf.Client
 	logger                       lager.Logger
 	tagManager                   brokertags.TagManager
@@ -62,29 +134,136 @@ type Credentials struct {
 	Bucket             string   `json:"bucket"`
 	Endpoint           string   `json:"endpoint"`
 	FIPSEndpoint       string   `json:"fips_endpoint"`
+	RegionalEndpoint   string   `json:"regional_endpoint"`
+	DualStackEndpoint  string   `json:"dualstack_endpoint"`
+	BucketURL          string   `json:"bucket_url"`
+	BucketURI          string   `json:"bucket_uri"`
 	AdditionalBuckets  []string `json:"additional_buckets"`
+	PathPrefix         string   `json:"path_prefix,omitempty"`
+	SessionToken       string   `json:"session_token,omitempty"`
+	Expiration         string   `json:"expiration,omitempty"`
+	RefreshURL         string   `json:"refresh_url,omitempty"`
+	RefreshToken       string   `json:"refresh_token,omitempty"`
+	PresignURL         string   `json:"presign_url,omitempty"`
+	PresignToken       string   `json:"presign_token,omitempty"`
+	SecretARN          string   `json:"secret_arn,omitempty"`
+	SecretName         string   `json:"secret_name,omitempty"`
+	RoleARN            string   `json:"role_arn,omitempty"`
+	BucketARN          string   `json:"bucket_arn,omitempty"`
+	ExternalID         string   `json:"external_id,omitempty"`
+	SASToken           string   `json:"sas_token,omitempty"`
 }
 
 func New(
 	config Config,
 	bucket awss3.Bucket,
 	user awsiam.User,
+	role awsiam.Role,
+	group awsiam.Group,
+	grants awskms.Grants,
+	secrets awssecrets.Secrets,
+	credentialIssuer awssts.CredentialIssuer,
+	credentialStore credhub.Store,
+	stateStore state.Store,
 	cfClient *cf.Client,
 	logger lager.Logger,
 	tagManager brokertags.TagManager,
 ) *S3Broker {
+	ttl := config.TemporaryCredentials.TTL
+	if ttl == 0 {
+		ttl = defaultTemporaryCredentialsTTL
+	}
+	gracePeriod := config.KeyRotation.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultKeyRotationGracePeriod
+	}
+	var presignURL string
+	if config.PresignedURLs.Enabled {
+		presignURL = strings.TrimSuffix(config.PresignedURLs.URL, "/")
+	}
+	var dashboardURL string
+	if config.Dashboard.Enabled {
+		dashboardURL = strings.TrimSuffix(config.Dashboard.URL, "/")
+	}
+	// Without min_api_version, the zero version accepts every request.
+	minAPIVersion, _ := ParseAPIVersion(config.MinAPIVersion)
+	presignMaxTTL := config.PresignedURLs.MaxTTL
+	if presignMaxTTL == 0 {
+		presignMaxTTL = defaultPresignedURLMaxTTL
+	}
+	var bindings BindingStore = NewMemoryBindingStore()
+	var operations OperationStore = NewMemoryOperationStore()
+	var temporaryBindings TemporaryBindingStore = NewMemoryTemporaryBindingStore()
+	var presignBindings PresignBindingStore = NewMemoryPresignBindingStore()
+	var deprovisions DeprovisionStore = NewMemoryDeprovisionStore()
+	var requests RequestStore = NewMemoryRequestStore()
+	if stateStore != nil {
+		bindings = stateBindingStore{store: stateStore}
+		operations = stateOperationStore{store: stateStore}
+		temporaryBindings = stateTemporaryBindingStore{records: stateRecords{store: stateStore, kind: temporaryBindingRecordKind}}
+		presignBindings = statePresignBindingStore{records: stateRecords{store: stateStore, kind: presignBindingRecordKind}}
+		deprovisions = stateDeprovisionStore{records: stateRecords{store: stateStore, kind: deprovisionRecordKind}}
+		requests = stateRequestStore{records: stateRecords{store: stateStore, kind: requestRecordKind}}
+	}
 	return &S3Broker{
 		insecureSkipVerify:           config.InsecureSkipVerify,
 		iamPath:                      config.IamPath,
 		userPrefix:                   config.UserPrefix,
 		policyPrefix:                 config.PolicyPrefix,
+		userNameTemplate:             config.UserNameTemplate,
+		policyNameTemplate:           config.PolicyNameTemplate,
 		bucketPrefix:                 config.BucketPrefix,
 		awsPartition:                 config.AwsPartition,
+		region:                       config.Region,
+		useDualStackEndpoints:        config.UseDualStackEndpoints,
+		regions:                      config.Regions,
+		unsupportedFeatures:          config.UnsupportedFeatures(),
 		allowUserProvisionParameters: config.AllowUserProvisionParameters,
 		allowUserUpdateParameters:    config.AllowUserUpdateParameters,
-		catalog:                      config.Catalog,
+		catalog:                      newReloadableCatalog(config.Catalog),
 		bucket:                       bucket,
 		user:                         user,
+		role:                         role,
+		group:                        group,
+		useInstanceGroups:            config.UseInstanceGroups,
+		allowBucketPolicyBindings:    config.AllowBucketPolicyBindings,
+		restrictSharedBindings:       config.RestrictSharedBindings,
+		quotas:                       newQuotas(config.Quotas),
+		operatorPolicy:               config.OperatorPolicy,
+		minAPIVersion:                minAPIVersion,
+		grants:                       grants,
+		secrets:                      secrets,
+		secretNamePrefix:             config.SecretsManager.NamePrefix,
+		credentialFormats:            config.CredentialFormats,
+		credentialIssuer:             credentialIssuer,
+		credhub:                      credentialStore,
+		credhubClientID:              config.CredHub.ClientID,
+		temporaryBindings:            temporaryBindings,
+		deprovisions:                 deprovisions,
+		instanceLocks:                NewInstanceLocks(),
+		lockOwner:                    newLockOwner(),
+		temporaryCredentialsTTL:      ttl,
+		refreshURL:                   strings.TrimSuffix(config.TemporaryCredentials.RefreshURL, "/"),
+		temporarySessionTags:         config.TemporaryCredentials.SessionTags,
+		presignBindings:              presignBindings,
+		presignURL:                   presignURL,
+		presignMaxTTL:                presignMaxTTL,
+		keyRotationGracePeriod:       gracePeriod,
+		staleAccessKeys:              config.StaleAccessKeys,
+		reconcile:                    config.Reconcile,
+		garbageCollection:            config.GarbageCollection,
+		usage:                        config.Usage,
+		alerts:                       config.Alerts,
+		leaderElection:               config.LeaderElection,
+		leader:                       new(atomic.Bool),
+		bindings:                     bindings,
+		bindingsRetrievable:          config.BindingRetrieval.Enabled,
+		redactBindings:               config.BindingRetrieval.Redact,
+		requests:                     requests,
+		operations:                   operations,
+		state:                        stateStore,
+		dashboardURL:                 dashboardURL,
+		dashboardSecret:              []byte(config.Dashboard.Secret),
 		cf:                           cfClient,
 		logger:                       logger.Session("broker"),
 		tagManager:                   tagManager,
@@ -92,6 +271,7 @@ func New(
 }
 
 func (b *S3Broker) Services(context context.Context) ([]brokerapi.Service, error) {
+	b = b.forRequest(context)
 	brokerCatalog, err := json.Marshal(b.catalog)
 	if err != nil {
 		b.logger.Error("marshal-error", err)
@@ -104,6 +284,24 @@ func (b *S3Broker) Services(context context.Context) ([]brokerapi.Service, error
 		return []brokerapi.Service{}, err
 	}
 
+	for i, service := range apiCatalog.Services {
+		// These fields are named differently in the broker's catalog, so
+		// they do not survive the conversion above.
+		if catalogService, ok := b.catalog.FindService(service.ID); ok {
+			apiCatalog.Services[i].PlanUpdatable = catalogService.PlanUpdatable
+		}
+		apiCatalog.Services[i].InstancesRetrievable = true
+		apiCatalog.Services[i].BindingsRetrievable = b.bindingsRetrievable
+		for j, plan := range service.Plans {
+			if servicePlan, ok := b.catalog.FindServicePlan(plan.ID); ok {
+				service.Plans[j].Schemas = b.planSchemas(servicePlan)
+				if supportsAPIVersion(context, apiVersionMaintenanceInfo) {
+					service.Plans[j].MaintenanceInfo = servicePlan.MaintenanceInfo
+				}
+			}
+		}
+	}
+
 	return apiCatalog.Services, nil
 }
 
@@ -112,12 +310,48 @@ func (b *S3Broker) Provision(
 	instanceID string,
 	details domain.ProvisionDetails,
 	asyncAllowed bool,
-) (domain.ProvisionedServiceSpec, error) {
+) (_ domain.ProvisionedServiceSpec, err error) {
+	b = b.forRequest(context)
 	b.logger.Debug("provision", lager.Data{
 		instanceIDLogKey:        instanceID,
 		detailsLogKey:           details,
 		acceptsIncompleteLogKey: asyncAllowed,
 	})
+	b.auditLog(context, "provision", lager.Data{instanceIDLogKey: instanceID, "plan-id": details.PlanID})
+	defer func() {
+		b.recordOperation(context, instanceID, "", details.PlanID, "provision", auditDetails{parameters: details.RawParameters}, err)
+	}()
+
+	unlock, err := b.lockInstance(instanceID)
+	if err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	defer unlock()
+	organizationGUID, spaceGUID := cfPlace(details.OrganizationGUID, details.SpaceGUID, details.RawContext)
+	defer func() {
+		if err == nil {
+			err = b.saveInstanceState(context, state.Instance{
+				InstanceID:       instanceID,
+				ServiceID:        details.ServiceID,
+				PlanID:           details.PlanID,
+				OrganizationGUID: organizationGUID,
+				SpaceGUID:        spaceGUID,
+			}, details.RawParameters)
+		}
+	}()
+	if err := b.checkNoDeprovision(instanceID); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+
+	// A platform that did not get the response to a provision sends it again.
+	fingerprint := provisionFingerprint(details)
+	replayed, err := b.checkProvisionReplay(instanceID, fingerprint)
+	if err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if replayed {
+		return domain.ProvisionedServiceSpec{AlreadyExists: true, DashboardURL: b.instanceDashboardURL(instanceID)}, nil
+	}
 
 	provisionParameters := ProvisionParameters{
 		// Default object ownership to "ObjectWriter" so that ACLs can be used.
@@ -125,26 +359,87 @@ func (b *S3Broker) Provision(
 		//   https://aws.amazon.com/blogs/aws/heads-up-amazon-s3-security-changes-are-coming-in-april-of-2023/
 		ObjectOwnership: s3.ObjectOwnershipObjectWriter,
 	}
+	servicePlan, ok := b.catalog.FindServicePlan(details.PlanID)
+	if !ok {
+		return domain.ProvisionedServiceSpec{}, fmt.Errorf("Service Plan '%s' not found", details.PlanID)
+	}
+	b = b.forPlan(servicePlan)
+	if err := checkMaintenanceInfo(servicePlan, details.MaintenanceInfo); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+
+	if err := checkOverrideParams(servicePlan, details.RawParameters); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if err := validateParameters(b.provisionSchema(servicePlan), details.RawParameters); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
 	if b.allowUserProvisionParameters && len(details.RawParameters) > 0 {
 		if err := json.Unmarshal(details.RawParameters, &provisionParameters); err != nil {
 			return domain.ProvisionedServiceSpec{}, err
 		}
 	}
+	if err := b.checkRegion(provisionParameters.Region); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if err := b.checkRegionPolicy(provisionParameters.Region); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if store := b.objectStore(servicePlan); store != nil {
+		return b.provisionObjectStore(context, store, instanceID, servicePlan, provisionParameters, details, fingerprint)
+	}
 
-	servicePlan, ok := b.catalog.FindServicePlan(details.PlanID)
-	if !ok {
-		return domain.ProvisionedServiceSpec{}, fmt.Errorf("Service Plan '%s' not found", details.PlanID)
+	if err := validateCORSRules(provisionParameters.CORSRules); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if err := validateLifecycleRules(provisionParameters.LifecycleRules); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if err := b.checkPlanPolicy(servicePlan, b.bucketName(instanceID), provisionParameters.Region); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if err := checkPlanAccess(servicePlan, details.OrganizationGUID, details.SpaceGUID, details.RawContext); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if err := b.checkQuotas(context, details.ServiceID, servicePlan, organizationGUID); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+
+	if servicePlan.S3Properties.ExistingBucket {
+		if err := b.adoptBucket(context, instanceID, servicePlan, details); err != nil {
+			return domain.ProvisionedServiceSpec{}, err
+		}
+		b.saveRequest(instanceRequestKey(instanceID), fingerprint)
+		return domain.ProvisionedServiceSpec{IsAsync: false, DashboardURL: b.instanceDashboardURL(instanceID)}, nil
 	}
 
-	instance, err := b.createBucket(instanceID, servicePlan, provisionParameters, details)
+	instance, err := b.createBucket(context, instanceID, servicePlan, provisionParameters, details)
 	if err != nil {
 		return domain.ProvisionedServiceSpec{}, err
 	}
-	if _, err = b.bucket.Create(b.bucketName(instanceID), *instance); err != nil {
-		return domain.ProvisionedServiceSpec{}, err
+	if _, err = b.bucket.Create(context, b.bucketName(instanceID), *instance); err != nil {
+		var stepErr *awss3.CreateStepError
+		if errors.As(err, &stepErr) {
+			b.logger.Error("provision: create bucket failed", err, lager.Data{
+				instanceIDLogKey: instanceID,
+				"step":           stepErr.Step,
+			})
+			if stepErr.Orphaned {
+				return domain.ProvisionedServiceSpec{}, orphanMitigation(err)
+			}
+		}
+		return domain.ProvisionedServiceSpec{}, mapBucketError(err)
+	}
+	if err := b.setAlertThreshold(context, instanceID, b.bucketName(instanceID), provisionParameters.AlertThresholdGB); err != nil {
+		return domain.ProvisionedServiceSpec{}, orphanMitigation(err)
 	}
+	b.saveRequest(instanceRequestKey(instanceID), fingerprint)
 
-	return domain.ProvisionedServiceSpec{IsAsync: false}, nil
+	return domain.ProvisionedServiceSpec{
+		IsAsync:      false,
+		DashboardURL: b.instanceDashboardURL(instanceID),
+		Metadata:     b.bucketMetadata(context, b.bucketName(instanceID), instance),
+	}, nil
 }
 
 func (b *S3Broker) Update(
@@ -152,34 +447,127 @@ func (b *S3Broker) Update(
 	instanceID string,
 	details domain.UpdateDetails,
 	asyncAllowed bool,
-) (domain.UpdateServiceSpec, error) {
+) (_ domain.UpdateServiceSpec, err error) {
+	b = b.forRequest(context)
 	b.logger.Debug("update", lager.Data{
 		instanceIDLogKey:        instanceID,
 		detailsLogKey:           details,
 		acceptsIncompleteLogKey: asyncAllowed,
 	})
+	// Upgrades reapply the plan's baseline configuration, like any update,
+	// but are told apart in the audit log and the dashboard.
+	action, auditData := "update", lager.Data{instanceIDLogKey: instanceID, "plan-id": details.PlanID}
+	if isUpgrade(details) {
+		action = "upgrade"
+		auditData["maintenance-version"] = details.MaintenanceInfo.Version
+	}
+	b.auditLog(context, action, auditData)
+	defer func() {
+		b.recordOperation(context, instanceID, "", details.PlanID, action, auditDetails{parameters: details.RawParameters}, err)
+	}()
 
-	updateParameters := UpdateParameters{}
-	if b.allowUserUpdateParameters && len(details.RawParameters) > 0 {
-		if err := json.Unmarshal(details.RawParameters, &updateParameters); err != nil {
-			return domain.UpdateServiceSpec{}, err
+	unlock, err := b.lockInstance(instanceID)
+	if err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+	defer unlock()
+	organizationGUID, spaceGUID := cfPlace(details.PreviousValues.OrgID, details.PreviousValues.SpaceID, details.RawContext)
+	defer func() {
+		if err == nil {
+			b.saveInstanceState(context, state.Instance{
+				InstanceID:       instanceID,
+				ServiceID:        details.ServiceID,
+				PlanID:           details.PlanID,
+				OrganizationGUID: organizationGUID,
+				SpaceGUID:        spaceGUID,
+			}, details.RawParameters)
 		}
+	}()
+	if err := b.checkNoDeprovision(instanceID); err != nil {
+		return domain.UpdateServiceSpec{}, err
 	}
 
 	servicePlan, ok := b.catalog.FindServicePlan(details.PlanID)
 	if !ok {
 		return domain.UpdateServiceSpec{}, fmt.Errorf("Service Plan '%s' not found", details.PlanID)
 	}
+	b = b.forPlan(servicePlan)
 
-	instance := b.modifyBucket(instanceID, servicePlan, updateParameters, details)
-	if err := b.bucket.Modify(b.bucketName(instanceID), *instance); err != nil {
-		if err == awss3.ErrBucketDoesNotExist {
-			return domain.UpdateServiceSpec{}, apiresponses.ErrInstanceDoesNotExist
+	if err := checkOverrideParams(servicePlan, details.RawParameters); err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+	if err := validateParameters(b.updateSchema(servicePlan), details.RawParameters); err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+	updateParameters := UpdateParameters{}
+	if b.allowUserUpdateParameters && len(details.RawParameters) > 0 {
+		if err := json.Unmarshal(details.RawParameters, &updateParameters); err != nil {
+			return domain.UpdateServiceSpec{}, err
+		}
+	}
+	if err := checkMaintenanceInfo(servicePlan, details.MaintenanceInfo); err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+
+	if previousPlan, ok := b.catalog.FindServicePlan(details.PreviousValues.PlanID); ok {
+		if previousPlan.S3Properties.ExistingBucket != servicePlan.S3Properties.ExistingBucket {
+			return domain.UpdateServiceSpec{}, ErrExistingBucketPlanChange
+		}
+		if previousPlan.S3Properties.Account != servicePlan.S3Properties.Account {
+			return domain.UpdateServiceSpec{}, ErrAccountPlanChange
 		}
+		if previousPlan.S3Properties.ObjectStore != servicePlan.S3Properties.ObjectStore {
+			return domain.UpdateServiceSpec{}, ErrObjectStorePlanChange
+		}
+		if err := checkPlanChange(previousPlan, servicePlan); err != nil {
+			return domain.UpdateServiceSpec{}, err
+		}
+		if previousPlan.ID != servicePlan.ID {
+			if err := checkPlanAccess(servicePlan, details.PreviousValues.OrgID, details.PreviousValues.SpaceID, details.RawContext); err != nil {
+				return domain.UpdateServiceSpec{}, err
+			}
+			if err := b.checkPlanQuota(context, details.ServiceID, servicePlan); err != nil {
+				return domain.UpdateServiceSpec{}, err
+			}
+		}
+	}
+	if store := b.objectStore(servicePlan); store != nil {
+		return b.updateObjectStore(context, store, instanceID, servicePlan, updateParameters, details)
+	}
+	if err := b.checkPlanPolicy(servicePlan, b.bucketName(instanceID), ""); err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+	if servicePlan.S3Properties.ExistingBucket {
+		// The broker does not manage the configuration of existing buckets.
+		return domain.UpdateServiceSpec{IsAsync: false, DashboardURL: b.instanceDashboardURL(instanceID)}, nil
+	}
+
+	if err := validateCORSRules(updateParameters.CORSRules); err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+	if err := validateLifecycleRules(updateParameters.LifecycleRules); err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+
+	instance, err := b.modifyBucket(context, instanceID, servicePlan, updateParameters, details)
+	if err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+	if err := b.bucket.Modify(context, b.bucketName(instanceID), *instance); err != nil {
+		return domain.UpdateServiceSpec{}, mapBucketError(err)
+	}
+	if err := b.setAlertThreshold(context, instanceID, b.bucketName(instanceID), updateParameters.AlertThresholdGB); err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+	if err := b.clearDroppedStorageQuota(context, instanceID, details.PreviousValues.PlanID, servicePlan); err != nil {
 		return domain.UpdateServiceSpec{}, err
 	}
 
-	return domain.UpdateServiceSpec{IsAsync: false}, nil
+	return domain.UpdateServiceSpec{
+		IsAsync:      false,
+		DashboardURL: b.instanceDashboardURL(instanceID),
+		Metadata:     b.bucketMetadata(context, b.bucketName(instanceID), instance),
+	}, nil
 }
 
 func (b *S3Broker) Deprovision(
@@ -187,25 +575,106 @@ func (b *S3Broker) Deprovision(
 	instanceID string,
 	details domain.DeprovisionDetails,
 	asyncAllowed bool,
-) (domain.DeprovisionServiceSpec, error) {
+) (_ domain.DeprovisionServiceSpec, err error) {
+	b = b.forRequest(context)
+	// Deprovisions must not take the AWS calls that provisions need.
+	context = awsretry.WithLowPriority(context)
 	b.logger.Debug("deprovision", lager.Data{
 		instanceIDLogKey:        instanceID,
 		detailsLogKey:           details,
 		acceptsIncompleteLogKey: asyncAllowed,
 	})
+	b.auditLog(context, "deprovision", lager.Data{instanceIDLogKey: instanceID, "plan-id": details.PlanID})
+	defer func() { b.recordOperation(context, instanceID, "", details.PlanID, "deprovision", auditDetails{}, err) }()
+
+	unlock, err := b.lockInstance(instanceID)
+	if err != nil {
+		return domain.DeprovisionServiceSpec{}, err
+	}
+	defer unlock()
+	// An asynchronous deprovision forgets the instance once its bucket is
+	// deleted, so that garbage collection does not take a bucket that failed
+	// to delete for an orphan.
+	var async bool
+	defer func() {
+		if err == nil && !async {
+			b.deleteInstanceState(context, instanceID)
+		}
+	}()
 
 	servicePlan, ok := b.catalog.FindServicePlan(details.PlanID)
 	if !ok {
 		return domain.DeprovisionServiceSpec{}, fmt.Errorf("Service Plan '%s' not found", details.PlanID)
 	}
-	if err := b.bucket.Delete(b.bucketName(instanceID), servicePlan.PlanDeletable); err != nil {
-		if err == awss3.ErrBucketDoesNotExist {
-			return domain.DeprovisionServiceSpec{}, brokerapi.ErrInstanceDoesNotExist
+	b = b.forPlan(servicePlan)
+
+	if store := b.objectStore(servicePlan); store != nil {
+		if err := store.DeleteBucket(context, b.bucketName(instanceID)); err != nil {
+			return domain.DeprovisionServiceSpec{}, mapObjectStoreError(err)
+		}
+		return domain.DeprovisionServiceSpec{IsAsync: false}, b.forgetRequest(instanceRequestKey(instanceID))
+	}
+
+	// The instance's bindings are gone by now, so its group is unused. If the
+	// bucket cannot be deleted, the next Bind recreates the group.
+	if b.group != nil {
+		if err := b.deleteInstanceGroup(instanceID); err != nil {
+			return domain.DeprovisionServiceSpec{}, err
 		}
+	}
+
+	if servicePlan.S3Properties.ExistingBucket {
+		if err := b.releaseBucket(context, instanceID); err != nil {
+			return domain.DeprovisionServiceSpec{}, err
+		}
+		return domain.DeprovisionServiceSpec{IsAsync: false}, b.forgetRequest(instanceRequestKey(instanceID))
+	}
+
+	// A kept bucket no longer belongs to an instance whose tenants could be
+	// alerted.
+	if err := b.deleteStorageAlarm(context, instanceID); err != nil {
 		return domain.DeprovisionServiceSpec{}, err
 	}
 
-	return domain.DeprovisionServiceSpec{IsAsync: false}, nil
+	preserve, err := b.preserveOnDelete(context, b.bucketName(instanceID), servicePlan)
+	if err != nil {
+		return domain.DeprovisionServiceSpec{}, err
+	}
+	if preserve {
+		if err := b.retainBucket(context, instanceID, b.bucketName(instanceID)); err != nil {
+			return domain.DeprovisionServiceSpec{}, err
+		}
+		return domain.DeprovisionServiceSpec{IsAsync: false}, b.forgetRequest(instanceRequestKey(instanceID))
+	}
+
+	// Deleting every object of a large bucket can take longer than the
+	// platform waits for a response.
+	if asyncAllowed && servicePlan.PlanDeletable && b.deprovisions != nil {
+		async = true
+		spec, err := b.deprovisionAsync(context, instanceID, details.PlanID)
+		if err != nil {
+			return spec, err
+		}
+		return spec, b.forgetRequest(instanceRequestKey(instanceID))
+	}
+
+	if err := b.bucket.Delete(context, b.bucketName(instanceID), servicePlan.PlanDeletable); err != nil {
+		var notEmptyErr *awss3.BucketNotEmptyError
+		if errors.As(err, &notEmptyErr) {
+			objects := fmt.Sprint(notEmptyErr.ObjectCount)
+			if notEmptyErr.Truncated {
+				objects = "more than " + objects
+			}
+			return domain.DeprovisionServiceSpec{}, apiresponses.NewFailureResponse(
+				fmt.Errorf("The bucket contains %s objects. Delete all objects from the bucket before deleting the service instance.", objects),
+				http.StatusUnprocessableEntity,
+				"bucket-not-empty",
+			)
+		}
+		return domain.DeprovisionServiceSpec{}, mapBucketError(err)
+	}
+
+	return domain.DeprovisionServiceSpec{IsAsync: false}, b.forgetRequest(instanceRequestKey(instanceID))
 }
 
 func (b *S3Broker) GetBucketURI(credentials Credentials) string {
@@ -213,16 +682,17 @@ func (b *S3Broker) GetBucketURI(credentials Credentials) string {
 		"s3://%s:%s@%s/%s",
 		url.QueryEscape(credentials.AccessKeyID),
 		url.QueryEscape(credentials.SecretAccessKey),
-		credentials.FIPSEndpoint,
+		cmp.Or(credentials.Endpoint, credentials.FIPSEndpoint),
 		credentials.Bucket,
 	)
 }
 
 // getBucketNames gets the underlying s3 bucket name for each service instance
-// in instanceNames, provided they are in the same space as instanceGUID, or
-// shared to that space. An error is returned if an instance is not found, or
-// if an instance is not shared to the space.
-func (b *S3Broker) getBucketNames(ctx context.Context, instanceNames []string, instanceGUID string) ([]string, error) {
+// in instanceNames, provided they are in spaceGUID, the space the binding is
+// requested from, or shared to that space. If spaceGUID is empty, the space
+// that owns instanceGUID is used. An error is returned if an instance is not
+// found, or if an instance is not shared to the space.
+func (b *S3Broker) getBucketNames(ctx context.Context, instanceNames []string, instanceGUID, spaceGUID string) ([]string, error) {
 	// Plans have IDs in the catalog distinct from their IDs in the Cloud Foundry cluster.
 	// Translate the catalog plan IDs to service plan IDs.
 	var planCatalogIDs []string
@@ -241,16 +711,24 @@ func (b *S3Broker) getBucketNames(ctx context.Context, instanceNames []string, i
 	}
 
 	var planIDs []string
+	existingBucketPlans := make(map[string]bool)
 	for _, plan := range plans {
 		planIDs = append(planIDs, plan.GUID)
+		if catalogPlan, ok := b.catalog.FindServicePlan(plan.BrokerCatalog.ID); ok && catalogPlan.S3Properties.ExistingBucket {
+			existingBucketPlans[plan.GUID] = true
+		}
 	}
 
-	// Get the space the contains the instance.
-	instance, err := b.cf.ServiceInstances.Get(ctx, instanceGUID)
-	if err != nil {
-		return nil, err
+	// Without a space from the binding, use the space that contains the
+	// instance.
+	space := spaceGUID
+	if space == "" {
+		instance, err := b.cf.ServiceInstances.Get(ctx, instanceGUID)
+		if err != nil {
+			return nil, err
+		}
+		space = instance.Relationships.Space.Data.GUID
 	}
-	space := instance.Relationships.Space.Data.GUID
 
 	// Get all service instances with s3 plans in the space.
 	sopts := cf.NewServiceInstanceListOptions()
@@ -265,10 +743,15 @@ func (b *S3Broker) getBucketNames(ctx context.Context, instanceNames []string, i
 		return nil, err
 	}
 
-	// Map from instance names to instance GUIDs.
+	// Map from instance names to instance GUIDs, noting which instances
+	// adopted an existing bucket.
 	instanceGUIDs := make(map[string]string, len(instanceNames))
+	adoptedBuckets := make(map[string]bool)
 	for _, instance := range instances {
 		instanceGUIDs[instance.Name] = instance.GUID
+		if plan := instance.Relationships.ServicePlan; plan != nil && plan.Data != nil && existingBucketPlans[plan.Data.GUID] {
+			adoptedBuckets[instance.GUID] = true
+		}
 	}
 
 	// Map from instance names to bucket names.
@@ -278,7 +761,15 @@ func (b *S3Broker) getBucketNames(ctx context.Context, instanceNames []string, i
 		if !ok {
 			return nil, fmt.Errorf("Service instance %s not found", instanceName)
 		}
-		bucketNames = append(bucketNames, b.bucketName(instanceGUID))
+		if !adoptedBuckets[instanceGUID] {
+			bucketNames = append(bucketNames, b.bucketName(instanceGUID))
+			continue
+		}
+		bucketName, err := b.bucket.FindAdopted(ctx, instanceGUID)
+		if err != nil {
+			return nil, mapBucketError(err)
+		}
+		bucketNames = append(bucketNames, bucketName)
 	}
 
 	return bucketNames, nil
@@ -290,18 +781,70 @@ func (b *S3Broker) Bind(
 	bindingID string,
 	details domain.BindDetails,
 	asyncAllowed bool,
-) (domain.Binding, error) {
+) (_ domain.Binding, err error) {
+	b = b.forRequest(context)
+	var credentials Credentials
+	defer func() {
+		b.recordOperation(context, instanceID, bindingID, details.PlanID, "bind", auditDetails{parameters: details.RawParameters, credentials: credentials}, err)
+	}()
+
+	// A replay of the bind that created the binding gets the same
+	// credentials; a different bind with the same ID is a conflict.
+	fingerprint := bindFingerprint(instanceID, details)
+	replayed, err := b.checkBindReplay(bindingID, fingerprint)
+	if err != nil {
+		return domain.Binding{}, err
+	}
+	if replayed != nil {
+		return *replayed, nil
+	}
+
+	b = b.forPlanID(details.PlanID)
+	binding, err := b.bind(context, instanceID, bindingID, details)
+	if err != nil {
+		return binding, err
+	}
+
+	// The binding's principal exists from here on, so failures leave it
+	// behind for the platform's orphan mitigation to unbind.
+	credentials, _ = binding.Credentials.(Credentials)
+	binding, err = b.formatCredentials(details, binding)
+	if err != nil {
+		return binding, orphanMitigation(err)
+	}
+	if b.credhub != nil {
+		binding, err = b.storeCredentials(context, instanceID, bindingID, details, binding)
+		if err != nil {
+			return binding, orphanMitigation(err)
+		}
+	}
+	if b.bindings != nil {
+		if err := b.saveBinding(instanceID, bindingID, details, credentials, binding); err != nil {
+			return binding, orphanMitigation(err)
+		}
+	}
+	b.saveRequest(bindingRequestKey(bindingID), fingerprint)
+	return binding, nil
+}
+
+func (b *S3Broker) bind(
+	context context.Context,
+	instanceID string,
+	bindingID string,
+	details domain.BindDetails,
+) (binding domain.Binding, err error) {
 	b.logger.Debug("bind", lager.Data{
 		instanceIDLogKey: instanceID,
 		bindingIDLogKey:  bindingID,
 		detailsLogKey:    details,
 	})
-	binding := domain.Binding{}
+	b.auditLog(context, "bind", lager.Data{instanceIDLogKey: instanceID, bindingIDLogKey: bindingID})
 
 	var accessKeyID, secretAccessKey string
-	var policyARN string
-	var err error
 
+	if err := validateParameters(b.bindSchema(), details.RawParameters); err != nil {
+		return binding, err
+	}
 	bindParameters := BindParameters{}
 	if len(details.RawParameters) > 0 {
 		if err := json.Unmarshal(details.RawParameters, &bindParameters); err != nil {
@@ -313,37 +856,129 @@ func (b *S3Broker) Bind(
 	if !ok {
 		return binding, fmt.Errorf("Service Plan '%s' not found", details.PlanID)
 	}
+	if store := b.objectStore(servicePlan); store != nil {
+		return b.bindObjectStore(context, store, instanceID, bindingID, bindParameters)
+	}
+	if err := b.checkBindingsSupported(); err != nil {
+		return binding, err
+	}
 
 	service, ok := b.catalog.FindService(details.ServiceID)
 	if !ok {
 		return binding, fmt.Errorf("Service '%s' not found", details.ServiceID)
 	}
 
-	tags, err := b.tagManager.GenerateTags(
-		brokertags.Create,
-		service.Name,
-		servicePlan.Name,
-		brokertags.ResourceGUIDs{
-			InstanceGUID: instanceID,
-		},
-		true,
-	)
-	iamTags := awsiam.ConvertTagsMapToIAMTags(tags)
+	bindContext, err := parseBindContext(details.RawContext)
+	if err != nil {
+		return binding, err
+	}
+	instanceBucketName, err := b.instanceBucketName(context, instanceID, servicePlan)
+	if err != nil {
+		return binding, mapBucketError(err)
+	}
+	shared, err := b.checkSharedBinding(context, instanceBucketName, bindContext, &bindParameters)
+	if err != nil {
+		return binding, err
+	}
+
+	iamPolicy, err := servicePlan.S3Properties.IamPolicyFor(bindParameters.Permissions)
+	if err != nil {
+		return binding, err
+	}
+
+	if _, err := b.credentialFormat(servicePlan, bindParameters); err != nil {
+		return binding, err
+	}
+
+	pathPrefix, err := normalizePathPrefix(bindParameters.PathPrefix)
+	if err != nil {
+		return binding, err
+	}
+	if bindParameters.CreateFolder && pathPrefix == "" {
+		pathPrefix = folderPrefix(bindingID, details)
+	}
 
-	bucketNames := []string{b.bucketName(instanceID)}
+	iamPath, err := b.bindingPath(instanceID, details.RawContext)
+	if err != nil {
+		return binding, err
+	}
+
+	var secretReaderARNs []string
+	if bindParameters.SecretName != "" {
+		secretReaderARNs, err = b.validateSecretDelivery(bindParameters)
+		if err != nil {
+			return binding, err
+		}
+	}
+
+	var trustPolicy, principalARN string
+	switch bindParameters.CredentialType {
+	case "", CredentialTypeAccessKey:
+	case CredentialTypeTemporary:
+		if b.credentialIssuer == nil {
+			return binding, ErrTemporaryCredentialsDisabled
+		}
+	case CredentialTypeRole:
+		if b.role == nil {
+			return binding, ErrRoleBindingsDisabled
+		}
+		trustPolicy, err = b.accountTrustPolicy(bindParameters.Principal, bindParameters.ExternalID)
+		if err != nil {
+			return binding, err
+		}
+	case CredentialTypeWebIdentity:
+		if b.role == nil {
+			return binding, ErrRoleBindingsDisabled
+		}
+		trustPolicy, err = b.webIdentityTrustPolicy(bindParameters.OIDCProviderARN, bindParameters.Subject)
+		if err != nil {
+			return binding, err
+		}
+	case CredentialTypeBucketPolicy:
+		if !b.allowBucketPolicyBindings {
+			return binding, ErrBucketPolicyBindingsDisabled
+		}
+		if len(bindParameters.AdditionalInstances) > 0 {
+			return binding, ErrBucketPolicyAdditionalInstances
+		}
+		principalARN, err = b.principalARN(bindParameters.Principal)
+		if err != nil {
+			return binding, err
+		}
+	case CredentialTypePresignedURL:
+		if b.presignURL == "" {
+			return binding, ErrPresignedURLsDisabled
+		}
+		if len(bindParameters.AdditionalInstances) > 0 {
+			return binding, ErrPresignedURLAdditionalInstances
+		}
+	default:
+		return binding, apiresponses.NewFailureResponse(
+			fmt.Errorf("credential_type must be %q, %q, %q, %q, %q or %q, got %q", CredentialTypeAccessKey, CredentialTypeTemporary, CredentialTypeRole, CredentialTypeWebIdentity, CredentialTypeBucketPolicy, CredentialTypePresignedURL, bindParameters.CredentialType),
+			http.StatusBadRequest,
+			"invalid-credential-type",
+		)
+	}
+
+	iamTags, err := b.bindingTags(context, service, servicePlan, instanceID, bindingID, details.RawContext)
+	if err != nil {
+		return binding, err
+	}
+
+	bucketNames := []string{instanceBucketName}
 	if len(bindParameters.AdditionalInstances) > 0 {
 		if b.cf == nil {
 			return binding, ErrNoClientConfigured
 		}
 
-		additionalNames, err := b.getBucketNames(context, bindParameters.AdditionalInstances, instanceID)
+		additionalNames, err := b.getBucketNames(context, bindParameters.AdditionalInstances, instanceID, bindContext.SpaceGUID)
 		if err != nil {
 			return binding, err
 		}
 		bucketNames = append(bucketNames, additionalNames...)
 	}
 
-	credentials := Credentials{AdditionalBuckets: []string{}}
+	credentials := Credentials{AdditionalBuckets: []string{}, PathPrefix: pathPrefix}
 	bucketARNs := make([]string, len(bucketNames))
 	detailc, errc := make(chan awss3.BucketDetails), make(chan error)
 	for _, bucketName := range bucketNames {
@@ -354,13 +989,9 @@ func (b *S3Broker) Bind(
 				detailsLogKey:    details,
 				"bucketname":     bucketName,
 			})
-			bucketDetails, err := b.bucket.Describe(bucketName, b.awsPartition)
+			bucketDetails, err := b.bucket.Describe(context, bucketName, b.awsPartition)
 			if err != nil {
-				if err == awss3.ErrBucketDoesNotExist {
-					errc <- apiresponses.ErrInstanceDoesNotExist
-				} else {
-					errc <- err
-				}
+				errc <- mapBucketError(err)
 			} else {
 				detailc <- bucketDetails
 			}
@@ -370,11 +1001,19 @@ func (b *S3Broker) Bind(
 		select {
 		case bucketDetails := <-detailc:
 			bucketARNs[idx] = bucketDetails.ARN
-			if bucketDetails.BucketName == b.bucketName(instanceID) {
+			if bucketDetails.BucketName == instanceBucketName {
 				credentials.Bucket = bucketDetails.BucketName
 				credentials.Region = bucketDetails.Region
 				credentials.FIPSEndpoint = bucketDetails.FIPSEndpoint
-				credentials.Endpoint = bucketDetails.FIPSEndpoint
+				credentials.RegionalEndpoint = bucketDetails.RegionalEndpoint
+				credentials.DualStackEndpoint = bucketDetails.DualStackEndpoint
+				credentials.BucketURL = bucketDetails.VirtualHostedURL
+				credentials.BucketURI = bucketDetails.URI
+				credentials.Endpoint = cmp.Or(bucketDetails.FIPSEndpoint, bucketDetails.RegionalEndpoint)
+				if b.useDualStackEndpoints || servicePlan.S3Properties.DualStack {
+					credentials.Endpoint = cmp.Or(bucketDetails.FIPSDualStackEndpoint, bucketDetails.DualStackEndpoint, credentials.Endpoint)
+					credentials.BucketURL = cmp.Or(bucketDetails.DualStackURL, credentials.BucketURL)
+				}
 				credentials.InsecureSkipVerify = b.insecureSkipVerify
 			} else {
 				credentials.AdditionalBuckets = append(credentials.AdditionalBuckets, bucketDetails.BucketName)
@@ -384,7 +1023,51 @@ func (b *S3Broker) Bind(
 		}
 	}
 
-	if _, err = b.user.Create(b.userName(bindingID), b.iamPath, iamTags); err != nil {
+	if err := b.tagSharedSpace(context, instanceBucketName, shared); err != nil {
+		return binding, err
+	}
+
+	if bindParameters.CreateFolder {
+		if err := b.bucket.CreateFolder(context, instanceBucketName, pathPrefix); err != nil {
+			return binding, mapBucketError(err)
+		}
+	}
+
+	if bindParameters.CredentialType == CredentialTypeBucketPolicy {
+		return b.bindBucketPolicy(context, instanceID, bindingID, instanceBucketName, bucketARNs[0], principalARN, bindParameters.Permissions, pathPrefix, credentials)
+	}
+
+	if bindParameters.CredentialType == CredentialTypePresignedURL {
+		return b.bindPresignedURL(instanceID, bindingID, instanceBucketName, bindParameters.Permissions, pathPrefix, credentials)
+	}
+
+	if bindParameters.CredentialType == CredentialTypeTemporary {
+		sessionTags, err := b.sessionTags(instanceID, bindingID, details)
+		if err != nil {
+			return binding, err
+		}
+		return b.bindTemporary(context, instanceID, bindingID, iamPolicy, bucketARNs, pathPrefix, sessionTags, credentials)
+	}
+
+	if trustPolicy != "" {
+		credentials.ExternalID = bindParameters.ExternalID
+		return b.bindRole(context, servicePlan, instanceID, bindingID, iamPath, trustPolicy, iamPolicy, bucketARNs, pathPrefix, iamTags, credentials)
+	}
+
+	useGroup := b.useInstanceGroups && usesInstanceGroup(bindParameters, pathPrefix)
+	if useGroup {
+		if err = b.ensureInstanceGroup(instanceID, iamPath, iamPolicy, bucketARNs); err != nil {
+			b.logger.Error("bind: error ensuring instance group", err, lager.Data{
+				instanceIDLogKey: instanceID,
+				bindingIDLogKey:  bindingID,
+				detailsLogKey:    details,
+			})
+			return binding, err
+		}
+	}
+
+	userARN, err := b.user.Create(b.userName(bindingID), iamPath, iamTags)
+	if err != nil {
 		b.logger.Error("bind: error creating user", err, lager.Data{
 			instanceIDLogKey: instanceID,
 			bindingIDLogKey:  bindingID,
@@ -411,6 +1094,7 @@ func (b *S3Broker) Bind(
 					detailsLogKey:    details,
 					"user":           b.userName(bindingID),
 				})
+				err = orphanMitigation(err)
 			}
 		}
 	}()
@@ -442,48 +1126,70 @@ func (b *S3Broker) Bind(
 					detailsLogKey:    details,
 					"user":           b.userName(bindingID),
 				})
+				err = orphanMitigation(err)
 			}
 		}
 	}()
 
-	policyARN, err = b.user.CreatePolicy(
-		b.policyName(bindingID),
-		b.iamPath,
-		string(servicePlan.S3Properties.IamPolicy),
-		bucketARNs,
-		iamTags,
+	userName := b.userName(bindingID)
+	managedPolicyARNs := servicePlan.S3Properties.ManagedPolicyARNs
+	err = b.attachManagedPolicies(
+		b.logger.Session("bind", lager.Data{bindingIDLogKey: bindingID}),
+		managedPolicyARNs,
+		func(policyARN string) error { return b.user.AttachUserPolicy(userName, policyARN) },
+		func(policyARN string) error { return b.user.DetachUserPolicy(userName, policyARN) },
 	)
 	if err != nil {
-		b.logger.Error("bind: error creating policy", err, lager.Data{
-			instanceIDLogKey: instanceID,
-			bindingIDLogKey:  bindingID,
-			detailsLogKey:    details,
-			"user":           b.userName(bindingID),
-		})
 		return binding, err
 	}
 	defer func() {
 		// If the function returns an error, Bind did not complete and resources must be cleaned up.
 		if err != nil {
-			b.logger.Info("bind: defer: err was not nil on return; deleting policy", lager.Data{
-				instanceIDLogKey: instanceID,
-				bindingIDLogKey:  bindingID,
-				detailsLogKey:    details,
-			})
+			b.detachManagedPolicies(
+				b.logger.Session("bind", lager.Data{bindingIDLogKey: bindingID}),
+				managedPolicyARNs,
+				func(policyARN string) error { return b.user.DetachUserPolicy(userName, policyARN) },
+			)
+		}
+	}()
 
-			// Careful: Do not shadow err, or future defers will not work.
-			if derr := b.user.DeletePolicy(policyARN); derr != nil {
-				b.logger.Error("bind: defer: error deleting policy", derr, lager.Data{
+	if err = b.grantKeyAccess(context, servicePlan, bindingID, userARN); err != nil {
+		return binding, err
+	}
+	defer func() {
+		// If the function returns an error, Bind did not complete and resources must be cleaned up.
+		if err != nil {
+			if derr := b.retireKeyAccess(context, servicePlan, bindingID); derr != nil {
+				b.logger.Error("bind: defer: error retiring kms grant", derr, lager.Data{
 					instanceIDLogKey: instanceID,
 					bindingIDLogKey:  bindingID,
 					detailsLogKey:    details,
-					"user":           b.userName(bindingID),
 				})
+				err = orphanMitigation(err)
 			}
 		}
 	}()
 
-	if err = b.user.AttachUserPolicy(b.userName(bindingID), policyARN); err != nil {
+	if useGroup {
+		err = b.group.AddUser(b.groupName(instanceID), b.userName(bindingID))
+	} else {
+		// The policy is inline so that it lives and dies with the binding's user
+		// and grants access to this binding's buckets only.
+		err = b.user.PutUserPolicy(
+			b.userName(bindingID),
+			b.policyName(bindingID),
+			iamPolicy,
+			bucketARNs,
+			pathPrefix,
+		)
+	}
+	if err != nil {
+		b.logger.Error("bind: error granting user access", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			detailsLogKey:    details,
+			"user":           b.userName(bindingID),
+		})
 		return binding, err
 	}
 
@@ -491,6 +1197,13 @@ func (b *S3Broker) Bind(
 	credentials.SecretAccessKey = secretAccessKey
 	credentials.URI = b.GetBucketURI(credentials)
 
+	if bindParameters.SecretName != "" {
+		credentials, err = b.deliverSecret(context, instanceID, bindingID, bindParameters.SecretName, append([]string{userARN}, secretReaderARNs...), iamTags, credentials)
+		if err != nil {
+			return binding, err
+		}
+	}
+
 	binding.Credentials = credentials
 
 	return binding, nil
@@ -502,12 +1215,90 @@ func (b *S3Broker) Unbind(
 	bindingID string,
 	details domain.UnbindDetails,
 	asyncAllowed bool,
-) (domain.UnbindSpec, error) {
+) (_ domain.UnbindSpec, err error) {
+	b = b.forRequest(context)
+	context = awsretry.WithLowPriority(context)
 	b.logger.Debug("unbind", lager.Data{
 		instanceIDLogKey: instanceID,
 		bindingIDLogKey:  bindingID,
 		detailsLogKey:    details,
 	})
+	b.auditLog(context, "unbind", lager.Data{instanceIDLogKey: instanceID, bindingIDLogKey: bindingID})
+	defer func() {
+		b.recordOperation(context, instanceID, bindingID, details.PlanID, "unbind", auditDetails{}, err)
+	}()
+
+	b = b.forPlanID(details.PlanID)
+
+	bindingKey := bindingRequestKey(bindingID)
+	known, err := b.knownRequest(bindingKey)
+	if err != nil {
+		return domain.UnbindSpec{}, err
+	}
+
+	if b.bindings != nil {
+		if err := b.bindings.DeleteBinding(bindingID); err != nil {
+			return domain.UnbindSpec{}, err
+		}
+	}
+
+	if b.temporaryBindings != nil {
+		// Revokes the refresh token. Credentials already issued stay valid
+		// until they expire.
+		if err := b.temporaryBindings.DeleteTemporaryBinding(bindingID); err != nil {
+			return domain.UnbindSpec{}, err
+		}
+	}
+
+	if b.secrets != nil {
+		if err := b.secrets.DeleteTagged(context, BindingGUIDTagKey, bindingID); err != nil {
+			return domain.UnbindSpec{}, err
+		}
+	}
+
+	if b.credhub != nil {
+		if err := b.credhub.Delete(context, b.credhubName(details.ServiceID, bindingID)); err != nil {
+			return domain.UnbindSpec{}, err
+		}
+	}
+
+	if b.presignBindings != nil {
+		// Revokes the presign token. URLs already issued stay valid until
+		// they expire.
+		if err := b.presignBindings.DeletePresignBinding(bindingID); err != nil {
+			return domain.UnbindSpec{}, err
+		}
+	}
+
+	if len(b.objectStores) > 0 {
+		if servicePlan, ok := b.catalog.FindServicePlan(details.PlanID); ok {
+			if store := b.objectStore(servicePlan); store != nil {
+				return domain.UnbindSpec{}, b.unbindObjectStore(context, store, instanceID, bindingID, known)
+			}
+		}
+	}
+
+	if b.grants != nil {
+		if servicePlan, ok := b.catalog.FindServicePlan(details.PlanID); ok {
+			if err := b.retireKeyAccess(context, servicePlan, bindingID); err != nil {
+				return domain.UnbindSpec{}, err
+			}
+		}
+	}
+
+	if b.allowBucketPolicyBindings {
+		if servicePlan, ok := b.catalog.FindServicePlan(details.PlanID); ok {
+			if err := b.unbindBucketPolicy(context, instanceID, bindingID, servicePlan); err != nil {
+				return domain.UnbindSpec{}, err
+			}
+		}
+	}
+
+	if b.role != nil {
+		if err := b.deleteRole(bindingID); err != nil {
+			return domain.UnbindSpec{}, err
+		}
+	}
 
 	userName := b.userName(bindingID)
 
@@ -516,21 +1307,51 @@ func (b *S3Broker) Unbind(
 		return domain.UnbindSpec{}, err
 	}
 	if !exists {
-		return domain.UnbindSpec{}, nil
+		// Unbinding a binding that was already unbound, or never bound, is
+		// answered with 410 Gone.
+		if !known {
+			return domain.UnbindSpec{}, apiresponses.ErrBindingDoesNotExist
+		}
+		return domain.UnbindSpec{}, b.forgetRequest(bindingKey)
 	}
 
-	accessKeys, err := b.user.ListAccessKeys(userName)
+	accessKeys, err := b.user.DescribeAccessKeys(userName)
 	if b.handleUnbindError(err) != nil {
 		return domain.UnbindSpec{}, err
 	}
 
+	// Quarantine leaves every access key of the binding inactive.
+	if slices.ContainsFunc(accessKeys, func(accessKey awsiam.AccessKeyDetails) bool {
+		return accessKey.Status == iam.StatusTypeInactive
+	}) {
+		if servicePlan, ok := b.catalog.FindServicePlan(details.PlanID); ok {
+			if err := b.LiftQuarantine(context, instanceID, bindingID, servicePlan); err != nil {
+				return domain.UnbindSpec{}, err
+			}
+		}
+	}
+
 	for _, accessKey := range accessKeys {
-		if err := b.user.DeleteAccessKey(userName, accessKey); err != nil {
+		if err := b.user.DeleteAccessKey(userName, accessKey.AccessKeyID); err != nil {
+			return domain.UnbindSpec{}, err
+		}
+	}
+
+	inlinePolicies, err := b.user.ListUserPolicies(userName)
+	if b.handleUnbindError(err) != nil {
+		return domain.UnbindSpec{}, err
+	}
+
+	for _, policyName := range inlinePolicies {
+		if err := b.user.DeleteUserPolicy(userName, policyName); err != nil {
 			return domain.UnbindSpec{}, err
 		}
 	}
 
-	userPolicies, err := b.user.ListAttachedUserPolicies(userName, b.iamPath)
+	// Managed policies from the plan are detached. Bindings created before
+	// policies were inlined also have a managed policy of their own, which
+	// is deleted.
+	userPolicies, err := b.user.ListAttachedUserPolicies(userName, "")
 	if b.handleUnbindError(err) != nil {
 		return domain.UnbindSpec{}, err
 	}
@@ -540,16 +1361,25 @@ func (b *S3Broker) Unbind(
 			return domain.UnbindSpec{}, err
 		}
 
+		if !b.isBindingPolicy(userPolicy, bindingID) {
+			continue
+		}
 		if err := b.user.DeletePolicy(userPolicy); err != nil {
 			return domain.UnbindSpec{}, err
 		}
 	}
 
+	if b.group != nil {
+		if err := b.leaveGroups(userName); err != nil {
+			return domain.UnbindSpec{}, err
+		}
+	}
+
 	if err := b.user.Delete(userName); b.handleUnbindError(err) != nil {
 		return domain.UnbindSpec{}, err
 	}
 
-	return domain.UnbindSpec{}, nil
+	return domain.UnbindSpec{}, b.forgetRequest(bindingKey)
 }
 
 func (b *S3Broker) LastOperation(
@@ -557,44 +1387,34 @@ func (b *S3Broker) LastOperation(
 	instanceID string,
 	details domain.PollDetails,
 ) (domain.LastOperation, error) {
+	b = b.forRequest(ctx)
 	b.logger.Debug("last-operation", lager.Data{
 		instanceIDLogKey: instanceID,
 	})
+	if details.OperationData == operationDeprovision && b.deprovisions != nil {
+		// A deprovision that is resumed deletes the bucket in the account
+		// of the plan that the platform polls with.
+		return b.forPlanID(details.PlanID).deprovisionLastOperation(ctx, instanceID, details.PlanID)
+	}
+	if b.operations != nil {
+		return b.lastOperation(instanceID, "")
+	}
 	return domain.LastOperation{}, errors.New("this broker does not support LastOperation")
 }
 
-func (b *S3Broker) GetBinding(
-	ctx context.Context,
-	instanceID,
-	bindingID string,
-	details domain.FetchBindingDetails,
-) (domain.GetBindingSpec, error) {
-	b.logger.Debug("get-binding", lager.Data{
-		instanceIDLogKey: instanceID,
-	})
-	return domain.GetBindingSpec{}, errors.New("this broker does not support GetBinding")
-}
-
-func (b *S3Broker) GetInstance(
-	ctx context.Context,
-	instanceID string,
-	details domain.FetchInstanceDetails,
-) (domain.GetInstanceDetailsSpec, error) {
-	b.logger.Debug("get-instance", lager.Data{
-		instanceIDLogKey: instanceID,
-	})
-	return domain.GetInstanceDetailsSpec{}, errors.New("this broker does not support GetInstance")
-}
-
 func (b *S3Broker) LastBindingOperation(
 	ctx context.Context,
 	instanceID,
 	bindingID string,
 	details domain.PollDetails,
 ) (domain.LastOperation, error) {
+	b = b.forRequest(ctx)
 	b.logger.Debug("last-binding-operation", lager.Data{
 		instanceIDLogKey: instanceID,
 	})
+	if b.operations != nil {
+		return b.lastOperation(instanceID, bindingID)
+	}
 	return domain.LastOperation{}, errors.New("this broker does not support LastBindingOperation")
 }
 
@@ -602,15 +1422,8 @@ func (b *S3Broker) bucketName(instanceID string) string {
 	return fmt.Sprintf("%s-%s", b.bucketPrefix, instanceID)
 }
 
-func (b *S3Broker) userName(bindingID string) string {
-	return fmt.Sprintf("%s-%s", b.userPrefix, bindingID)
-}
-
-func (b *S3Broker) policyName(bindingID string) string {
-	return fmt.Sprintf("%s-%s", b.policyPrefix, bindingID)
-}
-
 func (b *S3Broker) createBucket(
+	ctx context.Context,
 	instanceID string,
 	servicePlan ServicePlan,
 	provisionParameters ProvisionParameters,
@@ -623,13 +1436,18 @@ func (b *S3Broker) createBucket(
 		return nil, fmt.Errorf("Service '%s' not found", details.ServiceID)
 	}
 
+	platformContext, err := parseBindContext(details.RawContext)
+	if err != nil {
+		return nil, err
+	}
+	organizationGUID, spaceGUID := cfPlace(details.OrganizationGUID, details.SpaceGUID, details.RawContext)
 	tags, err := b.tagManager.GenerateTags(
 		brokertags.Create,
 		service.Name,
 		servicePlan.Name,
 		brokertags.ResourceGUIDs{
-			OrganizationGUID: details.OrganizationGUID,
-			SpaceGUID:        details.SpaceGUID,
+			OrganizationGUID: organizationGUID,
+			SpaceGUID:        spaceGUID,
 			InstanceGUID:     instanceID,
 		},
 		false,
@@ -637,22 +1455,84 @@ func (b *S3Broker) createBucket(
 	if err != nil {
 		return nil, err
 	}
-	bucketDetails.Tags = tags
+	tags = addPlatformTags(tags, platformContext)
+	tags = b.addIdentityTag(ctx, tags, CreatedByTagKey)
+	tags = addPreserveOnDeleteTag(tags, provisionParameters.PreserveOnDelete)
+	tags = addAlertThresholdTag(tags, provisionParameters.AlertThresholdGB)
+	tags = addMaintenanceVersionTag(tags, servicePlan)
+	userTags, err := addAnnotations(provisionParameters.Tags, provisionParameters.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	bucketDetails.Tags, err = addUserTags(tags, userTags)
+	if err != nil {
+		return nil, err
+	}
 
-	bucketDetails.Policy = string(servicePlan.S3Properties.BucketPolicy)
-	bucketDetails.Encryption = string(servicePlan.S3Properties.Encryption)
-	bucketDetails.AwsPartition = b.awsPartition
+	bucketDetails.Region = cmp.Or(provisionParameters.Region, b.region)
 	bucketDetails.ObjectOwnership = provisionParameters.ObjectOwnership
+	bucketDetails.CORSRules = provisionParameters.CORSRules
+	bucketDetails.LifecycleRules = provisionParameters.LifecycleRules
 	return bucketDetails, nil
 }
 
-func (b *S3Broker) modifyBucket(instanceID string, servicePlan ServicePlan, updateParameters UpdateParameters, details brokerapi.UpdateDetails) *awss3.BucketDetails {
+// modifyBucket returns the configuration that an update applies to the
+// instance's bucket: the new plan's settings, tags for the update, and the
+// CORS and lifecycle rules from the update parameters.
+func (b *S3Broker) modifyBucket(ctx context.Context, instanceID string, servicePlan ServicePlan, updateParameters UpdateParameters, details brokerapi.UpdateDetails) (*awss3.BucketDetails, error) {
 	bucketDetails := b.bucketFromPlan(servicePlan)
-	return bucketDetails
+
+	service, ok := b.catalog.FindService(details.ServiceID)
+	if !ok {
+		return nil, fmt.Errorf("Service '%s' not found", details.ServiceID)
+	}
+
+	platformContext, err := parseBindContext(details.RawContext)
+	if err != nil {
+		return nil, err
+	}
+	organizationGUID, spaceGUID := cfPlace(details.PreviousValues.OrgID, details.PreviousValues.SpaceID, details.RawContext)
+	tags, err := b.tagManager.GenerateTags(
+		brokertags.Update,
+		service.Name,
+		servicePlan.Name,
+		brokertags.ResourceGUIDs{
+			OrganizationGUID: organizationGUID,
+			SpaceGUID:        spaceGUID,
+			InstanceGUID:     instanceID,
+		},
+		false,
+	)
+	if err != nil {
+		return nil, err
+	}
+	tags = addPlatformTags(tags, platformContext)
+	tags = b.addIdentityTag(ctx, tags, UpdatedByTagKey)
+	tags = addPreserveOnDeleteTag(tags, updateParameters.PreserveOnDelete)
+	tags = addAlertThresholdTag(tags, updateParameters.AlertThresholdGB)
+	tags = addMaintenanceVersionTag(tags, servicePlan)
+	userTags, err := addAnnotations(updateParameters.Tags, updateParameters.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	bucketDetails.Tags, err = addUserTags(tags, userTags)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketDetails.CORSRules = updateParameters.CORSRules
+	bucketDetails.LifecycleRules = updateParameters.LifecycleRules
+	return bucketDetails, nil
 }
 
+// bucketFromPlan returns the bucket configuration that servicePlan sets.
 func (b *S3Broker) bucketFromPlan(servicePlan ServicePlan) *awss3.BucketDetails {
-	bucketDetails := &awss3.BucketDetails{}
+	bucketDetails := &awss3.BucketDetails{
+		Policy:       servicePlan.S3Properties.BucketPolicy,
+		Encryption:   servicePlan.S3Properties.Encryption,
+		Versioning:   servicePlan.S3Properties.Versioning,
+		AwsPartition: b.awsPartition,
+	}
 	return bucketDetails
 }
 
@@ -31,6 +31,13 @@ var _ = Describe("Broker", func() {
 				nil,
 				nil,
 				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
 				lager.NewLogger("s3-broker-test"),
 				&mockTagGenerator{},
 			)
@@ -50,6 +57,13 @@ var _ = Describe("Broker", func() {
 				nil,
 				nil,
 				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
 				lager.NewLogger("s3-broker-test"),
 				&mockTagGenerator{},
 			)
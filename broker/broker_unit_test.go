@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"net/http"
 	"slices"
 	"testing"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -18,12 +21,44 @@ import (
 
 	"github.com/pivotal-cf/brokerapi/v10"
 	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+	"github.com/pivotal-cf/brokerapi/v10/middlewares"
 )
 
+// newTestBroker returns a broker of mocks that provisions and binds plan1 of
+// service1, changed by options, so that each test sets only what it is about.
+func newTestBroker(options ...func(*S3Broker)) *S3Broker {
+	b := &S3Broker{
+		logger:     lager.NewLogger("broker-unit-test"),
+		bucket:     &mockBucket{},
+		catalog:    &mockCatalog{planName: "plan1", serviceName: "service1"},
+		tagManager: &mockTagGenerator{},
+		user:       &mockUser{},
+	}
+	for _, option := range options {
+		option(b)
+	}
+	return b
+}
+
+func withBucket(bucket *mockBucket) func(*S3Broker) {
+	return func(b *S3Broker) {
+		b.bucket = bucket
+	}
+}
+
+func withUser(user *mockUser) func(*S3Broker) {
+	return func(b *S3Broker) {
+		b.user = user
+	}
+}
+
 type mockTagGenerator struct {
-	serviceName string
-	generateErr error
-	tags        map[string]string
+	serviceName         string
+	generateErr         error
+	tags                map[string]string
+	resourceGUIDs       brokertags.ResourceGUIDs
+	getMissingResources bool
 }
 
 func (mt *mockTagGenerator) GenerateTags(
@@ -33,6 +68,8 @@ func (mt *mockTagGenerator) GenerateTags(
 	resourceGUIDs brokertags.ResourceGUIDs,
 	getMissingResources bool,
 ) (map[string]string, error) {
+	mt.resourceGUIDs = resourceGUIDs
+	mt.getMissingResources = getMissingResources
 	if mt.generateErr != nil {
 		return nil, mt.generateErr
 	}
@@ -51,33 +88,213 @@ type mockBucket struct {
 
 	describeDetails awss3.BucketDetails
 	describeErr     error
+	createErr       error
+	deleteErr       error
+	deleted         bool
+	deleteProgress  []awss3.DeleteProgress
+	deleteWait      chan struct{}
+	modified        *awss3.BucketDetails
+	modifyErr       error
+
+	// adopted maps adopted bucket names to their instance GUIDs.
+	adopted  map[string]string
+	released []string
+	adoptErr error
+
+	// policyStatements maps bucket names to their policy statements by Sid.
+	policyStatements map[string]map[string]awss3.PolicyStatement
+
+	usage awss3.BucketUsage
+	tags  map[string]string
+
+	// buckets maps bucket names to their tags. When it is set, Tags and
+	// AddTags use it instead of tags, FindBuckets finds its buckets and
+	// Delete removes them.
+	buckets map[string]map[string]string
+
+	// nonEmpty lists the buckets that Delete leaves alone unless told to
+	// delete their objects.
+	nonEmpty []string
+
+	// countBuckets counts the buckets with tags for CountBuckets.
+	countBuckets func(tags map[string][]string) int
+
+	// retained maps retained bucket names to their new tags.
+	retained map[string]map[string]string
+
+	// folders lists the folders created, as bucket/prefix.
+	folders []string
 }
 
-func (b mockBucket) Describe(bucketname, partition string) (awss3.BucketDetails, error) {
+func (b mockBucket) Describe(ctx context.Context, bucketname, partition string) (awss3.BucketDetails, error) {
 	if b.describeErr != nil {
 		return awss3.BucketDetails{}, b.describeErr
 	}
 	return b.describeDetails, nil
 }
 
-func (b mockBucket) Create(bucketName string, details awss3.BucketDetails) (string, error) {
-	return "", errors.New("not implemented")
-	// b.name = bucketName
-	// b.arn = "aws:" + bucketName
-	// return
+func (b mockBucket) DescribeConfiguration(ctx context.Context, bucketname, partition string) (awss3.BucketDetails, error) {
+	return b.Describe(ctx, bucketname, partition)
+}
+
+func (b *mockBucket) Create(ctx context.Context, bucketName string, details awss3.BucketDetails) (string, error) {
+	if b.createErr != nil {
+		return "", b.createErr
+	}
+	b.name = bucketName
+	b.arn = "arn:aws:s3:::" + bucketName
+	return b.arn, nil
+}
+
+func (b *mockBucket) Modify(ctx context.Context, bucketName string, details awss3.BucketDetails) error {
+	b.modified = &details
+	return b.modifyErr
+}
+
+func (b *mockBucket) Delete(ctx context.Context, bucketName string, deleteObjects bool) error {
+	if !deleteObjects && slices.Contains(b.nonEmpty, bucketName) {
+		return &awss3.BucketNotEmptyError{BucketName: bucketName, ObjectCount: 1}
+	}
+	b.deleted = true
+	if b.deleteErr == nil && b.buckets != nil {
+		delete(b.buckets, bucketName)
+	}
+	return b.deleteErr
+}
+
+func (b *mockBucket) DeleteWithProgress(ctx context.Context, bucketName string, progress func(awss3.DeleteProgress)) error {
+	for _, p := range b.deleteProgress {
+		progress(p)
+	}
+	if b.deleteWait != nil {
+		<-b.deleteWait
+	}
+	b.deleted = true
+	return b.deleteErr
+}
+
+func (b *mockBucket) Adopt(ctx context.Context, bucketName string, details awss3.BucketDetails) error {
+	if b.adoptErr != nil {
+		return b.adoptErr
+	}
+	if b.adopted == nil {
+		b.adopted = make(map[string]string)
+	}
+	b.adopted[bucketName] = details.Tags[brokertags.ServiceInstanceGUIDTagKey]
+	return nil
+}
+
+func (b *mockBucket) Release(ctx context.Context, bucketName string) error {
+	delete(b.adopted, bucketName)
+	b.released = append(b.released, bucketName)
+	return nil
+}
+
+func (b *mockBucket) FindAdopted(ctx context.Context, instanceID string) (string, error) {
+	for bucketName, instanceGUID := range b.adopted {
+		if instanceGUID == instanceID {
+			return bucketName, nil
+		}
+	}
+	return "", awss3.ErrBucketNotFound
+}
+
+func (b *mockBucket) AddPolicyStatements(ctx context.Context, bucketName string, statements []awss3.PolicyStatement) error {
+	if b.policyStatements == nil {
+		b.policyStatements = make(map[string]map[string]awss3.PolicyStatement)
+	}
+	if b.policyStatements[bucketName] == nil {
+		b.policyStatements[bucketName] = make(map[string]awss3.PolicyStatement)
+	}
+	for _, statement := range statements {
+		b.policyStatements[bucketName][statement.Sid] = statement
+	}
+	return nil
+}
+
+func (b *mockBucket) RemovePolicyStatements(ctx context.Context, bucketName string, sids []string) error {
+	for _, sid := range sids {
+		delete(b.policyStatements[bucketName], sid)
+	}
+	return nil
 }
 
-func (b mockBucket) Modify(bucketName string, details awss3.BucketDetails) error {
-	return errors.New("not implemented")
+func (b *mockBucket) Usage(ctx context.Context, bucketName string) (awss3.BucketUsage, error) {
+	return b.usage, b.describeErr
 }
 
-func (b mockBucket) Delete(bucketName string, deleteObjects bool) error {
-	return errors.New("not implemented")
+func (b *mockBucket) Tags(ctx context.Context, bucketName string) (map[string]string, error) {
+	if b.buckets != nil {
+		tags, ok := b.buckets[bucketName]
+		if !ok {
+			return nil, awss3.ErrBucketNotFound
+		}
+		return maps.Clone(tags), nil
+	}
+	return maps.Clone(b.tags), nil
+}
+
+func (b *mockBucket) AddTags(ctx context.Context, bucketName string, tags map[string]string) error {
+	if b.buckets != nil {
+		maps.Copy(b.buckets[bucketName], tags)
+		return nil
+	}
+	if b.tags == nil {
+		b.tags = make(map[string]string)
+	}
+	maps.Copy(b.tags, tags)
+	return nil
+}
+
+func (b *mockBucket) Retain(ctx context.Context, bucketName string, tags map[string]string) error {
+	if b.retained == nil {
+		b.retained = make(map[string]map[string]string)
+	}
+	b.retained[bucketName] = tags
+	return nil
+}
+
+func (b *mockBucket) CountBuckets(ctx context.Context, tags map[string][]string) (int, error) {
+	if b.countBuckets == nil {
+		return 0, nil
+	}
+	return b.countBuckets(tags), nil
+}
+
+func (b *mockBucket) FindBuckets(ctx context.Context, tags map[string][]string) (map[string]map[string]string, error) {
+	found := make(map[string]map[string]string)
+	for bucketName, bucketTags := range b.buckets {
+		matches := true
+		for key, values := range tags {
+			value, ok := bucketTags[key]
+			if !ok || (len(values) > 0 && !slices.Contains(values, value)) {
+				matches = false
+			}
+		}
+		if matches {
+			found[bucketName] = maps.Clone(bucketTags)
+		}
+	}
+	return found, nil
+}
+
+func (b *mockBucket) CreateFolder(ctx context.Context, bucketName, prefix string) error {
+	b.folders = append(b.folders, bucketName+"/"+prefix)
+	return nil
+}
+
+func (b *mockBucket) PresignObjectURL(bucketName, key, method string, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s?method=%s&expires=%d", bucketName, key, method, int(ttl.Seconds())), nil
 }
 
 type mockCatalog struct {
-	serviceName string
-	planName    string
+	serviceName   string
+	planName      string
+	planDeletable bool
+	s3Properties  S3Properties
+
+	// plans, if set, are found by ID instead of the plan above.
+	plans map[string]ServicePlan
 }
 
 func (c mockCatalog) Validate() error {
@@ -94,14 +311,27 @@ func (c mockCatalog) FindService(serviceID string) (service Service, found bool)
 }
 
 func (c mockCatalog) FindServicePlan(planID string) (plan ServicePlan, found bool) {
+	if c.plans != nil {
+		plan, found = c.plans[planID]
+		return plan, found
+	}
 	if c.planName == "" {
 		return ServicePlan{}, false
 	}
 	return ServicePlan{
-		Name: c.planName,
+		Name:          c.planName,
+		PlanDeletable: c.planDeletable,
+		S3Properties:  c.s3Properties,
 	}, true
 }
 
+func (c mockCatalog) ListServices() []Service {
+	if c.serviceName == "" {
+		return nil
+	}
+	return []Service{{Name: c.serviceName}}
+}
+
 func (c mockCatalog) ListServicePlans() []ServicePlan {
 	return nil
 }
@@ -116,20 +346,50 @@ type mockUser struct {
 	deletedPolicyArns    []string
 	detachedPolicyArns   []string
 	exists               bool
+	missing              bool     // Exists reports that no user exists.
 	policies             []string // ARNs
+	tags                 []*iam.Tag
 	users                []string
 
+	// inlinePolicies maps from usernames to inline policy names.
+	inlinePolicies map[string][]string
+
 	// Methods return these errors when set.
 	attachUserPolicyErr         error
 	createAccessKeyErr          error
 	createPolicyErr             error
 	createUserErr               error
 	deleteAccessKeyErr          error
+	deleteInlinePolicyErr       error
 	deleteUserErr               error
 	deleteUserPolicyErr         error
 	detachUserPolicyErr         error
 	listAccessKeysErr           error
 	listAttachedUserPoliciesErr error
+	listUserPoliciesErr         error
+	putUserPolicyErr            error
+	accessKeyCreateDates        map[string]time.Time
+	inactiveAccessKeys          []string
+	// accessKeyLastUsed maps access key IDs to when they were last used.
+	accessKeyLastUsed map[string]time.Time
+
+	// createDates maps usernames to the creation dates Describe returns.
+	createDates map[string]time.Time
+	// deletedUsers lists the users deleted.
+	deletedUsers []string
+}
+
+func (u *mockUser) ListUsers(iamPath string) ([]string, error) {
+	return slices.Sorted(maps.Keys(u.accessKeys)), nil
+}
+
+func (u *mockUser) DeactivateAccessKey(userName, accessKeyID string) error {
+	u.inactiveAccessKeys = append(u.inactiveAccessKeys, accessKeyID)
+	return nil
+}
+
+func (u *mockUser) AccessKeyLastUsed(accessKeyID string) (time.Time, error) {
+	return u.accessKeyLastUsed[accessKeyID], nil
 }
 
 func (u *mockUser) ListAccessKeys(userName string) ([]string, error) {
@@ -146,6 +406,23 @@ func (u *mockUser) ListAccessKeys(userName string) ([]string, error) {
 	return out, nil
 }
 
+func (u *mockUser) DescribeAccessKeys(userName string) ([]awsiam.AccessKeyDetails, error) {
+	accessKeys, err := u.ListAccessKeys(userName)
+	var out []awsiam.AccessKeyDetails
+	for _, accessKeyID := range accessKeys {
+		status := iam.StatusTypeActive
+		if slices.Contains(u.inactiveAccessKeys, accessKeyID) {
+			status = iam.StatusTypeInactive
+		}
+		out = append(out, awsiam.AccessKeyDetails{
+			AccessKeyID: accessKeyID,
+			Status:      status,
+			CreateDate:  u.accessKeyCreateDates[accessKeyID],
+		})
+	}
+	return out, err
+}
+
 func (u *mockUser) ListAttachedUserPolicies(userName, iamPath string) ([]string, error) {
 	if u.listAttachedUserPoliciesErr != nil {
 		return []string{}, u.listAttachedUserPoliciesErr
@@ -158,6 +435,7 @@ func (u *mockUser) Delete(userName string) error {
 		return u.deleteUserErr
 	}
 	u.exists = false
+	u.deletedUsers = append(u.deletedUsers, userName)
 	return nil
 }
 
@@ -173,11 +451,11 @@ func (u *mockUser) AttachUserPolicy(userName, policyARN string) error {
 }
 
 func (u *mockUser) Exists(userName string) (bool, error) {
-	return true, nil
+	return !u.missing, nil
 }
 
 func (u *mockUser) Describe(userName string) (awsiam.UserDetails, error) {
-	return awsiam.UserDetails{}, nil
+	return awsiam.UserDetails{UserName: userName, UserARN: "arn:aws:iam::000000000000:user/" + userName, CreateDate: u.createDates[userName]}, nil
 }
 
 func (u *mockUser) Create(userName, iamPath string, iamTags []*iam.Tag) (string, error) {
@@ -185,7 +463,8 @@ func (u *mockUser) Create(userName, iamPath string, iamTags []*iam.Tag) (string,
 		return "", u.createUserErr
 	}
 	u.exists = true
-	return "", nil
+	u.tags = iamTags
+	return "arn:aws:iam::000000000000:user/" + userName, nil
 }
 
 func (u *mockUser) CreateAccessKey(userName string) (string, string, error) {
@@ -195,8 +474,12 @@ func (u *mockUser) CreateAccessKey(userName string) (string, string, error) {
 	if u.accessKeys == nil {
 		u.accessKeys = make(map[string][]string)
 	}
-	keyID := fmt.Sprintf("%v-%v", userName, len(u.accessKeys[userName]))
+	if u.accessKeyCreateDates == nil {
+		u.accessKeyCreateDates = make(map[string]time.Time)
+	}
+	keyID := fmt.Sprintf("%v-%v", userName, len(u.accessKeyCreateDates))
 	u.accessKeys[userName] = append(u.accessKeys[userName], keyID)
+	u.accessKeyCreateDates[keyID] = time.Now()
 
 	return keyID, "", nil
 }
@@ -252,6 +535,36 @@ func (u *mockUser) DetachUserPolicy(userName, policyARN string) error {
 	return nil
 }
 
+func (u *mockUser) PutUserPolicy(userName, policyName, policyTemplate string, resources []string, pathPrefix string) error {
+	if u.putUserPolicyErr != nil {
+		return u.putUserPolicyErr
+	}
+	if u.inlinePolicies == nil {
+		u.inlinePolicies = make(map[string][]string)
+	}
+	u.inlinePolicies[userName] = append(u.inlinePolicies[userName], policyName)
+	return nil
+}
+
+func (u *mockUser) ListUserPolicies(userName string) ([]string, error) {
+	if u.listUserPoliciesErr != nil {
+		return []string{}, u.listUserPoliciesErr
+	}
+	return slices.Clone(u.inlinePolicies[userName]), nil
+}
+
+func (u *mockUser) DeleteUserPolicy(userName, policyName string) error {
+	if u.deleteInlinePolicyErr != nil {
+		return u.deleteInlinePolicyErr
+	}
+	idx := slices.Index(u.inlinePolicies[userName], policyName)
+	if idx == -1 {
+		return errors.New("not found")
+	}
+	u.inlinePolicies[userName] = slices.Delete(u.inlinePolicies[userName], idx, idx+1)
+	return nil
+}
+
 func TestCreateBucket(t *testing.T) {
 	testCases := map[string]struct {
 		broker              *S3Broker
@@ -260,6 +573,7 @@ func TestCreateBucket(t *testing.T) {
 		instanceID          string
 		provisionParameters ProvisionParameters
 		provisionDetails    brokerapi.ProvisionDetails
+		originatingIdentity string
 		expectErr           bool
 	}{
 		"success": {
@@ -298,6 +612,26 @@ func TestCreateBucket(t *testing.T) {
 				},
 			},
 		},
+		"tags the originating identity": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{
+					serviceName: "service-1",
+				},
+			},
+			servicePlan:         ServicePlan{ID: "plan-1", Name: "plan"},
+			originatingIdentity: "cloudfoundry eyJ1c2VyX2lkIjoiNjgzZWE3NDgtMzA5Mi00ZmY0LWI2NTYtMzljYWNjNGQ1MzYwIn0=",
+			expectedDetails: &awss3.BucketDetails{
+				AwsPartition: "gov",
+				Tags: map[string]string{
+					"service name":  "service-1",
+					CreatedByTagKey: "cloudfoundry/683ea748-3092-4ff4-b656-39cacc4d5360",
+				},
+			},
+		},
 		"service not found": {
 			broker: &S3Broker{
 				awsPartition: "gov",
@@ -351,7 +685,9 @@ func TestCreateBucket(t *testing.T) {
 
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), middlewares.OriginatingIdentityKey, test.originatingIdentity)
 			details, err := test.broker.createBucket(
+				ctx,
 				test.instanceID,
 				test.servicePlan,
 				test.provisionParameters,
@@ -373,6 +709,67 @@ func TestCreateBucket(t *testing.T) {
 	}
 }
 
+func TestDeprovision(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestDeprovision")
+
+	testCases := map[string]struct {
+		broker           *S3Broker
+		expectErr        error
+		expectStatusCode int
+	}{
+		"success": {
+			broker: &S3Broker{
+				logger:  logger,
+				bucket:  &mockBucket{},
+				catalog: &mockCatalog{planName: "plan1"},
+			},
+		},
+		"bucket does not exist": {
+			broker: &S3Broker{
+				logger:  logger,
+				bucket:  &mockBucket{deleteErr: awss3.ErrBucketDoesNotExist},
+				catalog: &mockCatalog{planName: "plan1"},
+			},
+			expectErr: brokerapi.ErrInstanceDoesNotExist,
+		},
+		"bucket not empty": {
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{deleteErr: &awss3.BucketNotEmptyError{
+					BucketName:  "bucket",
+					ObjectCount: 3,
+				}},
+				catalog: &mockCatalog{planName: "plan1"},
+			},
+			expectStatusCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := test.broker.Deprovision(
+				context.Background(),
+				"instance1",
+				domain.DeprovisionDetails{PlanID: "plan1"},
+				false,
+			)
+			if test.expectStatusCode != 0 {
+				failure, ok := err.(*apiresponses.FailureResponse)
+				if !ok {
+					t.Fatalf("expected failure response, got %v", err)
+				}
+				if failure.ValidatedStatusCode(logger) != test.expectStatusCode {
+					t.Fatalf("expected status code %d, got %d", test.expectStatusCode, failure.ValidatedStatusCode(logger))
+				}
+				return
+			}
+			if err != test.expectErr {
+				t.Fatalf("expected error: %v, got: %v", test.expectErr, err)
+			}
+		})
+	}
+}
+
 func TestUnbind(t *testing.T) {
 	logger := lager.NewLogger("broker-unit-test-TestUnbind")
 	listAccessKeysErr := errors.New("list access keys error")
@@ -381,6 +778,8 @@ func TestUnbind(t *testing.T) {
 	detachUserPolicyErr := errors.New("detach user policy error")
 	deleteUserPolicyErr := errors.New("delete user policy error")
 	deleteUserErr := errors.New("delete user error")
+	listUserPoliciesErr := errors.New("list inline policies error")
+	deleteInlinePolicyErr := errors.New("delete inline policy error")
 	noSuchEntityErr := awserr.New("NoSuchEntity", "user does not exist", errors.New("original error"))
 
 	testCases := map[string]struct {
@@ -392,6 +791,7 @@ func TestUnbind(t *testing.T) {
 		expectAccessKeys         map[string][]string
 		expectDetachedPolicyArns []string
 		expectPolicyARNs         []string
+		expectInlinePolicies     map[string][]string
 		expectUnbindSpec         domain.UnbindSpec
 	}{
 		"success": {
@@ -491,6 +891,54 @@ func TestUnbind(t *testing.T) {
 			},
 			expectUnbindSpec: domain.UnbindSpec{},
 		},
+		"deletes inline policies": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "binding-1",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					inlinePolicies: map[string][]string{
+						"prefix-binding-1": {"policy1", "stale-policy"},
+					},
+				},
+				userPrefix: "prefix",
+			},
+			expectInlinePolicies: map[string][]string{"prefix-binding-1": {}},
+			expectUnbindSpec:     domain.UnbindSpec{},
+		},
+		"error listing inline policies": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "binding-1",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					listUserPoliciesErr: listUserPoliciesErr,
+				},
+				userPrefix: "prefix",
+			},
+			expectedErr:      listUserPoliciesErr,
+			expectUnbindSpec: domain.UnbindSpec{},
+		},
+		"error deleting inline policy": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "binding-1",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					inlinePolicies: map[string][]string{
+						"prefix-binding-1": {"policy1"},
+					},
+					deleteInlinePolicyErr: deleteInlinePolicyErr,
+				},
+				userPrefix: "prefix",
+			},
+			expectedErr:          deleteInlinePolicyErr,
+			expectInlinePolicies: map[string][]string{"prefix-binding-1": {"policy1"}},
+			expectUnbindSpec:     domain.UnbindSpec{},
+		},
 		"error listing user policies": {
 			instanceId:    "fake-instance-id",
 			bindingId:     "binding-1",
@@ -538,12 +986,12 @@ func TestUnbind(t *testing.T) {
 			broker: &S3Broker{
 				logger: logger,
 				user: &mockUser{
-					attachedUserPolicies: []string{"policy1"},
+					attachedUserPolicies: []string{"arn:aws:iam::000000000000:policy/-binding-1"},
 					deleteUserPolicyErr:  deleteUserPolicyErr,
 				},
 			},
 			expectedErr:              deleteUserPolicyErr,
-			expectDetachedPolicyArns: []string{"policy1"},
+			expectDetachedPolicyArns: []string{"arn:aws:iam::000000000000:policy/-binding-1"},
 			expectUnbindSpec:         domain.UnbindSpec{},
 		},
 		"detaches policy and deletes policy successfully": {
@@ -553,14 +1001,29 @@ func TestUnbind(t *testing.T) {
 			broker: &S3Broker{
 				logger: logger,
 				user: &mockUser{
-					attachedUserPolicies: []string{"policy1"},
-					policies:             []string{"policy1"},
+					attachedUserPolicies: []string{"arn:aws:iam::000000000000:policy/-binding-1"},
+					policies:             []string{"arn:aws:iam::000000000000:policy/-binding-1"},
 				},
 			},
-			expectDetachedPolicyArns: []string{"policy1"},
+			expectDetachedPolicyArns: []string{"arn:aws:iam::000000000000:policy/-binding-1"},
 			expectPolicyARNs:         []string{},
 			expectUnbindSpec:         domain.UnbindSpec{},
 		},
+		"detaches plan managed policy without deleting it": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "binding-1",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					attachedUserPolicies: []string{"arn:aws:iam::aws:policy/CloudWatchLogsFullAccess"},
+					policies:             []string{"arn:aws:iam::aws:policy/CloudWatchLogsFullAccess"},
+				},
+			},
+			expectDetachedPolicyArns: []string{"arn:aws:iam::aws:policy/CloudWatchLogsFullAccess"},
+			expectPolicyARNs:         []string{"arn:aws:iam::aws:policy/CloudWatchLogsFullAccess"},
+			expectUnbindSpec:         domain.UnbindSpec{},
+		},
 	}
 
 	for name, test := range testCases {
@@ -585,6 +1048,9 @@ func TestUnbind(t *testing.T) {
 				if !cmp.Equal(test.expectPolicyARNs, user.policies) {
 					t.Fatalf(cmp.Diff(user.policies, test.expectPolicyARNs))
 				}
+				if !cmp.Equal(test.expectInlinePolicies, user.inlinePolicies) {
+					t.Fatalf(cmp.Diff(user.inlinePolicies, test.expectInlinePolicies))
+				}
 			}
 			if err != test.expectedErr {
 				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
@@ -636,11 +1102,10 @@ func TestBind(t *testing.T) {
 		expectErr     error
 
 		// side effects
-		expectUserExists         bool
-		expectUser               mockUser // todo dedup with above
-		expectAccessKeys         map[string][]string
-		expectPolicies           []string
-		expectAttachedPolicyArns []string
+		expectUserExists     bool
+		expectUser           mockUser // todo dedup with above
+		expectAccessKeys     map[string][]string
+		expectInlinePolicies map[string][]string
 	}{
 		"malformed bind parameters": {
 			instanceId: "instance1",
@@ -652,7 +1117,7 @@ func TestBind(t *testing.T) {
 				logger: logger,
 			},
 			expectBinding: domain.Binding{},
-			expectErr:     NewTestErr("unexpected end of JSON input"),
+			expectErr:     NewTestErr("Parameters are not valid JSON: unexpected end of JSON input"),
 		},
 		"missing service plan": {
 			instanceId: "instance1",
@@ -683,32 +1148,26 @@ func TestBind(t *testing.T) {
 			expectBinding: domain.Binding{},
 			expectErr:     NewTestErr("Service 'service1' not found"),
 		},
-		"failed to create user": {
+		"invalid permissions": {
 			instanceId: "instance1",
 			bindingId:  "binding1",
 			bindDetails: domain.BindDetails{
-				PlanID:    "planid1",
-				ServiceID: "serviceid1",
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(`{"permissions": "admin"}`),
 			},
 			broker: &S3Broker{
 				logger: logger,
-				bucket: &mockBucket{
-					describeDetails: awss3.BucketDetails{},
-				},
 				catalog: &mockCatalog{
 					planName:    "plan1",
 					serviceName: "service1",
 				},
-				tagManager: &mockTagGenerator{},
-				user: &mockUser{
-					createUserErr: NewTestErr("error creating user"),
-				},
+				user: &mockUser{},
 			},
-			expectBinding:    domain.Binding{},
-			expectErr:        NewTestErr("error creating user"),
-			expectUserExists: false,
+			expectBinding: domain.Binding{},
+			expectErr:     NewTestErr("Invalid parameters: permissions: must be one of read-write, read-only, write-only"),
 		},
-		"failed to create access key": {
+		"failed to create user": {
 			instanceId: "instance1",
 			bindingId:  "binding1",
 			bindDetails: domain.BindDetails{
@@ -726,14 +1185,14 @@ func TestBind(t *testing.T) {
 				},
 				tagManager: &mockTagGenerator{},
 				user: &mockUser{
-					createAccessKeyErr: NewTestErr("error creating access key"),
+					createUserErr: NewTestErr("error creating user"),
 				},
 			},
 			expectBinding:    domain.Binding{},
-			expectErr:        NewTestErr("error creating access key"),
+			expectErr:        NewTestErr("error creating user"),
 			expectUserExists: false,
 		},
-		"failed to create policy": {
+		"failed to create access key": {
 			instanceId: "instance1",
 			bindingId:  "binding1",
 			bindDetails: domain.BindDetails{
@@ -745,23 +1204,20 @@ func TestBind(t *testing.T) {
 				bucket: &mockBucket{
 					describeDetails: awss3.BucketDetails{},
 				},
-				bucketPrefix: "test",
 				catalog: &mockCatalog{
 					planName:    "plan1",
 					serviceName: "service1",
 				},
 				tagManager: &mockTagGenerator{},
 				user: &mockUser{
-					createPolicyErr: NewTestErr("error creating policy"),
+					createAccessKeyErr: NewTestErr("error creating access key"),
 				},
 			},
-			expectAccessKeys: map[string][]string{"-binding1": {}},
 			expectBinding:    domain.Binding{},
-			expectErr:        NewTestErr("error creating policy"),
+			expectErr:        NewTestErr("error creating access key"),
 			expectUserExists: false,
-			expectPolicies:   nil,
 		},
-		"failed to attach policy": {
+		"failed to put user policy": {
 			instanceId: "instance1",
 			bindingId:  "binding1",
 			bindDetails: domain.BindDetails{
@@ -780,14 +1236,13 @@ func TestBind(t *testing.T) {
 				},
 				tagManager: &mockTagGenerator{},
 				user: &mockUser{
-					attachUserPolicyErr: NewTestErr("error attaching policy"),
+					putUserPolicyErr: NewTestErr("error putting user policy"),
 				},
 			},
 			expectAccessKeys: map[string][]string{"-binding1": {}},
 			expectBinding:    domain.Binding{},
-			expectErr:        NewTestErr("error attaching policy"),
+			expectErr:        NewTestErr("error putting user policy"),
 			expectUserExists: false,
-			expectPolicies:   []string{},
 		},
 		"success": {
 			instanceId: "instance1",
@@ -817,8 +1272,8 @@ func TestBind(t *testing.T) {
 					AdditionalBuckets: []string{""},
 				},
 			},
-			expectUserExists: true,
-			expectPolicies:   []string{"-binding1"},
+			expectUserExists:     true,
+			expectInlinePolicies: map[string][]string{"-binding1": {"-binding1"}},
 		},
 	}
 	for name, tc := range testCases {
@@ -842,10 +1297,120 @@ func TestBind(t *testing.T) {
 				if !cmp.Equal(tc.expectAccessKeys, user.accessKeys) {
 					t.Fatalf(cmp.Diff(user.accessKeys, tc.expectAccessKeys))
 				}
-				if !cmp.Equal(tc.expectPolicies, user.policies) {
-					t.Fatalf(cmp.Diff(user.policies, tc.expectPolicies))
+				if user.policies != nil {
+					t.Fatalf("expected no managed policies, got %v", user.policies)
+				}
+				if !cmp.Equal(tc.expectInlinePolicies, user.inlinePolicies) {
+					t.Fatalf(cmp.Diff(user.inlinePolicies, tc.expectInlinePolicies))
 				}
 			}
 		})
 	}
 }
+
+func TestIamPolicyFor(t *testing.T) {
+	testCases := map[string]struct {
+		properties   S3Properties
+		permissions  Permissions
+		expectPolicy string
+		expectErr    bool
+	}{
+		"defaults to read-write": {
+			properties:   S3Properties{IamPolicy: "read-write-policy"},
+			expectPolicy: "read-write-policy",
+		},
+		"read-write": {
+			properties:   S3Properties{IamPolicy: "read-write-policy"},
+			permissions:  PermissionsReadWrite,
+			expectPolicy: "read-write-policy",
+		},
+		"read-only from plan": {
+			properties:   S3Properties{IamPolicy: "read-write-policy", ReadOnlyIamPolicy: "read-only-policy"},
+			permissions:  PermissionsReadOnly,
+			expectPolicy: "read-only-policy",
+		},
+		"read-only default": {
+			properties:   S3Properties{IamPolicy: "read-write-policy"},
+			permissions:  PermissionsReadOnly,
+			expectPolicy: defaultReadOnlyIamPolicy,
+		},
+		"write-only from plan": {
+			properties:   S3Properties{IamPolicy: "read-write-policy", WriteOnlyIamPolicy: "write-only-policy"},
+			permissions:  PermissionsWriteOnly,
+			expectPolicy: "write-only-policy",
+		},
+		"write-only default": {
+			properties:   S3Properties{IamPolicy: "read-write-policy"},
+			permissions:  PermissionsWriteOnly,
+			expectPolicy: defaultWriteOnlyIamPolicy,
+		},
+		"unknown permissions": {
+			properties:  S3Properties{IamPolicy: "read-write-policy"},
+			permissions: "admin",
+			expectErr:   true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			policy, err := tc.properties.IamPolicyFor(tc.permissions)
+			if tc.expectErr {
+				var failureResponse *apiresponses.FailureResponse
+				if !errors.As(err, &failureResponse) || failureResponse.ValidatedStatusCode(nil) != http.StatusBadRequest {
+					t.Fatalf("expected a 400 failure response, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if policy != tc.expectPolicy {
+				t.Errorf(cmp.Diff(tc.expectPolicy, policy))
+			}
+		})
+	}
+}
+
+func TestNormalizePathPrefix(t *testing.T) {
+	testCases := map[string]struct {
+		pathPrefix       string
+		expectPathPrefix string
+		expectErr        bool
+	}{
+		"empty": {},
+		"single segment": {
+			pathPrefix:       "app",
+			expectPathPrefix: "app",
+		},
+		"strips slashes": {
+			pathPrefix:       "/team/app/",
+			expectPathPrefix: "team/app",
+		},
+		"wildcard": {
+			pathPrefix: "app*",
+			expectErr:  true,
+		},
+		"policy variable": {
+			pathPrefix: "${aws:username}",
+			expectErr:  true,
+		},
+		"empty segment": {
+			pathPrefix: "team//app",
+			expectErr:  true,
+		},
+		"parent segment": {
+			pathPrefix: "team/../other",
+			expectErr:  true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			pathPrefix, err := normalizePathPrefix(tc.pathPrefix)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error: %t, got: %v", tc.expectErr, err)
+			}
+			if pathPrefix != tc.expectPathPrefix {
+				t.Errorf("expected %q, got %q", tc.expectPathPrefix, pathPrefix)
+			}
+		})
+	}
+}
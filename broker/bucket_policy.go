@@ -0,0 +1,115 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+var (
+	ErrBucketPolicyBindingsDisabled = apiresponses.NewFailureResponse(
+		errors.New("This broker is not configured to grant bucket access through the bucket policy. Contact your Cloud Foundry operator for details."),
+		http.StatusBadRequest,
+		"bucket-policy-bindings-disabled",
+	)
+	ErrBucketPolicyAdditionalInstances = apiresponses.NewFailureResponse(
+		errors.New("additional_instances cannot be used with credential_type bucket-policy"),
+		http.StatusBadRequest,
+		"invalid-additional-instances",
+	)
+
+	nonAlphanumericPattern = regexp.MustCompile(`[^A-Za-z0-9]`)
+)
+
+// bindingStatementSids returns the Sids of a binding's bucket policy
+// statements. They are derived from the binding ID so Unbind can remove
+// them without any other record of the binding.
+func bindingStatementSids(bindingID string) (bucketSid, listSid, objectsSid string) {
+	base := "Binding" + nonAlphanumericPattern.ReplaceAllString(bindingID, "")
+	return base + "Bucket", base + "List", base + "Objects"
+}
+
+// bucketPolicyStatements grants principalARN access to the bucket, matching
+// the default IAM policies of each permissions value.
+func bucketPolicyStatements(bindingID, principalARN, bucketARN string, permissions Permissions, pathPrefix string) []awss3.PolicyStatement {
+	bucketSid, listSid, objectsSid := bindingStatementSids(bindingID)
+	principal := map[string]string{"AWS": principalARN}
+
+	var bucketActions, objectActions []string
+	canList := true
+	switch permissions {
+	case PermissionsReadOnly:
+		bucketActions = []string{"s3:GetBucketLocation"}
+		objectActions = []string{"s3:GetObject"}
+	case PermissionsWriteOnly:
+		canList = false
+		bucketActions = []string{"s3:GetBucketLocation", "s3:ListBucketMultipartUploads"}
+		objectActions = []string{"s3:AbortMultipartUpload", "s3:ListMultipartUploadParts", "s3:PutObject"}
+	default:
+		bucketActions = []string{"s3:GetBucketLocation", "s3:ListBucketMultipartUploads"}
+		objectActions = []string{"s3:AbortMultipartUpload", "s3:DeleteObject", "s3:GetObject", "s3:ListMultipartUploadParts", "s3:PutObject"}
+	}
+
+	objectResource := bucketARN + "/*"
+	if pathPrefix != "" {
+		objectResource = bucketARN + "/" + pathPrefix + "/*"
+	}
+
+	statements := []awss3.PolicyStatement{
+		{Sid: bucketSid, Effect: "Allow", Principal: principal, Action: bucketActions, Resource: []string{bucketARN}},
+		{Sid: objectsSid, Effect: "Allow", Principal: principal, Action: objectActions, Resource: []string{objectResource}},
+	}
+	if canList {
+		list := awss3.PolicyStatement{Sid: listSid, Effect: "Allow", Principal: principal, Action: []string{"s3:ListBucket"}, Resource: []string{bucketARN}}
+		if pathPrefix != "" {
+			list.Condition = map[string]map[string]string{"StringLike": {"s3:prefix": pathPrefix + "/*"}}
+		}
+		statements = append(statements, list)
+	}
+	return statements
+}
+
+// bindBucketPolicy grants a principal in another AWS account access to the
+// bucket through the bucket policy, instead of handing out credentials.
+func (b *S3Broker) bindBucketPolicy(
+	ctx context.Context,
+	instanceID, bindingID, bucketName, bucketARN, principalARN string,
+	permissions Permissions,
+	pathPrefix string,
+	credentials Credentials,
+) (domain.Binding, error) {
+	statements := bucketPolicyStatements(bindingID, principalARN, bucketARN, permissions, pathPrefix)
+	if err := b.bucket.AddPolicyStatements(ctx, bucketName, statements); err != nil {
+		b.logger.Error("bind: error adding bucket policy statements", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			"bucket":         bucketName,
+		})
+		return domain.Binding{}, mapBucketError(err)
+	}
+
+	credentials.BucketARN = bucketARN
+	return domain.Binding{Credentials: credentials}, nil
+}
+
+// unbindBucketPolicy removes the binding's statements from the instance's
+// bucket policy, if it has any.
+func (b *S3Broker) unbindBucketPolicy(ctx context.Context, instanceID, bindingID string, servicePlan ServicePlan) error {
+	bucketName, err := b.instanceBucketName(ctx, instanceID, servicePlan)
+	if err != nil {
+		if errors.Is(err, awss3.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	bucketSid, listSid, objectsSid := bindingStatementSids(bindingID)
+	return mapBucketError(b.bucket.RemovePolicyStatements(ctx, bucketName, []string{bucketSid, listSid, objectsSid}))
+}
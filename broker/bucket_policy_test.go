@@ -0,0 +1,156 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+func withBucketPolicyBindings(allow bool) func(*S3Broker) {
+	return func(b *S3Broker) {
+		b.catalog = &mockCatalog{planName: "plan1", serviceName: "service1", s3Properties: S3Properties{IamPolicy: "{}"}}
+		b.bucketPrefix = "prefix"
+		b.awsPartition = "aws"
+		b.allowBucketPolicyBindings = allow
+	}
+}
+
+func TestBindBucketPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		allow           bool
+		parameters      string
+		expectErr       string
+		expectSids      []string
+		expectActions   map[string][]string
+		expectResources map[string][]string
+		expectCondition bool
+	}{
+		"disabled": {
+			parameters: `{"credential_type": "bucket-policy", "principal": "123456789012"}`,
+			expectErr:  ErrBucketPolicyBindingsDisabled.Error(),
+		},
+		"invalid principal": {
+			allow:      true,
+			parameters: `{"credential_type": "bucket-policy", "principal": "someone"}`,
+			expectErr:  `principal must be an AWS account ID or IAM ARN, got "someone"`,
+		},
+		"additional instances": {
+			allow:      true,
+			parameters: `{"credential_type": "bucket-policy", "principal": "123456789012", "additional_instances": ["instance2"]}`,
+			expectErr:  ErrBucketPolicyAdditionalInstances.Error(),
+		},
+		"read-write": {
+			allow:      true,
+			parameters: `{"credential_type": "bucket-policy", "principal": "123456789012"}`,
+			expectSids: []string{"Bindingbinding1Bucket", "Bindingbinding1List", "Bindingbinding1Objects"},
+			expectActions: map[string][]string{
+				"Bindingbinding1Objects": {"s3:AbortMultipartUpload", "s3:DeleteObject", "s3:GetObject", "s3:ListMultipartUploadParts", "s3:PutObject"},
+			},
+			expectResources: map[string][]string{
+				"Bindingbinding1Bucket":  {"arn:aws:s3:::prefix-instance1"},
+				"Bindingbinding1Objects": {"arn:aws:s3:::prefix-instance1/*"},
+			},
+		},
+		"write-only": {
+			allow:      true,
+			parameters: `{"credential_type": "bucket-policy", "principal": "arn:aws:iam::123456789012:role/uploader", "permissions": "write-only"}`,
+			expectSids: []string{"Bindingbinding1Bucket", "Bindingbinding1Objects"},
+			expectActions: map[string][]string{
+				"Bindingbinding1Objects": {"s3:AbortMultipartUpload", "s3:ListMultipartUploadParts", "s3:PutObject"},
+			},
+		},
+		"read-only with path prefix": {
+			allow:      true,
+			parameters: `{"credential_type": "bucket-policy", "principal": "123456789012", "permissions": "read-only", "path_prefix": "reports"}`,
+			expectSids: []string{"Bindingbinding1Bucket", "Bindingbinding1List", "Bindingbinding1Objects"},
+			expectActions: map[string][]string{
+				"Bindingbinding1Objects": {"s3:GetObject"},
+			},
+			expectResources: map[string][]string{
+				"Bindingbinding1Objects": {"arn:aws:s3:::prefix-instance1/reports/*"},
+			},
+			expectCondition: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := &mockBucket{describeDetails: awss3.BucketDetails{
+				BucketName: "prefix-instance1",
+				ARN:        "arn:aws:s3:::prefix-instance1",
+			}}
+			b := newTestBroker(withBucket(bucket), withBucketPolicyBindings(tc.allow))
+
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				PlanID:        "plan1",
+				ServiceID:     "service1",
+				RawParameters: json.RawMessage(tc.parameters),
+			}, false)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected err %q, got %v", tc.expectErr, err)
+				}
+				if len(bucket.policyStatements) > 0 {
+					t.Errorf("expected no policy statements, got %v", bucket.policyStatements)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			credentials := binding.Credentials.(Credentials)
+			if credentials.BucketARN != "arn:aws:s3:::prefix-instance1" || credentials.AccessKeyID != "" {
+				t.Errorf("unexpected credentials %+v", credentials)
+			}
+
+			statements := bucket.policyStatements["prefix-instance1"]
+			var sids []string
+			for sid := range statements {
+				sids = append(sids, sid)
+			}
+			slices.Sort(sids)
+			if !slices.Equal(sids, tc.expectSids) {
+				t.Fatalf("expected statements %v, got %v", tc.expectSids, sids)
+			}
+			for sid, actions := range tc.expectActions {
+				if !slices.Equal(statements[sid].Action, actions) {
+					t.Errorf("expected %s actions %v, got %v", sid, actions, statements[sid].Action)
+				}
+			}
+			for sid, resources := range tc.expectResources {
+				if !slices.Equal(statements[sid].Resource, resources) {
+					t.Errorf("expected %s resources %v, got %v", sid, resources, statements[sid].Resource)
+				}
+			}
+			if hasCondition := statements["Bindingbinding1List"].Condition != nil; hasCondition != tc.expectCondition {
+				t.Errorf("expected list condition %t, got %v", tc.expectCondition, statements["Bindingbinding1List"].Condition)
+			}
+		})
+	}
+}
+
+func TestUnbindBucketPolicy(t *testing.T) {
+	other := awss3.PolicyStatement{Sid: "Bindingbinding2Bucket"}
+	bucket := &mockBucket{policyStatements: map[string]map[string]awss3.PolicyStatement{
+		"prefix-instance1": {
+			"Bindingbinding1Bucket":  {Sid: "Bindingbinding1Bucket"},
+			"Bindingbinding1List":    {Sid: "Bindingbinding1List"},
+			"Bindingbinding1Objects": {Sid: "Bindingbinding1Objects"},
+			other.Sid:                other,
+		},
+	}}
+	b := newTestBroker(withBucket(bucket), withBucketPolicyBindings(true))
+
+	if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{PlanID: "plan1"}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	statements := bucket.policyStatements["prefix-instance1"]
+	if len(statements) != 1 || statements[other.Sid].Sid != other.Sid {
+		t.Errorf("expected only %s to remain, got %v", other.Sid, statements)
+	}
+}
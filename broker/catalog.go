@@ -1,9 +1,16 @@
 package broker
 
 import (
+	"cmp"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pivotal-cf/brokerapi/v10"
 )
 
@@ -11,6 +18,7 @@ type Catalog interface {
 	Validate() error
 	FindService(serviceID string) (service Service, found bool)
 	FindServicePlan(planID string) (plan ServicePlan, found bool)
+	ListServices() []Service
 	ListServicePlans() []ServicePlan
 }
 
@@ -27,31 +35,128 @@ type Service struct {
 	PlanUpdatable   bool                              `yaml:"plan_updateable"`
 	Plans           []ServicePlan                     `yaml:"plans"`
 	Requires        []brokerapi.RequiredPermission    `yaml:"requires,omitempty"`
-	Metadata        *brokerapi.ServiceMetadata        `yaml:"metadata,omitempty"`
+	Metadata        *ServiceMetadata                  `yaml:"metadata,omitempty"`
 	DashboardClient *brokerapi.ServiceDashboardClient `yaml:"dashboard_client,omitempty"`
+
+	// S3Properties are the defaults for the S3 properties of the service's
+	// plans, so that each offering can have its own baseline bucket
+	// configuration.
+	S3Properties S3Properties `yaml:"s3_properties,omitempty"`
 }
 
 type ServicePlan struct {
-	ID            string                         `yaml:"id"`
-	Name          string                         `yaml:"name"`
-	Description   string                         `yaml:"description"`
-	Free          bool                           `yaml:"free"`
-	Metadata      *brokerapi.ServicePlanMetadata `yaml:"metadata,omitempty"`
-	PlanDeletable bool                           `yaml:"deletable,omitempty"`
-	S3Properties  S3Properties                   `yaml:"s3_properties,omitempty"`
+	ID            string               `yaml:"id"`
+	Name          string               `yaml:"name"`
+	Description   string               `yaml:"description"`
+	Free          bool                 `yaml:"free"`
+	Metadata      *ServicePlanMetadata `yaml:"metadata,omitempty"`
+	PlanDeletable bool                 `yaml:"deletable,omitempty"`
+	S3Properties  S3Properties         `yaml:"s3_properties,omitempty"`
+	// MaintenanceInfo is the version of the plan's baseline configuration.
+	// Raising it lets platforms upgrade existing instances, which reapplies
+	// the plan's encryption, versioning and bucket policy to their buckets.
+	MaintenanceInfo *brokerapi.MaintenanceInfo `yaml:"maintenance_info,omitempty"`
+	// MaxInstances limits how many instances of the plan can exist. Zero
+	// means no limit.
+	MaxInstances int `yaml:"max_instances,omitempty"`
+	// Access restricts the organizations and spaces that can use the plan.
+	Access PlanAccess `yaml:"access,omitempty"`
+}
+
+// ServiceMetadata is what marketplaces show about a service besides its name
+// and description. Its keys are the OSB catalog's, such as displayName.
+type ServiceMetadata struct {
+	DisplayName         string `yaml:"displayName,omitempty" json:"displayName,omitempty"`
+	ImageURL            string `yaml:"imageUrl,omitempty" json:"imageUrl,omitempty"`
+	LongDescription     string `yaml:"longDescription,omitempty" json:"longDescription,omitempty"`
+	ProviderDisplayName string `yaml:"providerDisplayName,omitempty" json:"providerDisplayName,omitempty"`
+	DocumentationURL    string `yaml:"documentationUrl,omitempty" json:"documentationUrl,omitempty"`
+	SupportURL          string `yaml:"supportUrl,omitempty" json:"supportUrl,omitempty"`
+	Shareable           *bool  `yaml:"shareable,omitempty" json:"shareable,omitempty"`
+}
+
+// ServicePlanMetadata is what marketplaces show about a plan besides its
+// name and description.
+type ServicePlanMetadata struct {
+	DisplayName string            `yaml:"displayName,omitempty" json:"displayName,omitempty"`
+	Bullets     []string          `yaml:"bullets,omitempty" json:"bullets,omitempty"`
+	Costs       []ServicePlanCost `yaml:"costs,omitempty" json:"costs,omitempty"`
+}
+
+// ServicePlanCost is a plan's price per Unit, such as "Per GB", in one or
+// more currencies, such as usd.
+type ServicePlanCost struct {
+	Amount map[string]float64 `yaml:"amount" json:"amount"`
+	Unit   string             `yaml:"unit" json:"unit"`
 }
 
 type S3Properties struct {
-	IamPolicy    string `yaml:"iam_policy,omitempty"`
-	BucketPolicy string `yaml:"bucket_policy,omitempty"`
-	Encryption   string `yaml:"encryption,omitempty"`
+	IamPolicy          string `yaml:"iam_policy,omitempty"`
+	ReadOnlyIamPolicy  string `yaml:"read_only_iam_policy,omitempty"`
+	WriteOnlyIamPolicy string `yaml:"write_only_iam_policy,omitempty"`
+	BucketPolicy       string `yaml:"bucket_policy,omitempty"`
+	Encryption         string `yaml:"encryption,omitempty"`
+	// ManagedPolicyARNs are attached to every binding's user or role, in
+	// addition to the generated policy.
+	ManagedPolicyARNs []string `yaml:"managed_policy_arns,omitempty"`
+	// ExistingBucket plans use a bucket that already exists instead of
+	// creating one. The broker only tags the bucket and never deletes it.
+	ExistingBucket bool `yaml:"existing_bucket,omitempty"`
+	// CredentialFormat is the default format of the plan's binding
+	// credentials. Bindings can choose another with credential_format.
+	CredentialFormat string `yaml:"credential_format,omitempty"`
+	// Versioning enables object versioning on the plan's buckets. Updating
+	// to a plan without it suspends versioning.
+	Versioning bool `yaml:"versioning,omitempty"`
+	// UpdatableTo names the plans that instances of this plan can be
+	// updated to. If it is empty, any plan of the service is allowed.
+	UpdatableTo []string `yaml:"updatable_to,omitempty"`
+	// PreserveOnDelete keeps the plan's buckets and their objects when
+	// instances are deleted, unless an instance sets preserve_on_delete.
+	PreserveOnDelete bool `yaml:"preserve_on_delete,omitempty"`
+	// AllowedOverrideParams names the provision and update parameters that
+	// users may set to configure the plan's buckets, if the broker allows
+	// user parameters. If it is not set, all of them are allowed.
+	AllowedOverrideParams []string `yaml:"allowed_override_params,omitempty"`
+	// Account names the configured account that the plan's buckets and
+	// bindings are created in. If it is empty, they are created in the
+	// broker's own account.
+	Account string `yaml:"account,omitempty"`
+	// DualStack gives the plan's bindings dual-stack endpoints, which can
+	// be reached over IPv6.
+	DualStack bool `yaml:"dualstack,omitempty"`
+	// ObjectStore names the configured object store, gcs or azure, that the
+	// plan's buckets and bindings are created in instead of S3.
+	ObjectStore string `yaml:"object_store,omitempty"`
+	// StorageQuotaGB caps the size of the plan's buckets, in GB: once a
+	// measurement of an instance's usage exceeds it, its bucket policy
+	// denies uploads until a measurement is back under it. Zero means no
+	// quota.
+	StorageQuotaGB float64 `yaml:"storage_quota_gb,omitempty"`
 }
 
 func (c BrokerCatalog) Validate() error {
+	serviceIDs := make(map[string]bool)
+	serviceNames := make(map[string]bool)
+	planIDs := make(map[string]bool)
 	for _, service := range c.Services {
 		if err := service.Validate(); err != nil {
 			return fmt.Errorf("Validating Services configuration: %s", err)
 		}
+		if serviceIDs[service.ID] {
+			return fmt.Errorf("Validating Services configuration: service ID %q is not unique", service.ID)
+		}
+		if serviceNames[service.Name] {
+			return fmt.Errorf("Validating Services configuration: service name %q is not unique", service.Name)
+		}
+		serviceIDs[service.ID] = true
+		serviceNames[service.Name] = true
+		for _, plan := range service.Plans {
+			if planIDs[plan.ID] {
+				return fmt.Errorf("Validating Services configuration: plan ID %q is not unique", plan.ID)
+			}
+			planIDs[plan.ID] = true
+		}
 	}
 
 	return nil
@@ -69,7 +174,7 @@ func (c BrokerCatalog) FindService(serviceID string) (service Service, found boo
 
 func (c BrokerCatalog) FindServicePlan(planID string) (plan ServicePlan, found bool) {
 	for _, service := range c.Services {
-		for _, plan := range service.Plans {
+		for _, plan := range service.plansWithDefaults() {
 			if plan.ID == planID {
 				return plan, true
 			}
@@ -79,12 +184,25 @@ func (c BrokerCatalog) FindServicePlan(planID string) (plan ServicePlan, found b
 	return plan, false
 }
 
+func (c BrokerCatalog) ListServices() []Service {
+	return c.Services
+}
+
 func (c BrokerCatalog) ListServicePlans() []ServicePlan {
 	var plans []ServicePlan
 	for _, service := range c.Services {
-		for _, plan := range service.Plans {
-			plans = append(plans, plan)
-		}
+		plans = append(plans, service.plansWithDefaults()...)
+	}
+	return plans
+}
+
+// plansWithDefaults returns the service's plans with the S3 properties they
+// do not set taken from the service.
+func (s Service) plansWithDefaults() []ServicePlan {
+	plans := make([]ServicePlan, len(s.Plans))
+	for i, plan := range s.Plans {
+		plan.S3Properties = plan.S3Properties.withDefaults(s.S3Properties)
+		plans[i] = plan
 	}
 	return plans
 }
@@ -102,10 +220,21 @@ func (s Service) Validate() error {
 		return fmt.Errorf("Must provide a non-empty Description (%+v)", s)
 	}
 
-	for _, servicePlan := range s.Plans {
+	if s.Metadata != nil {
+		if err := s.Metadata.Validate(); err != nil {
+			return fmt.Errorf("Validating Metadata configuration: %s", err)
+		}
+	}
+
+	for _, servicePlan := range s.plansWithDefaults() {
 		if err := servicePlan.Validate(); err != nil {
 			return fmt.Errorf("Validating Plans configuration: %s", err)
 		}
+		for _, planName := range servicePlan.S3Properties.UpdatableTo {
+			if !slices.ContainsFunc(s.Plans, func(plan ServicePlan) bool { return plan.Name == planName }) {
+				return fmt.Errorf("Validating Plans configuration: plan %q is updatable to unknown plan %q", servicePlan.Name, planName)
+			}
+		}
 	}
 
 	return nil
@@ -124,17 +253,173 @@ func (sp ServicePlan) Validate() error {
 		return fmt.Errorf("Must provide a non-empty Description (%+v)", sp)
 	}
 
+	if sp.Metadata != nil {
+		if err := sp.Metadata.Validate(); err != nil {
+			return fmt.Errorf("Validating Metadata configuration: %s", err)
+		}
+	}
+
 	if err := sp.S3Properties.Validate(); err != nil {
 		return fmt.Errorf("Validating S3 Properties configuration: %s", err)
 	}
 
+	if sp.MaxInstances < 0 {
+		return fmt.Errorf("MaxInstances must not be negative, got %d", sp.MaxInstances)
+	}
+
+	if sp.MaintenanceInfo != nil && !semanticVersionPattern.MatchString(sp.MaintenanceInfo.Version) {
+		return fmt.Errorf("MaintenanceInfo version must be a semantic version, got %q", sp.MaintenanceInfo.Version)
+	}
+
+	return nil
+}
+
+func (m ServiceMetadata) Validate() error {
+	if err := validateMetadataURL("imageUrl", m.ImageURL, "http", "https", "data"); err != nil {
+		return err
+	}
+	if err := validateMetadataURL("documentationUrl", m.DocumentationURL, "http", "https"); err != nil {
+		return err
+	}
+	return validateMetadataURL("supportUrl", m.SupportURL, "http", "https", "mailto")
+}
+
+func (m ServicePlanMetadata) Validate() error {
+	for _, cost := range m.Costs {
+		if cost.Unit == "" {
+			return errors.New("Costs must have a non-empty unit")
+		}
+		if len(cost.Amount) == 0 {
+			return fmt.Errorf("Cost %q must have an amount in at least one currency", cost.Unit)
+		}
+		for currency, amount := range cost.Amount {
+			if amount < 0 {
+				return fmt.Errorf("Cost %q must not have a negative %s amount", cost.Unit, currency)
+			}
+		}
+	}
+	return nil
+}
+
+// validateMetadataURL checks that value, if set, is an absolute URL with one
+// of schemes, so that marketplaces can link to it.
+func validateMetadataURL(name, value string, schemes ...string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || !slices.Contains(schemes, u.Scheme) {
+		return fmt.Errorf("%s must be a URL with scheme %s", name, strings.Join(schemes, ", "))
+	}
 	return nil
 }
 
 func (eq S3Properties) Validate() error {
+	if eq.ObjectStore != "" {
+		return eq.validateObjectStore()
+	}
+
 	if len(eq.IamPolicy) == 0 {
 		return errors.New("Must provide a non-empty IAM Policy")
 	}
 
+	if eq.ExistingBucket && (len(eq.BucketPolicy) > 0 || len(eq.Encryption) > 0) {
+		return errors.New("Bucket policy and encryption cannot be set for existing buckets")
+	}
+
+	if eq.ExistingBucket && eq.Versioning {
+		return errors.New("Versioning cannot be set for existing buckets")
+	}
+
+	if eq.StorageQuotaGB < 0 {
+		return fmt.Errorf("Storage quota must not be negative, got %v", eq.StorageQuotaGB)
+	}
+
+	if eq.ExistingBucket && eq.StorageQuotaGB > 0 {
+		return errors.New("Storage quota cannot be set for existing buckets")
+	}
+
+	if len(eq.Encryption) > 0 {
+		var encryptionConfig s3.ServerSideEncryptionConfiguration
+		if err := json.Unmarshal([]byte(eq.Encryption), &encryptionConfig); err != nil {
+			return fmt.Errorf("Encryption is not a valid server-side encryption configuration: %s", err)
+		}
+	}
+
+	for _, name := range eq.AllowedOverrideParams {
+		if !slices.Contains(overrideParams, name) {
+			return fmt.Errorf("Allowed override parameter %q must be one of %s", name, strings.Join(overrideParams, ", "))
+		}
+	}
+
+	for _, policyARN := range eq.ManagedPolicyARNs {
+		if !policyARNPattern.MatchString(policyARN) {
+			return fmt.Errorf("Managed policy ARN %q is not an IAM policy ARN", policyARN)
+		}
+	}
+
 	return nil
 }
+
+// validateObjectStore checks the properties of a plan in another object store,
+// which has none of the S3 bucket and IAM configuration.
+func (eq S3Properties) validateObjectStore() error {
+	if !slices.Contains(objectStores, eq.ObjectStore) {
+		return fmt.Errorf("Object store must be one of %s, got %q", strings.Join(objectStores, ", "), eq.ObjectStore)
+	}
+	if eq.ExistingBucket || eq.Account != "" || eq.BucketPolicy != "" || eq.Encryption != "" ||
+		eq.Versioning || eq.PreserveOnDelete || eq.DualStack || len(eq.ManagedPolicyARNs) > 0 || eq.StorageQuotaGB != 0 {
+		return fmt.Errorf("Plans in object store %s cannot set existing_bucket, account, bucket_policy, encryption, versioning, preserve_on_delete, dualstack, managed_policy_arns or storage_quota_gb", eq.ObjectStore)
+	}
+	for _, name := range eq.AllowedOverrideParams {
+		if !slices.Contains(objectStoreParams, name) {
+			return fmt.Errorf("Allowed override parameter %q must be one of %s for plans in object store %s", name, strings.Join(objectStoreParams, ", "), eq.ObjectStore)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns eq with the properties it does not set taken from
+// defaults. Boolean properties are enabled if either enables them.
+func (eq S3Properties) withDefaults(defaults S3Properties) S3Properties {
+	eq.IamPolicy = cmp.Or(eq.IamPolicy, defaults.IamPolicy)
+	eq.ReadOnlyIamPolicy = cmp.Or(eq.ReadOnlyIamPolicy, defaults.ReadOnlyIamPolicy)
+	eq.WriteOnlyIamPolicy = cmp.Or(eq.WriteOnlyIamPolicy, defaults.WriteOnlyIamPolicy)
+	eq.BucketPolicy = cmp.Or(eq.BucketPolicy, defaults.BucketPolicy)
+	eq.Encryption = cmp.Or(eq.Encryption, defaults.Encryption)
+	eq.CredentialFormat = cmp.Or(eq.CredentialFormat, defaults.CredentialFormat)
+	eq.ObjectStore = cmp.Or(eq.ObjectStore, defaults.ObjectStore)
+	eq.StorageQuotaGB = cmp.Or(eq.StorageQuotaGB, defaults.StorageQuotaGB)
+	if eq.ManagedPolicyARNs == nil {
+		eq.ManagedPolicyARNs = defaults.ManagedPolicyARNs
+	}
+	if eq.UpdatableTo == nil {
+		eq.UpdatableTo = defaults.UpdatableTo
+	}
+	if eq.AllowedOverrideParams == nil {
+		eq.AllowedOverrideParams = defaults.AllowedOverrideParams
+	}
+	eq.ExistingBucket = eq.ExistingBucket || defaults.ExistingBucket
+	eq.Versioning = eq.Versioning || defaults.Versioning
+	eq.PreserveOnDelete = eq.PreserveOnDelete || defaults.PreserveOnDelete
+	return eq
+}
+
+// KMSKeyID returns the customer-managed KMS key that Encryption applies by
+// default, or "" if objects are not encrypted with one.
+func (eq S3Properties) KMSKeyID() string {
+	var encryptionConfig s3.ServerSideEncryptionConfiguration
+	if err := json.Unmarshal([]byte(eq.Encryption), &encryptionConfig); err != nil {
+		return ""
+	}
+	for _, rule := range encryptionConfig.Rules {
+		defaults := rule.ApplyServerSideEncryptionByDefault
+		if defaults == nil || !strings.HasPrefix(aws.StringValue(defaults.SSEAlgorithm), s3.ServerSideEncryptionAwsKms) {
+			continue
+		}
+		if keyID := aws.StringValue(defaults.KMSMasterKeyID); keyID != "" {
+			return keyID
+		}
+	}
+	return ""
+}
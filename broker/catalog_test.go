@@ -1,11 +1,14 @@
 package broker_test
 
 import (
+	"encoding/json"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloud-gov/s3-broker/broker"
 	"github.com/pivotal-cf/brokerapi/v10"
+	"gopkg.in/yaml.v2"
 )
 
 var _ = Describe("Catalog", func() {
@@ -39,6 +42,18 @@ var _ = Describe("Catalog", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("Validating Services configuration"))
 		})
+
+		It("returns error if two services have a plan with the same ID", func() {
+			validPlan := ServicePlan{ID: "Plan-1", Name: "basic", Description: "Basic", S3Properties: S3Properties{IamPolicy: "{}"}}
+			catalog.Services = []Service{
+				Service{ID: "Service-1", Name: "s3-private", Description: "Private buckets", Plans: []ServicePlan{validPlan}},
+				Service{ID: "Service-2", Name: "s3-archive", Description: "Archive buckets", Plans: []ServicePlan{validPlan}},
+			}
+
+			err := catalog.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`plan ID "Plan-1" is not unique`))
+		})
 	})
 
 	Describe("FindService", func() {
@@ -77,6 +92,15 @@ var _ = Describe("Catalog", func() {
 			_, found := catalog.FindServicePlan("Plan-?")
 			Expect(found).To(BeFalse())
 		})
+
+		It("returns the Service Plan with the service's S3 properties as defaults", func() {
+			catalog.Services[0].S3Properties = S3Properties{IamPolicy: "service-policy", Encryption: "service-encryption", Versioning: true}
+			catalog.Services[0].Plans = []ServicePlan{{ID: "Plan-1", S3Properties: S3Properties{Encryption: "plan-encryption"}}}
+
+			plan, found := catalog.FindServicePlan("Plan-1")
+			Expect(found).To(BeTrue())
+			Expect(plan.S3Properties).To(Equal(S3Properties{IamPolicy: "service-policy", Encryption: "plan-encryption", Versioning: true}))
+		})
 	})
 })
 
@@ -90,7 +114,7 @@ var _ = Describe("Service", func() {
 			Description:     "Service 1 description",
 			Bindable:        true,
 			Tags:            []string{"service"},
-			Metadata:        &brokerapi.ServiceMetadata{},
+			Metadata:        &ServiceMetadata{},
 			Requires:        []brokerapi.RequiredPermission{},
 			PlanUpdatable:   true,
 			Plans:           []ServicePlan{},
@@ -141,6 +165,29 @@ var _ = Describe("Service", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("Validating Plans configuration"))
 		})
+
+		It("returns error if the Metadata has an invalid URL", func() {
+			service.Metadata = &ServiceMetadata{DocumentationURL: "docs.example.com"}
+
+			err := service.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("documentationUrl must be a URL with scheme http, https"))
+		})
+
+		It("returns error if a plan is updatable to an unknown plan", func() {
+			service.Plans = []ServicePlan{
+				ServicePlan{
+					ID:           "Plan-1",
+					Name:         "Plan 1",
+					Description:  "Plan 1 description",
+					S3Properties: S3Properties{IamPolicy: "{}", UpdatableTo: []string{"Plan 2"}},
+				},
+			}
+
+			err := service.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`plan "Plan 1" is updatable to unknown plan "Plan 2"`))
+		})
 	})
 })
 
@@ -152,7 +199,7 @@ var _ = Describe("ServicePlan", func() {
 			ID:          "Plan-1",
 			Name:        "Plan 1",
 			Description: "Plan-1 description",
-			Metadata:    &brokerapi.ServicePlanMetadata{},
+			Metadata:    &ServicePlanMetadata{},
 			Free:        true,
 			S3Properties: S3Properties{
 				IamPolicy: "fake-iam-policy",
@@ -193,5 +240,134 @@ var _ = Describe("ServicePlan", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty Description"))
 		})
+
+		It("returns error if an existing bucket plan sets a bucket policy", func() {
+			servicePlan.S3Properties.ExistingBucket = true
+			servicePlan.S3Properties.BucketPolicy = "fake-bucket-policy"
+
+			err := servicePlan.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Bucket policy and encryption cannot be set for existing buckets"))
+		})
+
+		It("returns error if an existing bucket plan sets a storage quota", func() {
+			servicePlan.S3Properties.ExistingBucket = true
+			servicePlan.S3Properties.StorageQuotaGB = 100
+
+			err := servicePlan.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Storage quota cannot be set for existing buckets"))
+		})
+
+		It("returns error if Encryption is not valid", func() {
+			servicePlan.S3Properties.Encryption = "aws:kms"
+
+			err := servicePlan.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Encryption is not a valid server-side encryption configuration"))
+		})
+
+		It("returns error if an allowed override parameter is unknown", func() {
+			servicePlan.S3Properties.AllowedOverrideParams = []string{"cors_rules", "bucket_policy"}
+
+			err := servicePlan.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Allowed override parameter "bucket_policy" must be one of`))
+		})
+
+		It("does not require an IAM policy for plans in another object store", func() {
+			servicePlan.S3Properties = S3Properties{ObjectStore: "gcs", AllowedOverrideParams: []string{"tags"}}
+
+			err := servicePlan.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error if the object store is unknown", func() {
+			servicePlan.S3Properties.ObjectStore = "b2"
+
+			err := servicePlan.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Object store must be one of gcs, azure, got "b2"`))
+		})
+
+		It("returns error if a plan in another object store sets S3 bucket configuration", func() {
+			servicePlan.S3Properties = S3Properties{ObjectStore: "azure", Versioning: true}
+
+			err := servicePlan.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Plans in object store azure cannot set"))
+		})
+
+		It("returns error if a plan in another object store allows an S3 override parameter", func() {
+			servicePlan.S3Properties = S3Properties{ObjectStore: "gcs", AllowedOverrideParams: []string{"cors_rules"}}
+
+			err := servicePlan.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Allowed override parameter "cors_rules" must be one of tags, annotations`))
+		})
+
+		It("returns error if a cost has no amount", func() {
+			servicePlan.Metadata = &ServicePlanMetadata{Costs: []ServicePlanCost{{Unit: "Per GB"}}}
+
+			err := servicePlan.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Cost "Per GB" must have an amount in at least one currency`))
+		})
+
+		It("returns error if the MaintenanceInfo version is not a semantic version", func() {
+			servicePlan.MaintenanceInfo = &brokerapi.MaintenanceInfo{Version: "v2"}
+
+			err := servicePlan.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("MaintenanceInfo version must be a semantic version"))
+		})
+	})
+})
+
+var _ = Describe("Metadata", func() {
+	It("is read with the OSB catalog's keys and rendered for brokerapi", func() {
+		var service Service
+		err := yaml.Unmarshal([]byte(`
+id: Service-1
+metadata:
+  displayName: Amazon S3
+  imageUrl: https://example.com/s3.png
+  longDescription: Object storage
+  documentationUrl: https://example.com/docs
+  supportUrl: https://example.com/support
+plans:
+- id: Plan-1
+  metadata:
+    displayName: Basic
+    bullets:
+    - Single bucket
+    costs:
+    - amount:
+        usd: 0.03
+      unit: Per GB
+`), &service)
+		Expect(err).ToNot(HaveOccurred())
+
+		serviceMetadata, err := json.Marshal(service.Metadata)
+		Expect(err).ToNot(HaveOccurred())
+		var apiServiceMetadata brokerapi.ServiceMetadata
+		Expect(json.Unmarshal(serviceMetadata, &apiServiceMetadata)).To(Succeed())
+		Expect(apiServiceMetadata).To(Equal(brokerapi.ServiceMetadata{
+			DisplayName:      "Amazon S3",
+			ImageUrl:         "https://example.com/s3.png",
+			LongDescription:  "Object storage",
+			DocumentationUrl: "https://example.com/docs",
+			SupportUrl:       "https://example.com/support",
+		}))
+
+		planMetadata, err := json.Marshal(service.Plans[0].Metadata)
+		Expect(err).ToNot(HaveOccurred())
+		var apiPlanMetadata brokerapi.ServicePlanMetadata
+		Expect(json.Unmarshal(planMetadata, &apiPlanMetadata)).To(Succeed())
+		Expect(apiPlanMetadata).To(Equal(brokerapi.ServicePlanMetadata{
+			DisplayName: "Basic",
+			Bullets:     []string{"Single bucket"},
+			Costs:       []brokerapi.ServicePlanCost{{Amount: map[string]float64{"usd": 0.03}, Unit: "Per GB"}},
+		}))
 	})
 })
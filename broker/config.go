@@ -1,30 +1,337 @@
 package broker
 
 import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cloud-gov/s3-broker/awspartition"
+	"github.com/cloud-gov/s3-broker/awsretry"
+	"github.com/cloud-gov/s3-broker/awssts"
+	"github.com/cloud-gov/s3-broker/credhub"
 )
 
 type Config struct {
-	Region                       string        `yaml:"region"`
-	Endpoint                     string        `yaml:"endpoint"`
-	InsecureSkipVerify           bool          `yaml:"insecure_skip_verify"`
-	Provider                     string        `yaml:"provider"`
-	IamPath                      string        `yaml:"iam_path"`
-	UserPrefix                   string        `yaml:"user_prefix"`
-	PolicyPrefix                 string        `yaml:"policy_prefix"`
-	BucketPrefix                 string        `yaml:"bucket_prefix"`
-	AwsPartition                 string        `yaml:"aws_partition"`
-	AllowUserProvisionParameters bool          `yaml:"allow_user_provision_parameters"`
-	AllowUserUpdateParameters    bool          `yaml:"allow_user_update_parameters"`
-	Catalog                      BrokerCatalog `yaml:"catalog"`
+	Region                       string                      `yaml:"region"`
+	Regions                      []string                    `yaml:"regions"`
+	Preset                       string                      `yaml:"preset"`
+	Endpoint                     string                      `yaml:"endpoint"`
+	InsecureSkipVerify           bool                        `yaml:"insecure_skip_verify"`
+	PathStyle                    bool                        `yaml:"path_style"`
+	UseDualStackEndpoints        bool                        `yaml:"use_dualstack_endpoints"`
+	UseFIPSEndpoints             bool                        `yaml:"use_fips_endpoints"`
+	Signature                    SignatureConfig             `yaml:"signature"`
+	HTTP                         HTTPConfig                  `yaml:"http"`
+	Provider                     string                      `yaml:"provider"`
+	IamPath                      string                      `yaml:"iam_path"`
+	PermissionsBoundary          string                      `yaml:"permissions_boundary"`
+	UserPrefix                   string                      `yaml:"user_prefix"`
+	PolicyPrefix                 string                      `yaml:"policy_prefix"`
+	UserNameTemplate             string                      `yaml:"user_name_template"`
+	PolicyNameTemplate           string                      `yaml:"policy_name_template"`
+	BucketPrefix                 string                      `yaml:"bucket_prefix"`
+	AwsPartition                 string                      `yaml:"aws_partition"`
+	AllowUserProvisionParameters bool                        `yaml:"allow_user_provision_parameters"`
+	AllowUserUpdateParameters    bool                        `yaml:"allow_user_update_parameters"`
+	RetainFailedBuckets          bool                        `yaml:"retain_failed_buckets"`
+	Retry                        awsretry.Policy             `yaml:"retry"`
+	AssumeRole                   AssumeRoleConfig            `yaml:"assume_role"`
+	Accounts                     map[string]AccountConfig    `yaml:"accounts"`
+	ObjectStores                 ObjectStoresConfig          `yaml:"object_stores"`
+	TemporaryCredentials         TemporaryCredentialsConfig  `yaml:"temporary_credentials"`
+	AllowRoleBindings            bool                        `yaml:"allow_role_bindings"`
+	AllowBucketPolicyBindings    bool                        `yaml:"allow_bucket_policy_bindings"`
+	RestrictSharedBindings       bool                        `yaml:"restrict_shared_bindings"`
+	PresignedURLs                PresignedURLsConfig         `yaml:"presigned_urls"`
+	CredHub                      credhub.Config              `yaml:"credhub"`
+	SecretsManager               SecretsManagerConfig        `yaml:"secrets_manager"`
+	CredentialFormats            map[string]CredentialFormat `yaml:"credential_formats"`
+	UseInstanceGroups            bool                        `yaml:"use_instance_groups"`
+	KeyRotation                  KeyRotationConfig           `yaml:"key_rotation"`
+	StaleAccessKeys              StaleAccessKeysConfig       `yaml:"stale_access_keys"`
+	Events                       EventsConfig                `yaml:"events"`
+	AuditLog                     AuditLogConfig              `yaml:"audit_log"`
+	Metrics                      MetricsConfig               `yaml:"metrics"`
+	Reconcile                    ReconcileConfig             `yaml:"reconcile"`
+	GarbageCollection            GarbageCollectionConfig     `yaml:"garbage_collection"`
+	Usage                        UsageConfig                 `yaml:"usage"`
+	Alerts                       AlertsConfig                `yaml:"alerts"`
+	LeaderElection               LeaderElectionConfig        `yaml:"leader_election"`
+	BindingRetrieval             BindingRetrievalConfig      `yaml:"binding_retrieval"`
+	Dashboard                    DashboardConfig             `yaml:"dashboard"`
+	Quotas                       QuotasConfig                `yaml:"quotas"`
+	OperatorPolicy               OperatorPolicy              `yaml:"operator_policy"`
+	Provisioning                 ProvisioningConfig          `yaml:"provisioning"`
+	MinAPIVersion                string                      `yaml:"min_api_version"`
+	Catalog                      BrokerCatalog               `yaml:"catalog"`
+}
+
+const (
+	ChecksumsWhenSupported = "when_supported"
+	ChecksumsWhenRequired  = "when_required"
+)
+
+// SignatureConfig adjusts how S3 requests are signed, for S3-compatible
+// stores that do not support everything AWS does.
+type SignatureConfig struct {
+	// UnsignedPayload signs requests without hashing their bodies.
+	UnsignedPayload bool `yaml:"unsigned_payload"`
+	// Checksums is when_supported (the default), which sends and validates
+	// checksums on every request that can have them, or when_required,
+	// which sends them only on requests that need them.
+	Checksums string `yaml:"checksums"`
+}
+
+// HTTPConfig configures the HTTP client of the broker's AWS calls.
+type HTTPConfig struct {
+	// Proxy is the URL of a proxy that AWS calls go through. Defaults to the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+	Proxy string `yaml:"proxy"`
+	// CACert is a PEM bundle trusted in addition to the system roots, such
+	// as the certificate of a TLS-inspecting proxy.
+	CACert string `yaml:"ca_cert"`
+
+	// Timeout limits each attempt of an AWS call, including reading its
+	// response. The retry policy decides whether timed out calls are tried
+	// again.
+	Timeout time.Duration `yaml:"timeout"`
+	// DialTimeout, TLSHandshakeTimeout and ResponseHeaderTimeout limit the
+	// stages of a request, so that a connection to an unreachable endpoint
+	// fails quickly.
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	// IdleConnTimeout, MaxIdleConns and MaxIdleConnsPerHost control the
+	// pool of connections kept open between calls.
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+}
+
+const DefaultRoleSessionName = "s3-broker"
+
+var (
+	roleARNPattern         = regexp.MustCompile(`^arn:[\w-]+:iam::\d{12}:role/.+$`)
+	roleSessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+)
+
+// RoleConfig is a role that the broker assumes.
+type RoleConfig struct {
+	RoleARN string `yaml:"role_arn"`
+	// ExternalID is sent with AssumeRole, for roles whose trust policy
+	// requires one.
+	ExternalID string `yaml:"external_id"`
+	// SessionName identifies the broker's sessions in CloudTrail. Defaults
+	// to s3-broker.
+	SessionName string `yaml:"session_name"`
+}
+
+func (c RoleConfig) Validate() error {
+	if !roleARNPattern.MatchString(c.RoleARN) {
+		return fmt.Errorf("RoleARN must be an IAM role ARN, got %q", c.RoleARN)
+	}
+	if c.SessionName != "" && !roleSessionNamePattern.MatchString(c.SessionName) {
+		return fmt.Errorf("SessionName must be 2 to 64 letters, digits or +=,.@_- characters, got %q", c.SessionName)
+	}
+	return nil
+}
+
+// AssumeRoleConfig has the broker use a role's credentials instead of those
+// it finds in its environment. The credentials are refreshed before they
+// expire.
+type AssumeRoleConfig struct {
+	RoleConfig `yaml:",inline"`
+	// Region is the region of the STS endpoint. Defaults to the broker's
+	// region.
+	Region string `yaml:"region"`
+	// Duration is the lifetime of each session, between 15 minutes and 12
+	// hours. Defaults to 15 minutes. Sessions of chained roles last at most
+	// an hour.
+	Duration time.Duration `yaml:"duration"`
+	// Chain lists roles that are assumed, in order, before RoleARN, each
+	// with the credentials of the one before it.
+	Chain []RoleConfig `yaml:"chain"`
+}
+
+// Enabled reports whether the broker assumes a role.
+func (c AssumeRoleConfig) Enabled() bool {
+	return c.RoleARN != ""
+}
+
+// Roles returns the roles to assume, in order.
+func (c AssumeRoleConfig) Roles() []RoleConfig {
+	return append(slices.Clone(c.Chain), c.RoleConfig)
+}
+
+func (c AssumeRoleConfig) Validate() error {
+	if !c.Enabled() {
+		if len(c.Chain) > 0 {
+			return errors.New("Must provide a non-empty RoleARN to assume after the Chain")
+		}
+		return nil
+	}
+	for _, role := range c.Roles() {
+		if err := role.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Duration != 0 && (c.Duration < 15*time.Minute || c.Duration > 12*time.Hour) {
+		return errors.New("Duration must be between 15m and 12h")
+	}
+	return nil
+}
+
+type TemporaryCredentialsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Method is "federation-token" (the default) or "assume-role".
+	Method  string `yaml:"method"`
+	RoleARN string `yaml:"role_arn"`
+	// TTL is the lifetime of issued credentials. Defaults to one hour.
+	TTL time.Duration `yaml:"ttl"`
+	// RefreshURL is the broker's base URL as reachable from apps. When set,
+	// temporary bindings include a refresh URL and token.
+	RefreshURL string `yaml:"refresh_url"`
+	// SessionTags tags issued sessions with the binding's organization,
+	// space, instance, binding and app GUIDs. The broker's user, or the
+	// role's trust policy, must allow sts:TagSession.
+	SessionTags bool `yaml:"session_tags"`
+}
+
+type PresignedURLsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// URL is the broker's base URL as reachable from apps, which request
+	// presigned URLs from it.
+	URL string `yaml:"url"`
+	// MaxTTL is the longest a presigned URL may be valid. Defaults to one
+	// hour.
+	MaxTTL time.Duration `yaml:"max_ttl"`
+}
+
+type SecretsManagerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// NamePrefix is prepended to every secret name, so the broker's IAM
+	// policy can be limited to its own secrets.
+	NamePrefix string `yaml:"name_prefix"`
+}
+
+type KeyRotationConfig struct {
+	// GracePeriod is how long the previous access key of a binding stays
+	// valid after rotation. Defaults to 24 hours.
+	GracePeriod time.Duration `yaml:"grace_period"`
+}
+
+type StaleAccessKeysConfig struct {
+	// MaxAge is the age after which binding access keys are reported as
+	// stale. Stale key checks are disabled when it is zero.
+	MaxAge time.Duration `yaml:"max_age"`
+	// CheckInterval defaults to 24 hours.
+	CheckInterval time.Duration `yaml:"check_interval"`
+	// Deactivate stale keys instead of only logging them.
+	Deactivate bool `yaml:"deactivate"`
+}
+
+type ReconcileConfig struct {
+	// OnStartup compares the state store with AWS when the broker starts.
+	OnStartup bool `yaml:"on_startup"`
+	// RepairTags puts back broker tags missing from instances' buckets.
+	RepairTags bool `yaml:"repair_tags"`
+}
+
+// Provisioning engines.
+const (
+	// ProvisioningDirect creates and configures buckets with S3 API calls.
+	ProvisioningDirect = "direct"
+	// ProvisioningCloudFormation creates and configures buckets through a
+	// CloudFormation stack for each.
+	ProvisioningCloudFormation = "cloudformation"
+)
+
+var stackPrefixPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`)
+
+type ProvisioningConfig struct {
+	// Engine is direct (the default) or cloudformation.
+	Engine string `yaml:"engine"`
+	// StackPrefix starts the names of buckets' stacks. Defaults to
+	// s3-broker.
+	StackPrefix string `yaml:"stack_prefix"`
+	// Timeout is how long to wait for a stack to change. Defaults to 10
+	// minutes.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c ProvisioningConfig) Validate() error {
+	switch c.Engine {
+	case "", ProvisioningDirect, ProvisioningCloudFormation:
+	default:
+		return fmt.Errorf("Engine must be %q or %q, got %q", ProvisioningDirect, ProvisioningCloudFormation, c.Engine)
+	}
+	if c.StackPrefix != "" && !stackPrefixPattern.MatchString(c.StackPrefix) {
+		return fmt.Errorf("StackPrefix must start with a letter and have only letters, digits and hyphens, got %q", c.StackPrefix)
+	}
+	if c.Timeout < 0 {
+		return errors.New("Timeout must not be negative")
+	}
+	return nil
+}
+
+type GarbageCollectionConfig struct {
+	// Interval is how often to look for orphaned buckets and users. Garbage
+	// collection is disabled when it is zero.
+	Interval time.Duration `yaml:"interval"`
+	// Policy is report, delete-empty or delete. Defaults to report.
+	Policy string `yaml:"policy"`
+	// MinAge is how old an orphaned resource must be before it is deleted,
+	// so that provisions and binds in progress are left alone. Defaults to
+	// 24 hours.
+	MinAge time.Duration `yaml:"min_age"`
 }
 
+type LeaderElectionConfig struct {
+	// Enabled runs background jobs only on the broker process that holds the
+	// leader's lease in the state store.
+	Enabled bool `yaml:"enabled"`
+	// LeaseDuration is how long a leader that stopped without releasing the
+	// lease keeps it. Defaults to 30 seconds.
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+}
+
+type BindingRetrievalConfig struct {
+	// Enabled lets platforms fetch bindings after they are created.
+	Enabled bool `yaml:"enabled"`
+	// Redact replaces secrets in fetched bindings with a placeholder, for
+	// platforms whose policy forbids handing out credentials a second time.
+	Redact bool `yaml:"redact"`
+}
+
+type DashboardConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// URL is the broker's base URL as reachable from users' browsers.
+	URL string `yaml:"url"`
+	// Secret signs the token in each instance's dashboard URL. Changing it
+	// invalidates the URLs of existing instances until they are updated.
+	Secret string `yaml:"secret"`
+}
+
+var policyARNPattern = regexp.MustCompile(`^arn:[\w-]+:iam::(\d{12}|aws):policy/.+$`)
+
 func (c Config) Validate() error {
 	if c.Region == "" {
 		return errors.New("Must provide a non-empty Region")
 	}
 
+	if slices.Contains(c.Regions, "") {
+		return errors.New("Regions must not contain an empty region")
+	}
+
 	if c.UserPrefix == "" {
 		return errors.New("Must provide a non-empty UserPrefix")
 	}
@@ -41,9 +348,426 @@ func (c Config) Validate() error {
 		return errors.New("Must provide a non-empty AwsPartition")
 	}
 
+	if err := c.validatePartition(); err != nil {
+		return err
+	}
+
+	if err := validatePathTemplate(c.IamPath); err != nil {
+		return fmt.Errorf("IamPath %s", err)
+	}
+
+	if err := validateNameTemplate(c.UserNameTemplate); err != nil {
+		return fmt.Errorf("UserNameTemplate %s", err)
+	}
+
+	if err := validateNameTemplate(c.PolicyNameTemplate); err != nil {
+		return fmt.Errorf("PolicyNameTemplate %s", err)
+	}
+
+	if c.PermissionsBoundary != "" && !policyARNPattern.MatchString(c.PermissionsBoundary) {
+		return fmt.Errorf("PermissionsBoundary must be an IAM policy ARN, got %q", c.PermissionsBoundary)
+	}
+
+	if _, err := c.EndpointURL(); err != nil {
+		return fmt.Errorf("Endpoint %s", err)
+	}
+
+	if err := c.validatePreset(); err != nil {
+		return err
+	}
+
+	if err := c.AssumeRole.Validate(); err != nil {
+		return fmt.Errorf("Validating Assume Role configuration: %s", err)
+	}
+
+	if err := c.Signature.Validate(); err != nil {
+		return fmt.Errorf("Validating Signature configuration: %s", err)
+	}
+
+	if err := c.HTTP.Validate(); err != nil {
+		return fmt.Errorf("Validating HTTP configuration: %s", err)
+	}
+
+	if c.MinAPIVersion != "" {
+		version, err := ParseAPIVersion(c.MinAPIVersion)
+		if err != nil {
+			return fmt.Errorf("MinAPIVersion %s", err)
+		}
+		if version.Major != 2 {
+			return fmt.Errorf("MinAPIVersion must be a 2.x version, got %s", version)
+		}
+	}
+
+	if err := c.TemporaryCredentials.Validate(); err != nil {
+		return fmt.Errorf("Validating Temporary Credentials configuration: %s", err)
+	}
+
+	if err := c.PresignedURLs.Validate(); err != nil {
+		return fmt.Errorf("Validating Presigned URLs configuration: %s", err)
+	}
+
+	if err := c.Dashboard.Validate(); err != nil {
+		return fmt.Errorf("Validating Dashboard configuration: %s", err)
+	}
+
+	if err := c.Quotas.Validate(); err != nil {
+		return fmt.Errorf("Validating Quotas configuration: %s", err)
+	}
+
+	if err := c.OperatorPolicy.Validate(); err != nil {
+		return fmt.Errorf("Validating Operator Policy configuration: %s", err)
+	}
+
+	if err := c.Provisioning.Validate(); err != nil {
+		return fmt.Errorf("Validating Provisioning configuration: %s", err)
+	}
+	if c.Provisioning.Engine == ProvisioningCloudFormation && c.Endpoint != "" {
+		return errors.New("Provisioning engine cloudformation cannot be used with an Endpoint")
+	}
+
+	if err := c.Retry.RateLimit.Validate(); err != nil {
+		return fmt.Errorf("Validating Retry configuration: %s", err)
+	}
+
+	if err := c.Events.Validate(); err != nil {
+		return fmt.Errorf("Validating Events configuration: %s", err)
+	}
+
+	if err := c.AuditLog.Validate(); err != nil {
+		return fmt.Errorf("Validating Audit Log configuration: %s", err)
+	}
+
+	if err := c.Metrics.Validate(); err != nil {
+		return fmt.Errorf("Validating Metrics configuration: %s", err)
+	}
+
+	if err := c.GarbageCollection.Validate(); err != nil {
+		return fmt.Errorf("Validating Garbage Collection configuration: %s", err)
+	}
+
+	if err := c.Usage.Validate(); err != nil {
+		return fmt.Errorf("Validating Usage configuration: %s", err)
+	}
+	if c.Usage.FromCloudWatch() && c.Endpoint != "" {
+		return errors.New("Usage source cloudwatch cannot be used with an Endpoint")
+	}
+	if c.Usage.FromCloudWatch() && len(c.Regions) > 0 {
+		return errors.New("Usage source cloudwatch cannot be used with Regions, whose buckets report to their own region")
+	}
+	for _, plan := range c.Catalog.ListServicePlans() {
+		if plan.S3Properties.StorageQuotaGB > 0 && !c.Usage.Enabled() {
+			return fmt.Errorf("Plan %s sets a storage quota, which needs a usage interval to measure instances against it", plan.Name)
+		}
+	}
+
+	if err := c.Alerts.Validate(); err != nil {
+		return fmt.Errorf("Validating Alerts configuration: %s", err)
+	}
+	if c.Alerts.Enabled() && c.Endpoint != "" {
+		return errors.New("Alerts cannot be used with an Endpoint")
+	}
+	if c.Alerts.Enabled() && len(c.Regions) > 0 {
+		return errors.New("Alerts cannot be used with Regions, whose buckets report to their own region")
+	}
+
+	if err := c.LeaderElection.Validate(); err != nil {
+		return fmt.Errorf("Validating Leader Election configuration: %s", err)
+	}
+
+	if err := c.SecretsManager.Validate(); err != nil {
+		return fmt.Errorf("Validating Secrets Manager configuration: %s", err)
+	}
+
+	if err := c.CredHub.Validate(); err != nil {
+		return fmt.Errorf("Validating CredHub configuration: %s", err)
+	}
+
 	if err := c.Catalog.Validate(); err != nil {
 		return fmt.Errorf("Validating Catalog configuration: %s", err)
 	}
 
+	for name, account := range c.Accounts {
+		if name == "" {
+			return errors.New("Accounts must have non-empty names")
+		}
+		if err := account.Validate(); err != nil {
+			return fmt.Errorf("Validating account %q: %s", name, err)
+		}
+	}
+	for _, plan := range c.Catalog.ListServicePlans() {
+		if name := plan.S3Properties.Account; name != "" {
+			if _, ok := c.Accounts[name]; !ok {
+				return fmt.Errorf("Plan %s has unknown account %q", plan.Name, name)
+			}
+		}
+	}
+
+	if err := c.ObjectStores.Validate(); err != nil {
+		return fmt.Errorf("Validating Object Stores configuration: %s", err)
+	}
+	for _, plan := range c.Catalog.ListServicePlans() {
+		if name := plan.S3Properties.ObjectStore; name != "" && !c.ObjectStores.Enabled(name) {
+			return fmt.Errorf("Plan %s has object store %q, which is not configured", plan.Name, name)
+		}
+	}
+
+	for name, format := range c.CredentialFormats {
+		if name == CredentialFormatCloudFoundry {
+			return fmt.Errorf("Credential format %q cannot be redefined", name)
+		}
+		if err := format.Validate(); err != nil {
+			return fmt.Errorf("Validating credential format %q: %s", name, err)
+		}
+	}
+	for _, plan := range c.Catalog.ListServicePlans() {
+		name := plan.S3Properties.CredentialFormat
+		if _, ok := c.CredentialFormats[name]; ok || name == "" || name == CredentialFormatCloudFoundry {
+			continue
+		}
+		if _, ok := builtinCredentialFormats[name]; !ok {
+			return fmt.Errorf("Plan %s has unknown credential format %q", plan.Name, name)
+		}
+	}
+
+	return nil
+}
+
+// EndpointURL parses Endpoint, which is https unless it says otherwise. It
+// returns nil if Endpoint is empty.
+func (c Config) EndpointURL() (*url.URL, error) {
+	if c.Endpoint == "" {
+		return nil, nil
+	}
+
+	endpoint := c.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("must be an http or https URL, got %q", c.Endpoint)
+	}
+	return u, nil
+}
+
+func (c SignatureConfig) Validate() error {
+	switch c.Checksums {
+	case "", ChecksumsWhenSupported, ChecksumsWhenRequired:
+	default:
+		return fmt.Errorf("Checksums must be %q or %q", ChecksumsWhenSupported, ChecksumsWhenRequired)
+	}
+
+	return nil
+}
+
+func (c HTTPConfig) Validate() error {
+	if _, err := c.proxyURL(); err != nil {
+		return err
+	}
+	if _, err := c.certPool(); err != nil {
+		return err
+	}
+	timeouts := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"Timeout", c.Timeout},
+		{"DialTimeout", c.DialTimeout},
+		{"TLSHandshakeTimeout", c.TLSHandshakeTimeout},
+		{"ResponseHeaderTimeout", c.ResponseHeaderTimeout},
+		{"IdleConnTimeout", c.IdleConnTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.timeout < 0 {
+			return fmt.Errorf("%s must not be negative", timeout.name)
+		}
+	}
+	if c.MaxIdleConns < 0 || c.MaxIdleConnsPerHost < 0 {
+		return errors.New("MaxIdleConns and MaxIdleConnsPerHost must not be negative")
+	}
+	return nil
+}
+
+// tuned reports whether any timeout or connection pool setting is set.
+func (c HTTPConfig) tuned() bool {
+	return c.Timeout != 0 || c.DialTimeout != 0 || c.TLSHandshakeTimeout != 0 || c.ResponseHeaderTimeout != 0 ||
+		c.IdleConnTimeout != 0 || c.MaxIdleConns != 0 || c.MaxIdleConnsPerHost != 0
+}
+
+// proxyURL parses Proxy. It returns nil if Proxy is empty.
+func (c HTTPConfig) proxyURL() (*url.URL, error) {
+	if c.Proxy == "" {
+		return nil, nil
+	}
+	proxy, err := url.Parse(c.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("Proxy %s", err)
+	}
+	if proxy.Scheme != "http" && proxy.Scheme != "https" || proxy.Host == "" {
+		return nil, fmt.Errorf("Proxy must be an http or https URL, got %q", c.Proxy)
+	}
+	return proxy, nil
+}
+
+// certPool returns the system roots with CACert added. It returns nil if
+// CACert is empty.
+func (c HTTPConfig) certPool() (*x509.CertPool, error) {
+	if c.CACert == "" {
+		return nil, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(c.CACert)) {
+		return nil, errors.New("CACert contains no PEM certificates")
+	}
+	return pool, nil
+}
+
+// HTTPClient returns the HTTP client for the broker's AWS calls, or nil if
+// the AWS SDKs' own clients will do.
+func (c Config) HTTPClient() (*http.Client, error) {
+	proxy, err := c.HTTP.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := c.HTTP.certPool()
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil && pool == nil && !c.InsecureSkipVerify && !c.HTTP.tuned() {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:            pool,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.HTTP.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: c.HTTP.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
+	transport.TLSHandshakeTimeout = cmp.Or(c.HTTP.TLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+	transport.ResponseHeaderTimeout = cmp.Or(c.HTTP.ResponseHeaderTimeout, transport.ResponseHeaderTimeout)
+	transport.IdleConnTimeout = cmp.Or(c.HTTP.IdleConnTimeout, transport.IdleConnTimeout)
+	transport.MaxIdleConns = cmp.Or(c.HTTP.MaxIdleConns, transport.MaxIdleConns)
+	transport.MaxIdleConnsPerHost = cmp.Or(c.HTTP.MaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	return &http.Client{Transport: transport, Timeout: c.HTTP.Timeout}, nil
+}
+
+func (c TemporaryCredentialsConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	switch c.Method {
+	case "", awssts.MethodFederationToken:
+	case awssts.MethodAssumeRole:
+		if c.RoleARN == "" {
+			return errors.New("Must provide a non-empty RoleARN for the assume-role method")
+		}
+	default:
+		return fmt.Errorf("Method must be %q or %q", awssts.MethodFederationToken, awssts.MethodAssumeRole)
+	}
+
+	if c.TTL != 0 && (c.TTL < 15*time.Minute || c.TTL > 36*time.Hour) {
+		return errors.New("TTL must be between 15m and 36h")
+	}
+
+	return nil
+}
+
+func (c PresignedURLsConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.URL == "" {
+		return errors.New("Must provide a non-empty URL")
+	}
+
+	if c.MaxTTL < 0 || c.MaxTTL > maxPresignedURLTTL {
+		return errors.New("MaxTTL must be at most 168h")
+	}
+
+	return nil
+}
+
+func (c DashboardConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.URL == "" {
+		return errors.New("Must provide a non-empty URL")
+	}
+
+	if len(c.Secret) < minDashboardSecretLength {
+		return fmt.Errorf("Secret must be at least %d characters long", minDashboardSecretLength)
+	}
+
+	return nil
+}
+
+func (c SecretsManagerConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if !secretNamePattern.MatchString(c.NamePrefix) {
+		return fmt.Errorf("NamePrefix must be a non-empty secret name, got %q", c.NamePrefix)
+	}
+
+	return nil
+}
+
+// validatePartition checks that AwsPartition is a known partition, that
+// the regions the broker uses are in it, and that it has the endpoints that
+// the broker and its plans ask for. S3-compatible stores name regions
+// of their own, so their regions are not checked.
+func (c Config) validatePartition() error {
+	partition, ok := awspartition.Lookup(c.AwsPartition)
+	if !ok {
+		return fmt.Errorf("Unknown AwsPartition %s", c.AwsPartition)
+	}
+	regions := []string{c.AssumeRole.Region}
+	if c.Endpoint == "" {
+		regions = append(regions, c.Region)
+		regions = append(regions, c.Regions...)
+	}
+	for _, region := range regions {
+		if region != "" && !partition.HasRegion(region) {
+			return fmt.Errorf("Region %s is not in partition %s", region, c.AwsPartition)
+		}
+	}
+
+	dualStack := c.UseDualStackEndpoints
+	for _, plan := range c.Catalog.ListServicePlans() {
+		dualStack = dualStack || plan.S3Properties.DualStack
+	}
+	if dualStack && c.Endpoint != "" {
+		return errors.New("Dual-stack endpoints cannot be used with an S3-compatible store")
+	}
+	if dualStack && !partition.HasDualStack() {
+		return fmt.Errorf("Partition %s has no dual-stack endpoints", c.AwsPartition)
+	}
+
+	if c.UseFIPSEndpoints {
+		if c.Endpoint != "" {
+			return errors.New("FIPS endpoints cannot be used with an S3-compatible store")
+		}
+		for _, region := range append([]string{c.Region, c.AssumeRole.Region}, c.Regions...) {
+			if region != "" && !partition.HasFIPS(region) {
+				return fmt.Errorf("Region %s has no FIPS endpoints", region)
+			}
+		}
+	}
 	return nil
 }
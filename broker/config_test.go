@@ -1,9 +1,17 @@
 package broker_test
 
 import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/cloud-gov/s3-broker/awsretry"
+	"github.com/cloud-gov/s3-broker/azureblob"
 	. "github.com/cloud-gov/s3-broker/broker"
 )
 
@@ -12,11 +20,11 @@ var _ = Describe("Config", func() {
 		config Config
 
 		validConfig = Config{
-			Region:       "s3-region",
+			Region:       "us-east-1",
 			UserPrefix:   "cf",
 			PolicyPrefix: "cf",
 			BucketPrefix: "cf",
-			AwsPartition: "gov",
+			AwsPartition: "aws",
 			Catalog: BrokerCatalog{
 				[]Service{
 					Service{
@@ -47,6 +55,87 @@ var _ = Describe("Config", func() {
 			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty Region"))
 		})
 
+		It("returns error if Regions contains an empty region", func() {
+			config.Regions = []string{"us-gov-east-1", ""}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Regions must not contain an empty region"))
+		})
+
+		It("returns error if AwsPartition is not a known partition", func() {
+			config.AwsPartition = "gov"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Unknown AwsPartition gov"))
+		})
+
+		It("returns error if a region is not in the partition", func() {
+			config.AwsPartition = "aws-us-gov"
+			config.Region = "us-gov-west-1"
+			config.Regions = []string{"us-east-1"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Region us-east-1 is not in partition aws-us-gov"))
+		})
+
+		It("returns error if dual-stack endpoints are used in a partition without them", func() {
+			config.AwsPartition = "aws-iso"
+			config.Region = "us-iso-east-1"
+			config.UseDualStackEndpoints = true
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Partition aws-iso has no dual-stack endpoints"))
+		})
+
+		It("returns error if dual-stack endpoints are used with an S3-compatible store", func() {
+			config.Endpoint = "https://minio.example.com:9000"
+			config.UseDualStackEndpoints = true
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Dual-stack endpoints cannot be used with an S3-compatible store"))
+		})
+
+		It("returns error if FIPS endpoints are used in a region without them", func() {
+			config.Regions = []string{"eu-west-1"}
+			config.UseFIPSEndpoints = true
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Region eu-west-1 has no FIPS endpoints"))
+		})
+
+		It("does not return error if FIPS endpoints are used in GovCloud", func() {
+			config.AwsPartition = "aws-us-gov"
+			config.Region = "us-gov-west-1"
+			config.Regions = []string{"us-gov-east-1"}
+			config.UseFIPSEndpoints = true
+
+			err := config.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error if FIPS endpoints are used with an S3-compatible store", func() {
+			config.Endpoint = "https://minio.example.com:9000"
+			config.UseFIPSEndpoints = true
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("FIPS endpoints cannot be used with an S3-compatible store"))
+		})
+
+		It("does not check the regions of an S3-compatible store", func() {
+			config.Endpoint = "https://minio.example.com:9000"
+			config.Region = "minio"
+
+			err := config.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
 		It("returns error if UserPrefix is not valid", func() {
 			config.UserPrefix = ""
 
@@ -82,5 +171,441 @@ var _ = Describe("Config", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("Validating Catalog configuration"))
 		})
+
+		It("returns error if the assume-role method has no RoleARN", func() {
+			config.TemporaryCredentials = TemporaryCredentialsConfig{
+				Enabled: true,
+				Method:  "assume-role",
+			}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty RoleARN"))
+		})
+
+		It("returns error if the temporary credentials TTL is out of range", func() {
+			config.TemporaryCredentials = TemporaryCredentialsConfig{
+				Enabled: true,
+				TTL:     time.Minute,
+			}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("TTL must be between 15m and 36h"))
+		})
+
+		It("returns error if presigned URLs are enabled without a URL", func() {
+			config.PresignedURLs = PresignedURLsConfig{Enabled: true}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty URL"))
+		})
+
+		It("returns error if the presigned URL MaxTTL is too long", func() {
+			config.PresignedURLs = PresignedURLsConfig{
+				Enabled: true,
+				URL:     "https://broker.example.com",
+				MaxTTL:  8 * 24 * time.Hour,
+			}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("MaxTTL must be at most 168h"))
+		})
+
+		It("returns error if the dashboard secret is too short", func() {
+			config.Dashboard = DashboardConfig{
+				Enabled: true,
+				URL:     "https://broker.example.com",
+				Secret:  "secret",
+			}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Secret must be at least 32 characters long"))
+		})
+
+		It("returns error if a quota is negative", func() {
+			config.Quotas = QuotasConfig{MaxInstancesPerOrg: -1}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Quotas configuration"))
+		})
+
+		It("returns error if the operator policy names an unknown access mode", func() {
+			config.OperatorPolicy = OperatorPolicy{Access: PolicyRule{Denied: []string{"public"}}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Operator Policy configuration"))
+		})
+
+		It("returns error if the provisioning engine is unknown", func() {
+			config.Provisioning = ProvisioningConfig{Engine: "terraform"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Provisioning configuration"))
+		})
+
+		It("returns error if the cloudformation engine is used with an endpoint", func() {
+			config.Provisioning = ProvisioningConfig{Engine: ProvisioningCloudFormation}
+			config.Endpoint = "https://minio.example.com"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cannot be used with an Endpoint"))
+		})
+
+		It("returns error if the garbage collection policy is unknown", func() {
+			config.GarbageCollection = GarbageCollectionConfig{Interval: time.Hour, Policy: "purge"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Garbage Collection configuration"))
+		})
+
+		It("returns error if Secrets Manager is enabled without a NamePrefix", func() {
+			config.SecretsManager = SecretsManagerConfig{Enabled: true}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("NamePrefix must be a non-empty secret name"))
+		})
+
+		It("returns error if a credential format template is not valid", func() {
+			config.CredentialFormats = map[string]CredentialFormat{"env": {"BUCKET": "{{.Bucket"}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Validating credential format "env"`))
+		})
+
+		It("returns error if a credential format redefines cloudfoundry", func() {
+			config.CredentialFormats = map[string]CredentialFormat{"cloudfoundry": {"BUCKET": "{{.Bucket}}"}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Credential format "cloudfoundry" cannot be redefined`))
+		})
+
+		It("returns error if UserNameTemplate does not include the binding ID", func() {
+			config.UserNameTemplate = "{{.Prefix}}"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("UserNameTemplate must include {{.BindingID}}"))
+		})
+
+		It("returns error if PermissionsBoundary is not a policy ARN", func() {
+			config.PermissionsBoundary = "arn:aws:iam::123456789012:role/boundary"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("PermissionsBoundary must be an IAM policy ARN"))
+		})
+
+		It("returns error if MinAPIVersion is not a 2.x version", func() {
+			config.MinAPIVersion = "3.0"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("MinAPIVersion must be a 2.x version"))
+		})
+
+		It("returns error if the assumed role is not a role ARN", func() {
+			config.AssumeRole = AssumeRoleConfig{RoleConfig: RoleConfig{RoleARN: "arn:aws:iam::123456789012:user/s3-broker"}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Assume Role configuration: RoleARN must be an IAM role ARN"))
+		})
+
+		It("returns error if a chained role has an invalid session name", func() {
+			config.AssumeRole = AssumeRoleConfig{
+				RoleConfig: RoleConfig{RoleARN: "arn:aws:iam::123456789012:role/s3-broker"},
+				Chain:      []RoleConfig{{RoleARN: "arn:aws:iam::210987654321:role/hub", SessionName: "s3 broker"}},
+			}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("SessionName must be 2 to 64"))
+		})
+
+		It("returns error if a role chain has no final role", func() {
+			config.AssumeRole = AssumeRoleConfig{Chain: []RoleConfig{{RoleARN: "arn:aws:iam::210987654321:role/hub"}}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty RoleARN"))
+		})
+
+		It("returns error if the assumed role session duration is out of range", func() {
+			config.AssumeRole = AssumeRoleConfig{
+				RoleConfig: RoleConfig{RoleARN: "arn:aws:iam::123456789012:role/s3-broker"},
+				Duration:   5 * time.Minute,
+			}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Duration must be between 15m and 12h"))
+		})
+
+		It("returns error if an account has no role ARN", func() {
+			config.Accounts = map[string]AccountConfig{"dev": {}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Validating account "dev"`))
+		})
+
+		It("returns error if a plan names an unknown account", func() {
+			config.Catalog = BrokerCatalog{[]Service{{
+				ID:          "service-1",
+				Name:        "Service 1",
+				Description: "Service 1 description",
+				Plans: []ServicePlan{{
+					ID:           "plan-1",
+					Name:         "Plan 1",
+					Description:  "Plan 1 description",
+					S3Properties: S3Properties{IamPolicy: "{}", Account: "dev"},
+				}},
+			}}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Plan Plan 1 has unknown account "dev"`))
+		})
+
+		It("returns error if the object stores configuration is invalid", func() {
+			config.ObjectStores = ObjectStoresConfig{Azure: azureblob.Config{AccountName: "account"}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Object Stores configuration"))
+		})
+
+		It("returns error if a plan names an object store that is not configured", func() {
+			config.Catalog = BrokerCatalog{[]Service{{
+				ID:          "service-1",
+				Name:        "Service 1",
+				Description: "Service 1 description",
+				Plans: []ServicePlan{{
+					ID:           "plan-1",
+					Name:         "Plan 1",
+					Description:  "Plan 1 description",
+					S3Properties: S3Properties{ObjectStore: ObjectStoreGCS},
+				}},
+			}}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Plan Plan 1 has object store "gcs", which is not configured`))
+		})
+
+		It("returns error if the events target is not a topic or event bus", func() {
+			config.Events = EventsConfig{TargetARN: "arn:aws:sqs:us-east-1:123456789012:s3-broker-events"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Events configuration: TargetARN must be an SNS topic or EventBridge event bus ARN"))
+		})
+
+		It("returns error if the audit log has both a file and a log group", func() {
+			config.AuditLog = AuditLogConfig{File: "/var/log/s3-broker/audit.log", LogGroup: "s3-broker-audit"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Audit Log configuration: Must provide only one of File and LogGroup"))
+		})
+
+		It("returns error if the minimum rate limit is more than the rate limit", func() {
+			config.Retry.RateLimit = awsretry.RateLimitConfig{RequestsPerSecond: 5, MinRequestsPerSecond: 10}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Retry configuration: RateLimit MinRequestsPerSecond must not be more than RequestsPerSecond"))
+		})
+
+		It("returns error if metrics are put to an AWS namespace", func() {
+			config.Metrics = MetricsConfig{CloudWatchNamespace: "AWS/S3"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Validating Metrics configuration: CloudWatchNamespace must not begin with AWS/, got "AWS/S3"`))
+		})
+
+		It("returns error if usage is read from CloudWatch for a store at an endpoint", func() {
+			config.Endpoint = "https://storage.example.com"
+			config.Usage = UsageConfig{Interval: time.Hour}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Usage source cloudwatch cannot be used with an Endpoint"))
+		})
+
+		It("returns error if the alerts topic is not an SNS topic", func() {
+			config.Alerts = AlertsConfig{TopicARN: "arn:aws:events:us-east-1:123456789012:event-bus/default"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Alerts configuration: TopicARN must be an SNS topic ARN"))
+		})
+
+		It("returns error if a plan has a storage quota but usage is not measured", func() {
+			config.Catalog = BrokerCatalog{[]Service{{
+				ID:          "service-1",
+				Name:        "Service 1",
+				Description: "Service 1 description",
+				Plans: []ServicePlan{{
+					ID:           "plan-1",
+					Name:         "Plan 1",
+					Description:  "Plan 1 description",
+					S3Properties: S3Properties{IamPolicy: "{}", StorageQuotaGB: 100},
+				}},
+			}}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Plan Plan 1 sets a storage quota, which needs a usage interval"))
+		})
+
+		It("returns error if the preset is unknown", func() {
+			config.Preset = "wasabi"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Preset must be one of backblaze-b2, digitalocean-spaces, got "wasabi"`))
+		})
+
+		It("returns error if a plan uses a feature that the preset does not support", func() {
+			config.Preset = "backblaze-b2"
+			config.Endpoint = "https://s3.us-west-004.backblazeb2.com"
+			config.Catalog = BrokerCatalog{[]Service{{
+				ID:          "service-1",
+				Name:        "Service 1",
+				Description: "Service 1 description",
+				Plans: []ServicePlan{{
+					ID:           "plan-1",
+					Name:         "Plan 1",
+					Description:  "Plan 1 description",
+					S3Properties: S3Properties{Versioning: true},
+				}},
+			}}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Plan Plan 1 uses versioning, which preset backblaze-b2 does not support"))
+		})
+
+		It("returns error if Endpoint is not an http or https URL", func() {
+			config.Endpoint = "ftp://minio.example.com"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Endpoint must be an http or https URL"))
+		})
+
+		It("returns error if the signature checksums setting is unknown", func() {
+			config.Signature = SignatureConfig{Checksums: "never"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating Signature configuration"))
+		})
+
+		It("returns error if the HTTP proxy is not an http or https URL", func() {
+			config.HTTP = HTTPConfig{Proxy: "socks5://proxy.example.com:1080"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating HTTP configuration: Proxy must be an http or https URL"))
+		})
+
+		It("returns error if the HTTP CA certificate is not PEM", func() {
+			config.HTTP = HTTPConfig{CACert: "not a certificate"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating HTTP configuration: CACert contains no PEM certificates"))
+		})
+
+		It("returns error if an HTTP timeout is negative", func() {
+			config.HTTP = HTTPConfig{ResponseHeaderTimeout: -time.Second}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating HTTP configuration: ResponseHeaderTimeout must not be negative"))
+		})
+	})
+
+	Describe("HTTPClient", func() {
+		BeforeEach(func() {
+			config = validConfig
+		})
+
+		It("leaves the AWS SDKs' clients in place by default", func() {
+			client, err := config.HTTPClient()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(client).To(BeNil())
+		})
+
+		It("sends requests through the proxy", func() {
+			config.HTTP = HTTPConfig{Proxy: "http://proxy.example.com:3128"}
+
+			client, err := config.HTTPClient()
+			Expect(err).ToNot(HaveOccurred())
+			request := httptest.NewRequest(http.MethodGet, "https://s3.us-east-1.amazonaws.com/", nil)
+			proxy, err := client.Transport.(*http.Transport).Proxy(request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(proxy).To(Equal(&url.URL{Scheme: "http", Host: "proxy.example.com:3128"}))
+		})
+
+		It("applies the timeouts and connection pool settings", func() {
+			config.HTTP = HTTPConfig{
+				Timeout:               time.Minute,
+				TLSHandshakeTimeout:   5 * time.Second,
+				ResponseHeaderTimeout: 10 * time.Second,
+				MaxIdleConnsPerHost:   20,
+			}
+
+			client, err := config.HTTPClient()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(client.Timeout).To(Equal(time.Minute))
+			transport := client.Transport.(*http.Transport)
+			Expect(transport.TLSHandshakeTimeout).To(Equal(5 * time.Second))
+			Expect(transport.ResponseHeaderTimeout).To(Equal(10 * time.Second))
+			Expect(transport.MaxIdleConnsPerHost).To(Equal(20))
+			Expect(transport.MaxIdleConns).To(Equal(http.DefaultTransport.(*http.Transport).MaxIdleConns))
+		})
+
+		It("times out requests whose response headers are late", func() {
+			release := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+			defer server.Close()
+			defer close(release)
+			config.HTTP = HTTPConfig{ResponseHeaderTimeout: 50 * time.Millisecond}
+
+			client, err := config.HTTPClient()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = client.Get(server.URL)
+			Expect(err).To(MatchError(ContainSubstring("timeout awaiting response headers")))
+		})
+
+		It("trusts the CA certificate", func() {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer server.Close()
+			config.HTTP = HTTPConfig{
+				CACert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+			}
+
+			client, err := config.HTTPClient()
+			Expect(err).ToNot(HaveOccurred())
+			response, err := client.Get(server.URL)
+			Expect(err).ToNot(HaveOccurred())
+			response.Body.Close()
+		})
 	})
 })
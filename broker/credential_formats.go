@@ -0,0 +1,173 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// CredentialFormatCloudFoundry returns Credentials as they are. It is the
+// default format.
+const CredentialFormatCloudFoundry = "cloudfoundry"
+
+// CredentialFormat renders a binding's Credentials as a flat map of strings.
+// Each value is a text/template executed with the Credentials. Keys whose
+// value renders empty are left out.
+type CredentialFormat map[string]string
+
+var builtinCredentialFormats = map[string]CredentialFormat{
+	// kubernetes suits a Kubernetes Secret whose keys become environment
+	// variables.
+	"kubernetes": {
+		"AWS_ACCESS_KEY_ID":       "{{.AccessKeyID}}",
+		"AWS_SECRET_ACCESS_KEY":   "{{.SecretAccessKey}}",
+		"AWS_SESSION_TOKEN":       "{{.SessionToken}}",
+		"AWS_REGION":              "{{.Region}}",
+		"AWS_ROLE_ARN":            "{{.RoleARN}}",
+		"S3_BUCKET":               "{{.Bucket}}",
+		"S3_ADDITIONAL_BUCKETS":   `{{join .AdditionalBuckets ","}}`,
+		"S3_ENDPOINT":             "{{.Endpoint}}",
+		"S3_BUCKET_URL":           "{{.BucketURL}}",
+		"S3_PATH_PREFIX":          "{{.PathPrefix}}",
+		"S3_URI":                  "{{.URI}}",
+		"AZURE_STORAGE_SAS_TOKEN": "{{.SASToken}}",
+	},
+	// aws-config holds the files of an ~/.aws directory.
+	"aws-config": {
+		"credentials": "[default]\n" +
+			"aws_access_key_id = {{.AccessKeyID}}\n" +
+			"aws_secret_access_key = {{.SecretAccessKey}}\n" +
+			"{{with .SessionToken}}aws_session_token = {{.}}\n{{end}}",
+		"config": "[default]\n" +
+			"region = {{.Region}}\n" +
+			"{{with .Endpoint}}endpoint_url = https://{{.}}\n{{end}}",
+		"bucket": "{{.Bucket}}",
+	},
+	// s3cmd holds an .s3cfg file.
+	"s3cmd": {
+		"s3cfg": "[default]\n" +
+			"access_key = {{.AccessKeyID}}\n" +
+			"secret_key = {{.SecretAccessKey}}\n" +
+			"{{with .SessionToken}}access_token = {{.}}\n{{end}}" +
+			"host_base = {{or .Endpoint .RegionalEndpoint}}\n" +
+			"host_bucket = %(bucket)s.{{or .Endpoint .RegionalEndpoint}}\n" +
+			"bucket_location = {{.Region}}\n",
+		"bucket": "{{.Bucket}}",
+	},
+}
+
+var credentialFormatFuncs = template.FuncMap{"join": strings.Join}
+
+// Validate checks that every value is a valid template.
+func (f CredentialFormat) Validate() error {
+	for key, text := range f {
+		if _, err := template.New(key).Funcs(credentialFormatFuncs).Parse(text); err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// Render executes the format's templates with credentials.
+func (f CredentialFormat) Render(credentials Credentials) (map[string]string, error) {
+	rendered := make(map[string]string, len(f))
+	for key, text := range f {
+		tmpl, err := template.New(key).Funcs(credentialFormatFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, credentials); err != nil {
+			return nil, err
+		}
+		if buf.Len() > 0 {
+			rendered[key] = buf.String()
+		}
+	}
+	return rendered, nil
+}
+
+// findCredentialFormat returns the operator's or built-in format called name.
+// The Cloud Foundry format has no templates, so it is returned as nil.
+func (b *S3Broker) findCredentialFormat(name string) (CredentialFormat, bool) {
+	if name == "" || name == CredentialFormatCloudFoundry {
+		return nil, true
+	}
+	if format, ok := b.credentialFormats[name]; ok {
+		return format, true
+	}
+	format, ok := builtinCredentialFormats[name]
+	return format, ok
+}
+
+// credentialFormatName returns the name of the format a binding asks for, or
+// its plan's.
+func credentialFormatName(servicePlan ServicePlan, bindParameters BindParameters) string {
+	if bindParameters.CredentialFormat != "" {
+		return bindParameters.CredentialFormat
+	}
+	return servicePlan.S3Properties.CredentialFormat
+}
+
+// credentialFormat returns the format a binding asks for, or its plan's.
+func (b *S3Broker) credentialFormat(servicePlan ServicePlan, bindParameters BindParameters) (CredentialFormat, error) {
+	name := credentialFormatName(servicePlan, bindParameters)
+	format, ok := b.findCredentialFormat(name)
+	if !ok {
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("credential_format must be one of %s, got %q", strings.Join(b.credentialFormatNames(), ", "), name),
+			http.StatusBadRequest,
+			"invalid-credential-format",
+		)
+	}
+	return format, nil
+}
+
+func (b *S3Broker) credentialFormatNames() []string {
+	names := []string{CredentialFormatCloudFoundry}
+	for name := range builtinCredentialFormats {
+		names = append(names, name)
+	}
+	for name := range b.credentialFormats {
+		if _, ok := builtinCredentialFormats[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// formatCredentials renders a binding's credentials in the format it asks
+// for. Bind has already checked that the format exists.
+func (b *S3Broker) formatCredentials(details domain.BindDetails, binding domain.Binding) (domain.Binding, error) {
+	credentials, ok := binding.Credentials.(Credentials)
+	if !ok {
+		return binding, nil
+	}
+
+	var bindParameters BindParameters
+	if len(details.RawParameters) > 0 {
+		if err := json.Unmarshal(details.RawParameters, &bindParameters); err != nil {
+			return binding, err
+		}
+	}
+	servicePlan, _ := b.catalog.FindServicePlan(details.PlanID)
+	format, err := b.credentialFormat(servicePlan, bindParameters)
+	if err != nil || format == nil {
+		return binding, err
+	}
+
+	rendered, err := format.Render(credentials)
+	if err != nil {
+		return binding, err
+	}
+	binding.Credentials = rendered
+	return binding, nil
+}
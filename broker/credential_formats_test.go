@@ -0,0 +1,150 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func TestCredentialFormatRender(t *testing.T) {
+	credentials := Credentials{
+		AccessKeyID:       "AKIAEXAMPLE",
+		SecretAccessKey:   "secret",
+		Region:            "us-gov-west-1",
+		Bucket:            "bucket1",
+		AdditionalBuckets: []string{"bucket2", "bucket3"},
+		Endpoint:          "s3.us-gov-west-1.amazonaws.com",
+		URI:               "s3://AKIAEXAMPLE:secret@s3.us-gov-west-1.amazonaws.com/bucket1",
+	}
+	testCases := map[string]struct {
+		format CredentialFormat
+		expect map[string]string
+	}{
+		"kubernetes": {
+			format: builtinCredentialFormats["kubernetes"],
+			expect: map[string]string{
+				"AWS_ACCESS_KEY_ID":     "AKIAEXAMPLE",
+				"AWS_SECRET_ACCESS_KEY": "secret",
+				"AWS_REGION":            "us-gov-west-1",
+				"S3_BUCKET":             "bucket1",
+				"S3_ADDITIONAL_BUCKETS": "bucket2,bucket3",
+				"S3_ENDPOINT":           "s3.us-gov-west-1.amazonaws.com",
+				"S3_URI":                "s3://AKIAEXAMPLE:secret@s3.us-gov-west-1.amazonaws.com/bucket1",
+			},
+		},
+		"aws-config": {
+			format: builtinCredentialFormats["aws-config"],
+			expect: map[string]string{
+				"credentials": "[default]\naws_access_key_id = AKIAEXAMPLE\naws_secret_access_key = secret\n",
+				"config":      "[default]\nregion = us-gov-west-1\nendpoint_url = https://s3.us-gov-west-1.amazonaws.com\n",
+				"bucket":      "bucket1",
+			},
+		},
+		"s3cmd": {
+			format: builtinCredentialFormats["s3cmd"],
+			expect: map[string]string{
+				"s3cfg": "[default]\naccess_key = AKIAEXAMPLE\nsecret_key = secret\n" +
+					"host_base = s3.us-gov-west-1.amazonaws.com\n" +
+					"host_bucket = %(bucket)s.s3.us-gov-west-1.amazonaws.com\n" +
+					"bucket_location = us-gov-west-1\n",
+				"bucket": "bucket1",
+			},
+		},
+		"custom": {
+			format: CredentialFormat{"BUCKET_URL": "https://{{.Bucket}}.{{.Endpoint}}", "TOKEN": "{{.SessionToken}}"},
+			expect: map[string]string{"BUCKET_URL": "https://bucket1.s3.us-gov-west-1.amazonaws.com"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.format.Validate(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			rendered, err := tc.format.Render(credentials)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !maps.Equal(rendered, tc.expect) {
+				t.Errorf("expected %v, got %v", tc.expect, rendered)
+			}
+		})
+	}
+}
+
+func TestBindCredentialFormat(t *testing.T) {
+	testCases := map[string]struct {
+		planFormat string
+		parameters string
+		expectKey  string
+		expectErr  string
+	}{
+		"cloudfoundry by default": {},
+		"plan format": {
+			planFormat: "kubernetes",
+			expectKey:  "AWS_ACCESS_KEY_ID",
+		},
+		"bind parameter overrides plan": {
+			planFormat: "kubernetes",
+			parameters: `{"credential_format": "custom"}`,
+			expectKey:  "KEY",
+		},
+		"bind parameter selects cloudfoundry": {
+			planFormat: "kubernetes",
+			parameters: `{"credential_format": "cloudfoundry"}`,
+		},
+		"unknown format": {
+			parameters: `{"credential_format": "yaml"}`,
+			expectErr:  "Invalid parameters: credential_format: must be one of cloudfoundry, aws-config, custom, kubernetes, s3cmd",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			user := &mockUser{}
+			b := &S3Broker{
+				logger:            lager.NewLogger("broker-unit-test-credential-formats"),
+				bucket:            &mockBucket{},
+				catalog:           &mockCatalog{planName: "plan1", serviceName: "service1", s3Properties: S3Properties{CredentialFormat: tc.planFormat}},
+				tagManager:        &mockTagGenerator{},
+				user:              user,
+				awsPartition:      "aws",
+				credentialFormats: map[string]CredentialFormat{"custom": {"KEY": "{{.AccessKeyID}}"}},
+			}
+
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(tc.parameters),
+			}, false)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected err %q, got %v", tc.expectErr, err)
+				}
+				if len(user.accessKeys["-binding1"]) != 0 {
+					t.Errorf("expected no access key to be created, got %v", user.accessKeys)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if tc.expectKey == "" {
+				if _, ok := binding.Credentials.(Credentials); !ok {
+					t.Errorf("expected Cloud Foundry credentials, got %#v", binding.Credentials)
+				}
+				return
+			}
+			rendered, ok := binding.Credentials.(map[string]string)
+			if !ok {
+				t.Fatalf("expected formatted credentials, got %#v", binding.Credentials)
+			}
+			if rendered[tc.expectKey] != "-binding1-0" {
+				t.Errorf("expected %s to be the access key ID, got %v", tc.expectKey, rendered)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+// credhubRefKey is the credential that Cloud Foundry replaces with the
+// referenced CredHub value when it starts the app.
+const credhubRefKey = "credhub-ref"
+
+// credhubName follows the naming that Cloud Foundry expects of service
+// broker credentials: /c/<broker client>/<service ID>/<binding ID>/credentials.
+func (b *S3Broker) credhubName(serviceID, bindingID string) string {
+	return fmt.Sprintf("/c/%s/%s/%s/credentials", b.credhubClientID, serviceID, bindingID)
+}
+
+// storeCredentials writes an app binding's credentials to CredHub, lets the
+// app read them, and returns a reference in their place. Service keys have no
+// app to interpolate the reference, so they keep their credentials.
+func (b *S3Broker) storeCredentials(
+	ctx context.Context,
+	instanceID, bindingID string,
+	details domain.BindDetails,
+	binding domain.Binding,
+) (domain.Binding, error) {
+	appGUID := bindAppGUID(details)
+	if appGUID == "" {
+		return binding, nil
+	}
+
+	name := b.credhubName(details.ServiceID, bindingID)
+	logData := lager.Data{
+		instanceIDLogKey: instanceID,
+		bindingIDLogKey:  bindingID,
+		"credhub-name":   name,
+	}
+	if err := b.credhub.Put(ctx, name, binding.Credentials); err != nil {
+		b.logger.Error("bind: error storing credentials in credhub", err, logData)
+		return domain.Binding{}, err
+	}
+	if err := b.credhub.AddPermission(ctx, name, "mtls-app:"+appGUID, []string{"read"}); err != nil {
+		b.logger.Error("bind: error granting app access to credhub credentials", err, logData)
+		return domain.Binding{}, err
+	}
+
+	binding.Credentials = map[string]string{credhubRefKey: name}
+	return binding, nil
+}
@@ -0,0 +1,133 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+type mockCredHub struct {
+	credentials map[string]any
+	permissions map[string][]string
+	putErr      error
+}
+
+func (c *mockCredHub) Put(ctx context.Context, name string, value any) error {
+	if c.putErr != nil {
+		return c.putErr
+	}
+	if c.credentials == nil {
+		c.credentials = make(map[string]any)
+	}
+	c.credentials[name] = value
+	return nil
+}
+
+func (c *mockCredHub) Exists(ctx context.Context, name string) (bool, error) {
+	_, ok := c.credentials[name]
+	return ok, nil
+}
+
+func (c *mockCredHub) Delete(ctx context.Context, name string) error {
+	delete(c.credentials, name)
+	return nil
+}
+
+func (c *mockCredHub) AddPermission(ctx context.Context, name, actor string, operations []string) error {
+	if c.permissions == nil {
+		c.permissions = make(map[string][]string)
+	}
+	c.permissions[name] = append(c.permissions[name], actor)
+	return nil
+}
+
+func TestBindCredHub(t *testing.T) {
+	const credhubName = "/c/s3-broker/service1/binding1/credentials"
+	testCases := map[string]struct {
+		credhub     *mockCredHub
+		details     domain.BindDetails
+		expectRef   bool
+		expectActor string
+		expectErr   string
+	}{
+		"app binding": {
+			credhub: &mockCredHub{},
+			details: domain.BindDetails{
+				BindResource: &domain.BindResource{AppGuid: "app1"},
+			},
+			expectRef:   true,
+			expectActor: "mtls-app:app1",
+		},
+		"deprecated app GUID": {
+			credhub:     &mockCredHub{},
+			details:     domain.BindDetails{AppGUID: "app1"},
+			expectRef:   true,
+			expectActor: "mtls-app:app1",
+		},
+		"service key": {
+			credhub: &mockCredHub{},
+			details: domain.BindDetails{},
+		},
+		"credhub error": {
+			credhub: &mockCredHub{putErr: errors.New("credhub unavailable")},
+			details: domain.BindDetails{
+				BindResource: &domain.BindResource{AppGuid: "app1"},
+			},
+			expectErr: "credhub unavailable",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withPresignBindings)
+			b.credhub = tc.credhub
+			b.credhubClientID = "s3-broker"
+
+			details := tc.details
+			details.PlanID = "plan1"
+			details.ServiceID = "service1"
+			details.RawParameters = json.RawMessage(`{"credential_type": "presigned-url"}`)
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", details, false)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected err %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !tc.expectRef {
+				if _, ok := binding.Credentials.(Credentials); !ok {
+					t.Errorf("expected plain credentials, got %+v", binding.Credentials)
+				}
+				if len(tc.credhub.credentials) > 0 {
+					t.Errorf("expected nothing in credhub, got %v", tc.credhub.credentials)
+				}
+				return
+			}
+
+			ref, ok := binding.Credentials.(map[string]string)
+			if !ok || ref[credhubRefKey] != credhubName {
+				t.Fatalf("expected credhub-ref %s, got %+v", credhubName, binding.Credentials)
+			}
+			stored, ok := tc.credhub.credentials[credhubName].(Credentials)
+			if !ok || stored.PresignToken == "" {
+				t.Errorf("expected credentials in credhub, got %+v", tc.credhub.credentials[credhubName])
+			}
+			if actors := tc.credhub.permissions[credhubName]; len(actors) != 1 || actors[0] != tc.expectActor {
+				t.Errorf("expected permission for %s, got %v", tc.expectActor, actors)
+			}
+
+			if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{ServiceID: "service1"}, false); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, ok := tc.credhub.credentials[credhubName]; ok {
+				t.Errorf("expected credentials to be deleted from credhub")
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package broker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+// minDashboardSecretLength keeps dashboard tokens from being guessed.
+const minDashboardSecretLength = 32
+
+var (
+	ErrDashboardDisabled     = errors.New("dashboard disabled")
+	ErrInvalidDashboardToken = errors.New("invalid dashboard token")
+)
+
+// InstanceDashboard is what the dashboard shows about an instance.
+type InstanceDashboard struct {
+	InstanceID string
+	BucketName string
+	Region     string
+	Encryption string
+	Usage      awss3.BucketUsage
+	// MeasuredAt is when Usage was measured, or zero if it was measured for
+	// the dashboard.
+	MeasuredAt time.Time
+	Operations []Operation
+}
+
+// instanceDashboardURL returns the URL of an instance's dashboard, or "" if
+// the dashboard is disabled. The URL carries a token that lets anyone who can
+// see the instance on the platform open its dashboard.
+func (b *S3Broker) instanceDashboardURL(instanceID string) string {
+	if b.dashboardURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/dashboard/instances/%s?token=%s", b.dashboardURL, url.PathEscape(instanceID), b.dashboardToken(instanceID))
+}
+
+// dashboardToken signs instanceID, so that the broker does not need to store
+// a token for each instance.
+func (b *S3Broker) dashboardToken(instanceID string) string {
+	mac := hmac.New(sha256.New, b.dashboardSecret)
+	mac.Write([]byte("dashboard/" + instanceID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DescribeDashboard checks the token from an instance's dashboard URL, then
+// looks up the instance's bucket and its recent operations.
+func (b *S3Broker) DescribeDashboard(ctx context.Context, instanceID, token string) (InstanceDashboard, error) {
+	if b.dashboardURL == "" {
+		return InstanceDashboard{}, ErrDashboardDisabled
+	}
+	if !hmac.Equal([]byte(token), []byte(b.dashboardToken(instanceID))) {
+		return InstanceDashboard{}, ErrInvalidDashboardToken
+	}
+
+	// Dashboard requests do not say which plan the instance has, so look for
+	// an adopted bucket if the instance did not create its own.
+	b = b.forInstance(ctx, instanceID, ServicePlan{})
+	bucketName := b.bucketName(instanceID)
+	details, err := b.bucket.DescribeConfiguration(ctx, bucketName, b.awsPartition)
+	if errors.Is(err, awss3.ErrBucketNotFound) {
+		if bucketName, err = b.bucket.FindAdopted(ctx, instanceID); err != nil {
+			return InstanceDashboard{}, err
+		}
+		details, err = b.bucket.DescribeConfiguration(ctx, bucketName, b.awsPartition)
+	}
+	if err != nil {
+		return InstanceDashboard{}, err
+	}
+
+	usage, measuredAt, err := b.dashboardUsage(ctx, instanceID, bucketName)
+	if err != nil {
+		return InstanceDashboard{}, err
+	}
+
+	var operations []Operation
+	if b.operations != nil {
+		if operations, err = b.operations.ListOperations(instanceID, maxRecentOperations); err != nil {
+			return InstanceDashboard{}, err
+		}
+	}
+
+	return InstanceDashboard{
+		InstanceID: instanceID,
+		BucketName: bucketName,
+		Region:     details.Region,
+		Encryption: encryptionAlgorithm(details.Encryption),
+		Usage:      usage,
+		MeasuredAt: measuredAt,
+		Operations: operations,
+	}, nil
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"objects": func(usage awss3.BucketUsage) string {
+		if usage.Truncated {
+			return "more than " + formatObjectCount(usage.Objects)
+		}
+		return formatObjectCount(usage.Objects)
+	},
+	"size": func(usage awss3.BucketUsage) string {
+		if usage.Truncated {
+			return "more than " + formatBytes(usage.Bytes)
+		}
+		return formatBytes(usage.Bytes)
+	},
+	"time": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>S3 bucket {{.BucketName}}</title>
+<style>body { font-family: sans-serif; margin: 2em; } th { text-align: left; padding-right: 2em; } td { padding-right: 2em; }</style>
+</head>
+<body>
+<h1>S3 bucket {{.BucketName}}</h1>
+<table>
+<tr><th>Instance</th><td>{{.InstanceID}}</td></tr>
+<tr><th>Bucket</th><td>{{.BucketName}}</td></tr>
+<tr><th>Region</th><td>{{.Region}}</td></tr>
+<tr><th>Objects</th><td>{{objects .Usage}}</td></tr>
+<tr><th>Size</th><td>{{size .Usage}}</td></tr>
+{{if not .MeasuredAt.IsZero}}<tr><th>Measured</th><td>{{time .MeasuredAt}}</td></tr>
+{{end}}<tr><th>Encryption</th><td>{{.Encryption}}</td></tr>
+</table>
+<h2>Recent operations</h2>
+{{if .Operations}}<table>
+<tr><th>Time</th><th>Operation</th><th>Binding</th><th>User</th><th>Result</th></tr>
+{{range .Operations}}<tr><td>{{time .Time}}</td><td>{{.Action}}</td><td>{{.BindingID}}</td><td>{{.User}}</td><td>{{if .Error}}failed: {{.Error}}{{else}}succeeded{{end}}</td></tr>
+{{end}}</table>{{else}}<p>No operations recorded.</p>{{end}}
+</body>
+</html>
+`))
+
+// ServeDashboard handles GET /dashboard/instances/{instance_id}, the
+// dashboard_url returned by Provision.
+func (b *S3Broker) ServeDashboard(w http.ResponseWriter, r *http.Request) {
+	instanceID := r.PathValue("instance_id")
+	logger := b.logger.Session("dashboard", lager.Data{instanceIDLogKey: instanceID})
+
+	dashboard, err := b.DescribeDashboard(r.Context(), instanceID, r.URL.Query().Get("token"))
+	switch {
+	case errors.Is(err, ErrInvalidDashboardToken):
+		logger.Info("rejected")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, ErrDashboardDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, awss3.ErrBucketNotFound), errors.Is(err, awss3.ErrAdoptionDisabled):
+		http.Error(w, "instance not found", http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Error("describe", err)
+		http.Error(w, "could not describe instance", http.StatusBadGateway)
+		return
+	}
+
+	// The token is in the URL, so keep it out of caches and Referer headers.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	if err := dashboardTemplate.Execute(w, dashboard); err != nil {
+		logger.Error("render", err)
+	}
+}
+
+// formatBytes abbreviates sizes in binary units, such as 1.5 GiB.
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTP"[exp])
+}
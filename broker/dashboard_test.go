@@ -0,0 +1,187 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/middlewares"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+func withDashboard(b *S3Broker) {
+	b.bucketPrefix = "prefix"
+	b.bucket = &mockBucket{
+		describeDetails: awss3.BucketDetails{
+			Region:     "us-gov-west-1",
+			Encryption: `{"Rules": [{"ApplyServerSideEncryptionByDefault": {"SSEAlgorithm": "aws:kms"}}]}`,
+		},
+		usage: awss3.BucketUsage{Objects: 12, Bytes: 3 * 1024 * 1024},
+	}
+	b.operations = NewMemoryOperationStore()
+	b.dashboardURL = "https://broker.example.com"
+	b.dashboardSecret = []byte("0123456789abcdef0123456789abcdef")
+}
+
+func TestServeDashboard(t *testing.T) {
+	b := newTestBroker(withDashboard)
+	ctx := context.WithValue(context.Background(), middlewares.OriginatingIdentityKey, `cloudfoundry eyJ1c2VyX2lkIjogInVzZXIxIn0=`)
+	spec, err := b.Provision(ctx, "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dashboardURL, err := url.Parse(spec.DashboardURL)
+	if err != nil {
+		t.Fatalf("invalid dashboard URL %q: %s", spec.DashboardURL, err)
+	}
+	if dashboardURL.Host != "broker.example.com" || dashboardURL.Path != "/dashboard/instances/instance1" {
+		t.Fatalf("unexpected dashboard URL %q", spec.DashboardURL)
+	}
+	token := dashboardURL.Query().Get("token")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dashboard/instances/{instance_id}", b.ServeDashboard)
+
+	testCases := map[string]struct {
+		instanceID   string
+		token        string
+		disabled     bool
+		expectStatus int
+		expectBody   []string
+	}{
+		"valid token": {
+			instanceID:   "instance1",
+			token:        token,
+			expectStatus: http.StatusOK,
+			expectBody: []string{
+				"prefix-instance1",
+				"us-gov-west-1",
+				"aws:kms",
+				"<td>12</td>",
+				"3.0 MiB",
+				"<td>provision</td>",
+				"cloudfoundry/user1",
+				"succeeded",
+			},
+		},
+		"missing token": {
+			instanceID:   "instance1",
+			expectStatus: http.StatusUnauthorized,
+		},
+		"token of another instance": {
+			instanceID:   "instance2",
+			token:        token,
+			expectStatus: http.StatusUnauthorized,
+		},
+		"disabled": {
+			instanceID:   "instance1",
+			token:        token,
+			disabled:     true,
+			expectStatus: http.StatusNotFound,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			dashboardURL := b.dashboardURL
+			if tc.disabled {
+				b.dashboardURL = ""
+			}
+			defer func() { b.dashboardURL = dashboardURL }()
+
+			req := httptest.NewRequest(http.MethodGet, "/dashboard/instances/"+tc.instanceID+"?token="+url.QueryEscape(tc.token), nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectStatus, rec.Code, rec.Body.String())
+			}
+			for _, expected := range tc.expectBody {
+				if !strings.Contains(rec.Body.String(), expected) {
+					t.Errorf("expected dashboard to contain %q, got:\n%s", expected, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestDashboardURLDisabled(t *testing.T) {
+	b := newTestBroker(withDashboard)
+	b.dashboardURL = ""
+	spec, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if spec.DashboardURL != "" {
+		t.Errorf("expected no dashboard URL, got %q", spec.DashboardURL)
+	}
+}
+
+func TestDashboardMeasuredUsage(t *testing.T) {
+	b := newTestBroker(withDashboard)
+	b.state = state.NewMemoryStore()
+	measuredAt := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	if err := b.state.SaveUsage(context.Background(), state.Usage{InstanceID: "instance1", Bytes: 5 << 30, Objects: 40_000, MeasuredAt: measuredAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	dashboard, err := b.DescribeDashboard(context.Background(), "instance1", b.dashboardToken("instance1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if dashboard.Usage != (awss3.BucketUsage{Objects: 40_000, Bytes: 5 << 30}) || !dashboard.MeasuredAt.Equal(measuredAt) {
+		t.Errorf("expected the measured usage instead of listing the bucket, got %+v at %s", dashboard.Usage, dashboard.MeasuredAt)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := map[int64]string{
+		0:                      "0 B",
+		1023:                   "1023 B",
+		1536:                   "1.5 KiB",
+		5 * 1024 * 1024 * 1024: "5.0 GiB",
+	}
+	for bytes, expected := range testCases {
+		if formatted := formatBytes(bytes); formatted != expected {
+			t.Errorf("formatBytes(%d): expected %q, got %q", bytes, expected, formatted)
+		}
+	}
+}
+
+func TestRecentOperations(t *testing.T) {
+	b := newTestBroker(withDashboard)
+	for range maxRecentOperations {
+		if _, err := b.Update(context.Background(), "instance1", domain.UpdateDetails{ServiceID: "service1", PlanID: "plan1"}, false); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	_, updateErr := b.Update(context.Background(), "instance1", domain.UpdateDetails{
+		ServiceID:     "service1",
+		PlanID:        "plan1",
+		RawParameters: json.RawMessage(`{"unknown": true}`),
+	}, false)
+	if updateErr == nil {
+		t.Fatal("expected an error")
+	}
+
+	operations, err := b.operations.ListOperations("instance1", maxRecentOperations)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(operations) != maxRecentOperations {
+		t.Fatalf("expected %d operations, got %d", maxRecentOperations, len(operations))
+	}
+	if operations[0].Action != "update" || operations[0].Error != updateErr.Error() {
+		t.Errorf("expected the failed update first, got %+v", operations[0])
+	}
+	if operations[1].Error != "" {
+		t.Errorf("expected a successful update second, got %+v", operations[1])
+	}
+}
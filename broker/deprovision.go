@@ -0,0 +1,209 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+// operationDeprovision is the operation data of asynchronous deprovisions.
+const operationDeprovision = "deprovision"
+
+var ErrDeprovisionNotFound = errors.New("deprovision not found")
+
+// deprovisionStaleAfter is how long a deprovision in progress may go without
+// saving its progress before it is taken to have stopped with the broker that
+// ran it, and is started again.
+const deprovisionStaleAfter = 10 * time.Minute
+
+// Deprovision is the progress of an asynchronous deprovision, which deletes a
+// bucket's objects in the background.
+type Deprovision struct {
+	InstanceID string
+	State      domain.LastOperationState
+	Deleted    int64
+	Total      int64
+	// Truncated is set if the bucket held more than Total objects, which
+	// were not all counted.
+	Truncated bool
+	Error     string
+	// UpdatedAt is when the progress was last saved.
+	UpdatedAt time.Time
+}
+
+// stopped reports whether the deprovision is in progress but has not saved
+// its progress for deprovisionStaleAfter, because the broker running it
+// stopped. Only a state store outlives that broker.
+func (d Deprovision) stopped() bool {
+	return d.State == domain.InProgress && time.Since(d.UpdatedAt) > deprovisionStaleAfter
+}
+
+// Description is shown to users while they wait for the deprovision.
+func (d Deprovision) Description() string {
+	switch d.State {
+	case domain.Succeeded:
+		return fmt.Sprintf("deleted the bucket and its %s objects", formatObjectCount(d.Deleted))
+	case domain.Failed:
+		return fmt.Sprintf("deleting the bucket failed after deleting %s of %s objects: %s", formatObjectCount(d.Deleted), d.total(), d.Error)
+	default:
+		return fmt.Sprintf("deleted %s of %s objects", formatObjectCount(d.Deleted), d.total())
+	}
+}
+
+// total describes how many objects the bucket held, which is more than Total
+// if counting them was cut short.
+func (d Deprovision) total() string {
+	if d.Truncated {
+		return "more than " + formatObjectCount(d.Total)
+	}
+	return formatObjectCount(d.Total)
+}
+
+// DeprovisionStore keeps the progress of asynchronous deprovisions between
+// Deprovision and LastOperation.
+type DeprovisionStore interface {
+	GetDeprovision(instanceID string) (Deprovision, error)
+	SaveDeprovision(deprovision Deprovision) error
+	DeleteDeprovision(instanceID string) error
+}
+
+// MemoryDeprovisionStore keeps deprovisions in process memory. After a
+// restart, LastOperation starts deleting the bucket's remaining objects again.
+type MemoryDeprovisionStore struct {
+	mu           sync.Mutex
+	deprovisions map[string]Deprovision
+}
+
+func NewMemoryDeprovisionStore() *MemoryDeprovisionStore {
+	return &MemoryDeprovisionStore{
+		deprovisions: make(map[string]Deprovision),
+	}
+}
+
+func (m *MemoryDeprovisionStore) GetDeprovision(instanceID string) (Deprovision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deprovision, ok := m.deprovisions[instanceID]
+	if !ok {
+		return Deprovision{}, ErrDeprovisionNotFound
+	}
+	return deprovision, nil
+}
+
+func (m *MemoryDeprovisionStore) SaveDeprovision(deprovision Deprovision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deprovisions[deprovision.InstanceID] = deprovision
+	return nil
+}
+
+func (m *MemoryDeprovisionStore) DeleteDeprovision(instanceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.deprovisions, instanceID)
+	return nil
+}
+
+// deprovisionAsync starts deleting the instance's bucket and its objects in
+// the background, unless that is already under way.
+func (b *S3Broker) deprovisionAsync(ctx context.Context, instanceID, planID string) (domain.DeprovisionServiceSpec, error) {
+	spec := domain.DeprovisionServiceSpec{IsAsync: true, OperationData: operationDeprovision}
+
+	deprovision, err := b.deprovisions.GetDeprovision(instanceID)
+	if err == nil && deprovision.State == domain.InProgress && !deprovision.stopped() {
+		return spec, nil
+	} else if err != nil && !errors.Is(err, ErrDeprovisionNotFound) {
+		return domain.DeprovisionServiceSpec{}, err
+	}
+
+	if err := b.startDeprovision(ctx, instanceID, planID); err != nil {
+		return domain.DeprovisionServiceSpec{}, err
+	}
+	return spec, nil
+}
+
+// startDeprovision records a new deprovision and runs it. The deletion
+// outlives the request that started it, and forgets the instance's state once
+// the bucket is deleted.
+func (b *S3Broker) startDeprovision(ctx context.Context, instanceID, planID string) error {
+	deprovision := Deprovision{InstanceID: instanceID, State: domain.InProgress, UpdatedAt: time.Now()}
+	if err := b.deprovisions.SaveDeprovision(deprovision); err != nil {
+		return err
+	}
+
+	bucketName := b.bucketName(instanceID)
+	logger := b.logger.Session("deprovision-async", lager.Data{
+		instanceIDLogKey: instanceID,
+		"bucket":         bucketName,
+	})
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		err := b.bucket.DeleteWithProgress(ctx, bucketName, func(progress awss3.DeleteProgress) {
+			deprovision.Deleted, deprovision.Total, deprovision.Truncated = progress.Deleted, progress.Total, progress.Truncated
+			deprovision.UpdatedAt = time.Now()
+			if err := b.deprovisions.SaveDeprovision(deprovision); err != nil {
+				logger.Error("save-progress", err)
+			}
+		})
+		switch {
+		case err == nil, errors.Is(err, awss3.ErrBucketNotFound):
+			err = nil
+			deprovision.State = domain.Succeeded
+			logger.Info("deleted", lager.Data{"objects": deprovision.Deleted})
+			b.deleteInstanceState(ctx, instanceID)
+		default:
+			deprovision.State = domain.Failed
+			deprovision.Error = err.Error()
+			logger.Error("delete-bucket", err, lager.Data{"objects": deprovision.Deleted})
+		}
+		b.recordOperation(ctx, instanceID, "", planID, "delete-bucket", auditDetails{}, err)
+		deprovision.UpdatedAt = time.Now()
+		if err := b.deprovisions.SaveDeprovision(deprovision); err != nil {
+			logger.Error("save-progress", err)
+		}
+	}()
+	return nil
+}
+
+// deprovisionLastOperation reports the progress of an asynchronous
+// deprovision. Finished deprovisions are forgotten once reported, so a failed
+// one can be retried. A deprovision that is not known, because the broker
+// restarted without a state store, or that stopped with the broker running
+// it, is started again.
+func (b *S3Broker) deprovisionLastOperation(ctx context.Context, instanceID, planID string) (domain.LastOperation, error) {
+	deprovision, err := b.deprovisions.GetDeprovision(instanceID)
+	if errors.Is(err, ErrDeprovisionNotFound) || (err == nil && deprovision.stopped()) {
+		if err := b.startDeprovision(ctx, instanceID, planID); err != nil {
+			return domain.LastOperation{}, err
+		}
+		return domain.LastOperation{State: domain.InProgress, Description: "resuming deletion of the bucket's objects"}, nil
+	} else if err != nil {
+		return domain.LastOperation{}, err
+	}
+
+	if deprovision.State != domain.InProgress {
+		if err := b.deprovisions.DeleteDeprovision(instanceID); err != nil {
+			return domain.LastOperation{}, err
+		}
+	}
+	return domain.LastOperation{State: deprovision.State, Description: deprovision.Description()}, nil
+}
+
+// formatObjectCount abbreviates large object counts, such as 1.2M.
+func formatObjectCount(count int64) string {
+	switch {
+	case count >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(count)/1_000_000)
+	case count >= 10_000:
+		return fmt.Sprintf("%.1fK", float64(count)/1_000)
+	default:
+		return fmt.Sprint(count)
+	}
+}
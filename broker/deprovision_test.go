@@ -0,0 +1,203 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+func withDeletablePlan(b *S3Broker) {
+	b.catalog = &mockCatalog{planName: "plan1", planDeletable: true}
+	b.deprovisions = NewMemoryDeprovisionStore()
+	b.operations = NewMemoryOperationStore()
+}
+
+// waitForLastOperation polls LastOperation until it reports description or a
+// finished state.
+func waitForLastOperation(t *testing.T, b *S3Broker, description string) domain.LastOperation {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		lastOperation, err := b.LastOperation(context.Background(), "instance1", domain.PollDetails{OperationData: operationDeprovision})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if lastOperation.Description == description || lastOperation.State != domain.InProgress || time.Now().After(deadline) {
+			return lastOperation
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeprovisionAsync(t *testing.T) {
+	testCases := map[string]struct {
+		deleteErr         error
+		expectState       domain.LastOperationState
+		expectDescription string
+	}{
+		"success": {
+			expectState:       domain.Succeeded,
+			expectDescription: "deleted the bucket and its 1.2M objects",
+		},
+		"bucket already gone": {
+			deleteErr:         awss3.ErrBucketNotFound,
+			expectState:       domain.Succeeded,
+			expectDescription: "deleted the bucket and its 1.2M objects",
+		},
+		"failure": {
+			deleteErr:         errors.New("AccessDenied: denied"),
+			expectState:       domain.Failed,
+			expectDescription: "deleting the bucket failed after deleting 1.2M of 3.4M objects: AccessDenied: denied",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := &mockBucket{
+				deleteErr:  tc.deleteErr,
+				deleteWait: make(chan struct{}),
+				deleteProgress: []awss3.DeleteProgress{
+					{Deleted: 0, Total: 3_400_000},
+					{Deleted: 1_200_000, Total: 3_400_000},
+				},
+			}
+			b := newTestBroker(withBucket(bucket), withDeletablePlan)
+
+			spec, err := b.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{PlanID: "plan1"}, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !spec.IsAsync || spec.OperationData != operationDeprovision {
+				t.Fatalf("expected an asynchronous deprovision, got %+v", spec)
+			}
+
+			if lastOperation := waitForLastOperation(t, b, "deleted 1.2M of 3.4M objects"); lastOperation.State != domain.InProgress {
+				t.Fatalf("expected progress to be reported, got %+v", lastOperation)
+			}
+
+			// Deprovisioning again does not start a second deletion.
+			if spec, err := b.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{PlanID: "plan1"}, true); err != nil || !spec.IsAsync {
+				t.Fatalf("expected the deprovision to be in progress, got %+v, %v", spec, err)
+			}
+
+			close(bucket.deleteWait)
+			lastOperation := waitForLastOperation(t, b, tc.expectDescription)
+			if lastOperation.State != tc.expectState || lastOperation.Description != tc.expectDescription {
+				t.Errorf("expected %s %q, got %+v", tc.expectState, tc.expectDescription, lastOperation)
+			}
+
+			// The outcome of the deletion is in the instance's history.
+			var expectError string
+			if tc.expectState == domain.Failed {
+				expectError = tc.deleteErr.Error()
+			}
+			operations, _ := b.operations.ListOperations("instance1", maxRecentOperations)
+			if len(operations) == 0 || operations[0].Action != "delete-bucket" || operations[0].Error != expectError {
+				t.Errorf("expected a delete-bucket operation with error %q first, got %+v", expectError, operations)
+			}
+		})
+	}
+}
+
+func TestDeprovisionAsyncResumesAfterRestart(t *testing.T) {
+	b := newTestBroker(withDeletablePlan)
+
+	lastOperation, err := b.LastOperation(context.Background(), "instance1", domain.PollDetails{OperationData: operationDeprovision})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if lastOperation.State != domain.InProgress {
+		t.Fatalf("expected the deprovision to be resumed, got %+v", lastOperation)
+	}
+	if lastOperation := waitForLastOperation(t, b, ""); lastOperation.State != domain.Succeeded {
+		t.Errorf("expected the resumed deprovision to succeed, got %+v", lastOperation)
+	}
+}
+
+func TestDeprovisionAsyncInstanceState(t *testing.T) {
+	testCases := map[string]struct {
+		deleteErr   error
+		expectState domain.LastOperationState
+		expectKept  bool
+	}{
+		"deleted": {
+			expectState: domain.Succeeded,
+		},
+		"failed": {
+			deleteErr:   errors.New("AccessDenied: denied"),
+			expectState: domain.Failed,
+			expectKept:  true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			bucket := &mockBucket{deleteErr: tc.deleteErr, deleteWait: make(chan struct{})}
+			b := newTestBroker(withBucket(bucket), withDeletablePlan)
+			b.state = state.NewMemoryStore()
+			if err := b.state.SaveInstance(ctx, state.Instance{InstanceID: "instance1", PlanID: "plan1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := b.Deprovision(ctx, "instance1", domain.DeprovisionDetails{PlanID: "plan1"}, true); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := b.state.GetInstance(ctx, "instance1"); err != nil {
+				t.Errorf("expected the instance to be kept while its bucket is deleted, got %v", err)
+			}
+
+			close(bucket.deleteWait)
+			if lastOperation := waitForLastOperation(t, b, ""); lastOperation.State != tc.expectState {
+				t.Fatalf("expected %s, got %+v", tc.expectState, lastOperation)
+			}
+			if _, err := b.state.GetInstance(ctx, "instance1"); (err == nil) != tc.expectKept {
+				t.Errorf("expected the instance to be kept: %t, got %v", tc.expectKept, err)
+			}
+		})
+	}
+}
+
+func TestFormatObjectCount(t *testing.T) {
+	for count, expected := range map[int64]string{
+		0:         "0",
+		9_999:     "9999",
+		12_345:    "12.3K",
+		1_234_567: "1.2M",
+	} {
+		if formatted := formatObjectCount(count); formatted != expected {
+			t.Errorf("expected %d to be formatted as %s, got %s", count, expected, formatted)
+		}
+	}
+}
+
+func TestDeprovisionDescription(t *testing.T) {
+	testCases := map[string]struct {
+		deprovision Deprovision
+		expected    string
+	}{
+		"in progress": {
+			deprovision: Deprovision{State: domain.InProgress, Deleted: 1_200_000, Total: 3_400_000},
+			expected:    "deleted 1.2M of 3.4M objects",
+		},
+		"count truncated": {
+			deprovision: Deprovision{State: domain.InProgress, Deleted: 1_200, Total: 10_000, Truncated: true},
+			expected:    "deleted 1200 of more than 10.0K objects",
+		},
+		"failed with count truncated": {
+			deprovision: Deprovision{State: domain.Failed, Deleted: 1_200, Total: 10_000, Truncated: true, Error: "denied"},
+			expected:    "deleting the bucket failed after deleting 1200 of more than 10.0K objects: denied",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if description := tc.deprovision.Description(); description != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, description)
+			}
+		})
+	}
+}
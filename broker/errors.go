@@ -0,0 +1,58 @@
+package broker
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/objectstore"
+)
+
+// mapBucketError converts errors from the awss3 package into OSB failure
+// responses with a suitable HTTP status code. Unrecognized errors are
+// returned unchanged and reported by brokerapi as 500 Internal Server Error.
+func mapBucketError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, awss3.ErrBucketNotFound):
+		return apiresponses.ErrInstanceDoesNotExist
+	case errors.Is(err, awss3.ErrPolicyInvalid):
+		return apiresponses.NewFailureResponse(err, http.StatusBadRequest, "s3-policy-invalid")
+	case errors.Is(err, awss3.ErrThrottled):
+		return apiresponses.NewFailureResponse(err, http.StatusServiceUnavailable, "s3-throttled")
+	case errors.Is(err, awss3.ErrPolicyConflict):
+		return apiresponses.ErrConcurrentInstanceAccess
+	case errors.Is(err, awss3.ErrAccessDenied):
+		return apiresponses.NewFailureResponse(err, http.StatusInternalServerError, "s3-access-denied")
+	}
+	return err
+}
+
+// mapObjectStoreError converts errors from the objectstore package into OSB
+// failure responses, like mapBucketError.
+func mapObjectStoreError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, objectstore.ErrBucketNotFound):
+		return apiresponses.ErrInstanceDoesNotExist
+	case errors.Is(err, objectstore.ErrBucketExists):
+		return apiresponses.ErrInstanceAlreadyExists
+	case errors.Is(err, objectstore.ErrBucketNotEmpty):
+		return apiresponses.NewFailureResponse(
+			errors.New("The bucket contains objects. Delete all objects from the bucket before deleting the service instance."),
+			http.StatusUnprocessableEntity,
+			"bucket-not-empty",
+		)
+	case errors.Is(err, objectstore.ErrTooManyCredentials):
+		return apiresponses.NewFailureResponse(
+			errors.New("The bucket has as many bindings as its object store allows. Delete a binding before creating another."),
+			http.StatusUnprocessableEntity,
+			"too-many-bindings",
+		)
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/awsevents"
+)
+
+// DefaultEventSource is the source of the broker's EventBridge events.
+const DefaultEventSource = "s3-broker"
+
+// eventDetailTypePrefix begins the detail type of every event, which is
+// followed by the operation's action, such as "S3 Broker provision".
+const eventDetailTypePrefix = "S3 Broker "
+
+type EventsConfig struct {
+	// TargetARN is the SNS topic or EventBridge event bus that events are
+	// published to. Events are not published without it.
+	TargetARN string `yaml:"target_arn"`
+	// Source is the source of EventBridge events. Defaults to
+	// DefaultEventSource.
+	Source string `yaml:"source"`
+}
+
+func (c EventsConfig) Enabled() bool {
+	return c.TargetARN != ""
+}
+
+func (c EventsConfig) Validate() error {
+	if !c.Enabled() {
+		if c.Source != "" {
+			return errors.New("Must provide a TargetARN with a Source")
+		}
+		return nil
+	}
+	if !awsevents.IsTopicARN(c.TargetARN) && !awsevents.IsEventBusARN(c.TargetARN) {
+		return fmt.Errorf("TargetARN must be an SNS topic or EventBridge event bus ARN, got %q", c.TargetARN)
+	}
+	if c.Source != "" && awsevents.IsTopicARN(c.TargetARN) {
+		return errors.New("Source can only be set for an EventBridge event bus")
+	}
+	return nil
+}
+
+// Event describes an operation that the broker completed, successfully or
+// not, for automation such as registering new buckets with backup or data
+// loss prevention scanning.
+type Event struct {
+	// Action is the action of the operation, as in Operation.
+	Action     string `json:"action"`
+	InstanceID string `json:"instance_id"`
+	BindingID  string `json:"binding_id,omitempty"`
+	PlanID     string `json:"plan_id,omitempty"`
+	// Bucket and BucketARN are the instance's bucket. They are left out for
+	// plans of existing buckets, whose names the broker would have to look
+	// up, and BucketARN is left out for buckets in other object stores.
+	Bucket      string `json:"bucket,omitempty"`
+	BucketARN   string `json:"bucket_arn,omitempty"`
+	ObjectStore string `json:"object_store,omitempty"`
+	// User is the originating identity of the request, if the platform sent
+	// one.
+	User      string    `json:"user,omitempty"`
+	Time      time.Time `json:"time"`
+	Succeeded bool      `json:"succeeded"`
+	Error     string    `json:"error,omitempty"`
+	// RequestID is the ID of the request, as the broker returned it in
+	// X-Request-ID. Bucket deletions have the ID of their deprovision.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// SetEventPublisher makes the broker publish an event for every operation.
+// It must be called before SetAccounts.
+func (b *S3Broker) SetEventPublisher(publisher awsevents.Publisher) {
+	b.events = publisher
+}
+
+// operationEvent returns the event of an operation, of a plan with planID.
+func (b *S3Broker) operationEvent(operation Operation, planID string) Event {
+	event := Event{
+		Action:     operation.Action,
+		InstanceID: operation.InstanceID,
+		BindingID:  operation.BindingID,
+		PlanID:     planID,
+		User:       operation.User,
+		Time:       operation.Time,
+		Succeeded:  operation.Error == "",
+		Error:      operation.Error,
+	}
+	servicePlan, ok := b.catalog.FindServicePlan(planID)
+	if ok && !servicePlan.S3Properties.ExistingBucket {
+		event.Bucket = b.bucketName(operation.InstanceID)
+		event.ObjectStore = servicePlan.S3Properties.ObjectStore
+		if event.ObjectStore == "" {
+			event.BucketARN = fmt.Sprintf("arn:%s:s3:::%s", b.awsPartition, event.Bucket)
+		}
+	}
+	return event
+}
+
+// publishEvent publishes the outcome of an operation. Like recording it in
+// the history, failing to publish it does not fail the request.
+func (b *S3Broker) publishEvent(ctx context.Context, event Event) {
+	if b.events == nil {
+		return
+	}
+	detail, err := json.Marshal(event)
+	if err != nil {
+		b.logger.Error("publish-event", err, lager.Data{instanceIDLogKey: event.InstanceID})
+		return
+	}
+	if err := b.events.Publish(context.WithoutCancel(ctx), eventDetailTypePrefix+event.Action, detail); err != nil {
+		b.logger.Error("publish-event", err, lager.Data{instanceIDLogKey: event.InstanceID, "action": event.Action})
+	}
+}
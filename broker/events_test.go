@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+type mockEventPublisher struct {
+	detailTypes []string
+	events      []Event
+	err         error
+}
+
+func (p *mockEventPublisher) Publish(ctx context.Context, detailType string, detail []byte) error {
+	var event Event
+	if err := json.Unmarshal(detail, &event); err != nil {
+		return err
+	}
+	p.detailTypes = append(p.detailTypes, detailType)
+	p.events = append(p.events, event)
+	return p.err
+}
+
+func TestPublishEvent(t *testing.T) {
+	testCases := map[string]struct {
+		plans     map[string]ServicePlan
+		bindingID string
+		action    string
+		err       error
+		expect    Event
+	}{
+		"provision": {
+			plans:  map[string]ServicePlan{"plan1": {ID: "plan1"}},
+			action: "provision",
+			expect: Event{
+				Action:     "provision",
+				InstanceID: "instance1",
+				PlanID:     "plan1",
+				Bucket:     "prefix-instance1",
+				BucketARN:  "arn:aws:s3:::prefix-instance1",
+				Succeeded:  true,
+			},
+		},
+		"failed bind": {
+			plans:     map[string]ServicePlan{"plan1": {ID: "plan1"}},
+			bindingID: "binding1",
+			action:    "bind",
+			err:       errors.New("access denied"),
+			expect: Event{
+				Action:     "bind",
+				InstanceID: "instance1",
+				BindingID:  "binding1",
+				PlanID:     "plan1",
+				Bucket:     "prefix-instance1",
+				BucketARN:  "arn:aws:s3:::prefix-instance1",
+				Error:      "access denied",
+			},
+		},
+		"existing bucket": {
+			plans:  map[string]ServicePlan{"plan1": {ID: "plan1", S3Properties: S3Properties{ExistingBucket: true}}},
+			action: "provision",
+			expect: Event{Action: "provision", InstanceID: "instance1", PlanID: "plan1", Succeeded: true},
+		},
+		"other object store": {
+			plans:  map[string]ServicePlan{"plan1": {ID: "plan1", S3Properties: S3Properties{ObjectStore: ObjectStoreGCS}}},
+			action: "deprovision",
+			expect: Event{
+				Action:      "deprovision",
+				InstanceID:  "instance1",
+				PlanID:      "plan1",
+				Bucket:      "prefix-instance1",
+				ObjectStore: ObjectStoreGCS,
+				Succeeded:   true,
+			},
+		},
+		"unknown plan": {
+			plans:  map[string]ServicePlan{},
+			action: "unbind",
+			expect: Event{Action: "unbind", InstanceID: "instance1", PlanID: "plan1", Succeeded: true},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			publisher := &mockEventPublisher{}
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-events"),
+				bucketPrefix: "prefix",
+				awsPartition: "aws",
+				catalog:      &mockCatalog{plans: tc.plans},
+				operations:   NewMemoryOperationStore(),
+				events:       publisher,
+			}
+			b.recordOperation(context.Background(), "instance1", tc.bindingID, "plan1", tc.action, auditDetails{}, tc.err)
+
+			if diff := cmp.Diff([]Event{tc.expect}, publisher.events, cmpopts.IgnoreFields(Event{}, "Time")); diff != "" {
+				t.Errorf("unexpected events (-want +got):\n%s", diff)
+			}
+			if publisher.detailTypes[0] != "S3 Broker "+tc.action {
+				t.Errorf("unexpected detail type %q", publisher.detailTypes[0])
+			}
+		})
+	}
+}
+
+func TestPublishEventFailure(t *testing.T) {
+	operations := NewMemoryOperationStore()
+	b := &S3Broker{
+		logger:     lager.NewLogger("broker-unit-test-events"),
+		catalog:    &mockCatalog{},
+		operations: operations,
+		events:     &mockEventPublisher{err: errors.New("throttled")},
+	}
+	b.recordOperation(context.Background(), "instance1", "", "plan1", "provision", auditDetails{}, nil)
+
+	recorded, _ := operations.ListOperations("instance1", 1)
+	if len(recorded) != 1 {
+		t.Errorf("expected the operation to be recorded even though its event failed, got %v", recorded)
+	}
+}
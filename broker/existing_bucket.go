@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+var (
+	ErrBucketNameRequired = apiresponses.NewFailureResponse(
+		errors.New("This plan binds to an existing bucket. Pass its name as the bucket_name parameter."),
+		http.StatusBadRequest,
+		"bucket-name-required",
+	)
+
+	ErrExistingBucketPlanChange = apiresponses.NewFailureResponse(
+		errors.New("Instances cannot change between plans for existing buckets and plans for buckets created by the broker."),
+		http.StatusBadRequest,
+		"existing-bucket-plan-change",
+	)
+)
+
+// adoptBucket provisions an instance of an existing bucket plan. The bucket
+// named by the bucket_name parameter must be owned by the broker's account
+// and in its region, where the tagging API can find it again. It is tagged
+// for the instance but otherwise left as it is.
+func (b *S3Broker) adoptBucket(
+	ctx context.Context,
+	instanceID string,
+	servicePlan ServicePlan,
+	details domain.ProvisionDetails,
+) error {
+	var provisionParameters ProvisionParameters
+	if len(details.RawParameters) > 0 {
+		if err := json.Unmarshal(details.RawParameters, &provisionParameters); err != nil {
+			return err
+		}
+	}
+	bucketName := provisionParameters.BucketName
+	if bucketName == "" {
+		return ErrBucketNameRequired
+	}
+
+	bucketDetails, err := b.bucket.Describe(ctx, bucketName, b.awsPartition)
+	if err != nil {
+		if errors.Is(err, awss3.ErrBucketNotFound) {
+			return apiresponses.NewFailureResponse(fmt.Errorf("Bucket %s does not exist.", bucketName), http.StatusBadRequest, "bucket-not-found")
+		}
+		return mapBucketError(err)
+	}
+	if b.region != "" && !slices.Contains(b.allowedRegions(), bucketDetails.Region) {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("Bucket %s is in region %s. Only buckets in %s can be used.", bucketName, bucketDetails.Region, strings.Join(b.allowedRegions(), ", ")),
+			http.StatusBadRequest,
+			"bucket-region",
+		)
+	}
+
+	service, ok := b.catalog.FindService(details.ServiceID)
+	if !ok {
+		return fmt.Errorf("Service '%s' not found", details.ServiceID)
+	}
+	platformContext, err := parseBindContext(details.RawContext)
+	if err != nil {
+		return err
+	}
+	organizationGUID, spaceGUID := cfPlace(details.OrganizationGUID, details.SpaceGUID, details.RawContext)
+	tags, err := b.tagManager.GenerateTags(
+		brokertags.Create,
+		service.Name,
+		servicePlan.Name,
+		brokertags.ResourceGUIDs{
+			OrganizationGUID: organizationGUID,
+			SpaceGUID:        spaceGUID,
+			InstanceGUID:     instanceID,
+		},
+		false,
+	)
+	if err != nil {
+		return err
+	}
+	tags = addPlatformTags(tags, platformContext)
+
+	if err := b.bucket.Adopt(ctx, bucketName, awss3.BucketDetails{Tags: tags}); err != nil {
+		b.logger.Error("provision: adopt bucket failed", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			"bucket":         bucketName,
+		})
+		if errors.Is(err, awss3.ErrBucketNotOwned) {
+			return apiresponses.NewFailureResponse(err, http.StatusBadRequest, "bucket-not-owned")
+		}
+		var awsErr *awss3.Error
+		if !errors.As(err, &awsErr) {
+			// The bucket belongs to another instance.
+			return apiresponses.NewFailureResponse(err, http.StatusConflict, "bucket-in-use")
+		}
+		return mapBucketError(err)
+	}
+	return nil
+}
+
+// releaseBucket deprovisions an instance of an existing bucket plan, which
+// leaves the bucket and its objects in place.
+func (b *S3Broker) releaseBucket(ctx context.Context, instanceID string) error {
+	bucketName, err := b.bucket.FindAdopted(ctx, instanceID)
+	if err != nil {
+		return mapBucketError(err)
+	}
+	return mapBucketError(b.bucket.Release(ctx, bucketName))
+}
+
+// instanceBucketName returns the name of an instance's bucket: the bucket it
+// adopted for existing bucket plans, or the bucket the broker created.
+func (b *S3Broker) instanceBucketName(ctx context.Context, instanceID string, servicePlan ServicePlan) (string, error) {
+	if servicePlan.S3Properties.ExistingBucket {
+		return b.bucket.FindAdopted(ctx, instanceID)
+	}
+	return b.bucketName(instanceID), nil
+}
@@ -0,0 +1,134 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+func withExistingBucketPlan(b *S3Broker) {
+	b.catalog = &mockCatalog{
+		planName:     "existing",
+		serviceName:  "service1",
+		s3Properties: S3Properties{IamPolicy: "{}", ExistingBucket: true},
+	}
+	b.tagManager = &mockTagGenerator{
+		tags: map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance1"},
+	}
+	b.region = "us-gov-west-1"
+	b.awsPartition = "aws-us-gov"
+}
+
+func TestProvisionExistingBucket(t *testing.T) {
+	testCases := map[string]struct {
+		bucket        *mockBucket
+		parameters    string
+		expectErr     string
+		expectAdopted bool
+	}{
+		"adopts bucket": {
+			bucket:        &mockBucket{describeDetails: awss3.BucketDetails{Region: "us-gov-west-1"}},
+			parameters:    `{"bucket_name": "my-bucket"}`,
+			expectAdopted: true,
+		},
+		"bucket name missing": {
+			bucket:     &mockBucket{},
+			parameters: `{}`,
+			expectErr:  ErrBucketNameRequired.Error(),
+		},
+		"bucket does not exist": {
+			bucket:     &mockBucket{describeErr: awss3.ErrBucketNotFound},
+			parameters: `{"bucket_name": "my-bucket"}`,
+			expectErr:  "Bucket my-bucket does not exist.",
+		},
+		"bucket in another region": {
+			bucket:     &mockBucket{describeDetails: awss3.BucketDetails{Region: "us-east-1"}},
+			parameters: `{"bucket_name": "my-bucket"}`,
+			expectErr:  "Bucket my-bucket is in region us-east-1. Only buckets in us-gov-west-1 can be used.",
+		},
+		"bucket of another account": {
+			bucket: &mockBucket{
+				describeDetails: awss3.BucketDetails{Region: "us-gov-west-1"},
+				adoptErr:        awss3.ErrBucketNotOwned,
+			},
+			parameters: `{"bucket_name": "my-bucket"}`,
+			expectErr:  awss3.ErrBucketNotOwned.Error(),
+		},
+		"bucket of another instance": {
+			bucket: &mockBucket{
+				describeDetails: awss3.BucketDetails{Region: "us-gov-west-1"},
+				adoptErr:        errors.New("bucket my-bucket already belongs to service instance instance2"),
+			},
+			parameters: `{"bucket_name": "my-bucket"}`,
+			expectErr:  "bucket my-bucket already belongs to service instance instance2",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withBucket(tc.bucket), withExistingBucketPlan)
+			_, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{
+				PlanID:        "existing",
+				ServiceID:     "service1",
+				RawParameters: json.RawMessage(tc.parameters),
+			}, false)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected err %q, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if adopted := tc.bucket.adopted["my-bucket"] == "instance1"; adopted != tc.expectAdopted {
+				t.Errorf("expected adopted %t, got %v", tc.expectAdopted, tc.bucket.adopted)
+			}
+		})
+	}
+}
+
+func TestBindExistingBucket(t *testing.T) {
+	bucket := &mockBucket{
+		describeDetails: awss3.BucketDetails{BucketName: "my-bucket", ARN: "arn:aws-us-gov:s3:::my-bucket"},
+		adopted:         map[string]string{"my-bucket": "instance1"},
+	}
+	b := newTestBroker(withBucket(bucket), withExistingBucketPlan)
+
+	binding, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+		PlanID:    "existing",
+		ServiceID: "service1",
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if credentials := binding.Credentials.(Credentials); credentials.Bucket != "my-bucket" {
+		t.Errorf("expected bucket my-bucket, got %q", credentials.Bucket)
+	}
+
+	delete(bucket.adopted, "my-bucket")
+	if _, err := b.Bind(context.Background(), "instance1", "binding2", domain.BindDetails{
+		PlanID:    "existing",
+		ServiceID: "service1",
+	}, false); err == nil {
+		t.Errorf("expected error binding instance without an adopted bucket")
+	}
+}
+
+func TestDeprovisionExistingBucket(t *testing.T) {
+	bucket := &mockBucket{adopted: map[string]string{"my-bucket": "instance1"}}
+	b := newTestBroker(withBucket(bucket), withExistingBucketPlan)
+
+	if _, err := b.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{PlanID: "existing"}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(bucket.released) != 1 || bucket.released[0] != "my-bucket" {
+		t.Errorf("expected my-bucket to be released, got %v", bucket.released)
+	}
+	if bucket.deleted {
+		t.Errorf("expected bucket not to be deleted")
+	}
+}
@@ -0,0 +1,14 @@
+package broker
+
+import "github.com/pivotal-cf/brokerapi/v10/domain"
+
+// folderPrefix returns the key prefix of the folder that a binding with
+// create_folder and no path_prefix is confined to. Apps get the same folder
+// each time they are bound, so rebinding keeps their objects; service keys
+// get one of their own.
+func folderPrefix(bindingID string, details domain.BindDetails) string {
+	if appGUID := bindAppGUID(details); appGUID != "" {
+		return appGUID
+	}
+	return bindingID
+}
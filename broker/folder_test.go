@@ -0,0 +1,72 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func TestBindCreateFolder(t *testing.T) {
+	testCases := map[string]struct {
+		parameters    string
+		appGUID       string
+		expectPrefix  string
+		expectFolders []string
+	}{
+		"app binding": {
+			parameters:    `{"create_folder": true}`,
+			appGUID:       "app1",
+			expectPrefix:  "app1",
+			expectFolders: []string{"prefix-instance1/app1"},
+		},
+		"service key": {
+			parameters:    `{"create_folder": true}`,
+			expectPrefix:  "binding1",
+			expectFolders: []string{"prefix-instance1/binding1"},
+		},
+		"path prefix": {
+			parameters:    `{"create_folder": true, "path_prefix": "/uploads/"}`,
+			appGUID:       "app1",
+			expectPrefix:  "uploads",
+			expectFolders: []string{"prefix-instance1/uploads"},
+		},
+		"no folder": {
+			parameters:   `{"path_prefix": "uploads"}`,
+			appGUID:      "app1",
+			expectPrefix: "uploads",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := &mockBucket{}
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-folder"),
+				bucket:       bucket,
+				catalog:      &mockCatalog{planName: "plan1", serviceName: "service1", s3Properties: S3Properties{IamPolicy: "{}"}},
+				tagManager:   &mockTagGenerator{},
+				user:         &mockUser{},
+				bucketPrefix: "prefix",
+			}
+			details := domain.BindDetails{
+				ServiceID:     "service1",
+				PlanID:        "plan1",
+				AppGUID:       tc.appGUID,
+				RawParameters: json.RawMessage(tc.parameters),
+			}
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", details, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if prefix := binding.Credentials.(Credentials).PathPrefix; prefix != tc.expectPrefix {
+				t.Errorf("expected path_prefix %q, got %q", tc.expectPrefix, prefix)
+			}
+			if diff := cmp.Diff(tc.expectFolders, bucket.folders); diff != "" {
+				t.Errorf("unexpected folders (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+// Garbage collection policies.
+const (
+	// GarbageCollectionReport only logs orphaned buckets and users.
+	GarbageCollectionReport = "report"
+	// GarbageCollectionDeleteEmpty deletes orphaned users and empty buckets,
+	// and logs buckets that still have objects.
+	GarbageCollectionDeleteEmpty = "delete-empty"
+	// GarbageCollectionDelete is GarbageCollectionDeleteEmpty. It deleted
+	// buckets with their objects, but a bucket missing from the state store is
+	// not evidence enough to delete its objects.
+	GarbageCollectionDelete = "delete"
+)
+
+const defaultGarbageCollectionMinAge = 24 * time.Hour
+
+func (c GarbageCollectionConfig) Validate() error {
+	switch c.Policy {
+	case "", GarbageCollectionReport, GarbageCollectionDeleteEmpty, GarbageCollectionDelete:
+	default:
+		return fmt.Errorf("unknown policy %q", c.Policy)
+	}
+	if c.Interval < 0 || c.MinAge < 0 {
+		return errors.New("interval and min_age must not be negative")
+	}
+	return nil
+}
+
+// CollectGarbage finds buckets tagged as instances' and IAM users named like
+// bindings' that the state store does not have, such as those left behind by
+// failed provisions and binds, and logs or deletes them according to the
+// configured policy. Only resources created after the broker started using
+// the store, and older than the minimum age, are deleted, since the store
+// never had the instances and bindings that came before it. Adopted buckets
+// and buckets with objects are never deleted, and deletions are written to the
+// audit trail. It returns the orphans found, with Repaired set on those
+// deleted. Its AWS calls are low priority.
+func (b *S3Broker) CollectGarbage(ctx context.Context) ([]Discrepancy, error) {
+	ctx = awsretry.WithLowPriority(ctx)
+	logger := b.logger.Session("garbage-collection")
+
+	discrepancies, err := b.Reconcile(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	initializedAt, err := state.InitializedAt(ctx, b.state)
+	if err != nil {
+		return nil, fmt.Errorf("reading when the state store was initialized: %w", err)
+	}
+
+	policy := b.garbageCollection.Policy
+	if policy == "" {
+		policy = GarbageCollectionReport
+	}
+	minAge := b.garbageCollection.MinAge
+	if minAge == 0 {
+		minAge = defaultGarbageCollectionMinAge
+	}
+	cutoff := time.Now().Add(-minAge)
+	// collectable reports whether a resource created at createdAt may be
+	// deleted, or else why not.
+	collectable := func(createdAt time.Time) (bool, string) {
+		switch {
+		case createdAt.IsZero() || createdAt.After(cutoff):
+			return false, ""
+		case !createdAt.After(initializedAt):
+			return false, "created before the state store"
+		}
+		return policy != GarbageCollectionReport, ""
+	}
+
+	var orphans []Discrepancy
+	for _, orphan := range discrepancies {
+		if orphan.Kind != DiscrepancyBucketUntracked && orphan.Kind != DiscrepancyUserUntracked {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return orphans, err
+		}
+		accountBroker := b.forAccount(orphan.Account)
+		data := lager.Data{
+			"account":        orphan.Account,
+			"kind":           orphan.Kind,
+			instanceIDLogKey: orphan.InstanceID,
+			"resource":       orphan.Resource,
+		}
+
+		var createdAt time.Time
+		var deleteErr error
+		switch orphan.Kind {
+		case DiscrepancyBucketUntracked:
+			tags, err := accountBroker.bucket.Tags(ctx, orphan.Resource)
+			if err != nil {
+				logger.Error("bucket-tags", err, data)
+				continue
+			}
+			if _, ok := tags[awss3.AdoptedTagKey]; ok {
+				orphan.Detail = "adopted bucket"
+				break
+			}
+			createdAt, _ = time.Parse(time.RFC3339, tags["Created at"])
+			ok, detail := collectable(createdAt)
+			if !ok {
+				orphan.Detail = detail
+				break
+			}
+			deleteErr = accountBroker.bucket.Delete(ctx, orphan.Resource, false)
+			if errors.Is(deleteErr, awss3.ErrBucketNotEmpty) {
+				orphan.Detail = "bucket is not empty"
+				deleteErr = nil
+				break
+			}
+			orphan.Repaired = deleteErr == nil
+		case DiscrepancyUserUntracked:
+			user, err := accountBroker.user.Describe(orphan.Resource)
+			if err != nil {
+				logger.Error("describe-user", err, data)
+				continue
+			}
+			createdAt = user.CreateDate
+			ok, detail := collectable(createdAt)
+			if !ok {
+				orphan.Detail = detail
+				break
+			}
+			deleteErr = accountBroker.deleteOrphanedUser(orphan.Resource)
+			orphan.Repaired = deleteErr == nil
+		}
+
+		if deleteErr != nil || orphan.Repaired {
+			event := AuditEvent{
+				Event: Event{Action: "delete-orphaned-user", InstanceID: orphan.InstanceID},
+				Details: map[string]any{
+					"account":  orphan.Account,
+					"resource": orphan.Resource,
+					"created":  createdAt.UTC(),
+				},
+			}
+			if orphan.Kind == DiscrepancyBucketUntracked {
+				event.Action = "delete-orphaned-bucket"
+				event.Bucket = orphan.Resource
+			}
+			b.AuditAction(ctx, event, deleteErr)
+		}
+		if !createdAt.IsZero() {
+			data["created"] = createdAt.UTC().Format(time.RFC3339)
+		}
+		if orphan.Detail != "" {
+			data["detail"] = orphan.Detail
+		}
+		switch {
+		case deleteErr != nil:
+			logger.Error("delete", deleteErr, data)
+		case orphan.Repaired:
+			logger.Info("deleted", data)
+		default:
+			logger.Info("orphan", data)
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, nil
+}
+
+// deleteOrphanedUser deletes an IAM user that no binding in the state store
+// has, with its access keys and inline policies. Managed policies are
+// detached, and deleted if they are named like a binding's own.
+func (b *S3Broker) deleteOrphanedUser(userName string) error {
+	accessKeys, err := b.user.DescribeAccessKeys(userName)
+	if err != nil {
+		return err
+	}
+	for _, accessKey := range accessKeys {
+		if err := b.user.DeleteAccessKey(userName, accessKey.AccessKeyID); err != nil {
+			return err
+		}
+	}
+
+	inlinePolicies, err := b.user.ListUserPolicies(userName)
+	if err != nil {
+		return err
+	}
+	for _, policyName := range inlinePolicies {
+		if err := b.user.DeleteUserPolicy(userName, policyName); err != nil {
+			return err
+		}
+	}
+
+	userPolicies, err := b.user.ListAttachedUserPolicies(userName, "")
+	if err != nil {
+		return err
+	}
+	isBindingPolicy := nameMatcher(b.policyNameTemplate, b.policyPrefix)
+	for _, userPolicy := range userPolicies {
+		if err := b.user.DetachUserPolicy(userName, userPolicy); err != nil {
+			return err
+		}
+		if !isBindingPolicy(path.Base(userPolicy)) {
+			continue
+		}
+		if err := b.user.DeletePolicy(userPolicy); err != nil {
+			return err
+		}
+	}
+
+	if b.group != nil {
+		if err := b.leaveGroups(userName); err != nil {
+			return err
+		}
+	}
+
+	return b.user.Delete(userName)
+}
+
+// RunGarbageCollection calls CollectGarbage on the configured interval until
+// ctx is done, while this broker is the leader. It returns at once if no
+// interval is set.
+func (b *S3Broker) RunGarbageCollection(ctx context.Context) {
+	if b.garbageCollection.Interval == 0 {
+		return
+	}
+	if b.state == nil {
+		b.logger.Error("garbage-collection", ErrReconcileRequiresState)
+		return
+	}
+
+	ticker := time.NewTicker(b.garbageCollection.Interval)
+	defer ticker.Stop()
+	for {
+		if b.isLeader() {
+			if _, err := b.CollectGarbage(ctx); err != nil {
+				b.logger.Error("garbage-collection", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	initialized := time.Now().Add(-7 * 24 * time.Hour)
+	preexisting := time.Now().Add(-30 * 24 * time.Hour)
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+	bucketTags := func(instanceID string, createdAt time.Time) map[string]string {
+		return map[string]string{
+			brokertags.ServiceInstanceGUIDTagKey: instanceID,
+			brokertags.ServiceNameTagKey:         "service1",
+			"Created at":                         createdAt.Format(time.RFC3339),
+		}
+	}
+
+	testCases := map[string]struct {
+		policy        string
+		expectOrphans []Discrepancy
+		expectBuckets []string
+		expectUsers   []string
+	}{
+		"report": {
+			policy: GarbageCollectionReport,
+			expectOrphans: []Discrepancy{
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "adopted", Resource: "prefix-adopted", Detail: "adopted bucket"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "full", Resource: "prefix-full"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "old", Resource: "prefix-old"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "preexisting", Resource: "prefix-preexisting", Detail: "created before the state store"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "recent", Resource: "prefix-recent"},
+				{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding7", Detail: "created before the state store"},
+				{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding8"},
+				{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding9"},
+			},
+			expectBuckets: []string{"prefix-adopted", "prefix-full", "prefix-instance1", "prefix-old", "prefix-preexisting", "prefix-recent"},
+		},
+		"delete empty": {
+			policy: GarbageCollectionDeleteEmpty,
+			expectOrphans: []Discrepancy{
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "adopted", Resource: "prefix-adopted", Detail: "adopted bucket"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "full", Resource: "prefix-full", Detail: "bucket is not empty"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "old", Resource: "prefix-old", Repaired: true},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "preexisting", Resource: "prefix-preexisting", Detail: "created before the state store"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "recent", Resource: "prefix-recent"},
+				{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding7", Detail: "created before the state store"},
+				{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding8", Repaired: true},
+				{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding9"},
+			},
+			expectBuckets: []string{"prefix-adopted", "prefix-full", "prefix-instance1", "prefix-preexisting", "prefix-recent"},
+			expectUsers:   []string{"prefix-binding8"},
+		},
+		// Buckets with objects are kept whatever the policy.
+		"delete": {
+			policy: GarbageCollectionDelete,
+			expectOrphans: []Discrepancy{
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "adopted", Resource: "prefix-adopted", Detail: "adopted bucket"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "full", Resource: "prefix-full", Detail: "bucket is not empty"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "old", Resource: "prefix-old", Repaired: true},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "preexisting", Resource: "prefix-preexisting", Detail: "created before the state store"},
+				{Kind: DiscrepancyBucketUntracked, InstanceID: "recent", Resource: "prefix-recent"},
+				{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding7", Detail: "created before the state store"},
+				{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding8", Repaired: true},
+				{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding9"},
+			},
+			expectBuckets: []string{"prefix-adopted", "prefix-full", "prefix-instance1", "prefix-preexisting", "prefix-recent"},
+			expectUsers:   []string{"prefix-binding8"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := state.NewMemoryStore()
+			initializedData, _ := json.Marshal(initialized)
+			if err := store.SaveRecord(ctx, state.Record{Kind: "store", Key: "initialized", Data: initializedData}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SaveInstance(ctx, state.Instance{InstanceID: "instance1", ServiceID: "service1", PlanID: "plan1", BucketName: "prefix-instance1"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := (stateBindingStore{store: store}).SaveBinding(StoredBinding{InstanceID: "instance1", BindingID: "binding1", Credentials: Credentials{AccessKeyID: "key1"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			adoptedTags := bucketTags("adopted", old)
+			adoptedTags[awss3.AdoptedTagKey] = "true"
+			bucket := &mockBucket{
+				buckets: map[string]map[string]string{
+					"prefix-instance1":   bucketTags("instance1", old),
+					"prefix-adopted":     adoptedTags,
+					"prefix-full":        bucketTags("full", old),
+					"prefix-old":         bucketTags("old", old),
+					"prefix-preexisting": bucketTags("preexisting", preexisting),
+					"prefix-recent":      bucketTags("recent", recent),
+				},
+				nonEmpty: []string{"prefix-full"},
+			}
+			user := &mockUser{
+				accessKeys:     map[string][]string{"prefix-binding1": {"key1"}, "prefix-binding7": {"key7"}, "prefix-binding8": {"key8"}, "prefix-binding9": {"key9"}},
+				inlinePolicies: map[string][]string{"prefix-binding8": {"prefix-binding8"}},
+				createDates:    map[string]time.Time{"prefix-binding1": old, "prefix-binding7": preexisting, "prefix-binding8": old, "prefix-binding9": recent},
+			}
+			b := &S3Broker{
+				logger:            lager.NewLogger("broker-unit-test-garbage-collection"),
+				catalog:           &mockCatalog{planName: "plan1", serviceName: "service1"},
+				bucket:            bucket,
+				user:              user,
+				userPrefix:        "prefix",
+				state:             store,
+				garbageCollection: GarbageCollectionConfig{Policy: tc.policy},
+			}
+
+			orphans, err := b.CollectGarbage(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(tc.expectOrphans, orphans); diff != "" {
+				t.Errorf("unexpected orphans (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expectBuckets, slices.Sorted(maps.Keys(bucket.buckets))); diff != "" {
+				t.Errorf("unexpected buckets left (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expectUsers, user.deletedUsers); diff != "" {
+				t.Errorf("unexpected users deleted (-want +got):\n%s", diff)
+			}
+			if len(tc.expectUsers) > 0 && (len(user.accessKeys["prefix-binding8"]) > 0 || len(user.inlinePolicies["prefix-binding8"]) > 0) {
+				t.Error("expected the deleted user's access keys and policies to be deleted")
+			}
+		})
+	}
+}
+
+func TestGarbageCollectionConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		config    GarbageCollectionConfig
+		expectErr bool
+	}{
+		"default":          {config: GarbageCollectionConfig{}},
+		"delete":           {config: GarbageCollectionConfig{Interval: time.Hour, Policy: GarbageCollectionDelete}},
+		"unknown policy":   {config: GarbageCollectionConfig{Policy: "purge"}, expectErr: true},
+		"negative min age": {config: GarbageCollectionConfig{MinAge: -time.Hour}, expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.expectErr {
+				t.Errorf("expected error to be %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
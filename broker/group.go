@@ -0,0 +1,61 @@
+package broker
+
+import (
+	"fmt"
+)
+
+// usesInstanceGroup reports whether a binding's permissions are exactly
+// those of the instance group. Bindings that narrow or widen them get an
+// inline policy of their own instead.
+func usesInstanceGroup(bindParameters BindParameters, pathPrefix string) bool {
+	return len(bindParameters.AdditionalInstances) == 0 &&
+		pathPrefix == "" &&
+		(bindParameters.Permissions == "" || bindParameters.Permissions == PermissionsReadWrite)
+}
+
+func (b *S3Broker) groupName(instanceID string) string {
+	return fmt.Sprintf("%s-%s", b.userPrefix, instanceID)
+}
+
+// ensureInstanceGroup creates the instance's group if needed and puts the
+// plan's policy on it, so policy changes reach every binding of the instance
+// with its next bind.
+func (b *S3Broker) ensureInstanceGroup(instanceID, iamPath, iamPolicy string, bucketARNs []string) error {
+	groupName := b.groupName(instanceID)
+	if err := b.group.Create(groupName, iamPath); err != nil {
+		return err
+	}
+	return b.group.PutGroupPolicy(groupName, groupName, iamPolicy, bucketARNs)
+}
+
+// leaveGroups removes a binding user from its groups so it can be deleted.
+func (b *S3Broker) leaveGroups(userName string) error {
+	groupNames, err := b.group.ListGroupsForUser(userName)
+	if b.handleUnbindError(err) != nil {
+		return err
+	}
+	for _, groupName := range groupNames {
+		if err := b.group.RemoveUser(groupName, userName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteInstanceGroup deletes the instance's group and its policies, if the
+// instance has one.
+func (b *S3Broker) deleteInstanceGroup(instanceID string) error {
+	groupName := b.groupName(instanceID)
+
+	policyNames, err := b.group.ListGroupPolicies(groupName)
+	if err != nil {
+		return b.handleUnbindError(err)
+	}
+	for _, policyName := range policyNames {
+		if err := b.group.DeleteGroupPolicy(groupName, policyName); err != nil {
+			return err
+		}
+	}
+
+	return b.handleUnbindError(b.group.Delete(groupName))
+}
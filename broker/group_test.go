@@ -0,0 +1,159 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+type mockGroup struct {
+	policies map[string][]string
+	members  map[string][]string
+}
+
+func (g *mockGroup) Create(groupName, iamPath string) error {
+	if g.policies == nil {
+		g.policies = make(map[string][]string)
+	}
+	if _, ok := g.policies[groupName]; !ok {
+		g.policies[groupName] = []string{}
+	}
+	return nil
+}
+
+func (g *mockGroup) Delete(groupName string) error {
+	if _, ok := g.policies[groupName]; !ok {
+		return awserr.New(iam.ErrCodeNoSuchEntityException, "not found", nil)
+	}
+	delete(g.policies, groupName)
+	return nil
+}
+
+func (g *mockGroup) PutGroupPolicy(groupName, policyName, policyTemplate string, resources []string) error {
+	if !slices.Contains(g.policies[groupName], policyName) {
+		g.policies[groupName] = append(g.policies[groupName], policyName)
+	}
+	return nil
+}
+
+func (g *mockGroup) ListGroupPolicies(groupName string) ([]string, error) {
+	policyNames, ok := g.policies[groupName]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "not found", nil)
+	}
+	return slices.Clone(policyNames), nil
+}
+
+func (g *mockGroup) DeleteGroupPolicy(groupName, policyName string) error {
+	g.policies[groupName] = slices.DeleteFunc(g.policies[groupName], func(p string) bool { return p == policyName })
+	return nil
+}
+
+func (g *mockGroup) AddUser(groupName, userName string) error {
+	if g.members == nil {
+		g.members = make(map[string][]string)
+	}
+	g.members[groupName] = append(g.members[groupName], userName)
+	return nil
+}
+
+func (g *mockGroup) RemoveUser(groupName, userName string) error {
+	g.members[groupName] = slices.DeleteFunc(g.members[groupName], func(u string) bool { return u == userName })
+	return nil
+}
+
+func (g *mockGroup) ListGroupsForUser(userName string) ([]string, error) {
+	var groupNames []string
+	for groupName, members := range g.members {
+		if slices.Contains(members, userName) {
+			groupNames = append(groupNames, groupName)
+		}
+	}
+	return groupNames, nil
+}
+
+func withInstanceGroup(group *mockGroup) func(*S3Broker) {
+	return func(b *S3Broker) {
+		b.group = group
+		b.useInstanceGroups = true
+	}
+}
+
+func TestBindInstanceGroup(t *testing.T) {
+	testCases := map[string]struct {
+		parameters           string
+		expectGroupMembers   []string
+		expectInlinePolicies map[string][]string
+	}{
+		"instance-wide binding joins the group": {
+			parameters:         `{}`,
+			expectGroupMembers: []string{"-binding1"},
+		},
+		"read-only binding gets an inline policy": {
+			parameters:           `{"permissions": "read-only"}`,
+			expectInlinePolicies: map[string][]string{"-binding1": {"-binding1"}},
+		},
+		"path prefix binding gets an inline policy": {
+			parameters:           `{"path_prefix": "app"}`,
+			expectInlinePolicies: map[string][]string{"-binding1": {"-binding1"}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			group := &mockGroup{}
+			user := &mockUser{}
+			b := newTestBroker(withUser(user), withInstanceGroup(group))
+			_, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(tc.parameters),
+			}, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !slices.Equal(group.members["-instance1"], tc.expectGroupMembers) {
+				t.Errorf("expected group members %v, got %v", tc.expectGroupMembers, group.members["-instance1"])
+			}
+			if len(user.inlinePolicies) != len(tc.expectInlinePolicies) {
+				t.Errorf("expected inline policies %v, got %v", tc.expectInlinePolicies, user.inlinePolicies)
+			}
+			if tc.expectGroupMembers != nil && !slices.Equal(group.policies["-instance1"], []string{"-instance1"}) {
+				t.Errorf("expected the group policy to be put, got %v", group.policies)
+			}
+		})
+	}
+}
+
+func TestUnbindAndDeprovisionInstanceGroup(t *testing.T) {
+	group := &mockGroup{}
+	user := &mockUser{}
+	b := newTestBroker(withUser(user), withInstanceGroup(group))
+	details := domain.BindDetails{PlanID: "planid1", ServiceID: "serviceid1"}
+	if _, err := b.Bind(context.Background(), "instance1", "binding1", details, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(group.members["-instance1"]) != 0 {
+		t.Errorf("expected the user to leave the group, got %v", group.members)
+	}
+
+	if _, err := b.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{PlanID: "planid1"}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := group.policies["-instance1"]; ok {
+		t.Errorf("expected the group to be deleted")
+	}
+
+	// Deprovisioning an instance that never had a group succeeds.
+	if _, err := b.Deprovision(context.Background(), "instance2", domain.DeprovisionDetails{PlanID: "planid1"}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
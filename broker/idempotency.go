@@ -0,0 +1,176 @@
+package broker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+var ErrRequestNotFound = errors.New("request not found")
+
+// RequestStore keeps a fingerprint of the request that created each instance
+// and binding, so a replay of the same request can be told apart from a
+// conflicting one.
+type RequestStore interface {
+	GetRequest(key string) (string, error)
+	SaveRequest(key, fingerprint string) error
+	DeleteRequest(key string) error
+}
+
+// MemoryRequestStore keeps request fingerprints in process memory. After a
+// restart, replayed provisions reconcile the bucket instead of returning 200.
+type MemoryRequestStore struct {
+	mu       sync.Mutex
+	requests map[string]string
+}
+
+func NewMemoryRequestStore() *MemoryRequestStore {
+	return &MemoryRequestStore{
+		requests: make(map[string]string),
+	}
+}
+
+func (m *MemoryRequestStore) GetRequest(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fingerprint, ok := m.requests[key]
+	if !ok {
+		return "", ErrRequestNotFound
+	}
+	return fingerprint, nil
+}
+
+func (m *MemoryRequestStore) SaveRequest(key, fingerprint string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[key] = fingerprint
+	return nil
+}
+
+func (m *MemoryRequestStore) DeleteRequest(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.requests, key)
+	return nil
+}
+
+func instanceRequestKey(instanceID string) string {
+	return "instances/" + instanceID
+}
+
+func bindingRequestKey(bindingID string) string {
+	return "bindings/" + bindingID
+}
+
+// requestFingerprint hashes the parts of a request that must match for it to
+// count as a replay. Parameters are compared as JSON values, so formatting
+// and key order do not matter. Parameters that are not valid JSON are
+// compared as they are; the request fails validation anyway.
+func requestFingerprint(rawParameters json.RawMessage, parts ...string) string {
+	var parameters any
+	if len(rawParameters) > 0 {
+		if err := json.Unmarshal(rawParameters, &parameters); err != nil {
+			parameters = string(rawParameters)
+		}
+	}
+	encoded, _ := json.Marshal(struct {
+		Parts      []string
+		Parameters any
+	}{parts, parameters})
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+func provisionFingerprint(details domain.ProvisionDetails) string {
+	return requestFingerprint(details.RawParameters, details.ServiceID, details.PlanID, details.OrganizationGUID, details.SpaceGUID)
+}
+
+func bindFingerprint(instanceID string, details domain.BindDetails) string {
+	return requestFingerprint(details.RawParameters, instanceID, details.ServiceID, details.PlanID, bindAppGUID(details))
+}
+
+// checkProvisionReplay reports whether an identical provision already created
+// the instance, and returns the OSB conflict error if a different one did.
+func (b *S3Broker) checkProvisionReplay(instanceID, fingerprint string) (bool, error) {
+	if b.requests == nil {
+		return false, nil
+	}
+	stored, err := b.requests.GetRequest(instanceRequestKey(instanceID))
+	if errors.Is(err, ErrRequestNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if stored != fingerprint {
+		return false, apiresponses.ErrInstanceAlreadyExists
+	}
+	return true, nil
+}
+
+// checkBindReplay returns the response of an identical bind that already
+// created the binding, and the OSB conflict error if a different one did.
+func (b *S3Broker) checkBindReplay(bindingID, fingerprint string) (*domain.Binding, error) {
+	if b.requests == nil || b.bindings == nil {
+		return nil, nil
+	}
+	stored, err := b.requests.GetRequest(bindingRequestKey(bindingID))
+	if errors.Is(err, ErrRequestNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored != fingerprint {
+		return nil, apiresponses.ErrBindingAlreadyExists
+	}
+
+	storedBinding, err := b.bindings.GetBinding(bindingID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := b.renderStoredBinding(storedBinding, false)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.Binding{Credentials: credentials, AlreadyExists: true}, nil
+}
+
+// saveRequest records the fingerprint of a request that succeeded. Failing to
+// record it only costs idempotency, so the request still succeeds.
+func (b *S3Broker) saveRequest(key, fingerprint string) {
+	if b.requests == nil {
+		return
+	}
+	if err := b.requests.SaveRequest(key, fingerprint); err != nil {
+		b.logger.Error("save-request", err, lager.Data{"key": key})
+	}
+}
+
+// knownRequest reports whether the broker recorded the request that created
+// an instance or binding. Without a store every request counts as known.
+func (b *S3Broker) knownRequest(key string) (bool, error) {
+	if b.requests == nil {
+		return true, nil
+	}
+	_, err := b.requests.GetRequest(key)
+	if errors.Is(err, ErrRequestNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// forgetRequest removes the fingerprint of the request that created an
+// instance or binding once it is deleted.
+func (b *S3Broker) forgetRequest(key string) error {
+	if b.requests == nil {
+		return nil
+	}
+	return b.requests.DeleteRequest(key)
+}
@@ -0,0 +1,213 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+func withRequests(b *S3Broker) {
+	b.catalog = &mockCatalog{planName: "plan1", serviceName: "service1", s3Properties: S3Properties{IamPolicy: "{}"}}
+	b.bucketPrefix = "prefix"
+	b.bindings = NewMemoryBindingStore()
+	b.requests = NewMemoryRequestStore()
+	b.allowUserProvisionParameters = true
+}
+
+func TestProvisionReplay(t *testing.T) {
+	testCases := map[string]struct {
+		replay      domain.ProvisionDetails
+		deprovision bool
+		expectErr   error
+		expectExist bool
+	}{
+		"identical request": {
+			replay: domain.ProvisionDetails{
+				ServiceID:     "service1",
+				PlanID:        "plan1",
+				RawParameters: json.RawMessage(`{ "object_ownership":"BucketOwnerEnforced" }`),
+			},
+			expectExist: true,
+		},
+		"different parameters": {
+			replay: domain.ProvisionDetails{
+				ServiceID:     "service1",
+				PlanID:        "plan1",
+				RawParameters: json.RawMessage(`{"object_ownership": "ObjectWriter"}`),
+			},
+			expectErr: apiresponses.ErrInstanceAlreadyExists,
+		},
+		"different space": {
+			replay: domain.ProvisionDetails{
+				ServiceID:     "service1",
+				PlanID:        "plan1",
+				SpaceGUID:     "space2",
+				RawParameters: json.RawMessage(`{"object_ownership": "BucketOwnerEnforced"}`),
+			},
+			expectErr: apiresponses.ErrInstanceAlreadyExists,
+		},
+		"after deprovision": {
+			replay: domain.ProvisionDetails{
+				ServiceID:     "service1",
+				PlanID:        "plan1",
+				RawParameters: json.RawMessage(`{"object_ownership": "ObjectWriter"}`),
+			},
+			deprovision: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withRequests)
+			_, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{
+				ServiceID:     "service1",
+				PlanID:        "plan1",
+				RawParameters: json.RawMessage(`{"object_ownership": "BucketOwnerEnforced"}`),
+			}, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.deprovision {
+				if _, err := b.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{ServiceID: "service1", PlanID: "plan1"}, false); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			spec, err := b.Provision(context.Background(), "instance1", tc.replay, false)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if spec.AlreadyExists != tc.expectExist {
+				t.Errorf("expected AlreadyExists %t, got %t", tc.expectExist, spec.AlreadyExists)
+			}
+		})
+	}
+}
+
+func TestBindReplay(t *testing.T) {
+	testCases := map[string]struct {
+		replay      domain.BindDetails
+		instanceID  string
+		expectErr   error
+		expectExist bool
+	}{
+		"identical request": {
+			replay: domain.BindDetails{
+				ServiceID:     "service1",
+				PlanID:        "plan1",
+				RawParameters: json.RawMessage(`{"permissions":"read-only"}`),
+			},
+			expectExist: true,
+		},
+		"different parameters": {
+			replay: domain.BindDetails{
+				ServiceID: "service1",
+				PlanID:    "plan1",
+			},
+			expectErr: apiresponses.ErrBindingAlreadyExists,
+		},
+		"different instance": {
+			replay: domain.BindDetails{
+				ServiceID:     "service1",
+				PlanID:        "plan1",
+				RawParameters: json.RawMessage(`{"permissions": "read-only"}`),
+			},
+			instanceID: "instance2",
+			expectErr:  apiresponses.ErrBindingAlreadyExists,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withRequests)
+			original, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				ServiceID:     "service1",
+				PlanID:        "plan1",
+				RawParameters: json.RawMessage(`{"permissions": "read-only"}`),
+			}, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			instanceID := tc.instanceID
+			if instanceID == "" {
+				instanceID = "instance1"
+			}
+			binding, err := b.Bind(context.Background(), instanceID, "binding1", tc.replay, false)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if binding.AlreadyExists != tc.expectExist {
+				t.Errorf("expected AlreadyExists %t, got %t", tc.expectExist, binding.AlreadyExists)
+			}
+			// The replay must not create another access key.
+			if diff := cmp.Diff(original.Credentials, binding.Credentials); diff != "" {
+				t.Errorf("unexpected credentials (-original +replay):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnbindReplay(t *testing.T) {
+	testCases := map[string]struct {
+		bind      bool
+		unbind    bool
+		expectErr error
+	}{
+		"bound": {
+			bind: true,
+		},
+		"already unbound": {
+			bind:      true,
+			unbind:    true,
+			expectErr: apiresponses.ErrBindingDoesNotExist,
+		},
+		"never bound": {
+			expectErr: apiresponses.ErrBindingDoesNotExist,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withRequests)
+			user := b.user.(*mockUser)
+			unbindDetails := domain.UnbindDetails{ServiceID: "service1", PlanID: "plan1"}
+			if tc.bind {
+				if _, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{ServiceID: "service1", PlanID: "plan1"}, false); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+			if tc.unbind {
+				if _, err := b.Unbind(context.Background(), "instance1", "binding1", unbindDetails, false); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+			user.missing = !tc.bind || tc.unbind
+
+			_, err := b.Unbind(context.Background(), "instance1", "binding1", unbindDetails, false)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
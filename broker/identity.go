@@ -0,0 +1,139 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pivotal-cf/brokerapi/v10/middlewares"
+)
+
+// CreatedByTagKey tags buckets and IAM principals with the identity of the
+// user who created them, and UpdatedByTagKey tags buckets with the user who
+// last updated them.
+const (
+	CreatedByTagKey = "Created by"
+	UpdatedByTagKey = "Updated by"
+)
+
+const originatingIdentityLogKey = "originating-identity"
+
+// maxTagValueLength is the longest value S3 and IAM tags may have.
+const maxTagValueLength = 256
+
+// invalidTagValueChars matches characters that S3 and IAM tag values cannot
+// hold.
+var invalidTagValueChars = regexp.MustCompile(`[^\p{L}\p{Z}\p{N}_.:/=+\-@]`)
+
+// OriginatingIdentity is the user on whose behalf the platform sent a
+// request, from the X-Broker-API-Originating-Identity header.
+type OriginatingIdentity struct {
+	// Platform is the platform that identified the user, such as
+	// "cloudfoundry" or "kubernetes".
+	Platform string
+	// User is the Cloud Foundry user GUID or the Kubernetes username.
+	User string
+}
+
+// String returns the identity as <platform>/<user>, or "" if there is none.
+func (i OriginatingIdentity) String() string {
+	if i.User == "" {
+		return ""
+	}
+	return i.Platform + "/" + i.User
+}
+
+// tagValue returns the identity in a form that S3 and IAM accept as a tag
+// value.
+func (i OriginatingIdentity) tagValue() string {
+	value := invalidTagValueChars.ReplaceAllString(i.String(), "_")
+	if runes := []rune(value); len(runes) > maxTagValueLength {
+		value = string(runes[:maxTagValueLength])
+	}
+	return value
+}
+
+// parseOriginatingIdentity parses the value of an originating identity
+// header: the platform, a space, and base64-encoded JSON properties of the
+// user. Cloud Foundry sends the user's user_id; Kubernetes sends a username.
+func parseOriginatingIdentity(header string) (OriginatingIdentity, error) {
+	platform, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || platform == "" {
+		return OriginatingIdentity{}, errors.New("originating identity must be a platform and a value")
+	}
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return OriginatingIdentity{}, err
+	}
+	var properties struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(value, &properties); err != nil {
+		return OriginatingIdentity{}, err
+	}
+
+	user := properties.UserID
+	if user == "" {
+		user = properties.Username
+	}
+	if user == "" {
+		return OriginatingIdentity{}, errors.New("originating identity has no user_id or username")
+	}
+	return OriginatingIdentity{Platform: platform, User: user}, nil
+}
+
+// originatingIdentity returns the identity that brokerapi put in the request
+// context. Requests without a valid header have no identity.
+func (b *S3Broker) originatingIdentity(ctx context.Context) OriginatingIdentity {
+	header, _ := ctx.Value(middlewares.OriginatingIdentityKey).(string)
+	if header == "" {
+		return OriginatingIdentity{}
+	}
+	identity, err := parseOriginatingIdentity(header)
+	if err != nil {
+		b.logger.Info("invalid-originating-identity", lager.Data{"error": err.Error()})
+		return OriginatingIdentity{}
+	}
+	return identity
+}
+
+// auditLog records that the originating identity of ctx requested action.
+func (b *S3Broker) auditLog(ctx context.Context, action string, data lager.Data) {
+	data["action"] = action
+	data[originatingIdentityLogKey] = b.originatingIdentity(ctx).String()
+	b.logger.Info("audit", data)
+}
+
+// addIdentityTag sets key in tags to the originating identity of ctx, if
+// there is one, and returns tags.
+func (b *S3Broker) addIdentityTag(ctx context.Context, tags map[string]string, key string) map[string]string {
+	identity := b.originatingIdentity(ctx)
+	if identity.User == "" {
+		return tags
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags[key] = identity.tagValue()
+	return tags
+}
+
+// identityIAMTags returns a created-by tag for IAM principals, or nothing if
+// ctx has no originating identity.
+func (b *S3Broker) identityIAMTags(ctx context.Context) []*iam.Tag {
+	identity := b.originatingIdentity(ctx)
+	if identity.User == "" {
+		return nil
+	}
+	return []*iam.Tag{{
+		Key:   aws.String(CreatedByTagKey),
+		Value: aws.String(identity.tagValue()),
+	}}
+}
@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pivotal-cf/brokerapi/v10/middlewares"
+)
+
+func TestParseOriginatingIdentity(t *testing.T) {
+	encode := func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	testCases := map[string]struct {
+		header    string
+		expect    OriginatingIdentity
+		expectErr bool
+	}{
+		"cloud foundry user": {
+			header: "cloudfoundry " + encode(`{"user_id": "683ea748-3092-4ff4-b656-39cacc4d5360"}`),
+			expect: OriginatingIdentity{Platform: "cloudfoundry", User: "683ea748-3092-4ff4-b656-39cacc4d5360"},
+		},
+		"kubernetes user": {
+			header: "kubernetes " + encode(`{"username": "system:serviceaccount:ns:deployer", "uid": "1", "groups": ["admin"]}`),
+			expect: OriginatingIdentity{Platform: "kubernetes", User: "system:serviceaccount:ns:deployer"},
+		},
+		"no value": {
+			header:    "cloudfoundry",
+			expectErr: true,
+		},
+		"not base64": {
+			header:    "cloudfoundry {}",
+			expectErr: true,
+		},
+		"no user": {
+			header:    "cloudfoundry " + encode(`{}`),
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			identity, err := parseOriginatingIdentity(tc.header)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if identity != tc.expect {
+				t.Errorf("expected %+v, got %+v", tc.expect, identity)
+			}
+		})
+	}
+}
+
+func TestOriginatingIdentityTagValue(t *testing.T) {
+	testCases := map[string]struct {
+		identity    OriginatingIdentity
+		expectValue string
+	}{
+		"invalid characters": {
+			identity:    OriginatingIdentity{Platform: "kubernetes", User: "jane#doe@example.com"},
+			expectValue: "kubernetes/jane_doe@example.com",
+		},
+		"long non-ASCII user": {
+			identity:    OriginatingIdentity{Platform: "kubernetes", User: strings.Repeat("é", 300)},
+			expectValue: "kubernetes/" + strings.Repeat("é", maxTagValueLength-len("kubernetes/")),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			value := tc.identity.tagValue()
+			if value != tc.expectValue {
+				t.Errorf("unexpected tag value %q", value)
+			}
+			if !utf8.ValidString(value) {
+				t.Errorf("tag value %q is not valid UTF-8", value)
+			}
+		})
+	}
+}
+
+func TestBindingTagsCreatedBy(t *testing.T) {
+	b := &S3Broker{
+		logger:     lager.NewLogger("broker-unit-test-identity"),
+		tagManager: &mockTagGenerator{},
+	}
+	header := "cloudfoundry " + base64.StdEncoding.EncodeToString([]byte(`{"user_id": "user1"}`))
+	ctx := context.WithValue(context.Background(), middlewares.OriginatingIdentityKey, header)
+
+	tags, err := b.bindingTags(ctx, Service{}, ServicePlan{}, "instance1", "binding1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var createdBy string
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == CreatedByTagKey {
+			createdBy = aws.StringValue(tag.Value)
+		}
+	}
+	if createdBy != "cloudfoundry/user1" {
+		t.Errorf("expected created by tag cloudfoundry/user1, got %q", createdBy)
+	}
+}
@@ -0,0 +1,178 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"strconv"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+// Policy modes summarize an instance's bucket policy in GetInstance.
+const (
+	policyModePrivate    = "private"
+	policyModePublicRead = "public-read"
+	policyModeCustom     = "custom"
+)
+
+// GetInstance returns the instance's current parameters, read back from its
+// bucket, and a summary of the bucket's configuration and last measured usage
+// in the metadata labels and attributes. Platforms that do not send the plan
+// ID get the plan recorded in the state store, or else the plan named by the
+// bucket's tags.
+func (b *S3Broker) GetInstance(
+	ctx context.Context,
+	instanceID string,
+	details domain.FetchInstanceDetails,
+) (domain.GetInstanceDetailsSpec, error) {
+	b = b.forRequest(ctx)
+	b.logger.Debug("get-instance", lager.Data{
+		instanceIDLogKey: instanceID,
+		detailsLogKey:    details,
+	})
+
+	serviceID, planID := details.ServiceID, details.PlanID
+	var bucketName string
+	if instance, ok := b.instanceState(ctx, instanceID); ok {
+		if serviceID == "" {
+			serviceID = instance.ServiceID
+		}
+		if planID == "" {
+			planID = instance.PlanID
+		}
+		bucketName = instance.BucketName
+	}
+	servicePlan, _ := b.catalog.FindServicePlan(planID)
+	b = b.forPlan(servicePlan)
+	if bucketName == "" {
+		var err error
+		if bucketName, err = b.instanceBucketName(ctx, instanceID, servicePlan); err != nil {
+			return domain.GetInstanceDetailsSpec{}, mapBucketError(err)
+		}
+	}
+	bucketDetails, err := b.bucket.DescribeConfiguration(ctx, bucketName, b.awsPartition)
+	if err != nil {
+		return domain.GetInstanceDetailsSpec{}, mapBucketError(err)
+	}
+
+	if planID == "" {
+		planID = b.planIDFromTags(bucketDetails.Tags)
+	}
+
+	parameters := map[string]interface{}{
+		"cors_rules":      bucketDetails.CORSRules,
+		"lifecycle_rules": bucketDetails.LifecycleRules,
+	}
+	if bucketDetails.ObjectOwnership != "" {
+		parameters["object_ownership"] = bucketDetails.ObjectOwnership
+	}
+	if preserve, err := strconv.ParseBool(bucketDetails.Tags[PreserveOnDeleteTagKey]); err == nil {
+		parameters["preserve_on_delete"] = preserve
+	}
+	if threshold, err := strconv.ParseFloat(bucketDetails.Tags[AlertThresholdTagKey], 64); err == nil && threshold > 0 {
+		parameters["alert_threshold_gb"] = threshold
+	}
+
+	versioning := "disabled"
+	if bucketDetails.Versioning {
+		versioning = "enabled"
+	}
+
+	attributes := map[string]string{
+		"bucket":      bucketName,
+		"region":      bucketDetails.Region,
+		"bucket_url":  bucketDetails.VirtualHostedURL,
+		"encryption":  encryptionAlgorithm(bucketDetails.Encryption),
+		"versioning":  versioning,
+		"policy_mode": policyMode(bucketDetails.Policy),
+	}
+	if version := bucketDetails.Tags[MaintenanceVersionTagKey]; version != "" {
+		attributes["maintenance_version"] = version
+	}
+	maps.Copy(attributes, b.usageAttributes(ctx, instanceID))
+
+	spec := domain.GetInstanceDetailsSpec{
+		ServiceID:    serviceID,
+		PlanID:       planID,
+		DashboardURL: b.instanceDashboardURL(instanceID),
+		Parameters:   parameters,
+	}
+	if supportsAPIVersion(ctx, apiVersionInstanceMetadata) {
+		spec.Metadata = domain.InstanceMetadata{
+			Labels:     instanceLabels(bucketDetails.Region, bucketDetails.Encryption, bucketDetails.Policy),
+			Attributes: attributes,
+		}
+	}
+	return spec, nil
+}
+
+// planIDFromTags returns the ID of the plan named by a bucket's tags, or ""
+// if there is no such plan.
+func (b *S3Broker) planIDFromTags(tags map[string]string) string {
+	planName := tags[brokertags.ServicePlanName]
+	for _, plan := range b.catalog.ListServicePlans() {
+		if planName != "" && plan.Name == planName {
+			return plan.ID
+		}
+	}
+	return ""
+}
+
+// encryptionAlgorithm returns the default server-side encryption algorithm
+// of an encryption configuration, or "none".
+func encryptionAlgorithm(encryption string) string {
+	var encryptionConfig s3.ServerSideEncryptionConfiguration
+	if err := json.Unmarshal([]byte(encryption), &encryptionConfig); err != nil {
+		return encryptionNone
+	}
+	for _, rule := range encryptionConfig.Rules {
+		if defaults := rule.ApplyServerSideEncryptionByDefault; defaults != nil {
+			return aws.StringValue(defaults.SSEAlgorithm)
+		}
+	}
+	return encryptionNone
+}
+
+// policyMode summarizes a bucket policy. Statements with a Sid, which the
+// broker adds for bindings, are left out, so a bucket is private unless its
+// plan's policy grants access.
+func policyMode(policy string) string {
+	var document struct {
+		Statement []json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return policyModePrivate
+	}
+
+	mode := policyModePrivate
+	for _, raw := range document.Statement {
+		var statement struct {
+			Sid       string
+			Effect    string
+			Principal any
+		}
+		if err := json.Unmarshal(raw, &statement); err != nil || statement.Sid != "" {
+			continue
+		}
+		if statement.Effect == "Allow" && isAnonymousPrincipal(statement.Principal) {
+			return policyModePublicRead
+		}
+		mode = policyModeCustom
+	}
+	return mode
+}
+
+func isAnonymousPrincipal(principal any) bool {
+	switch p := principal.(type) {
+	case string:
+		return p == "*"
+	case map[string]any:
+		return p["AWS"] == "*"
+	}
+	return false
+}
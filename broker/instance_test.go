@@ -0,0 +1,93 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+func TestGetInstance(t *testing.T) {
+	bucket := &mockBucket{describeDetails: awss3.BucketDetails{
+		Region:           "us-gov-west-1",
+		VirtualHostedURL: "https://cg-instance1.s3.us-gov-west-1.amazonaws.com",
+		Encryption:       `{"Rules":[{"ApplyServerSideEncryptionByDefault":{"SSEAlgorithm":"aws:kms","KMSMasterKeyID":"key1"}}]}`,
+		Versioning:       true,
+		ObjectOwnership:  "BucketOwnerEnforced",
+		Policy:           `{"Statement":[{"Sid":"Bindingbinding1","Effect":"Allow","Principal":{"AWS":"*"}},{"Effect":"Allow","Principal":"*","Action":"s3:GetObject"}]}`,
+		Tags:             map[string]string{brokertags.ServicePlanName: "basic-public"},
+		CORSRules:        []awss3.CORSRule{},
+		LifecycleRules:   []awss3.LifecycleRule{{ID: "expire", ExpirationDays: 30}},
+	}}
+	b := &S3Broker{
+		logger:       lager.NewLogger("broker-unit-test-get-instance"),
+		bucket:       bucket,
+		catalog:      BrokerCatalog{Services: []Service{{ID: "service1", Plans: []ServicePlan{{ID: "plan1", Name: "basic-public"}}}}},
+		bucketPrefix: "cg",
+		awsPartition: "aws-us-gov",
+	}
+
+	spec, err := b.GetInstance(context.Background(), "instance1", domain.FetchInstanceDetails{ServiceID: "service1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := domain.GetInstanceDetailsSpec{
+		ServiceID: "service1",
+		PlanID:    "plan1",
+		Parameters: map[string]interface{}{
+			"object_ownership": "BucketOwnerEnforced",
+			"cors_rules":       []awss3.CORSRule{},
+			"lifecycle_rules":  []awss3.LifecycleRule{{ID: "expire", ExpirationDays: 30}},
+		},
+		Metadata: domain.InstanceMetadata{
+			Labels: map[string]string{
+				"region":     "us-gov-west-1",
+				"encryption": "aws-kms",
+				"access":     "public-read",
+			},
+			Attributes: map[string]string{
+				"bucket":      "cg-instance1",
+				"region":      "us-gov-west-1",
+				"bucket_url":  "https://cg-instance1.s3.us-gov-west-1.amazonaws.com",
+				"encryption":  "aws:kms",
+				"versioning":  "enabled",
+				"policy_mode": "public-read",
+			},
+		},
+	}
+	if diff := cmp.Diff(expected, spec); diff != "" {
+		t.Errorf("unexpected instance (-want +got):\n%s", diff)
+	}
+
+	bucket.describeErr = awss3.ErrBucketNotFound
+	if _, err := b.GetInstance(context.Background(), "instance1", domain.FetchInstanceDetails{}); !errors.Is(err, apiresponses.ErrInstanceDoesNotExist) {
+		t.Errorf("expected ErrInstanceDoesNotExist, got %v", err)
+	}
+}
+
+func TestPolicyMode(t *testing.T) {
+	testCases := map[string]struct {
+		policy string
+		expect string
+	}{
+		"no policy":               {policy: "", expect: policyModePrivate},
+		"only binding statements": {policy: `{"Statement":[{"Sid":"Bindingbinding1","Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111111111111:root"}}]}`, expect: policyModePrivate},
+		"public read":             {policy: `{"Statement":[{"Effect":"Allow","Principal":"*","Action":["s3:GetObject"]}]}`, expect: policyModePublicRead},
+		"deny statement":          {policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":["s3:DeleteObject"]}]}`, expect: policyModeCustom},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if mode := policyMode(tc.policy); mode != tc.expect {
+				t.Errorf("expected %s, got %s", tc.expect, mode)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package broker
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// grantKeyAccess lets a binding's user or role use the customer-managed KMS
+// key that encrypts the plan's buckets. S3 permissions alone are not enough
+// to read or write objects encrypted with such a key.
+func (b *S3Broker) grantKeyAccess(ctx context.Context, plan ServicePlan, bindingID, principalARN string) error {
+	keyID := plan.S3Properties.KMSKeyID()
+	if b.grants == nil || keyID == "" {
+		return nil
+	}
+	if err := b.grants.Create(ctx, keyID, principalARN, b.grantName(bindingID)); err != nil {
+		b.logger.Error("bind: error creating kms grant", err, lager.Data{
+			bindingIDLogKey: bindingID,
+			"key":           keyID,
+		})
+		return err
+	}
+	return nil
+}
+
+// retireKeyAccess retires the grants that grantKeyAccess created.
+func (b *S3Broker) retireKeyAccess(ctx context.Context, plan ServicePlan, bindingID string) error {
+	keyID := plan.S3Properties.KMSKeyID()
+	if b.grants == nil || keyID == "" {
+		return nil
+	}
+	return b.grants.Retire(ctx, keyID, b.grantName(bindingID))
+}
+
+func (b *S3Broker) grantName(bindingID string) string {
+	return b.userName(bindingID)
+}
@@ -0,0 +1,175 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+const testKMSEncryption = `{"Rules": [{"ApplyServerSideEncryptionByDefault": {"SSEAlgorithm": "aws:kms", "KMSMasterKeyID": "alias/bucket-key"}}]}`
+
+type mockGrants struct {
+	createErr error
+	grants    map[string]string
+	retired   []string
+}
+
+func (g *mockGrants) Create(ctx context.Context, keyID, granteePrincipal, name string) error {
+	if g.createErr != nil {
+		return g.createErr
+	}
+	if g.grants == nil {
+		g.grants = make(map[string]string)
+	}
+	g.grants[keyID+"/"+name] = granteePrincipal
+	return nil
+}
+
+func (g *mockGrants) Retire(ctx context.Context, keyID, name string) error {
+	delete(g.grants, keyID+"/"+name)
+	g.retired = append(g.retired, keyID+"/"+name)
+	return nil
+}
+
+func TestKMSKeyID(t *testing.T) {
+	testCases := map[string]struct {
+		encryption string
+		expect     string
+	}{
+		"customer-managed key": {
+			encryption: testKMSEncryption,
+			expect:     "alias/bucket-key",
+		},
+		"dual-layer": {
+			encryption: `{"Rules": [{"ApplyServerSideEncryptionByDefault": {"SSEAlgorithm": "aws:kms:dsse", "KMSMasterKeyID": "key-id"}}]}`,
+			expect:     "key-id",
+		},
+		"aws managed key": {
+			encryption: `{"Rules": [{"ApplyServerSideEncryptionByDefault": {"SSEAlgorithm": "aws:kms"}}]}`,
+		},
+		"sse-s3": {
+			encryption: `{"Rules": [{"ApplyServerSideEncryptionByDefault": {"SSEAlgorithm": "AES256"}}]}`,
+		},
+		"no encryption": {},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if keyID := (S3Properties{Encryption: tc.encryption}).KMSKeyID(); keyID != tc.expect {
+				t.Errorf("expected key %q, got %q", tc.expect, keyID)
+			}
+		})
+	}
+}
+
+func TestBindKMSGrants(t *testing.T) {
+	testCases := map[string]struct {
+		user          *mockUser
+		role          *mockRole
+		grants        *mockGrants
+		parameters    string
+		encryption    string
+		expectErr     string
+		expectGrantee string
+		expectRetired bool
+	}{
+		"user": {
+			user:          &mockUser{},
+			grants:        &mockGrants{},
+			parameters:    `{}`,
+			encryption:    testKMSEncryption,
+			expectGrantee: "arn:aws:iam::000000000000:user/-binding1",
+		},
+		"role": {
+			user:          &mockUser{},
+			role:          &mockRole{},
+			grants:        &mockGrants{},
+			parameters:    `{"credential_type": "role", "principal": "123456789012", "external_id": "ext-id"}`,
+			encryption:    testKMSEncryption,
+			expectGrantee: "arn:aws:iam::000000000000:role/-binding1",
+		},
+		"no customer-managed key": {
+			user:       &mockUser{},
+			grants:     &mockGrants{},
+			parameters: `{}`,
+		},
+		"grant fails": {
+			user:       &mockUser{},
+			grants:     &mockGrants{createErr: NewTestErr("grant error")},
+			parameters: `{}`,
+			encryption: testKMSEncryption,
+			expectErr:  "grant error",
+		},
+		"user policy fails": {
+			user:          &mockUser{putUserPolicyErr: NewTestErr("put user policy error")},
+			grants:        &mockGrants{},
+			parameters:    `{}`,
+			encryption:    testKMSEncryption,
+			expectErr:     "put user policy error",
+			expectRetired: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger: lager.NewLogger("broker-unit-test-kms"),
+				bucket: &mockBucket{},
+				catalog: &mockCatalog{
+					planName:     "plan1",
+					serviceName:  "service1",
+					s3Properties: S3Properties{Encryption: tc.encryption},
+				},
+				tagManager:   &mockTagGenerator{},
+				user:         tc.user,
+				grants:       tc.grants,
+				awsPartition: "aws",
+			}
+			if tc.role != nil {
+				b.role = tc.role
+			}
+
+			_, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(tc.parameters),
+			}, false)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected err %s, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			grantee := tc.grants.grants["alias/bucket-key/-binding1"]
+			if grantee != tc.expectGrantee {
+				t.Errorf("expected grantee %q, got %q", tc.expectGrantee, grantee)
+			}
+			if retired := len(tc.grants.retired) > 0; retired != tc.expectRetired {
+				t.Errorf("expected retired %t, got %v", tc.expectRetired, tc.grants.retired)
+			}
+		})
+	}
+}
+
+func TestUnbindRetiresKMSGrants(t *testing.T) {
+	grants := &mockGrants{grants: map[string]string{"alias/bucket-key/-binding1": "arn:aws:iam::000000000000:user/-binding1"}}
+	b := &S3Broker{
+		logger: lager.NewLogger("broker-unit-test-kms"),
+		catalog: &mockCatalog{
+			planName:     "plan1",
+			serviceName:  "service1",
+			s3Properties: S3Properties{Encryption: testKMSEncryption},
+		},
+		user:   &mockUser{exists: true},
+		grants: grants,
+	}
+	if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{PlanID: "planid1"}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(grants.grants) != 0 {
+		t.Errorf("expected grants to be retired, got %v", grants.grants)
+	}
+}
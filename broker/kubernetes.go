@@ -0,0 +1,111 @@
+package broker
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+)
+
+// PlatformKubernetes is the platform in the OSB context of requests from
+// Kubernetes, such as through the Service Catalog or the cloud-service-broker
+// adapters.
+const PlatformKubernetes = "kubernetes"
+
+// Tags on the buckets and binding principals of requests from Kubernetes,
+// which take the place of the organization and space tags.
+const (
+	KubernetesClusterIDTagKey = "Kubernetes cluster ID"
+	KubernetesNamespaceTagKey = "Kubernetes namespace"
+)
+
+// kubernetesClient is the client tag of requests from Kubernetes.
+const kubernetesClient = "Kubernetes"
+
+// annotationKeyPattern matches Kubernetes annotation keys: a name, with an
+// optional DNS subdomain prefix.
+var annotationKeyPattern = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+
+// kubernetesAnnotationDomains are the annotation prefixes that Kubernetes
+// reserves for its own components.
+var kubernetesAnnotationDomains = []string{"kubernetes.io", "k8s.io"}
+
+// isKubernetes reports whether the context is of a request from Kubernetes.
+func (c BindContext) isKubernetes() bool {
+	return c.Platform == PlatformKubernetes
+}
+
+// cfPlace returns the organization and space GUIDs of a provision or update
+// request. Kubernetes has no organizations or spaces, and adapters fill the
+// fields with IDs of their own, so requests from Kubernetes have neither.
+func cfPlace(organizationGUID, spaceGUID string, rawContext json.RawMessage) (string, string) {
+	// Contexts that cannot be parsed are rejected where they are used.
+	platformContext, _ := parseBindContext(rawContext)
+	if platformContext.isKubernetes() {
+		return "", ""
+	}
+	return organizationGUID, spaceGUID
+}
+
+// addPlatformTags replaces the Cloud Foundry client tag on the tags of a
+// request from Kubernetes, and tags its cluster and namespace.
+func addPlatformTags(tags map[string]string, platformContext BindContext) map[string]string {
+	if !platformContext.isKubernetes() {
+		return tags
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags[brokertags.ClientTagKey] = kubernetesClient
+	if platformContext.ClusterID != "" {
+		tags[KubernetesClusterIDTagKey] = platformContext.ClusterID
+	}
+	if platformContext.Namespace != "" {
+		tags[KubernetesNamespaceTagKey] = platformContext.Namespace
+	}
+	return tags
+}
+
+// addAnnotations merges the annotations parameter of a provision or update,
+// Kubernetes annotations that adapters pass along with a resource, into its
+// tags parameter. Annotations that Kubernetes reserves, such as
+// kubectl.kubernetes.io/last-applied-configuration, are left out.
+func addAnnotations(userTags, annotations map[string]string) (map[string]string, error) {
+	if len(annotations) == 0 {
+		return userTags, nil
+	}
+	merged := make(map[string]string, len(userTags)+len(annotations))
+	for key, value := range userTags {
+		merged[key] = value
+	}
+	for key, value := range annotations {
+		if !annotationKeyPattern.MatchString(key) || len(key) > maxTagKeyLength {
+			return nil, invalidTags("annotation key %q is not a valid Kubernetes annotation key", key)
+		}
+		if isKubernetesAnnotation(key) {
+			continue
+		}
+		if _, ok := merged[key]; ok {
+			return nil, invalidTags("annotation %q is also given as a tag", key)
+		}
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// isKubernetesAnnotation reports whether an annotation key is under a prefix
+// that Kubernetes reserves, including subdomains such as
+// kubectl.kubernetes.io.
+func isKubernetesAnnotation(key string) bool {
+	prefix, _, found := strings.Cut(key, "/")
+	if !found {
+		return false
+	}
+	for _, domain := range kubernetesAnnotationDomains {
+		if prefix == domain || strings.HasSuffix(prefix, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+const kubernetesContext = `{"platform": "kubernetes", "namespace": "team-a", "clusterid": "cluster1"}`
+
+func TestCreateBucketKubernetes(t *testing.T) {
+	tagManager := &mockTagGenerator{tags: map[string]string{brokertags.ClientTagKey: "Cloud Foundry"}}
+	b := &S3Broker{catalog: &mockCatalog{serviceName: "service-1"}, tagManager: tagManager}
+	details, err := b.createBucket(
+		context.Background(),
+		"instance1",
+		ServicePlan{ID: "plan-1", Name: "plan"},
+		ProvisionParameters{
+			Tags:        map[string]string{"team": "data"},
+			Annotations: map[string]string{"example.com/owner": "alice", "kubectl.kubernetes.io/last-applied-configuration": "{}"},
+		},
+		domain.ProvisionDetails{
+			// Adapters fill the organization and space with IDs of the
+			// cluster and namespace.
+			OrganizationGUID: "cluster-uid",
+			SpaceGUID:        "namespace-uid",
+			RawContext:       json.RawMessage(kubernetesContext),
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tagManager.resourceGUIDs != (brokertags.ResourceGUIDs{InstanceGUID: "instance1"}) {
+		t.Errorf("expected no organization or space to be tagged, got %+v", tagManager.resourceGUIDs)
+	}
+	expected := map[string]string{
+		brokertags.ClientTagKey:   "Kubernetes",
+		KubernetesClusterIDTagKey: "cluster1",
+		KubernetesNamespaceTagKey: "team-a",
+		"team":                    "data",
+		"example.com/owner":       "alice",
+	}
+	if diff := cmp.Diff(expected, details.Tags); diff != "" {
+		t.Errorf("unexpected tags (-want +got):\n%s", diff)
+	}
+}
+
+func TestBindingTagsKubernetes(t *testing.T) {
+	tagManager := &mockTagGenerator{}
+	b := &S3Broker{logger: lager.NewLogger("broker-unit-test-kubernetes"), tagManager: tagManager}
+	tags, err := b.bindingTags(context.Background(), Service{}, ServicePlan{}, "instance1", "binding1", json.RawMessage(kubernetesContext))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tagManager.getMissingResources {
+		t.Error("expected the tag manager not to look up the space and organization")
+	}
+	found := make(map[string]string)
+	for _, tag := range tags {
+		found[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	if found[KubernetesClusterIDTagKey] != "cluster1" || found[KubernetesNamespaceTagKey] != "team-a" {
+		t.Errorf("expected cluster and namespace tags, got %v", found)
+	}
+}
+
+func TestBindingPathKubernetes(t *testing.T) {
+	b := &S3Broker{iamPath: "/s3-broker/{{.OrganizationID}}/{{.SpaceID}}/{{.Namespace}}/"}
+	path, err := b.bindingPath("instance1", json.RawMessage(kubernetesContext))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if path != "/s3-broker/cluster1/team-a/team-a/" {
+		t.Errorf("unexpected path %q", path)
+	}
+}
+
+func TestCFPlace(t *testing.T) {
+	testCases := map[string]struct {
+		rawContext         string
+		expectOrganization string
+		expectSpace        string
+	}{
+		"no context":          {expectOrganization: "org1", expectSpace: "space1"},
+		"cloud foundry":       {rawContext: `{"platform": "cloudfoundry"}`, expectOrganization: "org1", expectSpace: "space1"},
+		"kubernetes":          {rawContext: kubernetesContext},
+		"unparseable context": {rawContext: `{"platform": 1}`, expectOrganization: "org1", expectSpace: "space1"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			organizationGUID, spaceGUID := cfPlace("org1", "space1", json.RawMessage(tc.rawContext))
+			if organizationGUID != tc.expectOrganization || spaceGUID != tc.expectSpace {
+				t.Errorf("expected %q and %q, got %q and %q", tc.expectOrganization, tc.expectSpace, organizationGUID, spaceGUID)
+			}
+		})
+	}
+}
+
+func TestAddAnnotations(t *testing.T) {
+	testCases := map[string]struct {
+		tags        map[string]string
+		annotations map[string]string
+		expected    map[string]string
+		expectErr   bool
+	}{
+		"no annotations": {
+			tags:     map[string]string{"team": "data"},
+			expected: map[string]string{"team": "data"},
+		},
+		"annotations": {
+			tags:        map[string]string{"team": "data"},
+			annotations: map[string]string{"example.com/owner": "alice", "cost-center": "42"},
+			expected:    map[string]string{"team": "data", "example.com/owner": "alice", "cost-center": "42"},
+		},
+		"reserved annotations": {
+			annotations: map[string]string{"kubernetes.io/description": "x", "kubectl.kubernetes.io/last-applied-configuration": "{}", "k8s.io/x": "y"},
+			expected:    map[string]string{},
+		},
+		"invalid key": {
+			annotations: map[string]string{"Example.com/owner": "alice"},
+			expectErr:   true,
+		},
+		"also a tag": {
+			tags:        map[string]string{"owner": "bob"},
+			annotations: map[string]string{"owner": "alice"},
+			expectErr:   true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tags, err := addAnnotations(tc.tags, tc.annotations)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(tc.expected, tags); diff != "" {
+				t.Errorf("unexpected tags (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
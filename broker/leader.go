@@ -0,0 +1,90 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+const (
+	// leaderLockKey is the state store lock that the leader holds.
+	leaderLockKey = "leader"
+
+	defaultLeaderLeaseDuration = 30 * time.Second
+)
+
+func (c LeaderElectionConfig) Validate() error {
+	if c.LeaseDuration < 0 {
+		return errors.New("lease_duration must not be negative")
+	}
+	return nil
+}
+
+// isLeader reports whether this broker process runs the background jobs. It
+// always does unless leader election is enabled.
+func (b *S3Broker) isLeader() bool {
+	return !b.leaderElection.Enabled || b.leader.Load()
+}
+
+// StartLeaderElection tries to become the leader, so that a job started right
+// after it returns knows whether to run, and then keeps trying, or renewing
+// the lease, in the background until ctx is done. A leader that stops
+// releases the lease; one that stops without releasing it is replaced once
+// the lease expires. It returns at once if leader election is not enabled.
+func (b *S3Broker) StartLeaderElection(ctx context.Context) {
+	if !b.leaderElection.Enabled {
+		return
+	}
+	locker, ok := b.state.(state.Locker)
+	if !ok {
+		// Without a shared store there are no other brokers to elect.
+		b.leader.Store(true)
+		return
+	}
+	lease := b.leaderElection.LeaseDuration
+	if lease == 0 {
+		lease = defaultLeaderLeaseDuration
+	}
+
+	b.campaign(ctx, locker, lease)
+	go func() {
+		// Renew well before the lease expires, so that a slow store does
+		// not cost the leader its lease.
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if b.leader.Swap(false) {
+					if err := locker.Unlock(context.WithoutCancel(ctx), leaderLockKey, b.lockOwner); err != nil {
+						b.logger.Error("leader-election.release", err)
+					}
+				}
+				return
+			case <-ticker.C:
+				b.campaign(ctx, locker, lease)
+			}
+		}
+	}()
+}
+
+// campaign takes or renews the leader's lease. A broker that cannot reach the
+// store stops leading, since another broker may take the lease when it
+// expires.
+func (b *S3Broker) campaign(ctx context.Context, locker state.Locker, lease time.Duration) {
+	logger := b.logger.Session("leader-election", lager.Data{"owner": b.lockOwner})
+	locked, err := locker.TryLock(ctx, leaderLockKey, b.lockOwner, lease)
+	if err != nil {
+		logger.Error("campaign", err)
+	}
+	switch wasLeader := b.leader.Swap(locked); {
+	case locked && !wasLeader:
+		logger.Info("elected")
+	case !locked && wasLeader:
+		logger.Info("lost-leadership")
+	}
+}
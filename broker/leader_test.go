@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+func TestLeaderElection(t *testing.T) {
+	store := &mockLockingStore{MemoryStore: state.NewMemoryStore(), locks: make(map[string]string)}
+	newBroker := func(owner string) *S3Broker {
+		return &S3Broker{
+			logger:         lager.NewLogger("broker-unit-test-leader"),
+			lockOwner:      owner,
+			state:          store,
+			leaderElection: LeaderElectionConfig{Enabled: true, LeaseDuration: 30 * time.Millisecond},
+			leader:         new(atomic.Bool),
+		}
+	}
+	brokerA, brokerB := newBroker("broker-a"), newBroker("broker-b")
+
+	ctxA, stopA := context.WithCancel(context.Background())
+	defer stopA()
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+
+	brokerA.StartLeaderElection(ctxA)
+	brokerB.StartLeaderElection(ctxB)
+	if !brokerA.isLeader() || brokerB.isLeader() {
+		t.Fatalf("expected only broker-a to lead, got %t and %t", brokerA.isLeader(), brokerB.isLeader())
+	}
+
+	// broker-a keeps renewing its lease.
+	time.Sleep(50 * time.Millisecond)
+	if !brokerA.isLeader() || brokerB.isLeader() {
+		t.Fatalf("expected broker-a to keep leading, got %t and %t", brokerA.isLeader(), brokerB.isLeader())
+	}
+
+	// When broker-a stops, it releases the lease and broker-b takes over.
+	stopA()
+	deadline := time.Now().Add(time.Second)
+	for !brokerB.isLeader() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !brokerB.isLeader() {
+		t.Error("expected broker-b to lead after broker-a stopped")
+	}
+	if brokerA.isLeader() {
+		t.Error("expected broker-a to stop leading")
+	}
+}
+
+func TestLeaderElectionDisabled(t *testing.T) {
+	testCases := map[string]*S3Broker{
+		"disabled": {
+			state: &mockLockingStore{MemoryStore: state.NewMemoryStore(), locks: make(map[string]string)},
+		},
+		"store cannot lock": {
+			state:          state.NewMemoryStore(),
+			leaderElection: LeaderElectionConfig{Enabled: true},
+			leader:         new(atomic.Bool),
+		},
+	}
+	for name, b := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b.logger = lager.NewLogger("broker-unit-test-leader")
+			b.StartLeaderElection(context.Background())
+			if !b.isLeader() {
+				t.Error("expected the broker to run background jobs")
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+// instanceLockTTL bounds how long a state store lock outlives a broker
+// process that stopped without releasing it.
+const instanceLockTTL = 10 * time.Minute
+
+// InstanceLocks keeps track of the instances that an operation is running
+// on, so that concurrent provisions, updates and deprovisions of one instance
+// do not race each other through the S3 API. The locks only cover one broker
+// process.
+type InstanceLocks struct {
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+func NewInstanceLocks() *InstanceLocks {
+	return &InstanceLocks{
+		locked: make(map[string]bool),
+	}
+}
+
+// TryLock locks instanceID and reports whether it was unlocked before.
+func (l *InstanceLocks) TryLock(instanceID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locked[instanceID] {
+		return false
+	}
+	l.locked[instanceID] = true
+	return true
+}
+
+func (l *InstanceLocks) Unlock(instanceID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.locked, instanceID)
+}
+
+// lockInstance locks instanceID until the returned function is called, or
+// returns the OSB ConcurrencyError if another operation holds the lock. If
+// the state store can lock, the instance is also locked in it, so that
+// operations in other broker processes are covered too.
+func (b *S3Broker) lockInstance(instanceID string) (func(), error) {
+	if b.instanceLocks == nil {
+		return func() {}, nil
+	}
+	if !b.instanceLocks.TryLock(instanceID) {
+		return nil, apiresponses.ErrConcurrentInstanceAccess
+	}
+	locker, ok := b.state.(state.Locker)
+	if !ok {
+		return func() { b.instanceLocks.Unlock(instanceID) }, nil
+	}
+
+	key := "instance/" + instanceID
+	locked, err := locker.TryLock(context.Background(), key, b.lockOwner, instanceLockTTL)
+	if err != nil || !locked {
+		b.instanceLocks.Unlock(instanceID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, apiresponses.ErrConcurrentInstanceAccess
+	}
+	return func() {
+		if err := locker.Unlock(context.Background(), key, b.lockOwner); err != nil {
+			b.logger.Error("unlock-instance", err, lager.Data{instanceIDLogKey: instanceID})
+		}
+		b.instanceLocks.Unlock(instanceID)
+	}, nil
+}
+
+// newLockOwner returns a name for this broker process that is unique among
+// those sharing a state store.
+func newLockOwner() string {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(err)
+	}
+	hostname, _ := os.Hostname()
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// checkNoDeprovision returns the OSB ConcurrencyError if the instance's
+// bucket is being deleted in the background.
+func (b *S3Broker) checkNoDeprovision(instanceID string) error {
+	if b.deprovisions == nil {
+		return nil
+	}
+	deprovision, err := b.deprovisions.GetDeprovision(instanceID)
+	if errors.Is(err, ErrDeprovisionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if deprovision.State == domain.InProgress {
+		return apiresponses.ErrConcurrentInstanceAccess
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+func TestInstanceLocking(t *testing.T) {
+	testCases := map[string]struct {
+		locked      bool
+		deprovision *Deprovision
+		operation   func(b *S3Broker) error
+		expectErr   error
+	}{
+		"update of an unlocked instance": {
+			operation: func(b *S3Broker) error {
+				_, err := b.Update(context.Background(), "instance1", domain.UpdateDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+				return err
+			},
+		},
+		"update during another operation": {
+			locked: true,
+			operation: func(b *S3Broker) error {
+				_, err := b.Update(context.Background(), "instance1", domain.UpdateDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+				return err
+			},
+			expectErr: apiresponses.ErrConcurrentInstanceAccess,
+		},
+		"provision during another operation": {
+			locked: true,
+			operation: func(b *S3Broker) error {
+				_, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+				return err
+			},
+			expectErr: apiresponses.ErrConcurrentInstanceAccess,
+		},
+		"deprovision during another operation": {
+			locked: true,
+			operation: func(b *S3Broker) error {
+				_, err := b.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+				return err
+			},
+			expectErr: apiresponses.ErrConcurrentInstanceAccess,
+		},
+		"update while the bucket is being deleted": {
+			deprovision: &Deprovision{InstanceID: "instance1", State: domain.InProgress},
+			operation: func(b *S3Broker) error {
+				_, err := b.Update(context.Background(), "instance1", domain.UpdateDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+				return err
+			},
+			expectErr: apiresponses.ErrConcurrentInstanceAccess,
+		},
+		"update after a failed deletion": {
+			deprovision: &Deprovision{InstanceID: "instance1", State: domain.Failed},
+			operation: func(b *S3Broker) error {
+				_, err := b.Update(context.Background(), "instance1", domain.UpdateDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+				return err
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger:        lager.NewLogger("broker-unit-test-locks"),
+				bucket:        &mockBucket{},
+				catalog:       &mockCatalog{planName: "plan1", serviceName: "service1"},
+				tagManager:    &mockTagGenerator{},
+				bucketPrefix:  "prefix",
+				deprovisions:  NewMemoryDeprovisionStore(),
+				instanceLocks: NewInstanceLocks(),
+			}
+			if tc.locked {
+				b.instanceLocks.TryLock("instance1")
+			}
+			if tc.deprovision != nil {
+				b.deprovisions.SaveDeprovision(*tc.deprovision)
+			}
+
+			err := tc.operation(b)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			// The operation must release the lock unless another one holds it.
+			if !tc.locked && !b.instanceLocks.TryLock("instance1") {
+				t.Errorf("expected the instance to be unlocked")
+			}
+		})
+	}
+}
+
+// mockLockingStore is a state store whose locks are shared by every broker
+// that uses it.
+type mockLockingStore struct {
+	*state.MemoryStore
+	mu    sync.Mutex
+	locks map[string]string
+}
+
+func (m *mockLockingStore) TryLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if holder, ok := m.locks[key]; ok && holder != owner {
+		return false, nil
+	}
+	m.locks[key] = owner
+	return true, nil
+}
+
+func (m *mockLockingStore) Unlock(ctx context.Context, key, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks[key] == owner {
+		delete(m.locks, key)
+	}
+	return nil
+}
+
+func TestInstanceLockingAcrossBrokers(t *testing.T) {
+	store := &mockLockingStore{MemoryStore: state.NewMemoryStore(), locks: make(map[string]string)}
+	newBroker := func(owner string) *S3Broker {
+		return &S3Broker{
+			logger:        lager.NewLogger("broker-unit-test-locks"),
+			instanceLocks: NewInstanceLocks(),
+			lockOwner:     owner,
+			state:         store,
+		}
+	}
+	brokerA, brokerB := newBroker("broker-a"), newBroker("broker-b")
+
+	unlock, err := brokerA.lockInstance("instance1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := brokerB.lockInstance("instance1"); !errors.Is(err, apiresponses.ErrConcurrentInstanceAccess) {
+		t.Fatalf("expected ErrConcurrentInstanceAccess, got %v", err)
+	}
+	// Failing to take the shared lock releases the broker's own lock.
+	if !brokerB.instanceLocks.TryLock("instance1") {
+		t.Fatal("expected broker-b's own lock to be released")
+	}
+	brokerB.instanceLocks.Unlock("instance1")
+
+	unlock()
+	unlockB, err := brokerB.lockInstance("instance1")
+	if err != nil {
+		t.Fatalf("expected broker-b to lock after broker-a unlocked, got %v", err)
+	}
+	unlockB()
+}
@@ -0,0 +1,59 @@
+package broker
+
+import (
+	"regexp"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// MaintenanceVersionTagKey tags buckets with the maintenance_info version of
+// the plan whose baseline configuration they were last given.
+const MaintenanceVersionTagKey = "Maintenance version"
+
+// semanticVersionPattern matches the semantic versions that OSB requires for
+// maintenance_info.
+var semanticVersionPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// checkMaintenanceInfo returns the OSB MaintenanceInfoConflict error if a
+// request's maintenance_info is not the one the catalog publishes for the
+// plan. Platforms send it to make sure an instance gets the plan's current
+// baseline; requests without it are not checked.
+func checkMaintenanceInfo(servicePlan ServicePlan, requested *domain.MaintenanceInfo) error {
+	if requested == nil {
+		return nil
+	}
+	if servicePlan.MaintenanceInfo == nil {
+		return apiresponses.ErrMaintenanceInfoNilConflict
+	}
+	if !servicePlan.MaintenanceInfo.Equals(*requested) {
+		return apiresponses.ErrMaintenanceInfoConflict
+	}
+	return nil
+}
+
+// isUpgrade reports whether an update only moves an instance to a new
+// maintenance_info version of its plan.
+func isUpgrade(details domain.UpdateDetails) bool {
+	if details.MaintenanceInfo == nil {
+		return false
+	}
+	if details.PreviousValues.PlanID != "" && details.PreviousValues.PlanID != details.PlanID {
+		return false
+	}
+	previous := details.PreviousValues.MaintenanceInfo
+	return previous == nil || !previous.Equals(*details.MaintenanceInfo)
+}
+
+// addMaintenanceVersionTag sets the maintenance version tag in tags to the
+// plan's version, if it has one, and returns tags.
+func addMaintenanceVersionTag(tags map[string]string, servicePlan ServicePlan) map[string]string {
+	if servicePlan.MaintenanceInfo == nil || servicePlan.MaintenanceInfo.Version == "" {
+		return tags
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags[MaintenanceVersionTagKey] = servicePlan.MaintenanceInfo.Version
+	return tags
+}
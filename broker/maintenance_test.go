@@ -0,0 +1,187 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+func TestUpgrade(t *testing.T) {
+	encryption := `{"Rules":[{"ApplyServerSideEncryptionByDefault":{"SSEAlgorithm":"AES256"}}]}`
+	plans := map[string]ServicePlan{
+		"basic": {ID: "basic", Name: "basic", S3Properties: S3Properties{Encryption: encryption},
+			MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.1.0", Description: "Enforce TLS"}},
+		"legacy": {ID: "legacy", Name: "legacy"},
+	}
+
+	testCases := map[string]struct {
+		planID          string
+		maintenanceInfo *domain.MaintenanceInfo
+		previous        *domain.MaintenanceInfo
+		expectErr       error
+		expectAction    string
+		expectDetails   awss3.BucketDetails
+	}{
+		"upgrade": {
+			planID:          "basic",
+			maintenanceInfo: &domain.MaintenanceInfo{Version: "1.1.0"},
+			previous:        &domain.MaintenanceInfo{Version: "1.0.0"},
+			expectAction:    "upgrade",
+			expectDetails: awss3.BucketDetails{
+				Encryption:   encryption,
+				AwsPartition: "aws",
+				Tags:         map[string]string{"service name": "service1", MaintenanceVersionTagKey: "1.1.0"},
+			},
+		},
+		"update without maintenance info": {
+			planID:       "basic",
+			expectAction: "update",
+			expectDetails: awss3.BucketDetails{
+				Encryption:   encryption,
+				AwsPartition: "aws",
+				Tags:         map[string]string{"service name": "service1", MaintenanceVersionTagKey: "1.1.0"},
+			},
+		},
+		"outdated maintenance info": {
+			planID:          "basic",
+			maintenanceInfo: &domain.MaintenanceInfo{Version: "1.0.0"},
+			expectErr:       apiresponses.ErrMaintenanceInfoConflict,
+		},
+		"maintenance info for a plan without it": {
+			planID:          "legacy",
+			maintenanceInfo: &domain.MaintenanceInfo{Version: "1.0.0"},
+			expectErr:       apiresponses.ErrMaintenanceInfoNilConflict,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := &mockBucket{}
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-maintenance"),
+				bucket:       bucket,
+				catalog:      &mockCatalog{serviceName: "service1", plans: plans},
+				tagManager:   &mockTagGenerator{serviceName: "service1"},
+				awsPartition: "aws",
+				operations:   NewMemoryOperationStore(),
+			}
+
+			_, err := b.Update(context.Background(), "instance1", domain.UpdateDetails{
+				PlanID:          tc.planID,
+				MaintenanceInfo: tc.maintenanceInfo,
+				PreviousValues:  domain.PreviousValues{PlanID: tc.planID, MaintenanceInfo: tc.previous},
+			}, false)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				if bucket.modified != nil {
+					t.Errorf("expected the bucket not to be modified")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(tc.expectDetails, *bucket.modified); diff != "" {
+				t.Errorf("unexpected bucket details (-want +got):\n%s", diff)
+			}
+			operations, _ := b.operations.ListOperations("instance1", maxRecentOperations)
+			if len(operations) != 1 || operations[0].Action != tc.expectAction {
+				t.Errorf("expected one %s operation, got %+v", tc.expectAction, operations)
+			}
+		})
+	}
+}
+
+func TestProvisionMaintenanceInfo(t *testing.T) {
+	b := &S3Broker{
+		logger: lager.NewLogger("broker-unit-test-maintenance"),
+		bucket: &mockBucket{},
+		catalog: &mockCatalog{serviceName: "service1", plans: map[string]ServicePlan{
+			"basic": {ID: "basic", Name: "basic", MaintenanceInfo: &domain.MaintenanceInfo{Version: "2.0.0"}},
+		}},
+		tagManager: &mockTagGenerator{},
+	}
+
+	_, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{
+		PlanID:          "basic",
+		MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.0.0"},
+	}, false)
+	if !errors.Is(err, apiresponses.ErrMaintenanceInfoConflict) {
+		t.Fatalf("expected error %v, got %v", apiresponses.ErrMaintenanceInfoConflict, err)
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	testCases := map[string]struct {
+		details domain.UpdateDetails
+		expect  bool
+	}{
+		"new version": {
+			details: domain.UpdateDetails{
+				PlanID:          "basic",
+				MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.1.0"},
+				PreviousValues:  domain.PreviousValues{PlanID: "basic", MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.0.0"}},
+			},
+			expect: true,
+		},
+		"first version": {
+			details: domain.UpdateDetails{
+				PlanID:          "basic",
+				MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.0.0"},
+			},
+			expect: true,
+		},
+		"same version": {
+			details: domain.UpdateDetails{
+				PlanID:          "basic",
+				MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.0.0"},
+				PreviousValues:  domain.PreviousValues{PlanID: "basic", MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.0.0"}},
+			},
+		},
+		"plan change": {
+			details: domain.UpdateDetails{
+				PlanID:          "basic",
+				MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.1.0"},
+				PreviousValues:  domain.PreviousValues{PlanID: "legacy"},
+			},
+		},
+		"no maintenance info": {
+			details: domain.UpdateDetails{PlanID: "basic"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if upgrade := isUpgrade(tc.details); upgrade != tc.expect {
+				t.Errorf("expected %t, got %t", tc.expect, upgrade)
+			}
+		})
+	}
+}
+
+func TestServicesIncludeMaintenanceInfo(t *testing.T) {
+	maintenanceInfo := &domain.MaintenanceInfo{Version: "1.1.0", Description: "Enforce TLS"}
+	b := &S3Broker{
+		logger: lager.NewLogger("broker-unit-test-maintenance"),
+		catalog: BrokerCatalog{Services: []Service{{
+			ID:    "service1",
+			Name:  "s3",
+			Plans: []ServicePlan{{ID: "plan1", Name: "basic", MaintenanceInfo: maintenanceInfo}},
+		}}},
+	}
+
+	services, err := b.Services(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(maintenanceInfo, services[0].Plans[0].MaintenanceInfo); diff != "" {
+		t.Errorf("unexpected maintenance info (-want +got):\n%s", diff)
+	}
+}
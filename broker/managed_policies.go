@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// attachManagedPolicies attaches a plan's managed policies to a binding's
+// user or role. If one cannot be attached, those attached before it are
+// detached again, so the principal can be deleted.
+func (b *S3Broker) attachManagedPolicies(
+	logger lager.Logger,
+	policyARNs []string,
+	attach, detach func(policyARN string) error,
+) error {
+	for i, policyARN := range policyARNs {
+		if err := attach(policyARN); err != nil {
+			logger.Error("attach-managed-policy", err, lager.Data{"policy-arn": policyARN})
+			b.detachManagedPolicies(logger, policyARNs[:i], detach)
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *S3Broker) detachManagedPolicies(logger lager.Logger, policyARNs []string, detach func(policyARN string) error) {
+	for _, policyARN := range policyARNs {
+		if err := detach(policyARN); err != nil {
+			logger.Error("detach-managed-policy", err, lager.Data{"policy-arn": policyARN})
+		}
+	}
+}
+
+// isBindingPolicy reports whether policyARN is the managed policy the broker
+// created for a binding before policies were inlined, as opposed to a
+// managed policy from the plan, which must survive the binding.
+func (b *S3Broker) isBindingPolicy(policyARN, bindingID string) bool {
+	return strings.HasSuffix(policyARN, "/"+b.policyName(bindingID))
+}
@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func TestBindManagedPolicies(t *testing.T) {
+	managedPolicyARNs := []string{
+		"arn:aws:iam::aws:policy/CloudWatchLogsFullAccess",
+		"arn:aws:iam::123456789012:policy/corporate-logging",
+	}
+
+	testCases := map[string]struct {
+		user           *mockUser
+		role           *mockRole
+		parameters     string
+		expectErr      error
+		expectAttached []string
+		expectDetached []string
+	}{
+		"user": {
+			user:           &mockUser{},
+			parameters:     `{}`,
+			expectAttached: managedPolicyARNs,
+		},
+		"user policy fails": {
+			user:           &mockUser{putUserPolicyErr: NewTestErr("put user policy error")},
+			parameters:     `{}`,
+			expectErr:      NewTestErr("put user policy error"),
+			expectAttached: managedPolicyARNs,
+			expectDetached: managedPolicyARNs,
+		},
+		"attach fails": {
+			user:       &mockUser{attachUserPolicyErr: NewTestErr("attach error")},
+			parameters: `{}`,
+			expectErr:  NewTestErr("attach error"),
+		},
+		"role": {
+			user:           &mockUser{},
+			role:           &mockRole{},
+			parameters:     `{"credential_type": "role", "principal": "123456789012", "external_id": "ext-id"}`,
+			expectAttached: managedPolicyARNs,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger: lager.NewLogger("broker-unit-test-managed-policies"),
+				bucket: &mockBucket{},
+				catalog: &mockCatalog{
+					planName:     "plan1",
+					serviceName:  "service1",
+					s3Properties: S3Properties{ManagedPolicyARNs: managedPolicyARNs},
+				},
+				tagManager:   &mockTagGenerator{},
+				user:         tc.user,
+				awsPartition: "aws",
+			}
+			if tc.role != nil {
+				b.role = tc.role
+			}
+
+			_, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(tc.parameters),
+			}, false)
+			if tc.expectErr != nil {
+				if err == nil || err.Error() != tc.expectErr.Error() {
+					t.Fatalf("expected err %s, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			attached := tc.user.attachedUserPolicies
+			if tc.role != nil {
+				attached = tc.role.attachedPolicies["-binding1"]
+			}
+			if tc.expectDetached == nil && !slices.Equal(attached, tc.expectAttached) {
+				t.Errorf("expected attached policies %v, got %v", tc.expectAttached, attached)
+			}
+			if !slices.Equal(tc.user.detachedPolicyArns, tc.expectDetached) {
+				t.Errorf("expected detached policies %v, got %v", tc.expectDetached, tc.user.detachedPolicyArns)
+			}
+		})
+	}
+}
+
+func TestUnbindRoleDetachesManagedPolicies(t *testing.T) {
+	role := &mockRole{
+		trustPolicies:    map[string]string{"-binding1": "{}"},
+		attachedPolicies: map[string][]string{"-binding1": {"arn:aws:iam::aws:policy/CloudWatchLogsFullAccess"}},
+	}
+	b := newTestBroker(withRole(role))
+	if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(role.attachedPolicies["-binding1"]) != 0 {
+		t.Errorf("expected managed policies to be detached, got %v", role.attachedPolicies)
+	}
+}
@@ -0,0 +1,56 @@
+package broker
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+// maxLabelValueLength is the longest label value Kubernetes accepts, which
+// platforms copy instance labels to.
+const maxLabelValueLength = 63
+
+// invalidLabelValueChars matches characters that Kubernetes label values
+// cannot hold.
+var invalidLabelValueChars = regexp.MustCompile(`[^a-z0-9._-]`)
+
+// instanceLabels returns the OSB metadata labels of an instance whose bucket
+// is in region and has the given encryption and policy: its region, default
+// encryption algorithm, and whether its policy makes it public.
+func instanceLabels(region, encryption, policy string) map[string]string {
+	labels := map[string]string{
+		"encryption": labelValue(encryptionAlgorithm(encryption)),
+		"access":     policyMode(policy),
+	}
+	if region != "" {
+		labels["region"] = labelValue(region)
+	}
+	return labels
+}
+
+// bucketMetadata returns the OSB metadata of an instance whose bucket the
+// broker just created or updated with bucketDetails, in the broker's region.
+// Requests of OSB API versions without instance metadata get none.
+func (b *S3Broker) bucketMetadata(ctx context.Context, bucketName string, bucketDetails *awss3.BucketDetails) domain.InstanceMetadata {
+	if !supportsAPIVersion(ctx, apiVersionInstanceMetadata) {
+		return domain.InstanceMetadata{}
+	}
+	return domain.InstanceMetadata{
+		Labels:     instanceLabels(b.region, bucketDetails.Encryption, bucketDetails.Policy),
+		Attributes: map[string]string{"bucket": bucketName},
+	}
+}
+
+// labelValue turns value into a valid Kubernetes label value, such as aws-kms
+// for aws:kms.
+func labelValue(value string) string {
+	value = invalidLabelValueChars.ReplaceAllString(strings.ToLower(value), "-")
+	if len(value) > maxLabelValueLength {
+		value = value[:maxLabelValueLength]
+	}
+	return strings.Trim(value, "-_.")
+}
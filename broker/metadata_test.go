@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func TestInstanceLabels(t *testing.T) {
+	testCases := map[string]struct {
+		region       string
+		encryption   string
+		policy       string
+		expectLabels map[string]string
+	}{
+		"private bucket": {
+			region:       "us-gov-west-1",
+			encryption:   `{"Rules": [{"ApplyServerSideEncryptionByDefault": {"SSEAlgorithm": "AES256"}}]}`,
+			expectLabels: map[string]string{"region": "us-gov-west-1", "encryption": "aes256", "access": "private"},
+		},
+		"public bucket encrypted with KMS": {
+			region:       "us-east-1",
+			encryption:   `{"Rules": [{"ApplyServerSideEncryptionByDefault": {"SSEAlgorithm": "aws:kms:dsse"}}]}`,
+			policy:       `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject"}]}`,
+			expectLabels: map[string]string{"region": "us-east-1", "encryption": "aws-kms-dsse", "access": "public-read"},
+		},
+		"unknown region": {
+			expectLabels: map[string]string{"encryption": "none", "access": "private"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			labels := instanceLabels(tc.region, tc.encryption, tc.policy)
+			if diff := cmp.Diff(tc.expectLabels, labels); diff != "" {
+				t.Errorf("unexpected labels (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	if value := labelValue("aws:kms"); value != "aws-kms" {
+		t.Errorf("expected aws-kms, got %q", value)
+	}
+	if value := labelValue(strings.Repeat("a", 70)); len(value) != maxLabelValueLength {
+		t.Errorf("expected %d characters, got %d", maxLabelValueLength, len(value))
+	}
+}
+
+func TestProvisionMetadata(t *testing.T) {
+	b := &S3Broker{
+		logger:       lager.NewLogger("broker-unit-test-metadata"),
+		bucket:       &mockBucket{},
+		catalog:      &mockCatalog{planName: "plan1", serviceName: "service1"},
+		tagManager:   &mockTagGenerator{},
+		bucketPrefix: "prefix",
+		region:       "us-gov-west-1",
+	}
+	spec, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := domain.InstanceMetadata{
+		Labels:     map[string]string{"region": "us-gov-west-1", "encryption": "none", "access": "private"},
+		Attributes: map[string]string{"bucket": "prefix-instance1"},
+	}
+	if diff := cmp.Diff(expected, spec.Metadata); diff != "" {
+		t.Errorf("unexpected metadata (-want +got):\n%s", diff)
+	}
+}
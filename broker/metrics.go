@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/cloud-gov/s3-broker/awsmetrics"
+)
+
+// DefaultMetricsInterval is how often metrics are put to CloudWatch.
+const DefaultMetricsInterval = time.Minute
+
+// maxMetricsDimensions leaves room in CloudWatch's 30 dimensions a metric for
+// the dimensions that the broker adds.
+const maxMetricsDimensions = 28
+
+// MetricsConfig configures the broker's operational metrics, which are put to
+// CloudWatch for operators who alert from CloudWatch.
+type MetricsConfig struct {
+	// CloudWatchNamespace is the namespace that metrics are put to. Metrics
+	// are not emitted without it.
+	CloudWatchNamespace string `yaml:"cloudwatch_namespace"`
+	// Interval is how often metrics are put. Defaults to
+	// DefaultMetricsInterval.
+	Interval time.Duration `yaml:"interval"`
+	// Dimensions are added to every metric, to tell brokers apart.
+	Dimensions map[string]string `yaml:"dimensions"`
+}
+
+func (c MetricsConfig) Enabled() bool {
+	return c.CloudWatchNamespace != ""
+}
+
+func (c MetricsConfig) Validate() error {
+	if !c.Enabled() {
+		if c.Interval != 0 || len(c.Dimensions) > 0 {
+			return errors.New("Must provide a CloudWatchNamespace to put metrics to")
+		}
+		return nil
+	}
+	if strings.HasPrefix(c.CloudWatchNamespace, "AWS/") {
+		return fmt.Errorf("CloudWatchNamespace must not begin with AWS/, got %q", c.CloudWatchNamespace)
+	}
+	if c.Interval < 0 {
+		return errors.New("Interval must not be negative")
+	}
+	if len(c.Dimensions) > maxMetricsDimensions {
+		return fmt.Errorf("Must provide at most %d Dimensions", maxMetricsDimensions)
+	}
+	return nil
+}
+
+// SetMetricsRecorder makes the broker record metrics of its operations and
+// quotas. It must be called before the broker serves requests.
+func (b *S3Broker) SetMetricsRecorder(recorder awsmetrics.Recorder) {
+	b.metrics = recorder
+}
+
+// countOperation counts an operation, and counts it again as a failure if
+// it failed.
+func (b *S3Broker) countOperation(action string, err error) {
+	if b.metrics == nil {
+		return
+	}
+	dimensions := map[string]string{"Action": action}
+	b.metrics.Count("Operations", dimensions)
+	if err != nil {
+		b.metrics.Count("OperationFailures", dimensions)
+	}
+}
+
+// recordQuotaUtilization records how much of a quota of max instances is
+// used by count instances, as a percentage.
+func (b *S3Broker) recordQuotaUtilization(count, max int, dimensions map[string]string) {
+	if b.metrics == nil {
+		return
+	}
+	b.metrics.Gauge("QuotaUtilization", float64(count)/float64(max)*100, cloudwatch.StandardUnitPercent, dimensions)
+}
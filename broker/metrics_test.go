@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+type mockMetric struct {
+	Name       string
+	Value      float64
+	Unit       string
+	Dimensions map[string]string
+}
+
+type mockMetricsRecorder struct {
+	metrics []mockMetric
+}
+
+func (r *mockMetricsRecorder) Count(name string, dimensions map[string]string) {
+	r.metrics = append(r.metrics, mockMetric{Name: name, Value: 1, Unit: "Count", Dimensions: dimensions})
+}
+
+func (r *mockMetricsRecorder) Gauge(name string, value float64, unit string, dimensions map[string]string) {
+	r.metrics = append(r.metrics, mockMetric{Name: name, Value: value, Unit: unit, Dimensions: dimensions})
+}
+
+func TestMetricsConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		config    MetricsConfig
+		expectErr string
+	}{
+		"disabled": {},
+		"enabled": {
+			config: MetricsConfig{CloudWatchNamespace: "S3Broker", Dimensions: map[string]string{"Environment": "production"}},
+		},
+		"dimensions without a namespace": {
+			config:    MetricsConfig{Dimensions: map[string]string{"Environment": "production"}},
+			expectErr: "Must provide a CloudWatchNamespace to put metrics to",
+		},
+		"AWS namespace": {
+			config:    MetricsConfig{CloudWatchNamespace: "AWS/S3"},
+			expectErr: `CloudWatchNamespace must not begin with AWS/, got "AWS/S3"`,
+		},
+		"negative interval": {
+			config:    MetricsConfig{CloudWatchNamespace: "S3Broker", Interval: -1},
+			expectErr: "Interval must not be negative",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.expectErr {
+				t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestProvisionMetrics(t *testing.T) {
+	testCases := map[string]struct {
+		total         int
+		expectMetrics []mockMetric
+	}{
+		"within quotas": {
+			total: 25,
+			expectMetrics: []mockMetric{
+				{Name: "QuotaUtilization", Value: 25, Unit: "Percent", Dimensions: map[string]string{"Quota": "broker"}},
+				{Name: "QuotaUtilization", Value: 40, Unit: "Percent", Dimensions: map[string]string{"Quota": "organization"}},
+				{Name: "QuotaUtilization", Value: 60, Unit: "Percent", Dimensions: map[string]string{"Quota": "plan", "Plan": "basic"}},
+				{Name: "Operations", Value: 1, Unit: "Count", Dimensions: map[string]string{"Action": "provision"}},
+			},
+		},
+		"quota reached": {
+			total: 100,
+			expectMetrics: []mockMetric{
+				{Name: "QuotaUtilization", Value: 100, Unit: "Percent", Dimensions: map[string]string{"Quota": "broker"}},
+				{Name: "Operations", Value: 1, Unit: "Count", Dimensions: map[string]string{"Action": "provision"}},
+				{Name: "OperationFailures", Value: 1, Unit: "Count", Dimensions: map[string]string{"Action": "provision"}},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &mockMetricsRecorder{}
+			b := &S3Broker{
+				logger: lager.NewLogger("broker-unit-test-metrics"),
+				bucket: &mockBucket{countBuckets: func(tags map[string][]string) int {
+					switch {
+					case tags[brokertags.ServicePlanName] != nil:
+						return 3
+					case tags[brokertags.OrganizationGUIDTagKey] != nil:
+						return 4
+					}
+					return tc.total
+				}},
+				catalog: &mockCatalog{serviceName: "s3", plans: map[string]ServicePlan{
+					"plan1": {ID: "plan1", Name: "basic", MaxInstances: 5, S3Properties: S3Properties{IamPolicy: "{}"}},
+				}},
+				tagManager: &mockTagGenerator{},
+				quotas:     newQuotas(QuotasConfig{MaxInstances: 100, MaxInstancesPerOrg: 10}),
+				metrics:    recorder,
+			}
+			b.Provision(context.Background(), "instance1", domain.ProvisionDetails{
+				ServiceID:        "service1",
+				PlanID:           "plan1",
+				OrganizationGUID: "org1",
+			}, false)
+			if diff := cmp.Diff(tc.expectMetrics, recorder.metrics); diff != "" {
+				t.Errorf("unexpected metrics (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// The IAM users, roles and policies of bindings are named from templates.
+// Names can only use the prefix and binding ID, because Unbind and key
+// rotation only know the binding. Paths can also use the instance,
+// organization and space, so SCPs and audits can target them.
+const defaultNameTemplate = "{{.Prefix}}-{{.BindingID}}"
+
+var iamNamePattern = regexp.MustCompile(`^[\w+=,.@-]{1,64}$`)
+
+type NameData struct {
+	Prefix    string
+	BindingID string
+}
+
+// PathData is what path templates can use. Requests from Kubernetes have no
+// organization or space, so OrganizationID and SpaceID are their cluster ID
+// and namespace.
+type PathData struct {
+	InstanceID     string
+	OrganizationID string
+	SpaceID        string
+	ClusterID      string
+	Namespace      string
+}
+
+func renderTemplate(text string, data interface{}) (string, error) {
+	tpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func renderName(nameTemplate, prefix, bindingID string) (string, error) {
+	if nameTemplate == "" {
+		nameTemplate = defaultNameTemplate
+	}
+	return renderTemplate(nameTemplate, NameData{Prefix: prefix, BindingID: bindingID})
+}
+
+// validateNameTemplate checks that nameTemplate renders valid IAM names that
+// are unique per binding.
+func validateNameTemplate(nameTemplate string) error {
+	const bindingID = "6f9a4c1e-8d2b-4e7a-9c3f-5b1d0e2a7f84"
+	name, err := renderName(nameTemplate, "prefix", bindingID)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(name, bindingID) {
+		return errors.New("must include {{.BindingID}}")
+	}
+	if !iamNamePattern.MatchString(name) {
+		return fmt.Errorf("renders %q, which is not a valid IAM name", name)
+	}
+	return nil
+}
+
+// validatePathTemplate checks that pathTemplate renders a valid IAM path.
+func validatePathTemplate(pathTemplate string) error {
+	path, err := renderTemplate(pathTemplate, PathData{
+		InstanceID:     "instance",
+		OrganizationID: "organization",
+		SpaceID:        "space",
+		ClusterID:      "cluster",
+		Namespace:      "namespace",
+	})
+	if err != nil {
+		return err
+	}
+	if path != "" && (!strings.HasPrefix(path, "/") || !strings.HasSuffix(path, "/")) {
+		return fmt.Errorf("renders %q, which does not begin and end with /", path)
+	}
+	return nil
+}
+
+// pathPrefix returns the part of pathTemplate before its first action, which
+// every rendered path shares.
+func pathPrefix(pathTemplate string) string {
+	static, _, found := strings.Cut(pathTemplate, "{{")
+	if !found {
+		return pathTemplate
+	}
+	return static[:strings.LastIndex(static, "/")+1]
+}
+
+// nameMatcher returns a function reporting whether name could have been
+// rendered from nameTemplate.
+func nameMatcher(nameTemplate, prefix string) func(name string) bool {
+	parse := nameParser(nameTemplate, prefix)
+	return func(name string) bool {
+		_, ok := parse(name)
+		return ok
+	}
+}
+
+// nameParser returns a function that returns the ID that name was rendered
+// from with nameTemplate, and reports whether it could have been.
+func nameParser(nameTemplate, prefix string) func(name string) (string, bool) {
+	const placeholder = "\x00"
+	rendered, err := renderName(nameTemplate, prefix, placeholder)
+	before, after, found := strings.Cut(rendered, placeholder)
+	if err != nil || !found {
+		return func(string) (string, bool) { return "", false }
+	}
+	return func(name string) (string, bool) {
+		if len(name) <= len(before)+len(after) || !strings.HasPrefix(name, before) || !strings.HasSuffix(name, after) {
+			return "", false
+		}
+		return name[len(before) : len(name)-len(after)], true
+	}
+}
+
+// bindingPath renders the IAM path of a binding's user or role from the
+// Cloud Foundry context of the bind request.
+func (b *S3Broker) bindingPath(instanceID string, rawContext json.RawMessage) (string, error) {
+	bindContext, err := parseBindContext(rawContext)
+	if err != nil {
+		return "", err
+	}
+	pathData := PathData{
+		InstanceID:     instanceID,
+		OrganizationID: bindContext.OrganizationGUID,
+		SpaceID:        bindContext.SpaceGUID,
+		ClusterID:      bindContext.ClusterID,
+		Namespace:      bindContext.Namespace,
+	}
+	if bindContext.isKubernetes() {
+		pathData.OrganizationID, pathData.SpaceID = bindContext.ClusterID, bindContext.Namespace
+	}
+	return renderTemplate(b.iamPath, pathData)
+}
+
+// The templates are checked by Config.Validate, so rendering them cannot
+// fail.
+
+func (b *S3Broker) userName(bindingID string) string {
+	name, _ := renderName(b.userNameTemplate, b.userPrefix, bindingID)
+	return name
+}
+
+func (b *S3Broker) policyName(bindingID string) string {
+	name, _ := renderName(b.policyNameTemplate, b.policyPrefix, bindingID)
+	return name
+}
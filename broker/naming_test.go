@@ -0,0 +1,98 @@
+package broker
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateNameTemplate(t *testing.T) {
+	testCases := map[string]struct {
+		nameTemplate string
+		expectErr    bool
+	}{
+		"default":            {nameTemplate: ""},
+		"custom":             {nameTemplate: "s3-{{.BindingID}}-{{.Prefix}}"},
+		"missing binding id": {nameTemplate: "{{.Prefix}}", expectErr: true},
+		"unknown field":      {nameTemplate: "{{.SpaceID}}-{{.BindingID}}", expectErr: true},
+		"invalid characters": {nameTemplate: "{{.Prefix}}/{{.BindingID}}", expectErr: true},
+		"too long":           {nameTemplate: "{{.BindingID}}-{{.BindingID}}", expectErr: true},
+		"does not parse":     {nameTemplate: "{{.BindingID", expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateNameTemplate(tc.nameTemplate)
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidatePathTemplate(t *testing.T) {
+	testCases := map[string]struct {
+		pathTemplate string
+		expectErr    bool
+	}{
+		"empty":          {pathTemplate: ""},
+		"static":         {pathTemplate: "/s3-broker/"},
+		"templated":      {pathTemplate: "/s3-broker/{{.OrganizationID}}/{{.SpaceID}}/{{.InstanceID}}/"},
+		"no slashes":     {pathTemplate: "{{.SpaceID}}", expectErr: true},
+		"unknown field":  {pathTemplate: "/{{.BindingID}}/", expectErr: true},
+		"does not parse": {pathTemplate: "/{{.SpaceID/", expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validatePathTemplate(tc.pathTemplate)
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	testCases := map[string]string{
+		"":                                 "",
+		"/s3-broker/":                      "/s3-broker/",
+		"/s3-broker/{{.OrganizationID}}/":  "/s3-broker/",
+		"/s3-broker/org-{{.SpaceID}}/":     "/s3-broker/",
+		"{{.OrganizationID}}/{{.SpaceID}}": "",
+	}
+	for pathTemplate, expected := range testCases {
+		if got := pathPrefix(pathTemplate); got != expected {
+			t.Errorf("pathPrefix(%q): expected %q, got %q", pathTemplate, expected, got)
+		}
+	}
+}
+
+func TestNameMatcher(t *testing.T) {
+	matches := nameMatcher("s3-{{.Prefix}}-{{.BindingID}}-user", "prefix")
+	parse := nameParser("s3-{{.Prefix}}-{{.BindingID}}-user", "prefix")
+	// testCases map names to the IDs they were rendered from, or "" if they
+	// were not.
+	testCases := map[string]string{
+		"s3-prefix-binding1-user": "binding1",
+		"s3-prefix--user":         "",
+		"s3-prefix-binding1":      "",
+		"prefix-binding1":         "",
+	}
+	for name, expected := range testCases {
+		if got := matches(name); got != (expected != "") {
+			t.Errorf("%q: expected match %v, got %v", name, expected != "", got)
+		}
+		if id, ok := parse(name); id != expected || ok != (expected != "") {
+			t.Errorf("%q: expected ID %q, got %q, %v", name, expected, id, ok)
+		}
+	}
+}
+
+func TestBindingPath(t *testing.T) {
+	b := &S3Broker{iamPath: "/s3-broker/{{.OrganizationID}}/{{.SpaceID}}/{{.InstanceID}}/"}
+	path, err := b.bindingPath("instance1", json.RawMessage(`{"platform": "cloudfoundry", "organization_guid": "org1", "space_guid": "space1"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if path != "/s3-broker/org1/space1/instance1/" {
+		t.Errorf("unexpected path %q", path)
+	}
+}
@@ -0,0 +1,216 @@
+package broker
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/azureblob"
+	"github.com/cloud-gov/s3-broker/gcpstorage"
+	"github.com/cloud-gov/s3-broker/objectstore"
+)
+
+// Object stores that plans can provision buckets in instead of S3.
+const (
+	ObjectStoreGCS   = "gcs"
+	ObjectStoreAzure = "azure"
+)
+
+var objectStores = []string{ObjectStoreGCS, ObjectStoreAzure}
+
+// objectStoreParams are the provision and update parameters of plans in other
+// object stores, which have none of the S3 bucket configuration.
+var objectStoreParams = []string{"tags", "annotations"}
+
+var (
+	ErrObjectStorePlanChange = apiresponses.NewFailureResponse(
+		errors.New("Instances cannot change between plans in different object stores."),
+		http.StatusBadRequest,
+		"object-store-plan-change",
+	)
+	ErrObjectStoreBindParameters = apiresponses.NewFailureResponse(
+		errors.New("Bindings of plans in other object stores only take the permissions, credential_type access-key and credential_format parameters."),
+		http.StatusBadRequest,
+		"object-store-bind-parameters",
+	)
+)
+
+// ObjectStoresConfig configures the object stores other than S3 that plans
+// can provision buckets in. A store is enabled when it is configured.
+type ObjectStoresConfig struct {
+	GCS   gcpstorage.Config `yaml:"gcs"`
+	Azure azureblob.Config  `yaml:"azure"`
+}
+
+func (c ObjectStoresConfig) Validate() error {
+	if err := c.GCS.Validate(); err != nil {
+		return fmt.Errorf("Validating GCS configuration: %s", err)
+	}
+	if err := c.Azure.Validate(); err != nil {
+		return fmt.Errorf("Validating Azure configuration: %s", err)
+	}
+	return nil
+}
+
+// Enabled reports whether the object store called name is configured.
+func (c ObjectStoresConfig) Enabled(name string) bool {
+	switch name {
+	case ObjectStoreGCS:
+		return c.GCS.Enabled()
+	case ObjectStoreAzure:
+		return c.Azure.Enabled()
+	}
+	return false
+}
+
+// SetObjectStores gives the broker the clients of the object stores that
+// plans name. It must be called before the broker serves requests.
+func (b *S3Broker) SetObjectStores(stores map[string]objectstore.BucketProvider) {
+	b.objectStores = stores
+}
+
+// objectStore returns the client of the object store that servicePlan
+// provisions buckets in, or nil for S3 plans.
+func (b *S3Broker) objectStore(servicePlan ServicePlan) objectstore.BucketProvider {
+	if servicePlan.S3Properties.ObjectStore == "" {
+		return nil
+	}
+	return b.objectStores[servicePlan.S3Properties.ObjectStore]
+}
+
+// planObjectStore returns the name of the object store that a plan provisions
+// into, or "" for S3 plans and plans that are not in the catalog.
+func (b *S3Broker) planObjectStore(planID string) string {
+	servicePlan, _ := b.catalog.FindServicePlan(planID)
+	return servicePlan.S3Properties.ObjectStore
+}
+
+// withObjectStoreParams leaves only the parameters that plans in other object
+// stores take in properties.
+func withObjectStoreParams(servicePlan ServicePlan, properties map[string]*ParameterSchema) map[string]*ParameterSchema {
+	if servicePlan.S3Properties.ObjectStore == "" {
+		return properties
+	}
+	maps.DeleteFunc(properties, func(name string, _ *ParameterSchema) bool {
+		return !slices.Contains(objectStoreParams, name)
+	})
+	return properties
+}
+
+// provisionObjectStore creates an instance's bucket in another object store,
+// labelled with the tags an S3 bucket would have. The broker's quotas apply
+// as they do to S3 plans, though they only count the instances in S3.
+func (b *S3Broker) provisionObjectStore(
+	ctx context.Context,
+	store objectstore.BucketProvider,
+	instanceID string,
+	servicePlan ServicePlan,
+	provisionParameters ProvisionParameters,
+	details domain.ProvisionDetails,
+	fingerprint string,
+) (domain.ProvisionedServiceSpec, error) {
+	if err := checkPlanAccess(servicePlan, details.OrganizationGUID, details.SpaceGUID, details.RawContext); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	organizationGUID, _ := cfPlace(details.OrganizationGUID, details.SpaceGUID, details.RawContext)
+	if err := b.checkQuotas(ctx, details.ServiceID, servicePlan, organizationGUID); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	instance, err := b.createBucket(ctx, instanceID, servicePlan, provisionParameters, details)
+	if err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	if err := store.CreateBucket(ctx, b.bucketName(instanceID), instance.Tags); err != nil {
+		return domain.ProvisionedServiceSpec{}, mapObjectStoreError(err)
+	}
+	b.saveRequest(instanceRequestKey(instanceID), fingerprint)
+	return domain.ProvisionedServiceSpec{IsAsync: false}, nil
+}
+
+// updateObjectStore relabels an instance's bucket in another object store
+// with the tags of the update.
+func (b *S3Broker) updateObjectStore(
+	ctx context.Context,
+	store objectstore.BucketProvider,
+	instanceID string,
+	servicePlan ServicePlan,
+	updateParameters UpdateParameters,
+	details domain.UpdateDetails,
+) (domain.UpdateServiceSpec, error) {
+	instance, err := b.modifyBucket(ctx, instanceID, servicePlan, updateParameters, details)
+	if err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
+	if err := store.SetLabels(ctx, b.bucketName(instanceID), instance.Tags); err != nil {
+		return domain.UpdateServiceSpec{}, mapObjectStoreError(err)
+	}
+	return domain.UpdateServiceSpec{IsAsync: false}, nil
+}
+
+// bindObjectStore creates credentials for an instance's bucket in another
+// object store. They have the shape of S3 credentials, with the fields that
+// the store has.
+func (b *S3Broker) bindObjectStore(
+	ctx context.Context,
+	store objectstore.BucketProvider,
+	instanceID, bindingID string,
+	bindParameters BindParameters,
+) (domain.Binding, error) {
+	if err := checkObjectStoreBindParameters(bindParameters); err != nil {
+		return domain.Binding{}, err
+	}
+	access := objectstore.Access(cmp.Or(bindParameters.Permissions, PermissionsReadWrite))
+	storeCredentials, err := store.CreateCredentials(ctx, b.bucketName(instanceID), bindingID, access)
+	if err != nil {
+		return domain.Binding{}, mapObjectStoreError(err)
+	}
+	return domain.Binding{
+		Credentials: Credentials{
+			URI:             storeCredentials.URI,
+			AccessKeyID:     storeCredentials.AccessKeyID,
+			SecretAccessKey: storeCredentials.SecretAccessKey,
+			Region:          storeCredentials.Region,
+			Bucket:          storeCredentials.Bucket,
+			Endpoint:        storeCredentials.Endpoint,
+			BucketURL:       storeCredentials.BucketURL,
+			SASToken:        storeCredentials.SASToken,
+		},
+	}, nil
+}
+
+// checkObjectStoreBindParameters rejects the bind parameters that only S3
+// plans take.
+func checkObjectStoreBindParameters(bindParameters BindParameters) error {
+	if len(bindParameters.AdditionalInstances) > 0 ||
+		bindParameters.PathPrefix != "" ||
+		bindParameters.CreateFolder ||
+		(bindParameters.CredentialType != "" && bindParameters.CredentialType != CredentialTypeAccessKey) ||
+		bindParameters.Principal != "" ||
+		bindParameters.ExternalID != "" ||
+		bindParameters.OIDCProviderARN != "" ||
+		bindParameters.Subject != "" ||
+		bindParameters.SecretName != "" {
+		return ErrObjectStoreBindParameters
+	}
+	return nil
+}
+
+// unbindObjectStore deletes a binding's credentials in another object store.
+// Unbinding a binding that was never bound is answered with 410 Gone.
+func (b *S3Broker) unbindObjectStore(ctx context.Context, store objectstore.BucketProvider, instanceID, bindingID string, known bool) error {
+	err := store.DeleteCredentials(ctx, b.bucketName(instanceID), bindingID)
+	if errors.Is(err, objectstore.ErrCredentialsNotFound) && !known {
+		return apiresponses.ErrBindingDoesNotExist
+	}
+	if err != nil && !errors.Is(err, objectstore.ErrCredentialsNotFound) {
+		return mapObjectStoreError(err)
+	}
+	return b.forgetRequest(bindingRequestKey(bindingID))
+}
@@ -0,0 +1,246 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/objectstore"
+)
+
+type mockObjectStore struct {
+	buckets     map[string]map[string]string
+	objects     map[string]bool
+	credentials map[string]objectstore.Access
+}
+
+func newMockObjectStore() *mockObjectStore {
+	return &mockObjectStore{
+		buckets:     make(map[string]map[string]string),
+		objects:     make(map[string]bool),
+		credentials: make(map[string]objectstore.Access),
+	}
+}
+
+func (s *mockObjectStore) CreateBucket(ctx context.Context, bucketName string, labels map[string]string) error {
+	if _, ok := s.buckets[bucketName]; ok {
+		return objectstore.ErrBucketExists
+	}
+	s.buckets[bucketName] = maps.Clone(labels)
+	return nil
+}
+
+func (s *mockObjectStore) SetLabels(ctx context.Context, bucketName string, labels map[string]string) error {
+	if _, ok := s.buckets[bucketName]; !ok {
+		return objectstore.ErrBucketNotFound
+	}
+	maps.Copy(s.buckets[bucketName], labels)
+	return nil
+}
+
+func (s *mockObjectStore) DeleteBucket(ctx context.Context, bucketName string) error {
+	if _, ok := s.buckets[bucketName]; !ok {
+		return objectstore.ErrBucketNotFound
+	}
+	if s.objects[bucketName] {
+		return objectstore.ErrBucketNotEmpty
+	}
+	delete(s.buckets, bucketName)
+	return nil
+}
+
+func (s *mockObjectStore) CreateCredentials(ctx context.Context, bucketName, bindingID string, access objectstore.Access) (objectstore.Credentials, error) {
+	s.credentials[bindingID] = access
+	return objectstore.Credentials{
+		Endpoint:        "storage.example.com",
+		Bucket:          bucketName,
+		AccessKeyID:     "access-key-" + bindingID,
+		SecretAccessKey: "secret",
+	}, nil
+}
+
+func (s *mockObjectStore) DeleteCredentials(ctx context.Context, bucketName, bindingID string) error {
+	if _, ok := s.credentials[bindingID]; !ok {
+		return objectstore.ErrCredentialsNotFound
+	}
+	delete(s.credentials, bindingID)
+	return nil
+}
+
+func withObjectStore(store *mockObjectStore) func(*S3Broker) {
+	return func(b *S3Broker) {
+		b.bucketPrefix = "prefix"
+		b.catalog = &mockCatalog{
+			planName:     "gcs",
+			serviceName:  "service1",
+			s3Properties: S3Properties{ObjectStore: ObjectStoreGCS},
+		}
+		b.tagManager = &mockTagGenerator{
+			tags: map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance1"},
+		}
+		b.objectStores = map[string]objectstore.BucketProvider{ObjectStoreGCS: store}
+		b.requests = NewMemoryRequestStore()
+		b.allowUserProvisionParameters = true
+		b.allowUserUpdateParameters = true
+	}
+}
+
+func TestObjectStoreInstance(t *testing.T) {
+	store := newMockObjectStore()
+	b := newTestBroker(withObjectStore(store))
+	ctx := context.Background()
+
+	_, err := b.Provision(ctx, "instance1", domain.ProvisionDetails{
+		ServiceID:     "service1",
+		PlanID:        "gcs",
+		RawParameters: json.RawMessage(`{"tags": {"team": "data"}}`),
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectLabels := map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance1", "team": "data"}
+	if diff := cmp.Diff(expectLabels, store.buckets["prefix-instance1"]); diff != "" {
+		t.Errorf("unexpected labels (-want +got):\n%s", diff)
+	}
+
+	_, err = b.Provision(ctx, "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "gcs"}, false)
+	if !errors.Is(err, apiresponses.ErrInstanceAlreadyExists) {
+		t.Errorf("expected ErrInstanceAlreadyExists, got %v", err)
+	}
+
+	// The mock tag generator keeps the tags of the provision, user tags
+	// included, which the update would reject as operator tags.
+	b.tagManager = &mockTagGenerator{
+		tags: map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance1"},
+	}
+	_, err = b.Update(ctx, "instance1", domain.UpdateDetails{
+		ServiceID:     "service1",
+		PlanID:        "gcs",
+		RawParameters: json.RawMessage(`{"tags": {"team": "analytics"}}`),
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if label := store.buckets["prefix-instance1"]["team"]; label != "analytics" {
+		t.Errorf("expected the update to relabel the bucket, got %q", label)
+	}
+
+	store.objects["prefix-instance1"] = true
+	_, err = b.Deprovision(ctx, "instance1", domain.DeprovisionDetails{PlanID: "gcs"}, false)
+	var failure *apiresponses.FailureResponse
+	if !errors.As(err, &failure) || failure.LoggerAction() != "bucket-not-empty" {
+		t.Errorf("expected a bucket-not-empty failure, got %v", err)
+	}
+	store.objects["prefix-instance1"] = false
+	if _, err := b.Deprovision(ctx, "instance1", domain.DeprovisionDetails{PlanID: "gcs"}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := store.buckets["prefix-instance1"]; ok {
+		t.Error("expected the bucket to be deleted")
+	}
+}
+
+func TestObjectStoreBinding(t *testing.T) {
+	testCases := map[string]struct {
+		parameters   string
+		expectAccess objectstore.Access
+		expectErr    error
+	}{
+		"read-write by default": {
+			expectAccess: objectstore.ReadWrite,
+		},
+		"read-only": {
+			parameters:   `{"permissions": "read-only"}`,
+			expectAccess: objectstore.ReadOnly,
+		},
+		"access key": {
+			parameters:   `{"credential_type": "access-key", "permissions": "write-only"}`,
+			expectAccess: objectstore.WriteOnly,
+		},
+		"path prefix": {
+			parameters: `{"path_prefix": "app"}`,
+			expectErr:  ErrObjectStoreBindParameters,
+		},
+		"temporary credentials": {
+			parameters: `{"credential_type": "temporary"}`,
+			expectErr:  ErrObjectStoreBindParameters,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			store := newMockObjectStore()
+			b := newTestBroker(withObjectStore(store))
+			ctx := context.Background()
+
+			details := domain.BindDetails{ServiceID: "service1", PlanID: "gcs"}
+			if tc.parameters != "" {
+				details.RawParameters = json.RawMessage(tc.parameters)
+			}
+			binding, err := b.Bind(ctx, "instance1", "binding1", details, false)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if access := store.credentials["binding1"]; access != tc.expectAccess {
+				t.Errorf("expected %s access, got %s", tc.expectAccess, access)
+			}
+			credentials, _ := binding.Credentials.(Credentials)
+			if credentials.AccessKeyID != "access-key-binding1" || credentials.Bucket != "prefix-instance1" {
+				t.Errorf("unexpected credentials %+v", credentials)
+			}
+
+			if _, err := b.Unbind(ctx, "instance1", "binding1", domain.UnbindDetails{PlanID: "gcs"}, false); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(store.credentials) > 0 {
+				t.Errorf("expected the credentials to be deleted, got %v", store.credentials)
+			}
+			if _, err := b.Unbind(ctx, "instance1", "binding1", domain.UnbindDetails{PlanID: "gcs"}, false); !errors.Is(err, apiresponses.ErrBindingDoesNotExist) {
+				t.Errorf("expected ErrBindingDoesNotExist, got %v", err)
+			}
+		})
+	}
+}
+
+func TestObjectStoreSchemas(t *testing.T) {
+	b := newTestBroker(withObjectStore(newMockObjectStore()))
+	servicePlan := ServicePlan{S3Properties: S3Properties{ObjectStore: ObjectStoreGCS}}
+	for name, schema := range map[string]*ParameterSchema{
+		"provision": b.provisionSchema(servicePlan),
+		"update":    b.updateSchema(servicePlan),
+	} {
+		properties := slices.Sorted(maps.Keys(schema.Properties))
+		if !slices.Equal(properties, []string{"annotations", "tags"}) {
+			t.Errorf("expected the %s schema to have only tags and annotations, got %v", name, properties)
+		}
+	}
+}
+
+func TestObjectStoreProvisionQuotas(t *testing.T) {
+	store := newMockObjectStore()
+	b := newTestBroker(withObjectStore(store))
+	b.bucket = &mockBucket{countBuckets: func(tags map[string][]string) int { return 10 }}
+	b.quotas = newQuotas(QuotasConfig{MaxInstances: 10})
+
+	_, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "gcs"}, false)
+	var failure *apiresponses.FailureResponse
+	if !errors.As(err, &failure) || failure.LoggerAction() != "instance-quota-exceeded" {
+		t.Fatalf("expected an instance-quota-exceeded failure, got %v", err)
+	}
+	if len(store.buckets) != 0 {
+		t.Errorf("expected no bucket to be created, got %v", store.buckets)
+	}
+}
@@ -0,0 +1,141 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	// maxRecentOperations is how many operations are kept for each instance
+	// in memory, and shown on its dashboard.
+	maxRecentOperations = 20
+
+	// defaultOperationHistoryLimit and maxOperationHistoryLimit bound how
+	// many operations ServeOperations lists.
+	defaultOperationHistoryLimit = 100
+	maxOperationHistoryLimit     = 1000
+)
+
+// Operation is a request that the broker handled for an instance or one of
+// its bindings.
+type Operation struct {
+	InstanceID string `json:"instance_id"`
+	BindingID  string `json:"binding_id,omitempty"`
+	// Action is provision, update, deprovision, delete-bucket, bind or
+	// unbind. delete-bucket is the outcome of an asynchronous deprovision.
+	Action string `json:"action"`
+	// User is the originating identity of the request, if the platform sent
+	// one.
+	User  string    `json:"user,omitempty"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// OperationStore keeps the operations of each instance, for the dashboard and
+// the operation history API.
+type OperationStore interface {
+	RecordOperation(operation Operation) error
+	// ListOperations returns up to limit of an instance's operations, most
+	// recent first.
+	ListOperations(instanceID string, limit int) ([]Operation, error)
+}
+
+// MemoryOperationStore keeps the last maxRecentOperations operations of each
+// instance in process memory. They are lost on restart.
+type MemoryOperationStore struct {
+	mu         sync.Mutex
+	operations map[string][]Operation
+}
+
+func NewMemoryOperationStore() *MemoryOperationStore {
+	return &MemoryOperationStore{
+		operations: make(map[string][]Operation),
+	}
+}
+
+func (m *MemoryOperationStore) RecordOperation(operation Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	operations := append(m.operations[operation.InstanceID], operation)
+	if len(operations) > maxRecentOperations {
+		operations = operations[len(operations)-maxRecentOperations:]
+	}
+	m.operations[operation.InstanceID] = operations
+	return nil
+}
+
+func (m *MemoryOperationStore) ListOperations(instanceID string, limit int) ([]Operation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.operations[instanceID]
+	operations := make([]Operation, 0, min(len(stored), limit))
+	for i := len(stored) - 1; i >= 0 && len(operations) < limit; i-- {
+		operations = append(operations, stored[i])
+	}
+	return operations, nil
+}
+
+// recordOperation records the outcome of a request, publishes it as an event
+// and writes it to the audit trail with details. Failing to record it only
+// costs the history an entry, so the request is not failed.
+func (b *S3Broker) recordOperation(ctx context.Context, instanceID, bindingID, planID, action string, details auditDetails, err error) {
+	operation := Operation{
+		InstanceID: instanceID,
+		BindingID:  bindingID,
+		Action:     action,
+		User:       b.originatingIdentity(ctx).String(),
+		Time:       time.Now(),
+	}
+	if err != nil {
+		operation.Error = err.Error()
+	}
+	b.countOperation(action, err)
+	if b.events != nil || b.audit != nil {
+		event := b.operationEvent(operation, planID)
+		event.RequestID = RequestID(ctx)
+		b.publishEvent(ctx, event)
+		b.writeAuditRecord(ctx, event, details)
+	}
+	if b.operations == nil {
+		return
+	}
+	if err := b.operations.RecordOperation(operation); err != nil {
+		b.logger.Error("record-operation", err, lager.Data{instanceIDLogKey: instanceID})
+	}
+}
+
+// ServeOperations handles GET /admin/instances/{instance_id}/operations,
+// which lists an instance's operations, most recent first, as JSON. The
+// limit query parameter defaults to 100 and is at most 1000. Without a state
+// store, only the last 20 operations since the broker started are kept.
+func (b *S3Broker) ServeOperations(w http.ResponseWriter, r *http.Request) {
+	instanceID := r.PathValue("instance_id")
+
+	limit := defaultOperationHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxOperationHistoryLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxOperationHistoryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	operations, err := b.operations.ListOperations(instanceID, limit)
+	if err != nil {
+		b.logger.Error("list-operations", err, lager.Data{instanceIDLogKey: instanceID})
+		http.Error(w, "could not list operations", http.StatusBadGateway)
+		return
+	}
+	if operations == nil {
+		operations = []Operation{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(operations)
+}
@@ -0,0 +1,65 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServeOperations(t *testing.T) {
+	operations := NewMemoryOperationStore()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, action := range []string{"provision", "bind", "update"} {
+		operations.RecordOperation(Operation{InstanceID: "instance1", Action: action, User: "user1", Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	operations.RecordOperation(Operation{InstanceID: "instance2", Action: "provision", Time: start})
+	b := &S3Broker{
+		logger:     lager.NewLogger("broker-unit-test-operations"),
+		operations: operations,
+	}
+
+	testCases := map[string]struct {
+		query         string
+		expectStatus  int
+		expectActions []string
+	}{
+		"all":           {expectStatus: http.StatusOK, expectActions: []string{"update", "bind", "provision"}},
+		"limited":       {query: "?limit=2", expectStatus: http.StatusOK, expectActions: []string{"update", "bind"}},
+		"invalid limit": {query: "?limit=0", expectStatus: http.StatusBadRequest},
+		"limit too big": {query: "?limit=1001", expectStatus: http.StatusBadRequest},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/instances/instance1/operations"+tc.query, nil)
+			req.SetPathValue("instance_id", "instance1")
+			w := httptest.NewRecorder()
+			b.ServeOperations(w, req)
+
+			if w.Code != tc.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectStatus, w.Code, w.Body)
+			}
+			if tc.expectStatus != http.StatusOK {
+				return
+			}
+			var got []Operation
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var actions []string
+			for _, operation := range got {
+				if operation.InstanceID != "instance1" || operation.User != "user1" {
+					t.Errorf("unexpected operation %+v", operation)
+				}
+				actions = append(actions, operation.Action)
+			}
+			if diff := cmp.Diff(tc.expectActions, actions); diff != "" {
+				t.Errorf("unexpected operations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
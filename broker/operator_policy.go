@@ -0,0 +1,122 @@
+package broker
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+// encryptionNone is the encryption of buckets whose plans set none.
+const encryptionNone = "none"
+
+var (
+	encryptionModes = []string{encryptionNone, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms, s3.ServerSideEncryptionAwsKmsDsse}
+	accessModes     = []string{policyModePrivate, policyModePublicRead, policyModeCustom}
+)
+
+// OperatorPolicy limits the buckets that the broker creates, whatever its
+// plans and users ask for, so that the operator can enforce an
+// organization's data residency and security rules in one place.
+type OperatorPolicy struct {
+	// Regions that buckets may be created in.
+	Regions PolicyRule `yaml:"regions"`
+	// Encryption algorithms that buckets may use by default: none, AES256,
+	// aws:kms or aws:kms:dsse.
+	Encryption PolicyRule `yaml:"encryption"`
+	// Access modes of bucket policies: private, public-read or custom.
+	Access PolicyRule `yaml:"access"`
+}
+
+// PolicyRule allows the values in Allowed, or every value if it is empty,
+// except those in Denied.
+type PolicyRule struct {
+	Allowed []string `yaml:"allowed"`
+	Denied  []string `yaml:"denied"`
+}
+
+func (r PolicyRule) allows(value string) bool {
+	return (len(r.Allowed) == 0 || slices.Contains(r.Allowed, value)) && !slices.Contains(r.Denied, value)
+}
+
+// validate checks that the rule only names values from known, if it is
+// not nil.
+func (r PolicyRule) validate(known []string) error {
+	for _, value := range slices.Concat(r.Allowed, r.Denied) {
+		if value == "" {
+			return fmt.Errorf("must not contain an empty value")
+		}
+		if known != nil && !slices.Contains(known, value) {
+			return fmt.Errorf("%q must be one of %s", value, strings.Join(known, ", "))
+		}
+		if slices.Contains(r.Allowed, value) && slices.Contains(r.Denied, value) {
+			return fmt.Errorf("%q must not be both allowed and denied", value)
+		}
+	}
+	return nil
+}
+
+func (c OperatorPolicy) Validate() error {
+	if err := c.Regions.validate(nil); err != nil {
+		return fmt.Errorf("Regions %s", err)
+	}
+	if err := c.Encryption.validate(encryptionModes); err != nil {
+		return fmt.Errorf("Encryption %s", err)
+	}
+	if err := c.Access.validate(accessModes); err != nil {
+		return fmt.Errorf("Access %s", err)
+	}
+	return nil
+}
+
+// checkRegionPolicy returns an error if the operator's policy does not
+// allow buckets in region. An empty region is the broker's own.
+func (b *S3Broker) checkRegionPolicy(region string) error {
+	region = cmp.Or(region, b.region)
+	if !b.operatorPolicy.Regions.allows(region) {
+		return operatorPolicyDenied("Buckets may not be created in region %s.", region)
+	}
+	return nil
+}
+
+// checkPlanPolicy returns an error if the operator's policy does not allow
+// the encryption or access of the buckets that servicePlan configures. It
+// runs before any AWS call, so a denied request changes nothing.
+func (b *S3Broker) checkPlanPolicy(servicePlan ServicePlan, bucketName, region string) error {
+	// The broker does not manage the configuration of existing buckets.
+	if servicePlan.S3Properties.ExistingBucket {
+		return nil
+	}
+	if encryption := encryptionAlgorithm(servicePlan.S3Properties.Encryption); !b.operatorPolicy.Encryption.allows(encryption) {
+		return operatorPolicyDenied("Plan %q encrypts buckets with %s, which is not allowed.", servicePlan.Name, encryption)
+	}
+	// Templates are rendered first, so that access granted by template
+	// actions is not missed.
+	policy, err := awss3.RenderBucketPolicy(awss3.BucketDetails{
+		BucketName:   bucketName,
+		Region:       cmp.Or(region, b.region),
+		AwsPartition: b.awsPartition,
+		Policy:       servicePlan.S3Properties.BucketPolicy,
+	})
+	if err != nil {
+		policy = servicePlan.S3Properties.BucketPolicy
+	}
+	if access := policyMode(policy); !b.operatorPolicy.Access.allows(access) {
+		return operatorPolicyDenied("Plan %q creates %s buckets, which is not allowed.", servicePlan.Name, access)
+	}
+	return nil
+}
+
+func operatorPolicyDenied(format string, a ...any) error {
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf(format+" The broker's operator policy forbids it; contact your Cloud Foundry operator for details.", a...),
+		http.StatusBadRequest,
+		"operator-policy-denied",
+	)
+}
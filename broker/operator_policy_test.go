@@ -0,0 +1,164 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+const (
+	publicReadPolicy = `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "{{.ARN}}/*"}]}`
+	kmsEncryption    = `{"Rules": [{"ApplyServerSideEncryptionByDefault": {"SSEAlgorithm": "aws:kms", "KMSMasterKeyID": "alias/buckets"}}]}`
+)
+
+func TestOperatorPolicyValidate(t *testing.T) {
+	testCases := map[string]struct {
+		policy    OperatorPolicy
+		expectErr string
+	}{
+		"empty": {},
+		"valid": {
+			policy: OperatorPolicy{
+				Regions:    PolicyRule{Allowed: []string{"us-gov-west-1", "us-gov-east-1"}},
+				Encryption: PolicyRule{Denied: []string{"none"}},
+				Access:     PolicyRule{Allowed: []string{"private"}},
+			},
+		},
+		"unknown encryption": {
+			policy:    OperatorPolicy{Encryption: PolicyRule{Allowed: []string{"sse-c"}}},
+			expectErr: `Encryption "sse-c" must be one of none, AES256, aws:kms, aws:kms:dsse`,
+		},
+		"unknown access": {
+			policy:    OperatorPolicy{Access: PolicyRule{Denied: []string{"public"}}},
+			expectErr: `Access "public" must be one of private, public-read, custom`,
+		},
+		"empty region": {
+			policy:    OperatorPolicy{Regions: PolicyRule{Denied: []string{""}}},
+			expectErr: "Regions must not contain an empty value",
+		},
+		"allowed and denied": {
+			policy:    OperatorPolicy{Regions: PolicyRule{Allowed: []string{"us-east-1"}, Denied: []string{"us-east-1"}}},
+			expectErr: `Regions "us-east-1" must not be both allowed and denied`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.policy.Validate()
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.expectErr {
+				t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckOperatorPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		policy     OperatorPolicy
+		properties S3Properties
+		region     string
+		expectErr  string
+	}{
+		"no policy": {
+			properties: S3Properties{BucketPolicy: publicReadPolicy},
+		},
+		"allowed region": {
+			policy: OperatorPolicy{Regions: PolicyRule{Allowed: []string{"us-gov-west-1", "us-gov-east-1"}}},
+			region: "us-gov-east-1",
+		},
+		"region that is not allowed": {
+			policy:    OperatorPolicy{Regions: PolicyRule{Allowed: []string{"us-gov-west-1"}}},
+			region:    "us-gov-east-1",
+			expectErr: "Buckets may not be created in region us-gov-east-1.",
+		},
+		"broker's region denied": {
+			policy:    OperatorPolicy{Regions: PolicyRule{Denied: []string{"us-gov-west-1"}}},
+			expectErr: "Buckets may not be created in region us-gov-west-1.",
+		},
+		"unencrypted plan": {
+			policy:    OperatorPolicy{Encryption: PolicyRule{Denied: []string{"none"}}},
+			expectErr: `Plan "basic" encrypts buckets with none, which is not allowed.`,
+		},
+		"KMS encrypted plan": {
+			policy:     OperatorPolicy{Encryption: PolicyRule{Allowed: []string{"aws:kms"}}},
+			properties: S3Properties{Encryption: kmsEncryption},
+		},
+		"public plan": {
+			policy:     OperatorPolicy{Access: PolicyRule{Allowed: []string{"private"}}},
+			properties: S3Properties{BucketPolicy: publicReadPolicy},
+			expectErr:  `Plan "basic" creates public-read buckets, which is not allowed.`,
+		},
+		"public statement in a template action": {
+			policy: OperatorPolicy{Access: PolicyRule{Denied: []string{"public-read"}}},
+			properties: S3Properties{
+				BucketPolicy: `{"Statement": [{{if .BucketName}}{"Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "s3:GetObject"}{{end}}]}`,
+			},
+			expectErr: `Plan "basic" creates public-read buckets, which is not allowed.`,
+		},
+		"existing bucket plan": {
+			policy:     OperatorPolicy{Encryption: PolicyRule{Denied: []string{"none"}}},
+			properties: S3Properties{ExistingBucket: true},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{region: "us-gov-west-1", regions: []string{"us-gov-east-1"}, awsPartition: "aws-us-gov", operatorPolicy: tc.policy}
+			servicePlan := ServicePlan{ID: "plan1", Name: "basic", S3Properties: tc.properties}
+			err := b.checkRegionPolicy(tc.region)
+			if err == nil {
+				err = b.checkPlanPolicy(servicePlan, "bucket", tc.region)
+			}
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			var failure *apiresponses.FailureResponse
+			if !errors.As(err, &failure) || failure.LoggerAction() != "operator-policy-denied" {
+				t.Fatalf("expected operator-policy-denied error, got %v", err)
+			}
+			if !strings.HasPrefix(err.Error(), tc.expectErr) {
+				t.Errorf("expected error starting with %q, got %q", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestProvisionOperatorPolicy(t *testing.T) {
+	bucket := &mockBucket{}
+	b := &S3Broker{
+		logger:                       lager.NewLogger("broker-unit-test-operator-policy"),
+		region:                       "us-gov-west-1",
+		regions:                      []string{"us-gov-east-1"},
+		allowUserProvisionParameters: true,
+		bucket:                       bucket,
+		catalog: &mockCatalog{serviceName: "s3", plans: map[string]ServicePlan{
+			"plan1": {ID: "plan1", Name: "basic", S3Properties: S3Properties{IamPolicy: "{}"}},
+		}},
+		tagManager:     &mockTagGenerator{},
+		quotas:         newQuotas(QuotasConfig{}),
+		operatorPolicy: OperatorPolicy{Regions: PolicyRule{Denied: []string{"us-gov-east-1"}}},
+	}
+	_, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{
+		ServiceID:     "service1",
+		PlanID:        "plan1",
+		RawParameters: []byte(`{"region": "us-gov-east-1"}`),
+	}, false)
+	if err == nil || !strings.Contains(err.Error(), "Buckets may not be created in region us-gov-east-1.") {
+		t.Fatalf("expected the region to be denied, got %v", err)
+	}
+	if bucket.name != "" {
+		t.Errorf("expected no bucket to be created, got %s", bucket.name)
+	}
+}
@@ -0,0 +1,16 @@
+package broker
+
+import (
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// orphanMitigation reports err for a provision or bind that failed after
+// creating resources it could not remove again. Platforms answer a 500
+// Internal Server Error with orphan mitigation: they deprovision or unbind
+// right away, and Deprovision and Unbind remove whatever was left behind.
+// Other status codes tell them that nothing was created.
+func orphanMitigation(err error) error {
+	return apiresponses.NewFailureResponse(err, http.StatusInternalServerError, "orphan-mitigation")
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+// expectFailure checks that err is a failure response with status and
+// loggerAction.
+func expectFailure(t *testing.T, err error, status int, loggerAction string) {
+	t.Helper()
+	var failure *apiresponses.FailureResponse
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected failure response %d %s, got %v", status, loggerAction, err)
+	}
+	if failure.ValidatedStatusCode(nil) != status || failure.LoggerAction() != loggerAction {
+		t.Errorf("expected failure response %d %s, got %d %s", status, loggerAction, failure.ValidatedStatusCode(nil), failure.LoggerAction())
+	}
+}
+
+func TestProvisionOrphanMitigation(t *testing.T) {
+	testCases := map[string]struct {
+		createErr    error
+		expectStatus int
+		expectAction string
+	}{
+		"rolled back": {
+			createErr:    &awss3.CreateStepError{Step: awss3.CreateStepPolicy, Err: awss3.ErrPolicyInvalid, RolledBack: true},
+			expectStatus: http.StatusBadRequest,
+			expectAction: "s3-policy-invalid",
+		},
+		"left behind": {
+			createErr:    &awss3.CreateStepError{Step: awss3.CreateStepPolicy, Err: awss3.ErrPolicyInvalid, Orphaned: true},
+			expectStatus: http.StatusInternalServerError,
+			expectAction: "orphan-mitigation",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-orphans"),
+				catalog:      &mockCatalog{planName: "plan1", serviceName: "service1"},
+				tagManager:   &mockTagGenerator{},
+				bucket:       &mockBucket{createErr: tc.createErr},
+				bucketPrefix: "prefix",
+			}
+			_, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+			expectFailure(t, err, tc.expectStatus, tc.expectAction)
+		})
+	}
+}
+
+func TestBindOrphanMitigation(t *testing.T) {
+	putUserPolicyErr := errors.New("put user policy failed")
+
+	testCases := map[string]struct {
+		user           *mockUser
+		expectOrphaned bool
+		expectUserGone bool
+	}{
+		"cleaned up": {
+			user:           &mockUser{putUserPolicyErr: putUserPolicyErr},
+			expectUserGone: true,
+		},
+		"user left behind": {
+			user:           &mockUser{putUserPolicyErr: putUserPolicyErr, deleteUserErr: errors.New("delete user failed")},
+			expectOrphaned: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-orphans"),
+				bucket:       &mockBucket{},
+				catalog:      &mockCatalog{planName: "plan1", serviceName: "service1", s3Properties: S3Properties{IamPolicy: "{}"}},
+				tagManager:   &mockTagGenerator{},
+				user:         tc.user,
+				bucketPrefix: "prefix",
+			}
+			_, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{ServiceID: "service1", PlanID: "plan1"}, false)
+			if tc.expectOrphaned {
+				expectFailure(t, err, http.StatusInternalServerError, "orphan-mitigation")
+			} else if !errors.Is(err, putUserPolicyErr) {
+				t.Fatalf("expected error %v, got %v", putUserPolicyErr, err)
+			}
+			if gone := !tc.user.exists; gone != tc.expectUserGone {
+				t.Errorf("expected user gone %t, got %t", tc.expectUserGone, gone)
+			}
+		})
+	}
+}
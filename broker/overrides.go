@@ -0,0 +1,73 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// overrideParams are the provision and update parameters that change the
+// bucket's configuration, which plans can limit with allowed_override_params.
+var overrideParams = []string{
+	"object_ownership",
+	"region",
+	"cors_rules",
+	"lifecycle_rules",
+	"preserve_on_delete",
+	"alert_threshold_gb",
+	"tags",
+	"annotations",
+}
+
+// allowsOverride reports whether the plan lets users set the bucket
+// configuration parameter name. Plans without allowed_override_params allow
+// all of them.
+func (eq S3Properties) allowsOverride(name string) bool {
+	return eq.AllowedOverrideParams == nil || slices.Contains(eq.AllowedOverrideParams, name)
+}
+
+// withAllowedOverrides removes the parameters that servicePlan does not let
+// users set from the properties of a provision or update schema.
+func withAllowedOverrides(servicePlan ServicePlan, properties map[string]*ParameterSchema) map[string]*ParameterSchema {
+	for _, name := range overrideParams {
+		if !servicePlan.S3Properties.allowsOverride(name) {
+			delete(properties, name)
+		}
+	}
+	return properties
+}
+
+// checkOverrideParams rejects provision or update parameters that set bucket
+// configuration that servicePlan does not let users override. It runs before
+// the parameters are validated against the plan's schema, so users learn
+// that the plan, not the broker, does not take them.
+func checkOverrideParams(servicePlan ServicePlan, rawParameters json.RawMessage) error {
+	if len(bytes.TrimSpace(rawParameters)) == 0 {
+		return nil
+	}
+	var parameters map[string]json.RawMessage
+	if err := json.Unmarshal(rawParameters, &parameters); err != nil {
+		// validateParameters reports parameters that are not an object.
+		return nil
+	}
+
+	var denied []string
+	for _, name := range overrideParams {
+		if _, ok := parameters[name]; ok && !servicePlan.S3Properties.allowsOverride(name) {
+			denied = append(denied, name)
+		}
+	}
+	if len(denied) > 0 {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("Plan %q does not allow the parameters %s", servicePlan.Name, strings.Join(denied, ", ")),
+			http.StatusBadRequest,
+			"parameter-not-allowed",
+		)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+func TestCheckOverrideParams(t *testing.T) {
+	corsOnly := ServicePlan{Name: "plan1", S3Properties: S3Properties{AllowedOverrideParams: []string{"cors_rules"}}}
+
+	testCases := map[string]struct {
+		servicePlan ServicePlan
+		parameters  string
+		expectErr   bool
+	}{
+		"no parameters": {
+			servicePlan: corsOnly,
+		},
+		"plan allows all parameters": {
+			servicePlan: ServicePlan{Name: "plan1"},
+			parameters:  `{"cors_rules": [], "lifecycle_rules": []}`,
+		},
+		"allowed parameter": {
+			servicePlan: corsOnly,
+			parameters:  `{"cors_rules": [], "apply_immediately": true}`,
+		},
+		"parameter that is not allowed": {
+			servicePlan: corsOnly,
+			parameters:  `{"cors_rules": [], "lifecycle_rules": []}`,
+			expectErr:   true,
+		},
+		"plan allows no parameters": {
+			servicePlan: ServicePlan{Name: "plan1", S3Properties: S3Properties{AllowedOverrideParams: []string{}}},
+			parameters:  `{"tags": {"team": "research"}}`,
+			expectErr:   true,
+		},
+		"not an object": {
+			servicePlan: corsOnly,
+			parameters:  `["lifecycle_rules"]`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := checkOverrideParams(tc.servicePlan, json.RawMessage(tc.parameters))
+			if tc.expectErr {
+				var failure *apiresponses.FailureResponse
+				if !errors.As(err, &failure) || failure.LoggerAction() != "parameter-not-allowed" {
+					t.Fatalf("expected parameter-not-allowed error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestSchemasWithAllowedOverrides(t *testing.T) {
+	b := &S3Broker{allowUserProvisionParameters: true, allowUserUpdateParameters: true}
+	servicePlan := ServicePlan{S3Properties: S3Properties{AllowedOverrideParams: []string{"cors_rules", "tags"}}}
+
+	provision := slices.Sorted(maps.Keys(b.provisionSchema(servicePlan).Properties))
+	if diff := cmp.Diff([]string{"cors_rules", "tags"}, provision); diff != "" {
+		t.Errorf("unexpected provision parameters (-want +got):\n%s", diff)
+	}
+	update := slices.Sorted(maps.Keys(b.updateSchema(servicePlan).Properties))
+	if diff := cmp.Diff([]string{"apply_immediately", "cors_rules", "tags"}, update); diff != "" {
+		t.Errorf("unexpected update parameters (-want +got):\n%s", diff)
+	}
+}
@@ -1,7 +1,47 @@
 package broker
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
 type ProvisionParameters struct {
 	ObjectOwnership string `json:"object_ownership"`
+
+	// Region is the region to create the bucket in, which must be the
+	// broker's region or one of the regions that the operator allows.
+	Region string `json:"region"`
+
+	// BucketName is the existing bucket to use with plans for existing
+	// buckets. It is read even if provision parameters are not allowed.
+	BucketName string `json:"bucket_name"`
+
+	// CORSRules and LifecycleRules configure the bucket's CORS and object
+	// lifecycle.
+	CORSRules      []awss3.CORSRule      `json:"cors_rules"`
+	LifecycleRules []awss3.LifecycleRule `json:"lifecycle_rules"`
+
+	// PreserveOnDelete keeps the bucket and its objects when the instance
+	// is deleted, overriding the plan's default.
+	PreserveOnDelete *bool `json:"preserve_on_delete"`
+
+	// AlertThresholdGB is the size of the bucket, in GB, above which the
+	// operators' alerts topic is notified. Zero sets no alert.
+	AlertThresholdGB *float64 `json:"alert_threshold_gb"`
+
+	// Tags are added to the bucket's tags.
+	Tags map[string]string `json:"tags"`
+
+	// Annotations are Kubernetes annotations, which are added to the
+	// bucket's tags along with Tags.
+	Annotations map[string]string `json:"annotations"`
 }
 
 type BindParameters struct {
@@ -10,8 +50,127 @@ type BindParameters struct {
 	// files between buckets. The contents should be a list of service
 	// instance names.
 	AdditionalInstances []string `json:"additional_instances"`
+
+	// Permissions limits what the binding's credentials may do with objects:
+	// "read-write" (the default), "read-only" or "write-only".
+	Permissions Permissions `json:"permissions"`
+
+	// PathPrefix confines the binding to keys under s3://<bucket>/<prefix>/,
+	// so several applications can share one bucket without seeing each
+	// other's objects.
+	PathPrefix string `json:"path_prefix"`
+
+	// CreateFolder creates a folder object for the binding's prefix, which
+	// defaults to the app's GUID, or the binding's GUID for service keys.
+	CreateFolder bool `json:"create_folder"`
+
+	// CredentialType "temporary" returns short-lived STS credentials and a
+	// refresh token instead of a long-lived IAM access key.
+	CredentialType CredentialType `json:"credential_type"`
+
+	// Principal and ExternalID are required with CredentialType "role". The
+	// binding's role trusts Principal, an AWS account ID or IAM ARN, when it
+	// presents ExternalID. With CredentialType "bucket-policy", the bucket
+	// policy grants Principal access directly. With SecretName, Principal may
+	// also read the secret.
+	Principal  string `json:"principal"`
+	ExternalID string `json:"external_id"`
+
+	// OIDCProviderARN and Subject are required with CredentialType
+	// "web-identity". The binding's role trusts tokens from the IAM OIDC
+	// provider issued to Subject, a Kubernetes service account for IRSA.
+	OIDCProviderARN string `json:"oidc_provider_arn"`
+	Subject         string `json:"subject"`
+
+	// SecretName delivers an access key binding's credentials through a
+	// Secrets Manager secret of that name, under the broker's configured
+	// prefix, instead of in the binding.
+	SecretName string `json:"secret_name"`
+
+	// CredentialFormat selects the shape of the returned credentials, such
+	// as "kubernetes" for flat environment variable names. It overrides the
+	// plan's format.
+	CredentialFormat string `json:"credential_format"`
+}
+
+// BindContext is the part of a request's OSB context that the broker uses
+// to place and tag the binding's principal or the instance's bucket. Cloud
+// Foundry contexts have an organization and space, and Kubernetes contexts a
+// cluster and namespace.
+type BindContext struct {
+	Platform         string `json:"platform"`
+	OrganizationGUID string `json:"organization_guid"`
+	SpaceGUID        string `json:"space_guid"`
+	ClusterID        string `json:"clusterid"`
+	Namespace        string `json:"namespace"`
+}
+
+func parseBindContext(rawContext json.RawMessage) (BindContext, error) {
+	var bindContext BindContext
+	if len(rawContext) > 0 {
+		if err := json.Unmarshal(rawContext, &bindContext); err != nil {
+			return BindContext{}, err
+		}
+	}
+	return bindContext, nil
+}
+
+// bindAppGUID returns the GUID of the app being bound, which is empty for
+// service keys.
+func bindAppGUID(details domain.BindDetails) string {
+	if details.BindResource != nil && details.BindResource.AppGuid != "" {
+		return details.BindResource.AppGuid
+	}
+	return details.AppGUID
 }
 
 type UpdateParameters struct {
 	ApplyImmediately bool `json:"apply_immediately"`
+
+	// CORSRules and LifecycleRules replace the bucket's CORS and object
+	// lifecycle configuration. An empty list removes it, and leaving them
+	// out keeps the current configuration.
+	CORSRules      []awss3.CORSRule      `json:"cors_rules"`
+	LifecycleRules []awss3.LifecycleRule `json:"lifecycle_rules"`
+
+	// PreserveOnDelete changes whether the bucket is kept when the instance
+	// is deleted. Leaving it out keeps the current setting.
+	PreserveOnDelete *bool `json:"preserve_on_delete"`
+
+	// AlertThresholdGB changes the size of the bucket, in GB, above which the
+	// operators' alerts topic is notified. Zero removes the alert, and
+	// leaving it out keeps the current one.
+	AlertThresholdGB *float64 `json:"alert_threshold_gb"`
+
+	// Tags are added to the bucket's tags, replacing the values of keys it
+	// already has. Tags that are left out are kept.
+	Tags map[string]string `json:"tags"`
+
+	// Annotations are Kubernetes annotations, which are added to the
+	// bucket's tags along with Tags.
+	Annotations map[string]string `json:"annotations"`
+}
+
+// normalizePathPrefix strips surrounding slashes from a path_prefix bind
+// parameter and rejects values that would not confine the binding to a
+// single key prefix.
+func normalizePathPrefix(pathPrefix string) (string, error) {
+	pathPrefix = strings.Trim(pathPrefix, "/")
+	if strings.ContainsAny(pathPrefix, "*?$") || strings.Contains(pathPrefix, "//") {
+		return "", apiresponses.NewFailureResponse(
+			fmt.Errorf("path_prefix %q must not contain '*', '?', '$' or empty path segments", pathPrefix),
+			http.StatusBadRequest,
+			"invalid-path-prefix",
+		)
+	}
+	for _, segment := range strings.Split(pathPrefix, "/") {
+		if segment == "." || segment == ".." {
+			return "", apiresponses.NewFailureResponse(
+				fmt.Errorf("path_prefix %q must not contain '.' or '..' segments", pathPrefix),
+				http.StatusBadRequest,
+				"invalid-path-prefix",
+			)
+		}
+	}
+	return pathPrefix, nil
 }
@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// PlanAccess restricts which organizations and spaces can provision a plan,
+// even if the platform makes the plan visible to others. Entries are GUIDs
+// or names.
+type PlanAccess struct {
+	AllowedOrgs   []string `yaml:"allowed_orgs,omitempty"`
+	DeniedOrgs    []string `yaml:"denied_orgs,omitempty"`
+	AllowedSpaces []string `yaml:"allowed_spaces,omitempty"`
+	DeniedSpaces  []string `yaml:"denied_spaces,omitempty"`
+}
+
+// restricted reports whether the plan has any access rules.
+func (a PlanAccess) restricted() bool {
+	return len(a.AllowedOrgs) > 0 || len(a.DeniedOrgs) > 0 || len(a.AllowedSpaces) > 0 || len(a.DeniedSpaces) > 0
+}
+
+// allows reports whether instances of the plan can be in place. Denials
+// take precedence. If the plan allows any organizations or spaces, place
+// must be in one of them.
+func (a PlanAccess) allows(place instancePlace) bool {
+	if place.inOrg(a.DeniedOrgs) || place.inSpace(a.DeniedSpaces) {
+		return false
+	}
+	if len(a.AllowedOrgs) == 0 && len(a.AllowedSpaces) == 0 {
+		return true
+	}
+	return place.inOrg(a.AllowedOrgs) || place.inSpace(a.AllowedSpaces)
+}
+
+// instancePlace is the organization and space of an instance. Platforms
+// send the names in the request context; without them, only GUIDs match.
+type instancePlace struct {
+	OrganizationGUID string
+	OrganizationName string `json:"organization_name"`
+	SpaceGUID        string
+	SpaceName        string `json:"space_name"`
+}
+
+func (p instancePlace) inOrg(orgs []string) bool {
+	return slices.ContainsFunc(orgs, func(org string) bool {
+		return org == p.OrganizationGUID || (p.OrganizationName != "" && org == p.OrganizationName)
+	})
+}
+
+func (p instancePlace) inSpace(spaces []string) bool {
+	return slices.ContainsFunc(spaces, func(space string) bool {
+		return space == p.SpaceGUID || (p.SpaceName != "" && space == p.SpaceName)
+	})
+}
+
+// checkPlanAccess returns an error if servicePlan may not be used in the
+// organization and space of a provision or update request.
+func checkPlanAccess(servicePlan ServicePlan, organizationGUID, spaceGUID string, rawContext json.RawMessage) error {
+	if !servicePlan.Access.restricted() {
+		return nil
+	}
+	var place instancePlace
+	if len(rawContext) > 0 {
+		if err := json.Unmarshal(rawContext, &place); err != nil {
+			return err
+		}
+	}
+	place.OrganizationGUID = organizationGUID
+	place.SpaceGUID = spaceGUID
+	if servicePlan.Access.allows(place) {
+		return nil
+	}
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("Plan %q is not available to this organization or space. Contact your Cloud Foundry operator for details.", servicePlan.Name),
+		http.StatusForbidden,
+		"plan-not-allowed",
+	)
+}
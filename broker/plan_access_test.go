@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+func TestCheckPlanAccess(t *testing.T) {
+	context := json.RawMessage(`{"platform":"cloudfoundry","organization_name":"research","space_name":"prod"}`)
+
+	testCases := map[string]struct {
+		access      PlanAccess
+		context     json.RawMessage
+		expectAllow bool
+	}{
+		"unrestricted": {
+			expectAllow: true,
+		},
+		"allowed org by GUID": {
+			access:      PlanAccess{AllowedOrgs: []string{"org-1"}},
+			expectAllow: true,
+		},
+		"allowed org by name": {
+			access:      PlanAccess{AllowedOrgs: []string{"research"}},
+			context:     context,
+			expectAllow: true,
+		},
+		"org name without context": {
+			access: PlanAccess{AllowedOrgs: []string{"research"}},
+		},
+		"other org": {
+			access:  PlanAccess{AllowedOrgs: []string{"finance", "org-2"}},
+			context: context,
+		},
+		"allowed space in other org": {
+			access:      PlanAccess{AllowedOrgs: []string{"finance"}, AllowedSpaces: []string{"space-1"}},
+			expectAllow: true,
+		},
+		"denied org": {
+			access:  PlanAccess{DeniedOrgs: []string{"research"}},
+			context: context,
+		},
+		"denied space in allowed org": {
+			access:  PlanAccess{AllowedOrgs: []string{"org-1"}, DeniedSpaces: []string{"prod"}},
+			context: context,
+		},
+		"not denied": {
+			access:      PlanAccess{DeniedOrgs: []string{"finance"}, DeniedSpaces: []string{"space-2"}},
+			context:     context,
+			expectAllow: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			servicePlan := ServicePlan{Name: "public", Access: tc.access}
+			err := checkPlanAccess(servicePlan, "org-1", "space-1", tc.context)
+			if tc.expectAllow {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			failure, ok := err.(*apiresponses.FailureResponse)
+			if !ok {
+				t.Fatalf("expected a failure response, got %v", err)
+			}
+			if failure.LoggerAction() != "plan-not-allowed" {
+				t.Errorf("expected plan-not-allowed, got %s", failure.LoggerAction())
+			}
+		})
+	}
+}
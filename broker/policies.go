@@ -0,0 +1,92 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// Permissions selects which IAM policy template a binding's user receives.
+type Permissions string
+
+const (
+	PermissionsReadWrite Permissions = "read-write"
+	PermissionsReadOnly  Permissions = "read-only"
+	PermissionsWriteOnly Permissions = "write-only"
+)
+
+// defaultReadOnlyIamPolicy is used for read-only bindings on plans that do
+// not set read_only_iam_policy.
+const defaultReadOnlyIamPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Action": [
+        "s3:GetBucketLocation",
+        "s3:ListBucket",
+        "s3:ListBucketVersions"
+      ],
+      "Effect": "Allow",
+      "Resource": {{resources ""}}
+    },
+    {
+      "Action": [
+        "s3:GetObject",
+        "s3:GetObjectVersion"
+      ],
+      "Effect": "Allow",
+      "Resource": {{resources "/*"}}
+    }
+  ]
+}`
+
+// defaultWriteOnlyIamPolicy is used for write-only bindings on plans that do
+// not set write_only_iam_policy.
+const defaultWriteOnlyIamPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Action": [
+        "s3:GetBucketLocation",
+        "s3:ListBucketMultipartUploads"
+      ],
+      "Effect": "Allow",
+      "Resource": {{resources ""}}
+    },
+    {
+      "Action": [
+        "s3:AbortMultipartUpload",
+        "s3:ListMultipartUploadParts",
+        "s3:PutObject"
+      ],
+      "Effect": "Allow",
+      "Resource": {{resources "/*"}}
+    }
+  ]
+}`
+
+// IamPolicyFor returns the IAM policy template for a binding with the given
+// permissions. An empty value means read-write.
+func (p S3Properties) IamPolicyFor(permissions Permissions) (string, error) {
+	switch permissions {
+	case "", PermissionsReadWrite:
+		return p.IamPolicy, nil
+	case PermissionsReadOnly:
+		if p.ReadOnlyIamPolicy != "" {
+			return p.ReadOnlyIamPolicy, nil
+		}
+		return defaultReadOnlyIamPolicy, nil
+	case PermissionsWriteOnly:
+		if p.WriteOnlyIamPolicy != "" {
+			return p.WriteOnlyIamPolicy, nil
+		}
+		return defaultWriteOnlyIamPolicy, nil
+	default:
+		return "", apiresponses.NewFailureResponse(
+			fmt.Errorf("permissions must be one of %q, %q or %q, got %q", PermissionsReadOnly, PermissionsReadWrite, PermissionsWriteOnly, permissions),
+			http.StatusBadRequest,
+			"invalid-permissions",
+		)
+	}
+}
@@ -0,0 +1,57 @@
+package broker
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	// PreserveOnDeleteTagKey records an instance's preserve_on_delete
+	// parameter on its bucket. Without it, the plan's default applies.
+	PreserveOnDeleteTagKey = "Preserve on delete"
+
+	// ReleasedAtTagKey and ReleasedInstanceTagKey mark a bucket that was kept
+	// when its instance was deleted.
+	ReleasedAtTagKey       = "Released at"
+	ReleasedInstanceTagKey = "Released instance GUID"
+)
+
+// addPreserveOnDeleteTag records preserveOnDelete in tags, if it was given,
+// and returns tags.
+func addPreserveOnDeleteTag(tags map[string]string, preserveOnDelete *bool) map[string]string {
+	if preserveOnDelete == nil {
+		return tags
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags[PreserveOnDeleteTagKey] = strconv.FormatBool(*preserveOnDelete)
+	return tags
+}
+
+// preserveOnDelete reports whether deleting the instance should keep its
+// bucket: the instance's preserve_on_delete parameter, or else the plan's
+// default.
+func (b *S3Broker) preserveOnDelete(ctx context.Context, bucketName string, servicePlan ServicePlan) (bool, error) {
+	tags, err := b.bucket.Tags(ctx, bucketName)
+	if err != nil {
+		return false, mapBucketError(err)
+	}
+	if preserve, err := strconv.ParseBool(tags[PreserveOnDeleteTagKey]); err == nil {
+		return preserve, nil
+	}
+	return servicePlan.S3Properties.PreserveOnDelete, nil
+}
+
+// retainBucket keeps an instance's bucket and its objects when the instance
+// is deleted, tagging it as released so that its owners can find it.
+func (b *S3Broker) retainBucket(ctx context.Context, instanceID, bucketName string) error {
+	b.logger.Info("retain-bucket", lager.Data{instanceIDLogKey: instanceID, "bucket": bucketName})
+	return mapBucketError(b.bucket.Retain(ctx, bucketName, map[string]string{
+		ReleasedAtTagKey:       time.Now().UTC().Format(time.RFC3339),
+		ReleasedInstanceTagKey: instanceID,
+	}))
+}
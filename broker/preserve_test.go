@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func TestDeprovisionPreserveOnDelete(t *testing.T) {
+	testCases := map[string]struct {
+		planDefault  bool
+		bucketTags   map[string]string
+		expectRetain bool
+	}{
+		"deleted by default": {},
+		"preserved by plan default": {
+			planDefault:  true,
+			expectRetain: true,
+		},
+		"preserved by instance parameter": {
+			bucketTags:   map[string]string{PreserveOnDeleteTagKey: "true"},
+			expectRetain: true,
+		},
+		"instance parameter overrides plan default": {
+			planDefault: true,
+			bucketTags:  map[string]string{PreserveOnDeleteTagKey: "false"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := &mockBucket{tags: tc.bucketTags}
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-preserve"),
+				bucket:       bucket,
+				catalog:      &mockCatalog{planName: "plan1", s3Properties: S3Properties{PreserveOnDelete: tc.planDefault}},
+				bucketPrefix: "prefix",
+			}
+			if _, err := b.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{PlanID: "plan1"}, false); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if bucket.deleted == tc.expectRetain {
+				t.Errorf("expected deleted %t, got %t", !tc.expectRetain, bucket.deleted)
+			}
+			retainedTags, retained := bucket.retained["prefix-instance1"]
+			if retained != tc.expectRetain {
+				t.Fatalf("expected retained %t, got %t", tc.expectRetain, retained)
+			}
+			if retained && retainedTags[ReleasedInstanceTagKey] != "instance1" {
+				t.Errorf("expected bucket to be tagged with instance1, got %v", retainedTags)
+			}
+		})
+	}
+}
+
+func TestProvisionPreserveOnDelete(t *testing.T) {
+	b := &S3Broker{
+		logger:                       lager.NewLogger("broker-unit-test-preserve"),
+		catalog:                      &mockCatalog{serviceName: "service1", planName: "plan1"},
+		tagManager:                   &mockTagGenerator{},
+		allowUserProvisionParameters: true,
+	}
+	var parameters ProvisionParameters
+	if err := json.Unmarshal([]byte(`{"preserve_on_delete": true}`), &parameters); err != nil {
+		t.Fatal(err)
+	}
+	bucketDetails, err := b.createBucket(context.Background(), "instance1", ServicePlan{Name: "plan1"}, parameters, domain.ProvisionDetails{ServiceID: "service1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if bucketDetails.Tags[PreserveOnDeleteTagKey] != "true" {
+		t.Errorf("expected bucket to be tagged to be preserved, got %v", bucketDetails.Tags)
+	}
+}
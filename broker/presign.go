@@ -0,0 +1,247 @@
+package broker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+const (
+	defaultPresignedURLTTL    = 15 * time.Minute
+	defaultPresignedURLMaxTTL = time.Hour
+	// maxPresignedURLTTL is the longest SigV4 allows.
+	maxPresignedURLTTL = 7 * 24 * time.Hour
+	maxObjectKeyLength = 1024
+)
+
+var (
+	ErrPresignedURLsDisabled = apiresponses.NewFailureResponse(
+		errors.New("This broker is not configured to issue presigned URLs. Contact your Cloud Foundry operator for details."),
+		http.StatusBadRequest,
+		"presigned-urls-disabled",
+	)
+	ErrPresignedURLAdditionalInstances = apiresponses.NewFailureResponse(
+		errors.New("additional_instances cannot be used with credential_type presigned-url"),
+		http.StatusBadRequest,
+		"invalid-additional-instances",
+	)
+	ErrPresignBindingNotFound = errors.New("presign binding not found")
+	ErrInvalidPresignToken    = errors.New("invalid presign token")
+	ErrPresignNotAllowed      = errors.New("binding does not allow this request")
+	ErrInvalidPresignRequest  = errors.New("invalid presign request")
+)
+
+// PresignBinding is what the broker keeps about a binding that mints
+// presigned URLs. Only a hash of the binding's token is kept.
+type PresignBinding struct {
+	InstanceID  string
+	BindingID   string
+	Account     string
+	BucketName  string
+	PathPrefix  string
+	Permissions Permissions
+	TokenHash   string
+}
+
+// PresignBindingStore persists presign bindings between Bind, URL requests
+// and Unbind.
+type PresignBindingStore interface {
+	GetPresignBinding(bindingID string) (PresignBinding, error)
+	SavePresignBinding(binding PresignBinding) error
+	DeletePresignBinding(bindingID string) error
+}
+
+// MemoryPresignBindingStore keeps presign bindings in process memory. They
+// are lost on restart, after which apps must be rebound.
+type MemoryPresignBindingStore struct {
+	mu       sync.Mutex
+	bindings map[string]PresignBinding
+}
+
+func NewMemoryPresignBindingStore() *MemoryPresignBindingStore {
+	return &MemoryPresignBindingStore{
+		bindings: make(map[string]PresignBinding),
+	}
+}
+
+func (m *MemoryPresignBindingStore) GetPresignBinding(bindingID string) (PresignBinding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	binding, ok := m.bindings[bindingID]
+	if !ok {
+		return PresignBinding{}, ErrPresignBindingNotFound
+	}
+	return binding, nil
+}
+
+func (m *MemoryPresignBindingStore) SavePresignBinding(binding PresignBinding) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bindings[binding.BindingID] = binding
+	return nil
+}
+
+func (m *MemoryPresignBindingStore) DeletePresignBinding(bindingID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bindings, bindingID)
+	return nil
+}
+
+// bindPresignedURL gives the binding a token to request presigned URLs from
+// the broker instead of any AWS credentials.
+func (b *S3Broker) bindPresignedURL(
+	instanceID, bindingID, bucketName string,
+	permissions Permissions,
+	pathPrefix string,
+	credentials Credentials,
+) (domain.Binding, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return domain.Binding{}, err
+	}
+	if permissions == "" {
+		permissions = PermissionsReadWrite
+	}
+	err = b.presignBindings.SavePresignBinding(PresignBinding{
+		InstanceID:  instanceID,
+		BindingID:   bindingID,
+		Account:     b.account,
+		BucketName:  bucketName,
+		PathPrefix:  pathPrefix,
+		Permissions: permissions,
+		TokenHash:   hashRefreshToken(token),
+	})
+	if err != nil {
+		return domain.Binding{}, err
+	}
+
+	credentials.PresignURL = fmt.Sprintf("%s/bindings/%s/presign", b.presignURL, url.PathEscape(bindingID))
+	credentials.PresignToken = token
+	return domain.Binding{Credentials: credentials}, nil
+}
+
+// PresignRequest asks for a URL to GET or PUT Key. ExpiresIn is in seconds
+// and defaults to 15 minutes.
+type PresignRequest struct {
+	Key       string `json:"key"`
+	Method    string `json:"method"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+type presignResponse struct {
+	URL        string `json:"url"`
+	Method     string `json:"method"`
+	Expiration string `json:"expiration"`
+}
+
+// PresignObjectURL checks the token handed out by Bind and that the binding
+// may make the request, then presigns it. The expiration is returned along
+// with the URL.
+func (b *S3Broker) PresignObjectURL(bindingID, token string, presignRequest PresignRequest) (string, time.Time, error) {
+	if b.presignBindings == nil || b.presignURL == "" {
+		return "", time.Time{}, ErrPresignedURLsDisabled
+	}
+
+	binding, err := b.presignBindings.GetPresignBinding(bindingID)
+	if errors.Is(err, ErrPresignBindingNotFound) {
+		return "", time.Time{}, ErrInvalidPresignToken
+	} else if err != nil {
+		return "", time.Time{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(binding.TokenHash), []byte(hashRefreshToken(token))) != 1 {
+		return "", time.Time{}, ErrInvalidPresignToken
+	}
+
+	method := strings.ToUpper(presignRequest.Method)
+	switch {
+	case method == http.MethodGet && binding.Permissions == PermissionsWriteOnly,
+		method == http.MethodPut && binding.Permissions == PermissionsReadOnly:
+		return "", time.Time{}, ErrPresignNotAllowed
+	case method != http.MethodGet && method != http.MethodPut:
+		return "", time.Time{}, fmt.Errorf("%w: method must be %s or %s, got %q", ErrInvalidPresignRequest, http.MethodGet, http.MethodPut, presignRequest.Method)
+	}
+
+	key := presignRequest.Key
+	if key == "" || len(key) > maxObjectKeyLength {
+		return "", time.Time{}, fmt.Errorf("%w: key must be between 1 and %d bytes long", ErrInvalidPresignRequest, maxObjectKeyLength)
+	}
+	if binding.PathPrefix != "" && !strings.HasPrefix(key, binding.PathPrefix+"/") {
+		return "", time.Time{}, ErrPresignNotAllowed
+	}
+
+	ttl := time.Duration(presignRequest.ExpiresIn) * time.Second
+	if ttl == 0 {
+		ttl = min(defaultPresignedURLTTL, b.presignMaxTTL)
+	}
+	if ttl < 0 || ttl > b.presignMaxTTL {
+		return "", time.Time{}, fmt.Errorf("%w: expires_in must be between 1 and %d seconds", ErrInvalidPresignRequest, int(b.presignMaxTTL.Seconds()))
+	}
+
+	expiration := time.Now().Add(ttl)
+	presigned, err := b.forAccount(binding.Account).bucket.PresignObjectURL(binding.BucketName, key, method, ttl)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return presigned, expiration, nil
+}
+
+// ServePresign handles POST /bindings/{binding_id}/presign. Apps send the
+// presign token from their binding as a bearer token and a PresignRequest
+// as the body.
+func (b *S3Broker) ServePresign(w http.ResponseWriter, r *http.Request) {
+	bindingID := r.PathValue("binding_id")
+	logger := b.logger.Session("presign-url", lager.Data{bindingIDLogKey: bindingID})
+
+	token, ok := bearerToken(r)
+	if !ok {
+		http.Error(w, ErrInvalidPresignToken.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var presignRequest PresignRequest
+	if err := json.NewDecoder(r.Body).Decode(&presignRequest); err != nil {
+		http.Error(w, ErrInvalidPresignRequest.Error(), http.StatusBadRequest)
+		return
+	}
+
+	presigned, expiration, err := b.PresignObjectURL(bindingID, token, presignRequest)
+	switch {
+	case errors.Is(err, ErrInvalidPresignToken):
+		logger.Info("rejected")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, ErrPresignNotAllowed):
+		logger.Info("forbidden", lager.Data{"key": presignRequest.Key, "method": presignRequest.Method})
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, ErrPresignedURLsDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrInvalidPresignRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		logger.Error("presign", err)
+		http.Error(w, "could not presign URL", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(presignResponse{
+		URL:        presigned,
+		Method:     strings.ToUpper(presignRequest.Method),
+		Expiration: expiration.UTC().Format(time.RFC3339),
+	})
+}
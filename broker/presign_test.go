@@ -0,0 +1,186 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func withPresignBindings(b *S3Broker) {
+	b.catalog = &mockCatalog{planName: "plan1", serviceName: "service1", s3Properties: S3Properties{IamPolicy: "{}"}}
+	b.bucketPrefix = "prefix"
+	b.presignBindings = NewMemoryPresignBindingStore()
+	b.presignURL = "https://broker.example.com"
+	b.presignMaxTTL = time.Hour
+}
+
+func TestBindPresignedURL(t *testing.T) {
+	testCases := map[string]struct {
+		presignURL string
+		parameters string
+		expectErr  string
+	}{
+		"creates presign binding": {
+			presignURL: "https://broker.example.com",
+			parameters: `{"credential_type": "presigned-url", "permissions": "read-only", "path_prefix": "reports"}`,
+		},
+		"disabled": {
+			parameters: `{"credential_type": "presigned-url"}`,
+			expectErr:  ErrPresignedURLsDisabled.Error(),
+		},
+		"additional instances": {
+			presignURL: "https://broker.example.com",
+			parameters: `{"credential_type": "presigned-url", "additional_instances": ["instance2"]}`,
+			expectErr:  ErrPresignedURLAdditionalInstances.Error(),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withPresignBindings)
+			b.presignURL = tc.presignURL
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				PlanID:        "plan1",
+				ServiceID:     "service1",
+				RawParameters: json.RawMessage(tc.parameters),
+			}, false)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected err %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			credentials := binding.Credentials.(Credentials)
+			if credentials.PresignURL != "https://broker.example.com/bindings/binding1/presign" || credentials.PresignToken == "" {
+				t.Errorf("expected presign url and token, got %+v", credentials)
+			}
+			if credentials.AccessKeyID != "" || b.user.(*mockUser).exists {
+				t.Errorf("expected no IAM user or access key")
+			}
+			stored, err := b.presignBindings.GetPresignBinding("binding1")
+			if err != nil {
+				t.Fatalf("expected the binding to be stored: %s", err)
+			}
+			if stored.BucketName != "prefix-instance1" || stored.PathPrefix != "reports" || stored.Permissions != PermissionsReadOnly {
+				t.Errorf("unexpected stored binding %+v", stored)
+			}
+			if stored.TokenHash == credentials.PresignToken {
+				t.Errorf("presign token must not be stored in plain text")
+			}
+		})
+	}
+}
+
+func TestServePresign(t *testing.T) {
+	b := newTestBroker(withPresignBindings)
+	binding, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+		PlanID:        "plan1",
+		ServiceID:     "service1",
+		RawParameters: json.RawMessage(`{"credential_type": "presigned-url", "permissions": "write-only", "path_prefix": "uploads"}`),
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := binding.Credentials.(Credentials).PresignToken
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /bindings/{binding_id}/presign", b.ServePresign)
+
+	presign := func(authorization, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/bindings/binding1/presign", bytes.NewBufferString(body))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	testCases := map[string]struct {
+		authorization string
+		body          string
+		expectStatus  int
+		expectURL     string
+	}{
+		"put under prefix": {
+			authorization: "Bearer " + token,
+			body:          `{"key": "uploads/photo.jpg", "method": "put"}`,
+			expectStatus:  http.StatusOK,
+			expectURL:     "https://prefix-instance1.s3.amazonaws.com/uploads/photo.jpg?method=PUT&expires=900",
+		},
+		"custom expiry": {
+			authorization: "Bearer " + token,
+			body:          `{"key": "uploads/photo.jpg", "method": "PUT", "expires_in": 60}`,
+			expectStatus:  http.StatusOK,
+			expectURL:     "https://prefix-instance1.s3.amazonaws.com/uploads/photo.jpg?method=PUT&expires=60",
+		},
+		"missing token": {
+			body:         `{"key": "uploads/photo.jpg", "method": "PUT"}`,
+			expectStatus: http.StatusUnauthorized,
+		},
+		"wrong token": {
+			authorization: "Bearer not-the-token",
+			body:          `{"key": "uploads/photo.jpg", "method": "PUT"}`,
+			expectStatus:  http.StatusUnauthorized,
+		},
+		"get on write-only binding": {
+			authorization: "Bearer " + token,
+			body:          `{"key": "uploads/photo.jpg", "method": "GET"}`,
+			expectStatus:  http.StatusForbidden,
+		},
+		"key outside prefix": {
+			authorization: "Bearer " + token,
+			body:          `{"key": "other/photo.jpg", "method": "PUT"}`,
+			expectStatus:  http.StatusForbidden,
+		},
+		"unsupported method": {
+			authorization: "Bearer " + token,
+			body:          `{"key": "uploads/photo.jpg", "method": "DELETE"}`,
+			expectStatus:  http.StatusBadRequest,
+		},
+		"expiry too long": {
+			authorization: "Bearer " + token,
+			body:          `{"key": "uploads/photo.jpg", "method": "PUT", "expires_in": 7200}`,
+			expectStatus:  http.StatusBadRequest,
+		},
+		"invalid body": {
+			authorization: "Bearer " + token,
+			body:          `key=uploads/photo.jpg`,
+			expectStatus:  http.StatusBadRequest,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rec := presign(tc.authorization, tc.body)
+			if rec.Code != tc.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectStatus, rec.Code, rec.Body)
+			}
+			if tc.expectStatus != http.StatusOK {
+				return
+			}
+			var response presignResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.URL != tc.expectURL || response.Method != http.MethodPut || response.Expiration == "" {
+				t.Errorf("unexpected response %+v", response)
+			}
+		})
+	}
+
+	if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if rec := presign("Bearer "+token, `{"key": "uploads/photo.jpg", "method": "PUT"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected presign to be rejected after unbind, got %d", rec.Code)
+	}
+}
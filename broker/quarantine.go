@@ -0,0 +1,191 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+const (
+	defaultQuarantineDuration = 24 * time.Hour
+	maxQuarantineDuration     = 30 * 24 * time.Hour
+)
+
+var (
+	ErrBindingNotQuarantinable = errors.New("binding has no IAM user to quarantine")
+	ErrInvalidQuarantine       = errors.New("invalid quarantine request")
+)
+
+// Quarantine describes a quarantined binding: the access keys that were
+// deactivated and the time until which the bucket policy denies its user.
+type Quarantine struct {
+	DeactivatedAccessKeyIDs []string  `json:"deactivated_access_key_ids"`
+	Bucket                  string    `json:"bucket"`
+	Until                   time.Time `json:"until"`
+}
+
+// quarantineSid returns the Sid of a binding's quarantine statement.
+func quarantineSid(bindingID string) string {
+	return "Quarantine" + nonAlphanumericPattern.ReplaceAllString(bindingID, "")
+}
+
+// QuarantineBinding contains a leaked access key without deprovisioning: it
+// deactivates every access key of the binding's IAM user and denies the user
+// all access to the instance's bucket until duration has passed. The deny
+// statement expires on its own, and also holds for keys issued by a later
+// rotation, so the app can be given new keys before it is lifted. The
+// quarantine is written to the audit trail.
+func (b *S3Broker) QuarantineBinding(ctx context.Context, instanceID, bindingID string, servicePlan ServicePlan, duration time.Duration) (_ Quarantine, err error) {
+	quarantine := Quarantine{DeactivatedAccessKeyIDs: []string{}}
+	// Keys deactivated before a failure are audited too.
+	defer func() {
+		b.AuditAction(ctx, AuditEvent{
+			Event: Event{Action: "quarantine-binding", InstanceID: instanceID, BindingID: bindingID, PlanID: servicePlan.ID, Bucket: quarantine.Bucket},
+			Details: map[string]any{
+				"deactivated_access_key_ids": quarantine.DeactivatedAccessKeyIDs,
+				"until":                      quarantine.Until,
+			},
+		}, err)
+	}()
+
+	b = b.forInstance(ctx, instanceID, servicePlan)
+	userName := b.userName(bindingID)
+	logger := b.logger.Session("quarantine-binding", lager.Data{
+		instanceIDLogKey: instanceID,
+		bindingIDLogKey:  bindingID,
+	})
+
+	accessKeys, err := b.user.DescribeAccessKeys(userName)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == iam.ErrCodeNoSuchEntityException {
+			return Quarantine{}, ErrBindingNotQuarantinable
+		}
+		return Quarantine{}, err
+	}
+
+	for _, accessKey := range accessKeys {
+		if accessKey.Status != iam.StatusTypeActive {
+			continue
+		}
+		if err := b.user.DeactivateAccessKey(userName, accessKey.AccessKeyID); err != nil {
+			logger.Error("deactivate-access-key", err, lager.Data{"access-key-id": accessKey.AccessKeyID})
+			return Quarantine{}, err
+		}
+		logger.Info("deactivated-access-key", lager.Data{"access-key-id": accessKey.AccessKeyID})
+		quarantine.DeactivatedAccessKeyIDs = append(quarantine.DeactivatedAccessKeyIDs, accessKey.AccessKeyID)
+	}
+
+	userDetails, err := b.user.Describe(userName)
+	if err != nil {
+		return Quarantine{}, err
+	}
+	bucketName, err := b.instanceBucketName(ctx, instanceID, servicePlan)
+	if err != nil {
+		return Quarantine{}, mapBucketError(err)
+	}
+
+	quarantine.Bucket = bucketName
+	quarantine.Until = time.Now().Add(duration).UTC().Truncate(time.Second)
+	bucketARN := fmt.Sprintf("arn:%s:s3:::%s", b.awsPartition, bucketName)
+	statement := awss3.PolicyStatement{
+		Sid:       quarantineSid(bindingID),
+		Effect:    "Deny",
+		Principal: map[string]string{"AWS": userDetails.UserARN},
+		Action:    []string{"s3:*"},
+		Resource:  []string{bucketARN, bucketARN + "/*"},
+		Condition: map[string]map[string]string{
+			"DateLessThan": {"aws:CurrentTime": quarantine.Until.Format(time.RFC3339)},
+		},
+	}
+	if err := b.bucket.AddPolicyStatements(ctx, bucketName, []awss3.PolicyStatement{statement}); err != nil {
+		logger.Error("add-deny-statement", err, lager.Data{"bucket": bucketName})
+		return Quarantine{}, mapBucketError(err)
+	}
+	logger.Info("quarantined", lager.Data{"bucket": bucketName, "until": quarantine.Until})
+
+	return quarantine, nil
+}
+
+// LiftQuarantine removes a binding's deny statement from the instance's
+// bucket policy. Deactivated access keys stay inactive; rotate the binding's
+// key to give the app a working one. Lifting is written to the audit trail.
+func (b *S3Broker) LiftQuarantine(ctx context.Context, instanceID, bindingID string, servicePlan ServicePlan) (err error) {
+	var bucketName string
+	defer func() {
+		b.AuditAction(ctx, AuditEvent{
+			Event: Event{Action: "lift-quarantine", InstanceID: instanceID, BindingID: bindingID, PlanID: servicePlan.ID, Bucket: bucketName},
+		}, err)
+	}()
+
+	b = b.forInstance(ctx, instanceID, servicePlan)
+	bucketName, err = b.instanceBucketName(ctx, instanceID, servicePlan)
+	if err != nil {
+		if errors.Is(err, awss3.ErrBucketNotFound) {
+			return nil
+		}
+		return mapBucketError(err)
+	}
+	return mapBucketError(b.bucket.RemovePolicyStatements(ctx, bucketName, []string{quarantineSid(bindingID)}))
+}
+
+// ServeQuarantine handles POST and DELETE on
+// /instances/{instance_id}/bindings/{binding_id}/quarantine, which quarantine
+// a binding and lift its quarantine. The plan_id query parameter is needed
+// for instances of existing bucket plans, and POST takes an optional
+// duration. It must be wrapped with the broker's basic auth.
+func (b *S3Broker) ServeQuarantine(w http.ResponseWriter, r *http.Request) {
+	instanceID := r.PathValue("instance_id")
+	bindingID := r.PathValue("binding_id")
+
+	var servicePlan ServicePlan
+	if planID := r.URL.Query().Get("plan_id"); planID != "" {
+		var ok bool
+		if servicePlan, ok = b.catalog.FindServicePlan(planID); !ok {
+			http.Error(w, fmt.Sprintf("%s: unknown plan_id %q", ErrInvalidQuarantine, planID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := b.LiftQuarantine(withAdminUser(r), instanceID, bindingID, servicePlan); err != nil {
+			b.logger.Error("lift-quarantine", err, lager.Data{instanceIDLogKey: instanceID, bindingIDLogKey: bindingID})
+			http.Error(w, "could not lift quarantine", http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	duration := defaultQuarantineDuration
+	if value := r.URL.Query().Get("duration"); value != "" {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil || duration <= 0 || duration > maxQuarantineDuration {
+			http.Error(w, fmt.Sprintf("%s: duration must be positive and at most %s", ErrInvalidQuarantine, maxQuarantineDuration), http.StatusBadRequest)
+			return
+		}
+	}
+
+	quarantine, err := b.QuarantineBinding(withAdminUser(r), instanceID, bindingID, servicePlan, duration)
+	switch {
+	case errors.Is(err, ErrBindingNotQuarantinable):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		b.logger.Error("quarantine-binding", err, lager.Data{instanceIDLogKey: instanceID, bindingIDLogKey: bindingID})
+		http.Error(w, "could not quarantine binding", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quarantine)
+}
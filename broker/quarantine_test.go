@@ -0,0 +1,128 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func withBrokerNames(b *S3Broker) {
+	b.bucketPrefix = "cg"
+	b.awsPartition = "aws"
+}
+
+func TestQuarantineBinding(t *testing.T) {
+	testCases := map[string]struct {
+		user              *mockUser
+		expectErr         error
+		expectDeactivated []string
+	}{
+		"binding without user": {
+			user:      &mockUser{listAccessKeysErr: awserr.New(iam.ErrCodeNoSuchEntityException, "not found", nil)},
+			expectErr: ErrBindingNotQuarantinable,
+		},
+		"deactivates active keys": {
+			user: &mockUser{
+				accessKeys:         map[string][]string{"-binding1": {"-binding1-0", "-binding1-1"}},
+				inactiveAccessKeys: []string{"-binding1-0"},
+			},
+			expectDeactivated: []string{"-binding1-1"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := &mockBucket{}
+			b := newTestBroker(withUser(tc.user), withBucket(bucket), withBrokerNames)
+
+			quarantine, err := b.QuarantineBinding(context.Background(), "instance1", "binding1", ServicePlan{}, time.Hour)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !slices.Equal(quarantine.DeactivatedAccessKeyIDs, tc.expectDeactivated) {
+				t.Errorf("expected deactivated keys %v, got %v", tc.expectDeactivated, quarantine.DeactivatedAccessKeyIDs)
+			}
+			if quarantine.Bucket != "cg-instance1" || quarantine.Until.Before(time.Now()) {
+				t.Errorf("unexpected quarantine %+v", quarantine)
+			}
+
+			statement, ok := bucket.policyStatements["cg-instance1"]["Quarantinebinding1"]
+			if !ok {
+				t.Fatalf("expected a deny statement, got %v", bucket.policyStatements)
+			}
+			if statement.Effect != "Deny" || statement.Principal["AWS"] != "arn:aws:iam::000000000000:user/-binding1" {
+				t.Errorf("unexpected statement %+v", statement)
+			}
+			if !slices.Equal(statement.Resource, []string{"arn:aws:s3:::cg-instance1", "arn:aws:s3:::cg-instance1/*"}) {
+				t.Errorf("unexpected resources %v", statement.Resource)
+			}
+			if until := statement.Condition["DateLessThan"]["aws:CurrentTime"]; until != quarantine.Until.Format(time.RFC3339) {
+				t.Errorf("expected the statement to expire at %s, got %s", quarantine.Until, until)
+			}
+
+			if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{PlanID: "planid1"}, false); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(bucket.policyStatements["cg-instance1"]) != 0 {
+				t.Errorf("expected unbind to remove the deny statement, got %v", bucket.policyStatements)
+			}
+		})
+	}
+}
+
+func TestServeQuarantine(t *testing.T) {
+	user := &mockUser{accessKeys: map[string][]string{"-binding1": {"-binding1-0"}}}
+	bucket := &mockBucket{}
+	b := newTestBroker(withUser(user), withBucket(bucket), withBrokerNames)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /instances/{instance_id}/bindings/{binding_id}/quarantine", b.ServeQuarantine)
+	mux.HandleFunc("DELETE /instances/{instance_id}/bindings/{binding_id}/quarantine", b.ServeQuarantine)
+
+	serve := func(method, query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, "/instances/instance1/bindings/binding1/quarantine"+query, nil))
+		return rec
+	}
+
+	if rec := serve(http.MethodPost, "?duration=1y"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid duration, got %d", rec.Code)
+	}
+
+	rec := serve(http.MethodPost, "?duration=2h")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var quarantine Quarantine
+	if err := json.NewDecoder(rec.Body).Decode(&quarantine); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(quarantine.DeactivatedAccessKeyIDs, []string{"-binding1-0"}) || quarantine.Until.Before(time.Now().Add(time.Hour)) {
+		t.Errorf("unexpected quarantine %+v", quarantine)
+	}
+
+	if rec := serve(http.MethodDelete, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(bucket.policyStatements["cg-instance1"]) != 0 {
+		t.Errorf("expected the deny statement to be removed, got %v", bucket.policyStatements)
+	}
+	if !slices.Equal(user.inactiveAccessKeys, []string{"-binding1-0"}) {
+		t.Errorf("expected the access key to stay inactive, got %v", user.inactiveAccessKeys)
+	}
+}
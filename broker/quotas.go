@@ -0,0 +1,96 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// QuotasConfig limits how many instances the broker provisions, to stay
+// within the account's bucket limit. Zero means no limit. Plans can also
+// set their own max_instances.
+type QuotasConfig struct {
+	// MaxInstances limits the instances of all of the catalog's services.
+	MaxInstances int `yaml:"max_instances"`
+	// MaxInstancesPerOrg limits the instances in each organization.
+	MaxInstancesPerOrg int `yaml:"max_instances_per_org"`
+}
+
+func (c QuotasConfig) Validate() error {
+	if c.MaxInstances < 0 || c.MaxInstancesPerOrg < 0 {
+		return fmt.Errorf("quotas must not be negative")
+	}
+	return nil
+}
+
+// quotaExceeded is returned when a provision would exceed a quota.
+func quotaExceeded(format string, a ...any) error {
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf(format+" Contact your Cloud Foundry operator for details.", a...),
+		http.StatusBadRequest,
+		"instance-quota-exceeded",
+	)
+}
+
+// checkQuotas returns an error if another instance of servicePlan in the
+// organization would exceed the broker's, the organization's or the plan's
+// quota. Instances are counted by their buckets' tags.
+func (b *S3Broker) checkQuotas(ctx context.Context, serviceID string, servicePlan ServicePlan, organizationGUID string) error {
+	quotas := b.currentQuotas()
+	if quotas.MaxInstances > 0 || quotas.MaxInstancesPerOrg > 0 {
+		instances := b.instanceBucketTagFilter()
+
+		if quotas.MaxInstances > 0 {
+			count, err := b.bucket.CountBuckets(ctx, instances)
+			if err != nil {
+				return mapBucketError(err)
+			}
+			b.recordQuotaUtilization(count, quotas.MaxInstances, map[string]string{"Quota": "broker"})
+			if count >= quotas.MaxInstances {
+				return quotaExceeded("The broker's quota of %d instances has been reached.", quotas.MaxInstances)
+			}
+		}
+
+		if quotas.MaxInstancesPerOrg > 0 && organizationGUID != "" {
+			instances[brokertags.OrganizationGUIDTagKey] = []string{organizationGUID}
+			count, err := b.bucket.CountBuckets(ctx, instances)
+			if err != nil {
+				return mapBucketError(err)
+			}
+			b.recordQuotaUtilization(count, quotas.MaxInstancesPerOrg, map[string]string{"Quota": "organization"})
+			if count >= quotas.MaxInstancesPerOrg {
+				return quotaExceeded("The organization's quota of %d instances has been reached.", quotas.MaxInstancesPerOrg)
+			}
+		}
+	}
+
+	return b.checkPlanQuota(ctx, serviceID, servicePlan)
+}
+
+// checkPlanQuota returns an error if another instance of servicePlan would
+// exceed its max_instances.
+func (b *S3Broker) checkPlanQuota(ctx context.Context, serviceID string, servicePlan ServicePlan) error {
+	if servicePlan.MaxInstances == 0 {
+		return nil
+	}
+	service, ok := b.catalog.FindService(serviceID)
+	if !ok {
+		return fmt.Errorf("Service '%s' not found", serviceID)
+	}
+	count, err := b.bucket.CountBuckets(ctx, map[string][]string{
+		brokertags.ServiceInstanceGUIDTagKey: nil,
+		brokertags.ServiceNameTagKey:         {service.Name},
+		brokertags.ServicePlanName:           {servicePlan.Name},
+	})
+	if err != nil {
+		return mapBucketError(err)
+	}
+	b.recordQuotaUtilization(count, servicePlan.MaxInstances, map[string]string{"Quota": "plan", "Plan": servicePlan.Name})
+	if count >= servicePlan.MaxInstances {
+		return quotaExceeded("The quota of %d instances of plan %q has been reached.", servicePlan.MaxInstances, servicePlan.Name)
+	}
+	return nil
+}
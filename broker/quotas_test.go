@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+func TestProvisionQuotas(t *testing.T) {
+	testCases := map[string]struct {
+		quotas       QuotasConfig
+		maxInstances int
+		total        int
+		inOrg        int
+		ofPlan       int
+		expectErr    string
+	}{
+		"no quotas": {
+			total: 1000,
+		},
+		"within quotas": {
+			quotas:       QuotasConfig{MaxInstances: 100, MaxInstancesPerOrg: 10},
+			maxInstances: 5,
+			total:        99,
+			inOrg:        9,
+			ofPlan:       4,
+		},
+		"broker quota reached": {
+			quotas:    QuotasConfig{MaxInstances: 100},
+			total:     100,
+			expectErr: "The broker's quota of 100 instances has been reached.",
+		},
+		"organization quota reached": {
+			quotas:    QuotasConfig{MaxInstances: 100, MaxInstancesPerOrg: 10},
+			total:     50,
+			inOrg:     10,
+			expectErr: "The organization's quota of 10 instances has been reached.",
+		},
+		"plan quota reached": {
+			maxInstances: 5,
+			ofPlan:       5,
+			expectErr:    `The quota of 5 instances of plan "basic" has been reached.`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger: lager.NewLogger("broker-unit-test-quotas"),
+				bucket: &mockBucket{countBuckets: func(tags map[string][]string) int {
+					switch {
+					case tags[brokertags.ServicePlanName] != nil:
+						return tc.ofPlan
+					case tags[brokertags.OrganizationGUIDTagKey] != nil:
+						return tc.inOrg
+					}
+					return tc.total
+				}},
+				catalog: &mockCatalog{serviceName: "s3", plans: map[string]ServicePlan{
+					"plan1": {ID: "plan1", Name: "basic", MaxInstances: tc.maxInstances, S3Properties: S3Properties{IamPolicy: "{}"}},
+				}},
+				tagManager: &mockTagGenerator{},
+				quotas:     newQuotas(tc.quotas),
+			}
+			_, err := b.Provision(context.Background(), "instance1", domain.ProvisionDetails{
+				ServiceID:        "service1",
+				PlanID:           "plan1",
+				OrganizationGUID: "org1",
+			}, false)
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			failure, ok := err.(*apiresponses.FailureResponse)
+			if !ok {
+				t.Fatalf("expected a failure response, got %v", err)
+			}
+			if failure.LoggerAction() != "instance-quota-exceeded" {
+				t.Errorf("expected instance-quota-exceeded, got %s", failure.LoggerAction())
+			}
+			if want := tc.expectErr + " Contact your Cloud Foundry operator for details."; err.Error() != want {
+				t.Errorf("expected error %q, got %q", want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+// Kinds of Discrepancy.
+const (
+	// DiscrepancyBucketMissing is an instance whose bucket no longer exists.
+	DiscrepancyBucketMissing = "bucket-missing"
+	// DiscrepancyBucketTags is an instance whose bucket lacks broker tags
+	// or has different values for them.
+	DiscrepancyBucketTags = "bucket-tags"
+	// DiscrepancyBucketDrift is an instance whose bucket differs from its
+	// CloudFormation stack.
+	DiscrepancyBucketDrift = "bucket-drift"
+	// DiscrepancyBucketUntracked is a bucket tagged as an instance's that
+	// the state store does not have.
+	DiscrepancyBucketUntracked = "bucket-untracked"
+	// DiscrepancyUserMissing is a binding whose IAM user no longer exists.
+	DiscrepancyUserMissing = "user-missing"
+	// DiscrepancyUserUntracked is an IAM user named like a binding's that
+	// the state store does not have.
+	DiscrepancyUserUntracked = "user-untracked"
+)
+
+var ErrReconcileRequiresState = errors.New("reconciliation requires a state store")
+
+// driftDetector is a Bucket that can tell whether buckets differ from how
+// the broker configured them, such as one that provisions through
+// CloudFormation.
+type driftDetector interface {
+	Drift(ctx context.Context, bucketName string) ([]string, error)
+}
+
+// Discrepancy is a difference between the state store and what exists in
+// AWS.
+type Discrepancy struct {
+	// Account is the account of the resource, if it is not the broker's
+	// own.
+	Account    string `json:"account,omitempty"`
+	Kind       string `json:"kind"`
+	InstanceID string `json:"instance_id,omitempty"`
+	BindingID  string `json:"binding_id,omitempty"`
+	// Resource is the name of the bucket or IAM user.
+	Resource string `json:"resource"`
+	Detail   string `json:"detail,omitempty"`
+	// Repaired is true if the broker corrected the discrepancy.
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+// Reconcile compares the instances and bindings in the state store with the
+// buckets tagged as instances' and the IAM users under the broker's path,
+// logs each discrepancy and returns them. With repairTags, broker tags
+// missing from instances' buckets are put back. Buckets provisioned through
+// CloudFormation are also checked for drift from their stacks. Resources
+// that cannot be checked are logged and skipped. Its AWS calls are low
+// priority.
+func (b *S3Broker) Reconcile(ctx context.Context, repairTags bool) ([]Discrepancy, error) {
+	ctx = awsretry.WithLowPriority(ctx)
+	if b.state == nil {
+		return nil, ErrReconcileRequiresState
+	}
+	logger := b.logger.Session("reconcile")
+
+	instances, err := b.state.ListInstances(ctx)
+	if err != nil {
+		logger.Error("list-instances", err)
+		return nil, err
+	}
+	bindings, err := b.state.ListBindings(ctx)
+	if err != nil {
+		logger.Error("list-bindings", err)
+		return nil, err
+	}
+
+	slices.SortFunc(instances, func(x, y state.Instance) int { return strings.Compare(x.InstanceID, y.InstanceID) })
+	slices.SortFunc(bindings, func(x, y state.Binding) int { return strings.Compare(x.BindingID, y.BindingID) })
+
+	var discrepancies []Discrepancy
+	for _, accountBroker := range b.accountBrokers() {
+		found, err := accountBroker.reconcileAccount(ctx, logger, repairTags, instances, bindings)
+		discrepancies = append(discrepancies, found...)
+		if err != nil {
+			return discrepancies, err
+		}
+	}
+	return discrepancies, nil
+}
+
+// reconcileAccount compares the instances and bindings of plans in the
+// broker's account with the account's buckets and IAM users.
+func (b *S3Broker) reconcileAccount(ctx context.Context, logger lager.Logger, repairTags bool, instances []state.Instance, bindings []state.Binding) ([]Discrepancy, error) {
+	var discrepancies []Discrepancy
+	report := func(discrepancy Discrepancy) {
+		discrepancy.Account = b.account
+		logger.Info("discrepancy", lager.Data{
+			"account":        discrepancy.Account,
+			"kind":           discrepancy.Kind,
+			instanceIDLogKey: discrepancy.InstanceID,
+			bindingIDLogKey:  discrepancy.BindingID,
+			"resource":       discrepancy.Resource,
+			"detail":         discrepancy.Detail,
+			"repaired":       discrepancy.Repaired,
+		})
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	trackedBuckets := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		if err := ctx.Err(); err != nil {
+			return discrepancies, err
+		}
+		if b.planAccount(instance.PlanID) != b.account || b.planObjectStore(instance.PlanID) != "" {
+			continue
+		}
+		bucketName := instance.BucketName
+		if bucketName == "" {
+			bucketName = b.bucketName(instance.InstanceID)
+		}
+		trackedBuckets[bucketName] = true
+
+		tags, err := b.bucket.Tags(ctx, bucketName)
+		if errors.Is(err, awss3.ErrBucketNotFound) {
+			report(Discrepancy{Kind: DiscrepancyBucketMissing, InstanceID: instance.InstanceID, Resource: bucketName})
+			continue
+		}
+		if err != nil {
+			logger.Error("bucket-tags", err, lager.Data{instanceIDLogKey: instance.InstanceID, "bucket": bucketName})
+			continue
+		}
+
+		if detector, ok := b.bucket.(driftDetector); ok {
+			differences, err := detector.Drift(ctx, bucketName)
+			if err != nil {
+				logger.Error("bucket-drift", err, lager.Data{instanceIDLogKey: instance.InstanceID, "bucket": bucketName})
+			} else if len(differences) > 0 {
+				report(Discrepancy{
+					Kind:       DiscrepancyBucketDrift,
+					InstanceID: instance.InstanceID,
+					Resource:   bucketName,
+					Detail:     strings.Join(differences, ", "),
+				})
+			}
+		}
+
+		missing := make(map[string]string)
+		for key, value := range b.expectedBucketTags(instance.InstanceID, instance.ServiceID, instance.PlanID, instance.OrganizationGUID, instance.SpaceGUID) {
+			if tags[key] != value {
+				missing[key] = value
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		discrepancy := Discrepancy{
+			Kind:       DiscrepancyBucketTags,
+			InstanceID: instance.InstanceID,
+			Resource:   bucketName,
+			Detail:     "missing or changed tags: " + strings.Join(slices.Sorted(maps.Keys(missing)), ", "),
+		}
+		if repairTags {
+			if err := b.bucket.AddTags(ctx, bucketName, missing); err != nil {
+				logger.Error("repair-bucket-tags", err, lager.Data{instanceIDLogKey: instance.InstanceID, "bucket": bucketName})
+			} else {
+				discrepancy.Repaired = true
+			}
+		}
+		report(discrepancy)
+	}
+
+	buckets, err := b.bucket.FindBuckets(ctx, b.instanceBucketTagFilter())
+	switch {
+	case errors.Is(err, awss3.ErrCountingDisabled):
+		logger.Debug("untracked-buckets-skipped")
+	case err != nil:
+		logger.Error("find-buckets", err)
+	default:
+		for _, bucketName := range slices.Sorted(maps.Keys(buckets)) {
+			if !trackedBuckets[bucketName] {
+				report(Discrepancy{
+					Kind:       DiscrepancyBucketUntracked,
+					InstanceID: buckets[bucketName][brokertags.ServiceInstanceGUIDTagKey],
+					Resource:   bucketName,
+				})
+			}
+		}
+	}
+
+	userNames, err := b.user.ListUsers(pathPrefix(b.iamPath))
+	if err != nil {
+		logger.Error("list-users", err)
+		return discrepancies, nil
+	}
+	existingUsers := make(map[string]bool, len(userNames))
+	for _, userName := range userNames {
+		existingUsers[userName] = true
+	}
+
+	trackedUsers := make(map[string]bool, len(bindings))
+	for _, binding := range bindings {
+		var stored StoredBinding
+		if err := json.Unmarshal(binding.Data, &stored); err != nil {
+			logger.Error("decode-binding", err, lager.Data{bindingIDLogKey: binding.BindingID})
+			continue
+		}
+		if stored.Account != b.account || stored.ObjectStore != "" || !isUserBinding(stored.Credentials) {
+			continue
+		}
+		userName := b.userName(binding.BindingID)
+		trackedUsers[userName] = true
+		if !existingUsers[userName] {
+			report(Discrepancy{Kind: DiscrepancyUserMissing, InstanceID: binding.InstanceID, BindingID: binding.BindingID, Resource: userName})
+		}
+	}
+
+	isBindingUser := nameMatcher(b.userNameTemplate, b.userPrefix)
+	for _, userName := range userNames {
+		if isBindingUser(userName) && !trackedUsers[userName] {
+			report(Discrepancy{Kind: DiscrepancyUserUntracked, Resource: userName})
+		}
+	}
+	return discrepancies, nil
+}
+
+// expectedBucketTags returns the broker tags that an instance's bucket should
+// have, leaving out those whose values are not known.
+func (b *S3Broker) expectedBucketTags(instanceID, serviceID, planID, organizationGUID, spaceGUID string) map[string]string {
+	tags := map[string]string{brokertags.ServiceInstanceGUIDTagKey: instanceID}
+	if service, ok := b.catalog.FindService(serviceID); ok {
+		tags[brokertags.ServiceNameTagKey] = service.Name
+	}
+	if plan, ok := b.catalog.FindServicePlan(planID); ok {
+		tags[brokertags.ServicePlanName] = plan.Name
+	}
+	if organizationGUID != "" {
+		tags[brokertags.OrganizationGUIDTagKey] = organizationGUID
+	}
+	if spaceGUID != "" {
+		tags[brokertags.SpaceGUIDTagKey] = spaceGUID
+	}
+	return tags
+}
+
+// instanceBucketTagFilter matches the buckets of instances of the catalog's
+// services.
+func (b *S3Broker) instanceBucketTagFilter() map[string][]string {
+	var serviceNames []string
+	for _, service := range b.catalog.ListServices() {
+		serviceNames = append(serviceNames, service.Name)
+	}
+	return map[string][]string{
+		brokertags.ServiceInstanceGUIDTagKey: nil,
+		brokertags.ServiceNameTagKey:         serviceNames,
+	}
+}
+
+// isUserBinding reports whether a binding's credentials belong to an IAM
+// user it created, rather than to a role, temporary credentials, a bucket
+// policy or presigned URLs.
+func isUserBinding(credentials Credentials) bool {
+	return credentials.RoleARN == "" && credentials.SessionToken == "" &&
+		(credentials.AccessKeyID != "" || credentials.SecretARN != "")
+}
+
+// ReconcileOnStartup runs Reconcile if the operator configured it to run on
+// startup and this broker is the leader.
+func (b *S3Broker) ReconcileOnStartup(ctx context.Context) {
+	if !b.reconcile.OnStartup || !b.isLeader() {
+		return
+	}
+	discrepancies, err := b.Reconcile(ctx, b.reconcile.RepairTags)
+	if err != nil {
+		b.logger.Error("reconcile-on-startup", err)
+		return
+	}
+	b.logger.Info("reconcile-on-startup", lager.Data{"discrepancies": len(discrepancies)})
+}
+
+// ServeReconcile runs Reconcile and responds with its discrepancies. Tags are
+// repaired if the repair query parameter is true.
+func (b *S3Broker) ServeReconcile(w http.ResponseWriter, r *http.Request) {
+	repair, _ := strconv.ParseBool(r.URL.Query().Get("repair"))
+	discrepancies, err := b.Reconcile(r.Context(), repair)
+	switch {
+	case errors.Is(err, ErrReconcileRequiresState):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		b.logger.Error("reconcile", err)
+		http.Error(w, "could not reconcile state", http.StatusBadGateway)
+		return
+	}
+	if discrepancies == nil {
+		discrepancies = []Discrepancy{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(discrepancies)
+}
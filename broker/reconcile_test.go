@@ -0,0 +1,127 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+func TestReconcile(t *testing.T) {
+	completeTags := func(instanceID string) map[string]string {
+		return map[string]string{
+			brokertags.ServiceInstanceGUIDTagKey: instanceID,
+			brokertags.ServiceNameTagKey:         "service1",
+			brokertags.ServicePlanName:           "plan1",
+			brokertags.OrganizationGUIDTagKey:    "org1",
+		}
+	}
+	newBroker := func(t *testing.T) (*S3Broker, *mockBucket) {
+		store := state.NewMemoryStore()
+		ctx := context.Background()
+		for _, instanceID := range []string{"instance1", "instance2", "instance3"} {
+			if err := store.SaveInstance(ctx, state.Instance{
+				InstanceID:       instanceID,
+				ServiceID:        "service1",
+				PlanID:           "plan1",
+				OrganizationGUID: "org1",
+				BucketName:       "prefix-" + instanceID,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		bindings := stateBindingStore{store: store}
+		for _, stored := range []StoredBinding{
+			{InstanceID: "instance1", BindingID: "binding1", Credentials: Credentials{AccessKeyID: "key1"}},
+			{InstanceID: "instance1", BindingID: "binding2", Credentials: Credentials{SecretARN: "arn:aws:secretsmanager:us-east-1:111111111111:secret:binding2"}},
+			{InstanceID: "instance1", BindingID: "binding3", Credentials: Credentials{AccessKeyID: "key3", RoleARN: "arn:aws:iam::111111111111:role/binding3"}},
+		} {
+			if err := bindings.SaveBinding(stored); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		instance2Tags := completeTags("instance2")
+		delete(instance2Tags, brokertags.ServicePlanName)
+		bucket := &mockBucket{buckets: map[string]map[string]string{
+			"prefix-instance1": completeTags("instance1"),
+			"prefix-instance2": instance2Tags,
+			"prefix-orphan":    completeTags("orphan"),
+			"unrelated":        {"team": "data"},
+		}}
+		return &S3Broker{
+			logger:     lager.NewLogger("broker-unit-test-reconcile"),
+			catalog:    &mockCatalog{planName: "plan1", serviceName: "service1"},
+			bucket:     bucket,
+			user:       &mockUser{accessKeys: map[string][]string{"prefix-binding1": {"key1"}, "prefix-binding9": {"key9"}, "other-user": {"key"}}},
+			userPrefix: "prefix",
+			state:      store,
+		}, bucket
+	}
+
+	expectDiscrepancies := func(repaired bool) []Discrepancy {
+		return []Discrepancy{
+			{Kind: DiscrepancyBucketTags, InstanceID: "instance2", Resource: "prefix-instance2", Detail: "missing or changed tags: " + brokertags.ServicePlanName, Repaired: repaired},
+			{Kind: DiscrepancyBucketMissing, InstanceID: "instance3", Resource: "prefix-instance3"},
+			{Kind: DiscrepancyBucketUntracked, InstanceID: "orphan", Resource: "prefix-orphan"},
+			{Kind: DiscrepancyUserMissing, InstanceID: "instance1", BindingID: "binding2", Resource: "prefix-binding2"},
+			{Kind: DiscrepancyUserUntracked, Resource: "prefix-binding9"},
+		}
+	}
+
+	for name, repair := range map[string]bool{"report only": false, "repair tags": true} {
+		t.Run(name, func(t *testing.T) {
+			b, bucket := newBroker(t)
+			discrepancies, err := b.Reconcile(context.Background(), repair)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(expectDiscrepancies(repair), discrepancies); diff != "" {
+				t.Errorf("unexpected discrepancies (-want +got):\n%s", diff)
+			}
+			_, tagged := bucket.buckets["prefix-instance2"][brokertags.ServicePlanName]
+			if tagged != repair {
+				t.Errorf("expected plan tag repaired to be %t, got %t", repair, tagged)
+			}
+		})
+	}
+
+	t.Run("stack drift", func(t *testing.T) {
+		b, bucket := newBroker(t)
+		b.bucket = &driftingBucket{mockBucket: bucket, drift: map[string][]string{
+			"prefix-instance1": {"Bucket/VersioningConfiguration/Status NOT_EQUAL", "BucketPolicy deleted"},
+		}}
+		discrepancies, err := b.Reconcile(context.Background(), false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expected := append([]Discrepancy{{
+			Kind:       DiscrepancyBucketDrift,
+			InstanceID: "instance1",
+			Resource:   "prefix-instance1",
+			Detail:     "Bucket/VersioningConfiguration/Status NOT_EQUAL, BucketPolicy deleted",
+		}}, expectDiscrepancies(false)...)
+		if diff := cmp.Diff(expected, discrepancies); diff != "" {
+			t.Errorf("unexpected discrepancies (-want +got):\n%s", diff)
+		}
+	})
+
+	b := &S3Broker{logger: lager.NewLogger("broker-unit-test-reconcile")}
+	if _, err := b.Reconcile(context.Background(), false); !errors.Is(err, ErrReconcileRequiresState) {
+		t.Errorf("expected ErrReconcileRequiresState, got %v", err)
+	}
+}
+
+type driftingBucket struct {
+	*mockBucket
+	drift map[string][]string
+}
+
+func (b *driftingBucket) Drift(_ context.Context, bucketName string) ([]string, error) {
+	return b.drift[bucketName], nil
+}
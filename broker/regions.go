@@ -0,0 +1,35 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// allowedRegions returns the regions that buckets may be in: the broker's
+// own region followed by the regions that users may choose at provision.
+func (b *S3Broker) allowedRegions() []string {
+	regions := []string{b.region}
+	for _, region := range b.regions {
+		if !slices.Contains(regions, region) {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// checkRegion returns an error if region is not one that buckets may be in.
+// An empty region is the broker's own.
+func (b *S3Broker) checkRegion(region string) error {
+	if region == "" || b.region == "" || slices.Contains(b.allowedRegions(), region) {
+		return nil
+	}
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("Region %s is not allowed. Choose one of %s.", region, strings.Join(b.allowedRegions(), ", ")),
+		http.StatusBadRequest,
+		"region-not-allowed",
+	)
+}
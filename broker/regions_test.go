@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+func TestCheckRegion(t *testing.T) {
+	testCases := map[string]struct {
+		regions   []string
+		region    string
+		expectErr bool
+	}{
+		"broker's region by default": {
+			region: "",
+		},
+		"broker's region": {
+			region: "us-gov-west-1",
+		},
+		"allowed region": {
+			regions: []string{"us-gov-east-1"},
+			region:  "us-gov-east-1",
+		},
+		"region that is not allowed": {
+			regions:   []string{"us-gov-east-1"},
+			region:    "us-east-1",
+			expectErr: true,
+		},
+		"no regions allowed": {
+			region:    "us-gov-east-1",
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{region: "us-gov-west-1", regions: tc.regions}
+			err := b.checkRegion(tc.region)
+			if tc.expectErr {
+				var failure *apiresponses.FailureResponse
+				if !errors.As(err, &failure) || failure.LoggerAction() != "region-not-allowed" {
+					t.Fatalf("expected region-not-allowed error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestProvisionSchemaRegion(t *testing.T) {
+	b := &S3Broker{
+		allowUserProvisionParameters: true,
+		region:                       "us-gov-west-1",
+		regions:                      []string{"us-gov-east-1", "us-gov-west-1"},
+	}
+
+	region, ok := b.provisionSchema(ServicePlan{}).Properties["region"]
+	if !ok {
+		t.Fatal("expected a region parameter")
+	}
+	if diff := cmp.Diff([]string{"us-gov-west-1", "us-gov-east-1"}, region.Enum); diff != "" {
+		t.Errorf("unexpected regions (-want +got):\n%s", diff)
+	}
+
+	b.regions = nil
+	if _, ok := b.provisionSchema(ServicePlan{}).Properties["region"]; ok {
+		t.Error("expected no region parameter without allowed regions")
+	}
+}
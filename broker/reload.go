@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// reloadableCatalog is the broker's Catalog, which Reload replaces while
+// requests are being served. Requests that already looked up a plan carry
+// on with it.
+type reloadableCatalog struct {
+	current atomic.Pointer[BrokerCatalog]
+}
+
+func newReloadableCatalog(catalog BrokerCatalog) *reloadableCatalog {
+	c := &reloadableCatalog{}
+	c.current.Store(&catalog)
+	return c
+}
+
+func (c *reloadableCatalog) Validate() error {
+	return c.current.Load().Validate()
+}
+
+func (c *reloadableCatalog) FindService(serviceID string) (Service, bool) {
+	return c.current.Load().FindService(serviceID)
+}
+
+func (c *reloadableCatalog) FindServicePlan(planID string) (ServicePlan, bool) {
+	return c.current.Load().FindServicePlan(planID)
+}
+
+func (c *reloadableCatalog) ListServices() []Service {
+	return c.current.Load().ListServices()
+}
+
+func (c *reloadableCatalog) ListServicePlans() []ServicePlan {
+	return c.current.Load().ListServicePlans()
+}
+
+func newQuotas(quotas QuotasConfig) *atomic.Pointer[QuotasConfig] {
+	p := new(atomic.Pointer[QuotasConfig])
+	p.Store(&quotas)
+	return p
+}
+
+// currentQuotas returns the broker's quotas, which Reload may replace.
+func (b *S3Broker) currentQuotas() QuotasConfig {
+	if b.quotas == nil {
+		return QuotasConfig{}
+	}
+	return *b.quotas.Load()
+}
+
+// Reload replaces the broker's catalog, including its plans and their
+// policies, and its quotas with those of config, without interrupting
+// requests in flight. The rest of config is only read on startup. Every
+// account's broker shares the catalog and quotas, so they are reloaded too.
+func (b *S3Broker) Reload(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	catalog, ok := b.catalog.(*reloadableCatalog)
+	if !ok || b.quotas == nil {
+		return errors.New("the broker's catalog cannot be reloaded")
+	}
+	catalog.current.Store(&config.Catalog)
+	b.quotas.Store(&config.Quotas)
+	b.logger.Info("reloaded", lager.Data{
+		"services": len(config.Catalog.ListServices()),
+		"plans":    len(config.Catalog.ListServicePlans()),
+	})
+	return nil
+}
@@ -0,0 +1,68 @@
+package broker
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+func reloadConfig(plans ...ServicePlan) Config {
+	return Config{
+		Region:       "us-east-1",
+		UserPrefix:   "cf",
+		PolicyPrefix: "cf",
+		BucketPrefix: "cf",
+		AwsPartition: "aws",
+		Catalog: BrokerCatalog{Services: []Service{{
+			ID:          "service1",
+			Name:        "s3",
+			Description: "S3 buckets",
+			Plans:       plans,
+		}}},
+	}
+}
+
+func TestReload(t *testing.T) {
+	basic := ServicePlan{ID: "plan1", Name: "basic", Description: "Basic", S3Properties: S3Properties{IamPolicy: "{}"}}
+	config := reloadConfig(basic)
+	b := &S3Broker{
+		catalog: newReloadableCatalog(config.Catalog),
+		quotas:  newQuotas(config.Quotas),
+		logger:  lager.NewLogger("broker-unit-test-reload"),
+	}
+	b.SetAccounts(map[string]Account{"production": {}})
+
+	premium := ServicePlan{ID: "plan2", Name: "premium", Description: "Premium", S3Properties: S3Properties{IamPolicy: "{}"}}
+	reloaded := reloadConfig(basic, premium)
+	reloaded.Quotas = QuotasConfig{MaxInstances: 10}
+	if err := b.Reload(reloaded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for name, accountBroker := range b.accounts {
+		if _, ok := accountBroker.catalog.FindServicePlan("plan2"); !ok {
+			t.Errorf("expected the %q account's broker to find the new plan", name)
+		}
+		if quotas := accountBroker.currentQuotas(); quotas.MaxInstances != 10 {
+			t.Errorf("expected the %q account's broker to have the new quotas, got %+v", name, quotas)
+		}
+	}
+
+	invalid := reloadConfig(basic)
+	invalid.Quotas = QuotasConfig{MaxInstances: -1}
+	if err := b.Reload(invalid); err == nil {
+		t.Fatal("expected invalid configuration to be rejected")
+	}
+	if quotas := b.currentQuotas(); quotas.MaxInstances != 10 {
+		t.Errorf("expected the previous quotas to be kept, got %+v", quotas)
+	}
+	if _, ok := b.catalog.FindServicePlan("plan2"); !ok {
+		t.Error("expected the previous catalog to be kept")
+	}
+}
+
+func TestReloadFixedCatalog(t *testing.T) {
+	b := &S3Broker{catalog: &mockCatalog{}, logger: lager.NewLogger("broker-unit-test-reload")}
+	if err := b.Reload(reloadConfig()); err == nil {
+		t.Fatal("expected a broker without a reloadable catalog to refuse to reload")
+	}
+}
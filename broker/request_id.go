@@ -0,0 +1,93 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// RequestIDHeader is the header in which the broker returns the ID of every
+// request, so that a failing request can be traced through the broker's logs,
+// events and audit log and AWS's CloudTrail.
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDLogKey = "request-id"
+
+// requestIDHeaders are the headers that a request's ID is taken from, in
+// order: the OSB API's request identity, then the usual correlation headers,
+// the last of which Cloud Foundry's router sets on every request.
+var requestIDHeaders = []string{
+	"X-Broker-API-Request-Identity",
+	RequestIDHeader,
+	"X-Correlation-ID",
+	"X-Vcap-Request-Id",
+}
+
+// validRequestID matches the IDs that are taken from requests. They end up
+// in log lines and user agents, so others are replaced.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type requestIDContextKey struct{}
+
+// WithRequestID returns a copy of ctx that carries a request's ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestID returns the ID of the request that ctx belongs to, or "" for
+// work that no request started.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// AssignRequestID wraps the broker's handlers. It takes each request's ID
+// from its headers, or generates one, adds it to the request's context and
+// returns it in the response. It also passes it on as X-Correlation-ID,
+// which brokerapi logs as the correlation-id of its own log lines.
+func AssignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requestID string
+		for _, header := range requestIDHeaders {
+			if value := r.Header.Get(header); validRequestID.MatchString(value) {
+				requestID = value
+				break
+			}
+		}
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		r = r.WithContext(WithRequestID(r.Context(), requestID))
+		r.Header.Set("X-Correlation-ID", requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// forRequest returns the broker for the request that ctx belongs to, whose
+// log lines have the request's ID. Brokers of other accounts that it returns
+// keep the ID.
+func (b *S3Broker) forRequest(ctx context.Context) *S3Broker {
+	return b.withRequestID(RequestID(ctx))
+}
+
+func (b *S3Broker) withRequestID(requestID string) *S3Broker {
+	if requestID == "" || requestID == b.requestID {
+		return b
+	}
+	requestBroker := *b
+	requestBroker.requestID = requestID
+	requestBroker.logger = b.logger.WithData(lager.Data{requestIDLogKey: requestID})
+	return &requestBroker
+}
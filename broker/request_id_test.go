@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3/lagertest"
+)
+
+func TestAssignRequestID(t *testing.T) {
+	testCases := map[string]struct {
+		headers  map[string]string
+		expectID string
+	}{
+		"OSB request identity": {
+			headers: map[string]string{
+				"X-Broker-API-Request-Identity": "e26cea8c-2d8f-4fbb-9b7c-b9e4a1a2b7cd",
+				"X-Vcap-Request-Id":             "a7e0d3b2-3c0e-4c1e-8f0d-1f3c3c1a2b3c",
+			},
+			expectID: "e26cea8c-2d8f-4fbb-9b7c-b9e4a1a2b7cd",
+		},
+		"request ID": {
+			headers:  map[string]string{"X-Request-ID": "trace-1", "X-Vcap-Request-Id": "router-1"},
+			expectID: "trace-1",
+		},
+		"router request ID": {
+			headers:  map[string]string{"X-Vcap-Request-Id": "router-1"},
+			expectID: "router-1",
+		},
+		"invalid request ID": {
+			headers:  map[string]string{"X-Request-ID": "trace 1\nforged-line", "X-Vcap-Request-Id": "router-1"},
+			expectID: "router-1",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var contextID, correlationID string
+			handler := AssignRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contextID = RequestID(r.Context())
+				correlationID = r.Header.Get("X-Correlation-ID")
+			}))
+			req := httptest.NewRequest(http.MethodPut, "/v2/service_instances/instance1", nil)
+			for header, value := range tc.headers {
+				req.Header.Set(header, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if contextID != tc.expectID {
+				t.Errorf("expected request ID %q in the context, got %q", tc.expectID, contextID)
+			}
+			if correlationID != tc.expectID {
+				t.Errorf("expected correlation ID %q, got %q", tc.expectID, correlationID)
+			}
+			if header := rec.Header().Get(RequestIDHeader); header != tc.expectID {
+				t.Errorf("expected %s %q, got %q", RequestIDHeader, tc.expectID, header)
+			}
+		})
+	}
+}
+
+func TestAssignRequestIDGenerates(t *testing.T) {
+	handler := AssignRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ids := make(map[string]bool)
+	for range 2 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/catalog", nil))
+		id := rec.Header().Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			t.Errorf("expected a generated request ID, got %q", id)
+		}
+		ids[id] = true
+	}
+	if len(ids) != 2 {
+		t.Errorf("expected a new request ID for each request, got %v", ids)
+	}
+}
+
+func TestForRequestLogs(t *testing.T) {
+	logger := lagertest.NewTestLogger("broker-unit-test-request-id")
+	b := &S3Broker{logger: logger}
+	b.SetAccounts(map[string]Account{"other": {}})
+	ctx := WithRequestID(context.Background(), "trace-1")
+
+	// Brokers of other accounts keep the request's ID.
+	for _, requestBroker := range []*S3Broker{b.forRequest(ctx), b.forRequest(ctx).forAccount("other")} {
+		requestBroker.logger.Info("provision")
+	}
+	b.logger.Info("startup")
+
+	logs := logger.Logs()
+	for i, expectID := range []any{"trace-1", "trace-1", nil} {
+		if id := logs[i].Data[requestIDLogKey]; id != expectID {
+			t.Errorf("expected log line %d to have request ID %v, got %v", i, expectID, id)
+		}
+	}
+}
+
+func TestEventRequestID(t *testing.T) {
+	publisher := &mockEventPublisher{}
+	b := &S3Broker{
+		logger:  lagertest.NewTestLogger("broker-unit-test-request-id"),
+		catalog: &mockCatalog{},
+		events:  publisher,
+	}
+	b.recordOperation(WithRequestID(context.Background(), "trace-1"), "instance1", "", "plan1", "provision", auditDetails{}, nil)
+	if publisher.events[0].RequestID != "trace-1" {
+		t.Errorf("expected the event to have the request ID, got %q", publisher.events[0].RequestID)
+	}
+}
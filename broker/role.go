@@ -0,0 +1,212 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awsiam"
+)
+
+var (
+	ErrRoleBindingsDisabled = apiresponses.NewFailureResponse(
+		errors.New("This broker is not configured to create IAM role bindings. Contact your Cloud Foundry operator for details."),
+		http.StatusBadRequest,
+		"role-bindings-disabled",
+	)
+
+	accountIDPattern    = regexp.MustCompile(`^\d{12}$`)
+	principalARNPattern = regexp.MustCompile(`^arn:[\w-]+:(iam|sts)::\d{12}:[\w+=,.@/-]+$`)
+	externalIDPattern   = regexp.MustCompile(`^[\w+=,.@:/-]+$`)
+
+	oidcProviderARNPattern       = regexp.MustCompile(`^arn:[\w-]+:iam::\d{12}:oidc-provider/[\w.-]+(/[\w.-]+)*$`)
+	serviceAccountSubjectPattern = regexp.MustCompile(`^system:serviceaccount:[a-z0-9]([-a-z0-9]*[a-z0-9])?:[a-z0-9]([-.a-z0-9]*[a-z0-9])?$`)
+)
+
+// accountTrustPolicy validates the principal and external ID of a role
+// binding and returns the role's trust policy. A bare account ID trusts the
+// whole account.
+func (b *S3Broker) accountTrustPolicy(principal, externalID string) (string, error) {
+	if len(externalID) < 2 || len(externalID) > 1224 || !externalIDPattern.MatchString(externalID) {
+		return "", apiresponses.NewFailureResponse(
+			errors.New("external_id must be 2 to 1224 letters, digits or any of +=,.@:/-"),
+			http.StatusBadRequest,
+			"invalid-external-id",
+		)
+	}
+	principalARN, err := b.principalARN(principal)
+	if err != nil {
+		return "", err
+	}
+	return awsiam.AccountTrustPolicy(principalARN, externalID)
+}
+
+// principalARN validates the principal of a binding for another AWS account.
+// A bare account ID stands for the whole account.
+func (b *S3Broker) principalARN(principal string) (string, error) {
+	switch {
+	case accountIDPattern.MatchString(principal):
+		return fmt.Sprintf("arn:%s:iam::%s:root", b.awsPartition, principal), nil
+	case principalARNPattern.MatchString(principal):
+		return principal, nil
+	default:
+		return "", apiresponses.NewFailureResponse(
+			fmt.Errorf("principal must be an AWS account ID or IAM ARN, got %q", principal),
+			http.StatusBadRequest,
+			"invalid-principal",
+		)
+	}
+}
+
+// webIdentityTrustPolicy validates the OIDC provider and subject of a
+// web-identity binding and returns the role's trust policy.
+func (b *S3Broker) webIdentityTrustPolicy(providerARN, subject string) (string, error) {
+	if !oidcProviderARNPattern.MatchString(providerARN) {
+		return "", apiresponses.NewFailureResponse(
+			fmt.Errorf("oidc_provider_arn must be an IAM OIDC provider ARN, got %q", providerARN),
+			http.StatusBadRequest,
+			"invalid-oidc-provider-arn",
+		)
+	}
+	if !serviceAccountSubjectPattern.MatchString(subject) {
+		return "", apiresponses.NewFailureResponse(
+			fmt.Errorf("subject must be system:serviceaccount:<namespace>:<name>, got %q", subject),
+			http.StatusBadRequest,
+			"invalid-subject",
+		)
+	}
+	return awsiam.WebIdentityTrustPolicy(providerARN, subject)
+}
+
+// bindRole creates a role that the consumer's own AWS or OIDC identity
+// assumes, instead of handing out credentials.
+func (b *S3Broker) bindRole(
+	ctx context.Context,
+	servicePlan ServicePlan,
+	instanceID, bindingID, iamPath, trustPolicy, iamPolicy string,
+	bucketARNs []string,
+	pathPrefix string,
+	iamTags []*iam.Tag,
+	credentials Credentials,
+) (binding domain.Binding, err error) {
+	roleName := b.roleName(bindingID)
+
+	roleARN, err := b.role.Create(roleName, iamPath, trustPolicy, iamTags)
+	if err != nil {
+		b.logger.Error("bind: error creating role", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			"role":           roleName,
+		})
+		return binding, err
+	}
+	defer func() {
+		// If the function returns an error, Bind did not complete and the role must be cleaned up.
+		if err != nil {
+			if derr := b.role.Delete(roleName); derr != nil {
+				b.logger.Error("bind: defer: error deleting role", derr, lager.Data{
+					instanceIDLogKey: instanceID,
+					bindingIDLogKey:  bindingID,
+					"role":           roleName,
+				})
+				err = orphanMitigation(err)
+			}
+		}
+	}()
+
+	managedPolicyARNs := servicePlan.S3Properties.ManagedPolicyARNs
+	err = b.attachManagedPolicies(
+		b.logger.Session("bind", lager.Data{bindingIDLogKey: bindingID}),
+		managedPolicyARNs,
+		func(policyARN string) error { return b.role.AttachRolePolicy(roleName, policyARN) },
+		func(policyARN string) error { return b.role.DetachRolePolicy(roleName, policyARN) },
+	)
+	if err != nil {
+		return binding, err
+	}
+	defer func() {
+		if err != nil {
+			b.detachManagedPolicies(
+				b.logger.Session("bind", lager.Data{bindingIDLogKey: bindingID}),
+				managedPolicyARNs,
+				func(policyARN string) error { return b.role.DetachRolePolicy(roleName, policyARN) },
+			)
+		}
+	}()
+
+	if err = b.grantKeyAccess(ctx, servicePlan, bindingID, roleARN); err != nil {
+		return binding, err
+	}
+	defer func() {
+		if err != nil {
+			if derr := b.retireKeyAccess(ctx, servicePlan, bindingID); derr != nil {
+				b.logger.Error("bind: defer: error retiring kms grant", derr, lager.Data{
+					instanceIDLogKey: instanceID,
+					bindingIDLogKey:  bindingID,
+				})
+				err = orphanMitigation(err)
+			}
+		}
+	}()
+
+	err = b.role.PutRolePolicy(roleName, b.policyName(bindingID), iamPolicy, bucketARNs, pathPrefix)
+	if err != nil {
+		b.logger.Error("bind: error putting role policy", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			"role":           roleName,
+		})
+		return binding, err
+	}
+
+	credentials.RoleARN = roleARN
+	binding.Credentials = credentials
+	return binding, nil
+}
+
+// deleteRole removes a binding's role and its inline policies, if the binding
+// has one.
+func (b *S3Broker) deleteRole(bindingID string) error {
+	roleName := b.roleName(bindingID)
+
+	exists, err := b.role.Exists(roleName)
+	if err != nil || !exists {
+		return err
+	}
+
+	policyNames, err := b.role.ListRolePolicies(roleName)
+	if b.handleUnbindError(err) != nil {
+		return err
+	}
+	for _, policyName := range policyNames {
+		if err := b.role.DeleteRolePolicy(roleName, policyName); err != nil {
+			return err
+		}
+	}
+
+	policyARNs, err := b.role.ListAttachedRolePolicies(roleName)
+	if b.handleUnbindError(err) != nil {
+		return err
+	}
+	for _, policyARN := range policyARNs {
+		if err := b.role.DetachRolePolicy(roleName, policyARN); err != nil {
+			return err
+		}
+	}
+
+	if err := b.role.Delete(roleName); b.handleUnbindError(err) != nil {
+		return err
+	}
+	return nil
+}
+
+func (b *S3Broker) roleName(bindingID string) string {
+	return b.userName(bindingID)
+}
@@ -0,0 +1,222 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+type mockRole struct {
+	trustPolicies    map[string]string
+	inlinePolicies   map[string][]string
+	putRolePolicyErr error
+	deleted          []string
+	attachedPolicies map[string][]string
+	attachErr        error
+	tags             []*iam.Tag
+}
+
+func (r *mockRole) AttachRolePolicy(roleName, policyARN string) error {
+	if r.attachErr != nil {
+		return r.attachErr
+	}
+	if r.attachedPolicies == nil {
+		r.attachedPolicies = make(map[string][]string)
+	}
+	r.attachedPolicies[roleName] = append(r.attachedPolicies[roleName], policyARN)
+	return nil
+}
+
+func (r *mockRole) DetachRolePolicy(roleName, policyARN string) error {
+	r.attachedPolicies[roleName] = slices.DeleteFunc(r.attachedPolicies[roleName], func(p string) bool { return p == policyARN })
+	return nil
+}
+
+func (r *mockRole) ListAttachedRolePolicies(roleName string) ([]string, error) {
+	return slices.Clone(r.attachedPolicies[roleName]), nil
+}
+
+func (r *mockRole) Exists(roleName string) (bool, error) {
+	_, ok := r.trustPolicies[roleName]
+	return ok, nil
+}
+
+func (r *mockRole) Create(roleName, iamPath, trustPolicy string, iamTags []*iam.Tag) (string, error) {
+	if r.trustPolicies == nil {
+		r.trustPolicies = make(map[string]string)
+	}
+	r.trustPolicies[roleName] = trustPolicy
+	r.tags = iamTags
+	return "arn:aws:iam::000000000000:role/" + roleName, nil
+}
+
+func (r *mockRole) Delete(roleName string) error {
+	delete(r.trustPolicies, roleName)
+	r.deleted = append(r.deleted, roleName)
+	return nil
+}
+
+func (r *mockRole) PutRolePolicy(roleName, policyName, policyTemplate string, resources []string, pathPrefix string) error {
+	if r.putRolePolicyErr != nil {
+		return r.putRolePolicyErr
+	}
+	if r.inlinePolicies == nil {
+		r.inlinePolicies = make(map[string][]string)
+	}
+	r.inlinePolicies[roleName] = append(r.inlinePolicies[roleName], policyName)
+	return nil
+}
+
+func (r *mockRole) ListRolePolicies(roleName string) ([]string, error) {
+	return slices.Clone(r.inlinePolicies[roleName]), nil
+}
+
+func (r *mockRole) DeleteRolePolicy(roleName, policyName string) error {
+	idx := slices.Index(r.inlinePolicies[roleName], policyName)
+	if idx == -1 {
+		return errors.New("not found")
+	}
+	r.inlinePolicies[roleName] = slices.Delete(r.inlinePolicies[roleName], idx, idx+1)
+	return nil
+}
+
+func withRole(role *mockRole) func(*S3Broker) {
+	return func(b *S3Broker) {
+		b.awsPartition = "aws"
+		if role != nil {
+			b.role = role
+		}
+	}
+}
+
+func roleBindDetails(parameters string) domain.BindDetails {
+	return domain.BindDetails{
+		PlanID:        "planid1",
+		ServiceID:     "serviceid1",
+		RawParameters: json.RawMessage(parameters),
+	}
+}
+
+func TestBindRole(t *testing.T) {
+	testCases := map[string]struct {
+		role             *mockRole
+		parameters       string
+		expectErr        error
+		expectPrincipal  string
+		expectExternalID string
+	}{
+		"disabled": {
+			parameters: `{"credential_type": "role", "principal": "123456789012", "external_id": "ext-id"}`,
+			expectErr:  ErrRoleBindingsDisabled,
+		},
+		"missing external id": {
+			role:       &mockRole{},
+			parameters: `{"credential_type": "role", "principal": "123456789012"}`,
+			expectErr:  NewTestErr("external_id must be 2 to 1224 letters, digits or any of +=,.@:/-"),
+		},
+		"invalid principal": {
+			role:       &mockRole{},
+			parameters: `{"credential_type": "role", "principal": "*", "external_id": "ext-id"}`,
+			expectErr:  NewTestErr(`principal must be an AWS account ID or IAM ARN, got "*"`),
+		},
+		"put role policy error": {
+			role:       &mockRole{putRolePolicyErr: NewTestErr("put role policy error")},
+			parameters: `{"credential_type": "role", "principal": "123456789012", "external_id": "ext-id"}`,
+			expectErr:  NewTestErr("put role policy error"),
+		},
+		"account principal": {
+			role:             &mockRole{},
+			parameters:       `{"credential_type": "role", "principal": "123456789012", "external_id": "ext-id"}`,
+			expectPrincipal:  "arn:aws:iam::123456789012:root",
+			expectExternalID: "ext-id",
+		},
+		"invalid oidc provider": {
+			role:       &mockRole{},
+			parameters: `{"credential_type": "web-identity", "oidc_provider_arn": "arn:aws:iam::123456789012:user/app", "subject": "system:serviceaccount:default:app"}`,
+			expectErr:  NewTestErr(`oidc_provider_arn must be an IAM OIDC provider ARN, got "arn:aws:iam::123456789012:user/app"`),
+		},
+		"invalid subject": {
+			role:       &mockRole{},
+			parameters: `{"credential_type": "web-identity", "oidc_provider_arn": "arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-east-1.amazonaws.com/id/ABC", "subject": "system:serviceaccount:*"}`,
+			expectErr:  NewTestErr(`subject must be system:serviceaccount:<namespace>:<name>, got "system:serviceaccount:*"`),
+		},
+		"web identity": {
+			role:            &mockRole{},
+			parameters:      `{"credential_type": "web-identity", "oidc_provider_arn": "arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-east-1.amazonaws.com/id/ABC", "subject": "system:serviceaccount:default:app"}`,
+			expectPrincipal: "arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-east-1.amazonaws.com/id/ABC",
+		},
+		"role principal": {
+			role:             &mockRole{},
+			parameters:       `{"credential_type": "role", "principal": "arn:aws:iam::123456789012:role/app", "external_id": "ext-id"}`,
+			expectPrincipal:  "arn:aws:iam::123456789012:role/app",
+			expectExternalID: "ext-id",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withRole(tc.role))
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", roleBindDetails(tc.parameters), false)
+			if tc.expectErr != nil {
+				if !errors.Is(tc.expectErr, err) && !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+				}
+				if tc.role != nil && len(tc.role.trustPolicies) != 0 {
+					t.Errorf("expected the role to be cleaned up, got %v", tc.role.trustPolicies)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			credentials := binding.Credentials.(Credentials)
+			if credentials.RoleARN != "arn:aws:iam::000000000000:role/-binding1" || credentials.ExternalID != tc.expectExternalID {
+				t.Errorf("expected role arn and external id %q, got %+v", tc.expectExternalID, credentials)
+			}
+			if credentials.AccessKeyID != "" || credentials.SecretAccessKey != "" {
+				t.Errorf("expected no access key, got %+v", credentials)
+			}
+			if user := b.user.(*mockUser); user.exists {
+				t.Errorf("expected no IAM user")
+			}
+
+			var trustPolicy struct {
+				Statement []struct {
+					Principal struct{ AWS, Federated string }
+				}
+			}
+			if err := json.Unmarshal([]byte(tc.role.trustPolicies["-binding1"]), &trustPolicy); err != nil {
+				t.Fatal(err)
+			}
+			principal := trustPolicy.Statement[0].Principal
+			if got := principal.AWS + principal.Federated; got != tc.expectPrincipal {
+				t.Errorf("expected trust policy principal %s, got %s", tc.expectPrincipal, got)
+			}
+			if !slices.Equal(tc.role.inlinePolicies["-binding1"], []string{"-binding1"}) {
+				t.Errorf("expected inline role policy, got %v", tc.role.inlinePolicies)
+			}
+		})
+	}
+}
+
+func TestUnbindRole(t *testing.T) {
+	role := &mockRole{
+		trustPolicies:  map[string]string{"-binding1": "{}"},
+		inlinePolicies: map[string][]string{"-binding1": {"-binding1"}},
+	}
+	b := newTestBroker(withRole(role))
+	if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(role.inlinePolicies["-binding1"]) != 0 {
+		t.Errorf("expected inline policies to be deleted, got %v", role.inlinePolicies)
+	}
+	if !slices.Equal(role.deleted, []string{"-binding1"}) {
+		t.Errorf("expected role to be deleted, got %v", role.deleted)
+	}
+}
@@ -0,0 +1,157 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+
+	"github.com/cloud-gov/s3-broker/awsiam"
+)
+
+const defaultKeyRotationGracePeriod = 24 * time.Hour
+
+var (
+	ErrBindingNotRotatable = errors.New("binding has no IAM user access key to rotate")
+	ErrRotationInProgress  = errors.New("the previous access key of this binding has not been revoked yet")
+	ErrBindingNotStored    = errors.New("binding is not in the binding store, so the credentials delivered to Secrets Manager or CredHub cannot be updated")
+)
+
+// RotatedAccessKey is the access key issued by RotateAccessKey. The keys it
+// replaces stay valid until PreviousKeysExpiration.
+type RotatedAccessKey struct {
+	AccessKeyID            string    `json:"access_key_id"`
+	SecretAccessKey        string    `json:"secret_access_key"`
+	PreviousKeysExpiration time.Time `json:"previous_keys_expiration"`
+}
+
+// RotateAccessKey issues a new access key for a binding's IAM user and
+// revokes the user's other keys once the grace period has passed, so apps can
+// switch keys without unbinding. The new key replaces the old one in the
+// binding store and in the binding's Secrets Manager secret or CredHub
+// credential; if it cannot, the new key is deleted again. A broker that
+// delivers credentials does not rotate bindings missing from its store, since
+// it cannot tell where their credentials went.
+//
+// Revocation is scheduled in memory. If the broker restarts before it runs,
+// the next rotation revokes the leftover keys instead. The rotation and each
+// revocation are written to the audit trail.
+func (b *S3Broker) RotateAccessKey(ctx context.Context, bindingID string) (rotated RotatedAccessKey, err error) {
+	defer func() {
+		b.AuditAction(ctx, AuditEvent{
+			Event:       Event{Action: "rotate-access-key", BindingID: bindingID},
+			AccessKeyID: rotated.AccessKeyID,
+		}, err)
+	}()
+
+	var stored *StoredBinding
+	if b.bindings != nil {
+		if binding, err := b.bindings.GetBinding(bindingID); err == nil {
+			stored = &binding
+			b = b.forAccount(binding.Account)
+		}
+	}
+	if stored == nil && (b.secrets != nil || b.credhub != nil) {
+		return RotatedAccessKey{}, ErrBindingNotStored
+	}
+	userName := b.userName(bindingID)
+	logger := b.logger.Session("rotate-access-key", lager.Data{bindingIDLogKey: bindingID})
+
+	accessKeys, err := b.user.DescribeAccessKeys(userName)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == iam.ErrCodeNoSuchEntityException {
+			return RotatedAccessKey{}, ErrBindingNotRotatable
+		}
+		return RotatedAccessKey{}, err
+	}
+	slices.SortFunc(accessKeys, func(a, b awsiam.AccessKeyDetails) int {
+		return a.CreateDate.Compare(b.CreateDate)
+	})
+
+	now := time.Now()
+	if len(accessKeys) > 1 {
+		newest := accessKeys[len(accessKeys)-1]
+		if now.Before(newest.CreateDate.Add(b.keyRotationGracePeriod)) {
+			return RotatedAccessKey{}, ErrRotationInProgress
+		}
+		if err := b.revokeAccessKeys(ctx, logger, bindingID, userName, accessKeys[:len(accessKeys)-1]); err != nil {
+			return RotatedAccessKey{}, err
+		}
+		accessKeys = accessKeys[len(accessKeys)-1:]
+	}
+
+	accessKeyID, secretAccessKey, err := b.user.CreateAccessKey(userName)
+	if err != nil {
+		return RotatedAccessKey{}, err
+	}
+	if stored != nil {
+		if err := b.updateStoredAccessKey(ctx, *stored, accessKeyID, secretAccessKey); err != nil {
+			logger.Error("update-stored-binding", err)
+			// The previous keys stay in use, so they must not be revoked
+			// for a key that no app has.
+			if err := b.user.DeleteAccessKey(userName, accessKeyID); err != nil {
+				logger.Error("delete-new-access-key", err, lager.Data{"access-key-id": accessKeyID})
+			}
+			return RotatedAccessKey{}, err
+		}
+	}
+	logger.Info("rotated", lager.Data{"access-key-id": accessKeyID})
+
+	previousKeys := accessKeys
+	revokeCtx := context.WithoutCancel(ctx)
+	time.AfterFunc(b.keyRotationGracePeriod, func() {
+		b.revokeAccessKeys(revokeCtx, logger, bindingID, userName, previousKeys)
+	})
+
+	return RotatedAccessKey{
+		AccessKeyID:            accessKeyID,
+		SecretAccessKey:        secretAccessKey,
+		PreviousKeysExpiration: now.Add(b.keyRotationGracePeriod).UTC(),
+	}, nil
+}
+
+func (b *S3Broker) revokeAccessKeys(ctx context.Context, logger lager.Logger, bindingID, userName string, accessKeys []awsiam.AccessKeyDetails) error {
+	for _, accessKey := range accessKeys {
+		err := b.user.DeleteAccessKey(userName, accessKey.AccessKeyID)
+		b.AuditAction(ctx, AuditEvent{
+			Event:       Event{Action: "revoke-access-key", BindingID: bindingID},
+			AccessKeyID: accessKey.AccessKeyID,
+		}, err)
+		if err != nil {
+			logger.Error("revoke-access-key", err, lager.Data{"access-key-id": accessKey.AccessKeyID})
+			return err
+		}
+		logger.Info("revoked-access-key", lager.Data{"access-key-id": accessKey.AccessKeyID})
+	}
+	return nil
+}
+
+// ServeRotate handles POST /bindings/{binding_id}/rotate. It must be wrapped
+// with the broker's basic auth.
+func (b *S3Broker) ServeRotate(w http.ResponseWriter, r *http.Request) {
+	bindingID := r.PathValue("binding_id")
+
+	accessKey, err := b.RotateAccessKey(withAdminUser(r), bindingID)
+	switch {
+	case errors.Is(err, ErrBindingNotRotatable):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrRotationInProgress), errors.Is(err, ErrBindingNotStored):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		b.logger.Error("rotate-access-key", err, lager.Data{bindingIDLogKey: bindingID})
+		http.Error(w, "could not rotate access key", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(accessKey)
+}
@@ -0,0 +1,235 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func withKeyRotation(b *S3Broker) {
+	b.keyRotationGracePeriod = time.Hour
+}
+
+func TestRotateAccessKey(t *testing.T) {
+	testCases := map[string]struct {
+		user             *mockUser
+		expectErr        error
+		expectAccessKeys []string
+	}{
+		"binding without user": {
+			user:      &mockUser{listAccessKeysErr: awserr.New(iam.ErrCodeNoSuchEntityException, "not found", nil)},
+			expectErr: ErrBindingNotRotatable,
+		},
+		"first rotation keeps the previous key": {
+			user: &mockUser{
+				accessKeys:           map[string][]string{"-binding1": {"-binding1-0"}},
+				accessKeyCreateDates: map[string]time.Time{"-binding1-0": time.Now().Add(-48 * time.Hour)},
+			},
+			expectAccessKeys: []string{"-binding1-0", "-binding1-1"},
+		},
+		"rotation in progress": {
+			user: &mockUser{
+				accessKeys: map[string][]string{"-binding1": {"-binding1-0", "-binding1-1"}},
+				accessKeyCreateDates: map[string]time.Time{
+					"-binding1-0": time.Now().Add(-48 * time.Hour),
+					"-binding1-1": time.Now().Add(-time.Minute),
+				},
+			},
+			expectErr: ErrRotationInProgress,
+		},
+		"leftover keys past the grace period are revoked": {
+			user: &mockUser{
+				accessKeys: map[string][]string{"-binding1": {"-binding1-1", "-binding1-0"}},
+				accessKeyCreateDates: map[string]time.Time{
+					"-binding1-0": time.Now().Add(-48 * time.Hour),
+					"-binding1-1": time.Now().Add(-2 * time.Hour),
+				},
+			},
+			expectAccessKeys: []string{"-binding1-1", "-binding1-2"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withUser(tc.user), withKeyRotation)
+			accessKey, err := b.RotateAccessKey(context.Background(), "binding1")
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if accessKey.AccessKeyID == "" || accessKey.PreviousKeysExpiration.Before(time.Now()) {
+				t.Errorf("unexpected access key %+v", accessKey)
+			}
+			if !slices.Equal(tc.user.accessKeys["-binding1"], tc.expectAccessKeys) {
+				t.Errorf("expected access keys %v, got %v", tc.expectAccessKeys, tc.user.accessKeys["-binding1"])
+			}
+		})
+	}
+}
+
+func TestRotateAccessKeyUpdatesStoredBinding(t *testing.T) {
+	user := &mockUser{
+		accessKeys:           map[string][]string{"-binding1": {"-binding1-0"}},
+		accessKeyCreateDates: map[string]time.Time{"-binding1-0": time.Now().Add(-48 * time.Hour)},
+	}
+	b := newTestBroker(withUser(user), withKeyRotation)
+	b.bindings = NewMemoryBindingStore()
+	b.bindings.SaveBinding(StoredBinding{
+		InstanceID:  "instance1",
+		BindingID:   "binding1",
+		Credentials: Credentials{AccessKeyID: "-binding1-0", SecretAccessKey: "old", Bucket: "bucket1"},
+	})
+
+	accessKey, err := b.RotateAccessKey(context.Background(), "binding1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stored, err := b.bindings.GetBinding("binding1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if stored.Credentials.AccessKeyID != accessKey.AccessKeyID || stored.Credentials.URI != b.GetBucketURI(stored.Credentials) {
+		t.Errorf("expected stored credentials with access key %s, got %+v", accessKey.AccessKeyID, stored.Credentials)
+	}
+}
+
+func TestRotateAccessKeyUpdatesDeliveredCredentials(t *testing.T) {
+	const secretARN = "arn:aws:secretsmanager:us-east-1:000000000000:secret:my-app/s3"
+	const credhubRef = "/c/broker/service1/binding1/credentials"
+	testCases := map[string]struct {
+		stored        *StoredBinding
+		secrets       *mockSecrets
+		credhub       *mockCredHub
+		expectErr     error
+		expectKeys    []string
+		expectUpdated func(t *testing.T, secrets *mockSecrets, credhub *mockCredHub, accessKeyID string)
+	}{
+		"secrets manager": {
+			stored: &StoredBinding{
+				InstanceID:  "instance1",
+				BindingID:   "binding1",
+				Credentials: Credentials{Bucket: "bucket1", SecretARN: secretARN, SecretName: "my-app/s3"},
+			},
+			secrets:    &mockSecrets{secrets: map[string]string{"my-app/s3": `{"access_key_id":"-binding1-0"}`}},
+			expectKeys: []string{"-binding1-0", "-binding1-1"},
+			expectUpdated: func(t *testing.T, secrets *mockSecrets, credhub *mockCredHub, accessKeyID string) {
+				var credentials Credentials
+				if err := json.Unmarshal([]byte(secrets.secrets["my-app/s3"]), &credentials); err != nil {
+					t.Fatal(err)
+				}
+				if credentials.AccessKeyID != accessKeyID || credentials.Bucket != "bucket1" || credentials.SecretARN != "" {
+					t.Errorf("expected the secret to hold access key %s, got %+v", accessKeyID, credentials)
+				}
+			},
+		},
+		"credhub": {
+			stored: &StoredBinding{
+				InstanceID:  "instance1",
+				BindingID:   "binding1",
+				Credentials: Credentials{AccessKeyID: "-binding1-0", SecretAccessKey: "old", Bucket: "bucket1"},
+				CredHubRef:  credhubRef,
+			},
+			credhub:    &mockCredHub{credentials: map[string]any{credhubRef: Credentials{AccessKeyID: "-binding1-0"}}},
+			expectKeys: []string{"-binding1-0", "-binding1-1"},
+			expectUpdated: func(t *testing.T, secrets *mockSecrets, credhub *mockCredHub, accessKeyID string) {
+				if credentials, ok := credhub.credentials[credhubRef].(Credentials); !ok || credentials.AccessKeyID != accessKeyID {
+					t.Errorf("expected CredHub to hold access key %s, got %+v", accessKeyID, credhub.credentials[credhubRef])
+				}
+			},
+		},
+		"secret update fails": {
+			stored: &StoredBinding{
+				InstanceID:  "instance1",
+				BindingID:   "binding1",
+				Credentials: Credentials{Bucket: "bucket1", SecretARN: secretARN, SecretName: "my-app/s3"},
+			},
+			secrets:    &mockSecrets{updateErr: errors.New("AccessDeniedException: denied")},
+			expectErr:  errors.New("AccessDeniedException: denied"),
+			expectKeys: []string{"-binding1-0"},
+		},
+		"binding not stored": {
+			secrets:    &mockSecrets{},
+			expectErr:  ErrBindingNotStored,
+			expectKeys: []string{"-binding1-0"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			user := &mockUser{
+				accessKeys:           map[string][]string{"-binding1": {"-binding1-0"}},
+				accessKeyCreateDates: map[string]time.Time{"-binding1-0": time.Now().Add(-48 * time.Hour)},
+			}
+			b := newTestBroker(withUser(user), withKeyRotation)
+			b.bindings = NewMemoryBindingStore()
+			if tc.stored != nil {
+				b.bindings.SaveBinding(*tc.stored)
+			}
+			if tc.secrets != nil {
+				b.secrets = tc.secrets
+			}
+			if tc.credhub != nil {
+				b.credhub = tc.credhub
+			}
+
+			accessKey, err := b.RotateAccessKey(context.Background(), "binding1")
+			if tc.expectErr != nil {
+				if err == nil || (!errors.Is(err, tc.expectErr) && err.Error() != tc.expectErr.Error()) {
+					t.Fatalf("expected err %s, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !slices.Equal(user.accessKeys["-binding1"], tc.expectKeys) {
+				t.Errorf("expected access keys %v, got %v", tc.expectKeys, user.accessKeys["-binding1"])
+			}
+			if tc.expectUpdated != nil {
+				tc.expectUpdated(t, tc.secrets, tc.credhub, accessKey.AccessKeyID)
+			}
+		})
+	}
+}
+
+func TestServeRotate(t *testing.T) {
+	user := &mockUser{
+		accessKeys:           map[string][]string{"-binding1": {"-binding1-0"}},
+		accessKeyCreateDates: map[string]time.Time{"-binding1-0": time.Now().Add(-48 * time.Hour)},
+	}
+	b := newTestBroker(withUser(user), withKeyRotation)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /bindings/{binding_id}/rotate", b.ServeRotate)
+
+	rotate := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/bindings/binding1/rotate", nil))
+		return rec
+	}
+
+	rec := rotate()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var accessKey RotatedAccessKey
+	if err := json.NewDecoder(rec.Body).Decode(&accessKey); err != nil {
+		t.Fatal(err)
+	}
+	if accessKey.AccessKeyID != "-binding1-1" {
+		t.Errorf("expected new access key, got %+v", accessKey)
+	}
+
+	if rec := rotate(); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 while the previous key is valid, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,319 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-04/schema#"
+
+// ParameterSchema is the subset of JSON Schema that describes the broker's
+// parameters. The catalog advertises it, and the broker validates parameters
+// against it before using them.
+type ParameterSchema struct {
+	Schema               string                      `json:"$schema,omitempty"`
+	Type                 string                      `json:"type,omitempty"`
+	Description          string                      `json:"description,omitempty"`
+	Properties           map[string]*ParameterSchema `json:"properties,omitempty"`
+	AdditionalProperties *bool                       `json:"additionalProperties,omitempty"`
+	Required             []string                    `json:"required,omitempty"`
+	Items                *ParameterSchema            `json:"items,omitempty"`
+	Enum                 []string                    `json:"enum,omitempty"`
+	MinLength            *int                        `json:"minLength,omitempty"`
+	MaxLength            *int                        `json:"maxLength,omitempty"`
+	Minimum              *float64                    `json:"minimum,omitempty"`
+	MaxItems             *int                        `json:"maxItems,omitempty"`
+}
+
+// Parameters returns the schema in the form of the OSB catalog.
+func (s *ParameterSchema) Parameters() map[string]interface{} {
+	schemaJSON, _ := json.Marshal(s)
+	var parameters map[string]interface{}
+	json.Unmarshal(schemaJSON, &parameters)
+	return parameters
+}
+
+// Validate returns one message for each part of value that the schema does
+// not allow, prefixed with its path such as cors_rules[0].allowed_methods.
+func (s *ParameterSchema) Validate(path string, value any) []string {
+	var problems []string
+	problem := func(format string, args ...any) {
+		message := fmt.Sprintf(format, args...)
+		if path != "" {
+			message = path + ": " + message
+		}
+		problems = append(problems, message)
+	}
+
+	switch s.Type {
+	case "object":
+		fields, ok := value.(map[string]any)
+		if !ok {
+			problem("must be an object")
+			return problems
+		}
+		for _, name := range s.Required {
+			if _, ok := fields[name]; !ok {
+				problem("%s is required", name)
+			}
+		}
+		for _, name := range sortedKeys(fields) {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					problems = append(problems, joinPath(path, name)+": is not a supported parameter")
+				}
+				continue
+			}
+			problems = append(problems, property.Validate(joinPath(path, name), fields[name])...)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			problem("must be an array")
+			return problems
+		}
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			problem("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				problems = append(problems, s.Items.Validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			problem("must be a string")
+			return problems
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			problem("must be one of %s", strings.Join(s.Enum, ", "))
+		}
+		if s.MinLength != nil && len(str) < *s.MinLength {
+			problem("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && len(str) > *s.MaxLength {
+			problem("must be at most %d characters", *s.MaxLength)
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			problem("must be an integer")
+			return problems
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			problem("must be at least %v", *s.Minimum)
+		}
+	case "number":
+		number, ok := value.(float64)
+		if !ok {
+			problem("must be a number")
+			return problems
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			problem("must be at least %v", *s.Minimum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problem("must be a boolean")
+		}
+	}
+	return problems
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func sortedKeys(fields map[string]any) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateParameters checks raw parameters against schema, and returns a 400
+// failure that lists every problem.
+func validateParameters(schema *ParameterSchema, rawParameters json.RawMessage) error {
+	if len(bytes.TrimSpace(rawParameters)) == 0 {
+		return nil
+	}
+
+	var parameters any
+	if err := json.Unmarshal(rawParameters, &parameters); err != nil {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("Parameters are not valid JSON: %s", err),
+			http.StatusBadRequest,
+			"invalid-parameters",
+		)
+	}
+	if problems := schema.Validate("", parameters); len(problems) > 0 {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("Invalid parameters: %s", strings.Join(problems, "; ")),
+			http.StatusBadRequest,
+			"invalid-parameters",
+		)
+	}
+	return nil
+}
+
+func objectSchema(properties map[string]*ParameterSchema, required ...string) *ParameterSchema {
+	return &ParameterSchema{
+		Type:                 "object",
+		Properties:           properties,
+		AdditionalProperties: new(bool),
+		Required:             required,
+	}
+}
+
+func stringSchema(description string, enum ...string) *ParameterSchema {
+	return &ParameterSchema{Type: "string", Description: description, Enum: enum}
+}
+
+func arraySchema(description string, items *ParameterSchema) *ParameterSchema {
+	return &ParameterSchema{Type: "array", Description: description, Items: items}
+}
+
+func stringArraySchema(description string) *ParameterSchema {
+	return arraySchema(description, &ParameterSchema{Type: "string"})
+}
+
+func daysSchema(description string) *ParameterSchema {
+	return &ParameterSchema{Type: "integer", Description: description, Minimum: new(float64)}
+}
+
+var (
+	corsRulesSchema = arraySchema(
+		"CORS rules of the bucket. An empty list removes them.",
+		objectSchema(map[string]*ParameterSchema{
+			"allowed_origins": stringArraySchema("Origins that may make cross-origin requests"),
+			"allowed_methods": arraySchema("HTTP methods that origins may use", stringSchema("", corsMethods...)),
+			"allowed_headers": stringArraySchema("Headers allowed in preflight requests"),
+			"expose_headers":  stringArraySchema("Response headers that browsers may read"),
+			"max_age_seconds": &ParameterSchema{Type: "integer", Description: "Seconds that browsers may cache a preflight response", Minimum: new(float64)},
+		}, "allowed_origins", "allowed_methods"),
+	)
+
+	lifecycleRulesSchema = func() *ParameterSchema {
+		minLength, maxLength, maxItems := 1, 255, maxLifecycleRules
+		schema := arraySchema(
+			"Lifecycle rules of the bucket. An empty list removes them.",
+			objectSchema(map[string]*ParameterSchema{
+				"id":                                     {Type: "string", Description: "Unique name of the rule", MinLength: &minLength, MaxLength: &maxLength},
+				"prefix":                                 stringSchema("Key prefix that the rule applies to"),
+				"expiration_days":                        daysSchema("Days after which objects expire"),
+				"noncurrent_version_expiration_days":     daysSchema("Days after which noncurrent versions expire"),
+				"abort_incomplete_multipart_upload_days": daysSchema("Days after which incomplete multipart uploads are aborted"),
+			}, "id"),
+		)
+		schema.MaxItems = &maxItems
+		return schema
+	}()
+
+	preserveOnDeleteSchema = &ParameterSchema{Type: "boolean", Description: "Keep the bucket and its objects when the instance is deleted"}
+
+	alertThresholdSchema = &ParameterSchema{Type: "number", Description: "Size of the bucket in GB above which operators are alerted. Zero removes the alert", Minimum: new(float64)}
+
+	tagsSchema = &ParameterSchema{Type: "object", Description: "Tags to add to the bucket, as a map of keys to string values"}
+
+	annotationsSchema = &ParameterSchema{Type: "object", Description: "Kubernetes annotations to add to the bucket's tags, as a map of keys to string values"}
+)
+
+// provisionSchema describes the provision parameters of servicePlan. Plans
+// for existing buckets take only bucket_name; other plans take nothing
+// unless user provision parameters are allowed.
+func (b *S3Broker) provisionSchema(servicePlan ServicePlan) *ParameterSchema {
+	properties := map[string]*ParameterSchema{}
+	if servicePlan.S3Properties.ExistingBucket {
+		properties["bucket_name"] = stringSchema("Name of the existing bucket (required)")
+	} else if b.allowUserProvisionParameters {
+		properties["object_ownership"] = stringSchema("Object ownership of the bucket", s3.ObjectOwnership_Values()...)
+		if len(b.regions) > 0 {
+			properties["region"] = stringSchema("Region to create the bucket in", b.allowedRegions()...)
+		}
+		properties["cors_rules"] = corsRulesSchema
+		properties["lifecycle_rules"] = lifecycleRulesSchema
+		properties["preserve_on_delete"] = preserveOnDeleteSchema
+		if b.alerts.Enabled() {
+			properties["alert_threshold_gb"] = alertThresholdSchema
+		}
+		properties["tags"] = tagsSchema
+		properties["annotations"] = annotationsSchema
+	}
+	properties = withObjectStoreParams(servicePlan, b.withStoreFeatures(servicePlan, properties))
+	schema := objectSchema(withAllowedOverrides(servicePlan, properties))
+	schema.Schema = jsonSchemaDraft
+	return schema
+}
+
+// updateSchema describes the update parameters of servicePlan, which are
+// only accepted if user update parameters are allowed.
+func (b *S3Broker) updateSchema(servicePlan ServicePlan) *ParameterSchema {
+	properties := map[string]*ParameterSchema{}
+	if b.allowUserUpdateParameters {
+		properties["apply_immediately"] = &ParameterSchema{Type: "boolean"}
+		properties["cors_rules"] = corsRulesSchema
+		properties["lifecycle_rules"] = lifecycleRulesSchema
+		properties["preserve_on_delete"] = preserveOnDeleteSchema
+		if b.alerts.Enabled() {
+			properties["alert_threshold_gb"] = alertThresholdSchema
+		}
+		properties["tags"] = tagsSchema
+		properties["annotations"] = annotationsSchema
+	}
+	properties = withObjectStoreParams(servicePlan, b.withStoreFeatures(servicePlan, properties))
+	schema := objectSchema(withAllowedOverrides(servicePlan, properties))
+	schema.Schema = jsonSchemaDraft
+	return schema
+}
+
+// bindSchema describes the bind parameters.
+func (b *S3Broker) bindSchema() *ParameterSchema {
+	schema := objectSchema(map[string]*ParameterSchema{
+		"additional_instances": stringArraySchema("Names of other service instances in the space to grant access to"),
+		"permissions": stringSchema("What the credentials may do with objects",
+			string(PermissionsReadWrite), string(PermissionsReadOnly), string(PermissionsWriteOnly)),
+		"path_prefix":   stringSchema("Key prefix that the binding is confined to"),
+		"create_folder": &ParameterSchema{Type: "boolean", Description: "Create a folder for the binding's key prefix"},
+		"credential_type": stringSchema("Kind of credentials to issue",
+			string(CredentialTypeAccessKey), string(CredentialTypeTemporary), string(CredentialTypeRole),
+			string(CredentialTypeWebIdentity), string(CredentialTypeBucketPolicy), string(CredentialTypePresignedURL)),
+		"principal":         stringSchema("AWS account ID or IAM ARN that is granted access"),
+		"external_id":       stringSchema("External ID that the principal presents"),
+		"oidc_provider_arn": stringSchema("ARN of the IAM OIDC provider trusted by the binding's role"),
+		"subject":           stringSchema("Subject of the tokens trusted by the binding's role"),
+		"secret_name":       stringSchema("Name of the Secrets Manager secret to deliver credentials through"),
+		"credential_format": stringSchema("Shape of the returned credentials", b.credentialFormatNames()...),
+	})
+	schema.Schema = jsonSchemaDraft
+	return schema
+}
+
+// planSchemas returns the schemas that the catalog advertises for servicePlan.
+func (b *S3Broker) planSchemas(servicePlan ServicePlan) *domain.ServiceSchemas {
+	return &domain.ServiceSchemas{
+		Instance: domain.ServiceInstanceSchema{
+			Create: domain.Schema{Parameters: b.provisionSchema(servicePlan).Parameters()},
+			Update: domain.Schema{Parameters: b.updateSchema(servicePlan).Parameters()},
+		},
+		Binding: domain.ServiceBindingSchema{
+			Create: domain.Schema{Parameters: b.bindSchema().Parameters()},
+		},
+	}
+}
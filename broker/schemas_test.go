@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+func TestValidateParameters(t *testing.T) {
+	b := &S3Broker{allowUserProvisionParameters: true}
+
+	testCases := map[string]struct {
+		schema        *ParameterSchema
+		parameters    string
+		expectProblem []string
+	}{
+		"valid provision parameters": {
+			schema:     b.provisionSchema(ServicePlan{}),
+			parameters: `{"object_ownership": "BucketOwnerEnforced", "lifecycle_rules": [{"id": "expire", "expiration_days": 30}]}`,
+		},
+		"typo in a parameter name": {
+			schema:        b.provisionSchema(ServicePlan{}),
+			parameters:    `{"object_ownershp": "BucketOwnerEnforced"}`,
+			expectProblem: []string{"object_ownershp: is not a supported parameter"},
+		},
+		"nested problems": {
+			schema:     b.provisionSchema(ServicePlan{}),
+			parameters: `{"cors_rules": [{"allowed_methods": ["PATCH"], "max_age_seconds": 1.5}]}`,
+			expectProblem: []string{
+				"cors_rules[0]: allowed_origins is required",
+				"cors_rules[0].allowed_methods[0]: must be one of GET, PUT, POST, DELETE, HEAD",
+				"cors_rules[0].max_age_seconds: must be an integer",
+			},
+		},
+		"parameters that are not allowed": {
+			schema:        (&S3Broker{}).provisionSchema(ServicePlan{}),
+			parameters:    `{"object_ownership": "BucketOwnerEnforced"}`,
+			expectProblem: []string{"object_ownership: is not a supported parameter"},
+		},
+		"existing bucket plan": {
+			schema:     (&S3Broker{}).provisionSchema(ServicePlan{S3Properties: S3Properties{ExistingBucket: true}}),
+			parameters: `{"bucket_name": "my-bucket"}`,
+		},
+		"not an object": {
+			schema:        b.bindSchema(),
+			parameters:    `["read-only"]`,
+			expectProblem: []string{"must be an object"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateParameters(tc.schema, json.RawMessage(tc.parameters))
+			if len(tc.expectProblem) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected problems %v", tc.expectProblem)
+			}
+			problems := strings.Split(strings.TrimPrefix(err.Error(), "Invalid parameters: "), "; ")
+			if !slices.Equal(problems, tc.expectProblem) {
+				t.Errorf("expected problems %q, got %q", tc.expectProblem, problems)
+			}
+		})
+	}
+}
+
+func TestServicesIncludeSchemas(t *testing.T) {
+	b := &S3Broker{
+		logger: lager.NewLogger("broker-unit-test-schemas"),
+		catalog: BrokerCatalog{Services: []Service{{
+			ID:    "service1",
+			Name:  "s3",
+			Plans: []ServicePlan{{ID: "plan1", Name: "basic"}},
+		}}},
+		allowUserUpdateParameters: true,
+	}
+
+	services, err := b.Services(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	schemas := services[0].Plans[0].Schemas
+	if schemas == nil {
+		t.Fatal("expected the plan to have schemas")
+	}
+
+	update := schemas.Instance.Update.Parameters
+	if update["$schema"] != jsonSchemaDraft || update["additionalProperties"] != false {
+		t.Errorf("unexpected update schema %v", update)
+	}
+	if _, ok := update["properties"].(map[string]interface{})["cors_rules"]; !ok {
+		t.Errorf("expected the update schema to describe cors_rules, got %v", update)
+	}
+	bind := schemas.Binding.Create.Parameters["properties"].(map[string]interface{})
+	if _, ok := bind["credential_type"]; !ok {
+		t.Errorf("expected the bind schema to describe credential_type, got %v", bind)
+	}
+}
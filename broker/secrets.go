@@ -0,0 +1,113 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awssecrets"
+)
+
+const maxSecretNameLength = 512
+
+var (
+	ErrSecretsManagerDisabled = apiresponses.NewFailureResponse(
+		errors.New("This broker is not configured to deliver credentials through Secrets Manager. Contact your Cloud Foundry operator for details."),
+		http.StatusBadRequest,
+		"secrets-manager-disabled",
+	)
+	ErrSecretNameCredentialType = apiresponses.NewFailureResponse(
+		errors.New("secret_name can only be used with credential_type access-key"),
+		http.StatusBadRequest,
+		"invalid-secret-name",
+	)
+
+	secretNamePattern = regexp.MustCompile(`^[a-zA-Z0-9/_+=.@-]+$`)
+)
+
+// validateSecretDelivery checks a bind request with secret_name before
+// anything is created, and returns the principals other than the binding's
+// user that may read the secret.
+func (b *S3Broker) validateSecretDelivery(bindParameters BindParameters) ([]string, error) {
+	if b.secrets == nil {
+		return nil, ErrSecretsManagerDisabled
+	}
+	switch bindParameters.CredentialType {
+	case "", CredentialTypeAccessKey:
+	default:
+		return nil, ErrSecretNameCredentialType
+	}
+
+	name := b.secretNamePrefix + bindParameters.SecretName
+	if !secretNamePattern.MatchString(bindParameters.SecretName) || len(name) > maxSecretNameLength {
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("secret_name may only contain letters, digits and /_+=.@- and be at most %d characters long, got %q", maxSecretNameLength-len(b.secretNamePrefix), bindParameters.SecretName),
+			http.StatusBadRequest,
+			"invalid-secret-name",
+		)
+	}
+
+	if bindParameters.Principal == "" {
+		return nil, nil
+	}
+	principalARN, err := b.principalARN(bindParameters.Principal)
+	if err != nil {
+		return nil, err
+	}
+	return []string{principalARN}, nil
+}
+
+// deliverSecret stores a binding's credentials in a Secrets Manager secret
+// that readerARNs may read, and returns the credentials without their keys
+// but with the secret's ARN. The secret is tagged like the binding's user,
+// and Unbind finds it by its binding GUID tag.
+func (b *S3Broker) deliverSecret(
+	ctx context.Context,
+	instanceID, bindingID, secretName string,
+	readerARNs []string,
+	iamTags []*iam.Tag,
+	credentials Credentials,
+) (Credentials, error) {
+	value, err := json.Marshal(credentials)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	tags := make(map[string]string, len(iamTags))
+	for _, tag := range iamTags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	name := b.secretNamePrefix + secretName
+	secretARN, err := b.secrets.Create(ctx, name, string(value), tags, BindingGUIDTagKey, readerARNs)
+	if err != nil {
+		b.logger.Error("bind: error delivering credentials to secrets manager", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			"secret":         name,
+		})
+		if errors.Is(err, awssecrets.ErrSecretExists) {
+			return Credentials{}, apiresponses.NewFailureResponse(
+				fmt.Errorf("Secret %s already exists. Choose another secret_name.", secretName),
+				http.StatusConflict,
+				"secret-exists",
+			)
+		}
+		return Credentials{}, err
+	}
+
+	credentials.AccessKeyID = ""
+	credentials.SecretAccessKey = ""
+	credentials.URI = ""
+	credentials.SecretARN = secretARN
+	credentials.SecretName = name
+	return credentials, nil
+}
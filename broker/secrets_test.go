@@ -0,0 +1,162 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awssecrets"
+)
+
+type mockSecrets struct {
+	createErr error
+	updateErr error
+	secrets   map[string]string
+	readers   map[string][]string
+	tags      map[string]map[string]string
+}
+
+func (s *mockSecrets) Create(ctx context.Context, name, value string, tags map[string]string, ownerTagKey string, readerARNs []string) (string, error) {
+	if s.createErr != nil {
+		return "", s.createErr
+	}
+	if s.secrets == nil {
+		s.secrets = make(map[string]string)
+		s.readers = make(map[string][]string)
+		s.tags = make(map[string]map[string]string)
+	}
+	s.secrets[name] = value
+	s.readers[name] = readerARNs
+	s.tags[name] = tags
+	return "arn:aws:secretsmanager:us-east-1:000000000000:secret:" + name, nil
+}
+
+func (s *mockSecrets) Update(ctx context.Context, secretARN, value string) error {
+	if s.updateErr != nil {
+		return s.updateErr
+	}
+	name := strings.TrimPrefix(secretARN, "arn:aws:secretsmanager:us-east-1:000000000000:secret:")
+	if _, ok := s.secrets[name]; !ok {
+		return errors.New("ResourceNotFoundException: no secret " + secretARN)
+	}
+	s.secrets[name] = value
+	return nil
+}
+
+func (s *mockSecrets) DeleteTagged(ctx context.Context, tagKey, tagValue string) error {
+	for name, tags := range s.tags {
+		if tags[tagKey] == tagValue {
+			delete(s.secrets, name)
+			delete(s.tags, name)
+		}
+	}
+	return nil
+}
+
+func TestBindSecretsManager(t *testing.T) {
+	testCases := map[string]struct {
+		secrets       *mockSecrets
+		parameters    string
+		expectErr     string
+		expectReaders []string
+		expectCleanup bool
+	}{
+		"delivers credentials": {
+			secrets:       &mockSecrets{},
+			parameters:    `{"secret_name": "my-app/s3"}`,
+			expectReaders: []string{"arn:aws:iam::000000000000:user/-binding1"},
+		},
+		"with principal": {
+			secrets:       &mockSecrets{},
+			parameters:    `{"secret_name": "my-app/s3", "principal": "arn:aws:iam::123456789012:role/my-app"}`,
+			expectReaders: []string{"arn:aws:iam::000000000000:user/-binding1", "arn:aws:iam::123456789012:role/my-app"},
+		},
+		"disabled": {
+			parameters: `{"secret_name": "my-app/s3"}`,
+			expectErr:  ErrSecretsManagerDisabled.Error(),
+		},
+		"other credential type": {
+			secrets:    &mockSecrets{},
+			parameters: `{"secret_name": "my-app/s3", "credential_type": "temporary"}`,
+			expectErr:  ErrSecretNameCredentialType.Error(),
+		},
+		"invalid name": {
+			secrets:    &mockSecrets{},
+			parameters: `{"secret_name": "my app"}`,
+			expectErr:  `secret_name may only contain letters, digits and /_+=.@- and be at most 506 characters long, got "my app"`,
+		},
+		"secret of someone else": {
+			secrets:       &mockSecrets{createErr: awssecrets.ErrSecretExists},
+			parameters:    `{"secret_name": "my-app/s3"}`,
+			expectErr:     "Secret my-app/s3 already exists. Choose another secret_name.",
+			expectCleanup: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			user := &mockUser{}
+			b := &S3Broker{
+				logger:           lager.NewLogger("broker-unit-test-secrets"),
+				bucket:           &mockBucket{},
+				catalog:          &mockCatalog{planName: "plan1", serviceName: "service1"},
+				tagManager:       &mockTagGenerator{},
+				user:             user,
+				awsPartition:     "aws",
+				secretNamePrefix: "cf-s3/",
+			}
+			if tc.secrets != nil {
+				b.secrets = tc.secrets
+			}
+
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(tc.parameters),
+			}, false)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected err %q, got %v", tc.expectErr, err)
+				}
+				if tc.expectCleanup && len(user.accessKeys["-binding1"]) != 0 {
+					t.Errorf("expected access key to be deleted, got %v", user.accessKeys)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			credentials := binding.Credentials.(Credentials)
+			if credentials.AccessKeyID != "" || credentials.SecretAccessKey != "" || credentials.URI != "" {
+				t.Errorf("expected no keys in the binding, got %+v", credentials)
+			}
+			if credentials.SecretName != "cf-s3/my-app/s3" || credentials.SecretARN != "arn:aws:secretsmanager:us-east-1:000000000000:secret:cf-s3/my-app/s3" {
+				t.Errorf("unexpected secret %s %s", credentials.SecretName, credentials.SecretARN)
+			}
+
+			var stored Credentials
+			if err := json.Unmarshal([]byte(tc.secrets.secrets["cf-s3/my-app/s3"]), &stored); err != nil {
+				t.Fatalf("invalid secret: %s", err)
+			}
+			if stored.AccessKeyID != "-binding1-0" {
+				t.Errorf("expected keys in the secret, got %+v", stored)
+			}
+			if readers := tc.secrets.readers["cf-s3/my-app/s3"]; !slices.Equal(readers, tc.expectReaders) {
+				t.Errorf("expected readers %v, got %v", tc.expectReaders, readers)
+			}
+
+			if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{}, false); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(tc.secrets.secrets) != 0 {
+				t.Errorf("expected secret to be deleted, got %v", tc.secrets.secrets)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// SharedSpaceTagKeyPrefix starts the keys of the bucket tags that record the
+// spaces an instance has been shared with and bound from. Each tag's value is
+// the space's organization GUID.
+const SharedSpaceTagKeyPrefix = "Shared space "
+
+var (
+	ErrSharedBindingPermissions = apiresponses.NewFailureResponse(
+		errors.New("Bindings from spaces this service instance is shared with can only have read-only permissions."),
+		http.StatusBadRequest,
+		"shared-binding-permissions",
+	)
+	ErrSharedBindingCredentialType = apiresponses.NewFailureResponse(
+		errors.New("Bindings from spaces this service instance is shared with cannot use credential_type bucket-policy, which changes the bucket's policy."),
+		http.StatusBadRequest,
+		"shared-binding-credential-type",
+	)
+)
+
+// sharedBinding is the space a binding was requested from, when that is not
+// the space that owns the instance.
+type sharedBinding struct {
+	SpaceGUID        string
+	OrganizationGUID string
+}
+
+// checkSharedBinding reports whether a binding is requested from a space the
+// instance has been shared with, rather than the space that owns it. The
+// owning space is the one the bucket was tagged with when it was created or
+// adopted; bindings without a space in their context are never shared.
+//
+// If the broker restricts shared bindings, they default to read-only
+// permissions and cannot ask for more, or change the bucket's policy.
+func (b *S3Broker) checkSharedBinding(ctx context.Context, bucketName string, bindContext BindContext, bindParameters *BindParameters) (*sharedBinding, error) {
+	if bindContext.SpaceGUID == "" {
+		return nil, nil
+	}
+	tags, err := b.bucket.Tags(ctx, bucketName)
+	if err != nil {
+		return nil, mapBucketError(err)
+	}
+	owningSpace := tags[brokertags.SpaceGUIDTagKey]
+	if owningSpace == "" || owningSpace == bindContext.SpaceGUID {
+		return nil, nil
+	}
+
+	if b.restrictSharedBindings {
+		switch bindParameters.Permissions {
+		case "":
+			bindParameters.Permissions = PermissionsReadOnly
+		case PermissionsReadOnly:
+		default:
+			return nil, ErrSharedBindingPermissions
+		}
+		if bindParameters.CredentialType == CredentialTypeBucketPolicy {
+			return nil, ErrSharedBindingCredentialType
+		}
+	}
+
+	return &sharedBinding{
+		SpaceGUID:        bindContext.SpaceGUID,
+		OrganizationGUID: bindContext.OrganizationGUID,
+	}, nil
+}
+
+// tagSharedSpace records on the bucket that the instance is used from a space
+// it has been shared with. Tags are not removed on unbind, so they list every
+// space the instance has been bound from.
+func (b *S3Broker) tagSharedSpace(ctx context.Context, bucketName string, shared *sharedBinding) error {
+	if shared == nil {
+		return nil
+	}
+	return mapBucketError(b.bucket.AddTags(ctx, bucketName, map[string]string{
+		SharedSpaceTagKeyPrefix + shared.SpaceGUID: shared.OrganizationGUID,
+	}))
+}
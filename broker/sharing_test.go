@@ -0,0 +1,137 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func TestBindSharedInstance(t *testing.T) {
+	owned := map[string]string{brokertags.SpaceGUIDTagKey: "space1"}
+
+	testCases := map[string]struct {
+		bucketTags map[string]string
+		context    string
+		expectTags map[string]string
+	}{
+		"owning space": {
+			bucketTags: owned,
+			context:    `{"organization_guid":"org1","space_guid":"space1"}`,
+			expectTags: owned,
+		},
+		"shared space": {
+			bucketTags: owned,
+			context:    `{"organization_guid":"org2","space_guid":"space2"}`,
+			expectTags: map[string]string{brokertags.SpaceGUIDTagKey: "space1", "Shared space space2": "org2"},
+		},
+		"no space in context": {
+			bucketTags: owned,
+			expectTags: owned,
+		},
+		"untagged bucket": {
+			context: `{"organization_guid":"org2","space_guid":"space2"}`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := &mockBucket{tags: tc.bucketTags}
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-sharing"),
+				bucket:       bucket,
+				catalog:      &mockCatalog{planName: "plan1", serviceName: "service1", s3Properties: S3Properties{IamPolicy: "{}"}},
+				tagManager:   &mockTagGenerator{},
+				user:         &mockUser{},
+				bucketPrefix: "prefix",
+			}
+			details := domain.BindDetails{ServiceID: "service1", PlanID: "plan1"}
+			if tc.context != "" {
+				details.RawContext = json.RawMessage(tc.context)
+			}
+			if _, err := b.Bind(context.Background(), "instance1", "binding1", details, false); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(tc.expectTags, bucket.tags); diff != "" {
+				t.Errorf("unexpected bucket tags (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckSharedBinding(t *testing.T) {
+	shared := BindContext{OrganizationGUID: "org2", SpaceGUID: "space2"}
+
+	testCases := map[string]struct {
+		restrict          bool
+		bindContext       BindContext
+		parameters        BindParameters
+		expectShared      bool
+		expectPermissions Permissions
+		expectErr         error
+	}{
+		"owning space": {
+			restrict:    true,
+			bindContext: BindContext{OrganizationGUID: "org1", SpaceGUID: "space1"},
+		},
+		"shared space": {
+			bindContext:  shared,
+			parameters:   BindParameters{CredentialType: CredentialTypeBucketPolicy},
+			expectShared: true,
+		},
+		"restricted shared space defaults to read-only": {
+			restrict:          true,
+			bindContext:       shared,
+			expectShared:      true,
+			expectPermissions: PermissionsReadOnly,
+		},
+		"restricted shared space allows read-only": {
+			restrict:          true,
+			bindContext:       shared,
+			parameters:        BindParameters{Permissions: PermissionsReadOnly},
+			expectShared:      true,
+			expectPermissions: PermissionsReadOnly,
+		},
+		"restricted shared space rejects read-write": {
+			restrict:    true,
+			bindContext: shared,
+			parameters:  BindParameters{Permissions: PermissionsReadWrite},
+			expectErr:   ErrSharedBindingPermissions,
+		},
+		"restricted shared space rejects bucket-policy": {
+			restrict:    true,
+			bindContext: shared,
+			parameters:  BindParameters{CredentialType: CredentialTypeBucketPolicy},
+			expectErr:   ErrSharedBindingCredentialType,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				bucket:                 &mockBucket{tags: map[string]string{brokertags.SpaceGUIDTagKey: "space1"}},
+				restrictSharedBindings: tc.restrict,
+			}
+			parameters := tc.parameters
+			sharedBinding, err := b.checkSharedBinding(context.Background(), "bucket", tc.bindContext, &parameters)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if (sharedBinding != nil) != tc.expectShared {
+				t.Errorf("expected shared %t, got %+v", tc.expectShared, sharedBinding)
+			}
+			if parameters.Permissions != tc.expectPermissions {
+				t.Errorf("expected permissions %q, got %q", tc.expectPermissions, parameters.Permissions)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/service/iam"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+const defaultStaleAccessKeyCheckInterval = 24 * time.Hour
+
+// CheckStaleAccessKeys finds binding access keys older than the configured
+// maximum age, logs a warning for each and, if configured, deactivates them.
+// Each deactivation is written to the audit trail with the key's binding and
+// when the key was last used. Its AWS calls are low priority.
+func (b *S3Broker) CheckStaleAccessKeys(ctx context.Context) error {
+	ctx = awsretry.WithLowPriority(ctx)
+	for _, accountBroker := range b.accountBrokers() {
+		if err := accountBroker.checkStaleAccessKeys(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStaleAccessKeys checks the access keys of binding users in the
+// broker's account.
+func (b *S3Broker) checkStaleAccessKeys(ctx context.Context) error {
+	logger := b.logger.Session("stale-access-keys", lager.Data{"account": b.account})
+
+	userNames, err := b.user.ListUsers(pathPrefix(b.iamPath))
+	if err != nil {
+		logger.Error("list-users", err)
+		return err
+	}
+
+	parseBindingID := nameParser(b.userNameTemplate, b.userPrefix)
+	cutoff := time.Now().Add(-b.staleAccessKeys.MaxAge)
+	for _, userName := range userNames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		bindingID, ok := parseBindingID(userName)
+		if !ok {
+			continue
+		}
+
+		accessKeys, err := b.user.DescribeAccessKeys(userName)
+		if err != nil {
+			logger.Error("describe-access-keys", err, lager.Data{"user": userName})
+			continue
+		}
+		for _, accessKey := range accessKeys {
+			if accessKey.Status != iam.StatusTypeActive || accessKey.CreateDate.After(cutoff) {
+				continue
+			}
+			data := lager.Data{
+				"user":          userName,
+				"access-key-id": accessKey.AccessKeyID,
+				"created":       accessKey.CreateDate.UTC().Format(time.RFC3339),
+			}
+			if !b.staleAccessKeys.Deactivate {
+				logger.Info("stale", data)
+				continue
+			}
+			details := map[string]any{
+				"account": b.account,
+				"user":    userName,
+				"created": accessKey.CreateDate.UTC(),
+			}
+			// The last use shows whether an app still depended on the key.
+			if lastUsed, err := b.user.AccessKeyLastUsed(accessKey.AccessKeyID); err != nil {
+				logger.Error("access-key-last-used", err, data)
+			} else if lastUsed.IsZero() {
+				details["last_used"] = "never"
+			} else {
+				details["last_used"] = lastUsed.UTC()
+				data["last-used"] = lastUsed.UTC().Format(time.RFC3339)
+			}
+			err := b.user.DeactivateAccessKey(userName, accessKey.AccessKeyID)
+			b.AuditAction(ctx, AuditEvent{
+				Event:       Event{Action: "deactivate-stale-access-key", BindingID: bindingID},
+				AccessKeyID: accessKey.AccessKeyID,
+				Details:     details,
+			}, err)
+			if err != nil {
+				logger.Error("deactivate", err, data)
+				continue
+			}
+			logger.Info("deactivated", data)
+		}
+	}
+	return nil
+}
+
+// RunStaleAccessKeyChecks calls CheckStaleAccessKeys on the configured
+// interval until ctx is done, while this broker is the leader. It returns at
+// once if no maximum age is set.
+func (b *S3Broker) RunStaleAccessKeyChecks(ctx context.Context) {
+	if b.staleAccessKeys.MaxAge == 0 {
+		return
+	}
+	interval := b.staleAccessKeys.CheckInterval
+	if interval == 0 {
+		interval = defaultStaleAccessKeyCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if b.isLeader() {
+			b.CheckStaleAccessKeys(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/cloud-gov/s3-broker/auditlog"
+)
+
+func TestCheckStaleAccessKeys(t *testing.T) {
+	lastUsed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newUser := func() *mockUser {
+		return &mockUser{
+			accessKeys: map[string][]string{
+				"prefix-binding1": {"old-key", "new-key"},
+				"prefix-binding2": {"inactive-key"},
+				"other-user":      {"other-key"},
+			},
+			accessKeyCreateDates: map[string]time.Time{
+				"old-key":      time.Now().Add(-100 * 24 * time.Hour),
+				"new-key":      time.Now().Add(-time.Hour),
+				"inactive-key": time.Now().Add(-100 * 24 * time.Hour),
+				"other-key":    time.Now().Add(-100 * 24 * time.Hour),
+			},
+			inactiveAccessKeys: []string{"inactive-key"},
+			accessKeyLastUsed:  map[string]time.Time{"old-key": lastUsed},
+		}
+	}
+
+	testCases := map[string]struct {
+		deactivate        bool
+		expectDeactivated []string
+		expectAudited     []AuditEvent
+	}{
+		"report only": {
+			expectDeactivated: []string{"inactive-key"},
+		},
+		"deactivate": {
+			deactivate:        true,
+			expectDeactivated: []string{"inactive-key", "old-key"},
+			expectAudited: []AuditEvent{{
+				Event:       Event{Action: "deactivate-stale-access-key", BindingID: "binding1", Succeeded: true},
+				AccessKeyID: "old-key",
+				Details: map[string]any{
+					"account":   "",
+					"user":      "prefix-binding1",
+					"last_used": lastUsed.Format(time.RFC3339),
+				},
+			}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			user := newUser()
+			sink := &mockAuditSink{}
+			b := &S3Broker{
+				audit:      auditlog.New(sink, nil, auditlog.Record{}),
+				logger:     lager.NewLogger("broker-unit-test-stale-keys"),
+				user:       user,
+				userPrefix: "prefix",
+				staleAccessKeys: StaleAccessKeysConfig{
+					MaxAge:     90 * 24 * time.Hour,
+					Deactivate: tc.deactivate,
+				},
+			}
+			if err := b.CheckStaleAccessKeys(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !slices.Equal(user.inactiveAccessKeys, tc.expectDeactivated) {
+				t.Errorf("expected inactive keys %v, got %v", tc.expectDeactivated, user.inactiveAccessKeys)
+			}
+
+			var audited []AuditEvent
+			decoder := json.NewDecoder(&sink.Buffer)
+			for decoder.More() {
+				var record struct {
+					Event AuditEvent `json:"event"`
+				}
+				if err := decoder.Decode(&record); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				delete(record.Event.Details, "created")
+				audited = append(audited, record.Event)
+			}
+			if diff := cmp.Diff(tc.expectAudited, audited, cmpopts.IgnoreFields(Event{}, "Time")); diff != "" {
+				t.Errorf("unexpected audit events (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+// stateBindingStore keeps bindings in a state store, so they survive
+// restarts and are shared by every broker process that uses the store.
+type stateBindingStore struct {
+	store state.Store
+}
+
+func (s stateBindingStore) GetBinding(bindingID string) (StoredBinding, error) {
+	binding, err := s.store.GetBinding(context.Background(), bindingID)
+	if errors.Is(err, state.ErrBindingNotFound) {
+		return StoredBinding{}, ErrStoredBindingNotFound
+	}
+	if err != nil {
+		return StoredBinding{}, err
+	}
+	var stored StoredBinding
+	if err := json.Unmarshal(binding.Data, &stored); err != nil {
+		return StoredBinding{}, err
+	}
+	return stored, nil
+}
+
+func (s stateBindingStore) SaveBinding(stored StoredBinding) error {
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	// Saving a binding again, after key rotation, keeps when it was created.
+	createdAt := time.Now()
+	if existing, err := s.store.GetBinding(context.Background(), stored.BindingID); err == nil {
+		createdAt = existing.CreatedAt
+	}
+	return s.store.SaveBinding(context.Background(), state.Binding{
+		BindingID:  stored.BindingID,
+		InstanceID: stored.InstanceID,
+		Data:       data,
+		CreatedAt:  createdAt,
+	})
+}
+
+func (s stateBindingStore) DeleteBinding(bindingID string) error {
+	return s.store.DeleteBinding(context.Background(), bindingID)
+}
+
+// stateOperationStore keeps operations in a state store. Unlike
+// MemoryOperationStore, it keeps every operation.
+type stateOperationStore struct {
+	store state.Store
+}
+
+func (s stateOperationStore) RecordOperation(operation Operation) error {
+	return s.store.RecordOperation(context.Background(), state.Operation(operation))
+}
+
+func (s stateOperationStore) ListOperations(instanceID string, limit int) ([]Operation, error) {
+	stored, err := s.store.ListOperations(context.Background(), instanceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	operations := make([]Operation, len(stored))
+	for i, operation := range stored {
+		operations[i] = Operation(operation)
+	}
+	return operations, nil
+}
+
+// Kinds of the state records that keep what the broker's other stores would
+// keep in memory.
+const (
+	temporaryBindingRecordKind = "temporary-binding"
+	presignBindingRecordKind   = "presign-binding"
+	deprovisionRecordKind      = "deprovision"
+	requestRecordKind          = "request"
+)
+
+// stateRecords keeps values of one kind as JSON records in a state store.
+type stateRecords struct {
+	store state.Store
+	kind  string
+}
+
+// get decodes the record with key into value, returning notFound if there
+// is none.
+func (s stateRecords) get(key string, value any, notFound error) error {
+	record, err := s.store.GetRecord(context.Background(), s.kind, key)
+	if errors.Is(err, state.ErrRecordNotFound) {
+		return notFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(record.Data, value)
+}
+
+func (s stateRecords) save(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.store.SaveRecord(context.Background(), state.Record{
+		Kind:      s.kind,
+		Key:       key,
+		Data:      data,
+		UpdatedAt: time.Now(),
+	})
+}
+
+func (s stateRecords) delete(key string) error {
+	return s.store.DeleteRecord(context.Background(), s.kind, key)
+}
+
+// stateTemporaryBindingStore keeps temporary bindings in a state store, so
+// apps can refresh their credentials after a restart, through any broker
+// that uses the store.
+type stateTemporaryBindingStore struct {
+	records stateRecords
+}
+
+func (s stateTemporaryBindingStore) GetTemporaryBinding(bindingID string) (TemporaryBinding, error) {
+	var binding TemporaryBinding
+	if err := s.records.get(bindingID, &binding, ErrTemporaryBindingNotFound); err != nil {
+		return TemporaryBinding{}, err
+	}
+	return binding, nil
+}
+
+func (s stateTemporaryBindingStore) SaveTemporaryBinding(binding TemporaryBinding) error {
+	return s.records.save(binding.BindingID, binding)
+}
+
+func (s stateTemporaryBindingStore) DeleteTemporaryBinding(bindingID string) error {
+	return s.records.delete(bindingID)
+}
+
+// statePresignBindingStore keeps presign bindings in a state store, so apps
+// can request URLs after a restart, from any broker that uses the store.
+type statePresignBindingStore struct {
+	records stateRecords
+}
+
+func (s statePresignBindingStore) GetPresignBinding(bindingID string) (PresignBinding, error) {
+	var binding PresignBinding
+	if err := s.records.get(bindingID, &binding, ErrPresignBindingNotFound); err != nil {
+		return PresignBinding{}, err
+	}
+	return binding, nil
+}
+
+func (s statePresignBindingStore) SavePresignBinding(binding PresignBinding) error {
+	return s.records.save(binding.BindingID, binding)
+}
+
+func (s statePresignBindingStore) DeletePresignBinding(bindingID string) error {
+	return s.records.delete(bindingID)
+}
+
+// stateDeprovisionStore keeps the progress of asynchronous deprovisions in a
+// state store, so any broker that uses the store can report it.
+type stateDeprovisionStore struct {
+	records stateRecords
+}
+
+func (s stateDeprovisionStore) GetDeprovision(instanceID string) (Deprovision, error) {
+	var deprovision Deprovision
+	if err := s.records.get(instanceID, &deprovision, ErrDeprovisionNotFound); err != nil {
+		return Deprovision{}, err
+	}
+	return deprovision, nil
+}
+
+func (s stateDeprovisionStore) SaveDeprovision(deprovision Deprovision) error {
+	return s.records.save(deprovision.InstanceID, deprovision)
+}
+
+func (s stateDeprovisionStore) DeleteDeprovision(instanceID string) error {
+	return s.records.delete(instanceID)
+}
+
+// stateRequestStore keeps request fingerprints in a state store, so replays
+// are recognized after a restart, by any broker that uses the store.
+type stateRequestStore struct {
+	records stateRecords
+}
+
+func (s stateRequestStore) GetRequest(key string) (string, error) {
+	var fingerprint string
+	if err := s.records.get(key, &fingerprint, ErrRequestNotFound); err != nil {
+		return "", err
+	}
+	return fingerprint, nil
+}
+
+func (s stateRequestStore) SaveRequest(key, fingerprint string) error {
+	return s.records.save(key, fingerprint)
+}
+
+func (s stateRequestStore) DeleteRequest(key string) error {
+	return s.records.delete(key)
+}
+
+// saveInstanceState records a provisioned or updated instance in the state
+// store, merging parameters into those it was given before. The bucket stays
+// the source of truth for the instance's configuration, so updates that fail
+// to record it still succeed, but provisions fail: garbage collection would
+// take the bucket of an instance missing from the store for an orphan.
+func (b *S3Broker) saveInstanceState(ctx context.Context, instance state.Instance, parameters json.RawMessage) error {
+	if b.state == nil {
+		return nil
+	}
+	logger := b.logger.Session("save-instance-state", lager.Data{instanceIDLogKey: instance.InstanceID})
+
+	now := time.Now()
+	instance.CreatedAt, instance.UpdatedAt = now, now
+	existing, err := b.state.GetInstance(ctx, instance.InstanceID)
+	switch {
+	case err == nil:
+		instance.CreatedAt = existing.CreatedAt
+		if instance.OrganizationGUID == "" {
+			instance.OrganizationGUID, instance.SpaceGUID = existing.OrganizationGUID, existing.SpaceGUID
+		}
+		parameters, err = mergeParameters(existing.Parameters, parameters)
+		if err != nil {
+			logger.Error("merge-parameters", err)
+			return err
+		}
+	case !errors.Is(err, state.ErrInstanceNotFound):
+		logger.Error("get-instance", err)
+		return err
+	}
+	instance.Parameters = parameters
+
+	if instance.BucketName == "" {
+		servicePlan, _ := b.catalog.FindServicePlan(instance.PlanID)
+		if instance.BucketName, err = b.instanceBucketName(ctx, instance.InstanceID, servicePlan); err != nil {
+			logger.Error("bucket-name", err)
+		}
+	}
+	if err := b.state.SaveInstance(ctx, instance); err != nil {
+		logger.Error("save-instance", err)
+		return err
+	}
+	return nil
+}
+
+// instanceState returns what the state store knows about an instance, if the
+// broker has one and the instance is in it.
+func (b *S3Broker) instanceState(ctx context.Context, instanceID string) (state.Instance, bool) {
+	if b.state == nil {
+		return state.Instance{}, false
+	}
+	instance, err := b.state.GetInstance(ctx, instanceID)
+	if err != nil {
+		if !errors.Is(err, state.ErrInstanceNotFound) {
+			b.logger.Error("get-instance-state", err, lager.Data{instanceIDLogKey: instanceID})
+		}
+		return state.Instance{}, false
+	}
+	return instance, true
+}
+
+// deleteInstanceState forgets a deprovisioned instance and its usage.
+func (b *S3Broker) deleteInstanceState(ctx context.Context, instanceID string) {
+	if b.state == nil {
+		return
+	}
+	if err := b.state.DeleteInstance(ctx, instanceID); err != nil {
+		b.logger.Error("delete-instance-state", err, lager.Data{instanceIDLogKey: instanceID})
+	}
+	if err := b.state.DeleteUsage(ctx, instanceID); err != nil {
+		b.logger.Error("delete-usage-state", err, lager.Data{instanceIDLogKey: instanceID})
+	}
+}
+
+// mergeParameters returns the JSON object previous with the keys of updated
+// replacing its own.
+func mergeParameters(previous, updated json.RawMessage) (json.RawMessage, error) {
+	if len(previous) == 0 {
+		return updated, nil
+	}
+	if len(updated) == 0 {
+		return previous, nil
+	}
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(previous, &merged); err != nil {
+		return nil, err
+	}
+	if merged == nil {
+		merged = map[string]json.RawMessage{}
+	}
+	var changes map[string]json.RawMessage
+	if err := json.Unmarshal(updated, &changes); err != nil {
+		return nil, err
+	}
+	maps.Copy(merged, changes)
+	return json.Marshal(merged)
+}
+
+// lastOperation reports the outcome of the most recent operation on an
+// instance, or on one of its bindings if bindingID is set. The broker
+// finishes every operation but asynchronous deprovisions before responding,
+// so this only tells platforms how a request they lost the response to
+// ended.
+func (b *S3Broker) lastOperation(instanceID, bindingID string) (domain.LastOperation, error) {
+	operations, err := b.operations.ListOperations(instanceID, maxRecentOperations)
+	if err != nil {
+		return domain.LastOperation{}, err
+	}
+	for _, operation := range operations {
+		if operation.BindingID != bindingID {
+			continue
+		}
+		if operation.Error != "" {
+			return domain.LastOperation{State: domain.Failed, Description: operation.Action + " failed: " + operation.Error}, nil
+		}
+		return domain.LastOperation{State: domain.Succeeded, Description: operation.Action + " succeeded"}, nil
+	}
+	if bindingID != "" {
+		return domain.LastOperation{}, apiresponses.ErrBindingDoesNotExist
+	}
+	return domain.LastOperation{}, apiresponses.ErrInstanceDoesNotExist
+}
@@ -0,0 +1,371 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+func TestInstanceState(t *testing.T) {
+	store := state.NewMemoryStore()
+	b := &S3Broker{
+		logger:                       lager.NewLogger("broker-unit-test-state"),
+		catalog:                      &mockCatalog{planName: "plan1", serviceName: "service1"},
+		tagManager:                   &mockTagGenerator{},
+		bucket:                       &mockBucket{},
+		bucketPrefix:                 "prefix",
+		allowUserProvisionParameters: true,
+		allowUserUpdateParameters:    true,
+		state:                        store,
+	}
+	ctx := context.Background()
+
+	if _, err := b.Provision(ctx, "instance1", domain.ProvisionDetails{
+		ServiceID:        "service1",
+		PlanID:           "plan1",
+		OrganizationGUID: "org1",
+		SpaceGUID:        "space1",
+		RawParameters:    json.RawMessage(`{"object_ownership":"BucketOwnerEnforced","preserve_on_delete":true}`),
+	}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	provisioned, err := store.GetInstance(ctx, "instance1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := b.Update(ctx, "instance1", domain.UpdateDetails{
+		ServiceID:     "service1",
+		PlanID:        "plan1",
+		RawParameters: json.RawMessage(`{"preserve_on_delete":false}`),
+	}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	updated, err := store.GetInstance(ctx, "instance1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expect := state.Instance{
+		InstanceID:       "instance1",
+		ServiceID:        "service1",
+		PlanID:           "plan1",
+		OrganizationGUID: "org1",
+		SpaceGUID:        "space1",
+		BucketName:       "prefix-instance1",
+		Parameters:       json.RawMessage(`{"object_ownership":"BucketOwnerEnforced","preserve_on_delete":false}`),
+		CreatedAt:        provisioned.CreatedAt,
+		UpdatedAt:        updated.UpdatedAt,
+	}
+	if diff := cmp.Diff(expect, updated); diff != "" {
+		t.Errorf("unexpected instance (-want +got):\n%s", diff)
+	}
+
+	if _, err := b.Deprovision(ctx, "instance1", domain.DeprovisionDetails{PlanID: "plan1"}, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := store.GetInstance(ctx, "instance1"); !errors.Is(err, state.ErrInstanceNotFound) {
+		t.Errorf("expected instance to be forgotten, got %v", err)
+	}
+}
+
+// failingSaveStore is a state store that cannot save instances.
+type failingSaveStore struct {
+	*state.MemoryStore
+}
+
+func (s failingSaveStore) SaveInstance(ctx context.Context, instance state.Instance) error {
+	return errors.New("database is read-only")
+}
+
+func TestProvisionFailsWithoutInstanceState(t *testing.T) {
+	store := failingSaveStore{state.NewMemoryStore()}
+	b := &S3Broker{
+		logger:       lager.NewLogger("broker-unit-test-state"),
+		catalog:      &mockCatalog{planName: "plan1", serviceName: "service1"},
+		tagManager:   &mockTagGenerator{},
+		bucket:       &mockBucket{},
+		bucketPrefix: "prefix",
+		state:        store,
+	}
+	ctx := context.Background()
+
+	if _, err := b.Provision(ctx, "instance1", domain.ProvisionDetails{ServiceID: "service1", PlanID: "plan1"}, false); err == nil {
+		t.Fatal("expected the provision to fail when the instance cannot be recorded")
+	}
+
+	// Updates still succeed, since the instance is already known.
+	store.MemoryStore.SaveInstance(ctx, state.Instance{InstanceID: "instance1", ServiceID: "service1", PlanID: "plan1"})
+	if _, err := b.Update(ctx, "instance1", domain.UpdateDetails{ServiceID: "service1", PlanID: "plan1"}, false); err != nil {
+		t.Errorf("expected the update to succeed, got %s", err)
+	}
+}
+
+func TestStateBindingStore(t *testing.T) {
+	store := stateBindingStore{store: state.NewMemoryStore()}
+	if _, err := store.GetBinding("binding1"); !errors.Is(err, ErrStoredBindingNotFound) {
+		t.Fatalf("expected ErrStoredBindingNotFound, got %v", err)
+	}
+
+	binding := StoredBinding{
+		InstanceID:       "instance1",
+		BindingID:        "binding1",
+		Credentials:      Credentials{Bucket: "bucket", AccessKeyID: "key1", SecretAccessKey: "secret1"},
+		CredentialFormat: "aws-cli",
+	}
+	if err := store.SaveBinding(binding); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	created, err := store.store.GetBinding(context.Background(), "binding1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	binding.Credentials.AccessKeyID = "key2"
+	if err := store.SaveBinding(binding); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := store.GetBinding("binding1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(binding, got); diff != "" {
+		t.Errorf("unexpected binding (-want +got):\n%s", diff)
+	}
+	rotated, err := store.store.GetBinding(context.Background(), "binding1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !rotated.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("expected creation time %s to be kept, got %s", created.CreatedAt, rotated.CreatedAt)
+	}
+
+	if err := store.DeleteBinding("binding1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := store.GetBinding("binding1"); !errors.Is(err, ErrStoredBindingNotFound) {
+		t.Errorf("expected ErrStoredBindingNotFound after delete, got %v", err)
+	}
+}
+
+func TestStateRecordStores(t *testing.T) {
+	store := state.NewMemoryStore()
+	temporaryBindings := stateTemporaryBindingStore{records: stateRecords{store: store, kind: temporaryBindingRecordKind}}
+	presignBindings := statePresignBindingStore{records: stateRecords{store: store, kind: presignBindingRecordKind}}
+	requests := stateRequestStore{records: stateRecords{store: store, kind: requestRecordKind}}
+
+	if _, err := temporaryBindings.GetTemporaryBinding("binding1"); !errors.Is(err, ErrTemporaryBindingNotFound) {
+		t.Fatalf("expected ErrTemporaryBindingNotFound, got %v", err)
+	}
+	temporaryBinding := TemporaryBinding{InstanceID: "instance1", BindingID: "binding1", SessionTags: map[string]string{"team": "data"}, RefreshTokenHash: "hash"}
+	if err := temporaryBindings.SaveTemporaryBinding(temporaryBinding); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, err := temporaryBindings.GetTemporaryBinding("binding1"); err != nil || !cmp.Equal(temporaryBinding, got) {
+		t.Errorf("expected %+v, got %+v, %v", temporaryBinding, got, err)
+	}
+
+	// Records of other kinds with the same key are kept apart.
+	if _, err := presignBindings.GetPresignBinding("binding1"); !errors.Is(err, ErrPresignBindingNotFound) {
+		t.Fatalf("expected ErrPresignBindingNotFound, got %v", err)
+	}
+	presignBinding := PresignBinding{InstanceID: "instance1", BindingID: "binding1", BucketName: "bucket", Permissions: PermissionsReadOnly, TokenHash: "hash"}
+	if err := presignBindings.SavePresignBinding(presignBinding); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, err := presignBindings.GetPresignBinding("binding1"); err != nil || got != presignBinding {
+		t.Errorf("expected %+v, got %+v, %v", presignBinding, got, err)
+	}
+
+	if err := temporaryBindings.DeleteTemporaryBinding("binding1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := temporaryBindings.GetTemporaryBinding("binding1"); !errors.Is(err, ErrTemporaryBindingNotFound) {
+		t.Errorf("expected ErrTemporaryBindingNotFound after delete, got %v", err)
+	}
+	if _, err := presignBindings.GetPresignBinding("binding1"); err != nil {
+		t.Errorf("expected the presign binding to be kept, got %v", err)
+	}
+
+	if _, err := requests.GetRequest("instance/instance1"); !errors.Is(err, ErrRequestNotFound) {
+		t.Fatalf("expected ErrRequestNotFound, got %v", err)
+	}
+	if err := requests.SaveRequest("instance/instance1", "fingerprint"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fingerprint, err := requests.GetRequest("instance/instance1"); err != nil || fingerprint != "fingerprint" {
+		t.Errorf("expected fingerprint, got %q, %v", fingerprint, err)
+	}
+}
+
+func TestProvisionReplayAfterRestart(t *testing.T) {
+	store := state.NewMemoryStore()
+	details := domain.ProvisionDetails{ServiceID: "service1", PlanID: "plan1"}
+	b := newTestBroker(withRequests)
+	b.requests = stateRequestStore{records: stateRecords{store: store, kind: requestRecordKind}}
+	if _, err := b.Provision(context.Background(), "instance1", details, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// A broker started again with the same state recognizes the replay.
+	b = newTestBroker(withRequests)
+	b.requests = stateRequestStore{records: stateRecords{store: store, kind: requestRecordKind}}
+	spec, err := b.Provision(context.Background(), "instance1", details, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !spec.AlreadyExists {
+		t.Errorf("expected the replay to report that the instance exists, got %+v", spec)
+	}
+}
+
+func TestStoppedDeprovisionResumes(t *testing.T) {
+	testCases := map[string]struct {
+		updatedAt         time.Time
+		expectDescription string
+	}{
+		"running": {
+			updatedAt:         time.Now(),
+			expectDescription: "deleted 5 of 10 objects",
+		},
+		"stopped": {
+			updatedAt:         time.Now().Add(-time.Hour),
+			expectDescription: "resuming deletion of the bucket's objects",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withDeletablePlan)
+			b.deprovisions = stateDeprovisionStore{records: stateRecords{store: state.NewMemoryStore(), kind: deprovisionRecordKind}}
+			b.deprovisions.SaveDeprovision(Deprovision{InstanceID: "instance1", State: domain.InProgress, Deleted: 5, Total: 10, UpdatedAt: tc.updatedAt})
+
+			lastOperation, err := b.LastOperation(context.Background(), "instance1", domain.PollDetails{OperationData: operationDeprovision})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if lastOperation.State != domain.InProgress || lastOperation.Description != tc.expectDescription {
+				t.Errorf("expected in progress %q, got %+v", tc.expectDescription, lastOperation)
+			}
+		})
+	}
+}
+
+func TestLastOperation(t *testing.T) {
+	operations := []Operation{
+		{InstanceID: "instance1", Action: "provision"},
+		{InstanceID: "instance1", BindingID: "binding1", Action: "bind", Error: "access denied"},
+		{InstanceID: "instance1", Action: "update"},
+	}
+
+	testCases := map[string]struct {
+		bindingID       string
+		instanceID      string
+		expectOperation domain.LastOperation
+		expectErr       error
+	}{
+		"instance": {
+			instanceID:      "instance1",
+			expectOperation: domain.LastOperation{State: domain.Succeeded, Description: "update succeeded"},
+		},
+		"binding": {
+			instanceID:      "instance1",
+			bindingID:       "binding1",
+			expectOperation: domain.LastOperation{State: domain.Failed, Description: "bind failed: access denied"},
+		},
+		"unknown instance": {
+			instanceID: "instance2",
+			expectErr:  apiresponses.ErrInstanceDoesNotExist,
+		},
+		"unknown binding": {
+			instanceID: "instance1",
+			bindingID:  "binding2",
+			expectErr:  apiresponses.ErrBindingDoesNotExist,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{
+				logger:     lager.NewLogger("broker-unit-test-state"),
+				operations: stateOperationStore{store: state.NewMemoryStore()},
+			}
+			for _, operation := range operations {
+				if err := b.operations.RecordOperation(operation); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			var lastOperation domain.LastOperation
+			var err error
+			if tc.bindingID != "" {
+				lastOperation, err = b.LastBindingOperation(context.Background(), tc.instanceID, tc.bindingID, domain.PollDetails{})
+			} else {
+				lastOperation, err = b.LastOperation(context.Background(), tc.instanceID, domain.PollDetails{})
+			}
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(tc.expectOperation, lastOperation); diff != "" {
+				t.Errorf("unexpected last operation (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMergeParameters(t *testing.T) {
+	testCases := map[string]struct {
+		previous string
+		updated  string
+		expect   string
+	}{
+		"no previous parameters": {
+			updated: `{"a":1}`,
+			expect:  `{"a":1}`,
+		},
+		"no updated parameters": {
+			previous: `{"a":1}`,
+			expect:   `{"a":1}`,
+		},
+		"replaces and adds keys": {
+			previous: `{"a":1,"b":2}`,
+			updated:  `{"b":3,"c":4}`,
+			expect:   `{"a":1,"b":3,"c":4}`,
+		},
+		"null previous parameters": {
+			previous: `null`,
+			updated:  `{"a":1}`,
+			expect:   `{"a":1}`,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var previous, updated json.RawMessage
+			if tc.previous != "" {
+				previous = json.RawMessage(tc.previous)
+			}
+			if tc.updated != "" {
+				updated = json.RawMessage(tc.updated)
+			}
+			merged, err := mergeParameters(previous, updated)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(merged) != tc.expect {
+				t.Errorf("expected %s, got %s", tc.expect, merged)
+			}
+		})
+	}
+}
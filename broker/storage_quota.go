@@ -0,0 +1,67 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+// storageQuotaSid is the Sid of the bucket policy statement that denies
+// uploads to a bucket over its plan's storage quota. Plan changes keep it,
+// since plan policies do not use it.
+const storageQuotaSid = "StorageQuota"
+
+// storageQuotaStatement denies everyone uploads to bucketName, including
+// copies into it and the parts of multipart uploads. Reads and deletes are
+// still allowed, so the bucket can be brought back under its quota.
+func (b *S3Broker) storageQuotaStatement(bucketName string) awss3.PolicyStatement {
+	bucketARN := fmt.Sprintf("arn:%s:s3:::%s", b.awsPartition, bucketName)
+	return awss3.PolicyStatement{
+		Sid:       storageQuotaSid,
+		Effect:    "Deny",
+		Principal: map[string]string{"AWS": "*"},
+		Action:    []string{"s3:PutObject"},
+		Resource:  []string{bucketARN + "/*"},
+	}
+}
+
+// enforceStorageQuota denies uploads to an instance's bucket if usage exceeds
+// its plan's storage quota, and allows them again if usage is back under it.
+// A truncated count that is under the quota says nothing of the bucket's
+// real size, so it leaves the bucket as it is.
+func (b *S3Broker) enforceStorageQuota(ctx context.Context, servicePlan ServicePlan, bucketName string, usage state.Usage) error {
+	quotaGB := servicePlan.S3Properties.StorageQuotaGB
+	if quotaGB <= 0 {
+		return nil
+	}
+	data := lager.Data{instanceIDLogKey: usage.InstanceID, "bucket": bucketName, "bytes": usage.Bytes, "quota-gb": quotaGB}
+	if float64(usage.Bytes) > quotaGB*bytesPerGB {
+		b.logger.Info("storage-quota-exceeded", data)
+		return mapBucketError(b.bucket.AddPolicyStatements(ctx, bucketName, []awss3.PolicyStatement{b.storageQuotaStatement(bucketName)}))
+	}
+	if usage.Truncated {
+		return nil
+	}
+	return b.clearStorageQuota(ctx, bucketName)
+}
+
+// clearStorageQuota removes the statement that denies uploads to bucketName,
+// if it has one.
+func (b *S3Broker) clearStorageQuota(ctx context.Context, bucketName string) error {
+	return mapBucketError(b.bucket.RemovePolicyStatements(ctx, bucketName, []string{storageQuotaSid}))
+}
+
+// clearDroppedStorageQuota allows uploads again to the bucket of an instance
+// updated from a plan with a storage quota to one without, which
+// measurements no longer check.
+func (b *S3Broker) clearDroppedStorageQuota(ctx context.Context, instanceID, previousPlanID string, servicePlan ServicePlan) error {
+	previousPlan, ok := b.catalog.FindServicePlan(previousPlanID)
+	if !ok || previousPlan.S3Properties.StorageQuotaGB == 0 || servicePlan.S3Properties.StorageQuotaGB > 0 {
+		return nil
+	}
+	return b.clearStorageQuota(ctx, b.bucketName(instanceID))
+}
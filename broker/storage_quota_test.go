@@ -0,0 +1,154 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awsmetrics"
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+func TestEnforceStorageQuota(t *testing.T) {
+	testCases := map[string]struct {
+		quotaGB      float64
+		usage        state.Usage
+		denied       bool
+		expectDenied bool
+	}{
+		"no quota": {
+			usage: state.Usage{Bytes: 5 << 30},
+		},
+		"under quota": {
+			quotaGB: 5,
+			usage:   state.Usage{Bytes: 5 << 30},
+		},
+		"over quota": {
+			quotaGB:      5,
+			usage:        state.Usage{Bytes: 5<<30 + 1},
+			expectDenied: true,
+		},
+		"back under quota": {
+			quotaGB: 5,
+			usage:   state.Usage{Bytes: 4 << 30},
+			denied:  true,
+		},
+		"truncated count under quota": {
+			quotaGB:      5,
+			usage:        state.Usage{Bytes: 4 << 30, Truncated: true},
+			denied:       true,
+			expectDenied: true,
+		},
+		"truncated count over quota": {
+			quotaGB:      0.5,
+			usage:        state.Usage{Bytes: 1 << 30, Truncated: true},
+			expectDenied: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := &mockBucket{}
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-storage-quota"),
+				bucket:       bucket,
+				awsPartition: "aws",
+			}
+			if tc.denied {
+				bucket.AddPolicyStatements(context.Background(), "bucket1", []awss3.PolicyStatement{b.storageQuotaStatement("bucket1")})
+			}
+
+			servicePlan := ServicePlan{S3Properties: S3Properties{StorageQuotaGB: tc.quotaGB}}
+			if err := b.enforceStorageQuota(context.Background(), servicePlan, "bucket1", tc.usage); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			statement, denied := bucket.policyStatements["bucket1"][storageQuotaSid]
+			if denied != tc.expectDenied {
+				t.Fatalf("expected uploads denied %t, got %t", tc.expectDenied, denied)
+			}
+			if denied {
+				expect := awss3.PolicyStatement{
+					Sid:       storageQuotaSid,
+					Effect:    "Deny",
+					Principal: map[string]string{"AWS": "*"},
+					Action:    []string{"s3:PutObject"},
+					Resource:  []string{"arn:aws:s3:::bucket1/*"},
+				}
+				if diff := cmp.Diff(expect, statement); diff != "" {
+					t.Errorf("unexpected statement (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectUsageEnforcesStorageQuota(t *testing.T) {
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+	for _, instance := range []state.Instance{
+		{InstanceID: "instance1", PlanID: "small", BucketName: "prefix-instance1"},
+		{InstanceID: "instance2", PlanID: "large", BucketName: "prefix-instance2"},
+	} {
+		if err := store.SaveInstance(ctx, instance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bucket := &mockBucket{}
+	b := &S3Broker{
+		logger: lager.NewLogger("broker-unit-test-storage-quota"),
+		catalog: &mockCatalog{plans: map[string]ServicePlan{
+			"small": {ID: "small", S3Properties: S3Properties{StorageQuotaGB: 1}},
+			"large": {ID: "large", S3Properties: S3Properties{StorageQuotaGB: 100}},
+		}},
+		bucket:       bucket,
+		awsPartition: "aws",
+		state:        store,
+		usage:        UsageConfig{Interval: time.Hour},
+		storage: &mockStorageReader{storage: map[string]awsmetrics.BucketStorage{
+			"prefix-instance1": {Bytes: 2 << 30, Time: time.Now()},
+			"prefix-instance2": {Bytes: 2 << 30, Time: time.Now()},
+		}},
+	}
+
+	if err := b.CollectUsage(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, denied := bucket.policyStatements["prefix-instance1"][storageQuotaSid]; !denied {
+		t.Error("expected uploads to the bucket over its quota to be denied")
+	}
+	if _, denied := bucket.policyStatements["prefix-instance2"][storageQuotaSid]; denied {
+		t.Error("expected uploads to the bucket under its quota to be allowed")
+	}
+}
+
+func TestUpdateClearsDroppedStorageQuota(t *testing.T) {
+	bucket := &mockBucket{}
+	b := &S3Broker{
+		logger:       lager.NewLogger("broker-unit-test-storage-quota"),
+		bucketPrefix: "prefix",
+		bucket:       bucket,
+		awsPartition: "aws",
+		catalog: &mockCatalog{serviceName: "service1", plans: map[string]ServicePlan{
+			"small":     {ID: "small", Name: "small", S3Properties: S3Properties{StorageQuotaGB: 1}},
+			"unlimited": {ID: "unlimited", Name: "unlimited"},
+		}},
+		tagManager: &mockTagGenerator{},
+	}
+	bucket.AddPolicyStatements(context.Background(), "prefix-instance1", []awss3.PolicyStatement{b.storageQuotaStatement("prefix-instance1")})
+
+	_, err := b.Update(context.Background(), "instance1", domain.UpdateDetails{
+		ServiceID:      "service1",
+		PlanID:         "unlimited",
+		PreviousValues: domain.PreviousValues{PlanID: "small"},
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, denied := bucket.policyStatements["prefix-instance1"][storageQuotaSid]; denied {
+		t.Error("expected updating to a plan without a quota to allow uploads again")
+	}
+}
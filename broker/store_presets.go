@@ -0,0 +1,165 @@
+package broker
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// Features that S3-compatible stores may lack. AWS S3 has all of them.
+const (
+	StoreFeatureTagging         = "tagging"
+	StoreFeatureEncryption      = "encryption"
+	StoreFeatureVersioning      = "versioning"
+	StoreFeatureCORS            = "cors"
+	StoreFeatureLifecycle       = "lifecycle"
+	StoreFeatureBucketPolicy    = "bucket_policy"
+	StoreFeatureObjectOwnership = "object_ownership"
+	// StoreFeatureIAM is an IAM API at the store's endpoint, which the
+	// broker creates binding credentials through.
+	StoreFeatureIAM = "iam"
+)
+
+var storeFeatures = []string{
+	StoreFeatureTagging,
+	StoreFeatureEncryption,
+	StoreFeatureVersioning,
+	StoreFeatureCORS,
+	StoreFeatureLifecycle,
+	StoreFeatureBucketPolicy,
+	StoreFeatureObjectOwnership,
+	StoreFeatureIAM,
+}
+
+// paramFeatures are the features that provision and update parameters
+// configure.
+var paramFeatures = map[string]string{
+	"object_ownership": StoreFeatureObjectOwnership,
+	"cors_rules":       StoreFeatureCORS,
+	"lifecycle_rules":  StoreFeatureLifecycle,
+	"tags":             StoreFeatureTagging,
+	"annotations":      StoreFeatureTagging,
+}
+
+// StorePreset holds the settings that an S3-compatible provider needs, so
+// that operators can name the provider instead of working them out.
+type StorePreset struct {
+	// Endpoint is the provider's S3 endpoint, in which {region} is replaced
+	// with the broker's region.
+	Endpoint  string
+	PathStyle bool
+	Signature SignatureConfig
+	// Features are the features of storeFeatures that the provider has.
+	Features []string
+}
+
+// storePresets are the presets that the preset option can name.
+var storePresets = map[string]StorePreset{
+	"backblaze-b2": {
+		Endpoint:  "https://s3.{region}.backblazeb2.com",
+		Signature: SignatureConfig{Checksums: ChecksumsWhenRequired},
+		Features:  []string{StoreFeatureEncryption, StoreFeatureCORS},
+	},
+	"digitalocean-spaces": {
+		Endpoint:  "https://{region}.digitaloceanspaces.com",
+		Signature: SignatureConfig{Checksums: ChecksumsWhenRequired},
+		Features:  []string{StoreFeatureVersioning, StoreFeatureCORS, StoreFeatureLifecycle, StoreFeatureBucketPolicy},
+	},
+}
+
+var ErrBindingsNotSupported = apiresponses.NewFailureResponse(
+	errors.New("The store has no IAM API to create binding credentials with."),
+	http.StatusUnprocessableEntity,
+	"bindings-not-supported",
+)
+
+// ApplyPreset fills in the endpoint, addressing style and signature settings
+// of the store preset that the config names. Settings that the config has
+// are kept. Unknown presets are left for Validate to reject.
+func (c *Config) ApplyPreset() {
+	preset, ok := storePresets[c.Preset]
+	if !ok {
+		return
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = strings.ReplaceAll(preset.Endpoint, "{region}", c.Region)
+	}
+	c.PathStyle = c.PathStyle || preset.PathStyle
+	c.Signature.UnsignedPayload = c.Signature.UnsignedPayload || preset.Signature.UnsignedPayload
+	c.Signature.Checksums = cmp.Or(c.Signature.Checksums, preset.Signature.Checksums)
+}
+
+// UnsupportedFeatures returns the features of storeFeatures that the config's
+// store preset lacks, which are none without a preset.
+func (c Config) UnsupportedFeatures() []string {
+	preset, ok := storePresets[c.Preset]
+	if !ok {
+		return nil
+	}
+	return slices.DeleteFunc(slices.Clone(storeFeatures), func(feature string) bool {
+		return slices.Contains(preset.Features, feature)
+	})
+}
+
+// validatePreset checks that the store preset is known, and that plans do
+// not ask for features the store lacks.
+func (c Config) validatePreset() error {
+	if c.Preset == "" {
+		return nil
+	}
+	if _, ok := storePresets[c.Preset]; !ok {
+		return fmt.Errorf("Preset must be one of %s, got %q", strings.Join(slices.Sorted(maps.Keys(storePresets)), ", "), c.Preset)
+	}
+	if len(c.Regions) > 0 {
+		return fmt.Errorf("Regions cannot be used with preset %s, whose endpoint is in the broker's region", c.Preset)
+	}
+	unsupported := c.UnsupportedFeatures()
+	for _, plan := range c.Catalog.ListServicePlans() {
+		properties := plan.S3Properties
+		if properties.ObjectStore != "" {
+			continue
+		}
+		uses := []struct {
+			feature string
+			set     bool
+		}{
+			{StoreFeatureEncryption, properties.Encryption != ""},
+			{StoreFeatureVersioning, properties.Versioning},
+			{StoreFeatureBucketPolicy, properties.BucketPolicy != "" || properties.StorageQuotaGB > 0},
+		}
+		for _, use := range uses {
+			if use.set && slices.Contains(unsupported, use.feature) {
+				return fmt.Errorf("Plan %s uses %s, which preset %s does not support", plan.Name, use.feature, c.Preset)
+			}
+		}
+	}
+	return nil
+}
+
+// withStoreFeatures leaves out of properties the parameters that configure
+// features the store lacks. Plans in other object stores are not in the
+// store, so keep theirs.
+func (b *S3Broker) withStoreFeatures(servicePlan ServicePlan, properties map[string]*ParameterSchema) map[string]*ParameterSchema {
+	if servicePlan.S3Properties.ObjectStore != "" {
+		return properties
+	}
+	maps.DeleteFunc(properties, func(name string, _ *ParameterSchema) bool {
+		return slices.Contains(b.unsupportedFeatures, paramFeatures[name])
+	})
+	return properties
+}
+
+// checkBindingsSupported rejects bindings to buckets in stores without an IAM
+// API.
+func (b *S3Broker) checkBindingsSupported() error {
+	if slices.Contains(b.unsupportedFeatures, StoreFeatureIAM) {
+		return ErrBindingsNotSupported
+	}
+	return nil
+}
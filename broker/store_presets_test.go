@@ -0,0 +1,135 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func TestApplyPreset(t *testing.T) {
+	testCases := map[string]struct {
+		config Config
+		expect Config
+	}{
+		"no preset": {
+			config: Config{Region: "us-east-1"},
+			expect: Config{Region: "us-east-1"},
+		},
+		"backblaze-b2": {
+			config: Config{Region: "us-west-004", Preset: "backblaze-b2"},
+			expect: Config{
+				Region:    "us-west-004",
+				Preset:    "backblaze-b2",
+				Endpoint:  "https://s3.us-west-004.backblazeb2.com",
+				Signature: SignatureConfig{Checksums: ChecksumsWhenRequired},
+			},
+		},
+		"keeps the config's settings": {
+			config: Config{
+				Region:    "nyc3",
+				Preset:    "digitalocean-spaces",
+				Endpoint:  "https://spaces.example.com",
+				PathStyle: true,
+				Signature: SignatureConfig{UnsignedPayload: true, Checksums: ChecksumsWhenSupported},
+			},
+			expect: Config{
+				Region:    "nyc3",
+				Preset:    "digitalocean-spaces",
+				Endpoint:  "https://spaces.example.com",
+				PathStyle: true,
+				Signature: SignatureConfig{UnsignedPayload: true, Checksums: ChecksumsWhenSupported},
+			},
+		},
+		"unknown preset": {
+			config: Config{Region: "us-east-1", Preset: "unknown"},
+			expect: Config{Region: "us-east-1", Preset: "unknown"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tc.config.ApplyPreset()
+			if diff := cmp.Diff(tc.expect, tc.config); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnsupportedFeatures(t *testing.T) {
+	testCases := map[string]struct {
+		preset string
+		expect []string
+	}{
+		"no preset": {},
+		"backblaze-b2": {
+			preset: "backblaze-b2",
+			expect: []string{StoreFeatureTagging, StoreFeatureVersioning, StoreFeatureLifecycle, StoreFeatureBucketPolicy, StoreFeatureObjectOwnership, StoreFeatureIAM},
+		},
+		"digitalocean-spaces": {
+			preset: "digitalocean-spaces",
+			expect: []string{StoreFeatureTagging, StoreFeatureEncryption, StoreFeatureObjectOwnership, StoreFeatureIAM},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.expect, Config{Preset: tc.preset}.UnsupportedFeatures()); diff != "" {
+				t.Errorf("unexpected features (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStoreFeatureSchemas(t *testing.T) {
+	b := &S3Broker{
+		allowUserProvisionParameters: true,
+		allowUserUpdateParameters:    true,
+		unsupportedFeatures:          Config{Preset: "digitalocean-spaces"}.UnsupportedFeatures(),
+	}
+	testCases := map[string]struct {
+		schema *ParameterSchema
+		expect []string
+	}{
+		"provision": {
+			schema: b.provisionSchema(ServicePlan{}),
+			expect: []string{"cors_rules", "lifecycle_rules", "preserve_on_delete"},
+		},
+		"update": {
+			schema: b.updateSchema(ServicePlan{}),
+			expect: []string{"apply_immediately", "cors_rules", "lifecycle_rules", "preserve_on_delete"},
+		},
+		"plan in another object store": {
+			schema: b.provisionSchema(ServicePlan{S3Properties: S3Properties{ObjectStore: ObjectStoreGCS}}),
+			expect: []string{"annotations", "tags"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			properties := slices.Sorted(maps.Keys(tc.schema.Properties))
+			if diff := cmp.Diff(tc.expect, properties); diff != "" {
+				t.Errorf("unexpected properties (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBindWithoutIAM(t *testing.T) {
+	b := &S3Broker{
+		logger:              lager.NewLogger("broker-unit-test-store-presets"),
+		catalog:             &mockCatalog{planName: "plan1"},
+		unsupportedFeatures: Config{Preset: "backblaze-b2"}.UnsupportedFeatures(),
+	}
+	_, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+		PlanID:        "plan1",
+		RawParameters: json.RawMessage(`{}`),
+	}, false)
+	if !errors.Is(err, ErrBindingsNotSupported) {
+		t.Errorf("expected ErrBindingsNotSupported, got %v", err)
+	}
+}
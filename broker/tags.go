@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awsiam"
+)
+
+// BindingGUIDTagKey tags the IAM user or role of a binding with the binding
+// it belongs to, so orphaned principals can be traced back to it.
+const BindingGUIDTagKey = "Binding GUID"
+
+// AppGUIDTagKey tags the temporary credentials of an app binding with the
+// app they were issued to.
+const AppGUIDTagKey = "App GUID"
+
+// bindingTags returns the tags for a binding's IAM user or role: the broker,
+// environment, service, plan, instance, organization and space tags that
+// buckets get, plus the binding GUID and the user who created the binding.
+func (b *S3Broker) bindingTags(
+	ctx context.Context,
+	service Service,
+	servicePlan ServicePlan,
+	instanceID, bindingID string,
+	rawContext json.RawMessage,
+) ([]*iam.Tag, error) {
+	bindContext, err := parseBindContext(rawContext)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := b.tagManager.GenerateTags(
+		brokertags.Create,
+		service.Name,
+		servicePlan.Name,
+		brokertags.ResourceGUIDs{
+			InstanceGUID:     instanceID,
+			SpaceGUID:        bindContext.SpaceGUID,
+			OrganizationGUID: bindContext.OrganizationGUID,
+		},
+		// Kubernetes has no spaces or organizations to look up.
+		!bindContext.isKubernetes(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tags = addPlatformTags(tags, bindContext)
+
+	iamTags := awsiam.ConvertTagsMapToIAMTags(tags)
+	iamTags = append(iamTags, &iam.Tag{
+		Key:   aws.String(BindingGUIDTagKey),
+		Value: aws.String(bindingID),
+	})
+	iamTags = append(iamTags, b.identityIAMTags(ctx)...)
+	return iamTags, nil
+}
+
+// sessionTags returns the STS session tags for a temporary binding, so that
+// CloudTrail attributes its requests to the organization, space and app, or
+// the Kubernetes cluster and namespace, instead of only the shared broker user or role. They are nil unless
+// session tags are enabled.
+func (b *S3Broker) sessionTags(instanceID, bindingID string, details domain.BindDetails) (map[string]string, error) {
+	if !b.temporarySessionTags {
+		return nil, nil
+	}
+	bindContext, err := parseBindContext(details.RawContext)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{
+		brokertags.ServiceInstanceGUIDTagKey: instanceID,
+		BindingGUIDTagKey:                    bindingID,
+	}
+	if bindContext.OrganizationGUID != "" {
+		tags[brokertags.OrganizationGUIDTagKey] = bindContext.OrganizationGUID
+	}
+	if bindContext.SpaceGUID != "" {
+		tags[brokertags.SpaceGUIDTagKey] = bindContext.SpaceGUID
+	}
+	if bindContext.isKubernetes() {
+		if bindContext.ClusterID != "" {
+			tags[KubernetesClusterIDTagKey] = bindContext.ClusterID
+		}
+		if bindContext.Namespace != "" {
+			tags[KubernetesNamespaceTagKey] = bindContext.Namespace
+		}
+	}
+	if appGUID := bindAppGUID(details); appGUID != "" {
+		tags[AppGUIDTagKey] = appGUID
+	}
+	return tags, nil
+}
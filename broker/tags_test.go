@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+func tagValue(tags []*iam.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+func TestBindTags(t *testing.T) {
+	testCases := map[string]struct {
+		parameters string
+		role       *mockRole
+		tagManager *mockTagGenerator
+		expectErr  string
+	}{
+		"user": {
+			parameters: `{}`,
+			tagManager: &mockTagGenerator{serviceName: "service1"},
+		},
+		"role": {
+			parameters: `{"credential_type": "role", "principal": "123456789012", "external_id": "ext-id"}`,
+			role:       &mockRole{},
+			tagManager: &mockTagGenerator{serviceName: "service1"},
+		},
+		"tags cannot be generated": {
+			parameters: `{}`,
+			tagManager: &mockTagGenerator{generateErr: NewTestErr("cf api error")},
+			expectErr:  "cf api error",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			user := &mockUser{}
+			b := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-tags"),
+				bucket:       &mockBucket{},
+				catalog:      &mockCatalog{planName: "plan1", serviceName: "service1"},
+				tagManager:   tc.tagManager,
+				user:         user,
+				awsPartition: "aws",
+			}
+			if tc.role != nil {
+				b.role = tc.role
+			}
+
+			_, err := b.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(tc.parameters),
+				RawContext:    json.RawMessage(`{"platform": "cloudfoundry", "organization_guid": "org1", "space_guid": "space1"}`),
+			}, false)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected err %s, got %v", tc.expectErr, err)
+				}
+				if user.exists {
+					t.Errorf("expected no user to be created")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			expectGUIDs := brokertags.ResourceGUIDs{InstanceGUID: "instance1", SpaceGUID: "space1", OrganizationGUID: "org1"}
+			if tc.tagManager.resourceGUIDs != expectGUIDs {
+				t.Errorf("expected resource GUIDs %+v, got %+v", expectGUIDs, tc.tagManager.resourceGUIDs)
+			}
+
+			tags := user.tags
+			if tc.role != nil {
+				tags = tc.role.tags
+			}
+			if value := tagValue(tags, BindingGUIDTagKey); value != "binding1" {
+				t.Errorf("expected %s tag binding1, got %q", BindingGUIDTagKey, value)
+			}
+			if value := tagValue(tags, "service name"); value != "service1" {
+				t.Errorf("expected service name tag service1, got %q", value)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awssts"
+)
+
+// CredentialType selects what kind of credentials a binding receives.
+type CredentialType string
+
+const (
+	CredentialTypeAccessKey    CredentialType = "access-key"
+	CredentialTypeTemporary    CredentialType = "temporary"
+	CredentialTypeRole         CredentialType = "role"
+	CredentialTypeWebIdentity  CredentialType = "web-identity"
+	CredentialTypeBucketPolicy CredentialType = "bucket-policy"
+	CredentialTypePresignedURL CredentialType = "presigned-url"
+)
+
+const defaultTemporaryCredentialsTTL = time.Hour
+
+var (
+	ErrTemporaryCredentialsDisabled = apiresponses.NewFailureResponse(
+		errors.New("This broker is not configured to issue temporary credentials. Contact your Cloud Foundry operator for details."),
+		http.StatusBadRequest,
+		"temporary-credentials-disabled",
+	)
+	ErrTemporaryBindingNotFound = errors.New("temporary binding not found")
+	ErrInvalidRefreshToken      = errors.New("invalid refresh token")
+)
+
+// TemporaryBinding is what the broker keeps about a binding with temporary
+// credentials so that the app can refresh them. Only a hash of the refresh
+// token is kept.
+type TemporaryBinding struct {
+	InstanceID       string
+	BindingID        string
+	Policy           string
+	SessionTags      map[string]string
+	RefreshTokenHash string
+}
+
+// TemporaryBindingStore persists temporary bindings between Bind, credential
+// refreshes and Unbind.
+type TemporaryBindingStore interface {
+	GetTemporaryBinding(bindingID string) (TemporaryBinding, error)
+	SaveTemporaryBinding(binding TemporaryBinding) error
+	DeleteTemporaryBinding(bindingID string) error
+}
+
+// MemoryTemporaryBindingStore keeps temporary bindings in process memory.
+// They are lost on restart, after which apps must be rebound to refresh.
+type MemoryTemporaryBindingStore struct {
+	mu       sync.Mutex
+	bindings map[string]TemporaryBinding
+}
+
+func NewMemoryTemporaryBindingStore() *MemoryTemporaryBindingStore {
+	return &MemoryTemporaryBindingStore{
+		bindings: make(map[string]TemporaryBinding),
+	}
+}
+
+func (m *MemoryTemporaryBindingStore) GetTemporaryBinding(bindingID string) (TemporaryBinding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	binding, ok := m.bindings[bindingID]
+	if !ok {
+		return TemporaryBinding{}, ErrTemporaryBindingNotFound
+	}
+	return binding, nil
+}
+
+func (m *MemoryTemporaryBindingStore) SaveTemporaryBinding(binding TemporaryBinding) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bindings[binding.BindingID] = binding
+	return nil
+}
+
+func (m *MemoryTemporaryBindingStore) DeleteTemporaryBinding(bindingID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bindings, bindingID)
+	return nil
+}
+
+// bindTemporary issues STS credentials scoped to the binding's policy instead
+// of creating an IAM user. If a refresh URL is configured, the binding also
+// gets a refresh token to exchange for new credentials before they expire.
+func (b *S3Broker) bindTemporary(
+	ctx context.Context,
+	instanceID, bindingID, iamPolicy string,
+	bucketARNs []string,
+	pathPrefix string,
+	sessionTags map[string]string,
+	credentials Credentials,
+) (domain.Binding, error) {
+	policy, err := awsiam.RenderPolicy(iamPolicy, bucketARNs, pathPrefix)
+	if err != nil {
+		return domain.Binding{}, err
+	}
+
+	temporaryCredentials, err := b.credentialIssuer.Issue(ctx, b.userName(bindingID), policy, sessionTags, b.temporaryCredentialsTTL)
+	if err != nil {
+		b.logger.Error("bind: error issuing temporary credentials", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+		})
+		return domain.Binding{}, err
+	}
+
+	if b.refreshURL != "" {
+		refreshToken, err := newRefreshToken()
+		if err != nil {
+			return domain.Binding{}, err
+		}
+		err = b.temporaryBindings.SaveTemporaryBinding(TemporaryBinding{
+			InstanceID:       instanceID,
+			BindingID:        bindingID,
+			Policy:           policy,
+			SessionTags:      sessionTags,
+			RefreshTokenHash: hashRefreshToken(refreshToken),
+		})
+		if err != nil {
+			return domain.Binding{}, err
+		}
+		credentials.RefreshURL = fmt.Sprintf("%s/bindings/%s/credentials", b.refreshURL, url.PathEscape(bindingID))
+		credentials.RefreshToken = refreshToken
+	}
+
+	credentials.AccessKeyID = temporaryCredentials.AccessKeyID
+	credentials.SecretAccessKey = temporaryCredentials.SecretAccessKey
+	credentials.SessionToken = temporaryCredentials.SessionToken
+	credentials.Expiration = temporaryCredentials.Expiration.UTC().Format(time.RFC3339)
+	credentials.URI = b.GetBucketURI(credentials)
+
+	return domain.Binding{Credentials: credentials}, nil
+}
+
+// RefreshTemporaryCredentials issues new credentials for a temporary binding
+// after checking the refresh token handed out by Bind.
+func (b *S3Broker) RefreshTemporaryCredentials(ctx context.Context, bindingID, refreshToken string) (awssts.Credentials, error) {
+	if b.credentialIssuer == nil {
+		return awssts.Credentials{}, ErrTemporaryCredentialsDisabled
+	}
+
+	binding, err := b.temporaryBindings.GetTemporaryBinding(bindingID)
+	if errors.Is(err, ErrTemporaryBindingNotFound) {
+		return awssts.Credentials{}, ErrInvalidRefreshToken
+	} else if err != nil {
+		return awssts.Credentials{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(binding.RefreshTokenHash), []byte(hashRefreshToken(refreshToken))) != 1 {
+		return awssts.Credentials{}, ErrInvalidRefreshToken
+	}
+
+	return b.credentialIssuer.Issue(ctx, b.userName(bindingID), binding.Policy, binding.SessionTags, b.temporaryCredentialsTTL)
+}
+
+type refreshResponse struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+	Expiration      string `json:"expiration"`
+}
+
+// ServeRefresh handles POST /bindings/{binding_id}/credentials. Apps send the
+// refresh token from their binding as a bearer token.
+func (b *S3Broker) ServeRefresh(w http.ResponseWriter, r *http.Request) {
+	bindingID := r.PathValue("binding_id")
+	logger := b.logger.Session("refresh-credentials", lager.Data{bindingIDLogKey: bindingID})
+
+	refreshToken, ok := bearerToken(r)
+	if !ok {
+		http.Error(w, ErrInvalidRefreshToken.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := b.RefreshTemporaryCredentials(r.Context(), bindingID, refreshToken)
+	switch {
+	case errors.Is(err, ErrInvalidRefreshToken):
+		logger.Info("rejected")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, ErrTemporaryCredentialsDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Error("issue-credentials", err)
+		http.Error(w, "could not issue credentials", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(refreshResponse{
+		AccessKeyID:     credentials.AccessKeyID,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.SessionToken,
+		Expiration:      credentials.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || header[:len(prefix)] != prefix {
+		return "", false
+	}
+	return header[len(prefix):], true
+}
+
+func newRefreshToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(hash[:])
+}
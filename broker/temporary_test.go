@@ -0,0 +1,241 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+
+	"github.com/cloud-gov/s3-broker/awssts"
+)
+
+type mockCredentialIssuer struct {
+	issued   int
+	policies []string
+	tags     []map[string]string
+	err      error
+}
+
+func (m *mockCredentialIssuer) Issue(ctx context.Context, name, policy string, tags map[string]string, ttl time.Duration) (awssts.Credentials, error) {
+	if m.err != nil {
+		return awssts.Credentials{}, m.err
+	}
+	m.issued++
+	m.policies = append(m.policies, policy)
+	m.tags = append(m.tags, tags)
+	return awssts.Credentials{
+		AccessKeyID:     "ASIAEXAMPLE",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func withTemporaryCredentials(issuer awssts.CredentialIssuer) func(*S3Broker) {
+	return func(b *S3Broker) {
+		b.credentialIssuer = issuer
+		b.temporaryBindings = NewMemoryTemporaryBindingStore()
+		b.temporaryCredentialsTTL = time.Hour
+		b.refreshURL = "https://broker.example.com"
+	}
+}
+
+var temporaryBindDetails = domain.BindDetails{
+	PlanID:        "planid1",
+	ServiceID:     "serviceid1",
+	RawParameters: json.RawMessage(`{"credential_type": "temporary"}`),
+}
+
+func TestBindTemporary(t *testing.T) {
+	testCases := map[string]struct {
+		issuer    awssts.CredentialIssuer
+		details   domain.BindDetails
+		expectErr error
+	}{
+		"disabled": {
+			details:   temporaryBindDetails,
+			expectErr: ErrTemporaryCredentialsDisabled,
+		},
+		"unknown credential type": {
+			issuer: &mockCredentialIssuer{},
+			details: domain.BindDetails{
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(`{"credential_type": "password"}`),
+			},
+			expectErr: NewTestErr("Invalid parameters: credential_type: must be one of access-key, temporary, role, web-identity, bucket-policy, presigned-url"),
+		},
+		"issue error": {
+			issuer:    &mockCredentialIssuer{err: NewTestErr("sts unavailable")},
+			details:   temporaryBindDetails,
+			expectErr: NewTestErr("sts unavailable"),
+		},
+		"success": {
+			issuer:  &mockCredentialIssuer{},
+			details: temporaryBindDetails,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBroker(withTemporaryCredentials(tc.issuer))
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", tc.details, false)
+			if tc.expectErr != nil {
+				if !errors.Is(tc.expectErr, err) && !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			credentials := binding.Credentials.(Credentials)
+			if credentials.SessionToken != "token" || credentials.Expiration != "2030-01-01T00:00:00Z" {
+				t.Errorf("expected temporary credentials, got %+v", credentials)
+			}
+			if credentials.RefreshURL != "https://broker.example.com/bindings/binding1/credentials" || credentials.RefreshToken == "" {
+				t.Errorf("expected refresh url and token, got %+v", credentials)
+			}
+			if user := b.user.(*mockUser); user.exists || user.accessKeys != nil {
+				t.Errorf("expected no IAM user or access key")
+			}
+			stored, err := b.temporaryBindings.GetTemporaryBinding("binding1")
+			if err != nil {
+				t.Fatalf("expected the binding to be stored: %s", err)
+			}
+			if stored.RefreshTokenHash == credentials.RefreshToken {
+				t.Errorf("refresh token must not be stored in plain text")
+			}
+		})
+	}
+}
+
+func TestServeRefresh(t *testing.T) {
+	issuer := &mockCredentialIssuer{}
+	b := newTestBroker(withTemporaryCredentials(issuer))
+	binding, err := b.Bind(context.Background(), "instance1", "binding1", temporaryBindDetails, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshToken := binding.Credentials.(Credentials).RefreshToken
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /bindings/{binding_id}/credentials", b.ServeRefresh)
+
+	refresh := func(bindingID, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/bindings/"+bindingID+"/credentials", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	testCases := map[string]struct {
+		bindingID     string
+		authorization string
+		expectStatus  int
+	}{
+		"valid token": {
+			bindingID:     "binding1",
+			authorization: "Bearer " + refreshToken,
+			expectStatus:  http.StatusOK,
+		},
+		"missing token": {
+			bindingID:    "binding1",
+			expectStatus: http.StatusUnauthorized,
+		},
+		"wrong token": {
+			bindingID:     "binding1",
+			authorization: "Bearer not-the-token",
+			expectStatus:  http.StatusUnauthorized,
+		},
+		"unknown binding": {
+			bindingID:     "binding2",
+			authorization: "Bearer " + refreshToken,
+			expectStatus:  http.StatusUnauthorized,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rec := refresh(tc.bindingID, tc.authorization)
+			if rec.Code != tc.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectStatus, rec.Code, rec.Body)
+			}
+			if tc.expectStatus != http.StatusOK {
+				return
+			}
+			var response refreshResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.SessionToken != "token" || response.Expiration != "2030-01-01T00:00:00Z" {
+				t.Errorf("unexpected response %+v", response)
+			}
+		})
+	}
+
+	if issuer.policies[1] != issuer.policies[0] {
+		t.Errorf("expected refreshed credentials to use the binding's policy")
+	}
+
+	if _, err := b.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if rec := refresh("binding1", "Bearer "+refreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected refresh to be rejected after unbind, got %d", rec.Code)
+	}
+}
+
+func TestBindTemporarySessionTags(t *testing.T) {
+	details := domain.BindDetails{
+		PlanID:        "planid1",
+		ServiceID:     "serviceid1",
+		BindResource:  &domain.BindResource{AppGuid: "app1"},
+		RawParameters: json.RawMessage(`{"credential_type": "temporary"}`),
+		RawContext:    json.RawMessage(`{"platform": "cloudfoundry", "organization_guid": "org1", "space_guid": "space1"}`),
+	}
+	testCases := map[string]struct {
+		enabled    bool
+		expectTags map[string]string
+	}{
+		"disabled": {},
+		"enabled": {
+			enabled: true,
+			expectTags: map[string]string{
+				"Organization GUID": "org1",
+				"Space GUID":        "space1",
+				"Instance GUID":     "instance1",
+				BindingGUIDTagKey:   "binding1",
+				AppGUIDTagKey:       "app1",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			issuer := &mockCredentialIssuer{}
+			b := newTestBroker(withTemporaryCredentials(issuer))
+			b.temporarySessionTags = tc.enabled
+
+			binding, err := b.Bind(context.Background(), "instance1", "binding1", details, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := b.RefreshTemporaryCredentials(context.Background(), "binding1", binding.Credentials.(Credentials).RefreshToken); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for i, tags := range issuer.tags {
+				if !maps.Equal(tags, tc.expectTags) {
+					t.Errorf("expected session tags %v on issue %d, got %v", tc.expectTags, i, tags)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+// Terraform resource types of the resources the broker manages.
+const (
+	terraformBucket               = "aws_s3_bucket"
+	terraformUser                 = "aws_iam_user"
+	terraformUserPolicy           = "aws_iam_user_policy"
+	terraformUserPolicyAttachment = "aws_iam_user_policy_attachment"
+	terraformPolicy               = "aws_iam_policy"
+	terraformRole                 = "aws_iam_role"
+	terraformRolePolicy           = "aws_iam_role_policy"
+	terraformRolePolicyAttachment = "aws_iam_role_policy_attachment"
+	terraformGroup                = "aws_iam_group"
+	terraformGroupPolicy          = "aws_iam_group_policy"
+)
+
+// ManagedResource is a bucket or IAM resource that the broker manages, as
+// Terraform imports it.
+type ManagedResource struct {
+	// Account is the account of the resource, if it is not the broker's
+	// own.
+	Account string `json:"account,omitempty"`
+	// Type is the Terraform resource type, such as aws_s3_bucket.
+	Type string `json:"type"`
+	// Name is the name of the resource in AWS, from which its Terraform
+	// address is made.
+	Name string `json:"name"`
+	// ID is the ID that Terraform imports the resource by.
+	ID         string `json:"id"`
+	InstanceID string `json:"instance_id,omitempty"`
+	BindingID  string `json:"binding_id,omitempty"`
+}
+
+// ManagedResources returns the buckets, IAM users, roles and groups that the
+// broker manages in each of its accounts, with their inline policies, policy
+// attachments and the managed policies it created for bindings. Buckets are
+// those of instances in the state store and those tagged as instances'.
+// Roles are only known from the state store. Resources that cannot be
+// listed are logged and skipped.
+func (b *S3Broker) ManagedResources(ctx context.Context) ([]ManagedResource, error) {
+	logger := b.logger.Session("managed-resources")
+
+	var instances []state.Instance
+	var bindings []state.Binding
+	if b.state != nil {
+		var err error
+		if instances, err = b.state.ListInstances(ctx); err != nil {
+			logger.Error("list-instances", err)
+			return nil, err
+		}
+		if bindings, err = b.state.ListBindings(ctx); err != nil {
+			logger.Error("list-bindings", err)
+			return nil, err
+		}
+	}
+
+	var resources []ManagedResource
+	for _, accountBroker := range b.accountBrokers() {
+		found, err := accountBroker.accountResources(ctx, logger, instances, bindings)
+		resources = append(resources, found...)
+		if err != nil {
+			return resources, err
+		}
+	}
+	return resources, nil
+}
+
+// accountResources returns the resources that the broker manages in its
+// account.
+func (b *S3Broker) accountResources(ctx context.Context, logger lager.Logger, instances []state.Instance, bindings []state.Binding) ([]ManagedResource, error) {
+	var resources []ManagedResource
+	add := func(resource ManagedResource) {
+		resource.Account = b.account
+		resources = append(resources, resource)
+	}
+
+	// bucketInstances maps bucket names to the IDs of their instances.
+	bucketInstances := make(map[string]string)
+	for _, instance := range instances {
+		if b.planAccount(instance.PlanID) != b.account || b.planObjectStore(instance.PlanID) != "" {
+			continue
+		}
+		bucketName := instance.BucketName
+		if bucketName == "" {
+			bucketName = b.bucketName(instance.InstanceID)
+		}
+		bucketInstances[bucketName] = instance.InstanceID
+	}
+	buckets, err := b.bucket.FindBuckets(ctx, b.instanceBucketTagFilter())
+	switch {
+	case errors.Is(err, awss3.ErrCountingDisabled):
+		logger.Debug("tagged-buckets-skipped")
+	case err != nil:
+		logger.Error("find-buckets", err)
+	default:
+		for bucketName, tags := range buckets {
+			if _, ok := bucketInstances[bucketName]; !ok {
+				bucketInstances[bucketName] = tags[brokertags.ServiceInstanceGUIDTagKey]
+			}
+		}
+	}
+	for _, bucketName := range slices.Sorted(maps.Keys(bucketInstances)) {
+		add(ManagedResource{Type: terraformBucket, Name: bucketName, ID: bucketName, InstanceID: bucketInstances[bucketName]})
+	}
+
+	// userBindings and roleBindings map the names of bindings' users and
+	// roles to their bindings.
+	userBindings := make(map[string]state.Binding)
+	roleBindings := make(map[string]state.Binding)
+	for _, binding := range bindings {
+		var stored StoredBinding
+		if err := json.Unmarshal(binding.Data, &stored); err != nil {
+			logger.Error("decode-binding", err, lager.Data{bindingIDLogKey: binding.BindingID})
+			continue
+		}
+		if stored.Account != b.account || stored.ObjectStore != "" {
+			continue
+		}
+		switch {
+		case isUserBinding(stored.Credentials):
+			userBindings[b.userName(binding.BindingID)] = binding
+		case stored.Credentials.RoleARN != "":
+			roleName := stored.Credentials.RoleARN[strings.LastIndex(stored.Credentials.RoleARN, "/")+1:]
+			roleBindings[roleName] = binding
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return resources, err
+	}
+	userNames, err := b.user.ListUsers(pathPrefix(b.iamPath))
+	if err != nil {
+		logger.Error("list-users", err)
+		userNames = nil
+	}
+	isBindingUser := nameMatcher(b.userNameTemplate, b.userPrefix)
+	isBindingPolicy := nameMatcher(b.policyNameTemplate, b.policyPrefix)
+	for _, userName := range userNames {
+		if !isBindingUser(userName) {
+			continue
+		}
+		binding := userBindings[userName]
+		add(ManagedResource{Type: terraformUser, Name: userName, ID: userName, InstanceID: binding.InstanceID, BindingID: binding.BindingID})
+
+		policyNames, err := b.user.ListUserPolicies(userName)
+		if err != nil {
+			logger.Error("list-user-policies", err, lager.Data{"user": userName})
+		}
+		for _, policyName := range policyNames {
+			add(ManagedResource{Type: terraformUserPolicy, Name: userName + "_" + policyName, ID: userName + ":" + policyName, InstanceID: binding.InstanceID, BindingID: binding.BindingID})
+		}
+
+		policyARNs, err := b.user.ListAttachedUserPolicies(userName, "")
+		if err != nil {
+			logger.Error("list-attached-user-policies", err, lager.Data{"user": userName})
+		}
+		for _, policyARN := range policyARNs {
+			policyName := policyARN[strings.LastIndex(policyARN, "/")+1:]
+			// Managed policies from plans belong to the operator, but the
+			// broker created those of bindings from before policies were
+			// inlined.
+			if isBindingPolicy(policyName) {
+				add(ManagedResource{Type: terraformPolicy, Name: policyName, ID: policyARN, InstanceID: binding.InstanceID, BindingID: binding.BindingID})
+			}
+			add(ManagedResource{Type: terraformUserPolicyAttachment, Name: userName + "_" + policyName, ID: userName + "/" + policyARN, InstanceID: binding.InstanceID, BindingID: binding.BindingID})
+		}
+	}
+
+	if b.role != nil {
+		for _, roleName := range slices.Sorted(maps.Keys(roleBindings)) {
+			binding := roleBindings[roleName]
+			add(ManagedResource{Type: terraformRole, Name: roleName, ID: roleName, InstanceID: binding.InstanceID, BindingID: binding.BindingID})
+
+			policyNames, err := b.role.ListRolePolicies(roleName)
+			if err != nil {
+				logger.Error("list-role-policies", err, lager.Data{"role": roleName})
+			}
+			for _, policyName := range policyNames {
+				add(ManagedResource{Type: terraformRolePolicy, Name: roleName + "_" + policyName, ID: roleName + ":" + policyName, InstanceID: binding.InstanceID, BindingID: binding.BindingID})
+			}
+
+			policyARNs, err := b.role.ListAttachedRolePolicies(roleName)
+			if err != nil {
+				logger.Error("list-attached-role-policies", err, lager.Data{"role": roleName})
+			}
+			for _, policyARN := range policyARNs {
+				policyName := policyARN[strings.LastIndex(policyARN, "/")+1:]
+				add(ManagedResource{Type: terraformRolePolicyAttachment, Name: roleName + "_" + policyName, ID: roleName + "/" + policyARN, InstanceID: binding.InstanceID, BindingID: binding.BindingID})
+			}
+		}
+	}
+
+	if b.useInstanceGroups {
+		instanceIDs := make(map[string]bool)
+		for _, instanceID := range bucketInstances {
+			if instanceID != "" {
+				instanceIDs[instanceID] = true
+			}
+		}
+		for _, instanceID := range slices.Sorted(maps.Keys(instanceIDs)) {
+			groupName := b.groupName(instanceID)
+			// Instances only have a group once they are bound, and
+			// ListGroupPolicies fails for groups that do not exist.
+			policyNames, err := b.group.ListGroupPolicies(groupName)
+			if err != nil {
+				if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == iam.ErrCodeNoSuchEntityException {
+					continue
+				}
+				logger.Error("list-group-policies", err, lager.Data{"group": groupName})
+				continue
+			}
+			add(ManagedResource{Type: terraformGroup, Name: groupName, ID: groupName, InstanceID: instanceID})
+			for _, policyName := range policyNames {
+				add(ManagedResource{Type: terraformGroupPolicy, Name: groupName + "_" + policyName, ID: groupName + ":" + policyName, InstanceID: instanceID})
+			}
+		}
+	}
+	return resources, nil
+}
+
+// terraformName matches the characters that Terraform names may not have.
+var terraformName = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// terraformIdentifier turns a name into a Terraform identifier, which must
+// start with a letter or underscore.
+func terraformIdentifier(name string) string {
+	identifier := terraformName.ReplaceAllString(name, "_")
+	if identifier == "" || (identifier[0] >= '0' && identifier[0] <= '9') || identifier[0] == '-' {
+		identifier = "_" + identifier
+	}
+	return identifier
+}
+
+// WriteTerraform writes Terraform import blocks for resources, from which
+// Terraform or OpenTofu 1.5 or later can generate their configuration.
+// Resources in accounts other than the broker's own are imported through an
+// aws provider aliased by the account's name.
+func WriteTerraform(w io.Writer, resources []ManagedResource) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# Resources managed by the S3 broker. Generate their configuration with")
+	fmt.Fprintln(bw, "#")
+	fmt.Fprintln(bw, "#   terraform plan -generate-config-out=generated.tf")
+	fmt.Fprintln(bw, "#")
+	fmt.Fprintln(bw, "# and apply it before removing them from the broker.")
+
+	addresses := make(map[string]bool, len(resources))
+	for _, resource := range resources {
+		name := resource.Name
+		if resource.Account != "" {
+			name = resource.Account + "_" + name
+		}
+		address := resource.Type + "." + terraformIdentifier(name)
+		// Names that differ only in characters Terraform does not allow
+		// get a suffix to keep their addresses apart.
+		for i := 2; addresses[address]; i++ {
+			address = resource.Type + "." + terraformIdentifier(name) + "_" + strconv.Itoa(i)
+		}
+		addresses[address] = true
+
+		fmt.Fprintln(bw)
+		var comments []string
+		if resource.InstanceID != "" {
+			comments = append(comments, "instance "+resource.InstanceID)
+		}
+		if resource.BindingID != "" {
+			comments = append(comments, "binding "+resource.BindingID)
+		}
+		if len(comments) > 0 {
+			fmt.Fprintf(bw, "# %s\n", strings.Join(comments, ", "))
+		}
+		fmt.Fprintln(bw, "import {")
+		if resource.Account != "" {
+			fmt.Fprintf(bw, "  provider = aws.%s\n", terraformIdentifier(resource.Account))
+		}
+		fmt.Fprintf(bw, "  to = %s\n", address)
+		fmt.Fprintf(bw, "  id = %s\n", strconv.Quote(resource.ID))
+		fmt.Fprintln(bw, "}")
+	}
+	return bw.Flush()
+}
+
+// ServeTerraform responds with Terraform import blocks for the resources the
+// broker manages.
+func (b *S3Broker) ServeTerraform(w http.ResponseWriter, r *http.Request) {
+	resources, err := b.ManagedResources(r.Context())
+	if err != nil {
+		b.logger.Error("managed-resources", err)
+		http.Error(w, "could not list managed resources", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	WriteTerraform(w, resources)
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+func TestManagedResources(t *testing.T) {
+	store := state.NewMemoryStore()
+	ctx := context.Background()
+	if err := store.SaveInstance(ctx, state.Instance{InstanceID: "instance1", PlanID: "plan1", BucketName: "prefix-instance1"}); err != nil {
+		t.Fatal(err)
+	}
+	bindings := stateBindingStore{store: store}
+	for _, stored := range []StoredBinding{
+		{InstanceID: "instance1", BindingID: "binding1", Credentials: Credentials{AccessKeyID: "key1"}},
+		{InstanceID: "instance1", BindingID: "binding2", Credentials: Credentials{AccessKeyID: "key2", RoleARN: "arn:aws:iam::111111111111:role/s3/prefix-binding2"}},
+	} {
+		if err := bindings.SaveBinding(stored); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := &S3Broker{
+		logger:  lager.NewLogger("broker-unit-test-terraform"),
+		catalog: &mockCatalog{planName: "plan1", serviceName: "service1"},
+		bucket: &mockBucket{buckets: map[string]map[string]string{
+			"prefix-instance1": {brokertags.ServiceInstanceGUIDTagKey: "instance1", brokertags.ServiceNameTagKey: "service1"},
+			"prefix-orphan":    {brokertags.ServiceInstanceGUIDTagKey: "orphan", brokertags.ServiceNameTagKey: "service1"},
+		}},
+		user: &mockUser{
+			accessKeys:           map[string][]string{"prefix-binding1": {"key1"}, "other-user": {"key"}},
+			inlinePolicies:       map[string][]string{"prefix-binding1": {"prefix-binding1"}},
+			attachedUserPolicies: []string{"arn:aws:iam::111111111111:policy/plan-policy", "arn:aws:iam::111111111111:policy/policy-binding1"},
+		},
+		role: &mockRole{
+			inlinePolicies:   map[string][]string{"prefix-binding2": {"prefix-binding2"}},
+			attachedPolicies: map[string][]string{"prefix-binding2": {"arn:aws:iam::111111111111:policy/plan-policy"}},
+		},
+		group:             &mockGroup{policies: map[string][]string{"prefix-instance1": {"prefix-instance1"}}},
+		useInstanceGroups: true,
+		userPrefix:        "prefix",
+		policyPrefix:      "policy",
+		state:             store,
+	}
+
+	resources, err := b.ManagedResources(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []ManagedResource{
+		{Type: "aws_s3_bucket", Name: "prefix-instance1", ID: "prefix-instance1", InstanceID: "instance1"},
+		{Type: "aws_s3_bucket", Name: "prefix-orphan", ID: "prefix-orphan", InstanceID: "orphan"},
+		{Type: "aws_iam_user", Name: "prefix-binding1", ID: "prefix-binding1", InstanceID: "instance1", BindingID: "binding1"},
+		{Type: "aws_iam_user_policy", Name: "prefix-binding1_prefix-binding1", ID: "prefix-binding1:prefix-binding1", InstanceID: "instance1", BindingID: "binding1"},
+		{Type: "aws_iam_user_policy_attachment", Name: "prefix-binding1_plan-policy", ID: "prefix-binding1/arn:aws:iam::111111111111:policy/plan-policy", InstanceID: "instance1", BindingID: "binding1"},
+		{Type: "aws_iam_policy", Name: "policy-binding1", ID: "arn:aws:iam::111111111111:policy/policy-binding1", InstanceID: "instance1", BindingID: "binding1"},
+		{Type: "aws_iam_user_policy_attachment", Name: "prefix-binding1_policy-binding1", ID: "prefix-binding1/arn:aws:iam::111111111111:policy/policy-binding1", InstanceID: "instance1", BindingID: "binding1"},
+		{Type: "aws_iam_role", Name: "prefix-binding2", ID: "prefix-binding2", InstanceID: "instance1", BindingID: "binding2"},
+		{Type: "aws_iam_role_policy", Name: "prefix-binding2_prefix-binding2", ID: "prefix-binding2:prefix-binding2", InstanceID: "instance1", BindingID: "binding2"},
+		{Type: "aws_iam_role_policy_attachment", Name: "prefix-binding2_plan-policy", ID: "prefix-binding2/arn:aws:iam::111111111111:policy/plan-policy", InstanceID: "instance1", BindingID: "binding2"},
+		{Type: "aws_iam_group", Name: "prefix-instance1", ID: "prefix-instance1", InstanceID: "instance1"},
+		{Type: "aws_iam_group_policy", Name: "prefix-instance1_prefix-instance1", ID: "prefix-instance1:prefix-instance1", InstanceID: "instance1"},
+	}
+	if diff := cmp.Diff(expected, resources); diff != "" {
+		t.Errorf("unexpected resources (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteTerraform(t *testing.T) {
+	var out bytes.Buffer
+	err := WriteTerraform(&out, []ManagedResource{
+		{Type: "aws_s3_bucket", Name: "my.bucket", ID: "my.bucket", InstanceID: "instance1"},
+		{Type: "aws_s3_bucket", Name: "my-bucket", ID: "my-bucket"},
+		{Type: "aws_s3_bucket", Name: "my_bucket", ID: "my_bucket"},
+		{Account: "tenant", Type: "aws_iam_user", Name: "1user", ID: "1user", InstanceID: "instance2", BindingID: "binding2"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `# Resources managed by the S3 broker. Generate their configuration with
+#
+#   terraform plan -generate-config-out=generated.tf
+#
+# and apply it before removing them from the broker.
+
+# instance instance1
+import {
+  to = aws_s3_bucket.my_bucket
+  id = "my.bucket"
+}
+
+import {
+  to = aws_s3_bucket.my-bucket
+  id = "my-bucket"
+}
+
+import {
+  to = aws_s3_bucket.my_bucket_2
+  id = "my_bucket"
+}
+
+# instance instance2, binding binding2
+import {
+  provider = aws.tenant
+  to = aws_iam_user.tenant_1user
+  id = "1user"
+}
+`
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Errorf("unexpected output (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,107 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+const maxLifecycleRules = 1000
+
+var corsMethods = []string{"GET", "PUT", "POST", "DELETE", "HEAD"}
+
+// checkPlanChange returns an error if instances of previousPlan may not be
+// updated to servicePlan. A plan's updatable_to lists the plans its instances
+// can move to; without it, any plan is allowed. Plans must also share their
+// KMS key, since the grants of existing bindings are on the previous key.
+func checkPlanChange(previousPlan, servicePlan ServicePlan) error {
+	if previousPlan.ID == servicePlan.ID {
+		return nil
+	}
+
+	updatableTo := previousPlan.S3Properties.UpdatableTo
+	if len(updatableTo) > 0 && !slices.Contains(updatableTo, servicePlan.Name) {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("Instances of plan %q cannot be updated to plan %q. Allowed plans are: %s", previousPlan.Name, servicePlan.Name, strings.Join(updatableTo, ", ")),
+			http.StatusBadRequest,
+			"plan-change-not-allowed",
+		)
+	}
+
+	if previousPlan.S3Properties.KMSKeyID() != servicePlan.S3Properties.KMSKeyID() {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("Instances of plan %q cannot be updated to plan %q because the plans encrypt objects with different KMS keys", previousPlan.Name, servicePlan.Name),
+			http.StatusBadRequest,
+			"plan-change-not-allowed",
+		)
+	}
+
+	return nil
+}
+
+// validateCORSRules rejects cors_rules that S3 would not accept.
+func validateCORSRules(rules []awss3.CORSRule) error {
+	for i, rule := range rules {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return invalidCORSRule(i, "must have allowed_origins and allowed_methods")
+		}
+		for _, method := range rule.AllowedMethods {
+			if !slices.Contains(corsMethods, method) {
+				return invalidCORSRule(i, fmt.Sprintf("method %q must be one of %s", method, strings.Join(corsMethods, ", ")))
+			}
+		}
+		if rule.MaxAgeSeconds < 0 {
+			return invalidCORSRule(i, "max_age_seconds must not be negative")
+		}
+	}
+	return nil
+}
+
+func invalidCORSRule(index int, reason string) error {
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("cors_rules[%d] %s", index, reason),
+		http.StatusBadRequest,
+		"invalid-cors-rules",
+	)
+}
+
+// validateLifecycleRules rejects lifecycle_rules that S3 would not accept.
+// Every rule needs a unique id and at least one action.
+func validateLifecycleRules(rules []awss3.LifecycleRule) error {
+	if len(rules) > maxLifecycleRules {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("lifecycle_rules must have at most %d rules", maxLifecycleRules),
+			http.StatusBadRequest,
+			"invalid-lifecycle-rules",
+		)
+	}
+
+	ids := make(map[string]bool)
+	for i, rule := range rules {
+		switch {
+		case rule.ID == "" || len(rule.ID) > 255:
+			return invalidLifecycleRule(i, "id must be between 1 and 255 characters")
+		case ids[rule.ID]:
+			return invalidLifecycleRule(i, fmt.Sprintf("id %q is not unique", rule.ID))
+		case rule.ExpirationDays < 0 || rule.NoncurrentVersionExpirationDays < 0 || rule.AbortIncompleteMultipartUploadDays < 0:
+			return invalidLifecycleRule(i, "days must not be negative")
+		case rule.ExpirationDays == 0 && rule.NoncurrentVersionExpirationDays == 0 && rule.AbortIncompleteMultipartUploadDays == 0:
+			return invalidLifecycleRule(i, "must set expiration_days, noncurrent_version_expiration_days or abort_incomplete_multipart_upload_days")
+		}
+		ids[rule.ID] = true
+	}
+	return nil
+}
+
+func invalidLifecycleRule(index int, reason string) error {
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("lifecycle_rules[%d] %s", index, reason),
+		http.StatusBadRequest,
+		"invalid-lifecycle-rules",
+	)
+}
@@ -0,0 +1,143 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+func TestUpdate(t *testing.T) {
+	kmsEncryption := `{"Rules":[{"ApplyServerSideEncryptionByDefault":{"SSEAlgorithm":"aws:kms","KMSMasterKeyID":"key1"}}]}`
+	plans := map[string]ServicePlan{
+		"private": {ID: "private", Name: "basic", S3Properties: S3Properties{
+			UpdatableTo: []string{"basic-versioned"},
+		}},
+		"versioned": {ID: "versioned", Name: "basic-versioned", S3Properties: S3Properties{
+			Versioning: true,
+		}},
+		"public": {ID: "public", Name: "basic-public", S3Properties: S3Properties{
+			BucketPolicy: `{"Statement":[]}`,
+		}},
+		"kms": {ID: "kms", Name: "basic-kms", S3Properties: S3Properties{
+			Encryption: kmsEncryption,
+		}},
+		"dev": {ID: "dev", Name: "basic-dev", S3Properties: S3Properties{
+			Account: "dev",
+		}},
+	}
+
+	testCases := map[string]struct {
+		previousPlanID string
+		planID         string
+		params         string
+		expectErr      string
+		expectDetails  awss3.BucketDetails
+	}{
+		"plan change": {
+			previousPlanID: "private",
+			planID:         "versioned",
+			expectDetails: awss3.BucketDetails{
+				Versioning:   true,
+				AwsPartition: "aws",
+				Tags:         map[string]string{"service name": "service1"},
+			},
+		},
+		"plan change not in updatable_to": {
+			previousPlanID: "private",
+			planID:         "public",
+			expectErr:      "plan-change-not-allowed",
+		},
+		"plan change between KMS keys": {
+			previousPlanID: "versioned",
+			planID:         "kms",
+			expectErr:      "plan-change-not-allowed",
+		},
+		"CORS and lifecycle rules": {
+			previousPlanID: "versioned",
+			planID:         "versioned",
+			params:         `{"cors_rules":[{"allowed_origins":["https://example.com"],"allowed_methods":["GET"]}],"lifecycle_rules":[]}`,
+			expectDetails: awss3.BucketDetails{
+				Versioning:     true,
+				AwsPartition:   "aws",
+				Tags:           map[string]string{"service name": "service1"},
+				CORSRules:      []awss3.CORSRule{{AllowedOrigins: []string{"https://example.com"}, AllowedMethods: []string{"GET"}}},
+				LifecycleRules: []awss3.LifecycleRule{},
+			},
+		},
+		"invalid CORS rule": {
+			previousPlanID: "versioned",
+			planID:         "versioned",
+			params:         `{"cors_rules":[{"allowed_origins":["*"],"allowed_methods":[]}]}`,
+			expectErr:      "invalid-cors-rules",
+		},
+		"parameters that do not match the schema": {
+			previousPlanID: "versioned",
+			planID:         "versioned",
+			params:         `{"cors_rule":[],"lifecycle_rules":[{"id":"expire","expiration_days":"30"}]}`,
+			expectErr:      "invalid-parameters",
+		},
+		"plan in another account": {
+			previousPlanID: "versioned",
+			planID:         "dev",
+			expectErr:      "account-plan-change",
+		},
+		"lifecycle rule without an action": {
+			previousPlanID: "versioned",
+			planID:         "versioned",
+			params:         `{"lifecycle_rules":[{"id":"expire-logs","prefix":"logs/"}]}`,
+			expectErr:      "invalid-lifecycle-rules",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bucket := &mockBucket{}
+			b := &S3Broker{
+				logger:                    lager.NewLogger("broker-unit-test-update"),
+				bucket:                    bucket,
+				catalog:                   &mockCatalog{serviceName: "service1", plans: plans},
+				tagManager:                &mockTagGenerator{serviceName: "service1"},
+				allowUserUpdateParameters: true,
+				awsPartition:              "aws",
+			}
+
+			details := domain.UpdateDetails{
+				PlanID:         tc.planID,
+				PreviousValues: domain.PreviousValues{PlanID: tc.previousPlanID},
+			}
+			if tc.params != "" {
+				details.RawParameters = json.RawMessage(tc.params)
+			}
+
+			_, err := b.Update(context.Background(), "instance1", details, false)
+			if tc.expectErr != "" {
+				var failure *apiresponses.FailureResponse
+				if !errors.As(err, &failure) || failure.ValidatedStatusCode(nil) != http.StatusBadRequest ||
+					failure.LoggerAction() != tc.expectErr {
+					t.Fatalf("expected a 400 %s failure, got %v", tc.expectErr, err)
+				}
+				if bucket.modified != nil {
+					t.Errorf("expected the bucket not to be modified")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if bucket.modified == nil {
+				t.Fatal("expected the bucket to be modified")
+			}
+			if diff := cmp.Diff(tc.expectDetails, *bucket.modified); diff != "" {
+				t.Errorf("unexpected bucket details (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
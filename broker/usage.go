@@ -0,0 +1,317 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/cloud-gov/s3-broker/awsmetrics"
+	"github.com/cloud-gov/s3-broker/awsretry"
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+// Sources of instances' storage usage.
+const (
+	// UsageSourceCloudWatch reads the storage metrics that S3 reports to
+	// CloudWatch once a day, which count every storage class and noncurrent
+	// versions.
+	UsageSourceCloudWatch = "cloudwatch"
+	// UsageSourceList lists the current objects of buckets, which is up to
+	// date but stops counting at 10,000 objects.
+	UsageSourceList = "list"
+)
+
+var ErrUsageRequiresState = errors.New("usage reporting requires a state store")
+
+type UsageConfig struct {
+	// Interval is how often the usage of every instance is measured. Usage
+	// is not measured when it is zero.
+	Interval time.Duration `yaml:"interval"`
+	// Source is cloudwatch or list. Defaults to cloudwatch.
+	Source string `yaml:"source"`
+}
+
+func (c UsageConfig) Enabled() bool {
+	return c.Interval != 0
+}
+
+func (c UsageConfig) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("Interval must not be negative")
+	}
+	switch c.Source {
+	case "", UsageSourceCloudWatch, UsageSourceList:
+	default:
+		return fmt.Errorf("Source must be %q or %q, got %q", UsageSourceCloudWatch, UsageSourceList, c.Source)
+	}
+	if c.Source != "" && !c.Enabled() {
+		return errors.New("Must provide an Interval with a Source")
+	}
+	return nil
+}
+
+// FromCloudWatch reports whether usage is measured from CloudWatch.
+func (c UsageConfig) FromCloudWatch() bool {
+	return c.Enabled() && (c.Source == "" || c.Source == UsageSourceCloudWatch)
+}
+
+// StorageReader reads the storage that S3 last reported for buckets.
+type StorageReader interface {
+	BucketStorage(ctx context.Context, bucketName string) (awsmetrics.BucketStorage, error)
+}
+
+// SetStorageReader gives the broker the reader of its own account's storage
+// metrics. It must be called before SetAccounts.
+func (b *S3Broker) SetStorageReader(reader StorageReader) {
+	b.storage = reader
+}
+
+// InstanceUsage is an instance's last measured usage, with what showback and
+// chargeback need to attribute it.
+type InstanceUsage struct {
+	InstanceID       string    `json:"instance_id"`
+	PlanID           string    `json:"plan_id"`
+	OrganizationGUID string    `json:"organization_guid"`
+	SpaceGUID        string    `json:"space_guid"`
+	BucketName       string    `json:"bucket_name"`
+	Bytes            int64     `json:"bytes"`
+	Objects          int64     `json:"objects"`
+	Truncated        bool      `json:"truncated,omitempty"`
+	MeasuredAt       time.Time `json:"measured_at"`
+}
+
+// CollectUsage measures the storage of every instance in the state store
+// whose bucket is in S3, saves it to the store, if metrics are enabled,
+// records it as metrics, and enforces the storage quotas of plans. Instances
+// that cannot be measured keep the usage last saved for them. Its AWS calls
+// are low priority.
+func (b *S3Broker) CollectUsage(ctx context.Context) error {
+	if b.state == nil {
+		return ErrUsageRequiresState
+	}
+	ctx = awsretry.WithLowPriority(ctx)
+	logger := b.logger.Session("usage")
+
+	instances, err := b.state.ListInstances(ctx)
+	if err != nil {
+		logger.Error("list-instances", err)
+		return err
+	}
+	for _, instance := range instances {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if b.planObjectStore(instance.PlanID) != "" {
+			continue
+		}
+		data := lager.Data{instanceIDLogKey: instance.InstanceID}
+		servicePlan, _ := b.catalog.FindServicePlan(instance.PlanID)
+		accountBroker := b.forPlan(servicePlan)
+		if instance.BucketName == "" {
+			if instance.BucketName, err = accountBroker.instanceBucketName(ctx, instance.InstanceID, servicePlan); err != nil {
+				logger.Error("bucket-name", err, data)
+				continue
+			}
+		}
+		usage, err := accountBroker.measureUsage(ctx, instance.InstanceID, instance.BucketName)
+		if errors.Is(err, awsmetrics.ErrNoStorageMetrics) {
+			logger.Info("not-reported", data)
+			continue
+		}
+		if err != nil {
+			logger.Error("measure", err, data)
+			continue
+		}
+		if err := b.state.SaveUsage(ctx, usage); err != nil {
+			logger.Error("save-usage", err, data)
+			continue
+		}
+		b.recordUsage(usage, instance.OrganizationGUID)
+		if err := accountBroker.enforceStorageQuota(ctx, servicePlan, instance.BucketName, usage); err != nil {
+			logger.Error("enforce-storage-quota", err, data)
+		}
+	}
+	return nil
+}
+
+// measureUsage measures a bucket from the configured source.
+func (b *S3Broker) measureUsage(ctx context.Context, instanceID, bucketName string) (state.Usage, error) {
+	if !b.usage.FromCloudWatch() {
+		usage, err := b.bucket.Usage(ctx, bucketName)
+		if err != nil {
+			return state.Usage{}, err
+		}
+		return state.Usage{
+			InstanceID: instanceID,
+			Bytes:      usage.Bytes,
+			Objects:    usage.Objects,
+			Truncated:  usage.Truncated,
+			MeasuredAt: time.Now(),
+		}, nil
+	}
+	storage, err := b.storage.BucketStorage(ctx, bucketName)
+	if err != nil {
+		return state.Usage{}, err
+	}
+	return state.Usage{
+		InstanceID: instanceID,
+		Bytes:      storage.Bytes,
+		Objects:    storage.Objects,
+		MeasuredAt: storage.Time,
+	}, nil
+}
+
+// recordUsage records an instance's usage as the StorageBytes and
+// StorageObjects metrics, which cost a series each for every instance.
+func (b *S3Broker) recordUsage(usage state.Usage, organizationGUID string) {
+	if b.metrics == nil {
+		return
+	}
+	dimensions := map[string]string{"InstanceID": usage.InstanceID}
+	if organizationGUID != "" {
+		dimensions["Organization"] = organizationGUID
+	}
+	b.metrics.Gauge("StorageBytes", float64(usage.Bytes), cloudwatch.StandardUnitBytes, dimensions)
+	b.metrics.Gauge("StorageObjects", float64(usage.Objects), cloudwatch.StandardUnitCount, dimensions)
+}
+
+// instanceUsage returns the usage last saved for an instance, if the broker
+// has a state store and the instance's usage is in it.
+func (b *S3Broker) instanceUsage(ctx context.Context, instanceID string) (state.Usage, bool) {
+	if b.state == nil {
+		return state.Usage{}, false
+	}
+	usage, err := b.state.GetUsage(ctx, instanceID)
+	if err != nil {
+		if !errors.Is(err, state.ErrUsageNotFound) {
+			b.logger.Error("get-usage", err, lager.Data{instanceIDLogKey: instanceID})
+		}
+		return state.Usage{}, false
+	}
+	return usage, true
+}
+
+// usageAttributes returns the metadata attributes that report an instance's
+// usage, which are none if it has not been measured.
+func (b *S3Broker) usageAttributes(ctx context.Context, instanceID string) map[string]string {
+	usage, ok := b.instanceUsage(ctx, instanceID)
+	if !ok {
+		return nil
+	}
+	attributes := map[string]string{
+		"storage_bytes":     strconv.FormatInt(usage.Bytes, 10),
+		"object_count":      strconv.FormatInt(usage.Objects, 10),
+		"usage_measured_at": usage.MeasuredAt.UTC().Format(time.RFC3339),
+	}
+	if usage.Truncated {
+		attributes["usage_truncated"] = "true"
+	}
+	return attributes
+}
+
+// UsageReport returns the usage of every instance that has been measured,
+// sorted by instance ID.
+func (b *S3Broker) UsageReport(ctx context.Context) ([]InstanceUsage, error) {
+	if b.state == nil {
+		return nil, ErrUsageRequiresState
+	}
+	instances, err := b.state.ListInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	usages, err := b.state.ListUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]state.Usage, len(usages))
+	for _, usage := range usages {
+		byID[usage.InstanceID] = usage
+	}
+
+	report := []InstanceUsage{}
+	for _, instance := range instances {
+		usage, ok := byID[instance.InstanceID]
+		if !ok {
+			continue
+		}
+		report = append(report, InstanceUsage{
+			InstanceID:       instance.InstanceID,
+			PlanID:           instance.PlanID,
+			OrganizationGUID: instance.OrganizationGUID,
+			SpaceGUID:        instance.SpaceGUID,
+			BucketName:       instance.BucketName,
+			Bytes:            usage.Bytes,
+			Objects:          usage.Objects,
+			Truncated:        usage.Truncated,
+			MeasuredAt:       usage.MeasuredAt,
+		})
+	}
+	slices.SortFunc(report, func(a, b InstanceUsage) int {
+		return strings.Compare(a.InstanceID, b.InstanceID)
+	})
+	return report, nil
+}
+
+// ServeUsage handles GET /admin/usage, which lists the last measured usage
+// of every instance as JSON.
+func (b *S3Broker) ServeUsage(w http.ResponseWriter, r *http.Request) {
+	report, err := b.UsageReport(r.Context())
+	switch {
+	case errors.Is(err, ErrUsageRequiresState):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		b.logger.Error("usage-report", err)
+		http.Error(w, "could not list usage", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// RunUsageCollection calls CollectUsage on the configured interval until ctx
+// is done, while this broker is the leader. It returns at once if no
+// interval is set.
+func (b *S3Broker) RunUsageCollection(ctx context.Context) {
+	if !b.usage.Enabled() {
+		return
+	}
+	if b.state == nil {
+		b.logger.Error("usage", ErrUsageRequiresState)
+		return
+	}
+
+	ticker := time.NewTicker(b.usage.Interval)
+	defer ticker.Stop()
+	for {
+		if b.isLeader() {
+			b.CollectUsage(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dashboardUsage returns the usage that an instance's dashboard shows: the
+// last measured, if the instance has been measured, or else what listing its
+// bucket finds now, with a zero time.
+func (b *S3Broker) dashboardUsage(ctx context.Context, instanceID, bucketName string) (awss3.BucketUsage, time.Time, error) {
+	if usage, ok := b.instanceUsage(ctx, instanceID); ok {
+		return awss3.BucketUsage{Objects: usage.Objects, Bytes: usage.Bytes, Truncated: usage.Truncated}, usage.MeasuredAt, nil
+	}
+	usage, err := b.bucket.Usage(ctx, bucketName)
+	return usage, time.Time{}, err
+}
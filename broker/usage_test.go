@@ -0,0 +1,221 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cloud-gov/s3-broker/awsmetrics"
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+type mockStorageReader struct {
+	storage map[string]awsmetrics.BucketStorage
+}
+
+func (r *mockStorageReader) BucketStorage(ctx context.Context, bucketName string) (awsmetrics.BucketStorage, error) {
+	storage, ok := r.storage[bucketName]
+	if !ok {
+		return awsmetrics.BucketStorage{}, awsmetrics.ErrNoStorageMetrics
+	}
+	return storage, nil
+}
+
+func TestUsageConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		config    UsageConfig
+		expectErr string
+	}{
+		"disabled": {},
+		"cloudwatch": {
+			config: UsageConfig{Interval: time.Hour},
+		},
+		"list": {
+			config: UsageConfig{Interval: time.Hour, Source: UsageSourceList},
+		},
+		"negative interval": {
+			config:    UsageConfig{Interval: -time.Hour},
+			expectErr: "Interval must not be negative",
+		},
+		"unknown source": {
+			config:    UsageConfig{Interval: time.Hour, Source: "inventory"},
+			expectErr: `Source must be "cloudwatch" or "list", got "inventory"`,
+		},
+		"source without interval": {
+			config:    UsageConfig{Source: UsageSourceList},
+			expectErr: "Must provide an Interval with a Source",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.expectErr {
+				t.Errorf("expected error %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestCollectUsage(t *testing.T) {
+	measuredAt := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+	for _, instance := range []state.Instance{
+		{InstanceID: "instance1", PlanID: "plan1", OrganizationGUID: "org1", BucketName: "prefix-instance1"},
+		{InstanceID: "instance2", PlanID: "plan1", BucketName: "prefix-instance2"},
+		{InstanceID: "instance3", PlanID: "gcs", BucketName: "prefix-instance3"},
+	} {
+		if err := store.SaveInstance(ctx, instance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder := &mockMetricsRecorder{}
+	b := &S3Broker{
+		logger: lager.NewLogger("broker-unit-test-usage"),
+		catalog: &mockCatalog{plans: map[string]ServicePlan{
+			"plan1": {ID: "plan1"},
+			"gcs":   {ID: "gcs", S3Properties: S3Properties{ObjectStore: ObjectStoreGCS}},
+		}},
+		state:   store,
+		metrics: recorder,
+		usage:   UsageConfig{Interval: time.Hour},
+		storage: &mockStorageReader{storage: map[string]awsmetrics.BucketStorage{
+			"prefix-instance1": {Bytes: 4096, Objects: 3, Time: measuredAt},
+			"prefix-instance3": {Bytes: 1024, Objects: 1, Time: measuredAt},
+		}},
+	}
+
+	if err := b.CollectUsage(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The instance without storage metrics and the instance in another object
+	// store are not measured.
+	usages, err := store.ListUsage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]state.Usage{{InstanceID: "instance1", Bytes: 4096, Objects: 3, MeasuredAt: measuredAt}}, usages); diff != "" {
+		t.Errorf("unexpected usage (-want +got):\n%s", diff)
+	}
+	dimensions := map[string]string{"InstanceID": "instance1", "Organization": "org1"}
+	expectMetrics := []mockMetric{
+		{Name: "StorageBytes", Value: 4096, Unit: "Bytes", Dimensions: dimensions},
+		{Name: "StorageObjects", Value: 3, Unit: "Count", Dimensions: dimensions},
+	}
+	if diff := cmp.Diff(expectMetrics, recorder.metrics); diff != "" {
+		t.Errorf("unexpected metrics (-want +got):\n%s", diff)
+	}
+
+	attributes := b.usageAttributes(ctx, "instance1")
+	expectAttributes := map[string]string{
+		"storage_bytes":     "4096",
+		"object_count":      "3",
+		"usage_measured_at": "2024-05-02T00:00:00Z",
+	}
+	if diff := cmp.Diff(expectAttributes, attributes); diff != "" {
+		t.Errorf("unexpected attributes (-want +got):\n%s", diff)
+	}
+
+	b.deleteInstanceState(ctx, "instance1")
+	if _, ok := b.instanceUsage(ctx, "instance1"); ok {
+		t.Error("expected deprovisioning to forget the instance's usage")
+	}
+}
+
+func TestCollectUsageFromListing(t *testing.T) {
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+	if err := store.SaveInstance(ctx, state.Instance{InstanceID: "instance1", PlanID: "plan1", BucketName: "prefix-instance1"}); err != nil {
+		t.Fatal(err)
+	}
+	b := &S3Broker{
+		logger:  lager.NewLogger("broker-unit-test-usage"),
+		catalog: &mockCatalog{planName: "plan1"},
+		bucket:  &mockBucket{usage: awss3.BucketUsage{Objects: 10_000, Bytes: 5 << 20, Truncated: true}},
+		state:   store,
+		usage:   UsageConfig{Interval: time.Hour, Source: UsageSourceList},
+	}
+
+	if err := b.CollectUsage(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	usage, ok := b.instanceUsage(ctx, "instance1")
+	if !ok {
+		t.Fatal("expected the instance's usage to be saved")
+	}
+	if usage.Objects != 10_000 || usage.Bytes != 5<<20 || !usage.Truncated || usage.MeasuredAt.IsZero() {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if attributes := b.usageAttributes(ctx, "instance1"); attributes["usage_truncated"] != "true" {
+		t.Errorf("expected the attributes to say the count was truncated, got %v", attributes)
+	}
+}
+
+func TestCollectUsageRequiresState(t *testing.T) {
+	b := &S3Broker{logger: lager.NewLogger("broker-unit-test-usage")}
+	if err := b.CollectUsage(context.Background()); !errors.Is(err, ErrUsageRequiresState) {
+		t.Errorf("expected ErrUsageRequiresState, got %v", err)
+	}
+}
+
+func TestServeUsage(t *testing.T) {
+	measuredAt := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+	for _, instance := range []state.Instance{
+		{InstanceID: "instance2", PlanID: "plan1", OrganizationGUID: "org1", SpaceGUID: "space1", BucketName: "prefix-instance2"},
+		{InstanceID: "instance1", PlanID: "plan1", OrganizationGUID: "org1", SpaceGUID: "space1", BucketName: "prefix-instance1"},
+		{InstanceID: "unmeasured", PlanID: "plan1", BucketName: "prefix-unmeasured"},
+	} {
+		if err := store.SaveInstance(ctx, instance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, usage := range []state.Usage{
+		{InstanceID: "instance1", Bytes: 10, Objects: 1, MeasuredAt: measuredAt},
+		{InstanceID: "instance2", Bytes: 20, Objects: 2, MeasuredAt: measuredAt},
+	} {
+		if err := store.SaveUsage(ctx, usage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := &S3Broker{logger: lager.NewLogger("broker-unit-test-usage"), state: store}
+
+	recorder := httptest.NewRecorder()
+	b.ServeUsage(recorder, httptest.NewRequest(http.MethodGet, "/admin/usage", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+	var report []InstanceUsage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	expect := []InstanceUsage{
+		{InstanceID: "instance1", PlanID: "plan1", OrganizationGUID: "org1", SpaceGUID: "space1", BucketName: "prefix-instance1", Bytes: 10, Objects: 1, MeasuredAt: measuredAt},
+		{InstanceID: "instance2", PlanID: "plan1", OrganizationGUID: "org1", SpaceGUID: "space1", BucketName: "prefix-instance2", Bytes: 20, Objects: 2, MeasuredAt: measuredAt},
+	}
+	if diff := cmp.Diff(expect, report); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%s", diff)
+	}
+
+	recorder = httptest.NewRecorder()
+	(&S3Broker{logger: lager.NewLogger("broker-unit-test-usage")}).ServeUsage(recorder, httptest.NewRequest(http.MethodGet, "/admin/usage", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without a state store, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+const (
+	// maxUserTags leaves room within S3's limit of 50 tags per bucket for the
+	// broker's and operator's tags.
+	maxUserTags = 20
+
+	// maxTagKeyLength is the longest key S3 tags may have.
+	maxTagKeyLength = 128
+)
+
+// reservedTagKeys are the keys of the tags the broker manages itself, which
+// users cannot set.
+var reservedTagKeys = []string{
+	awss3.AdoptedTagKey,
+	"Created at",
+	"Updated at",
+	brokertags.BrokerTagKey,
+	brokertags.ClientTagKey,
+	brokertags.EnvironmentTagKey,
+	brokertags.OrganizationGUIDTagKey,
+	brokertags.OrganizationNameTagKey,
+	brokertags.ServiceInstanceGUIDTagKey,
+	brokertags.ServiceNameTagKey,
+	brokertags.ServicePlanName,
+	brokertags.SpaceGUIDTagKey,
+	brokertags.SpaceNameTagKey,
+	CreatedByTagKey,
+	UpdatedByTagKey,
+	MaintenanceVersionTagKey,
+	PreserveOnDeleteTagKey,
+	AlertThresholdTagKey,
+	ReleasedAtTagKey,
+	ReleasedInstanceTagKey,
+	KubernetesClusterIDTagKey,
+	KubernetesNamespaceTagKey,
+}
+
+func invalidTags(format string, args ...any) error {
+	return apiresponses.NewFailureResponse(fmt.Errorf(format, args...), http.StatusBadRequest, "invalid-tags")
+}
+
+// addUserTags merges the tags parameter of a provision or update into the
+// tags the broker generated for the bucket. Users cannot set the broker's
+// own tags, the operator's, or tags that AWS reserves.
+func addUserTags(tags, userTags map[string]string) (map[string]string, error) {
+	if len(userTags) == 0 {
+		return tags, nil
+	}
+	if len(userTags) > maxUserTags {
+		return nil, invalidTags("tags must have at most %d keys, got %d", maxUserTags, len(userTags))
+	}
+	for key, value := range userTags {
+		if key == "" || utf8.RuneCountInString(key) > maxTagKeyLength {
+			return nil, invalidTags("tag key %q must be between 1 and %d characters", key, maxTagKeyLength)
+		}
+		if utf8.RuneCountInString(value) > maxTagValueLength {
+			return nil, invalidTags("value of tag %q must be at most %d characters", key, maxTagValueLength)
+		}
+		if invalidTagValueChars.MatchString(key) || invalidTagValueChars.MatchString(value) {
+			return nil, invalidTags("tag %q may only contain letters, numbers, spaces and the characters _ . : / = + - @", key)
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return nil, invalidTags("tag key %q must not start with aws:, which AWS reserves", key)
+		}
+		_, operatorTag := tags[key]
+		if operatorTag || slices.Contains(reservedTagKeys, key) || strings.HasPrefix(key, SharedSpaceTagKeyPrefix) {
+			return nil, invalidTags("tag key %q is reserved for the broker", key)
+		}
+	}
+
+	if tags == nil {
+		tags = make(map[string]string, len(userTags))
+	}
+	for key, value := range userTags {
+		tags[key] = value
+	}
+	return tags, nil
+}
@@ -0,0 +1,104 @@
+package broker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+func TestAddUserTags(t *testing.T) {
+	brokerTags := map[string]string{
+		brokertags.ServiceInstanceGUIDTagKey: "instance1",
+		"cost-center":                        "operator",
+	}
+
+	tooMany := make(map[string]string)
+	for i := range maxUserTags + 1 {
+		tooMany[strings.Repeat("k", i+1)] = "value"
+	}
+
+	testCases := map[string]struct {
+		userTags   map[string]string
+		expectTags map[string]string
+		expectErr  bool
+	}{
+		"no tags": {
+			expectTags: brokerTags,
+		},
+		"adds tags": {
+			userTags: map[string]string{"team": "research", "owner": "jane@example.com"},
+			expectTags: map[string]string{
+				brokertags.ServiceInstanceGUIDTagKey: "instance1",
+				"cost-center":                        "operator",
+				"team":                               "research",
+				"owner":                              "jane@example.com",
+			},
+		},
+		"too many tags": {
+			userTags:  tooMany,
+			expectErr: true,
+		},
+		"empty key": {
+			userTags:  map[string]string{"": "value"},
+			expectErr: true,
+		},
+		"long key": {
+			userTags:  map[string]string{strings.Repeat("k", maxTagKeyLength+1): "value"},
+			expectErr: true,
+		},
+		"long value": {
+			userTags:  map[string]string{"team": strings.Repeat("v", maxTagValueLength+1)},
+			expectErr: true,
+		},
+		"invalid characters": {
+			userTags:  map[string]string{"team": "research & development"},
+			expectErr: true,
+		},
+		"aws prefix": {
+			userTags:  map[string]string{"AWS:cloudformation:stack-name": "stack"},
+			expectErr: true,
+		},
+		"broker tag": {
+			userTags:  map[string]string{PreserveOnDeleteTagKey: "true"},
+			expectErr: true,
+		},
+		"broker tag not generated for this bucket": {
+			userTags:  map[string]string{brokertags.SpaceGUIDTagKey: "space1"},
+			expectErr: true,
+		},
+		"shared space tag": {
+			userTags:  map[string]string{SharedSpaceTagKeyPrefix + "space2": "org2"},
+			expectErr: true,
+		},
+		"operator tag": {
+			userTags:  map[string]string{"cost-center": "team"},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tags := make(map[string]string)
+			for key, value := range brokerTags {
+				tags[key] = value
+			}
+			tags, err := addUserTags(tags, tc.userTags)
+			if tc.expectErr {
+				var failure *apiresponses.FailureResponse
+				if !errors.As(err, &failure) || failure.LoggerAction() != "invalid-tags" {
+					t.Fatalf("expected invalid-tags error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(tc.expectTags, tags); diff != "" {
+				t.Errorf("unexpected tags (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
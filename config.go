@@ -7,16 +7,32 @@ import (
 	"os"
 
 	"github.com/cloud-gov/s3-broker/broker"
+	"github.com/cloud-gov/s3-broker/state"
+	"github.com/cloud-gov/s3-broker/tokenauth"
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	LogLevel    string        `yaml:"log_level"`
-	Username    string        `yaml:"username"`
-	Password    string        `yaml:"password"`
-	Environment string        `yaml:"environment"`
-	S3Config    broker.Config `yaml:"s3_config"`
-	CFConfig    *CFConfig     `yaml:"cf_config"`
+	LogLevel    string           `yaml:"log_level"`
+	Username    string           `yaml:"username"`
+	Password    string           `yaml:"password"`
+	Environment string           `yaml:"environment"`
+	S3Config    broker.Config    `yaml:"s3_config"`
+	CFConfig    *CFConfig        `yaml:"cf_config"`
+	State       state.Config     `yaml:"state"`
+	TLS         TLSConfig        `yaml:"tls"`
+	OAuth2      tokenauth.Config `yaml:"oauth2"`
+	// Credentials are accepted in addition to Username and Password.
+	Credentials     []BrokerCredential    `yaml:"credentials"`
+	CredentialsFile CredentialsFileConfig `yaml:"credentials_file"`
+	// PermissionCheck is what the broker does when its AWS credentials are
+	// not allowed an action that it needs on startup: "warn", the default,
+	// "fail" or "off".
+	PermissionCheck string       `yaml:"permission_check"`
+	Limits          LimitsConfig `yaml:"limits"`
+	// RedactPolicies redacts policy documents from log lines, as well as the
+	// secrets that are always redacted.
+	RedactPolicies bool `yaml:"redact_policies"`
 }
 
 type CFConfig struct {
@@ -44,6 +60,15 @@ func LoadConfig(configFile string) (config *Config, err error) {
 	if err = yaml.Unmarshal(bytes, &config); err != nil {
 		return config, err
 	}
+	if config == nil {
+		config = &Config{}
+	}
+
+	if err = applyOverrides(config, os.Environ()); err != nil {
+		return config, fmt.Errorf("Applying environment overrides: %s", err)
+	}
+
+	config.S3Config.ApplyPreset()
 
 	if err = config.Validate(); err != nil {
 		return config, fmt.Errorf("Validating config contents: %s", err)
@@ -57,17 +82,63 @@ func (c Config) Validate() error {
 		return errors.New("Must provide a non-empty LogLevel")
 	}
 
-	if c.Username == "" {
+	// Username and Password are optional when the platform can authenticate
+	// another way, but a Username without a Password is still a mistake.
+	if c.Username == "" && (c.Password != "" || !c.otherAuth()) {
 		return errors.New("Must provide a non-empty Username")
 	}
 
-	if c.Password == "" {
+	if c.Password == "" && (c.Username != "" || !c.otherAuth()) {
 		return errors.New("Must provide a non-empty Password")
 	}
 
+	if err := validateCredentials(c.Credentials); err != nil {
+		return err
+	}
+
+	if err := c.CredentialsFile.Validate(); err != nil {
+		return fmt.Errorf("Validating credentials file configuration: %s", err)
+	}
+
+	switch c.PermissionCheck {
+	case "", PermissionCheckWarn, PermissionCheckFail, PermissionCheckOff:
+	default:
+		return fmt.Errorf("PermissionCheck must be one of %q, %q or %q, got %q", PermissionCheckWarn, PermissionCheckFail, PermissionCheckOff, c.PermissionCheck)
+	}
+
+	if err := c.OAuth2.Validate(); err != nil {
+		return fmt.Errorf("Validating OAuth2 configuration: %s", err)
+	}
+
 	if err := c.S3Config.Validate(); err != nil {
 		return fmt.Errorf("Validating S3 configuration: %s", err)
 	}
 
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("Validating limits configuration: %s", err)
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("Validating TLS configuration: %s", err)
+	}
+
+	if err := c.State.Validate(); err != nil {
+		return fmt.Errorf("Validating state configuration: %s", err)
+	}
+
+	if c.State.Encryption.CredHubName != "" && !c.S3Config.CredHub.Enabled() {
+		return errors.New("Must configure CredHub to read the state encryption key from it")
+	}
+
+	if c.S3Config.LeaderElection.Enabled && (c.State.Backend == "" || c.State.Backend == state.BackendMemory) {
+		return errors.New("Must provide a shared state Backend to elect a leader in")
+	}
+
 	return nil
 }
+
+// otherAuth reports whether the platform can authenticate without Username
+// and Password.
+func (c Config) otherAuth() bool {
+	return len(c.Credentials) > 0 || c.CredentialsFile.Enabled() || c.OAuth2.Enabled()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/accessanalyzer"
+	"github.com/aws/aws-sdk-go/service/accessanalyzer/accessanalyzeriface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	"github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awspartition"
+	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+const configUsage = `usage: s3-broker -config FILE config validate [-offline]`
+
+// exampleBucket is the bucket that policy templates are rendered for.
+const exampleBucket = "example-bucket"
+
+// runConfigCommand runs "config validate", which checks a config file more
+// deeply than starting the broker does, so that a change can be checked in
+// CI before it is deployed. Loading the config has already parsed and
+// validated it. The plans' policy templates are rendered and checked with
+// IAM Access Analyzer, and the broker's credentials and IAM permissions are
+// checked in each of its accounts. -offline skips the checks that call AWS.
+func runConfigCommand(ctx context.Context, config *Config, awsConfig *aws.Config, s3Config awsv2.Config, args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return errors.New(configUsage)
+	}
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	offline := flags.Bool("offline", false, "Skip the checks that call AWS")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	v := &validation{out: os.Stdout}
+	v.report("config file", nil)
+	policies := v.checkPolicyTemplates(config.S3Config)
+	// S3-compatible stores have no IAM or Access Analyzer to ask.
+	if !*offline && config.S3Config.Endpoint == "" {
+		awsSession := session.New(awsConfig)
+		v.checkPolicyFindings(ctx, accessanalyzer.New(awsSession), policies)
+		v.checkPermissions(ctx, "broker account", config, sts.New(awsSession), iam.New(awsSession))
+
+		names := make([]string, 0, len(config.S3Config.Accounts))
+		for name := range config.S3Config.Accounts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			accountConfig, _ := assumeRoles(awsConfig, s3Config, "", 0, []broker.RoleConfig{config.S3Config.Accounts[name].RoleConfig})
+			accountSession := session.New(accountConfig)
+			v.checkPermissions(ctx, fmt.Sprintf("account %s", name), config, sts.New(accountSession), iam.New(accountSession))
+		}
+	}
+
+	if v.failures > 0 {
+		return fmt.Errorf("%d checks failed", v.failures)
+	}
+	fmt.Fprintln(v.out, "Config is valid")
+	return nil
+}
+
+// validation reports the result of each check.
+type validation struct {
+	out      io.Writer
+	failures int
+}
+
+func (v *validation) report(check string, err error) {
+	if err != nil {
+		v.failures++
+		fmt.Fprintf(v.out, "FAIL  %s: %s\n", check, err)
+		return
+	}
+	fmt.Fprintf(v.out, "ok    %s\n", check)
+}
+
+func (v *validation) warn(check, message string) {
+	fmt.Fprintf(v.out, "warn  %s: %s\n", check, message)
+}
+
+// renderedPolicy is a plan's policy template rendered for exampleBucket.
+type renderedPolicy struct {
+	name     string
+	document string
+	// bucket is set for bucket policies, and unset for the identity
+	// policies of bindings.
+	bucket bool
+}
+
+// checkPolicyTemplates renders the policy templates of every plan, and
+// returns those that rendered to valid JSON.
+func (v *validation) checkPolicyTemplates(config broker.Config) []renderedPolicy {
+	partition, _ := awspartition.Lookup(config.AwsPartition)
+	bucketARN := partition.ARN("s3", "", "", exampleBucket)
+
+	var policies []renderedPolicy
+	check := func(policy renderedPolicy, err error) {
+		if err == nil && !json.Valid([]byte(policy.document)) {
+			err = errors.New("rendered policy is not valid JSON")
+		}
+		v.report(policy.name, err)
+		if err == nil {
+			policies = append(policies, policy)
+		}
+	}
+	for _, plan := range config.Catalog.ListServicePlans() {
+		properties := plan.S3Properties
+		// Plans in other object stores have no IAM or bucket policies.
+		if properties.ObjectStore != "" {
+			continue
+		}
+		templates := []struct{ key, template string }{
+			{"iam_policy", properties.IamPolicy},
+			{"read_only_iam_policy", properties.ReadOnlyIamPolicy},
+			{"write_only_iam_policy", properties.WriteOnlyIamPolicy},
+		}
+		for _, t := range templates {
+			if t.template == "" {
+				continue
+			}
+			document, err := awsiam.RenderPolicy(t.template, []string{bucketARN}, "")
+			check(renderedPolicy{name: fmt.Sprintf("plan %s %s", plan.Name, t.key), document: document}, err)
+		}
+		if properties.BucketPolicy != "" {
+			document, err := awss3.RenderBucketPolicy(awss3.BucketDetails{
+				BucketName:   exampleBucket,
+				ARN:          bucketARN,
+				Region:       config.Region,
+				AwsPartition: config.AwsPartition,
+				Policy:       properties.BucketPolicy,
+			})
+			check(renderedPolicy{name: fmt.Sprintf("plan %s bucket_policy", plan.Name), document: document, bucket: true}, err)
+		}
+	}
+	return policies
+}
+
+// checkPolicyFindings validates the rendered policies with IAM Access
+// Analyzer. Errors and security warnings fail the check, and other findings
+// are reported as warnings.
+func (v *validation) checkPolicyFindings(ctx context.Context, analyzer accessanalyzeriface.AccessAnalyzerAPI, policies []renderedPolicy) {
+	for _, policy := range policies {
+		input := &accessanalyzer.ValidatePolicyInput{
+			PolicyDocument: aws.String(policy.document),
+			PolicyType:     aws.String(accessanalyzer.PolicyTypeIdentityPolicy),
+		}
+		if policy.bucket {
+			input.PolicyType = aws.String(accessanalyzer.PolicyTypeResourcePolicy)
+			input.ValidatePolicyResourceType = aws.String(accessanalyzer.ValidatePolicyResourceTypeAwsS3Bucket)
+		}
+
+		check := policy.name + " findings"
+		var problems []string
+		err := analyzer.ValidatePolicyPagesWithContext(ctx, input, func(page *accessanalyzer.ValidatePolicyOutput, _ bool) bool {
+			for _, finding := range page.Findings {
+				message := fmt.Sprintf("%s %s: %s", aws.StringValue(finding.FindingType), aws.StringValue(finding.IssueCode), aws.StringValue(finding.FindingDetails))
+				switch aws.StringValue(finding.FindingType) {
+				case accessanalyzer.ValidatePolicyFindingTypeError, accessanalyzer.ValidatePolicyFindingTypeSecurityWarning:
+					problems = append(problems, message)
+				default:
+					v.warn(check, message)
+				}
+			}
+			return true
+		})
+		if err == nil && len(problems) > 0 {
+			err = errors.New(strings.Join(problems, "; "))
+		}
+		v.report(check, err)
+	}
+}
+
+// checkPermissions checks that the broker's credentials in an account work,
+// and that IAM allows them every action that config needs.
+func (v *validation) checkPermissions(ctx context.Context, account string, config *Config, stssvc stsiface.STSAPI, iamsvc iamiface.IAMAPI) {
+	principal, err := callerPrincipal(ctx, stssvc, iamsvc)
+	v.report(account+" credentials", err)
+	if err != nil {
+		return
+	}
+	missing, err := missingPermissions(ctx, iamsvc, principal, requiredActions(config))
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("%s is not allowed %s", principal, strings.Join(missing, ", "))
+	}
+	v.report(account+" IAM permissions", err)
+}
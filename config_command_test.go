@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/accessanalyzer"
+	"github.com/aws/aws-sdk-go/service/accessanalyzer/accessanalyzeriface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+type mockAnalyzer struct {
+	accessanalyzeriface.AccessAnalyzerAPI
+	findings map[string][]*accessanalyzer.ValidatePolicyFinding
+	inputs   []*accessanalyzer.ValidatePolicyInput
+}
+
+func (a *mockAnalyzer) ValidatePolicyPagesWithContext(_ aws.Context, input *accessanalyzer.ValidatePolicyInput, fn func(*accessanalyzer.ValidatePolicyOutput, bool) bool, _ ...request.Option) error {
+	a.inputs = append(a.inputs, input)
+	fn(&accessanalyzer.ValidatePolicyOutput{Findings: a.findings[aws.StringValue(input.PolicyDocument)]}, true)
+	return nil
+}
+
+type mockSTS struct {
+	stsiface.STSAPI
+	arn string
+	err error
+}
+
+func (s *mockSTS) GetCallerIdentityWithContext(aws.Context, *sts.GetCallerIdentityInput, ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Arn: aws.String(s.arn)}, s.err
+}
+
+type mockIAM struct {
+	iamiface.IAMAPI
+	denied    []string
+	simulated string
+}
+
+func (m *mockIAM) GetRoleWithContext(_ aws.Context, input *iam.GetRoleInput, _ ...request.Option) (*iam.GetRoleOutput, error) {
+	return &iam.GetRoleOutput{Role: &iam.Role{Arn: aws.String("arn:aws:iam::123456789012:role/platform/" + aws.StringValue(input.RoleName))}}, nil
+}
+
+func (m *mockIAM) SimulatePrincipalPolicyPagesWithContext(_ aws.Context, input *iam.SimulatePrincipalPolicyInput, fn func(*iam.SimulatePolicyResponse, bool) bool, _ ...request.Option) error {
+	m.simulated = aws.StringValue(input.PolicySourceArn)
+	page := &iam.SimulatePolicyResponse{}
+	for _, action := range aws.StringValueSlice(input.ActionNames) {
+		decision := iam.PolicyEvaluationDecisionTypeAllowed
+		if slices.Contains(m.denied, action) {
+			decision = iam.PolicyEvaluationDecisionTypeImplicitDeny
+		}
+		page.EvaluationResults = append(page.EvaluationResults, &iam.EvaluationResult{EvalActionName: aws.String(action), EvalDecision: aws.String(decision)})
+	}
+	fn(page, true)
+	return nil
+}
+
+func planConfig(properties broker.S3Properties) broker.Config {
+	return broker.Config{
+		Region:       "us-east-1",
+		AwsPartition: "aws",
+		Catalog: broker.BrokerCatalog{Services: []broker.Service{{
+			ID:    "service1",
+			Name:  "s3",
+			Plans: []broker.ServicePlan{{ID: "plan1", Name: "basic", S3Properties: properties}},
+		}}},
+	}
+}
+
+func TestCheckPolicyTemplates(t *testing.T) {
+	testCases := map[string]struct {
+		properties     broker.S3Properties
+		expectOutput   []string
+		expectPolicies int
+	}{
+		"valid policies": {
+			properties: broker.S3Properties{
+				IamPolicy:    `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": {{resources "/*"}}}]}`,
+				BucketPolicy: `{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "{{.ARN}}/*", "Condition": {"Bool": {"aws:SecureTransport": "false"}}}]}`,
+			},
+			expectOutput:   []string{"ok    plan basic iam_policy", "ok    plan basic bucket_policy"},
+			expectPolicies: 2,
+		},
+		"template error": {
+			properties:   broker.S3Properties{IamPolicy: `{"Resource": {{resources}}}`},
+			expectOutput: []string{"FAIL  plan basic iam_policy: "},
+		},
+		"unknown bucket field": {
+			properties:     broker.S3Properties{IamPolicy: "{}", BucketPolicy: `{"Resource": "{{.Bucket}}"}`},
+			expectOutput:   []string{"ok    plan basic iam_policy", "FAIL  plan basic bucket_policy: "},
+			expectPolicies: 1,
+		},
+		"invalid JSON": {
+			properties:   broker.S3Properties{IamPolicy: `{"Resource": {{.Resource}}}`},
+			expectOutput: []string{"FAIL  plan basic iam_policy: rendered policy is not valid JSON"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			v := &validation{out: out}
+			policies := v.checkPolicyTemplates(planConfig(tc.properties))
+			for _, line := range tc.expectOutput {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("expected output to contain %q, got:\n%s", line, out)
+				}
+			}
+			if len(policies) != tc.expectPolicies {
+				t.Errorf("expected %d rendered policies, got %d", tc.expectPolicies, len(policies))
+			}
+		})
+	}
+}
+
+func TestCheckPolicyFindings(t *testing.T) {
+	analyzer := &mockAnalyzer{findings: map[string][]*accessanalyzer.ValidatePolicyFinding{
+		"identity": {{FindingType: aws.String("SUGGESTION"), IssueCode: aws.String("EMPTY_ARRAY_RESOURCE"), FindingDetails: aws.String("Remove the empty array.")}},
+		"bucket":   {{FindingType: aws.String("SECURITY_WARNING"), IssueCode: aws.String("PASS_ROLE_WITH_STAR_IN_RESOURCE"), FindingDetails: aws.String("Limit the resource.")}},
+	}}
+	out := &bytes.Buffer{}
+	v := &validation{out: out}
+	v.checkPolicyFindings(context.Background(), analyzer, []renderedPolicy{
+		{name: "plan basic iam_policy", document: "identity"},
+		{name: "plan basic bucket_policy", document: "bucket", bucket: true},
+	})
+
+	for _, line := range []string{
+		"warn  plan basic iam_policy findings: SUGGESTION EMPTY_ARRAY_RESOURCE: Remove the empty array.",
+		"ok    plan basic iam_policy findings",
+		"FAIL  plan basic bucket_policy findings: SECURITY_WARNING PASS_ROLE_WITH_STAR_IN_RESOURCE: Limit the resource.",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected output to contain %q, got:\n%s", line, out)
+		}
+	}
+	if v.failures != 1 {
+		t.Errorf("expected 1 failure, got %d", v.failures)
+	}
+	if policyType := aws.StringValue(analyzer.inputs[1].PolicyType); policyType != accessanalyzer.PolicyTypeResourcePolicy {
+		t.Errorf("expected the bucket policy to be validated as a resource policy, got %s", policyType)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	testCases := map[string]struct {
+		callerARN       string
+		callerErr       error
+		denied          []string
+		config          *Config
+		expectSimulated string
+		expectOutput    []string
+	}{
+		"user with every permission": {
+			callerARN:       "arn:aws:iam::123456789012:user/s3-broker",
+			expectSimulated: "arn:aws:iam::123456789012:user/s3-broker",
+			expectOutput:    []string{"ok    broker account credentials", "ok    broker account IAM permissions"},
+		},
+		"assumed role": {
+			callerARN:       "arn:aws:sts::123456789012:assumed-role/s3-broker/session",
+			expectSimulated: "arn:aws:iam::123456789012:role/platform/s3-broker",
+			expectOutput:    []string{"ok    broker account IAM permissions"},
+		},
+		"missing permissions": {
+			callerARN:    "arn:aws:iam::123456789012:user/s3-broker",
+			denied:       []string{"iam:CreateUser", "s3:PutBucketPolicy"},
+			expectOutput: []string{"FAIL  broker account IAM permissions: arn:aws:iam::123456789012:user/s3-broker is not allowed s3:PutBucketPolicy, iam:CreateUser"},
+		},
+		"permissions the config does not need": {
+			callerARN:    "arn:aws:iam::123456789012:user/s3-broker",
+			denied:       []string{"iam:CreateGroup", "secretsmanager:CreateSecret"},
+			expectOutput: []string{"ok    broker account IAM permissions"},
+		},
+		"permissions the config needs": {
+			callerARN:    "arn:aws:iam::123456789012:user/s3-broker",
+			denied:       []string{"iam:CreateGroup"},
+			config:       &Config{S3Config: broker.Config{UseInstanceGroups: true}},
+			expectOutput: []string{"FAIL  broker account IAM permissions: arn:aws:iam::123456789012:user/s3-broker is not allowed iam:CreateGroup"},
+		},
+		"invalid credentials": {
+			callerErr:    errors.New("InvalidClientTokenId"),
+			expectOutput: []string{"FAIL  broker account credentials: InvalidClientTokenId"},
+		},
+		"federated user": {
+			callerARN:    "arn:aws:sts::123456789012:federated-user/s3-broker",
+			expectOutput: []string{"FAIL  broker account credentials: cannot simulate the policies of arn:aws:sts::123456789012:federated-user/s3-broker"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			config := tc.config
+			if config == nil {
+				config = &Config{}
+			}
+			iamsvc := &mockIAM{denied: tc.denied}
+			out := &bytes.Buffer{}
+			v := &validation{out: out}
+			v.checkPermissions(context.Background(), "broker account", config, &mockSTS{arn: tc.callerARN, err: tc.callerErr}, iamsvc)
+			for _, line := range tc.expectOutput {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("expected output to contain %q, got:\n%s", line, out)
+				}
+			}
+			if tc.expectSimulated != "" && iamsvc.simulated != tc.expectSimulated {
+				t.Errorf("expected the policies of %s to be simulated, got %s", tc.expectSimulated, iamsvc.simulated)
+			}
+		})
+	}
+}
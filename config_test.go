@@ -1,12 +1,16 @@
 package main_test
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloud-gov/s3-broker"
 
 	"github.com/cloud-gov/s3-broker/broker"
+	"github.com/cloud-gov/s3-broker/state"
+	"github.com/cloud-gov/s3-broker/tokenauth"
 )
 
 var _ = Describe("Config", func() {
@@ -18,7 +22,7 @@ var _ = Describe("Config", func() {
 			Username: "broker-username",
 			Password: "broker-password",
 			S3Config: broker.Config{
-				Region:       "s3-region",
+				Region:       "us-east-1",
 				UserPrefix:   "cf",
 				PolicyPrefix: "cf",
 				BucketPrefix: "cf",
@@ -53,6 +57,14 @@ var _ = Describe("Config", func() {
 			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty Username"))
 		})
 
+		It("returns error if the TLS key file is missing", func() {
+			config.TLS = TLSConfig{CertFile: "/etc/broker/tls.crt"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating TLS configuration: Must provide both CertFile and KeyFile"))
+		})
+
 		It("returns error if Password is not valid", func() {
 			config.Password = ""
 
@@ -61,6 +73,92 @@ var _ = Describe("Config", func() {
 			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty Password"))
 		})
 
+		It("does not require Username and Password if bearer tokens are accepted", func() {
+			config.Username = ""
+			config.Password = ""
+			config.OAuth2 = tokenauth.Config{
+				Issuer:  "https://uaa.example.com/oauth/token",
+				KeysURL: "https://uaa.example.com/token_keys",
+				Scopes:  []string{"s3-broker.admin"},
+			}
+
+			err := config.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error if Password is missing when bearer tokens are accepted", func() {
+			config.Password = ""
+			config.OAuth2 = tokenauth.Config{
+				Issuer:  "https://uaa.example.com/oauth/token",
+				KeysURL: "https://uaa.example.com/token_keys",
+				Scopes:  []string{"s3-broker.admin"},
+			}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty Password"))
+		})
+
+		It("does not require Username and Password if Credentials are configured", func() {
+			config.Username = ""
+			config.Password = ""
+			config.Credentials = []BrokerCredential{{Username: "broker", Password: "new-password"}}
+
+			err := config.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error if a credential has no Password", func() {
+			config.Credentials = []BrokerCredential{{Username: "broker"}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Credential 0 must have a non-empty Username and Password"))
+		})
+
+		It("returns error if the credentials file is reloaded without a Path", func() {
+			config.CredentialsFile = CredentialsFileConfig{ReloadInterval: time.Minute}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating credentials file configuration: Must provide a Path to reload"))
+		})
+
+		It("returns error if PermissionCheck is not valid", func() {
+			config.PermissionCheck = "sometimes"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`PermissionCheck must be one of "warn", "fail" or "off", got "sometimes"`))
+		})
+
+		It("returns error if the limits configuration is not valid", func() {
+			config.Limits = LimitsConfig{RequestsPerSecond: -1}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating limits configuration: RequestsPerSecond must not be negative"))
+		})
+
+		It("returns error if a trusted proxy is not an IP address or CIDR range", func() {
+			config.Limits = LimitsConfig{TrustedProxies: []string{"10.0.0.0/8", "proxy.example.com"}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`Validating limits configuration: TrustedProxies: "proxy.example.com" is not an IP address or CIDR range`))
+		})
+
+		It("returns error if the OAuth2 configuration has no Scopes", func() {
+			config.OAuth2 = tokenauth.Config{
+				Issuer:  "https://uaa.example.com/oauth/token",
+				KeysURL: "https://uaa.example.com/token_keys",
+			}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating OAuth2 configuration: Must provide the Scopes that tokens need"))
+		})
+
 		It("returns error if S3 configuration is not valid", func() {
 			config.S3Config = broker.Config{}
 
@@ -68,5 +166,30 @@ var _ = Describe("Config", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("Validating S3 configuration"))
 		})
+
+		It("returns error if state configuration is not valid", func() {
+			config.State = state.Config{Backend: state.BackendPostgres}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Validating state configuration"))
+		})
+
+		It("returns error if the state encryption key is in CredHub but CredHub is not configured", func() {
+			config.State = state.Config{Backend: state.BackendMemory, Encryption: state.EncryptionConfig{CredHubName: "/s3-broker/state-key"}}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must configure CredHub to read the state encryption key from it"))
+		})
+
+		It("returns error if leader election is enabled without a shared state store", func() {
+			config.S3Config.LeaderElection = broker.LeaderElectionConfig{Enabled: true}
+			config.State = state.Config{Backend: state.BackendMemory}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a shared state Backend to elect a leader in"))
+		})
 	})
 })
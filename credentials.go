@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// BrokerCredential is a username and password that the platform may use to
+// call the broker API.
+type BrokerCredential struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// CredentialsFileConfig is a YAML file listing more BrokerCredentials, such
+// as a mounted Kubernetes Secret. The platform's credential is rotated by
+// adding the new credential to the file, switching the platform to it, and
+// then removing the old one.
+type CredentialsFileConfig struct {
+	Path string `yaml:"path"`
+	// ReloadInterval is how often the broker checks the file for changes,
+	// and reloads it if it changed. Zero disables reloading.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+func (c CredentialsFileConfig) Enabled() bool {
+	return c.Path != ""
+}
+
+func (c CredentialsFileConfig) Validate() error {
+	if c.ReloadInterval < 0 {
+		return errors.New("ReloadInterval must not be negative")
+	}
+	if c.ReloadInterval > 0 && c.Path == "" {
+		return errors.New("Must provide a Path to reload")
+	}
+	return nil
+}
+
+func validateCredentials(credentials []BrokerCredential) error {
+	for idx, credential := range credentials {
+		if credential.Username == "" || credential.Password == "" {
+			return fmt.Errorf("Credential %d must have a non-empty Username and Password", idx)
+		}
+	}
+	return nil
+}
+
+// credentialDigest is a credential's hashed username and password, which
+// have the same length whatever was configured, so they can be compared in
+// constant time.
+type credentialDigest struct {
+	username [sha256.Size]byte
+	password [sha256.Size]byte
+}
+
+func digestCredentials(credentials []BrokerCredential) []credentialDigest {
+	digests := make([]credentialDigest, 0, len(credentials))
+	for _, credential := range credentials {
+		digests = append(digests, credentialDigest{
+			username: sha256.Sum256([]byte(credential.Username)),
+			password: sha256.Sum256([]byte(credential.Password)),
+		})
+	}
+	return digests
+}
+
+// brokerCredentials checks requests' basic-auth credentials against the
+// configured ones and those in the credentials file, reloading the file when
+// it changes. Unlike the brokerapi wrapper, a username may have several
+// passwords, so that a password can be rotated without an outage.
+type brokerCredentials struct {
+	file   CredentialsFileConfig
+	static []credentialDigest
+	logger lager.Logger
+
+	mu      sync.RWMutex
+	loaded  []credentialDigest
+	modTime time.Time
+}
+
+func newBrokerCredentials(config *Config, logger lager.Logger) (*brokerCredentials, error) {
+	c := &brokerCredentials{
+		file:   config.CredentialsFile,
+		static: configCredentials(config),
+		logger: logger.Session("credentials"),
+	}
+	if c.file.Enabled() {
+		if _, err := c.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func configCredentials(config *Config) []credentialDigest {
+	credentials := config.Credentials
+	if config.Username != "" {
+		credentials = append([]BrokerCredential{{Username: config.Username, Password: config.Password}}, credentials...)
+	}
+	return digestCredentials(credentials)
+}
+
+// setStatic replaces the credentials from the config file with those of a
+// reloaded config. The credentials file's path is only read on startup.
+func (c *brokerCredentials) setStatic(config *Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.static = configCredentials(config)
+}
+
+// Empty reports whether no credentials are configured, so that basic auth
+// is not accepted at all.
+func (c *brokerCredentials) Empty() bool {
+	return len(c.static) == 0 && !c.file.Enabled()
+}
+
+// Wrap rejects requests without one of the credentials.
+func (c *brokerCredentials) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.authorized(r) {
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *brokerCredentials) authorized(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	request := credentialDigest{
+		username: sha256.Sum256([]byte(username)),
+		password: sha256.Sum256([]byte(password)),
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	matched := 0
+	for _, digests := range [][]credentialDigest{c.static, c.loaded} {
+		for _, digest := range digests {
+			matched |= subtle.ConstantTimeCompare(digest.username[:], request.username[:]) &
+				subtle.ConstantTimeCompare(digest.password[:], request.password[:])
+		}
+	}
+	return matched == 1
+}
+
+// reload reads the credentials file again if it changed since it was last
+// read, and reports whether it did.
+func (c *brokerCredentials) reload() (bool, error) {
+	info, err := os.Stat(c.file.Path)
+	if err != nil {
+		return false, err
+	}
+	c.mu.RLock()
+	unchanged := c.loaded != nil && info.ModTime() == c.modTime
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(c.file.Path)
+	if err != nil {
+		return false, err
+	}
+	var credentials []BrokerCredential
+	if err := yaml.Unmarshal(data, &credentials); err != nil {
+		return false, fmt.Errorf("Parsing %s: %s", c.file.Path, err)
+	}
+	if len(credentials) == 0 {
+		return false, fmt.Errorf("%s contains no credentials", c.file.Path)
+	}
+	if err := validateCredentials(credentials); err != nil {
+		return false, fmt.Errorf("%s: %s", c.file.Path, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loaded, c.modTime = digestCredentials(credentials), info.ModTime()
+	return true, nil
+}
+
+// Run reloads the credentials file every ReloadInterval until ctx is done.
+// A file that fails to load is logged and the previous credentials are kept,
+// so a bad edit cannot lock the platform out.
+func (c *brokerCredentials) Run(ctx context.Context) {
+	if c.file.ReloadInterval == 0 {
+		return
+	}
+	ticker := time.NewTicker(c.file.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.reload()
+			if err != nil {
+				c.logger.Error("reload", err)
+			} else if reloaded {
+				c.logger.Info("reloaded", lager.Data{"path": c.file.Path})
+			}
+		}
+	}
+}
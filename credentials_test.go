@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+func writeCredentials(t *testing.T, path, contents string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func authorizedAs(c *brokerCredentials, username, password string) bool {
+	r := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+	r.SetBasicAuth(username, password)
+	w := httptest.NewRecorder()
+	c.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, r)
+	return w.Code == http.StatusOK
+}
+
+func TestBrokerCredentials(t *testing.T) {
+	config := &Config{
+		Username:    "broker",
+		Password:    "old-password",
+		Credentials: []BrokerCredential{{Username: "broker", Password: "new-password"}},
+	}
+	c, err := newBrokerCredentials(config, lager.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		username string
+		password string
+		expectOK bool
+	}{
+		"old password": {
+			username: "broker",
+			password: "old-password",
+			expectOK: true,
+		},
+		"new password": {
+			username: "broker",
+			password: "new-password",
+			expectOK: true,
+		},
+		"other password": {
+			username: "broker",
+			password: "wrong",
+		},
+		"password of another user": {
+			username: "other",
+			password: "new-password",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if ok := authorizedAs(c, tc.username, tc.password); ok != tc.expectOK {
+				t.Errorf("expected authorized %t, got %t", tc.expectOK, ok)
+			}
+		})
+	}
+}
+
+func TestBrokerCredentialsReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yml")
+	start := time.Now().Add(-time.Hour)
+	writeCredentials(t, path, "- username: broker\n  password: old-password\n", start)
+
+	c, err := newBrokerCredentials(&Config{CredentialsFile: CredentialsFileConfig{Path: path}}, lager.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !authorizedAs(c, "broker", "old-password") {
+		t.Fatal("expected the old password to be accepted")
+	}
+
+	if reloaded, err := c.reload(); err != nil || reloaded {
+		t.Fatalf("expected an unchanged file not to be reloaded, got %t, %v", reloaded, err)
+	}
+
+	// During a rotation both passwords are accepted.
+	writeCredentials(t, path, "- username: broker\n  password: old-password\n- username: broker\n  password: new-password\n", start.Add(time.Minute))
+	if reloaded, err := c.reload(); err != nil || !reloaded {
+		t.Fatalf("expected the file to be reloaded, got %t, %v", reloaded, err)
+	}
+	if !authorizedAs(c, "broker", "old-password") || !authorizedAs(c, "broker", "new-password") {
+		t.Fatal("expected both passwords to be accepted")
+	}
+
+	// A broken file keeps the previous credentials.
+	writeCredentials(t, path, "- username: broker\n", start.Add(2*time.Minute))
+	if _, err := c.reload(); err == nil {
+		t.Fatal("expected a credential without a password to fail to load")
+	}
+	if !authorizedAs(c, "broker", "new-password") {
+		t.Fatal("expected the previous credentials to be kept")
+	}
+
+	writeCredentials(t, path, "- username: broker\n  password: new-password\n", start.Add(3*time.Minute))
+	if _, err := c.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if authorizedAs(c, "broker", "old-password") {
+		t.Fatal("expected the old password to be rejected once removed")
+	}
+	if !authorizedAs(c, "broker", "new-password") {
+		t.Fatal("expected the new password to be accepted")
+	}
+}
+
+func TestNewBrokerCredentialsEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yml")
+	writeCredentials(t, path, "", time.Now())
+	if _, err := newBrokerCredentials(&Config{CredentialsFile: CredentialsFileConfig{Path: path}}, lager.NewLogger("test")); err == nil {
+		t.Fatal("expected an empty credentials file to fail to load")
+	}
+}
+
+func TestBrokerCredentialsSetStatic(t *testing.T) {
+	c, err := newBrokerCredentials(&Config{Username: "broker", Password: "old-password"}, lager.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.setStatic(&Config{Username: "broker", Password: "new-password"})
+	if authorizedAs(c, "broker", "old-password") {
+		t.Error("expected the old password to be rejected after a reload")
+	}
+	if !authorizedAs(c, "broker", "new-password") {
+		t.Error("expected the new password to be accepted after a reload")
+	}
+}
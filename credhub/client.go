@@ -0,0 +1,199 @@
+// Package credhub stores binding credentials in CredHub, so that they reach
+// apps through a credhub-ref instead of in plain text in VCAP_SERVICES.
+package credhub
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Store keeps JSON credentials under a name and controls who can read them.
+type Store interface {
+	Put(ctx context.Context, name string, value any) error
+	Exists(ctx context.Context, name string) (bool, error)
+	Delete(ctx context.Context, name string) error
+	AddPermission(ctx context.Context, name, actor string, operations []string) error
+}
+
+type Config struct {
+	// URL of the CredHub API. CredHub is disabled when it is empty.
+	URL string `yaml:"url"`
+	// UAAURL is the UAA that issues the broker's CredHub tokens.
+	UAAURL       string `yaml:"uaa_url"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// CACert is a PEM bundle trusted in addition to the system roots, for
+	// CredHub and UAA.
+	CACert string `yaml:"ca_cert"`
+}
+
+func (c Config) Enabled() bool {
+	return c.URL != ""
+}
+
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.UAAURL == "" {
+		return errors.New("Must provide a non-empty UAAURL")
+	}
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return errors.New("Must provide a non-empty ClientID and ClientSecret")
+	}
+	return nil
+}
+
+type Client struct {
+	url        string
+	httpClient *http.Client
+	logger     lager.Logger
+}
+
+// NewClient returns a client that authenticates to CredHub with a UAA
+// client credentials token.
+func NewClient(config Config, logger lager.Logger) (*Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(config.CACert)) {
+			return nil, errors.New("CACert contains no PEM certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	tokenConfig := clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		TokenURL:     strings.TrimSuffix(config.UAAURL, "/") + "/oauth/token",
+	}
+	// Token requests go through the same transport as CredHub requests.
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+
+	return &Client{
+		url:        strings.TrimSuffix(config.URL, "/"),
+		httpClient: tokenConfig.Client(ctx),
+		logger:     logger.Session("credhub"),
+	}, nil
+}
+
+// Put sets the JSON credential called name to value, replacing any previous
+// value.
+func (c *Client) Put(ctx context.Context, name string, value any) error {
+	body := map[string]any{
+		"name":  name,
+		"type":  "json",
+		"value": value,
+	}
+	c.logger.Debug("put", lager.Data{"name": name})
+	_, err := c.do(ctx, http.MethodPut, "/api/v1/data", body, nil, http.StatusOK)
+	return err
+}
+
+// Exists reports whether there is a credential called name.
+func (c *Client) Exists(ctx context.Context, name string) (bool, error) {
+	c.logger.Debug("exists", lager.Data{"name": name})
+	status, err := c.do(ctx, http.MethodGet, "/api/v1/data?current=true&name="+url.QueryEscape(name), nil, nil, http.StatusOK, http.StatusNotFound)
+	return status == http.StatusOK, err
+}
+
+// Get decodes the current value of the credential called name into value.
+func (c *Client) Get(ctx context.Context, name string, value any) error {
+	var response struct {
+		Data []struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"data"`
+	}
+	c.logger.Debug("get", lager.Data{"name": name})
+	if _, err := c.do(ctx, http.MethodGet, "/api/v1/data?current=true&name="+url.QueryEscape(name), nil, &response, http.StatusOK); err != nil {
+		return err
+	}
+	if len(response.Data) == 0 {
+		return fmt.Errorf("CredHub credential %s has no value", name)
+	}
+	return json.Unmarshal(response.Data[0].Value, value)
+}
+
+// Delete deletes the credential called name. A credential that does not
+// exist is already deleted.
+func (c *Client) Delete(ctx context.Context, name string) error {
+	c.logger.Debug("delete", lager.Data{"name": name})
+	_, err := c.do(ctx, http.MethodDelete, "/api/v1/data?name="+url.QueryEscape(name), nil, nil, http.StatusNoContent, http.StatusNotFound)
+	return err
+}
+
+// AddPermission lets actor, such as "mtls-app:<app guid>", perform operations
+// on the credential called name. An existing permission is left as it is.
+func (c *Client) AddPermission(ctx context.Context, name, actor string, operations []string) error {
+	body := map[string]any{
+		"path":       name,
+		"actor":      actor,
+		"operations": operations,
+	}
+	c.logger.Debug("add-permission", lager.Data{"name": name, "actor": actor})
+	_, err := c.do(ctx, http.MethodPost, "/api/v2/permissions", body, nil, http.StatusOK, http.StatusCreated, http.StatusConflict)
+	return err
+}
+
+// do sends a request and returns the response status if it is one of
+// expectStatus, decoding the response into result if it is not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, result any, expectStatus ...int) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logger.Error("credhub-error", err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	for _, status := range expectStatus {
+		if resp.StatusCode == status {
+			if result != nil {
+				if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+					return status, err
+				}
+			}
+			return status, nil
+		}
+	}
+
+	var credhubErr struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&credhubErr)
+	err = fmt.Errorf("CredHub %s %s returned %d: %s", method, strings.SplitN(path, "?", 2)[0], resp.StatusCode, credhubErr.Error)
+	c.logger.Error("credhub-error", err)
+	return resp.StatusCode, err
+}
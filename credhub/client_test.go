@@ -0,0 +1,192 @@
+package credhub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+type fakeCredHub struct {
+	credentials map[string]json.RawMessage
+	permissions map[string][]string
+	tokens      int
+}
+
+func newFakeCredHub(t *testing.T) (*fakeCredHub, *Client) {
+	fake := &fakeCredHub{
+		credentials: make(map[string]json.RawMessage),
+		permissions: make(map[string][]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		fake.tokens++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "token", "token_type": "bearer", "expires_in": 3600}`))
+	})
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+	mux.HandleFunc("PUT /api/v1/data", authorized(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Name  string          `json:"name"`
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Type != "json" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid credential"}`))
+			return
+		}
+		fake.credentials[body.Name] = body.Value
+		w.Write([]byte(`{}`))
+	}))
+	mux.HandleFunc("GET /api/v1/data", authorized(func(w http.ResponseWriter, r *http.Request) {
+		value, ok := fake.credentials[r.URL.Query().Get("name")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data": [{"type": "json", "value": ` + string(value) + `}]}`))
+	}))
+	mux.HandleFunc("DELETE /api/v1/data", authorized(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if _, ok := fake.credentials[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(fake.credentials, name)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("POST /api/v2/permissions", authorized(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Path  string `json:"path"`
+			Actor string `json:"actor"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for _, actor := range fake.permissions[body.Path] {
+			if actor == body.Actor {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		}
+		fake.permissions[body.Path] = append(fake.permissions[body.Path], body.Actor)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(Config{
+		URL:          server.URL,
+		UAAURL:       server.URL,
+		ClientID:     "s3-broker",
+		ClientSecret: "secret",
+	}, lager.NewLogger("credhub-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, client
+}
+
+func TestClient(t *testing.T) {
+	fake, client := newFakeCredHub(t)
+	ctx := context.Background()
+	name := "/c/s3-broker/service1/binding1/credentials"
+
+	if err := client.Put(ctx, name, map[string]string{"access_key_id": "key"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(fake.credentials[name]) != `{"access_key_id":"key"}` {
+		t.Errorf("unexpected credential %s", fake.credentials[name])
+	}
+
+	if exists, err := client.Exists(ctx, name); err != nil || !exists {
+		t.Errorf("expected credential to exist, got %t, %v", exists, err)
+	}
+
+	var value map[string]string
+	if err := client.Get(ctx, name, &value); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if value["access_key_id"] != "key" {
+		t.Errorf("unexpected value %v", value)
+	}
+
+	for range 2 {
+		if err := client.AddPermission(ctx, name, "mtls-app:app1", []string{"read"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if actors := fake.permissions[name]; len(actors) != 1 || actors[0] != "mtls-app:app1" {
+		t.Errorf("unexpected permissions %v", actors)
+	}
+
+	for range 2 {
+		if err := client.Delete(ctx, name); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if _, ok := fake.credentials[name]; ok {
+		t.Errorf("expected credential to be deleted")
+	}
+	if exists, err := client.Exists(ctx, name); err != nil || exists {
+		t.Errorf("expected credential not to exist, got %t, %v", exists, err)
+	}
+
+	if fake.tokens != 1 {
+		t.Errorf("expected one token request, got %d", fake.tokens)
+	}
+}
+
+func TestClientError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": "The request could not be completed because the credential does not exist or you do not have sufficient authorization."}`))
+	}))
+	t.Cleanup(server.Close)
+	client := &Client{url: server.URL, httpClient: server.Client(), logger: lager.NewLogger("credhub-test")}
+
+	err := client.Put(context.Background(), "/c/s3-broker/service1/binding1/credentials", map[string]string{})
+	expectErr := "CredHub PUT /api/v1/data returned 403: The request could not be completed because the credential does not exist or you do not have sufficient authorization."
+	if err == nil || err.Error() != expectErr {
+		t.Errorf("expected error %q, got %v", expectErr, err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		config    Config
+		expectErr bool
+	}{
+		"disabled": {},
+		"complete": {
+			config: Config{URL: "https://credhub", UAAURL: "https://uaa", ClientID: "id", ClientSecret: "secret"},
+		},
+		"missing UAA": {
+			config:    Config{URL: "https://credhub", ClientID: "id", ClientSecret: "secret"},
+			expectErr: true,
+		},
+		"missing client secret": {
+			config:    Config{URL: "https://credhub", UAAURL: "https://uaa", ClientID: "id"},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.config.Validate(); (err != nil) != tc.expectErr {
+				t.Errorf("expected error %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
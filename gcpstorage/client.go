@@ -0,0 +1,482 @@
+// Package gcpstorage provisions buckets in Google Cloud Storage. Each binding
+// has a service account with an HMAC key, which S3 clients can use with the
+// Cloud Storage XML API.
+package gcpstorage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+
+	"code.cloudfoundry.org/lager/v3"
+	"golang.org/x/oauth2/jwt"
+
+	"github.com/cloud-gov/s3-broker/objectstore"
+)
+
+const (
+	DefaultLocation   = "US"
+	DefaultStorageURL = "https://storage.googleapis.com"
+	DefaultIAMURL     = "https://iam.googleapis.com"
+
+	// Endpoint is the host of the Cloud Storage XML API, which is
+	// compatible with S3.
+	Endpoint = "storage.googleapis.com"
+)
+
+const (
+	scope = "https://www.googleapis.com/auth/cloud-platform"
+
+	objectAdminRole   = "roles/storage.objectAdmin"
+	objectViewerRole  = "roles/storage.objectViewer"
+	objectCreatorRole = "roles/storage.objectCreator"
+
+	// serviceAccountPrefix starts the IDs of binding service accounts,
+	// which are followed by a hash of the binding ID to fit the 30
+	// characters that IDs can have.
+	serviceAccountPrefix = "s3b-"
+
+	// maxLabelLength is the length of the longest label key or value.
+	maxLabelLength = 63
+
+	// maxPolicyAttempts is how many times a bucket's IAM policy is read and
+	// written when it is changed concurrently.
+	maxPolicyAttempts = 3
+)
+
+// accessRoles are the roles on a bucket that give each access to its objects.
+var accessRoles = map[objectstore.Access]string{
+	objectstore.ReadWrite: objectAdminRole,
+	objectstore.ReadOnly:  objectViewerRole,
+	objectstore.WriteOnly: objectCreatorRole,
+}
+
+type Config struct {
+	// ProjectID is the project that buckets and service accounts are
+	// created in. Cloud Storage plans are disabled when it is empty.
+	ProjectID string `yaml:"project_id"`
+	// Location of new buckets. Defaults to DefaultLocation.
+	Location string `yaml:"location"`
+	// CredentialsJSON is the JSON key of the service account that the
+	// broker manages buckets and service accounts with.
+	CredentialsJSON string `yaml:"credentials_json"`
+	// StorageURL and IAMURL are the bases of the Cloud Storage JSON API and
+	// the IAM API. They default to DefaultStorageURL and DefaultIAMURL.
+	StorageURL string `yaml:"storage_url"`
+	IAMURL     string `yaml:"iam_url"`
+}
+
+func (c Config) Enabled() bool {
+	return c.ProjectID != ""
+}
+
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.CredentialsJSON == "" {
+		return errors.New("Must provide a non-empty CredentialsJSON")
+	}
+	if _, err := parseCredentials(c.CredentialsJSON); err != nil {
+		return fmt.Errorf("CredentialsJSON is not a service account key: %s", err)
+	}
+	for name, value := range map[string]string{"StorageURL": c.StorageURL, "IAMURL": c.IAMURL} {
+		if value == "" {
+			continue
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s must be an absolute URL, got %q", name, value)
+		}
+	}
+	return nil
+}
+
+// serviceAccountKey is the part of a service account's JSON key that the
+// broker signs token requests with.
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+func parseCredentials(credentialsJSON string) (serviceAccountKey, error) {
+	var key serviceAccountKey
+	if err := json.Unmarshal([]byte(credentialsJSON), &key); err != nil {
+		return key, err
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return key, errors.New("must have type service_account, a client_email and a private_key")
+	}
+	return key, nil
+}
+
+// Client implements objectstore.BucketProvider with the Cloud Storage JSON
+// API and the IAM API.
+type Client struct {
+	projectID  string
+	location   string
+	storageURL string
+	iamURL     string
+	httpClient *http.Client
+	logger     lager.Logger
+}
+
+var _ objectstore.BucketProvider = (*Client)(nil)
+
+// NewClient returns a client that authenticates with the service account key
+// in config.
+func NewClient(config Config, logger lager.Logger) (*Client, error) {
+	key, err := parseCredentials(config.CredentialsJSON)
+	if err != nil {
+		return nil, fmt.Errorf("CredentialsJSON is not a service account key: %s", err)
+	}
+	tokenConfig := jwt.Config{
+		Email:        key.ClientEmail,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.PrivateKeyID,
+		Scopes:       []string{scope},
+		TokenURL:     key.TokenURI,
+	}
+	return &Client{
+		projectID:  config.ProjectID,
+		location:   cmp.Or(config.Location, DefaultLocation),
+		storageURL: strings.TrimSuffix(cmp.Or(config.StorageURL, DefaultStorageURL), "/"),
+		iamURL:     strings.TrimSuffix(cmp.Or(config.IAMURL, DefaultIAMURL), "/"),
+		httpClient: tokenConfig.Client(context.Background()),
+		logger:     logger.Session("gcpstorage"),
+	}, nil
+}
+
+func (c *Client) CreateBucket(ctx context.Context, bucketName string, labels map[string]string) error {
+	body := map[string]any{
+		"name":     bucketName,
+		"location": c.location,
+		"labels":   toLabels(labels),
+		// Access is granted to whole buckets, so object ACLs are
+		// disabled.
+		"iamConfiguration": map[string]any{
+			"uniformBucketLevelAccess": map[string]any{"enabled": true},
+			"publicAccessPrevention":   "enforced",
+		},
+	}
+	c.logger.Debug("create-bucket", lager.Data{"bucket": bucketName})
+	status, err := c.do(ctx, http.MethodPost, c.storageURL+"/storage/v1/b?project="+url.QueryEscape(c.projectID), body, nil, http.StatusOK, http.StatusConflict)
+	if status == http.StatusConflict {
+		return objectstore.ErrBucketExists
+	}
+	return err
+}
+
+func (c *Client) SetLabels(ctx context.Context, bucketName string, labels map[string]string) error {
+	// A patch merges labels into the bucket's.
+	body := map[string]any{"labels": toLabels(labels)}
+	c.logger.Debug("set-labels", lager.Data{"bucket": bucketName})
+	status, err := c.do(ctx, http.MethodPatch, c.bucketURL(bucketName), body, nil, http.StatusOK, http.StatusNotFound)
+	if status == http.StatusNotFound {
+		return objectstore.ErrBucketNotFound
+	}
+	return err
+}
+
+func (c *Client) DeleteBucket(ctx context.Context, bucketName string) error {
+	var objects struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	c.logger.Debug("delete-bucket", lager.Data{"bucket": bucketName})
+	status, err := c.do(ctx, http.MethodGet, c.bucketURL(bucketName)+"/o?maxResults=1", nil, &objects, http.StatusOK, http.StatusNotFound)
+	if status == http.StatusNotFound {
+		return objectstore.ErrBucketNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if len(objects.Items) > 0 {
+		return objectstore.ErrBucketNotEmpty
+	}
+
+	status, err = c.do(ctx, http.MethodDelete, c.bucketURL(bucketName), nil, nil, http.StatusNoContent, http.StatusNotFound, http.StatusConflict)
+	switch status {
+	case http.StatusNotFound:
+		return objectstore.ErrBucketNotFound
+	case http.StatusConflict:
+		// Objects were written since the bucket was listed.
+		return objectstore.ErrBucketNotEmpty
+	}
+	return err
+}
+
+// CreateCredentials creates a service account for the binding, with an HMAC
+// key, and grants it access to the bucket's objects. A service account that
+// already exists, such as from a binding that failed part way, is reused.
+func (c *Client) CreateCredentials(ctx context.Context, bucketName, bindingID string, access objectstore.Access) (objectstore.Credentials, error) {
+	role, ok := accessRoles[access]
+	if !ok {
+		return objectstore.Credentials{}, fmt.Errorf("unknown access %q", access)
+	}
+
+	accountID := serviceAccountID(bindingID)
+	body := map[string]any{
+		"accountId": accountID,
+		"serviceAccount": map[string]any{
+			"displayName": "s3-broker binding",
+			"description": fmt.Sprintf("Binding %s to bucket %s", bindingID, bucketName),
+		},
+	}
+	c.logger.Debug("create-service-account", lager.Data{"binding": bindingID, "account": accountID})
+	if _, err := c.do(ctx, http.MethodPost, c.serviceAccountsURL(), body, nil, http.StatusOK, http.StatusConflict); err != nil {
+		return objectstore.Credentials{}, err
+	}
+	email := c.serviceAccountEmail(accountID)
+
+	var key struct {
+		Secret   string `json:"secret"`
+		Metadata struct {
+			AccessID string `json:"accessId"`
+		} `json:"metadata"`
+	}
+	c.logger.Debug("create-hmac-key", lager.Data{"binding": bindingID})
+	if _, err := c.do(ctx, http.MethodPost, c.hmacKeysURL()+"?serviceAccountEmail="+url.QueryEscape(email), nil, &key, http.StatusOK); err != nil {
+		return objectstore.Credentials{}, err
+	}
+
+	member := "serviceAccount:" + email
+	err := c.updateBucketPolicy(ctx, bucketName, func(policy *bucketPolicy) {
+		policy.add(role, member)
+	})
+	if err != nil {
+		return objectstore.Credentials{}, err
+	}
+
+	bucketURL := "https://" + Endpoint + "/" + bucketName
+	return objectstore.Credentials{
+		Endpoint:        Endpoint,
+		Region:          strings.ToLower(c.location),
+		Bucket:          bucketName,
+		BucketURL:       bucketURL,
+		URI:             fmt.Sprintf("s3://%s:%s@%s/%s", url.QueryEscape(key.Metadata.AccessID), url.QueryEscape(key.Secret), Endpoint, bucketName),
+		AccessKeyID:     key.Metadata.AccessID,
+		SecretAccessKey: key.Secret,
+	}, nil
+}
+
+// DeleteCredentials deletes the HMAC keys of the binding's service account,
+// removes the account from the bucket's IAM policy and deletes it.
+func (c *Client) DeleteCredentials(ctx context.Context, bucketName, bindingID string) error {
+	email := c.serviceAccountEmail(serviceAccountID(bindingID))
+
+	var keys struct {
+		Items []struct {
+			AccessID string `json:"accessId"`
+			State    string `json:"state"`
+		} `json:"items"`
+	}
+	c.logger.Debug("delete-hmac-keys", lager.Data{"binding": bindingID})
+	if _, err := c.do(ctx, http.MethodGet, c.hmacKeysURL()+"?serviceAccountEmail="+url.QueryEscape(email), nil, &keys, http.StatusOK); err != nil {
+		return err
+	}
+	for _, key := range keys.Items {
+		keyURL := c.hmacKeysURL() + "/" + url.PathEscape(key.AccessID)
+		// Only inactive keys can be deleted.
+		if key.State == "ACTIVE" {
+			if _, err := c.do(ctx, http.MethodPut, keyURL, map[string]any{"state": "INACTIVE"}, nil, http.StatusOK); err != nil {
+				return err
+			}
+		}
+		if _, err := c.do(ctx, http.MethodDelete, keyURL, nil, nil, http.StatusOK, http.StatusNoContent, http.StatusNotFound); err != nil {
+			return err
+		}
+	}
+
+	member := "serviceAccount:" + email
+	err := c.updateBucketPolicy(ctx, bucketName, func(policy *bucketPolicy) {
+		policy.remove(member)
+	})
+	if err != nil && !errors.Is(err, objectstore.ErrBucketNotFound) {
+		return err
+	}
+
+	c.logger.Debug("delete-service-account", lager.Data{"binding": bindingID})
+	status, err := c.do(ctx, http.MethodDelete, c.serviceAccountsURL()+"/"+url.PathEscape(email), nil, nil, http.StatusOK, http.StatusNotFound)
+	if status == http.StatusNotFound {
+		return objectstore.ErrCredentialsNotFound
+	}
+	return err
+}
+
+type bucketPolicy struct {
+	Version  int             `json:"version,omitempty"`
+	Bindings []policyBinding `json:"bindings"`
+	Etag     string          `json:"etag,omitempty"`
+}
+
+type policyBinding struct {
+	Role    string   `json:"role"`
+	Members []string `json:"members"`
+}
+
+func (p *bucketPolicy) add(role, member string) {
+	for i, binding := range p.Bindings {
+		if binding.Role == role {
+			if !slices.Contains(binding.Members, member) {
+				p.Bindings[i].Members = append(binding.Members, member)
+			}
+			return
+		}
+	}
+	p.Bindings = append(p.Bindings, policyBinding{Role: role, Members: []string{member}})
+}
+
+func (p *bucketPolicy) remove(member string) {
+	for i := range p.Bindings {
+		p.Bindings[i].Members = slices.DeleteFunc(p.Bindings[i].Members, func(m string) bool { return m == member })
+	}
+	p.Bindings = slices.DeleteFunc(p.Bindings, func(binding policyBinding) bool {
+		return len(binding.Members) == 0
+	})
+}
+
+// updateBucketPolicy changes the bucket's IAM policy with change. The policy's
+// etag makes the write fail if the policy changed since it was read, in which
+// case it is read and changed again.
+func (c *Client) updateBucketPolicy(ctx context.Context, bucketName string, change func(*bucketPolicy)) error {
+	policyURL := c.bucketURL(bucketName) + "/iam"
+	for attempt := 1; ; attempt++ {
+		var policy bucketPolicy
+		status, err := c.do(ctx, http.MethodGet, policyURL, nil, &policy, http.StatusOK, http.StatusNotFound)
+		if status == http.StatusNotFound {
+			return objectstore.ErrBucketNotFound
+		}
+		if err != nil {
+			return err
+		}
+		change(&policy)
+		status, err = c.do(ctx, http.MethodPut, policyURL, policy, nil, http.StatusOK, http.StatusPreconditionFailed)
+		if status != http.StatusPreconditionFailed {
+			return err
+		}
+		if attempt == maxPolicyAttempts {
+			return fmt.Errorf("IAM policy of bucket %s changed concurrently %d times", bucketName, attempt)
+		}
+	}
+}
+
+func (c *Client) bucketURL(bucketName string) string {
+	return c.storageURL + "/storage/v1/b/" + url.PathEscape(bucketName)
+}
+
+func (c *Client) hmacKeysURL() string {
+	return c.storageURL + "/storage/v1/projects/" + url.PathEscape(c.projectID) + "/hmacKeys"
+}
+
+func (c *Client) serviceAccountsURL() string {
+	return c.iamURL + "/v1/projects/" + url.PathEscape(c.projectID) + "/serviceAccounts"
+}
+
+func (c *Client) serviceAccountEmail(accountID string) string {
+	return accountID + "@" + c.projectID + ".iam.gserviceaccount.com"
+}
+
+// serviceAccountID returns the ID of a binding's service account.
+func serviceAccountID(bindingID string) string {
+	sum := sha256.Sum256([]byte(bindingID))
+	return serviceAccountPrefix + hex.EncodeToString(sum[:])[:30-len(serviceAccountPrefix)]
+}
+
+// toLabels adapts tags to the rules of Cloud Storage labels, whose keys and
+// values have only lowercase letters, digits, underscores and dashes, and
+// whose keys start with a letter.
+func toLabels(tags map[string]string) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for key, value := range tags {
+		key = labelValue(key)
+		if key == "" {
+			continue
+		}
+		if first := rune(key[0]); !unicode.IsLetter(first) {
+			key = "x" + key
+		}
+		labels[truncate(key)] = labelValue(value)
+	}
+	return labels
+}
+
+func labelValue(value string) string {
+	return truncate(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return unicode.ToLower(r)
+		default:
+			return '_'
+		}
+	}, value))
+}
+
+func truncate(value string) string {
+	if len(value) > maxLabelLength {
+		return value[:maxLabelLength]
+	}
+	return value
+}
+
+// do sends a request and returns the response status if it is one of
+// expectStatus, decoding the response into result if it is not nil.
+func (c *Client) do(ctx context.Context, method, requestURL string, body, result any, expectStatus ...int) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logger.Error("gcp-error", err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if slices.Contains(expectStatus, resp.StatusCode) {
+		if result != nil && resp.StatusCode < http.StatusMultipleChoices {
+			if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+				return resp.StatusCode, err
+			}
+		}
+		return resp.StatusCode, nil
+	}
+
+	var gcpErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&gcpErr)
+	path := strings.SplitN(requestURL, "?", 2)[0]
+	err = fmt.Errorf("Google Cloud %s %s returned %d: %s", method, path, resp.StatusCode, gcpErr.Error.Message)
+	c.logger.Error("gcp-error", err)
+	return resp.StatusCode, err
+}
@@ -0,0 +1,362 @@
+package gcpstorage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cloud-gov/s3-broker/objectstore"
+)
+
+type fakeGCP struct {
+	buckets         map[string]map[string]string
+	objects         map[string]int
+	policies        map[string]*bucketPolicy
+	serviceAccounts map[string]bool
+	hmacKeys        map[string]string
+	policyConflicts int
+}
+
+func newFakeGCP(t *testing.T) (*fakeGCP, *Client) {
+	fake := &fakeGCP{
+		buckets:         make(map[string]map[string]string),
+		objects:         make(map[string]int),
+		policies:        make(map[string]*bucketPolicy),
+		serviceAccounts: make(map[string]bool),
+		hmacKeys:        make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "token", "token_type": "Bearer", "expires_in": 3600}`))
+	})
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+	mux.HandleFunc("POST /storage/v1/b", authorized(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Name   string            `json:"name"`
+			Labels map[string]string `json:"labels"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Query().Get("project") != "project" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, ok := fake.buckets[body.Name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		fake.buckets[body.Name] = body.Labels
+		fake.policies[body.Name] = &bucketPolicy{}
+		w.Write([]byte(`{}`))
+	}))
+	mux.HandleFunc("PATCH /storage/v1/b/{bucket}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		labels, ok := fake.buckets[r.PathValue("bucket")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Labels map[string]string `json:"labels"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for key, value := range body.Labels {
+			labels[key] = value
+		}
+		w.Write([]byte(`{}`))
+	}))
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/o", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := fake.buckets[r.PathValue("bucket")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if fake.objects[r.PathValue("bucket")] > 0 {
+			w.Write([]byte(`{"items": [{"name": "object"}]}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	mux.HandleFunc("DELETE /storage/v1/b/{bucket}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		delete(fake.buckets, r.PathValue("bucket"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/iam", authorized(func(w http.ResponseWriter, r *http.Request) {
+		policy, ok := fake.policies[r.PathValue("bucket")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(policy)
+	}))
+	mux.HandleFunc("PUT /storage/v1/b/{bucket}/iam", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if fake.policyConflicts > 0 {
+			fake.policyConflicts--
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		var policy bucketPolicy
+		json.NewDecoder(r.Body).Decode(&policy)
+		fake.policies[r.PathValue("bucket")] = &policy
+		json.NewEncoder(w).Encode(policy)
+	}))
+	mux.HandleFunc("POST /v1/projects/project/serviceAccounts", authorized(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			AccountID string `json:"accountId"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if fake.serviceAccounts[body.AccountID] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		fake.serviceAccounts[body.AccountID] = true
+		w.Write([]byte(`{}`))
+	}))
+	mux.HandleFunc("DELETE /v1/projects/project/serviceAccounts/{email}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		accountID, _, _ := strings.Cut(r.PathValue("email"), "@")
+		if !fake.serviceAccounts[accountID] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(fake.serviceAccounts, accountID)
+		w.Write([]byte(`{}`))
+	}))
+	mux.HandleFunc("POST /storage/v1/projects/project/hmacKeys", authorized(func(w http.ResponseWriter, r *http.Request) {
+		accessID := "GOOG" + r.URL.Query().Get("serviceAccountEmail")
+		fake.hmacKeys[accessID] = "ACTIVE"
+		json.NewEncoder(w).Encode(map[string]any{"secret": "secret", "metadata": map[string]any{"accessId": accessID}})
+	}))
+	mux.HandleFunc("GET /storage/v1/projects/project/hmacKeys", authorized(func(w http.ResponseWriter, r *http.Request) {
+		var items []map[string]string
+		for accessID, state := range fake.hmacKeys {
+			if accessID == "GOOG"+r.URL.Query().Get("serviceAccountEmail") {
+				items = append(items, map[string]string{"accessId": accessID, "state": state})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"items": items})
+	}))
+	mux.HandleFunc("PUT /storage/v1/projects/project/hmacKeys/{accessID}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			State string `json:"state"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		fake.hmacKeys[r.PathValue("accessID")] = body.State
+		w.Write([]byte(`{}`))
+	}))
+	mux.HandleFunc("DELETE /storage/v1/projects/project/hmacKeys/{accessID}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if fake.hmacKeys[r.PathValue("accessID")] != "INACTIVE" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delete(fake.hmacKeys, r.PathValue("accessID"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(Config{
+		ProjectID:       "project",
+		CredentialsJSON: serviceAccountKeyJSON(t, server.URL+"/token"),
+		StorageURL:      server.URL,
+		IAMURL:          server.URL,
+	}, lager.NewLogger("test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return fake, client
+}
+
+func serviceAccountKeyJSON(t *testing.T, tokenURI string) string {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := json.Marshal(serviceAccountKey{
+		Type:        "service_account",
+		ClientEmail: "broker@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    tokenURI,
+	})
+	return string(key)
+}
+
+func TestBuckets(t *testing.T) {
+	fake, client := newFakeGCP(t)
+	ctx := context.Background()
+
+	if err := client.CreateBucket(ctx, "bucket", map[string]string{"Instance GUID": "ABC-123", "2fa": "yes"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(map[string]string{"instance_guid": "abc-123", "x2fa": "yes"}, fake.buckets["bucket"]); diff != "" {
+		t.Errorf("unexpected labels (-want +got):\n%s", diff)
+	}
+	if err := client.CreateBucket(ctx, "bucket", nil); !errors.Is(err, objectstore.ErrBucketExists) {
+		t.Errorf("expected ErrBucketExists, got %v", err)
+	}
+
+	if err := client.SetLabels(ctx, "bucket", map[string]string{"Updated at": "2024-01-02T03:04:05Z"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if label := fake.buckets["bucket"]["updated_at"]; label != "2024-01-02t03_04_05z" {
+		t.Errorf("unexpected label %q", label)
+	}
+
+	fake.objects["bucket"] = 1
+	if err := client.DeleteBucket(ctx, "bucket"); !errors.Is(err, objectstore.ErrBucketNotEmpty) {
+		t.Errorf("expected ErrBucketNotEmpty, got %v", err)
+	}
+	fake.objects["bucket"] = 0
+	if err := client.DeleteBucket(ctx, "bucket"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := client.DeleteBucket(ctx, "bucket"); !errors.Is(err, objectstore.ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	testCases := map[string]struct {
+		access          objectstore.Access
+		policyConflicts int
+		expectRole      string
+		expectErr       bool
+	}{
+		"read-write": {
+			access:     objectstore.ReadWrite,
+			expectRole: objectAdminRole,
+		},
+		"read-only": {
+			access:     objectstore.ReadOnly,
+			expectRole: objectViewerRole,
+		},
+		"write-only": {
+			access:     objectstore.WriteOnly,
+			expectRole: objectCreatorRole,
+		},
+		"unknown access": {
+			access:    "admin",
+			expectErr: true,
+		},
+		"concurrent policy change": {
+			access:          objectstore.ReadWrite,
+			policyConflicts: 1,
+			expectRole:      objectAdminRole,
+		},
+		"policy keeps changing": {
+			access:          objectstore.ReadWrite,
+			policyConflicts: maxPolicyAttempts,
+			expectErr:       true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake, client := newFakeGCP(t)
+			ctx := context.Background()
+			if err := client.CreateBucket(ctx, "bucket", nil); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			fake.policyConflicts = tc.policyConflicts
+
+			credentials, err := client.CreateCredentials(ctx, "bucket", "binding-id", tc.access)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			email := serviceAccountID("binding-id") + "@project.iam.gserviceaccount.com"
+			expected := objectstore.Credentials{
+				Endpoint:        Endpoint,
+				Region:          "us",
+				Bucket:          "bucket",
+				BucketURL:       "https://storage.googleapis.com/bucket",
+				URI:             "s3://GOOG" + strings.ReplaceAll(email, "@", "%40") + ":secret@storage.googleapis.com/bucket",
+				AccessKeyID:     "GOOG" + email,
+				SecretAccessKey: "secret",
+			}
+			if diff := cmp.Diff(expected, credentials); diff != "" {
+				t.Errorf("unexpected credentials (-want +got):\n%s", diff)
+			}
+			expectPolicy := &bucketPolicy{Bindings: []policyBinding{{Role: tc.expectRole, Members: []string{"serviceAccount:" + email}}}}
+			if diff := cmp.Diff(expectPolicy, fake.policies["bucket"]); diff != "" {
+				t.Errorf("unexpected bucket policy (-want +got):\n%s", diff)
+			}
+
+			if err := client.DeleteCredentials(ctx, "bucket", "binding-id"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(fake.hmacKeys) > 0 || len(fake.serviceAccounts) > 0 || len(fake.policies["bucket"].Bindings) > 0 {
+				t.Errorf("expected the binding's key, service account and policy binding to be deleted, got %v, %v, %v", fake.hmacKeys, fake.serviceAccounts, fake.policies["bucket"])
+			}
+			if err := client.DeleteCredentials(ctx, "bucket", "binding-id"); !errors.Is(err, objectstore.ErrCredentialsNotFound) {
+				t.Errorf("expected ErrCredentialsNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestServiceAccountID(t *testing.T) {
+	id := serviceAccountID("c2f1f7b3-2b1e-4a1c-9a6e-3f6f1f0c8d2e")
+	if len(id) != 30 || !strings.HasPrefix(id, serviceAccountPrefix) {
+		t.Errorf("expected a 30 character ID starting with %s, got %q", serviceAccountPrefix, id)
+	}
+	if other := serviceAccountID("another-binding"); other == id {
+		t.Errorf("expected different bindings to have different service accounts")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	key := serviceAccountKeyJSON(t, "https://oauth2.googleapis.com/token")
+	testCases := map[string]struct {
+		config    Config
+		expectErr bool
+	}{
+		"disabled":          {},
+		"valid":             {config: Config{ProjectID: "project", CredentialsJSON: key}},
+		"no credentials":    {config: Config{ProjectID: "project"}, expectErr: true},
+		"invalid key":       {config: Config{ProjectID: "project", CredentialsJSON: `{"type": "authorized_user"}`}, expectErr: true},
+		"relative base URL": {config: Config{ProjectID: "project", CredentialsJSON: key, StorageURL: "storage"}, expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestToLabels(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	labels := toLabels(map[string]string{long: long, "": "dropped"})
+	if len(labels) != 1 || labels[long[:maxLabelLength]] != long[:maxLabelLength] {
+		t.Errorf("expected one truncated label, got %v", labels)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+const (
+	// readinessTimeout bounds each readiness check, so that a hung
+	// dependency fails the check rather than the probe.
+	readinessTimeout = 5 * time.Second
+	// readinessCacheTTL is how long a readiness result is reused, so that
+	// frequent probes from several load balancers do not each call AWS.
+	readinessCacheTTL = 5 * time.Second
+)
+
+// readinessInstanceID is looked up to check that the state store is
+// reachable. No instance has it, so a reachable store reports it missing.
+const readinessInstanceID = "readiness-check"
+
+// serveHealthz reports that the process is alive and serving requests.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readiness reports whether the broker can serve requests: its state store
+// is reachable and its AWS credentials work.
+type readiness struct {
+	checks []readinessCheck
+	logger lager.Logger
+	now    func() time.Time
+
+	mu       sync.Mutex
+	checked  time.Time
+	response readinessResponse
+}
+
+// newReadiness checks store, unless the broker keeps no state, and the
+// broker's AWS credentials with stssvc, unless stssvc is nil.
+func newReadiness(store state.Store, stssvc stsiface.STSAPI, logger lager.Logger) *readiness {
+	r := &readiness{logger: logger.Session("readiness"), now: time.Now}
+	if store != nil {
+		r.checks = append(r.checks, readinessCheck{name: "state", check: func(ctx context.Context) error {
+			_, err := store.GetInstance(ctx, readinessInstanceID)
+			if errors.Is(err, state.ErrInstanceNotFound) {
+				return nil
+			}
+			return err
+		}})
+	}
+	if stssvc != nil {
+		r.checks = append(r.checks, readinessCheck{name: "aws", check: func(ctx context.Context) error {
+			_, err := stssvc.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+			return err
+		}})
+	}
+	return r
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	response := r.check(req.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// check runs the checks, or returns their result from the last
+// readinessCacheTTL. Errors are logged rather than returned, because the
+// endpoint is unauthenticated.
+func (r *readiness) check(ctx context.Context) readinessResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.checked.IsZero() && r.now().Sub(r.checked) < readinessCacheTTL {
+		return r.response
+	}
+
+	// A probe that gives up must not cache a failure for the next one.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readinessTimeout)
+	defer cancel()
+	response := readinessResponse{Status: "ready", Checks: map[string]string{}}
+	for _, c := range r.checks {
+		if err := c.check(ctx); err != nil {
+			r.logger.Error("check-failed", err, lager.Data{"check": c.name})
+			response.Status = "not ready"
+			response.Checks[c.name] = "failed"
+			continue
+		}
+		response.Checks[c.name] = "ok"
+	}
+	r.checked, r.response = r.now(), response
+	return response
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+type failingStore struct {
+	state.Store
+	err error
+}
+
+func (s failingStore) GetInstance(context.Context, string) (state.Instance, error) {
+	return state.Instance{}, s.err
+}
+
+func TestServeHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	serveHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	testCases := map[string]struct {
+		store        state.Store
+		sts          stsiface.STSAPI
+		expectCode   int
+		expectChecks map[string]string
+	}{
+		"ready": {
+			store:        state.NewMemoryStore(),
+			sts:          &mockSTS{arn: "arn:aws:iam::123456789012:user/s3-broker"},
+			expectCode:   http.StatusOK,
+			expectChecks: map[string]string{"state": "ok", "aws": "ok"},
+		},
+		"no state store or STS": {
+			expectCode:   http.StatusOK,
+			expectChecks: map[string]string{},
+		},
+		"state store unreachable": {
+			store:        failingStore{err: errors.New("connection refused")},
+			sts:          &mockSTS{arn: "arn:aws:iam::123456789012:user/s3-broker"},
+			expectCode:   http.StatusServiceUnavailable,
+			expectChecks: map[string]string{"state": "failed", "aws": "ok"},
+		},
+		"AWS credentials rejected": {
+			store:        state.NewMemoryStore(),
+			sts:          &mockSTS{err: errors.New("ExpiredToken")},
+			expectCode:   http.StatusServiceUnavailable,
+			expectChecks: map[string]string{"state": "ok", "aws": "failed"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			readiness := newReadiness(tc.store, tc.sts, lagertest.NewTestLogger("test"))
+			rec := httptest.NewRecorder()
+			readiness.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tc.expectCode {
+				t.Errorf("expected %d, got %d", tc.expectCode, rec.Code)
+			}
+			var response readinessResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(response.Checks, tc.expectChecks) {
+				t.Errorf("expected checks %v, got %v", tc.expectChecks, response.Checks)
+			}
+		})
+	}
+}
+
+func TestReadinessCache(t *testing.T) {
+	now := time.Now()
+	stssvc := &mockSTS{err: errors.New("ExpiredToken")}
+	readiness := newReadiness(nil, stssvc, lagertest.NewTestLogger("test"))
+	readiness.now = func() time.Time { return now }
+
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		readiness.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", code)
+	}
+
+	stssvc.err = nil
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected the cached 503 within the cache TTL, got %d", code)
+	}
+
+	now = now.Add(readinessCacheTTL)
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("expected 200 once the cache expired, got %d", code)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+)
+
+// defaultMaxBodyBytes is far more than any OSB request needs, parameters
+// included.
+const defaultMaxBodyBytes = 1 << 20
+
+// LimitsConfig protects the broker, and the AWS API quotas it spends, from
+// platforms that send too many or too large requests.
+type LimitsConfig struct {
+	// RequestsPerSecond is how many requests each client may make per
+	// second, on average. Zero disables rate limiting.
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// Burst is how many requests a client may make at once. It defaults to
+	// RequestsPerSecond, rounded up.
+	Burst int `yaml:"burst"`
+	// MaxBodyBytes is the largest request body the broker reads. It
+	// defaults to 1 MiB.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// TrustedProxies are the IP addresses or CIDR ranges of proxies in
+	// front of the broker, whose X-Forwarded-For headers name the clients.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+func (c LimitsConfig) Validate() error {
+	if c.RequestsPerSecond < 0 {
+		return errors.New("RequestsPerSecond must not be negative")
+	}
+	if c.Burst < 0 {
+		return errors.New("Burst must not be negative")
+	}
+	if c.MaxBodyBytes < 0 {
+		return errors.New("MaxBodyBytes must not be negative")
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parseProxy(proxy); err != nil {
+			return fmt.Errorf("TrustedProxies: %q is not an IP address or CIDR range", proxy)
+		}
+	}
+	return nil
+}
+
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		return netip.ParsePrefix(proxy)
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// limiter rate limits each client, told apart by broker username or IP
+// address, with a token bucket, and rejects request bodies over the maximum
+// size.
+type limiter struct {
+	rate           float64
+	burst          float64
+	maxBodyBytes   int64
+	trustedProxies []netip.Prefix
+	// authenticated reports whether a request's basic-auth credentials are
+	// the broker's. It may be nil.
+	authenticated func(*http.Request) bool
+	logger        lager.Logger
+	now           func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newLimiter(config LimitsConfig, authenticated func(*http.Request) bool, logger lager.Logger) *limiter {
+	l := &limiter{
+		rate:          config.RequestsPerSecond,
+		burst:         float64(config.Burst),
+		maxBodyBytes:  config.MaxBodyBytes,
+		authenticated: authenticated,
+		logger:        logger.Session("limits"),
+		now:           time.Now,
+		buckets:       map[string]*tokenBucket{},
+	}
+	for _, proxy := range config.TrustedProxies {
+		if prefix, err := parseProxy(proxy); err == nil {
+			l.trustedProxies = append(l.trustedProxies, prefix)
+		}
+	}
+	if l.burst == 0 {
+		l.burst = math.Ceil(l.rate)
+	}
+	if l.maxBodyBytes == 0 {
+		l.maxBodyBytes = defaultMaxBodyBytes
+	}
+	return l
+}
+
+// Wrap limits the requests to next.
+func (l *limiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > l.maxBodyBytes {
+			l.reject(w, http.StatusRequestEntityTooLarge, "Request body is larger than "+strconv.FormatInt(l.maxBodyBytes, 10)+" bytes.")
+			return
+		}
+		if l.rate > 0 {
+			client := l.client(r)
+			if ok, wait := l.allow(client); !ok {
+				l.logger.Info("rate-limited", lager.Data{"client": client, "path": r.URL.Path})
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				l.reject(w, http.StatusTooManyRequests, "Too many requests. Retry after the time in the Retry-After header.")
+				return
+			}
+		}
+		// Bodies without a Content-Length are cut off at the limit, and
+		// fail to decode.
+		r.Body = http.MaxBytesReader(w, r.Body, l.maxBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *limiter) reject(w http.ResponseWriter, status int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiresponses.ErrorResponse{Description: description})
+}
+
+// allow takes a token from client's bucket, or reports how long until the
+// bucket has one.
+func (l *limiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep forgets, at most once a minute, the clients whose buckets have
+// refilled, since a new bucket would be just the same.
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// client names the client that made r: the broker username it authenticated
+// with, or else its IP address. Requests with bearer tokens, which are only
+// verified later, are told apart by IP address.
+func (l *limiter) client(r *http.Request) string {
+	if username, _, ok := r.BasicAuth(); ok && l.authenticated != nil && l.authenticated(r) {
+		return "user " + username
+	}
+	return l.clientIP(r)
+}
+
+// clientIP returns the IP address that r came from. A request from a trusted
+// proxy came from the last address in its X-Forwarded-For header that is not
+// itself a trusted proxy, since clients can forge the addresses before it.
+func (l *limiter) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !l.trusted(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !l.trusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func (l *limiter) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, proxy := range l.trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
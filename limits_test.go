@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3/lagertest"
+)
+
+func TestLimiterRateLimit(t *testing.T) {
+	now := time.Now()
+	limiter := newLimiter(LimitsConfig{RequestsPerSecond: 2, Burst: 3}, nil, lagertest.NewTestLogger("test"))
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/v2/service_instances/instance", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := range 3 {
+		if rec := serve("10.0.0.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("expected request %d within the burst to be allowed, got %d", i, rec.Code)
+		}
+	}
+	rec := serve("10.0.0.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 after the burst, got %d", rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("expected Retry-After 1, got %q", retryAfter)
+	}
+	if !strings.Contains(rec.Body.String(), `"description":"Too many requests.`) {
+		t.Errorf("expected an OSB error body, got %s", rec.Body)
+	}
+
+	if rec := serve("10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("expected another client to be allowed, got %d", rec.Code)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if rec := serve("10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("expected a request to be allowed once a token was added, got %d", rec.Code)
+	}
+	if rec := serve("10.0.0.1:1234"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 once the token was spent, got %d", rec.Code)
+	}
+
+	now = now.Add(time.Hour)
+	serve("10.0.0.3:1234")
+	if len(limiter.buckets) != 1 {
+		t.Errorf("expected idle clients to be forgotten, got %d buckets", len(limiter.buckets))
+	}
+}
+
+func TestLimiterClient(t *testing.T) {
+	authenticated := func(r *http.Request) bool {
+		username, password, _ := r.BasicAuth()
+		return username == "broker" && password == "secret"
+	}
+	limiter := newLimiter(LimitsConfig{RequestsPerSecond: 1, TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}}, authenticated, lagertest.NewTestLogger("test"))
+
+	testCases := map[string]struct {
+		remoteAddr   string
+		forwardedFor []string
+		username     string
+		password     string
+		expectClient string
+	}{
+		"direct": {
+			remoteAddr:   "203.0.113.1:1234",
+			expectClient: "203.0.113.1",
+		},
+		"forwarded by an untrusted address": {
+			remoteAddr:   "203.0.113.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			expectClient: "203.0.113.1",
+		},
+		"forwarded by a trusted proxy": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			expectClient: "198.51.100.1",
+		},
+		"forwarded by several trusted proxies": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.9, 198.51.100.1", "192.168.1.1"},
+			expectClient: "198.51.100.1",
+		},
+		"forwarded by a trusted proxy as IPv4-mapped IPv6": {
+			remoteAddr:   "[::ffff:10.0.0.1]:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			expectClient: "198.51.100.1",
+		},
+		"only trusted proxies": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"10.0.0.2"},
+			expectClient: "10.0.0.2",
+		},
+		"authenticated": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			username:     "broker",
+			password:     "secret",
+			expectClient: "user broker",
+		},
+		"wrong password": {
+			remoteAddr:   "203.0.113.1:1234",
+			username:     "broker",
+			password:     "guess",
+			expectClient: "203.0.113.1",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/v2/service_instances/instance", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, forwardedFor := range tc.forwardedFor {
+				req.Header.Add("X-Forwarded-For", forwardedFor)
+			}
+			if tc.username != "" {
+				req.SetBasicAuth(tc.username, tc.password)
+			}
+			if client := limiter.client(req); client != tc.expectClient {
+				t.Errorf("expected client %q, got %q", tc.expectClient, client)
+			}
+		})
+	}
+}
+
+func TestLimiterBodySize(t *testing.T) {
+	var readErr error
+	limiter := newLimiter(LimitsConfig{MaxBodyBytes: 10}, nil, lagertest.NewTestLogger("test"))
+	handler := limiter.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	testCases := map[string]struct {
+		body          io.Reader
+		expectCode    int
+		expectReadErr bool
+	}{
+		"small body": {
+			body:       strings.NewReader(`{"a": 1}`),
+			expectCode: http.StatusOK,
+		},
+		"large body": {
+			body:       strings.NewReader(`{"parameters": {}}`),
+			expectCode: http.StatusRequestEntityTooLarge,
+		},
+		"large body without a length": {
+			body:          io.MultiReader(strings.NewReader(`{"parameters": {}}`)),
+			expectCode:    http.StatusOK,
+			expectReadErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			readErr = nil
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/v2/service_instances/instance", tc.body))
+			if rec.Code != tc.expectCode {
+				t.Errorf("expected %d, got %d", tc.expectCode, rec.Code)
+			}
+			if (readErr != nil) != tc.expectReadErr {
+				t.Errorf("expected read error %t, got %v", tc.expectReadErr, readErr)
+			}
+		})
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	handler := newLimiter(LimitsConfig{}, nil, lagertest.NewTestLogger("test")).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for range 100 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/catalog", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected requests to be unlimited, got %d", rec.Code)
+		}
+	}
+}
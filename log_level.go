@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+// maxLogLevelDuration bounds how long a changed log level lasts, so that a
+// forgotten DEBUG level does not flood the logs for days.
+const maxLogLevelDuration = 24 * time.Hour
+
+// logLevelHandler serves /admin/log-level. GET responds with the log level,
+// and PUT changes it without a restart, to capture DEBUG output of a
+// misbehaving request in production. A change with a duration reverts to
+// the configured level when the duration has passed. Changes and reverts are
+// written to the audit trail with audit.
+type logLevelHandler struct {
+	mu         sync.Mutex
+	sink       *lager.ReconfigurableSink
+	configured lager.LogLevel
+	revert     *time.Timer
+	revertsAt  time.Time
+	audit      func(context.Context, broker.AuditEvent, error)
+	logger     lager.Logger
+	now        func() time.Time
+	afterFunc  func(time.Duration, func()) *time.Timer
+}
+
+func newLogLevelHandler(sink *lager.ReconfigurableSink, audit func(context.Context, broker.AuditEvent, error), logger lager.Logger) *logLevelHandler {
+	return &logLevelHandler{
+		sink:       sink,
+		configured: sink.GetMinLevel(),
+		audit:      audit,
+		logger:     logger.Session("log-level"),
+		now:        time.Now,
+		afterFunc:  time.AfterFunc,
+	}
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+	// Duration is how long the level lasts, such as 15m. Without it, the
+	// level lasts until it is changed again.
+	Duration string `json:"duration"`
+}
+
+type logLevelResponse struct {
+	Level           string     `json:"level"`
+	ConfiguredLevel string     `json:"configured_level"`
+	RevertsAt       *time.Time `json:"reverts_at,omitempty"`
+}
+
+func (h *logLevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var request logLevelRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("could not parse request: %s", err), http.StatusBadRequest)
+			return
+		}
+		if err := h.setLevel(request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		username, _, _ := r.BasicAuth()
+		h.audit(r.Context(), broker.AuditEvent{
+			Event: broker.Event{Action: "set-log-level", User: username},
+			Details: map[string]any{
+				"level":    strings.ToUpper(request.Level),
+				"duration": request.Duration,
+			},
+		}, nil)
+	}
+
+	h.mu.Lock()
+	response := logLevelResponse{
+		Level:           strings.ToUpper(h.sink.GetMinLevel().String()),
+		ConfiguredLevel: strings.ToUpper(h.configured.String()),
+	}
+	if h.revert != nil {
+		response.RevertsAt = &h.revertsAt
+	}
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *logLevelHandler) setLevel(request logLevelRequest) error {
+	level, ok := logLevels[strings.ToUpper(request.Level)]
+	if !ok {
+		return fmt.Errorf("level must be one of DEBUG, INFO, ERROR or FATAL, got %q", request.Level)
+	}
+	var duration time.Duration
+	if request.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 || duration > maxLogLevelDuration {
+			return fmt.Errorf("duration must be a positive duration of at most %s, such as 15m, got %q", maxLogLevelDuration, request.Duration)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.revert != nil {
+		h.revert.Stop()
+		h.revert = nil
+	}
+	h.sink.SetMinLevel(level)
+	h.logger.Info("changed", lager.Data{"level": level.String(), "duration": duration.String()})
+	if duration > 0 {
+		h.revertsAt = h.now().Add(duration)
+		var revert *time.Timer
+		revert = h.afterFunc(duration, func() { h.revertLevel(revert) })
+		h.revert = revert
+	}
+	return nil
+}
+
+// revertLevel sets the configured level again, unless the level was changed
+// since revert was set.
+func (h *logLevelHandler) revertLevel(revert *time.Timer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.revert != revert {
+		return
+	}
+	h.revert = nil
+	h.sink.SetMinLevel(h.configured)
+	h.logger.Info("reverted", lager.Data{"level": h.configured.String()})
+	h.audit(context.Background(), broker.AuditEvent{
+		Event:   broker.Event{Action: "revert-log-level"},
+		Details: map[string]any{"level": strings.ToUpper(h.configured.String())},
+	}, nil)
+}
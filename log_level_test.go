@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	testCases := map[string]struct {
+		body            string
+		expectStatus    int
+		expectLevel     string
+		expectRevertsAt bool
+	}{
+		"raise": {
+			body:         `{"level": "debug"}`,
+			expectStatus: http.StatusOK,
+			expectLevel:  "DEBUG",
+		},
+		"raise for a while": {
+			body:            `{"level": "DEBUG", "duration": "15m"}`,
+			expectStatus:    http.StatusOK,
+			expectLevel:     "DEBUG",
+			expectRevertsAt: true,
+		},
+		"lower": {
+			body:         `{"level": "error"}`,
+			expectStatus: http.StatusOK,
+			expectLevel:  "ERROR",
+		},
+		"unknown level": {
+			body:         `{"level": "trace"}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		"duration too long": {
+			body:         `{"level": "debug", "duration": "72h"}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		"not JSON": {
+			body:         `debug`,
+			expectStatus: http.StatusBadRequest,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			sink := lager.NewReconfigurableSink(lagertest.NewTestSink(), lager.INFO)
+			var audited []broker.AuditEvent
+			handler := newLogLevelHandler(sink, func(ctx context.Context, event broker.AuditEvent, err error) {
+				audited = append(audited, event)
+			}, lagertest.NewTestLogger("test"))
+
+			rec := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPut, "/admin/log-level", strings.NewReader(tc.body))
+			request.SetBasicAuth("operator", "secret")
+			handler.ServeHTTP(rec, request)
+			if rec.Code != tc.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectStatus, rec.Code, rec.Body)
+			}
+			if tc.expectStatus == http.StatusOK {
+				if len(audited) != 1 || audited[0].Action != "set-log-level" || audited[0].User != "operator" || audited[0].Details["level"] != tc.expectLevel {
+					t.Errorf("expected the change to be audited, got %+v", audited)
+				}
+			} else if len(audited) != 0 {
+				t.Errorf("expected a rejected change not to be audited, got %+v", audited)
+			}
+			if tc.expectStatus != http.StatusOK {
+				if sink.GetMinLevel() != lager.INFO {
+					t.Errorf("expected the level to be kept, got %s", sink.GetMinLevel())
+				}
+				return
+			}
+
+			var response logLevelResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if response.Level != tc.expectLevel || strings.ToUpper(sink.GetMinLevel().String()) != tc.expectLevel {
+				t.Errorf("expected level %s, got %s and sink level %s", tc.expectLevel, response.Level, sink.GetMinLevel())
+			}
+			if response.ConfiguredLevel != "INFO" {
+				t.Errorf("expected configured level INFO, got %s", response.ConfiguredLevel)
+			}
+			if (response.RevertsAt != nil) != tc.expectRevertsAt {
+				t.Errorf("expected reverts_at: %t, got %v", tc.expectRevertsAt, response.RevertsAt)
+			}
+		})
+	}
+}
+
+func TestLogLevelRevert(t *testing.T) {
+	sink := lager.NewReconfigurableSink(lagertest.NewTestSink(), lager.INFO)
+	var audited []broker.AuditEvent
+	handler := newLogLevelHandler(sink, func(ctx context.Context, event broker.AuditEvent, err error) {
+		audited = append(audited, event)
+	}, lagertest.NewTestLogger("test"))
+	var reverts []func()
+	handler.afterFunc = func(d time.Duration, f func()) *time.Timer {
+		reverts = append(reverts, f)
+		return time.NewTimer(time.Hour)
+	}
+
+	if err := handler.setLevel(logLevelRequest{Level: "debug", Duration: "10m"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := handler.setLevel(logLevelRequest{Level: "error", Duration: "5m"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The first change's revert is superseded by the second.
+	reverts[0]()
+	if sink.GetMinLevel() != lager.ERROR {
+		t.Errorf("expected a superseded revert to keep the level, got %s", sink.GetMinLevel())
+	}
+	reverts[1]()
+	if sink.GetMinLevel() != lager.INFO {
+		t.Errorf("expected the level to revert to INFO, got %s", sink.GetMinLevel())
+	}
+	if len(audited) != 1 || audited[0].Action != "revert-log-level" {
+		t.Errorf("expected only the revert to be audited, got %+v", audited)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/log-level", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `"level":"INFO"`) || strings.Contains(body, "reverts_at") {
+		t.Errorf("unexpected response %s", body)
+	}
+}
@@ -1,28 +1,71 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	stscredsv2 "github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	stsv2 "github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 	brokertags "github.com/cloud-gov/go-broker-tags"
 	cf "github.com/cloudfoundry-community/go-cfclient/v3/client"
 	cfconfig "github.com/cloudfoundry-community/go-cfclient/v3/config"
 	"github.com/pivotal-cf/brokerapi/v10"
 
+	"github.com/cloud-gov/s3-broker/auditlog"
+	"github.com/cloud-gov/s3-broker/awscfn"
+	"github.com/cloud-gov/s3-broker/awsevents"
 	"github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awskms"
+	"github.com/cloud-gov/s3-broker/awsmetrics"
+	"github.com/cloud-gov/s3-broker/awsretry"
 	"github.com/cloud-gov/s3-broker/awss3"
+	"github.com/cloud-gov/s3-broker/awssecrets"
+	"github.com/cloud-gov/s3-broker/awssts"
+	"github.com/cloud-gov/s3-broker/azureblob"
 	"github.com/cloud-gov/s3-broker/broker"
+	"github.com/cloud-gov/s3-broker/credhub"
+	"github.com/cloud-gov/s3-broker/gcpstorage"
+	"github.com/cloud-gov/s3-broker/objectstore"
+	"github.com/cloud-gov/s3-broker/state"
 )
 
+// shutdownGracePeriod bounds how long in-flight requests may run after a
+// termination signal before their contexts are cancelled.
+const shutdownGracePeriod = 30 * time.Second
+
 var (
 	configFilePath string
 	port           string
@@ -40,16 +83,25 @@ func init() {
 	flag.StringVar(&port, "port", "3000", "Listen port")
 }
 
-func buildLogger(logLevel string) lager.Logger {
+// buildLogger returns the broker's logger and the sink whose level
+// /admin/log-level changes.
+func buildLogger(logLevel string, redactPolicies bool) (lager.Logger, *lager.ReconfigurableSink) {
 	laggerLogLevel, ok := logLevels[strings.ToUpper(logLevel)]
 	if !ok {
 		log.Fatal("Invalid log level: ", logLevel)
 	}
 
+	// Lines are dropped by level before they are redacted, which is the
+	// costlier step.
+	redactingSink, err := newRedactingSink(lager.NewWriterSink(os.Stdout, lager.DEBUG), redactPolicies)
+	if err != nil {
+		log.Fatal("Invalid log redaction: ", err)
+	}
+	sink := lager.NewReconfigurableSink(redactingSink, laggerLogLevel)
 	logger := lager.NewLogger("s3-broker")
-	logger.RegisterSink(lager.NewWriterSink(os.Stdout, laggerLogLevel))
+	logger.RegisterSink(sink)
 
-	return logger
+	return logger, sink
 }
 
 func main() {
@@ -60,30 +112,145 @@ func main() {
 		log.Fatalf("Error loading config file: %s", err)
 	}
 
-	logger := buildLogger(config.LogLevel)
+	logger, logSink := buildLogger(config.LogLevel, config.RedactPolicies)
+
+	if err := loadAWSSecretFiles(os.Environ()); err != nil {
+		log.Fatalf("Error loading AWS credentials: %s", err)
+	}
 
 	awsConfig := aws.NewConfig().WithRegion(config.S3Config.Region)
-	if config.S3Config.Endpoint != "" {
-		fmt.Printf("Using alternate endpoint: %s\n", config.S3Config.Endpoint)
+	s3ConfigOptions := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(config.S3Config.Region)}
+	if config.S3Config.UseFIPSEndpoints {
+		awsConfig.WithUseFIPSEndpoint(true)
+		s3ConfigOptions = append(s3ConfigOptions, awsconfig.WithUseFIPSEndpoint(awsv2.FIPSEndpointStateEnabled))
+	}
+	s3Config, err := awsconfig.LoadDefaultConfig(context.Background(), s3ConfigOptions...)
+	if err != nil {
+		log.Fatalf("Error loading AWS configuration: %s", err)
+	}
+	s3Config.APIOptions = append(s3Config.APIOptions, addRequestIDToUserAgentV2)
+	endpoint, err := config.S3Config.EndpointURL()
+	if err != nil {
+		log.Fatalf("Error parsing endpoint: %s", err)
+	}
+	if endpoint != nil {
+		fmt.Printf("Using alternate endpoint: %s\n", endpoint)
 		awsConfig.WithEndpoint(config.S3Config.Endpoint)
+		s3Config.BaseEndpoint = awsv2.String(endpoint.String())
+	}
+	if config.S3Config.Signature.Checksums == broker.ChecksumsWhenRequired {
+		s3Config.RequestChecksumCalculation = awsv2.RequestChecksumCalculationWhenRequired
+		s3Config.ResponseChecksumValidation = awsv2.ResponseChecksumValidationWhenRequired
 	}
 	if config.S3Config.InsecureSkipVerify {
 		fmt.Printf("Setting connection to insecure (do not validate certificates)\n")
-		customTransport := http.DefaultTransport.(*http.Transport).Clone()
-		customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.S3Config.InsecureSkipVerify}
-		customClient := &http.Client{Transport: customTransport}
-		awsConfig.WithHTTPClient(customClient)
+	}
+	httpClient, err := config.S3Config.HTTPClient()
+	if err != nil {
+		log.Fatalf("Error configuring the AWS HTTP client: %s", err)
+	}
+	if httpClient != nil {
+		awsConfig.WithHTTPClient(httpClient)
+		s3Config.HTTPClient = httpClient
+	}
+	if config.S3Config.AssumeRole.Enabled() {
+		fmt.Printf("Assuming role: %s\n", config.S3Config.AssumeRole.RoleARN)
+		awsConfig, s3Config = assumeRoles(awsConfig, s3Config, config.S3Config.AssumeRole.Region, config.S3Config.AssumeRole.Duration, config.S3Config.AssumeRole.Roles())
 	}
 	awsSession := session.New(awsConfig)
+	awsSession.Handlers.Build.PushBack(addRequestIDToUserAgent)
 
-	s3svc := s3.New(awsSession)
-	s3bucket := awss3.NewS3Bucket(s3svc, logger)
+	if flag.Arg(0) == "audit" {
+		if err := runAuditCommand(config.S3Config.AuditLog, flag.Args()[1:]); err != nil {
+			log.Fatalf("Error verifying audit log: %s", err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "config" {
+		if err := runConfigCommand(context.Background(), config, awsConfig, s3Config, flag.Args()[1:]); err != nil {
+			log.Fatalf("Error validating config: %s", err)
+		}
+		return
+	}
+
+	var credentialIssuer awssts.CredentialIssuer
+	if config.S3Config.TemporaryCredentials.Enabled {
+		credentialIssuer, err = awssts.NewCredentialIssuer(
+			config.S3Config.TemporaryCredentials.Method,
+			sts.New(awsSession),
+			config.S3Config.TemporaryCredentials.RoleARN,
+			config.S3Config.Retry,
+			logger,
+		)
+		if err != nil {
+			log.Fatalf("Failure to configure temporary credentials: %s", err)
+		}
+	}
+
+	var credentialStore credhub.Store
+	var credhubClient state.CredHubClient
+	if config.S3Config.CredHub.Enabled() {
+		client, err := credhub.NewClient(config.S3Config.CredHub, logger)
+		if err != nil {
+			log.Fatalf("Failure to configure CredHub: %s", err)
+		}
+		credentialStore, credhubClient = client, client
+	}
+
+	if flag.Arg(0) == "migrate" {
+		migrations, err := state.Migrate(context.Background(), config.State, logger)
+		if err != nil {
+			log.Fatalf("Error migrating state store: %s", err)
+		}
+		for _, migration := range migrations {
+			fmt.Printf("Applied migration %d_%s\n", migration.Version, migration.Name)
+		}
+		fmt.Printf("Applied %d migrations\n", len(migrations))
+		return
+	}
 
-	user, err := awsiam.NewUser(config.S3Config.Provider, logger, awsSession, config.S3Config.Endpoint, config.S3Config.InsecureSkipVerify)
+	var stateStore state.Store
+	if config.State.Enabled() {
+		stateStore, err = state.New(context.Background(), config.State, awsSession, credhubClient, logger)
+		if err != nil {
+			log.Fatalf("Failure to configure state store: %s", err)
+		}
+	}
+
+	if flag.Arg(0) == "state" {
+		if err := runStateCommand(context.Background(), stateStore, flag.Args()[1:]); err != nil {
+			log.Fatalf("Error running state command: %s", err)
+		}
+		return
+	}
+
+	account, err := newAccount(config.S3Config, logger, awsSession, s3Config, endpoint, stateStore)
 	if err != nil {
 		log.Fatalf("Failure to configure user management: %s", err)
 	}
 
+	// The broker assumes a role in each other account, starting from its
+	// own credentials.
+	accounts := make(map[string]broker.Account, len(config.S3Config.Accounts))
+	permissionAccounts := map[string]permissionClients{
+		"": {sts: sts.New(awsSession), iam: iam.New(awsSession)},
+	}
+	for name, accountConfig := range config.S3Config.Accounts {
+		accountAWSConfig, accountS3Config := assumeRoles(awsConfig, s3Config, "", 0, []broker.RoleConfig{accountConfig.RoleConfig})
+		accountSession := session.New(accountAWSConfig)
+		accountSession.Handlers.Build.PushBack(addRequestIDToUserAgent)
+		accounts[name], err = newAccount(config.S3Config, logger, accountSession, accountS3Config, endpoint, stateStore)
+		if err != nil {
+			log.Fatalf("Failure to configure account %s: %s", name, err)
+		}
+		permissionAccounts[name] = permissionClients{sts: sts.New(accountSession), iam: iam.New(accountSession)}
+	}
+
+	if err := checkPermissionsOnStartup(context.Background(), config, permissionAccounts, logger); err != nil {
+		log.Fatalf("Error checking AWS permissions: %s", err)
+	}
+
 	var client *cf.Client
 	if config.CFConfig != nil {
 		cfConfig, err := cfconfig.NewClientSecret(config.CFConfig.ApiAddress, config.CFConfig.ClientID, config.CFConfig.ClientSecret)
@@ -109,21 +276,356 @@ func main() {
 
 	serviceBroker := broker.New(
 		config.S3Config,
-		s3bucket,
-		user,
+		account.Bucket,
+		account.User,
+		account.Role,
+		account.Group,
+		account.Grants,
+		account.Secrets,
+		credentialIssuer,
+		credentialStore,
+		stateStore,
 		client,
 		logger,
 		tagManager,
 	)
+	objectStores, err := newObjectStores(config.S3Config.ObjectStores, logger)
+	if err != nil {
+		log.Fatalf("Error configuring object stores: %s", err)
+	}
+	serviceBroker.SetObjectStores(objectStores)
+	serviceBroker.SetStorageReader(account.Storage)
+	serviceBroker.SetStorageAlarms(account.Alarms)
+	if config.S3Config.Events.Enabled() {
+		serviceBroker.SetEventPublisher(newEventPublisher(config.S3Config, awsSession, logger))
+	}
+	if config.S3Config.AuditLog.Enabled() {
+		auditLog, err := newAuditLog(context.Background(), config.S3Config, awsSession)
+		if err != nil {
+			log.Fatalf("Error opening audit log: %s", err)
+		}
+		serviceBroker.SetAuditLog(auditLog)
+	}
+	var metricsRecorder *awsmetrics.CloudWatchRecorder
+	if config.S3Config.Metrics.Enabled() {
+		metricsRecorder = newMetricsRecorder(config.S3Config, awsSession, logger)
+		serviceBroker.SetMetricsRecorder(metricsRecorder)
+		awsretry.OnExhausted(func(operation string) {
+			metricsRecorder.Count("RetriesExhausted", map[string]string{"Operation": operation})
+		})
+	}
+	var limiter *awsretry.Limiter
+	if config.S3Config.Retry.RateLimit.Enabled() {
+		limiter = awsretry.NewLimiter(config.S3Config.Retry.RateLimit)
+		awsretry.SetLimiter(limiter)
+	}
+	// A nil *CloudWatchRecorder would be a Recorder that is not nil.
+	if metricsRecorder != nil {
+		awsretry.OnThrottled(throttleHandler(limiter, metricsRecorder, logger))
+	} else {
+		awsretry.OnThrottled(throttleHandler(limiter, nil, logger))
+	}
+	// The brokers of other accounts are copies of this one, so they are made
+	// last.
+	serviceBroker.SetAccounts(accounts)
+
+	if flag.Arg(0) == "terraform" {
+		if err := runTerraformCommand(context.Background(), serviceBroker, flag.Args()[1:]); err != nil {
+			log.Fatalf("Error running terraform command: %s", err)
+		}
+		return
+	}
+
+	credentials, err := newBrokerCredentials(config, logger)
+	if err != nil {
+		log.Fatalf("Error loading broker credentials: %s", err)
+	}
+	authenticate, err := authMiddleware(config, credentials, logger)
+	if err != nil {
+		log.Fatalf("Error configuring authentication: %s", err)
+	}
+
+	brokerAPI := brokerapi.NewWithCustomAuth(serviceBroker, logger, authenticate)
+	http.Handle("/", serviceBroker.CheckAPIVersion(brokerAPI))
+	http.HandleFunc("POST /bindings/{binding_id}/credentials", serviceBroker.ServeRefresh)
+	http.HandleFunc("POST /bindings/{binding_id}/presign", serviceBroker.ServePresign)
+	http.HandleFunc("GET /dashboard/instances/{instance_id}", serviceBroker.ServeDashboard)
+	http.Handle("POST /bindings/{binding_id}/rotate", authenticate(http.HandlerFunc(serviceBroker.ServeRotate)))
+	http.Handle("POST /instances/{instance_id}/bindings/{binding_id}/quarantine", authenticate(http.HandlerFunc(serviceBroker.ServeQuarantine)))
+	http.Handle("DELETE /instances/{instance_id}/bindings/{binding_id}/quarantine", authenticate(http.HandlerFunc(serviceBroker.ServeQuarantine)))
+	http.Handle("POST /admin/reconcile", authenticate(http.HandlerFunc(serviceBroker.ServeReconcile)))
+	http.Handle("GET /admin/terraform", authenticate(http.HandlerFunc(serviceBroker.ServeTerraform)))
+	http.Handle("GET /admin/instances/{instance_id}/operations", authenticate(http.HandlerFunc(serviceBroker.ServeOperations)))
+	http.Handle("GET /admin/usage", authenticate(http.HandlerFunc(serviceBroker.ServeUsage)))
+	logLevel := authenticate(newLogLevelHandler(logSink, serviceBroker.AuditAction, logger))
+	http.Handle("GET /admin/log-level", logLevel)
+	http.Handle("PUT /admin/log-level", logLevel)
 
-	credentials := brokerapi.BrokerCredentials{
-		Username: config.Username,
-		Password: config.Password,
+	// Platform health checks and load balancers probe these without
+	// credentials. S3-compatible stores have no STS to check credentials with.
+	var readinessSTS stsiface.STSAPI
+	if config.S3Config.Endpoint == "" {
+		readinessSTS = sts.New(awsSession)
 	}
 
-	brokerAPI := brokerapi.New(serviceBroker, logger, credentials)
-	http.Handle("/", brokerAPI)
+	// Every request but health probes is given a request ID and is subject
+	// to the rate and body size limits, so a rate-limited client does not
+	// also fail its probes.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", serveHealthz)
+	mux.Handle("GET /readyz", newReadiness(stateStore, readinessSTS, logger))
+	mux.Handle("/", broker.AssignRequestID(newLimiter(config.Limits, credentials.authorized, logger).Wrap(http.DefaultServeMux)))
+
+	// Request contexts derive from baseCtx, so cancelling it aborts in-flight
+	// AWS calls once the shutdown grace period has passed.
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:        ":" + port,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	serviceBroker.StartLeaderElection(signalCtx)
+	go serviceBroker.RunStaleAccessKeyChecks(signalCtx)
+	go serviceBroker.ReconcileOnStartup(signalCtx)
+	go serviceBroker.RunGarbageCollection(signalCtx)
+	go serviceBroker.RunUsageCollection(signalCtx)
+	go credentials.Run(signalCtx)
+	if metricsRecorder != nil {
+		go metricsRecorder.Run(signalCtx)
+	}
+	go reloadOnHangup(signalCtx, hangups(), configFilePath, serviceBroker, credentials, logger)
+	go func() {
+		<-signalCtx.Done()
+		logger.Info("shutdown", lager.Data{"grace_period": shutdownGracePeriod.String()})
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("shutdown", err)
+		}
+		cancelRequests()
+	}()
+
+	serve := server.ListenAndServe
+	if config.TLS.Enabled() {
+		certs, err := newCertReloader(config.TLS, logger)
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate: %s", err)
+		}
+		go certs.Run(signalCtx)
+		server.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+	}
 
 	fmt.Println("S3 Service Broker started on port " + port + "...")
-	http.ListenAndServe(":"+port, nil)
+	if err := serve(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Error serving: %s", err)
+	}
+	<-baseCtx.Done()
+	if metricsRecorder != nil {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := metricsRecorder.Flush(flushCtx); err != nil {
+			logger.Error("flush-metrics", err)
+		}
+	}
+}
+
+// newAccount builds the clients that the broker uses in one AWS account.
+// Temporary credentials are only issued in the broker's own account, so the
+// account's CredentialIssuer is left nil. Bucket creation checkpoints are kept
+// in stateStore if the broker has one.
+func newAccount(config broker.Config, logger lager.Logger, awsSession *session.Session, s3Config awsv2.Config, endpoint *url.URL, stateStore state.Store) (broker.Account, error) {
+	s3Options := func(o *s3.Options) {
+		o.UsePathStyle = config.PathStyle
+		if config.UseDualStackEndpoints {
+			o.EndpointOptions.UseDualStackEndpoint = awsv2.DualStackEndpointStateEnabled
+		}
+		if config.Signature.UnsignedPayload {
+			o.APIOptions = append(o.APIOptions, v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware)
+		}
+	}
+	bucketConfig := awss3.Config{
+		RetainFailedBuckets: config.RetainFailedBuckets,
+		Retry:               config.Retry,
+		Tagging:             resourcegroupstaggingapi.NewFromConfig(s3Config),
+		Endpoint:            endpoint,
+		PathStyle:           config.PathStyle,
+		SkipBucketTagging:   slices.Contains(config.UnsupportedFeatures(), broker.StoreFeatureTagging),
+		Region:              config.Region,
+	}
+	if stateStore != nil {
+		bucketConfig.CheckpointStore = awss3.NewStateCheckpointStore(stateStore)
+	}
+	// Buckets in the regions that users may choose are managed through
+	// clients for those regions, and counted with tagging clients for them.
+	if len(config.Regions) > 0 {
+		bucketConfig.RegionClient = func(region string) awss3.S3Client {
+			return s3.NewFromConfig(s3Config, s3Options, func(o *s3.Options) { o.Region = region })
+		}
+		bucketConfig.Regions = config.Regions
+		bucketConfig.RegionTagging = func(region string) awss3.TaggingClient {
+			return resourcegroupstaggingapi.NewFromConfig(s3Config, func(o *resourcegroupstaggingapi.Options) { o.Region = region })
+		}
+	}
+	account := broker.Account{
+		Bucket: awss3.NewS3Bucket(s3.NewFromConfig(s3Config, s3Options), logger, bucketConfig),
+	}
+	if config.Provisioning.Engine == broker.ProvisioningCloudFormation {
+		account.Bucket = awscfn.NewStackBucket(account.Bucket, logger, awscfn.Config{
+			StackPrefix: config.Provisioning.StackPrefix,
+			Timeout:     config.Provisioning.Timeout,
+			Retry:       config.Retry,
+			Region:      config.Region,
+			Client: func(region string) awscfn.CloudFormationClient {
+				return cloudformation.New(awsSession, aws.NewConfig().WithRegion(region))
+			},
+		})
+	}
+
+	var err error
+	account.User, err = awsiam.NewUser(config.Provider, logger, awsSession, config.Endpoint, config.InsecureSkipVerify, config.Retry, config.PermissionsBoundary)
+	if err != nil {
+		return broker.Account{}, err
+	}
+
+	iamsvc := iam.New(awsSession)
+	if config.AllowRoleBindings {
+		account.Role = awsiam.NewIAMRole(iamsvc, logger, config.Retry, config.PermissionsBoundary)
+	}
+	account.Group = awsiam.NewIAMGroup(iamsvc, logger, config.Retry)
+	account.Grants = awskms.NewKMSGrants(kms.New(awsSession), logger, config.Retry)
+	if config.SecretsManager.Enabled {
+		account.Secrets = awssecrets.NewSecretsManagerSecrets(secretsmanager.New(awsSession), logger, config.Retry)
+	}
+	if config.Usage.FromCloudWatch() {
+		account.Storage = awsmetrics.NewStorageReader(cloudwatch.New(awsSession), logger, config.Retry)
+	}
+	if config.Alerts.Enabled() {
+		account.Alarms = awsmetrics.NewStorageAlarms(cloudwatch.New(awsSession), config.Alerts.TopicARN, logger, config.Retry)
+	}
+	return account, nil
+}
+
+// newObjectStores builds the clients of the object stores other than S3 that
+// are configured.
+func newObjectStores(config broker.ObjectStoresConfig, logger lager.Logger) (map[string]objectstore.BucketProvider, error) {
+	stores := make(map[string]objectstore.BucketProvider)
+	if config.GCS.Enabled() {
+		client, err := gcpstorage.NewClient(config.GCS, logger)
+		if err != nil {
+			return nil, fmt.Errorf("GCS: %s", err)
+		}
+		stores[broker.ObjectStoreGCS] = client
+	}
+	if config.Azure.Enabled() {
+		client, err := azureblob.NewClient(config.Azure, logger)
+		if err != nil {
+			return nil, fmt.Errorf("Azure: %s", err)
+		}
+		stores[broker.ObjectStoreAzure] = client
+	}
+	return stores, nil
+}
+
+// newEventPublisher builds the publisher of the SNS topic or EventBridge event
+// bus that events are published to. It is called in the target's region, and
+// on AWS even if the broker uses an S3-compatible store.
+func newEventPublisher(config broker.Config, awsSession *session.Session, logger lager.Logger) awsevents.Publisher {
+	targetARN, _ := arn.Parse(config.Events.TargetARN)
+	eventsSession := awsSession.Copy(aws.NewConfig().WithRegion(targetARN.Region).WithEndpoint(""))
+	if awsevents.IsTopicARN(config.Events.TargetARN) {
+		return awsevents.NewSNSPublisher(sns.New(eventsSession), config.Events.TargetARN, logger, config.Retry)
+	}
+	source := cmp.Or(config.Events.Source, broker.DefaultEventSource)
+	return awsevents.NewEventBridgePublisher(eventbridge.New(eventsSession), config.Events.TargetARN, source, logger, config.Retry)
+}
+
+// newMetricsRecorder builds the recorder that puts the broker's metrics to
+// CloudWatch, on AWS even if the broker uses an S3-compatible store.
+func newMetricsRecorder(config broker.Config, awsSession *session.Session, logger lager.Logger) *awsmetrics.CloudWatchRecorder {
+	metricsSession := awsSession.Copy(aws.NewConfig().WithEndpoint(""))
+	return awsmetrics.NewCloudWatchRecorder(
+		cloudwatch.New(metricsSession),
+		config.Metrics.CloudWatchNamespace,
+		config.Metrics.Dimensions,
+		cmp.Or(config.Metrics.Interval, broker.DefaultMetricsInterval),
+		logger,
+		config.Retry,
+	)
+}
+
+// newAuditLog opens the audit log file, continuing its chain, or creates a
+// CloudWatch Logs log stream for the audit log, named after the host and the
+// time the broker started.
+func newAuditLog(ctx context.Context, config broker.Config, awsSession *session.Session) (*auditlog.Log, error) {
+	auditConfig := config.AuditLog
+	key := []byte(auditConfig.HMACKey)
+	if auditConfig.File != "" {
+		last, err := auditlog.LastRecord(auditConfig.File)
+		if err != nil {
+			return nil, err
+		}
+		maxSize := int64(cmp.Or(auditConfig.MaxSizeMB, broker.DefaultAuditLogMaxSizeMB)) << 20
+		sink, err := auditlog.NewFileSink(auditConfig.File, maxSize, cmp.Or(auditConfig.MaxBackups, broker.DefaultAuditLogMaxBackups))
+		if err != nil {
+			return nil, err
+		}
+		return auditlog.New(sink, key, last), nil
+	}
+
+	host, _ := os.Hostname()
+	stream := fmt.Sprintf("%s/%s/%s",
+		cmp.Or(auditConfig.LogStreamPrefix, broker.DefaultAuditLogLogStreamPrefix),
+		cmp.Or(host, "unknown"),
+		time.Now().UTC().Format("2006-01-02T15-04-05Z"),
+	)
+	logsSession := awsSession.Copy(aws.NewConfig().WithEndpoint(""))
+	sink, err := auditlog.NewCloudWatchSink(ctx, cloudwatchlogs.New(logsSession), auditConfig.LogGroup, stream, config.Retry)
+	if err != nil {
+		return nil, err
+	}
+	return auditlog.New(sink, key, auditlog.Record{}), nil
+}
+
+// assumeRoles returns copies of awsConfig and s3Config with the credentials of
+// the last of roles, which are assumed in order, each with the credentials of
+// the role before it, and refreshed before they expire. STS is called in
+// stsRegion, if it is set.
+func assumeRoles(awsConfig *aws.Config, s3Config awsv2.Config, stsRegion string, duration time.Duration, roles []broker.RoleConfig) (*aws.Config, awsv2.Config) {
+	stsConfig, stsConfigV2 := awsConfig.Copy(), s3Config.Copy()
+	if stsRegion != "" {
+		stsConfig.WithRegion(stsRegion)
+		stsConfigV2.Region = stsRegion
+	}
+
+	for _, role := range roles {
+		sessionName := cmp.Or(role.SessionName, broker.DefaultRoleSessionName)
+		stsConfig = stsConfig.Copy().WithCredentials(stscreds.NewCredentials(session.New(stsConfig), role.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = sessionName
+			if role.ExternalID != "" {
+				p.ExternalID = aws.String(role.ExternalID)
+			}
+			if duration != 0 {
+				p.Duration = duration
+			}
+		}))
+		provider := stscredsv2.NewAssumeRoleProvider(stsv2.NewFromConfig(stsConfigV2), role.RoleARN, func(o *stscredsv2.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if role.ExternalID != "" {
+				o.ExternalID = awsv2.String(role.ExternalID)
+			}
+			if duration != 0 {
+				o.Duration = duration
+			}
+		})
+		stsConfigV2 = stsConfigV2.Copy()
+		stsConfigV2.Credentials = awsv2.NewCredentialsCache(provider)
+	}
+
+	assumedConfig, assumedS3Config := awsConfig.Copy().WithCredentials(stsConfig.Credentials), s3Config.Copy()
+	assumedS3Config.Credentials = stsConfigV2.Credentials
+	return assumedConfig, assumedS3Config
 }
@@ -0,0 +1,74 @@
+// Package objectstore defines the BucketProvider interface through which the
+// broker provisions buckets in object stores other than S3, so that plans can
+// offer buckets in other clouds with the same credential shape as S3 plans.
+//
+// S3 plans do not go through a BucketProvider: they use awss3.Bucket and the
+// awsiam clients directly, which manage much more than a BucketProvider can
+// describe, such as bucket policies, encryption and IAM roles.
+package objectstore
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrBucketExists        = errors.New("bucket already exists")
+	ErrBucketNotFound      = errors.New("bucket does not exist")
+	ErrBucketNotEmpty      = errors.New("bucket is not empty")
+	ErrCredentialsNotFound = errors.New("credentials do not exist")
+	// ErrTooManyCredentials is returned for buckets that have as many
+	// credentials as the store allows.
+	ErrTooManyCredentials = errors.New("bucket has too many credentials")
+)
+
+// Access is what credentials may do with a bucket's objects.
+type Access string
+
+const (
+	ReadWrite Access = "read-write"
+	ReadOnly  Access = "read-only"
+	WriteOnly Access = "write-only"
+)
+
+// BucketProvider creates buckets, and credentials for them, in an object
+// store. Bucket names are made by the broker from its bucket prefix and the
+// instance ID, so the prefix must suit every store that plans use, such as
+// Azure, which does not allow dots. Credentials are identified by the binding
+// they are created for.
+type BucketProvider interface {
+	// CreateBucket returns ErrBucketExists if the bucket already exists.
+	// Labels are the bucket's tags, which providers adapt to their own
+	// rules for label and metadata names.
+	CreateBucket(ctx context.Context, bucketName string, labels map[string]string) error
+	// SetLabels adds labels to the bucket, replacing those with the same
+	// names.
+	SetLabels(ctx context.Context, bucketName string, labels map[string]string) error
+	// DeleteBucket returns ErrBucketNotEmpty if the bucket has objects, and
+	// ErrBucketNotFound if it does not exist.
+	DeleteBucket(ctx context.Context, bucketName string) error
+	// CreateCredentials returns credentials with access to the bucket's
+	// objects.
+	CreateCredentials(ctx context.Context, bucketName, bindingID string, access Access) (Credentials, error)
+	// DeleteCredentials returns ErrCredentialsNotFound if the binding has no
+	// credentials.
+	DeleteCredentials(ctx context.Context, bucketName, bindingID string) error
+}
+
+// Credentials give access to a bucket. Stores with S3-compatible APIs have
+// an access key ID and secret access key; others have a token that grants
+// access to the bucket's URL.
+type Credentials struct {
+	// Endpoint is the host name of the store's API.
+	Endpoint string
+	// Region is the location of the bucket.
+	Region          string
+	Bucket          string
+	BucketURL       string
+	URI             string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SASToken is an Azure shared access signature, the query string that
+	// authorizes requests to BucketURL.
+	SASToken string
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// envPrefix starts the environment variables that override config
+	// values. The rest of the name is the value's path in the config file,
+	// with keys separated by two underscores, so S3_BROKER_S3_CONFIG__REGION
+	// overrides s3_config.region. List items are addressed by index.
+	envPrefix = "S3_BROKER_"
+	// fileSuffix ends variables that name a file holding the value, such as
+	// a mounted Kubernetes or Docker secret, rather than the value itself.
+	fileSuffix = "_FILE"
+)
+
+// awsSecretVariables are the AWS SDK's credential variables, which may also
+// be read from files with fileSuffix.
+var awsSecretVariables = []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"}
+
+// applyOverrides sets the config values named by the S3_BROKER_ variables
+// in environ. A variable ending in _FILE names a file holding the value,
+// unless the config has a key by its full name, such as tls.cert_file.
+func applyOverrides(config *Config, environ []string) error {
+	overrides := map[string]string{}
+	files := map[string]string{}
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		if base, ok := strings.CutSuffix(name, fileSuffix); ok && !hasKey(reflect.TypeOf(config), configPath(name)) {
+			files[base] = value
+			continue
+		}
+		overrides[name] = value
+	}
+	for name, path := range files {
+		value, err := readSecretFile(name, path, overrides)
+		if err != nil {
+			return err
+		}
+		overrides[name] = value
+	}
+
+	for name, value := range overrides {
+		if err := setValue(reflect.ValueOf(config).Elem(), configPath(name), value); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func configPath(name string) []string {
+	return strings.Split(strings.ToLower(strings.TrimPrefix(name, envPrefix)), "__")
+}
+
+// loadAWSSecretFiles sets the AWS SDK's credential variables from the files
+// named by their _FILE variables.
+func loadAWSSecretFiles(environ []string) error {
+	variables := map[string]string{}
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		variables[name] = value
+	}
+	for _, name := range awsSecretVariables {
+		path, ok := variables[name+fileSuffix]
+		if !ok {
+			continue
+		}
+		value, err := readSecretFile(name, path, variables)
+		if err != nil {
+			return err
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSecretFile reads the value of the variable name from the file at
+// path, without its trailing newline. The variable must not also be set.
+func readSecretFile(name, path string, variables map[string]string) (string, error) {
+	if _, ok := variables[name]; ok {
+		return "", fmt.Errorf("Must not set both %s and %s%s", name, name, fileSuffix)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Reading %s%s: %s", name, fileSuffix, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// hasKey reports whether path names a value within t.
+func hasKey(t reflect.Type, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return hasKey(t.Elem(), path)
+	case reflect.Struct:
+		field, ok := yamlField(reflect.New(t).Elem(), path[0])
+		return ok && hasKey(field.Type(), path[1:])
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && hasKey(t.Elem(), path[1:])
+	case reflect.Slice:
+		_, err := strconv.Atoi(path[0])
+		return err == nil && hasKey(t.Elem(), path[1:])
+	}
+	return false
+}
+
+// setValue sets the value at path within v, following yaml tags the way the
+// config file is decoded.
+func setValue(v reflect.Value, path []string, value string) error {
+	if len(path) == 0 {
+		return decodeValue(v, value)
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setValue(v.Elem(), path, value)
+	case reflect.Struct:
+		field, ok := yamlField(v, path[0])
+		if !ok {
+			return fmt.Errorf("unknown config key %q", path[0])
+		}
+		return setValue(field, path[1:], value)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.ValueOf(path[0]).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := setValue(elem, path[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+	case reflect.Slice:
+		idx, err := strconv.Atoi(path[0])
+		if err != nil || idx < 0 || idx >= v.Len() {
+			return fmt.Errorf("no list item %q", path[0])
+		}
+		return setValue(v.Index(idx), path[1:], value)
+	}
+	return fmt.Errorf("cannot override key %q of a %s", path[0], v.Type())
+}
+
+// yamlField returns the field of struct v that decodes the key name,
+// including the fields of inlined structs.
+func yamlField(v reflect.Value, name string) (reflect.Value, bool) {
+	for idx := range v.NumField() {
+		field := v.Type().Field(idx)
+		tag, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "-" {
+			continue
+		}
+		if options == "inline" {
+			if inlined, ok := yamlField(v.Field(idx), name); ok {
+				return inlined, true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = strings.ToLower(field.Name)
+		}
+		if tag == name && field.IsExported() {
+			return v.Field(idx), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// decodeValue decodes value into v as YAML, so lists, durations and numbers
+// are written as they are in the config file. Strings are taken as they are,
+// so that secrets like 0123 or p#ss are not reinterpreted.
+func decodeValue(v reflect.Value, value string) error {
+	data := []byte(value)
+	if v.Kind() == reflect.String {
+		var err error
+		if data, err = yaml.Marshal(value); err != nil {
+			return err
+		}
+	}
+	decoded := reflect.New(v.Type())
+	if err := yaml.Unmarshal(data, decoded.Interface()); err != nil {
+		return err
+	}
+	v.Set(decoded.Elem())
+	return nil
+}
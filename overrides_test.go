@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("0123#secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		environ   []string
+		expect    func(*Config)
+		expectErr string
+	}{
+		"string": {
+			environ: []string{"S3_BROKER_USERNAME=broker"},
+			expect:  func(c *Config) { c.Username = "broker" },
+		},
+		"string that looks like a number": {
+			environ: []string{"S3_BROKER_PASSWORD=0123"},
+			expect:  func(c *Config) { c.Password = "0123" },
+		},
+		"nested value": {
+			environ: []string{"S3_BROKER_S3_CONFIG__REGION=us-gov-west-1"},
+			expect:  func(c *Config) { c.S3Config.Region = "us-gov-west-1" },
+		},
+		"value in an unset section": {
+			environ: []string{"S3_BROKER_CF_CONFIG__API_URL=https://api.example.com"},
+			expect:  func(c *Config) { c.CFConfig = &CFConfig{ApiAddress: "https://api.example.com"} },
+		},
+		"boolean": {
+			environ: []string{"S3_BROKER_S3_CONFIG__USE_FIPS_ENDPOINTS=true"},
+			expect:  func(c *Config) { c.S3Config.UseFIPSEndpoints = true },
+		},
+		"duration": {
+			environ: []string{"S3_BROKER_TLS__RELOAD_INTERVAL=5m"},
+			expect:  func(c *Config) { c.TLS.ReloadInterval = 5 * time.Minute },
+		},
+		"list": {
+			environ: []string{"S3_BROKER_S3_CONFIG__REGIONS=[us-east-2, us-west-2]"},
+			expect:  func(c *Config) { c.S3Config.Regions = []string{"us-east-2", "us-west-2"} },
+		},
+		"list item": {
+			environ: []string{"S3_BROKER_CREDENTIALS__0__PASSWORD=new-password"},
+			expect:  func(c *Config) { c.Credentials[0].Password = "new-password" },
+		},
+		"secret file": {
+			environ: []string{"S3_BROKER_PASSWORD_FILE=" + secret},
+			expect:  func(c *Config) { c.Password = "0123#secret" },
+		},
+		"key ending in _file": {
+			environ: []string{"S3_BROKER_TLS__CERT_FILE=/etc/broker/tls.crt"},
+			expect:  func(c *Config) { c.TLS.CertFile = "/etc/broker/tls.crt" },
+		},
+		"other variables": {
+			environ: []string{"HOME=/root", "AWS_REGION=us-east-1"},
+		},
+		"value and secret file": {
+			environ:   []string{"S3_BROKER_PASSWORD=password", "S3_BROKER_PASSWORD_FILE=" + secret},
+			expectErr: "Must not set both S3_BROKER_PASSWORD and S3_BROKER_PASSWORD_FILE",
+		},
+		"missing secret file": {
+			environ:   []string{"S3_BROKER_PASSWORD_FILE=" + filepath.Join(t.TempDir(), "missing")},
+			expectErr: "Reading S3_BROKER_PASSWORD_FILE",
+		},
+		"unknown key": {
+			environ:   []string{"S3_BROKER_S3_CONFIG__REGOIN=us-east-1"},
+			expectErr: `S3_BROKER_S3_CONFIG__REGOIN: unknown config key "regoin"`,
+		},
+		"missing list item": {
+			environ:   []string{"S3_BROKER_CREDENTIALS__1__PASSWORD=new-password"},
+			expectErr: `S3_BROKER_CREDENTIALS__1__PASSWORD: no list item "1"`,
+		},
+		"invalid value": {
+			environ:   []string{"S3_BROKER_S3_CONFIG__USE_FIPS_ENDPOINTS=sometimes"},
+			expectErr: "S3_BROKER_S3_CONFIG__USE_FIPS_ENDPOINTS: yaml: unmarshal errors",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			newConfig := func() *Config {
+				return &Config{
+					LogLevel:    "INFO",
+					Credentials: []BrokerCredential{{Username: "broker", Password: "old-password"}},
+					S3Config:    broker.Config{Region: "us-east-1"},
+				}
+			}
+			config, expected := newConfig(), newConfig()
+			if tc.expect != nil {
+				tc.expect(expected)
+			}
+
+			err := applyOverrides(config, tc.environ)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(config, expected) {
+				t.Errorf("expected %+v, got %+v", expected, config)
+			}
+		})
+	}
+}
+
+func TestLoadAWSSecretFiles(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret_access_key")
+	if err := os.WriteFile(secret, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	environ := []string{"AWS_SECRET_ACCESS_KEY_FILE=" + secret, "AWS_WEB_IDENTITY_TOKEN_FILE=/var/run/secrets/token"}
+	if err := loadAWSSecretFiles(environ); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if value := os.Getenv("AWS_SECRET_ACCESS_KEY"); value != "secret" {
+		t.Errorf("expected AWS_SECRET_ACCESS_KEY to be read from its file, got %q", value)
+	}
+
+	environ = append(environ, "AWS_SECRET_ACCESS_KEY=other")
+	if err := loadAWSSecretFiles(environ); err == nil {
+		t.Error("expected an error when both AWS_SECRET_ACCESS_KEY and its file are set")
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	"github.com/cloud-gov/s3-broker/awsevents"
+	"github.com/cloud-gov/s3-broker/awssts"
+	"github.com/cloud-gov/s3-broker/broker"
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+// permission is a group of AWS actions that the broker calls, and whether a
+// configuration calls them. They match the statements of iam_policy.json.
+type permission struct {
+	actions []string
+	// needed is nil for actions that every configuration calls.
+	needed func(config *Config) bool
+}
+
+var permissions = []permission{
+	{actions: []string{
+		"s3:CreateBucket",
+		"s3:DeleteBucket",
+		"s3:ListAllMyBuckets",
+		"s3:GetBucketLocation",
+		"s3:GetBucketTagging",
+		"s3:PutBucketTagging",
+		"s3:GetBucketPolicy",
+		"s3:PutBucketPolicy",
+		"s3:DeleteBucketPolicy",
+		"s3:GetEncryptionConfiguration",
+		"s3:PutEncryptionConfiguration",
+		"s3:GetBucketVersioning",
+		"s3:PutBucketVersioning",
+		"s3:GetBucketPublicAccessBlock",
+		"s3:PutBucketPublicAccessBlock",
+		"s3:GetBucketOwnershipControls",
+		"s3:PutBucketOwnershipControls",
+		"s3:GetBucketCORS",
+		"s3:PutBucketCORS",
+		"s3:GetLifecycleConfiguration",
+		"s3:PutLifecycleConfiguration",
+		"s3:ListBucket",
+		"s3:ListBucketVersions",
+		"s3:PutObject",
+		"s3:DeleteObject",
+		"s3:DeleteObjectVersion",
+		"tag:GetResources",
+	}},
+	{actions: []string{
+		"iam:GetUser",
+		"iam:CreateUser",
+		"iam:TagUser",
+		"iam:DeleteUser",
+		"iam:ListUsers",
+		"iam:ListAccessKeys",
+		"iam:CreateAccessKey",
+		"iam:DeleteAccessKey",
+		"iam:UpdateAccessKey",
+		"iam:CreatePolicy",
+		"iam:DeletePolicy",
+		"iam:ListAttachedUserPolicies",
+		"iam:AttachUserPolicy",
+		"iam:DetachUserPolicy",
+		"iam:PutUserPolicy",
+		"iam:ListUserPolicies",
+		"iam:DeleteUserPolicy",
+	}},
+	{
+		actions: []string{"iam:PutUserPermissionsBoundary"},
+		needed:  func(config *Config) bool { return config.S3Config.PermissionsBoundary != "" },
+	},
+	{
+		actions: []string{
+			"iam:CreateGroup",
+			"iam:DeleteGroup",
+			"iam:PutGroupPolicy",
+			"iam:ListGroupPolicies",
+			"iam:DeleteGroupPolicy",
+			"iam:AddUserToGroup",
+			"iam:RemoveUserFromGroup",
+			"iam:ListGroupsForUser",
+		},
+		needed: func(config *Config) bool { return config.S3Config.UseInstanceGroups },
+	},
+	{
+		actions: []string{
+			"iam:GetRole",
+			"iam:CreateRole",
+			"iam:DeleteRole",
+			"iam:TagRole",
+			"iam:PutRolePolicy",
+			"iam:ListRolePolicies",
+			"iam:DeleteRolePolicy",
+			"iam:AttachRolePolicy",
+			"iam:DetachRolePolicy",
+			"iam:ListAttachedRolePolicies",
+		},
+		needed: func(config *Config) bool { return config.S3Config.AllowRoleBindings },
+	},
+	{
+		actions: []string{"iam:PutRolePermissionsBoundary"},
+		needed: func(config *Config) bool {
+			return config.S3Config.AllowRoleBindings && config.S3Config.PermissionsBoundary != ""
+		},
+	},
+	{
+		actions: []string{"iam:GetAccessKeyLastUsed"},
+		needed: func(config *Config) bool {
+			return config.S3Config.StaleAccessKeys.MaxAge > 0 && config.S3Config.StaleAccessKeys.Deactivate
+		},
+	},
+	{
+		actions: []string{"kms:DescribeKey", "kms:CreateGrant", "kms:ListGrants", "kms:RetireGrant"},
+		needed: func(config *Config) bool {
+			for _, plan := range config.S3Config.Catalog.ListServicePlans() {
+				if plan.S3Properties.KMSKeyID() != "" {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		actions: []string{
+			"secretsmanager:CreateSecret",
+			"secretsmanager:DescribeSecret",
+			"secretsmanager:PutSecretValue",
+			"secretsmanager:PutResourcePolicy",
+			"secretsmanager:TagResource",
+			"secretsmanager:DeleteSecret",
+			"secretsmanager:ListSecrets",
+		},
+		needed: func(config *Config) bool { return config.S3Config.SecretsManager.Enabled },
+	},
+	{
+		actions: []string{"sts:GetFederationToken"},
+		needed: func(config *Config) bool {
+			return config.S3Config.TemporaryCredentials.Enabled && config.S3Config.TemporaryCredentials.Method != awssts.MethodAssumeRole
+		},
+	},
+	{
+		actions: []string{"sts:AssumeRole"},
+		needed: func(config *Config) bool {
+			return config.S3Config.TemporaryCredentials.Enabled && config.S3Config.TemporaryCredentials.Method == awssts.MethodAssumeRole
+		},
+	},
+	{
+		actions: []string{"sts:TagSession"},
+		needed: func(config *Config) bool {
+			return config.S3Config.TemporaryCredentials.Enabled && config.S3Config.TemporaryCredentials.SessionTags
+		},
+	},
+	{
+		actions: []string{
+			"cloudformation:CreateStack",
+			"cloudformation:UpdateStack",
+			"cloudformation:DeleteStack",
+			"cloudformation:DescribeStacks",
+			"cloudformation:DescribeStackEvents",
+			"cloudformation:DetectStackDrift",
+			"cloudformation:DetectStackResourceDrift",
+			"cloudformation:DescribeStackResourceDrifts",
+			"cloudformation:DescribeStackDriftDetectionStatus",
+		},
+		needed: func(config *Config) bool {
+			return config.S3Config.Provisioning.Engine == broker.ProvisioningCloudFormation
+		},
+	},
+	{
+		actions: []string{"sns:Publish"},
+		needed: func(config *Config) bool {
+			return awsevents.IsTopicARN(config.S3Config.Events.TargetARN)
+		},
+	},
+	{
+		actions: []string{"events:PutEvents"},
+		needed: func(config *Config) bool {
+			return awsevents.IsEventBusARN(config.S3Config.Events.TargetARN)
+		},
+	},
+	{
+		actions: []string{"logs:CreateLogStream", "logs:PutLogEvents"},
+		needed: func(config *Config) bool {
+			return config.S3Config.AuditLog.LogGroup != ""
+		},
+	},
+	{
+		actions: []string{"cloudwatch:PutMetricData"},
+		needed:  func(config *Config) bool { return config.S3Config.Metrics.Enabled() },
+	},
+	{
+		actions: []string{"cloudwatch:GetMetricData"},
+		needed:  func(config *Config) bool { return config.S3Config.Usage.FromCloudWatch() },
+	},
+	{
+		actions: []string{"cloudwatch:PutMetricAlarm", "cloudwatch:DeleteAlarms"},
+		needed:  func(config *Config) bool { return config.S3Config.Alerts.Enabled() },
+	},
+	{
+		actions: []string{"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:DeleteItem", "dynamodb:Query", "dynamodb:Scan"},
+		needed:  func(config *Config) bool { return config.State.Backend == state.BackendDynamoDB },
+	},
+	{
+		actions: []string{"kms:GenerateDataKey", "kms:Decrypt"},
+		needed:  func(config *Config) bool { return config.State.Encryption.KMSKeyID != "" },
+	},
+}
+
+// requiredActions returns the AWS actions that the broker calls with config.
+func requiredActions(config *Config) []string {
+	var actions []string
+	for _, permission := range permissions {
+		if permission.needed == nil || permission.needed(config) {
+			actions = append(actions, permission.actions...)
+		}
+	}
+	return actions
+}
+
+// callerPrincipal returns the IAM user or role whose credentials the broker
+// calls AWS with. Sessions of an assumed role are traced back to the role,
+// whose policies IAM can simulate.
+func callerPrincipal(ctx context.Context, stssvc stsiface.STSAPI, iamsvc iamiface.IAMAPI) (string, error) {
+	identity, err := stssvc.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	callerARN := aws.StringValue(identity.Arn)
+	parsed, err := arn.Parse(callerARN)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Service != "sts" {
+		return callerARN, nil
+	}
+	roleName, ok := strings.CutPrefix(parsed.Resource, "assumed-role/")
+	if !ok {
+		return "", fmt.Errorf("cannot simulate the policies of %s", callerARN)
+	}
+	roleName, _, _ = strings.Cut(roleName, "/")
+	role, err := iamsvc.GetRoleWithContext(ctx, &iam.GetRoleInput{RoleName: aws.String(roleName)})
+	if err != nil {
+		return "", fmt.Errorf("looking up role %s: %w", roleName, err)
+	}
+	return aws.StringValue(role.Role.Arn), nil
+}
+
+// missingPermissions returns the actions that IAM's policy simulator does
+// not allow principal. It considers the principal's identity policies and
+// permissions boundary, but not service control policies or the policies of
+// the broker's buckets and keys.
+func missingPermissions(ctx context.Context, iamsvc iamiface.IAMAPI, principal string, actions []string) ([]string, error) {
+	var missing []string
+	err := iamsvc.SimulatePrincipalPolicyPagesWithContext(ctx, &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(principal),
+		ActionNames:     aws.StringSlice(actions),
+	}, func(page *iam.SimulatePolicyResponse, _ bool) bool {
+		for _, result := range page.EvaluationResults {
+			if aws.StringValue(result.EvalDecision) != iam.PolicyEvaluationDecisionTypeAllowed {
+				missing = append(missing, aws.StringValue(result.EvalActionName))
+			}
+		}
+		return true
+	})
+	return missing, err
+}
+
+// Modes of the permission check on startup.
+const (
+	// PermissionCheckWarn logs the actions that the broker's credentials are
+	// not allowed, and starts the broker anyway.
+	PermissionCheckWarn = "warn"
+	// PermissionCheckFail refuses to start the broker when its credentials
+	// are not allowed an action it needs, or cannot be checked.
+	PermissionCheckFail = "fail"
+	// PermissionCheckOff skips the check.
+	PermissionCheckOff = "off"
+)
+
+var ErrMissingPermissions = errors.New("missing AWS permissions")
+
+// permissionClients are the clients that check the broker's permissions in
+// one account. The broker's own account has the empty name.
+type permissionClients struct {
+	sts stsiface.STSAPI
+	iam iamiface.IAMAPI
+}
+
+// checkPermissionsOnStartup asks the IAM policy simulator whether the
+// broker's credentials in each account allow every action that config
+// needs, so that a missing permission is found when the broker starts rather
+// than when a provision fails with AccessDenied. Problems are logged, and
+// returned in PermissionCheckFail mode.
+func checkPermissionsOnStartup(ctx context.Context, config *Config, accounts map[string]permissionClients, logger lager.Logger) error {
+	mode := config.PermissionCheck
+	if mode == "" {
+		mode = PermissionCheckWarn
+	}
+	// S3-compatible stores have no IAM policy simulator to ask.
+	if mode == PermissionCheckOff || config.S3Config.Endpoint != "" {
+		return nil
+	}
+	logger = logger.Session("permission-check")
+
+	names := make([]string, 0, len(accounts))
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	actions := requiredActions(config)
+	var problems []string
+	for _, name := range names {
+		clients := accounts[name]
+		account := "broker account"
+		if name != "" {
+			account = "account " + name
+		}
+		principal, err := callerPrincipal(ctx, clients.sts, clients.iam)
+		var missing []string
+		if err == nil {
+			missing, err = missingPermissions(ctx, clients.iam, principal, actions)
+		}
+		if err != nil {
+			logger.Error("check-failed", err, lager.Data{"account": name})
+			problems = append(problems, fmt.Sprintf("%s: %s", account, err))
+			continue
+		}
+		if len(missing) > 0 {
+			logger.Error("missing-permissions", ErrMissingPermissions, lager.Data{"account": name, "principal": principal, "actions": missing})
+			problems = append(problems, fmt.Sprintf("%s: %s is not allowed %s", account, principal, strings.Join(missing, ", ")))
+			continue
+		}
+		logger.Info("ok", lager.Data{"account": name, "principal": principal, "actions": len(actions)})
+	}
+
+	if len(problems) > 0 && mode == PermissionCheckFail {
+		return fmt.Errorf("%s: %s", ErrMissingPermissions, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3/lagertest"
+
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+func TestCheckPermissionsOnStartup(t *testing.T) {
+	user := "arn:aws:iam::123456789012:user/s3-broker"
+	testCases := map[string]struct {
+		config    Config
+		accounts  map[string]permissionClients
+		expectErr string
+		expectLog string
+	}{
+		"every permission": {
+			config:    Config{PermissionCheck: PermissionCheckFail},
+			accounts:  map[string]permissionClients{"": {sts: &mockSTS{arn: user}, iam: &mockIAM{}}},
+			expectLog: "permission-check.ok",
+		},
+		"missing permission in warn mode": {
+			accounts:  map[string]permissionClients{"": {sts: &mockSTS{arn: user}, iam: &mockIAM{denied: []string{"s3:CreateBucket"}}}},
+			expectLog: "permission-check.missing-permissions",
+		},
+		"missing permission in fail mode": {
+			config: Config{PermissionCheck: PermissionCheckFail},
+			accounts: map[string]permissionClients{
+				"":        {sts: &mockSTS{arn: user}, iam: &mockIAM{}},
+				"tenants": {sts: &mockSTS{arn: user}, iam: &mockIAM{denied: []string{"iam:CreateUser"}}},
+			},
+			expectErr: "missing AWS permissions: account tenants: " + user + " is not allowed iam:CreateUser",
+		},
+		"unchecked credentials in fail mode": {
+			config:    Config{PermissionCheck: PermissionCheckFail},
+			accounts:  map[string]permissionClients{"": {sts: &mockSTS{err: errors.New("ExpiredToken")}, iam: &mockIAM{}}},
+			expectErr: "broker account: ExpiredToken",
+		},
+		"off": {
+			config:   Config{PermissionCheck: PermissionCheckOff},
+			accounts: map[string]permissionClients{"": {sts: &mockSTS{err: errors.New("ExpiredToken")}, iam: &mockIAM{}}},
+		},
+		"S3-compatible store": {
+			config:   Config{PermissionCheck: PermissionCheckFail, S3Config: broker.Config{Endpoint: "https://minio.example.com"}},
+			accounts: map[string]permissionClients{"": {sts: &mockSTS{err: errors.New("ExpiredToken")}, iam: &mockIAM{}}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			logger := lagertest.NewTestLogger("test")
+			err := checkPermissionsOnStartup(context.Background(), &tc.config, tc.accounts, logger)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.expectLog != "" && !slices.Contains(logger.LogMessages(), "test."+tc.expectLog) {
+				t.Errorf("expected log message %q, got %v", tc.expectLog, logger.LogMessages())
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// redactedKeys match the keys of log data whose values are secrets, at any
+// depth, such as the SecretAccessKey of a logged CreateAccessKey output or
+// the Authorization of logged headers.
+var redactedKeys = []string{
+	`(?i)^(secret[-_]?access[-_]?key|session[-_]?token|refresh[-_]?token|presign[-_]?token|sas[-_]?token)$`,
+	`(?i)^(client[-_]?secret|secret[-_]?string|secret[-_]?binary|hmac[-_]?key|private[-_]?key|password)$`,
+	`(?i)^(authorization|x-amz-security-token)$`,
+}
+
+// redactedPolicyKeys match the keys of policy documents, which are redacted
+// with redact_policies.
+var redactedPolicyKeys = []string{
+	`(?i)^(policy|policy[-_]?document|assume[-_]?role[-_]?policy[-_]?document)$`,
+}
+
+// redactedValues match secrets in strings of log data, such as error
+// messages, whatever their keys.
+var redactedValues = []string{
+	`(?i)secret[-_]?access[-_]?key["']?\s*(?::|=>|=)\s*["']?[A-Za-z0-9/+=]{40}`,
+	`(?i)^(bearer|basic) \S+`,
+	`AWS4-HMAC-SHA256 Credential=\S+`,
+	`-----BEGIN(.*)PRIVATE KEY-----`,
+}
+
+// newRedactingSink wraps sink so that secrets in the data of every log line
+// are replaced with *REDACTED*, at every log level, and policy documents too
+// if redactPolicies is set. Access key IDs are kept, so that log lines can be
+// matched with CloudTrail.
+func newRedactingSink(sink lager.Sink, redactPolicies bool) (lager.Sink, error) {
+	keys := redactedKeys
+	if redactPolicies {
+		keys = append(keys[:len(keys):len(keys)], redactedPolicyKeys...)
+	}
+	return lager.NewRedactingSink(sink, keys, redactedValues)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+// hangups returns a channel of SIGHUPs. Catching them stops SIGHUP from
+// terminating the process, so it is called before the broker starts serving.
+func hangups() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	return signals
+}
+
+// reloadOnHangup reloads the config file on every SIGHUP until ctx is done.
+// The broker's catalog, quotas and credentials are replaced without a
+// restart; a config file that fails to load or validate is logged and the
+// running configuration is kept.
+func reloadOnHangup(ctx context.Context, signals <-chan os.Signal, configFile string, serviceBroker *broker.S3Broker, credentials *brokerCredentials, logger lager.Logger) {
+	logger = logger.Session("reload")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := reloadConfig(configFile, serviceBroker, credentials); err != nil {
+				logger.Error("failed", err)
+				continue
+			}
+			logger.Info("reloaded", lager.Data{"config": configFile})
+		}
+	}
+}
+
+func reloadConfig(configFile string, serviceBroker *broker.S3Broker, credentials *brokerCredentials) error {
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if credentials.file.Enabled() {
+		if _, err := credentials.reload(); err != nil {
+			return err
+		}
+	}
+	if err := serviceBroker.Reload(config.S3Config); err != nil {
+		return err
+	}
+	credentials.setStatic(config)
+	return nil
+}
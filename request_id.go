@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+// requestIDUserAgentName precedes the broker request ID in the user agents of
+// AWS calls, which CloudTrail records.
+const requestIDUserAgentName = "s3-broker-request"
+
+// addRequestIDToUserAgent is a handler that appends the ID of the broker
+// request that an AWS call is made for to the call's user agent.
+func addRequestIDToUserAgent(r *request.Request) {
+	if requestID := broker.RequestID(r.Context()); requestID != "" {
+		request.AddToUserAgent(r, requestIDUserAgentName+"/"+requestID)
+	}
+}
+
+// addRequestIDToUserAgentV2 is addRequestIDToUserAgent for clients of the v2
+// SDK. It runs after the SDK has set the user agent.
+func addRequestIDToUserAgentV2(stack *middleware.Stack) error {
+	return stack.Build.Add(middleware.BuildMiddlewareFunc("RequestIDUserAgent", func(
+		ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler,
+	) (middleware.BuildOutput, middleware.Metadata, error) {
+		req, ok := in.Request.(*smithyhttp.Request)
+		if requestID := broker.RequestID(ctx); ok && requestID != "" {
+			userAgent := req.Header.Get("User-Agent") + " " + requestIDUserAgentName + "/" + requestID
+			req.Header.Set("User-Agent", strings.TrimSpace(userAgent))
+		}
+		return next.HandleBuild(ctx, in)
+	}), middleware.After)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+type userAgentClient struct {
+	userAgent string
+}
+
+func (c *userAgentClient) Do(req *http.Request) (*http.Response, error) {
+	c.userAgent = req.Header.Get("User-Agent")
+	return nil, errors.New("not sent")
+}
+
+func TestRequestIDUserAgent(t *testing.T) {
+	testCases := map[string]struct {
+		ctx         context.Context
+		expectAgent bool
+	}{
+		"broker request": {ctx: broker.WithRequestID(context.Background(), "trace-1"), expectAgent: true},
+		"background":     {ctx: context.Background()},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			awsSession := session.Must(session.NewSession(aws.NewConfig().
+				WithRegion("us-east-1").
+				WithCredentials(credentials.NewStaticCredentials("id", "secret", ""))))
+			awsSession.Handlers.Build.PushBack(addRequestIDToUserAgent)
+			req, _ := sts.New(awsSession).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+			req.SetContext(tc.ctx)
+			if err := req.Build(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			client := &userAgentClient{}
+			s3Client := s3.New(s3.Options{
+				Region:      "us-east-1",
+				Credentials: awsv2.AnonymousCredentials{},
+				HTTPClient:  client,
+				APIOptions:  []func(*middleware.Stack) error{addRequestIDToUserAgentV2},
+				Retryer:     awsv2.NopRetryer{},
+			})
+			s3Client.ListBuckets(tc.ctx, &s3.ListBucketsInput{})
+
+			for sdk, userAgent := range map[string]string{"v1": req.HTTPRequest.Header.Get("User-Agent"), "v2": client.userAgent} {
+				if hasID := strings.Contains(userAgent, "s3-broker-request/trace-1"); hasID != tc.expectAgent {
+					t.Errorf("expected the %s user agent to have the request ID: %t, got %q", sdk, tc.expectAgent, userAgent)
+				}
+				if !strings.Contains(userAgent, "aws-sdk-go") {
+					t.Errorf("expected the %s user agent to keep the SDK's, got %q", sdk, userAgent)
+				}
+			}
+		})
+	}
+}
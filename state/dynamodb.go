@@ -0,0 +1,544 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+type DynamoDBConfig struct {
+	// Table has a string partition key "pk" and a string sort key "sk".
+	Table string `yaml:"table"`
+	// Region of the table. Defaults to the broker's region.
+	Region string `yaml:"region"`
+}
+
+func (c DynamoDBConfig) Validate() error {
+	if c.Table == "" {
+		return errors.New("Must provide a non-empty DynamoDB Table")
+	}
+	return nil
+}
+
+type DynamoDBClient interface {
+	GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error)
+	PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error)
+	DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error)
+	QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error)
+	ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error
+}
+
+// Items of each kind share the table, told apart by the prefix of their
+// partition key. An instance's operations share a partition, sorted by time.
+const (
+	dynamoInstancePrefix   = "instance#"
+	dynamoBindingPrefix    = "binding#"
+	dynamoOperationsPrefix = "operations#"
+	dynamoLockPrefix       = "lock#"
+	dynamoUsagePrefix      = "usage#"
+	dynamoRecordPrefix     = "record#"
+
+	// dynamoTimeLayout sorts operations by time as strings.
+	dynamoTimeLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+type dynamoInstance struct {
+	PK               string    `dynamodbav:"pk"`
+	SK               string    `dynamodbav:"sk"`
+	InstanceID       string    `dynamodbav:"instance_id"`
+	ServiceID        string    `dynamodbav:"service_id"`
+	PlanID           string    `dynamodbav:"plan_id"`
+	OrganizationGUID string    `dynamodbav:"organization_guid"`
+	SpaceGUID        string    `dynamodbav:"space_guid"`
+	BucketName       string    `dynamodbav:"bucket_name"`
+	Parameters       string    `dynamodbav:"parameters,omitempty"`
+	CreatedAt        time.Time `dynamodbav:"created_at"`
+	UpdatedAt        time.Time `dynamodbav:"updated_at"`
+}
+
+type dynamoBinding struct {
+	PK         string    `dynamodbav:"pk"`
+	SK         string    `dynamodbav:"sk"`
+	BindingID  string    `dynamodbav:"binding_id"`
+	InstanceID string    `dynamodbav:"instance_id"`
+	Data       string    `dynamodbav:"data"`
+	CreatedAt  time.Time `dynamodbav:"created_at"`
+}
+
+func (item dynamoInstance) instance() Instance {
+	instance := Instance{
+		InstanceID:       item.InstanceID,
+		ServiceID:        item.ServiceID,
+		PlanID:           item.PlanID,
+		OrganizationGUID: item.OrganizationGUID,
+		SpaceGUID:        item.SpaceGUID,
+		BucketName:       item.BucketName,
+		CreatedAt:        item.CreatedAt,
+		UpdatedAt:        item.UpdatedAt,
+	}
+	if item.Parameters != "" {
+		instance.Parameters = json.RawMessage(item.Parameters)
+	}
+	return instance
+}
+
+func (item dynamoBinding) binding() Binding {
+	return Binding{
+		BindingID:  item.BindingID,
+		InstanceID: item.InstanceID,
+		Data:       json.RawMessage(item.Data),
+		CreatedAt:  item.CreatedAt,
+	}
+}
+
+type dynamoOperation struct {
+	PK         string    `dynamodbav:"pk"`
+	SK         string    `dynamodbav:"sk"`
+	InstanceID string    `dynamodbav:"instance_id"`
+	BindingID  string    `dynamodbav:"binding_id,omitempty"`
+	Action     string    `dynamodbav:"action"`
+	User       string    `dynamodbav:"user,omitempty"`
+	Time       time.Time `dynamodbav:"time"`
+	Error      string    `dynamodbav:"error,omitempty"`
+}
+
+type dynamoUsage struct {
+	PK         string    `dynamodbav:"pk"`
+	SK         string    `dynamodbav:"sk"`
+	InstanceID string    `dynamodbav:"instance_id"`
+	Bytes      int64     `dynamodbav:"bytes"`
+	Objects    int64     `dynamodbav:"objects"`
+	Truncated  bool      `dynamodbav:"truncated,omitempty"`
+	MeasuredAt time.Time `dynamodbav:"measured_at"`
+}
+
+func (item dynamoUsage) usage() Usage {
+	return Usage{
+		InstanceID: item.InstanceID,
+		Bytes:      item.Bytes,
+		Objects:    item.Objects,
+		Truncated:  item.Truncated,
+		MeasuredAt: item.MeasuredAt,
+	}
+}
+
+type dynamoRecord struct {
+	PK        string    `dynamodbav:"pk"`
+	SK        string    `dynamodbav:"sk"`
+	Kind      string    `dynamodbav:"kind"`
+	Key       string    `dynamodbav:"key"`
+	Data      string    `dynamodbav:"data"`
+	UpdatedAt time.Time `dynamodbav:"updated_at"`
+}
+
+type dynamoLock struct {
+	PK    string `dynamodbav:"pk"`
+	SK    string `dynamodbav:"sk"`
+	Owner string `dynamodbav:"owner"`
+	// ExpiresAt is in Unix seconds, so DynamoDB's TTL can remove the lock
+	// if the table has TTL enabled on it.
+	ExpiresAt int64 `dynamodbav:"expires_at"`
+}
+
+// DynamoDBStore keeps state in a DynamoDB table, so brokers that run on AWS
+// can share it without a database server. It locks instances with
+// conditional writes.
+type DynamoDBStore struct {
+	client DynamoDBClient
+	table  string
+	logger lager.Logger
+}
+
+func NewDynamoDBStore(client DynamoDBClient, table string, logger lager.Logger) *DynamoDBStore {
+	return &DynamoDBStore{
+		client: client,
+		table:  table,
+		logger: logger.Session("dynamodb-state"),
+	}
+}
+
+func (d *DynamoDBStore) GetInstance(ctx context.Context, instanceID string) (Instance, error) {
+	var item dynamoInstance
+	found, err := d.getItem(ctx, dynamoInstancePrefix+instanceID, "instance", &item)
+	if err != nil {
+		return Instance{}, err
+	}
+	if !found {
+		return Instance{}, ErrInstanceNotFound
+	}
+	return item.instance(), nil
+}
+
+// ListInstances scans the table, which reads every item in it.
+func (d *DynamoDBStore) ListInstances(ctx context.Context) ([]Instance, error) {
+	var items []dynamoInstance
+	if err := d.scan(ctx, "instance", &items); err != nil {
+		return nil, err
+	}
+	instances := make([]Instance, len(items))
+	for i, item := range items {
+		instances[i] = item.instance()
+	}
+	return instances, nil
+}
+
+func (d *DynamoDBStore) SaveInstance(ctx context.Context, instance Instance) error {
+	return d.putItem(ctx, dynamoInstance{
+		PK:               dynamoInstancePrefix + instance.InstanceID,
+		SK:               "instance",
+		InstanceID:       instance.InstanceID,
+		ServiceID:        instance.ServiceID,
+		PlanID:           instance.PlanID,
+		OrganizationGUID: instance.OrganizationGUID,
+		SpaceGUID:        instance.SpaceGUID,
+		BucketName:       instance.BucketName,
+		Parameters:       string(instance.Parameters),
+		CreatedAt:        instance.CreatedAt,
+		UpdatedAt:        instance.UpdatedAt,
+	}, nil)
+}
+
+func (d *DynamoDBStore) DeleteInstance(ctx context.Context, instanceID string) error {
+	return d.deleteItem(ctx, dynamoInstancePrefix+instanceID, "instance")
+}
+
+func (d *DynamoDBStore) GetBinding(ctx context.Context, bindingID string) (Binding, error) {
+	var item dynamoBinding
+	found, err := d.getItem(ctx, dynamoBindingPrefix+bindingID, "binding", &item)
+	if err != nil {
+		return Binding{}, err
+	}
+	if !found {
+		return Binding{}, ErrBindingNotFound
+	}
+	return item.binding(), nil
+}
+
+// ListBindings scans the table, which reads every item in it.
+func (d *DynamoDBStore) ListBindings(ctx context.Context) ([]Binding, error) {
+	var items []dynamoBinding
+	if err := d.scan(ctx, "binding", &items); err != nil {
+		return nil, err
+	}
+	bindings := make([]Binding, len(items))
+	for i, item := range items {
+		bindings[i] = item.binding()
+	}
+	return bindings, nil
+}
+
+func (d *DynamoDBStore) SaveBinding(ctx context.Context, binding Binding) error {
+	return d.putItem(ctx, dynamoBinding{
+		PK:         dynamoBindingPrefix + binding.BindingID,
+		SK:         "binding",
+		BindingID:  binding.BindingID,
+		InstanceID: binding.InstanceID,
+		Data:       string(binding.Data),
+		CreatedAt:  binding.CreatedAt,
+	}, nil)
+}
+
+func (d *DynamoDBStore) DeleteBinding(ctx context.Context, bindingID string) error {
+	return d.deleteItem(ctx, dynamoBindingPrefix+bindingID, "binding")
+}
+
+// RecordOperation adds an operation to its instance's partition. Operations
+// recorded at the same nanosecond are told apart by a counter in their sort
+// key, which the write's condition keeps from being overwritten.
+func (d *DynamoDBStore) RecordOperation(ctx context.Context, operation Operation) error {
+	item := dynamoOperation{
+		PK:         dynamoOperationsPrefix + operation.InstanceID,
+		InstanceID: operation.InstanceID,
+		BindingID:  operation.BindingID,
+		Action:     operation.Action,
+		User:       operation.User,
+		Time:       operation.Time,
+		Error:      operation.Error,
+	}
+	sortKey := operation.Time.UTC().Format(dynamoTimeLayout)
+	for i := 0; ; i++ {
+		item.SK = fmt.Sprintf("%s#%d", sortKey, i)
+		err := d.putItem(ctx, item, &dynamodb.PutItemInput{
+			ConditionExpression: aws.String("attribute_not_exists(pk)"),
+		})
+		if !isConditionFailed(err) {
+			return err
+		}
+	}
+}
+
+func (d *DynamoDBStore) ListOperations(ctx context.Context, instanceID string, limit int) ([]Operation, error) {
+	output, err := d.client.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(d.table),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(dynamoOperationsPrefix + instanceID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		d.logger.Error("query", err, lager.Data{"instance-id": instanceID})
+		return nil, err
+	}
+	var items []dynamoOperation
+	if err := dynamodbattribute.UnmarshalListOfMaps(output.Items, &items); err != nil {
+		return nil, err
+	}
+	operations := make([]Operation, len(items))
+	for i, item := range items {
+		operations[i] = Operation{
+			InstanceID: item.InstanceID,
+			BindingID:  item.BindingID,
+			Action:     item.Action,
+			User:       item.User,
+			Time:       item.Time,
+			Error:      item.Error,
+		}
+	}
+	return operations, nil
+}
+
+func (d *DynamoDBStore) GetUsage(ctx context.Context, instanceID string) (Usage, error) {
+	var item dynamoUsage
+	found, err := d.getItem(ctx, dynamoUsagePrefix+instanceID, "usage", &item)
+	if err != nil {
+		return Usage{}, err
+	}
+	if !found {
+		return Usage{}, ErrUsageNotFound
+	}
+	return item.usage(), nil
+}
+
+func (d *DynamoDBStore) SaveUsage(ctx context.Context, usage Usage) error {
+	return d.putItem(ctx, dynamoUsage{
+		PK:         dynamoUsagePrefix + usage.InstanceID,
+		SK:         "usage",
+		InstanceID: usage.InstanceID,
+		Bytes:      usage.Bytes,
+		Objects:    usage.Objects,
+		Truncated:  usage.Truncated,
+		MeasuredAt: usage.MeasuredAt,
+	}, nil)
+}
+
+func (d *DynamoDBStore) DeleteUsage(ctx context.Context, instanceID string) error {
+	return d.deleteItem(ctx, dynamoUsagePrefix+instanceID, "usage")
+}
+
+// ListUsage scans the table, which reads every item in it.
+func (d *DynamoDBStore) ListUsage(ctx context.Context) ([]Usage, error) {
+	var items []dynamoUsage
+	if err := d.scan(ctx, "usage", &items); err != nil {
+		return nil, err
+	}
+	usages := make([]Usage, len(items))
+	for i, item := range items {
+		usages[i] = item.usage()
+	}
+	return usages, nil
+}
+
+// The records of a kind share a partition, sorted by key.
+func (d *DynamoDBStore) GetRecord(ctx context.Context, kind, key string) (Record, error) {
+	var item dynamoRecord
+	found, err := d.getItem(ctx, dynamoRecordPrefix+kind, key, &item)
+	if err != nil {
+		return Record{}, err
+	}
+	if !found {
+		return Record{}, ErrRecordNotFound
+	}
+	return Record{
+		Kind:      item.Kind,
+		Key:       item.Key,
+		Data:      json.RawMessage(item.Data),
+		UpdatedAt: item.UpdatedAt,
+	}, nil
+}
+
+func (d *DynamoDBStore) SaveRecord(ctx context.Context, record Record) error {
+	return d.putItem(ctx, dynamoRecord{
+		PK:        dynamoRecordPrefix + record.Kind,
+		SK:        record.Key,
+		Kind:      record.Kind,
+		Key:       record.Key,
+		Data:      string(record.Data),
+		UpdatedAt: record.UpdatedAt,
+	}, nil)
+}
+
+func (d *DynamoDBStore) DeleteRecord(ctx context.Context, kind, key string) error {
+	return d.deleteItem(ctx, dynamoRecordPrefix+kind, key)
+}
+
+// ListRecords scans the table, which reads every item in it. Records are the
+// items whose partition key has the record prefix, since their sort keys are
+// the records' own keys.
+func (d *DynamoDBStore) ListRecords(ctx context.Context) ([]Record, error) {
+	var scanned []map[string]*dynamodb.AttributeValue
+	err := d.client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:        aws.String(d.table),
+		FilterExpression: aws.String("begins_with(pk, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":prefix": {S: aws.String(dynamoRecordPrefix)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		scanned = append(scanned, page.Items...)
+		return true
+	})
+	if err != nil {
+		d.logger.Error("scan-records", err)
+		return nil, err
+	}
+	var items []dynamoRecord
+	if err := dynamodbattribute.UnmarshalListOfMaps(scanned, &items); err != nil {
+		return nil, err
+	}
+	records := make([]Record, len(items))
+	for i, item := range items {
+		records[i] = Record{
+			Kind:      item.Kind,
+			Key:       item.Key,
+			Data:      json.RawMessage(item.Data),
+			UpdatedAt: item.UpdatedAt,
+		}
+	}
+	return records, nil
+}
+
+// TryLock writes a lock item unless another owner holds an unexpired lock on
+// key.
+func (d *DynamoDBStore) TryLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	err := d.putItem(ctx, dynamoLock{
+		PK:        dynamoLockPrefix + key,
+		SK:        "lock",
+		Owner:     owner,
+		ExpiresAt: now.Add(ttl).Unix(),
+	}, &dynamodb.PutItemInput{
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR expires_at < :now OR #owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":   {N: aws.String(fmt.Sprint(now.Unix()))},
+			":owner": {S: aws.String(owner)},
+		},
+	})
+	if isConditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlock removes owner's lock on key. A lock that expired and was taken by
+// another owner is left alone.
+func (d *DynamoDBStore) Unlock(ctx context.Context, key, owner string) error {
+	_, err := d.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 dynamoKey(dynamoLockPrefix+key, "lock"),
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+		},
+	})
+	if err != nil && !isConditionFailed(err) {
+		d.logger.Error("unlock", err, lager.Data{"key": key})
+		return err
+	}
+	return nil
+}
+
+func (d *DynamoDBStore) getItem(ctx context.Context, pk, sk string, item any) (bool, error) {
+	output, err := d.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            dynamoKey(pk, sk),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		d.logger.Error("get-item", err, lager.Data{"pk": pk})
+		return false, err
+	}
+	if len(output.Item) == 0 {
+		return false, nil
+	}
+	return true, dynamodbattribute.UnmarshalMap(output.Item, item)
+}
+
+// scan decodes every item with sort key sk into items, which must point to a
+// slice.
+func (d *DynamoDBStore) scan(ctx context.Context, sk string, items any) error {
+	var scanned []map[string]*dynamodb.AttributeValue
+	err := d.client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:        aws.String(d.table),
+		FilterExpression: aws.String("sk = :sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {S: aws.String(sk)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		scanned = append(scanned, page.Items...)
+		return true
+	})
+	if err != nil {
+		d.logger.Error("scan", err, lager.Data{"sk": sk})
+		return err
+	}
+	return dynamodbattribute.UnmarshalListOfMaps(scanned, items)
+}
+
+// putItem writes item, with the condition in input if it is not nil.
+func (d *DynamoDBStore) putItem(ctx context.Context, item any, input *dynamodb.PutItemInput) error {
+	attributes, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+	if input == nil {
+		input = &dynamodb.PutItemInput{}
+	}
+	input.TableName = aws.String(d.table)
+	input.Item = attributes
+	if _, err := d.client.PutItemWithContext(ctx, input); err != nil {
+		if !isConditionFailed(err) {
+			d.logger.Error("put-item", err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *DynamoDBStore) deleteItem(ctx context.Context, pk, sk string) error {
+	_, err := d.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       dynamoKey(pk, sk),
+	})
+	if err != nil {
+		d.logger.Error("delete-item", err, lager.Data{"pk": pk})
+	}
+	return err
+}
+
+func dynamoKey(pk, sk string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {S: aws.String(pk)},
+		"sk": {S: aws.String(sk)},
+	}
+}
+
+func isConditionFailed(err error) bool {
+	return isAWSError(err, dynamodb.ErrCodeConditionalCheckFailedException)
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// mockDynamoDBClient is a table in memory. It evaluates only the condition
+// expressions that DynamoDBStore uses.
+type mockDynamoDBClient struct {
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newMockDynamoDBClient() *mockDynamoDBClient {
+	return &mockDynamoDBClient{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+}
+
+func mockItemKey(key map[string]*dynamodb.AttributeValue) string {
+	return aws.StringValue(key["pk"].S) + "|" + aws.StringValue(key["sk"].S)
+}
+
+func (m *mockDynamoDBClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: m.items[mockItemKey(input.Key)]}, nil
+}
+
+func (m *mockDynamoDBClient) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := mockItemKey(input.Item)
+	existing, exists := m.items[key]
+	switch aws.StringValue(input.ConditionExpression) {
+	case "":
+	case "attribute_not_exists(pk)":
+		if exists {
+			return nil, mockConditionFailed()
+		}
+	case "attribute_not_exists(pk) OR expires_at < :now OR #owner = :owner":
+		if exists {
+			expiresAt, _ := strconv.ParseInt(aws.StringValue(existing["expires_at"].N), 10, 64)
+			now, _ := strconv.ParseInt(aws.StringValue(input.ExpressionAttributeValues[":now"].N), 10, 64)
+			owner := aws.StringValue(input.ExpressionAttributeValues[":owner"].S)
+			if expiresAt >= now && aws.StringValue(existing["owner"].S) != owner {
+				return nil, mockConditionFailed()
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected condition %q", aws.StringValue(input.ConditionExpression))
+	}
+	m.items[key] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockDynamoDBClient) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := mockItemKey(input.Key)
+	switch aws.StringValue(input.ConditionExpression) {
+	case "":
+	case "#owner = :owner":
+		existing, exists := m.items[key]
+		if !exists || aws.StringValue(existing["owner"].S) != aws.StringValue(input.ExpressionAttributeValues[":owner"].S) {
+			return nil, mockConditionFailed()
+		}
+	default:
+		return nil, fmt.Errorf("unexpected condition %q", aws.StringValue(input.ConditionExpression))
+	}
+	delete(m.items, key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (m *mockDynamoDBClient) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pk := aws.StringValue(input.ExpressionAttributeValues[":pk"].S)
+	var items []map[string]*dynamodb.AttributeValue
+	for _, item := range m.items {
+		if aws.StringValue(item["pk"].S) == pk {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		less := aws.StringValue(items[i]["sk"].S) < aws.StringValue(items[j]["sk"].S)
+		if !aws.BoolValue(input.ScanIndexForward) {
+			return !less
+		}
+		return less
+	})
+	if limit := int(aws.Int64Value(input.Limit)); limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return &dynamodb.QueryOutput{Items: items}, nil
+}
+
+func (m *mockDynamoDBClient) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var match func(item map[string]*dynamodb.AttributeValue) bool
+	switch aws.StringValue(input.FilterExpression) {
+	case "sk = :sk":
+		sk := aws.StringValue(input.ExpressionAttributeValues[":sk"].S)
+		match = func(item map[string]*dynamodb.AttributeValue) bool {
+			return aws.StringValue(item["sk"].S) == sk
+		}
+	case "begins_with(pk, :prefix)":
+		prefix := aws.StringValue(input.ExpressionAttributeValues[":prefix"].S)
+		match = func(item map[string]*dynamodb.AttributeValue) bool {
+			return strings.HasPrefix(aws.StringValue(item["pk"].S), prefix)
+		}
+	default:
+		return fmt.Errorf("unexpected filter %q", aws.StringValue(input.FilterExpression))
+	}
+	var items []map[string]*dynamodb.AttributeValue
+	for _, item := range m.items {
+		if match(item) {
+			items = append(items, item)
+		}
+	}
+	fn(&dynamodb.ScanOutput{Items: items}, true)
+	return nil
+}
+
+func mockConditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+func TestDynamoDBStore(t *testing.T) {
+	testStore(t, NewDynamoDBStore(newMockDynamoDBClient(), "state", lager.NewLogger("test")))
+}
+
+func TestDynamoDBStoreOperationsAtTheSameTime(t *testing.T) {
+	store := NewDynamoDBStore(newMockDynamoDBClient(), "state", lager.NewLogger("test"))
+	ctx := context.Background()
+	now := time.Now()
+	for _, action := range []string{"provision", "update"} {
+		if err := store.RecordOperation(ctx, Operation{InstanceID: "instance-1", Action: action, Time: now}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	operations, err := store.ListOperations(ctx, "instance-1", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(operations) != 2 || operations[0].Action != "update" {
+		t.Errorf("expected both operations, most recent first, got %+v", operations)
+	}
+}
+
+func TestDynamoDBStoreLocks(t *testing.T) {
+	testLocker(t, NewDynamoDBStore(newMockDynamoDBClient(), "state", lager.NewLogger("test")))
+}
@@ -0,0 +1,309 @@
+package state
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// dataKeySize is the size of the AES-256 keys that encrypt binding data and
+// of a configured key encryption key.
+const dataKeySize = 32
+
+type EncryptionConfig struct {
+	// Key is a base64-encoded 256-bit key that encrypts data keys.
+	Key string `yaml:"key"`
+	// KMSKeyID is a KMS key that encrypts data keys. It may be a key ID, key
+	// ARN, alias name or alias ARN.
+	KMSKeyID string `yaml:"kms_key_id"`
+	// CredHubName is a CredHub credential whose value is a base64-encoded
+	// 256-bit key that encrypts data keys.
+	CredHubName string `yaml:"credhub_name"`
+}
+
+func (c EncryptionConfig) Enabled() bool {
+	return c.Key != "" || c.KMSKeyID != "" || c.CredHubName != ""
+}
+
+func (c EncryptionConfig) Validate() error {
+	sources := 0
+	for _, source := range []string{c.Key, c.KMSKeyID, c.CredHubName} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("Must provide only one of Key, KMSKeyID and CredHubName")
+	}
+	if c.Key != "" {
+		if _, err := decodeKey(c.Key); err != nil {
+			return fmt.Errorf("Key %s", err)
+		}
+	}
+	return nil
+}
+
+// CredHubClient reads the key encryption key from CredHub.
+type CredHubClient interface {
+	Get(ctx context.Context, name string, value any) error
+}
+
+type KMSClient interface {
+	GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error)
+	DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error)
+}
+
+// KeyEncrypter issues the data keys that encrypt binding data, and recovers
+// them from their encrypted form, which is stored with the data.
+type KeyEncrypter interface {
+	GenerateDataKey(ctx context.Context) (key, encryptedKey []byte, err error)
+	DecryptDataKey(ctx context.Context, encryptedKey []byte) ([]byte, error)
+}
+
+// NewKeyEncrypter returns the KeyEncrypter that config selects. credhubClient
+// is used only with CredHubName.
+func NewKeyEncrypter(ctx context.Context, config EncryptionConfig, kmsClient KMSClient, credhubClient CredHubClient) (KeyEncrypter, error) {
+	switch {
+	case config.KMSKeyID != "":
+		return NewKMSKeyEncrypter(kmsClient, config.KMSKeyID), nil
+	case config.CredHubName != "":
+		if credhubClient == nil {
+			return nil, errors.New("CredHub must be configured to read the state encryption key from it")
+		}
+		var encoded string
+		if err := credhubClient.Get(ctx, config.CredHubName, &encoded); err != nil {
+			return nil, fmt.Errorf("reading state encryption key from CredHub: %w", err)
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("CredHub credential %s %w", config.CredHubName, err)
+		}
+		return NewAESKeyEncrypter(key)
+	default:
+		key, err := decodeKey(config.Key)
+		if err != nil {
+			return nil, err
+		}
+		return NewAESKeyEncrypter(key)
+	}
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("must be base64-encoded")
+	}
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("must be %d bytes, not %d", dataKeySize, len(key))
+	}
+	return key, nil
+}
+
+// AESKeyEncrypter generates data keys locally and encrypts them with AES-GCM
+// under a key encryption key that the broker holds.
+type AESKeyEncrypter struct {
+	aead cipher.AEAD
+}
+
+func NewAESKeyEncrypter(key []byte) (*AESKeyEncrypter, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AESKeyEncrypter{aead: aead}, nil
+}
+
+func (e *AESKeyEncrypter) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+	encryptedKey, err := seal(e.aead, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, encryptedKey, nil
+}
+
+func (e *AESKeyEncrypter) DecryptDataKey(ctx context.Context, encryptedKey []byte) ([]byte, error) {
+	return open(e.aead, encryptedKey, nil)
+}
+
+// KMSKeyEncrypter has KMS generate data keys and decrypt them, so the key
+// encryption key never leaves KMS.
+type KMSKeyEncrypter struct {
+	client KMSClient
+	keyID  string
+}
+
+func NewKMSKeyEncrypter(client KMSClient, keyID string) *KMSKeyEncrypter {
+	return &KMSKeyEncrypter{client: client, keyID: keyID}
+}
+
+func (e *KMSKeyEncrypter) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	output, err := e.client.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(e.keyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return output.Plaintext, output.CiphertextBlob, nil
+}
+
+func (e *KMSKeyEncrypter) DecryptDataKey(ctx context.Context, encryptedKey []byte) ([]byte, error) {
+	output, err := e.client.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(e.keyID),
+		CiphertextBlob: encryptedKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
+
+// encryptedData is what is stored in place of a binding's Data. The binding
+// ID is authenticated with the ciphertext, so data cannot be moved from one
+// binding to another.
+type encryptedData struct {
+	Encrypted *envelope `json:"encrypted"`
+}
+
+// envelope is data encrypted with a data key, stored with the data key
+// encrypted by a KeyEncrypter.
+type envelope struct {
+	Key        []byte `json:"key"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedStore encrypts the Data of bindings, which holds their
+// credentials, with a data key of its own before another store saves them.
+// Bindings that were saved unencrypted are read as they are and encrypted the
+// next time they are saved.
+type EncryptedStore struct {
+	Store
+	keys   KeyEncrypter
+	logger lager.Logger
+}
+
+// encryptedLockingStore is an EncryptedStore of a store that is also a
+// Locker.
+type encryptedLockingStore struct {
+	*EncryptedStore
+	Locker
+}
+
+// NewEncryptedStore returns store with binding data encrypted by keys. The
+// result is a Locker if store is.
+func NewEncryptedStore(store Store, keys KeyEncrypter, logger lager.Logger) Store {
+	encrypted := &EncryptedStore{Store: store, keys: keys, logger: logger.Session("encrypted-state")}
+	if locker, ok := store.(Locker); ok {
+		return encryptedLockingStore{EncryptedStore: encrypted, Locker: locker}
+	}
+	return encrypted
+}
+
+func (s *EncryptedStore) GetBinding(ctx context.Context, bindingID string) (Binding, error) {
+	binding, err := s.Store.GetBinding(ctx, bindingID)
+	if err != nil {
+		return Binding{}, err
+	}
+	return s.decrypt(ctx, binding)
+}
+
+// ListBindings returns every binding with its data decrypted.
+func (s *EncryptedStore) ListBindings(ctx context.Context) ([]Binding, error) {
+	bindings, err := s.Store.ListBindings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, binding := range bindings {
+		if bindings[i], err = s.decrypt(ctx, binding); err != nil {
+			return nil, err
+		}
+	}
+	return bindings, nil
+}
+
+// decrypt returns binding with its data decrypted, unless it was saved
+// unencrypted.
+func (s *EncryptedStore) decrypt(ctx context.Context, binding Binding) (Binding, error) {
+	bindingID := binding.BindingID
+	var data encryptedData
+	if err := json.Unmarshal(binding.Data, &data); err != nil || data.Encrypted == nil {
+		return binding, nil
+	}
+	key, err := s.keys.DecryptDataKey(ctx, data.Encrypted.Key)
+	if err != nil {
+		s.logger.Error("decrypt-data-key", err, lager.Data{"binding-id": bindingID})
+		return Binding{}, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return Binding{}, err
+	}
+	binding.Data, err = open(aead, data.Encrypted.Ciphertext, []byte(bindingID))
+	if err != nil {
+		s.logger.Error("decrypt-binding", err, lager.Data{"binding-id": bindingID})
+		return Binding{}, err
+	}
+	return binding, nil
+}
+
+func (s *EncryptedStore) SaveBinding(ctx context.Context, binding Binding) error {
+	key, encryptedKey, err := s.keys.GenerateDataKey(ctx)
+	if err != nil {
+		s.logger.Error("generate-data-key", err, lager.Data{"binding-id": binding.BindingID})
+		return err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := seal(aead, binding.Data, []byte(binding.BindingID))
+	if err != nil {
+		return err
+	}
+	binding.Data, err = json.Marshal(encryptedData{Encrypted: &envelope{Key: encryptedKey, Ciphertext: ciphertext}})
+	if err != nil {
+		return err
+	}
+	return s.Store.SaveBinding(ctx, binding)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which it prepends to the
+// ciphertext.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,182 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+var testKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, dataKeySize))
+
+// mockKMSClient encrypts data keys with a key of its own, as KMS does with
+// the key it is asked to use.
+type mockKMSClient struct {
+	keys *AESKeyEncrypter
+}
+
+func newMockKMSClient(t *testing.T) *mockKMSClient {
+	keys, err := NewAESKeyEncrypter(bytes.Repeat([]byte{2}, dataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mockKMSClient{keys: keys}
+}
+
+func (m *mockKMSClient) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	key, encryptedKey, err := m.keys.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GenerateDataKeyOutput{Plaintext: key, CiphertextBlob: encryptedKey, KeyId: input.KeyId}, nil
+}
+
+func (m *mockKMSClient) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	key, err := m.keys.DecryptDataKey(ctx, input.CiphertextBlob)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.DecryptOutput{Plaintext: key, KeyId: input.KeyId}, nil
+}
+
+type mockCredHubClient map[string]any
+
+func (m mockCredHubClient) Get(ctx context.Context, name string, value any) error {
+	stored, ok := m[name]
+	if !ok {
+		return errors.New("credential not found")
+	}
+	encoded, _ := json.Marshal(stored)
+	return json.Unmarshal(encoded, value)
+}
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	testCases := map[string]EncryptionConfig{
+		"key":     {Key: testKey},
+		"kms":     {KMSKeyID: "alias/s3-broker-state"},
+		"credhub": {CredHubName: "/s3-broker/state-key"},
+	}
+	for name, config := range testCases {
+		t.Run(name, func(t *testing.T) {
+			keys, err := NewKeyEncrypter(ctx, config, newMockKMSClient(t), mockCredHubClient{"/s3-broker/state-key": testKey})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			backend := NewMemoryStore()
+			store := NewEncryptedStore(backend, keys, lager.NewLogger("test"))
+			testStore(t, store)
+
+			data := json.RawMessage(`{"Credentials":{"SecretAccessKey":"secret"}}`)
+			if err := store.SaveBinding(ctx, Binding{BindingID: "binding-1", Data: data}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			stored, err := backend.GetBinding(ctx, "binding-1")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if bytes.Contains(stored.Data, []byte("secret")) {
+				t.Errorf("expected binding data to be encrypted, got %s", stored.Data)
+			}
+			got, err := store.GetBinding(ctx, "binding-1")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(got.Data, data) {
+				t.Errorf("expected %s, got %s", data, got.Data)
+			}
+
+			// Data encrypted for one binding cannot be passed off as
+			// another's.
+			stored.BindingID = "binding-2"
+			if err := backend.SaveBinding(ctx, stored); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := store.GetBinding(ctx, "binding-2"); err == nil {
+				t.Error("expected data moved to another binding not to decrypt")
+			}
+		})
+	}
+}
+
+func TestEncryptedStoreReadsUnencryptedBindings(t *testing.T) {
+	ctx := context.Background()
+	keys, err := NewKeyEncrypter(ctx, EncryptionConfig{Key: testKey}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	backend := NewMemoryStore()
+	data := json.RawMessage(`{"BindingID":"binding-1"}`)
+	if err := backend.SaveBinding(ctx, Binding{BindingID: "binding-1", Data: data}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := NewEncryptedStore(backend, keys, lager.NewLogger("test")).GetBinding(ctx, "binding-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(got.Data, data) {
+		t.Errorf("expected %s, got %s", data, got.Data)
+	}
+}
+
+func TestEncryptedStoreLocks(t *testing.T) {
+	keys, err := NewAESKeyEncrypter(bytes.Repeat([]byte{1}, dataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := NewEncryptedStore(NewMemoryStore(), keys, lager.NewLogger("test")).(Locker); ok {
+		t.Error("expected a store that does not lock not to lock when encrypted")
+	}
+	dynamoDBStore := NewDynamoDBStore(newMockDynamoDBClient(), "state", lager.NewLogger("test"))
+	if _, ok := NewEncryptedStore(dynamoDBStore, keys, lager.NewLogger("test")).(Locker); !ok {
+		t.Error("expected a store that locks to lock when encrypted")
+	}
+}
+
+func TestEncryptionConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		config    EncryptionConfig
+		expectErr bool
+	}{
+		"disabled": {},
+		"key": {
+			config: EncryptionConfig{Key: testKey},
+		},
+		"short key": {
+			config:    EncryptionConfig{Key: base64.StdEncoding.EncodeToString([]byte("short"))},
+			expectErr: true,
+		},
+		"key not base64": {
+			config:    EncryptionConfig{Key: "not base64!"},
+			expectErr: true,
+		},
+		"kms": {
+			config: EncryptionConfig{KMSKeyID: "alias/s3-broker-state"},
+		},
+		"credhub": {
+			config: EncryptionConfig{CredHubName: "/s3-broker/state-key"},
+		},
+		"key and kms": {
+			config:    EncryptionConfig{Key: testKey, KMSKeyID: "alias/s3-broker-state"},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectErr && err == nil {
+				t.Error("expected an error")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
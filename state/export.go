@@ -0,0 +1,99 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format that Export writes
+// and Import reads. Version 1 snapshots had no records.
+const SnapshotVersion = 2
+
+// Snapshot is every instance, binding and record in a store, in a form that
+// any backend can import. Binding data is as the store returns it, which is
+// decrypted if the store encrypts it.
+type Snapshot struct {
+	Version    int        `json:"version"`
+	ExportedAt time.Time  `json:"exported_at"`
+	Instances  []Instance `json:"instances"`
+	Bindings   []Binding  `json:"bindings"`
+	Records    []Record   `json:"records"`
+}
+
+// Export reads every instance and binding in store, sorted by ID, and every
+// record, sorted by kind and key. Operations and usage are not exported.
+func Export(ctx context.Context, store Store) (Snapshot, error) {
+	instances, err := store.ListInstances(ctx)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("listing instances: %w", err)
+	}
+	slices.SortFunc(instances, func(a, b Instance) int {
+		return strings.Compare(a.InstanceID, b.InstanceID)
+	})
+	bindings, err := store.ListBindings(ctx)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("listing bindings: %w", err)
+	}
+	slices.SortFunc(bindings, func(a, b Binding) int {
+		return strings.Compare(a.BindingID, b.BindingID)
+	})
+	records, err := store.ListRecords(ctx)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("listing records: %w", err)
+	}
+	slices.SortFunc(records, func(a, b Record) int {
+		if c := strings.Compare(a.Kind, b.Kind); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return Snapshot{
+		Version:    SnapshotVersion,
+		ExportedAt: time.Now().UTC(),
+		Instances:  instances,
+		Bindings:   bindings,
+		Records:    records,
+	}, nil
+}
+
+// Import saves every instance, binding and record in snapshot to store,
+// replacing those with the same IDs. Each is read first, so stores that only
+// overwrite what they have seen, like S3Store, can replace it. Snapshots of
+// older versions are refused rather than imported without their records.
+func Import(ctx context.Context, store Store, snapshot Snapshot) error {
+	switch {
+	case snapshot.Version < 1 || snapshot.Version > SnapshotVersion:
+		return fmt.Errorf("unknown snapshot version %d", snapshot.Version)
+	case snapshot.Version < SnapshotVersion:
+		return fmt.Errorf("snapshot version %d has no records; export it again with this version of the broker", snapshot.Version)
+	}
+	for _, instance := range snapshot.Instances {
+		if _, err := store.GetInstance(ctx, instance.InstanceID); err != nil && !errors.Is(err, ErrInstanceNotFound) {
+			return fmt.Errorf("reading instance %s: %w", instance.InstanceID, err)
+		}
+		if err := store.SaveInstance(ctx, instance); err != nil {
+			return fmt.Errorf("saving instance %s: %w", instance.InstanceID, err)
+		}
+	}
+	for _, binding := range snapshot.Bindings {
+		if _, err := store.GetBinding(ctx, binding.BindingID); err != nil && !errors.Is(err, ErrBindingNotFound) {
+			return fmt.Errorf("reading binding %s: %w", binding.BindingID, err)
+		}
+		if err := store.SaveBinding(ctx, binding); err != nil {
+			return fmt.Errorf("saving binding %s: %w", binding.BindingID, err)
+		}
+	}
+	for _, record := range snapshot.Records {
+		if _, err := store.GetRecord(ctx, record.Kind, record.Key); err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return fmt.Errorf("reading %s record %s: %w", record.Kind, record.Key, err)
+		}
+		if err := store.SaveRecord(ctx, record); err != nil {
+			return fmt.Errorf("saving %s record %s: %w", record.Kind, record.Key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	source := NewMemoryStore()
+	for _, id := range []string{"instance-2", "instance-1"} {
+		if err := source.SaveInstance(ctx, Instance{InstanceID: id, PlanID: "plan-1", BucketName: "bucket-" + id, CreatedAt: now, UpdatedAt: now}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := source.SaveBinding(ctx, Binding{BindingID: "binding-1", InstanceID: "instance-1", Data: json.RawMessage(`{"BindingID":"binding-1"}`), CreatedAt: now}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := source.SaveRecord(ctx, Record{Kind: "deprovision", Key: "instance-2", Data: json.RawMessage(`{"deleted":3}`), UpdatedAt: now}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := source.SaveRecord(ctx, Record{Kind: "create-checkpoint", Key: "bucket-instance-1", Data: json.RawMessage(`"put-bucket-tagging"`), UpdatedAt: now}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	snapshot, err := Export(ctx, source)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(snapshot.Instances) != 2 || snapshot.Instances[0].InstanceID != "instance-1" {
+		t.Errorf("expected both instances sorted by ID, got %+v", snapshot.Instances)
+	}
+	if len(snapshot.Records) != 2 || snapshot.Records[0].Kind != "create-checkpoint" {
+		t.Errorf("expected both records sorted by kind, got %+v", snapshot.Records)
+	}
+
+	// Moving to another backend, with encryption, keeps everything. An
+	// instance that is already there is replaced.
+	keys, err := NewKeyEncrypter(ctx, EncryptionConfig{Key: testKey}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	client := newMockS3Client()
+	if err := NewS3Store(client, S3Config{Bucket: "state"}, lager.NewLogger("test")).SaveInstance(ctx, Instance{InstanceID: "instance-1", PlanID: "plan-2"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	destination := NewEncryptedStore(NewS3Store(client, S3Config{Bucket: "state"}, lager.NewLogger("test")), keys, lager.NewLogger("test"))
+	if err := Import(ctx, destination, snapshot); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	imported, err := Export(ctx, destination)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(snapshot, imported, cmpopts.IgnoreFields(Snapshot{}, "ExportedAt")); diff != "" {
+		t.Errorf("unexpected snapshot (-want +got):\n%s", diff)
+	}
+
+	for _, version := range []int{0, 1, SnapshotVersion + 1} {
+		snapshot.Version = version
+		if err := Import(ctx, NewMemoryStore(), snapshot); err == nil {
+			t.Errorf("expected an error importing version %d", version)
+		}
+	}
+}
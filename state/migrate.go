@@ -0,0 +1,81 @@
+package state
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+var (
+	// ErrSchemaOutdated is returned on startup when migrations are run
+	// manually and some have not been.
+	ErrSchemaOutdated = errors.New("state schema is out of date, run s3-broker migrate")
+	// ErrSchemaTooNew is returned when the store was migrated by a newer
+	// broker than this one.
+	ErrSchemaTooNew = errors.New("state schema is newer than this broker supports")
+)
+
+// migrationFilePattern matches migration files, such as 0002_locks.sql.
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+// Migration is a versioned change to a store's schema.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations reads the migrations in dir of fsys, which must be numbered
+// from 1 without gaps.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s is not named like 0001_name.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: match[2], SQL: string(body)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrate applies the pending migrations of the store that config describes,
+// for the migrate command, and returns them. Only the postgres backend has a
+// schema; the others have no migrations.
+func Migrate(ctx context.Context, config Config, logger lager.Logger) ([]Migration, error) {
+	if config.Backend != BackendPostgres {
+		return nil, nil
+	}
+	store, err := openPostgres(config.Postgres, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return store.Migrate(ctx)
+}
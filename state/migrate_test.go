@@ -0,0 +1,64 @@
+package state
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	testCases := map[string]struct {
+		files       fstest.MapFS
+		expectNames []string
+		expectErr   bool
+	}{
+		"in order": {
+			files: fstest.MapFS{
+				"m/0002_locks.sql":   {Data: []byte("CREATE TABLE locks ();")},
+				"m/0001_initial.sql": {Data: []byte("CREATE TABLE instances ();")},
+			},
+			expectNames: []string{"initial", "locks"},
+		},
+		"gap": {
+			files: fstest.MapFS{
+				"m/0001_initial.sql": {},
+				"m/0003_locks.sql":   {},
+			},
+			expectErr: true,
+		},
+		"misnamed": {
+			files:     fstest.MapFS{"m/initial.sql": {}},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			migrations, err := loadMigrations(tc.files, "m")
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var names []string
+			for i, migration := range migrations {
+				if migration.Version != i+1 || migration.SQL == "" {
+					t.Errorf("unexpected migration %+v", migration)
+				}
+				names = append(names, migration.Name)
+			}
+			if diff := cmp.Diff(tc.expectNames, names); diff != "" {
+				t.Errorf("unexpected migrations (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The broker's own migrations load.
+	if _, err := loadMigrations(postgresMigrations, "migrations/postgres"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
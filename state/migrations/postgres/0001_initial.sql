@@ -0,0 +1,30 @@
+-- The tables that brokers created on startup before migrations existed. IF
+-- NOT EXISTS lets this apply to their databases too.
+CREATE TABLE IF NOT EXISTS instances (
+	instance_id       text PRIMARY KEY,
+	service_id        text NOT NULL,
+	plan_id           text NOT NULL,
+	organization_guid text NOT NULL,
+	space_guid        text NOT NULL,
+	bucket_name       text NOT NULL,
+	parameters        jsonb,
+	created_at        timestamptz NOT NULL,
+	updated_at        timestamptz NOT NULL
+);
+CREATE TABLE IF NOT EXISTS bindings (
+	binding_id  text PRIMARY KEY,
+	instance_id text NOT NULL,
+	data        jsonb NOT NULL,
+	created_at  timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS bindings_instance_id ON bindings (instance_id);
+CREATE TABLE IF NOT EXISTS operations (
+	id          bigserial PRIMARY KEY,
+	instance_id text NOT NULL,
+	binding_id  text NOT NULL,
+	action      text NOT NULL,
+	username    text NOT NULL,
+	time        timestamptz NOT NULL,
+	error       text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS operations_instance_id ON operations (instance_id, id);
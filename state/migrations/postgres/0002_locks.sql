@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS locks (
+	key        text PRIMARY KEY,
+	owner      text NOT NULL,
+	expires_at timestamptz NOT NULL
+);
@@ -0,0 +1,7 @@
+CREATE TABLE usage (
+	instance_id text PRIMARY KEY,
+	bytes       bigint NOT NULL,
+	objects     bigint NOT NULL,
+	truncated   boolean NOT NULL,
+	measured_at timestamptz NOT NULL
+);
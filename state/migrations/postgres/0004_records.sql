@@ -0,0 +1,7 @@
+CREATE TABLE records (
+	kind       text NOT NULL,
+	key        text NOT NULL,
+	data       jsonb NOT NULL,
+	updated_at timestamptz NOT NULL,
+	PRIMARY KEY (kind, key)
+);
@@ -0,0 +1,44 @@
+package state
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// TestPostgresStore runs against the database in STATE_TEST_POSTGRES_URL,
+// whose tables it drops and migrates again first, and is skipped if that is
+// not set.
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("STATE_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("STATE_TEST_POSTGRES_URL is not set")
+	}
+	ctx := context.Background()
+	store, err := OpenPostgresStore(ctx, PostgresConfig{URL: url}, lager.NewLogger("test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer store.Close()
+	if _, err := store.db.ExecContext(ctx, `DROP TABLE IF EXISTS instances, bindings, operations, locks, usage, schema_migrations`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	applied, err := store.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	current, latest, err := store.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(applied) != latest || current != latest {
+		t.Fatalf("expected all %d migrations to be applied, got %d at version %d", latest, len(applied), current)
+	}
+	if applied, err := store.Migrate(ctx); err != nil || len(applied) != 0 {
+		t.Fatalf("expected no migrations to be pending, got %v, %v", applied, err)
+	}
+	testStore(t, store)
+	testLocker(t, store)
+}
@@ -0,0 +1,438 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// maxS3Operations is how many operations are kept for each instance,
+	// since they are rewritten as one object every time one is recorded.
+	maxS3Operations = 100
+	// maxS3Attempts bounds how often a conflicting operation or usage write is
+	// retried.
+	maxS3Attempts = 5
+)
+
+type S3Config struct {
+	// Bucket keeps the state. It should not be one the broker provisions.
+	Bucket string `yaml:"bucket"`
+	// Prefix is prepended to the key of every object, such as "state/".
+	Prefix string `yaml:"prefix"`
+	// Region of the bucket. Defaults to the broker's region.
+	Region string `yaml:"region"`
+}
+
+func (c S3Config) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("Must provide a non-empty S3 Bucket")
+	}
+	return nil
+}
+
+type S3Client interface {
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+	PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error)
+	DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error
+}
+
+// S3Store keeps state as JSON objects in a bucket, so the broker needs
+// nothing but S3 to keep it.
+//
+// Writes are conditional on the ETag of the object the store last read or
+// wrote, or on there being no object if it has seen none, so a broker cannot
+// overwrite changes made by another broker since it looked. Such writes fail
+// with ErrConflict.
+type S3Store struct {
+	client S3Client
+	bucket string
+	prefix string
+	logger lager.Logger
+
+	mu    sync.Mutex
+	etags map[string]string
+}
+
+func NewS3Store(client S3Client, config S3Config, logger lager.Logger) *S3Store {
+	return &S3Store{
+		client: client,
+		bucket: config.Bucket,
+		prefix: config.Prefix,
+		logger: logger.Session("s3-state"),
+		etags:  make(map[string]string),
+	}
+}
+
+func (s *S3Store) GetInstance(ctx context.Context, instanceID string) (Instance, error) {
+	var instance Instance
+	found, err := s.getObject(ctx, s.prefix+"instances/"+instanceID+".json", &instance)
+	if err != nil {
+		return Instance{}, err
+	}
+	if !found {
+		return Instance{}, ErrInstanceNotFound
+	}
+	return instance, nil
+}
+
+func (s *S3Store) SaveInstance(ctx context.Context, instance Instance) error {
+	return s.putObject(ctx, s.prefix+"instances/"+instance.InstanceID+".json", instance)
+}
+
+func (s *S3Store) ListInstances(ctx context.Context) ([]Instance, error) {
+	keys, err := s.listKeys(ctx, s.prefix+"instances/")
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]Instance, 0, len(keys))
+	for _, key := range keys {
+		var instance Instance
+		found, err := s.getObject(ctx, key, &instance)
+		if err != nil {
+			return nil, err
+		}
+		// The instance was deleted since the bucket was listed.
+		if found {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+func (s *S3Store) DeleteInstance(ctx context.Context, instanceID string) error {
+	return s.deleteObject(ctx, s.prefix+"instances/"+instanceID+".json")
+}
+
+func (s *S3Store) GetBinding(ctx context.Context, bindingID string) (Binding, error) {
+	var binding Binding
+	found, err := s.getObject(ctx, s.prefix+"bindings/"+bindingID+".json", &binding)
+	if err != nil {
+		return Binding{}, err
+	}
+	if !found {
+		return Binding{}, ErrBindingNotFound
+	}
+	return binding, nil
+}
+
+func (s *S3Store) SaveBinding(ctx context.Context, binding Binding) error {
+	return s.putObject(ctx, s.prefix+"bindings/"+binding.BindingID+".json", binding)
+}
+
+func (s *S3Store) ListBindings(ctx context.Context) ([]Binding, error) {
+	keys, err := s.listKeys(ctx, s.prefix+"bindings/")
+	if err != nil {
+		return nil, err
+	}
+	bindings := make([]Binding, 0, len(keys))
+	for _, key := range keys {
+		var binding Binding
+		found, err := s.getObject(ctx, key, &binding)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings, nil
+}
+
+func (s *S3Store) DeleteBinding(ctx context.Context, bindingID string) error {
+	return s.deleteObject(ctx, s.prefix+"bindings/"+bindingID+".json")
+}
+
+// RecordOperation appends to the object that lists an instance's operations,
+// reading it again and retrying if another broker changed it in between.
+func (s *S3Store) RecordOperation(ctx context.Context, operation Operation) error {
+	key := s.prefix + "operations/" + operation.InstanceID + ".json"
+	for attempt := 1; ; attempt++ {
+		var operations []Operation
+		if _, err := s.getObject(ctx, key, &operations); err != nil {
+			return err
+		}
+		operations = append(operations, operation)
+		if len(operations) > maxS3Operations {
+			operations = operations[len(operations)-maxS3Operations:]
+		}
+		err := s.putObject(ctx, key, operations)
+		if !errors.Is(err, ErrConflict) || attempt == maxS3Attempts {
+			return err
+		}
+	}
+}
+
+func (s *S3Store) ListOperations(ctx context.Context, instanceID string, limit int) ([]Operation, error) {
+	var stored []Operation
+	if _, err := s.getObject(ctx, s.prefix+"operations/"+instanceID+".json", &stored); err != nil {
+		return nil, err
+	}
+	operations := make([]Operation, 0, min(len(stored), limit))
+	for i := len(stored) - 1; i >= 0 && len(operations) < limit; i-- {
+		operations = append(operations, stored[i])
+	}
+	return operations, nil
+}
+
+func (s *S3Store) GetUsage(ctx context.Context, instanceID string) (Usage, error) {
+	var usage Usage
+	found, err := s.getObject(ctx, s.prefix+"usage/"+instanceID+".json", &usage)
+	if err != nil {
+		return Usage{}, err
+	}
+	if !found {
+		return Usage{}, ErrUsageNotFound
+	}
+	return usage, nil
+}
+
+// SaveUsage reads the instance's usage before replacing it, so that it
+// overwrites usage that another broker saved, whose ETag it has not seen.
+func (s *S3Store) SaveUsage(ctx context.Context, usage Usage) error {
+	key := s.prefix + "usage/" + usage.InstanceID + ".json"
+	for attempt := 1; ; attempt++ {
+		var stored Usage
+		if _, err := s.getObject(ctx, key, &stored); err != nil {
+			return err
+		}
+		err := s.putObject(ctx, key, usage)
+		if !errors.Is(err, ErrConflict) || attempt == maxS3Attempts {
+			return err
+		}
+	}
+}
+
+func (s *S3Store) DeleteUsage(ctx context.Context, instanceID string) error {
+	return s.deleteObject(ctx, s.prefix+"usage/"+instanceID+".json")
+}
+
+func (s *S3Store) ListUsage(ctx context.Context) ([]Usage, error) {
+	keys, err := s.listKeys(ctx, s.prefix+"usage/")
+	if err != nil {
+		return nil, err
+	}
+	usages := make([]Usage, 0, len(keys))
+	for _, key := range keys {
+		var usage Usage
+		found, err := s.getObject(ctx, key, &usage)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			usages = append(usages, usage)
+		}
+	}
+	return usages, nil
+}
+
+func (s *S3Store) GetRecord(ctx context.Context, kind, key string) (Record, error) {
+	var record Record
+	found, err := s.getObject(ctx, s.recordKey(kind, key), &record)
+	if err != nil {
+		return Record{}, err
+	}
+	if !found {
+		return Record{}, ErrRecordNotFound
+	}
+	return record, nil
+}
+
+// SaveRecord reads the record before replacing it, so that, like SaveUsage,
+// it overwrites a record that another broker saved.
+func (s *S3Store) SaveRecord(ctx context.Context, record Record) error {
+	key := s.recordKey(record.Kind, record.Key)
+	for attempt := 1; ; attempt++ {
+		var stored Record
+		if _, err := s.getObject(ctx, key, &stored); err != nil {
+			return err
+		}
+		err := s.putObject(ctx, key, record)
+		if !errors.Is(err, ErrConflict) || attempt == maxS3Attempts {
+			return err
+		}
+	}
+}
+
+func (s *S3Store) DeleteRecord(ctx context.Context, kind, key string) error {
+	return s.deleteObject(ctx, s.recordKey(kind, key))
+}
+
+func (s *S3Store) ListRecords(ctx context.Context) ([]Record, error) {
+	keys, err := s.listKeys(ctx, s.prefix+"records/")
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, 0, len(keys))
+	for _, key := range keys {
+		var record Record
+		found, err := s.getObject(ctx, key, &record)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// recordKey is the object key of a record. Record keys may contain slashes,
+// so they are escaped to keep each record one object below its kind.
+func (s *S3Store) recordKey(kind, key string) string {
+	return s.prefix + "records/" + kind + "/" + url.PathEscape(key) + ".json"
+}
+
+// s3Lock is the object that holds a lock.
+type s3Lock struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TryLock writes a lock object unless another owner holds an unexpired lock
+// on key. The write is conditional on the object TryLock read, so of two
+// brokers taking an expired lock at once only one succeeds.
+func (s *S3Store) TryLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	objectKey := s.prefix + "locks/" + key + ".json"
+	now := time.Now()
+	var lock s3Lock
+	exists, err := s.getObject(ctx, objectKey, &lock)
+	if err != nil {
+		return false, err
+	}
+	if exists && lock.Owner != owner && lock.ExpiresAt.After(now) {
+		return false, nil
+	}
+	err = s.putObject(ctx, objectKey, s3Lock{Owner: owner, ExpiresAt: now.Add(ttl)})
+	if errors.Is(err, ErrConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlock removes owner's lock on key. A lock that was taken by another owner
+// is left alone, unless it expires and is taken between Unlock reading and
+// deleting it.
+func (s *S3Store) Unlock(ctx context.Context, key, owner string) error {
+	objectKey := s.prefix + "locks/" + key + ".json"
+	var lock s3Lock
+	exists, err := s.getObject(ctx, objectKey, &lock)
+	if err != nil || !exists || lock.Owner != owner {
+		return err
+	}
+	return s.deleteObject(ctx, objectKey)
+}
+
+// getObject decodes the object at key into value and remembers its ETag. It
+// reports false, and forgets the ETag, if there is no such object.
+func (s *S3Store) getObject(ctx context.Context, key string, value any) (bool, error) {
+	output, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if isAWSError(err, s3.ErrCodeNoSuchKey) {
+		s.setETag(key, "")
+		return false, nil
+	}
+	if err != nil {
+		s.logger.Error("get-object", err, lager.Data{"key": key})
+		return false, err
+	}
+	defer output.Body.Close()
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(body, value); err != nil {
+		return false, err
+	}
+	s.setETag(key, aws.StringValue(output.ETag))
+	return true, nil
+}
+
+// putObject writes value as JSON to key if the object is still the one the
+// store last saw.
+func (s *S3Store) putObject(ctx context.Context, key string, value any) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	condition := map[string]string{"If-None-Match": "*"}
+	if etag := s.etag(key); etag != "" {
+		condition = map[string]string{"If-Match": etag}
+	}
+	output, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}, request.WithSetRequestHeaders(condition))
+	if isAWSError(err, "PreconditionFailed") || isAWSError(err, "ConditionalRequestConflict") {
+		return ErrConflict
+	}
+	if err != nil {
+		s.logger.Error("put-object", err, lager.Data{"key": key})
+		return err
+	}
+	s.setETag(key, aws.StringValue(output.ETag))
+	return nil
+}
+
+func (s *S3Store) deleteObject(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		s.logger.Error("delete-object", err, lager.Data{"key": key})
+		return err
+	}
+	s.setETag(key, "")
+	return nil
+}
+
+// listKeys returns the key of every object whose key starts with prefix.
+func (s *S3Store) listKeys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil {
+		s.logger.Error("list-objects", err, lager.Data{"prefix": prefix})
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *S3Store) etag(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.etags[key]
+}
+
+func (s *S3Store) setETag(key, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if etag == "" {
+		delete(s.etags, key)
+		return
+	}
+	s.etags[key] = etag
+}
@@ -0,0 +1,172 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// mockS3Client is a bucket in memory that honors If-Match and If-None-Match
+// on writes.
+type mockS3Client struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	puts    int
+}
+
+func newMockS3Client() *mockS3Client {
+	return &mockS3Client{objects: make(map[string][]byte)}
+}
+
+func mockETag(body []byte) string {
+	sum := md5.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (m *mockS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	body, ok := m.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{
+		Body: io.NopCloser(bytes.NewReader(body)),
+		ETag: aws.String(mockETag(body)),
+	}, nil
+}
+
+func (m *mockS3Client) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	for _, opt := range opts {
+		opt(r)
+	}
+	key := aws.StringValue(input.Key)
+	existing, exists := m.objects[key]
+	ifMatch, ifNoneMatch := r.HTTPRequest.Header.Get("If-Match"), r.HTTPRequest.Header.Get("If-None-Match")
+	if (ifNoneMatch == "*" && exists) || (ifMatch != "" && (!exists || ifMatch != mockETag(existing))) {
+		return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	}
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[key] = body
+	m.puts++
+	return &s3.PutObjectOutput{ETag: aws.String(mockETag(body))}, nil
+}
+
+func (m *mockS3Client) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *mockS3Client) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	output := &s3.ListObjectsV2Output{}
+	for _, key := range keys {
+		output.Contents = append(output.Contents, &s3.Object{Key: aws.String(key)})
+	}
+	fn(output, true)
+	return nil
+}
+
+func TestS3Store(t *testing.T) {
+	testStore(t, NewS3Store(newMockS3Client(), S3Config{Bucket: "state", Prefix: "broker/"}, lager.NewLogger("test")))
+}
+
+func TestS3StoreLocks(t *testing.T) {
+	testLocker(t, NewS3Store(newMockS3Client(), S3Config{Bucket: "state"}, lager.NewLogger("test")))
+
+	// Of two brokers taking an expired lock at once, only one gets it.
+	client := newMockS3Client()
+	brokerA := NewS3Store(client, S3Config{Bucket: "state"}, lager.NewLogger("test"))
+	brokerB := NewS3Store(client, S3Config{Bucket: "state"}, lager.NewLogger("test"))
+	ctx := context.Background()
+	if locked, err := brokerA.TryLock(ctx, "leader", "broker-a", -time.Minute); err != nil || !locked {
+		t.Fatalf("expected broker-a to lock, got %t, %v", locked, err)
+	}
+	if _, err := brokerB.getObject(ctx, "locks/leader.json", &s3Lock{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if locked, err := brokerA.TryLock(ctx, "leader", "broker-a", time.Minute); err != nil || !locked {
+		t.Fatalf("expected broker-a to extend its lock, got %t, %v", locked, err)
+	}
+	if err := brokerB.putObject(ctx, "locks/leader.json", s3Lock{Owner: "broker-b", ExpiresAt: time.Now().Add(time.Minute)}); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected broker-b's write of the lock it read as expired to conflict, got %v", err)
+	}
+}
+
+func TestS3StoreConflicts(t *testing.T) {
+	client := newMockS3Client()
+	brokerA := NewS3Store(client, S3Config{Bucket: "state"}, lager.NewLogger("test"))
+	brokerB := NewS3Store(client, S3Config{Bucket: "state"}, lager.NewLogger("test"))
+	ctx := context.Background()
+
+	if err := brokerA.SaveInstance(ctx, Instance{InstanceID: "instance-1", PlanID: "plan-1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// broker-b has not read the instance, so it cannot overwrite it.
+	if err := brokerB.SaveInstance(ctx, Instance{InstanceID: "instance-1", PlanID: "plan-2"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if _, err := brokerB.GetInstance(ctx, "instance-1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := brokerB.SaveInstance(ctx, Instance{InstanceID: "instance-1", PlanID: "plan-2"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// broker-a's last look at the instance is out of date now.
+	if err := brokerA.SaveInstance(ctx, Instance{InstanceID: "instance-1", PlanID: "plan-3"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	instance, err := brokerA.GetInstance(ctx, "instance-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if instance.PlanID != "plan-2" {
+		t.Errorf("expected broker-b's plan-2 to be kept, got %s", instance.PlanID)
+	}
+
+	// Operations are appended, rereading the list when another broker
+	// changed it.
+	for _, store := range []*S3Store{brokerA, brokerB, brokerA} {
+		if err := store.RecordOperation(ctx, Operation{InstanceID: "instance-1", Action: "update"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	operations, err := brokerB.ListOperations(ctx, "instance-1", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(operations) != 3 {
+		t.Errorf("expected 3 operations, got %d", len(operations))
+	}
+}
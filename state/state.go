@@ -0,0 +1,417 @@
+// Package state persists what the broker has provisioned: its instances,
+// their bindings and parameters, and the operations it performed on them. It
+// lets the broker be restarted, or run as several processes, without losing
+// track of them.
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+	BackendDynamoDB = "dynamodb"
+	BackendS3       = "s3"
+)
+
+var (
+	ErrInstanceNotFound = errors.New("instance not found")
+	ErrBindingNotFound  = errors.New("binding not found")
+	ErrUsageNotFound    = errors.New("usage not found")
+	ErrRecordNotFound   = errors.New("record not found")
+	// ErrConflict is returned by stores that detect when another broker
+	// changed what is being saved since it was read.
+	ErrConflict = errors.New("state was changed by another broker")
+)
+
+// Instance is a service instance that the broker provisioned or adopted.
+type Instance struct {
+	InstanceID       string `json:"instance_id"`
+	ServiceID        string `json:"service_id"`
+	PlanID           string `json:"plan_id"`
+	OrganizationGUID string `json:"organization_guid"`
+	SpaceGUID        string `json:"space_guid"`
+	BucketName       string `json:"bucket_name"`
+	// Parameters are the instance's provision parameters, with those of
+	// later updates merged in.
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// Binding is a binding that the broker created. Data is the broker's record
+// of it, including its credentials.
+type Binding struct {
+	BindingID  string          `json:"binding_id"`
+	InstanceID string          `json:"instance_id"`
+	Data       json.RawMessage `json:"data"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Operation is a request that the broker handled for an instance or one of
+// its bindings.
+type Operation struct {
+	InstanceID string    `json:"instance_id"`
+	BindingID  string    `json:"binding_id,omitempty"`
+	Action     string    `json:"action"`
+	User       string    `json:"user,omitempty"`
+	Time       time.Time `json:"time"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Usage is the storage that an instance's bucket used when it was last
+// measured.
+type Usage struct {
+	InstanceID string `json:"instance_id"`
+	Bytes      int64  `json:"bytes"`
+	Objects    int64  `json:"objects"`
+	// Truncated is set when the bucket was measured by listing it, and had
+	// more objects than were listed.
+	Truncated  bool      `json:"truncated,omitempty"`
+	MeasuredAt time.Time `json:"measured_at"`
+}
+
+// Record is a piece of the broker's bookkeeping between requests, such as how
+// far a bucket's creation got, kept as JSON by its kind and its key within
+// that kind.
+type Record struct {
+	Kind      string          `json:"kind"`
+	Key       string          `json:"key"`
+	Data      json.RawMessage `json:"data"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Store keeps the broker's instances, bindings and operations, the storage
+// usage of instances, and the broker's other records.
+type Store interface {
+	GetInstance(ctx context.Context, instanceID string) (Instance, error)
+	SaveInstance(ctx context.Context, instance Instance) error
+	DeleteInstance(ctx context.Context, instanceID string) error
+	// ListInstances returns every instance, in no particular order.
+	ListInstances(ctx context.Context) ([]Instance, error)
+	GetBinding(ctx context.Context, bindingID string) (Binding, error)
+	SaveBinding(ctx context.Context, binding Binding) error
+	DeleteBinding(ctx context.Context, bindingID string) error
+	// ListBindings returns every binding, in no particular order.
+	ListBindings(ctx context.Context) ([]Binding, error)
+	RecordOperation(ctx context.Context, operation Operation) error
+	// ListOperations returns up to limit of an instance's operations, most
+	// recent first.
+	ListOperations(ctx context.Context, instanceID string, limit int) ([]Operation, error)
+	GetUsage(ctx context.Context, instanceID string) (Usage, error)
+	// SaveUsage replaces the usage last saved for the instance.
+	SaveUsage(ctx context.Context, usage Usage) error
+	DeleteUsage(ctx context.Context, instanceID string) error
+	// ListUsage returns the usage of every instance, in no particular order.
+	ListUsage(ctx context.Context) ([]Usage, error)
+	GetRecord(ctx context.Context, kind, key string) (Record, error)
+	// SaveRecord replaces the record with the same kind and key.
+	SaveRecord(ctx context.Context, record Record) error
+	DeleteRecord(ctx context.Context, kind, key string) error
+	// ListRecords returns every record of every kind, in no particular order.
+	ListRecords(ctx context.Context) ([]Record, error)
+}
+
+// Locker is implemented by stores that can lock a key across every broker
+// process that shares them.
+type Locker interface {
+	// TryLock locks key for owner until ttl passes or owner unlocks it, and
+	// reports whether it was unlocked, expired or already owner's before. An
+	// owner extends its lock by locking it again.
+	TryLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, key, owner string) error
+}
+
+type Config struct {
+	// Backend is "memory", "postgres", "dynamodb" or "s3". State is kept
+	// only by the broker's in-process stores when it is empty.
+	Backend  string         `yaml:"backend"`
+	Postgres PostgresConfig `yaml:"postgres"`
+	DynamoDB DynamoDBConfig `yaml:"dynamodb"`
+	S3       S3Config       `yaml:"s3"`
+	// Encryption encrypts the credentials of stored bindings.
+	Encryption EncryptionConfig `yaml:"encryption"`
+}
+
+func (c Config) Enabled() bool {
+	return c.Backend != ""
+}
+
+func (c Config) Validate() error {
+	if err := c.Encryption.Validate(); err != nil {
+		return err
+	}
+	if c.Encryption.Enabled() && !c.Enabled() {
+		return errors.New("Must provide a Backend to encrypt")
+	}
+	switch c.Backend {
+	case "", BackendMemory:
+		return nil
+	case BackendPostgres:
+		return c.Postgres.Validate()
+	case BackendDynamoDB:
+		return c.DynamoDB.Validate()
+	case BackendS3:
+		return c.S3.Validate()
+	default:
+		return fmt.Errorf("Backend must be %q, %q, %q or %q, not %q", BackendMemory, BackendPostgres, BackendDynamoDB, BackendS3, c.Backend)
+	}
+}
+
+// New returns the store that config selects, ready for use, encrypting
+// binding data if config enables it. AWS backends and KMS use awsSession, and
+// an encryption key in CredHub is read with credhubClient.
+func New(ctx context.Context, config Config, awsSession *session.Session, credhubClient CredHubClient, logger lager.Logger) (Store, error) {
+	store, err := newStore(ctx, config, awsSession, logger)
+	if err != nil {
+		return nil, err
+	}
+	if config.Encryption.Enabled() {
+		keys, err := NewKeyEncrypter(ctx, config.Encryption, kms.New(awsSession), credhubClient)
+		if err != nil {
+			return nil, err
+		}
+		store = NewEncryptedStore(store, keys, logger)
+	}
+	if err := MarkInitialized(ctx, store); err != nil {
+		return nil, fmt.Errorf("recording when the store was initialized: %w", err)
+	}
+	return store, nil
+}
+
+// The record of when the broker started using the store.
+const (
+	storeRecordKind      = "store"
+	initializedRecordKey = "initialized"
+)
+
+// MarkInitialized records that the broker started using the store now,
+// unless it already recorded an earlier time.
+func MarkInitialized(ctx context.Context, store Store) error {
+	if _, err := InitializedAt(ctx, store); !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+	now := time.Now().UTC()
+	data, err := json.Marshal(now)
+	if err != nil {
+		return err
+	}
+	return store.SaveRecord(ctx, Record{Kind: storeRecordKind, Key: initializedRecordKey, Data: data, UpdatedAt: now})
+}
+
+// InitializedAt returns when the broker started using the store. Resources
+// created before then may belong to instances that the store never had.
+func InitializedAt(ctx context.Context, store Store) (time.Time, error) {
+	record, err := store.GetRecord(ctx, storeRecordKind, initializedRecordKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var initializedAt time.Time
+	if err := json.Unmarshal(record.Data, &initializedAt); err != nil {
+		return time.Time{}, err
+	}
+	return initializedAt, nil
+}
+
+func newStore(ctx context.Context, config Config, awsSession *session.Session, logger lager.Logger) (Store, error) {
+	switch config.Backend {
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	case BackendPostgres:
+		return OpenPostgresStore(ctx, config.Postgres, logger)
+	case BackendDynamoDB:
+		awsConfig := aws.NewConfig()
+		if config.DynamoDB.Region != "" {
+			awsConfig.WithRegion(config.DynamoDB.Region)
+		}
+		return NewDynamoDBStore(dynamodb.New(awsSession, awsConfig), config.DynamoDB.Table, logger), nil
+	case BackendS3:
+		awsConfig := aws.NewConfig()
+		if config.S3.Region != "" {
+			awsConfig.WithRegion(config.S3.Region)
+		}
+		return NewS3Store(s3.New(awsSession, awsConfig), config.S3, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", config.Backend)
+	}
+}
+
+func isAWSError(err error, code string) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == code
+}
+
+// MemoryStore keeps state in process memory, so it is lost on restart. It is
+// meant for development and tests.
+type MemoryStore struct {
+	mu         sync.Mutex
+	instances  map[string]Instance
+	bindings   map[string]Binding
+	operations map[string][]Operation
+	usage      map[string]Usage
+	records    map[[2]string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		instances:  make(map[string]Instance),
+		bindings:   make(map[string]Binding),
+		operations: make(map[string][]Operation),
+		usage:      make(map[string]Usage),
+		records:    make(map[[2]string]Record),
+	}
+}
+
+func (m *MemoryStore) GetInstance(ctx context.Context, instanceID string) (Instance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	instance, ok := m.instances[instanceID]
+	if !ok {
+		return Instance{}, ErrInstanceNotFound
+	}
+	return instance, nil
+}
+
+func (m *MemoryStore) SaveInstance(ctx context.Context, instance Instance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.instances[instance.InstanceID] = instance
+	return nil
+}
+
+func (m *MemoryStore) DeleteInstance(ctx context.Context, instanceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.instances, instanceID)
+	return nil
+}
+
+func (m *MemoryStore) ListInstances(ctx context.Context) ([]Instance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Collect(maps.Values(m.instances)), nil
+}
+
+func (m *MemoryStore) GetBinding(ctx context.Context, bindingID string) (Binding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	binding, ok := m.bindings[bindingID]
+	if !ok {
+		return Binding{}, ErrBindingNotFound
+	}
+	return binding, nil
+}
+
+func (m *MemoryStore) SaveBinding(ctx context.Context, binding Binding) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bindings[binding.BindingID] = binding
+	return nil
+}
+
+func (m *MemoryStore) DeleteBinding(ctx context.Context, bindingID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bindings, bindingID)
+	return nil
+}
+
+func (m *MemoryStore) ListBindings(ctx context.Context) ([]Binding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Collect(maps.Values(m.bindings)), nil
+}
+
+func (m *MemoryStore) RecordOperation(ctx context.Context, operation Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operations[operation.InstanceID] = append(m.operations[operation.InstanceID], operation)
+	return nil
+}
+
+func (m *MemoryStore) ListOperations(ctx context.Context, instanceID string, limit int) ([]Operation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.operations[instanceID]
+	operations := make([]Operation, 0, min(len(stored), limit))
+	for i := len(stored) - 1; i >= 0 && len(operations) < limit; i-- {
+		operations = append(operations, stored[i])
+	}
+	return operations, nil
+}
+
+func (m *MemoryStore) GetUsage(ctx context.Context, instanceID string) (Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage, ok := m.usage[instanceID]
+	if !ok {
+		return Usage{}, ErrUsageNotFound
+	}
+	return usage, nil
+}
+
+func (m *MemoryStore) SaveUsage(ctx context.Context, usage Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage[usage.InstanceID] = usage
+	return nil
+}
+
+func (m *MemoryStore) DeleteUsage(ctx context.Context, instanceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.usage, instanceID)
+	return nil
+}
+
+func (m *MemoryStore) ListUsage(ctx context.Context) ([]Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Collect(maps.Values(m.usage)), nil
+}
+
+func (m *MemoryStore) GetRecord(ctx context.Context, kind, key string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.records[[2]string{kind, key}]
+	if !ok {
+		return Record{}, ErrRecordNotFound
+	}
+	return record, nil
+}
+
+func (m *MemoryStore) SaveRecord(ctx context.Context, record Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[[2]string{record.Kind, record.Key}] = record
+	return nil
+}
+
+func (m *MemoryStore) DeleteRecord(ctx context.Context, kind, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, [2]string{kind, key})
+	return nil
+}
+
+func (m *MemoryStore) ListRecords(ctx context.Context) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Collect(maps.Values(m.records)), nil
+}
@@ -0,0 +1,329 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// testStore checks the behavior that every Store implementation shares.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	t.Run("instances", func(t *testing.T) {
+		if _, err := store.GetInstance(ctx, "instance-1"); !errors.Is(err, ErrInstanceNotFound) {
+			t.Fatalf("expected ErrInstanceNotFound, got %v", err)
+		}
+		instance := Instance{
+			InstanceID:       "instance-1",
+			ServiceID:        "service-1",
+			PlanID:           "plan-1",
+			OrganizationGUID: "org-1",
+			SpaceGUID:        "space-1",
+			BucketName:       "bucket-1",
+			Parameters:       json.RawMessage(`{"object_ownership": "BucketOwnerEnforced"}`),
+			CreatedAt:        now,
+			UpdatedAt:        now,
+		}
+		if err := store.SaveInstance(ctx, instance); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		instance.PlanID = "plan-2"
+		instance.Parameters = nil
+		instance.UpdatedAt = now.Add(time.Minute)
+		if err := store.SaveInstance(ctx, instance); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got, err := store.GetInstance(ctx, "instance-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff(instance, got); diff != "" {
+			t.Errorf("unexpected instance (-want +got):\n%s", diff)
+		}
+		instances, err := store.ListInstances(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff([]Instance{instance}, instances); diff != "" {
+			t.Errorf("unexpected instances (-want +got):\n%s", diff)
+		}
+		if err := store.DeleteInstance(ctx, "instance-1"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := store.GetInstance(ctx, "instance-1"); !errors.Is(err, ErrInstanceNotFound) {
+			t.Errorf("expected ErrInstanceNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("bindings", func(t *testing.T) {
+		if _, err := store.GetBinding(ctx, "binding-1"); !errors.Is(err, ErrBindingNotFound) {
+			t.Fatalf("expected ErrBindingNotFound, got %v", err)
+		}
+		binding := Binding{
+			BindingID:  "binding-1",
+			InstanceID: "instance-1",
+			Data:       json.RawMessage(`{"BindingID":"binding-1"}`),
+			CreatedAt:  now,
+		}
+		if err := store.SaveBinding(ctx, binding); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got, err := store.GetBinding(ctx, "binding-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff(binding, got); diff != "" {
+			t.Errorf("unexpected binding (-want +got):\n%s", diff)
+		}
+		bindings, err := store.ListBindings(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff([]Binding{binding}, bindings); diff != "" {
+			t.Errorf("unexpected bindings (-want +got):\n%s", diff)
+		}
+		if err := store.DeleteBinding(ctx, "binding-1"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := store.GetBinding(ctx, "binding-1"); !errors.Is(err, ErrBindingNotFound) {
+			t.Errorf("expected ErrBindingNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("operations", func(t *testing.T) {
+		operations := []Operation{
+			{InstanceID: "instance-2", Action: "provision", User: "user-1", Time: now},
+			{InstanceID: "instance-2", BindingID: "binding-2", Action: "bind", Time: now.Add(time.Second), Error: "failed"},
+			{InstanceID: "instance-2", Action: "update", Time: now.Add(2 * time.Second)},
+			{InstanceID: "instance-3", Action: "provision", Time: now},
+		}
+		for _, operation := range operations {
+			if err := store.RecordOperation(ctx, operation); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		got, err := store.ListOperations(ctx, "instance-2", 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff([]Operation{operations[2], operations[1]}, got); diff != "" {
+			t.Errorf("unexpected operations (-want +got):\n%s", diff)
+		}
+		got, err = store.ListOperations(ctx, "instance-4", 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("expected no operations, got %+v", got)
+		}
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		if _, err := store.GetUsage(ctx, "instance-1"); !errors.Is(err, ErrUsageNotFound) {
+			t.Fatalf("expected ErrUsageNotFound, got %v", err)
+		}
+		usage := Usage{InstanceID: "instance-1", Bytes: 2048, Objects: 3, MeasuredAt: now}
+		if err := store.SaveUsage(ctx, usage); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		usage.Bytes = 4096
+		usage.Truncated = true
+		usage.MeasuredAt = now.Add(time.Hour)
+		if err := store.SaveUsage(ctx, usage); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got, err := store.GetUsage(ctx, "instance-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff(usage, got); diff != "" {
+			t.Errorf("unexpected usage (-want +got):\n%s", diff)
+		}
+		usages, err := store.ListUsage(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff([]Usage{usage}, usages); diff != "" {
+			t.Errorf("unexpected usage (-want +got):\n%s", diff)
+		}
+		if err := store.DeleteUsage(ctx, "instance-1"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := store.GetUsage(ctx, "instance-1"); !errors.Is(err, ErrUsageNotFound) {
+			t.Errorf("expected ErrUsageNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("records", func(t *testing.T) {
+		if _, err := store.GetRecord(ctx, "checkpoint", "bucket/1"); !errors.Is(err, ErrRecordNotFound) {
+			t.Fatalf("expected ErrRecordNotFound, got %v", err)
+		}
+		record := Record{Kind: "checkpoint", Key: "bucket/1", Data: json.RawMessage(`"create-bucket"`), UpdatedAt: now}
+		if err := store.SaveRecord(ctx, record); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		record.Data = json.RawMessage(`"put-bucket-tagging"`)
+		if err := store.SaveRecord(ctx, record); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// Records of other kinds with the same key are kept apart.
+		if err := store.SaveRecord(ctx, Record{Kind: "request", Key: "bucket/1", Data: json.RawMessage(`"other"`), UpdatedAt: now}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got, err := store.GetRecord(ctx, "checkpoint", "bucket/1")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff(record, got); diff != "" {
+			t.Errorf("unexpected record (-want +got):\n%s", diff)
+		}
+		records, err := store.ListRecords(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := []Record{record, {Kind: "request", Key: "bucket/1", Data: json.RawMessage(`"other"`), UpdatedAt: now}}
+		byKind := cmpopts.SortSlices(func(a, b Record) bool { return a.Kind < b.Kind })
+		if diff := cmp.Diff(want, records, byKind); diff != "" {
+			t.Errorf("unexpected records (-want +got):\n%s", diff)
+		}
+		if err := store.DeleteRecord(ctx, "checkpoint", "bucket/1"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := store.GetRecord(ctx, "checkpoint", "bucket/1"); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected ErrRecordNotFound after delete, got %v", err)
+		}
+		if _, err := store.GetRecord(ctx, "request", "bucket/1"); err != nil {
+			t.Errorf("expected the other record to be kept, got %v", err)
+		}
+	})
+}
+
+// testLocker checks the behavior that every Locker implementation shares.
+func testLocker(t *testing.T, locker Locker) {
+	ctx := context.Background()
+
+	locked, err := locker.TryLock(ctx, "instance/1", "broker-a", time.Minute)
+	if err != nil || !locked {
+		t.Fatalf("expected broker-a to lock, got %t, %v", locked, err)
+	}
+	if locked, err := locker.TryLock(ctx, "instance/1", "broker-b", time.Minute); err != nil || locked {
+		t.Fatalf("expected broker-b not to lock while broker-a holds the lock, got %t, %v", locked, err)
+	}
+	if locked, err := locker.TryLock(ctx, "instance/1", "broker-a", time.Minute); err != nil || !locked {
+		t.Fatalf("expected broker-a to extend its lock, got %t, %v", locked, err)
+	}
+	if err := locker.Unlock(ctx, "instance/1", "broker-b"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if locked, err := locker.TryLock(ctx, "instance/1", "broker-b", time.Minute); err != nil || locked {
+		t.Fatalf("expected broker-b's unlock to leave broker-a's lock, got %t, %v", locked, err)
+	}
+	if err := locker.Unlock(ctx, "instance/1", "broker-a"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if locked, err := locker.TryLock(ctx, "instance/1", "broker-b", time.Minute); err != nil || !locked {
+		t.Fatalf("expected broker-b to lock after broker-a unlocked, got %t, %v", locked, err)
+	}
+
+	// A lock whose owner stopped without unlocking expires.
+	if locked, err := locker.TryLock(ctx, "instance/2", "broker-a", -time.Minute); err != nil || !locked {
+		t.Fatalf("expected broker-a to lock, got %t, %v", locked, err)
+	}
+	if locked, err := locker.TryLock(ctx, "instance/2", "broker-b", time.Minute); err != nil || !locked {
+		t.Errorf("expected broker-b to take the expired lock, got %t, %v", locked, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestMarkInitialized(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if _, err := InitializedAt(ctx, store); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+	if err := MarkInitialized(ctx, store); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	initializedAt, err := InitializedAt(ctx, store)
+	if err != nil || initializedAt.IsZero() {
+		t.Fatalf("expected the initialization time, got %s, %v", initializedAt, err)
+	}
+
+	// Marking the store again keeps the first time.
+	time.Sleep(time.Millisecond)
+	if err := MarkInitialized(ctx, store); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if again, err := InitializedAt(ctx, store); err != nil || !again.Equal(initializedAt) {
+		t.Errorf("expected the initialization time %s to be kept, got %s, %v", initializedAt, again, err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		config    Config
+		expectErr bool
+	}{
+		"disabled": {},
+		"memory": {
+			config: Config{Backend: BackendMemory},
+		},
+		"postgres": {
+			config: Config{Backend: BackendPostgres, Postgres: PostgresConfig{URL: "postgres://localhost/broker"}},
+		},
+		"postgres without url": {
+			config:    Config{Backend: BackendPostgres},
+			expectErr: true,
+		},
+		"postgres with negative connections": {
+			config:    Config{Backend: BackendPostgres, Postgres: PostgresConfig{URL: "postgres://localhost/broker", MaxOpenConns: -1}},
+			expectErr: true,
+		},
+		"dynamodb": {
+			config: Config{Backend: BackendDynamoDB, DynamoDB: DynamoDBConfig{Table: "broker-state"}},
+		},
+		"dynamodb without table": {
+			config:    Config{Backend: BackendDynamoDB},
+			expectErr: true,
+		},
+		"s3": {
+			config: Config{Backend: BackendS3, S3: S3Config{Bucket: "broker-state"}},
+		},
+		"s3 without bucket": {
+			config:    Config{Backend: BackendS3},
+			expectErr: true,
+		},
+		"encrypted": {
+			config: Config{Backend: BackendMemory, Encryption: EncryptionConfig{KMSKeyID: "alias/s3-broker-state"}},
+		},
+		"encrypted without backend": {
+			config:    Config{Encryption: EncryptionConfig{KMSKeyID: "alias/s3-broker-state"}},
+			expectErr: true,
+		},
+		"unknown backend": {
+			config:    Config{Backend: "etcd"},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectErr && err == nil {
+				t.Error("expected an error")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloud-gov/s3-broker/state"
+)
+
+const stateUsage = `usage: s3-broker -config FILE state export -file FILE
+       s3-broker -config FILE state import -file FILE`
+
+// runStateCommand runs "state export", which writes every instance and
+// binding in the configured state store to a JSON snapshot file, or "state
+// import", which loads one into it.
+func runStateCommand(ctx context.Context, store state.Store, args []string) error {
+	if len(args) == 0 {
+		return errors.New(stateUsage)
+	}
+	if store == nil {
+		return errors.New("state.backend must be configured")
+	}
+
+	flags := flag.NewFlagSet("state "+args[0], flag.ContinueOnError)
+	file := flags.String("file", "", "Snapshot file")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New(stateUsage)
+	}
+
+	switch args[0] {
+	case "export":
+		snapshot, err := state.Export(ctx, store)
+		if err != nil {
+			return err
+		}
+		// Snapshots hold binding credentials, so only the owner may read
+		// them.
+		f, err := os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(snapshot); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d instances, %d bindings and %d records\n", len(snapshot.Instances), len(snapshot.Bindings), len(snapshot.Records))
+		return nil
+	case "import":
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var snapshot state.Snapshot
+		if err := json.NewDecoder(f).Decode(&snapshot); err != nil {
+			return fmt.Errorf("reading snapshot: %w", err)
+		}
+		if err := state.Import(ctx, store, snapshot); err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d instances, %d bindings and %d records\n", len(snapshot.Instances), len(snapshot.Bindings), len(snapshot.Records))
+		return nil
+	default:
+		return errors.New(stateUsage)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloud-gov/s3-broker/broker"
+)
+
+const terraformUsage = `usage: s3-broker -config FILE terraform [-file FILE]`
+
+// runTerraformCommand runs "terraform", which writes Terraform import blocks
+// for the buckets and IAM resources the broker manages to a file, or to
+// standard output without one.
+func runTerraformCommand(ctx context.Context, serviceBroker *broker.S3Broker, args []string) error {
+	flags := flag.NewFlagSet("terraform", flag.ContinueOnError)
+	file := flags.String("file", "", "Terraform file to write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New(terraformUsage)
+	}
+
+	resources, err := serviceBroker.ManagedResources(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *file == "" {
+		return broker.WriteTerraform(os.Stdout, resources)
+	}
+	f, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	if err := broker.WriteTerraform(f, resources); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote import blocks for %d resources\n", len(resources))
+	return nil
+}
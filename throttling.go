@@ -0,0 +1,30 @@
+package main
+
+import (
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/cloud-gov/s3-broker/awsmetrics"
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+// throttleHandler returns the func that awsretry calls when AWS throttles a
+// call. It logs the throttle and, with a recorder, counts it by operation
+// and records the rate that the limiter, if any, cut to.
+func throttleHandler(limiter *awsretry.Limiter, recorder awsmetrics.Recorder, logger lager.Logger) func(operation string) {
+	logger = logger.Session("aws-throttling")
+	return func(operation string) {
+		data := lager.Data{"operation": operation}
+		if limiter != nil {
+			data["rate"] = limiter.Rate()
+		}
+		logger.Info("throttled", data)
+		if recorder == nil {
+			return
+		}
+		recorder.Count("Throttles", map[string]string{"Operation": operation})
+		if limiter != nil {
+			recorder.Gauge("RateLimit", limiter.Rate(), cloudwatch.StandardUnitCountSecond, nil)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3/lagertest"
+
+	"github.com/cloud-gov/s3-broker/awsretry"
+)
+
+type recordedMetric struct {
+	name       string
+	dimensions map[string]string
+}
+
+type testMetricsRecorder struct {
+	metrics []recordedMetric
+}
+
+func (r *testMetricsRecorder) Count(name string, dimensions map[string]string) {
+	r.metrics = append(r.metrics, recordedMetric{name: name, dimensions: dimensions})
+}
+
+func (r *testMetricsRecorder) Gauge(name string, value float64, unit string, dimensions map[string]string) {
+	r.metrics = append(r.metrics, recordedMetric{name: name, dimensions: dimensions})
+}
+
+func TestThrottleHandler(t *testing.T) {
+	testCases := map[string]struct {
+		limiter       *awsretry.Limiter
+		expectMetrics []string
+	}{
+		"without a limiter": {
+			expectMetrics: []string{"Throttles"},
+		},
+		"with a limiter": {
+			limiter:       awsretry.NewLimiter(awsretry.RateLimitConfig{RequestsPerSecond: 10}),
+			expectMetrics: []string{"Throttles", "RateLimit"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &testMetricsRecorder{}
+			logger := lagertest.NewTestLogger("throttling")
+			throttleHandler(tc.limiter, recorder, logger)("CreateUser")
+
+			if len(recorder.metrics) != len(tc.expectMetrics) {
+				t.Fatalf("expected metrics %v, got %v", tc.expectMetrics, recorder.metrics)
+			}
+			for i, name := range tc.expectMetrics {
+				if recorder.metrics[i].name != name {
+					t.Errorf("expected metric %s, got %s", name, recorder.metrics[i].name)
+				}
+			}
+			if operation := recorder.metrics[0].dimensions["Operation"]; operation != "CreateUser" {
+				t.Errorf("expected the Operation dimension to be CreateUser, got %q", operation)
+			}
+			if len(logger.LogMessages()) != 1 {
+				t.Errorf("expected the throttle to be logged, got %v", logger.LogMessages())
+			}
+		})
+	}
+}
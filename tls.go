@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// TLSConfig makes the broker serve its API over TLS, so it can be exposed
+// without a TLS-terminating proxy in front of it.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ReloadInterval is how often the broker checks the certificate and key
+	// files for changes, and reloads them if they changed, so that rotated
+	// certificates are served without a restart. Zero disables reloading.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+func (c TLSConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("Must provide both CertFile and KeyFile")
+	}
+	if c.ReloadInterval < 0 {
+		return errors.New("ReloadInterval must not be negative")
+	}
+	return nil
+}
+
+// certReloader serves the certificate in a TLSConfig's files, reloading it
+// when the files change.
+type certReloader struct {
+	config TLSConfig
+	logger lager.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+func newCertReloader(config TLSConfig, logger lager.Logger) (*certReloader, error) {
+	r := &certReloader{config: config, logger: logger.Session("tls")}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// reload loads the certificate again if either file changed since it was
+// last loaded, and reports whether it did.
+func (r *certReloader) reload() (bool, error) {
+	modTimes, err := r.fileModTimes()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.modTimes = &cert, modTimes
+	return true, nil
+}
+
+func (r *certReloader) fileModTimes() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for idx, name := range []string{r.config.CertFile, r.config.KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[idx] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Run reloads the certificate every ReloadInterval until ctx is done. A
+// certificate that fails to load is logged and the previous one is kept,
+// since a rotation may have written only one of the files so far.
+func (r *certReloader) Run(ctx context.Context) {
+	if r.config.ReloadInterval == 0 {
+		return
+	}
+	ticker := time.NewTicker(r.config.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.reload()
+			if err != nil {
+				r.logger.Error("reload", err)
+			} else if reloaded {
+				r.logger.Info("reloaded", lager.Data{"cert_file": r.config.CertFile})
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// writeCert writes a self-signed certificate for commonName and its key to
+// dir, with the files' modification times set to modTime.
+func writeCert(t *testing.T, dir, commonName string, modTime time.Time) TLSConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := TLSConfig{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	files := map[string]*pem.Block{
+		config.CertFile: {Type: "CERTIFICATE", Bytes: der},
+		config.KeyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for name, block := range files {
+		if err := os.WriteFile(name, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return config
+}
+
+func servedName(t *testing.T, r *certReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Minute)
+	config := writeCert(t, dir, "first", start)
+
+	r, err := newCertReloader(config, lager.NewLogger("test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if name := servedName(t, r); name != "first" {
+		t.Fatalf("expected the first certificate, got %s", name)
+	}
+
+	if reloaded, err := r.reload(); err != nil || reloaded {
+		t.Errorf("expected unchanged files not to be reloaded, got %t, %v", reloaded, err)
+	}
+
+	writeCert(t, dir, "second", start.Add(time.Second))
+	if reloaded, err := r.reload(); err != nil || !reloaded {
+		t.Fatalf("expected changed files to be reloaded, got %t, %v", reloaded, err)
+	}
+	if name := servedName(t, r); name != "second" {
+		t.Errorf("expected the second certificate, got %s", name)
+	}
+
+	// A rotation that has replaced only the certificate so far leaves the
+	// previous certificate in place.
+	if err := os.WriteFile(config.KeyFile, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reload(); err == nil {
+		t.Error("expected an error loading a mismatched key")
+	}
+	if name := servedName(t, r); name != "second" {
+		t.Errorf("expected the second certificate to still be served, got %s", name)
+	}
+}
+
+func TestNewCertReloaderMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, err := newCertReloader(TLSConfig{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}, lager.NewLogger("test"))
+	if err == nil {
+		t.Error("expected an error for missing files")
+	}
+}
@@ -0,0 +1,67 @@
+// Package tokenauth authenticates broker API requests with OAuth2 bearer
+// tokens issued by UAA or another OpenID Connect provider.
+package tokenauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Config is the issuer whose tokens the broker accepts.
+type Config struct {
+	// Issuer is the iss claim of accepted tokens, such as
+	// https://uaa.example.com/oauth/token.
+	Issuer string `yaml:"issuer"`
+	// KeysURL serves the issuer's signing keys as a JSON Web Key Set, such
+	// as UAA's /token_keys or an OpenID Connect provider's jwks_uri.
+	KeysURL string `yaml:"keys_url"`
+	// Audience, if set, must be one of a token's aud claims.
+	Audience string `yaml:"audience"`
+	// Scopes must all be granted to a token, such as
+	// cloud_controller.admin or a scope created for the platform's client.
+	Scopes []string `yaml:"scopes"`
+	// CACert is a PEM bundle trusted in addition to the system roots when
+	// fetching keys.
+	CACert string `yaml:"ca_cert"`
+}
+
+func (c Config) Enabled() bool {
+	return c.Issuer != ""
+}
+
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	keysURL, err := url.Parse(c.KeysURL)
+	if err != nil || keysURL.Scheme != "https" && keysURL.Scheme != "http" || keysURL.Host == "" {
+		return errors.New("Must provide an http or https KeysURL")
+	}
+	if len(c.Scopes) == 0 {
+		return errors.New("Must provide the Scopes that tokens need")
+	}
+	if _, err := c.httpClient(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// httpClient returns the client that fetches keys.
+func (c Config) httpClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(c.CACert)) {
+			return nil, errors.New("CACert contains no PEM certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}, nil
+}
@@ -0,0 +1,216 @@
+package tokenauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// keysRefreshInterval is the least time between fetches of the issuer's
+// keys, so that tokens naming unknown keys cannot make the broker hammer the
+// issuer.
+const keysRefreshInterval = time.Minute
+
+var (
+	errUnknownKey   = errors.New("token is signed with an unknown key")
+	errNoUsableKeys = errors.New("the issuer has no keys that can verify tokens")
+)
+
+// jsonWebKey is a key of a JSON Web Key Set. UAA's token_keys use the same
+// format.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keySet caches the issuer's signing keys by ID.
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// fetching is the fetch in progress, if any. It is made without holding
+	// mu, so that known keys can be looked up meanwhile, and callers looking
+	// for unknown keys wait for it rather than fetching again.
+	fetching *keyFetch
+	now      func() time.Time
+	logger   lager.Logger
+}
+
+// keyFetch is a fetch of the issuer's keys. done is closed once it is over
+// and err is set.
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// key returns the key called kid, fetching the issuer's keys if it is not
+// known. Keys are fetched at most once every keysRefreshInterval.
+func (s *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	if key, ok := s.lookup(kid); ok {
+		s.mu.Unlock()
+		return key, nil
+	}
+	fetch := s.fetching
+	if fetch == nil {
+		if !s.fetched.IsZero() && s.now().Sub(s.fetched) < keysRefreshInterval {
+			s.mu.Unlock()
+			return nil, errUnknownKey
+		}
+		fetch = &keyFetch{done: make(chan struct{})}
+		s.fetching = fetch
+		// The fetch is shared with other callers, so it outlives this
+		// request; the client's timeout bounds it.
+		go s.fetch(context.WithoutCancel(ctx), fetch)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-fetch.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if fetch.err != nil {
+		return nil, fmt.Errorf("fetching token keys: %w", fetch.err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, errUnknownKey
+}
+
+// fetch fetches the issuer's keys and swaps them in.
+func (s *keySet) fetch(ctx context.Context, fetch *keyFetch) {
+	keys, err := s.fetchKeys(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetched = s.now()
+	if err == nil {
+		s.keys = keys
+	}
+	s.fetching = nil
+	fetch.err = err
+	close(fetch.done)
+}
+
+// lookup finds kid among the cached keys. Tokens without a kid may be
+// verified with the issuer's only key.
+func (s *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+func (s *keySet) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", s.url, resp.Status)
+	}
+
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	// Issuers publish keys of types and curves that the broker does not
+	// support, and keys for encryption, alongside their signing keys. Those
+	// are skipped rather than failing the rest.
+	keys := make(map[string]crypto.PublicKey, len(body.Keys))
+	for _, jwk := range body.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			s.logger.Debug("skipped", lager.Data{"kid": jwk.Kid, "use": jwk.Use})
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			s.logger.Info("skipped", lager.Data{"kid": jwk.Kid, "kty": jwk.Kty, "reason": err.Error()})
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errNoUsableKeys
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if _, err := key.ECDH(); err != nil {
+			return nil, err
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
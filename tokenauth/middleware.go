@@ -0,0 +1,43 @@
+package tokenauth
+
+import (
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const notAuthorized = "Not Authorized"
+
+// Middleware authenticates requests that carry a bearer token with the
+// verifier. Other requests are passed to basic, which checks their basic-auth
+// credentials, or are rejected if basic is nil.
+func (v *Verifier) Middleware(basic func(http.Handler) http.Handler, logger lager.Logger) func(http.Handler) http.Handler {
+	logger = logger.Session("token-auth")
+	return func(next http.Handler) http.Handler {
+		var basicNext http.Handler
+		if basic != nil {
+			basicNext = basic(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				if basicNext == nil {
+					http.Error(w, notAuthorized, http.StatusUnauthorized)
+					return
+				}
+				basicNext.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := v.Verify(r.Context(), strings.TrimSpace(token))
+			if err != nil {
+				logger.Info("rejected", lager.Data{"reason": err.Error(), "path": r.URL.Path})
+				http.Error(w, notAuthorized, http.StatusUnauthorized)
+				return
+			}
+			logger.Debug("accepted", lager.Data{"client-id": claims.ClientID, "subject": claims.Subject})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
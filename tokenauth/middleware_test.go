@@ -0,0 +1,72 @@
+package tokenauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pivotal-cf/brokerapi/v10/auth"
+)
+
+func TestMiddleware(t *testing.T) {
+	issuer := newIssuer(t)
+	v, err := NewVerifier(issuer.config(), lager.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic := auth.NewWrapper("broker", "secret").Wrap
+
+	testCases := map[string]struct {
+		basic        func(http.Handler) http.Handler
+		setAuth      func(*http.Request)
+		expectStatus int
+	}{
+		"valid token": {
+			setAuth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+issuer.sign(t, "RS256", "rsa-key", validClaims()))
+			},
+			expectStatus: http.StatusOK,
+		},
+		"invalid token": {
+			basic: basic,
+			setAuth: func(r *http.Request) {
+				claims := validClaims()
+				claims["scope"] = []string{"openid"}
+				r.Header.Set("Authorization", "Bearer "+issuer.sign(t, "RS256", "rsa-key", claims))
+			},
+			expectStatus: http.StatusUnauthorized,
+		},
+		"basic auth": {
+			basic:        basic,
+			setAuth:      func(r *http.Request) { r.SetBasicAuth("broker", "secret") },
+			expectStatus: http.StatusOK,
+		},
+		"wrong basic auth": {
+			basic:        basic,
+			setAuth:      func(r *http.Request) { r.SetBasicAuth("broker", "wrong") },
+			expectStatus: http.StatusUnauthorized,
+		},
+		"basic auth without basic credentials": {
+			setAuth:      func(r *http.Request) { r.SetBasicAuth("broker", "secret") },
+			expectStatus: http.StatusUnauthorized,
+		},
+		"no credentials": {
+			basic:        basic,
+			setAuth:      func(r *http.Request) {},
+			expectStatus: http.StatusUnauthorized,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			handler := v.Middleware(tc.basic, lager.NewLogger("test"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+			tc.setAuth(r)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.expectStatus {
+				t.Errorf("expected status %d, got %d", tc.expectStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package tokenauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// clockSkew is how far the issuer's clock may be from the broker's.
+const clockSkew = time.Minute
+
+var (
+	ErrMalformedToken = errors.New("malformed token")
+	ErrTokenExpired   = errors.New("token has expired")
+)
+
+// Claims are the claims of a token that the broker checks.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	ClientID  string   `json:"client_id"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Scopes    scopes   `json:"scope"`
+}
+
+// audience is a token's aud claim, which is a string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// scopes is a token's scope claim: a list in UAA tokens, and a
+// space-separated string in OAuth2 access tokens elsewhere.
+type scopes []string
+
+func (s *scopes) UnmarshalJSON(data []byte) error {
+	var joined string
+	if err := json.Unmarshal(data, &joined); err == nil {
+		*s = strings.Fields(joined)
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(s))
+}
+
+// Verifier checks bearer tokens against a Config.
+type Verifier struct {
+	config Config
+	keys   *keySet
+	now    func() time.Time
+}
+
+// NewVerifier returns a Verifier for config. logger logs keys of the issuer
+// that are skipped because they cannot verify tokens.
+func NewVerifier(config Config, logger lager.Logger) (*Verifier, error) {
+	client, err := config.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{
+		config: config,
+		keys:   &keySet{url: config.KeysURL, client: client, now: time.Now, logger: logger.Session("token-keys")},
+		now:    time.Now,
+	}, nil
+}
+
+// Verify returns the claims of token if it is signed by the issuer, is
+// current, and has the configured audience and scopes.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, ErrMalformedToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformedToken
+	}
+
+	key, err := v.keys.key(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return Claims{}, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, ErrMalformedToken
+	}
+	return claims, v.checkClaims(claims)
+}
+
+func (v *Verifier) checkClaims(claims Claims) error {
+	now := v.now()
+	if claims.Issuer != v.config.Issuer {
+		return fmt.Errorf("token is issued by %q, not %q", claims.Issuer, v.config.Issuer)
+	}
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if v.config.Audience != "" && !slices.Contains(claims.Audience, v.config.Audience) {
+		return fmt.Errorf("token is not for audience %q", v.config.Audience)
+	}
+	for _, scope := range v.config.Scopes {
+		if !slices.Contains(claims.Scopes, scope) {
+			return fmt.Errorf("token does not have scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// verifySignature checks an RS256 or ES256 signature. Other algorithms,
+// including none and the HMAC ones, are rejected.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 token is signed with a key that is not RSA")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("token signature is invalid")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("ES256 token is signed with a key that is not P-256")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return errors.New("token signature is invalid")
+		}
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
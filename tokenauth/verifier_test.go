@@ -0,0 +1,345 @@
+package tokenauth
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const testIssuer = "https://uaa.example.com/oauth/token"
+
+// issuer signs tokens and serves its keys like UAA's token_keys.
+type issuer struct {
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	server  *httptest.Server
+	fetches int
+	// If release is set, key fetches are announced on fetching and wait
+	// until release is closed.
+	fetching chan struct{}
+	release  chan struct{}
+	// otherKeys are served with the issuer's signing keys, and keys are
+	// left out if onlyOtherKeys is set.
+	otherKeys     []map[string]string
+	onlyOtherKeys bool
+}
+
+func newIssuer(t *testing.T) *issuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &issuer{rsaKey: rsaKey, ecKey: ecKey}
+	i.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i.fetches++
+		if i.release != nil {
+			i.fetching <- struct{}{}
+			<-i.release
+		}
+		encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+		keys := []map[string]string{
+			{"kty": "RSA", "kid": "rsa-key", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec-key", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+		}
+		if i.onlyOtherKeys {
+			keys = nil
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": append(keys, i.otherKeys...)})
+	}))
+	t.Cleanup(i.server.Close)
+	return i
+}
+
+func (i *issuer) config() Config {
+	return Config{Issuer: testIssuer, KeysURL: i.server.URL, Scopes: []string{"s3-broker.admin"}}
+}
+
+func (i *issuer) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, i.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":       testIssuer,
+		"sub":       "cloud_controller",
+		"client_id": "cloud_controller",
+		"aud":       []string{"s3-broker", "cloud_controller"},
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"scope":     []string{"s3-broker.admin", "openid"},
+	}
+}
+
+func TestVerify(t *testing.T) {
+	testCases := map[string]struct {
+		alg       string
+		kid       string
+		claims    func(map[string]any)
+		audience  string
+		token     string
+		expectErr string
+	}{
+		"RS256 token": {},
+		"ES256 token": {
+			alg: "ES256",
+			kid: "ec-key",
+		},
+		"scopes as a string": {
+			claims: func(c map[string]any) { c["scope"] = "openid s3-broker.admin" },
+		},
+		"audience": {
+			audience: "s3-broker",
+		},
+		"audience as a string": {
+			claims:   func(c map[string]any) { c["aud"] = "s3-broker" },
+			audience: "s3-broker",
+		},
+		"other audience": {
+			audience:  "credhub",
+			expectErr: `token is not for audience "credhub"`,
+		},
+		"missing scope": {
+			claims:    func(c map[string]any) { c["scope"] = []string{"openid"} },
+			expectErr: `token does not have scope "s3-broker.admin"`,
+		},
+		"expired": {
+			claims:    func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			expectErr: "token has expired",
+		},
+		"expired within the clock skew": {
+			claims: func(c map[string]any) { c["exp"] = time.Now().Add(-10 * time.Second).Unix() },
+		},
+		"no expiry": {
+			claims:    func(c map[string]any) { delete(c, "exp") },
+			expectErr: "token has expired",
+		},
+		"not valid yet": {
+			claims:    func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+			expectErr: "token is not valid yet",
+		},
+		"other issuer": {
+			claims:    func(c map[string]any) { c["iss"] = "https://uaa.other.example.com/oauth/token" },
+			expectErr: `token is issued by "https://uaa.other.example.com/oauth/token", not "https://uaa.example.com/oauth/token"`,
+		},
+		"unknown key": {
+			kid:       "other-key",
+			expectErr: "token is signed with an unknown key",
+		},
+		"algorithm does not match the key": {
+			alg:       "ES256",
+			kid:       "rsa-key",
+			expectErr: "ES256 token is signed with a key that is not P-256",
+		},
+		"unsigned": {
+			alg:       "none",
+			expectErr: `unsupported token algorithm "none"`,
+		},
+		"tampered": {
+			token:     "tampered",
+			expectErr: "token signature is invalid",
+		},
+		"malformed": {
+			token:     "not-a-token",
+			expectErr: "malformed token",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			issuer := newIssuer(t)
+			config := issuer.config()
+			config.Audience = tc.audience
+			v, err := NewVerifier(config, lager.NewLogger("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := validClaims()
+			if tc.claims != nil {
+				tc.claims(claims)
+			}
+			alg, kid := cmp.Or(tc.alg, "RS256"), cmp.Or(tc.kid, "rsa-key")
+			token := issuer.sign(t, alg, kid, claims)
+			switch tc.token {
+			case "tampered":
+				parts := strings.Split(token, ".")
+				claims["scope"] = []string{"s3-broker.admin", "cloud_controller.admin"}
+				forged := strings.Split(issuer.sign(t, alg, kid, claims), ".")
+				token = parts[0] + "." + forged[1] + "." + parts[2]
+			case "":
+			default:
+				token = tc.token
+			}
+
+			verified, err := v.Verify(context.Background(), token)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if verified.ClientID != "cloud_controller" {
+				t.Errorf("expected client cloud_controller, got %q", verified.ClientID)
+			}
+		})
+	}
+}
+
+func TestVerifyFetchesKeys(t *testing.T) {
+	issuer := newIssuer(t)
+	v, err := NewVerifier(issuer.config(), lager.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	v.keys.now = func() time.Time { return now }
+
+	for range 2 {
+		if _, err := v.Verify(context.Background(), issuer.sign(t, "RS256", "rsa-key", validClaims())); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if issuer.fetches != 1 {
+		t.Errorf("expected the keys to be fetched once, got %d fetches", issuer.fetches)
+	}
+
+	// Unknown keys refetch the keys, but not more than once a minute.
+	for range 2 {
+		if _, err := v.Verify(context.Background(), issuer.sign(t, "RS256", "rotated-key", validClaims())); !errors.Is(err, errUnknownKey) {
+			t.Fatalf("expected unknown key error, got %v", err)
+		}
+	}
+	if issuer.fetches != 1 {
+		t.Errorf("expected no fetches within a minute, got %d fetches", issuer.fetches)
+	}
+	now = now.Add(keysRefreshInterval)
+	if _, err := v.Verify(context.Background(), issuer.sign(t, "RS256", "rotated-key", validClaims())); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+	if issuer.fetches != 2 {
+		t.Errorf("expected the keys to be fetched again, got %d fetches", issuer.fetches)
+	}
+}
+
+func TestVerifySkipsUnusableKeys(t *testing.T) {
+	unusable := []map[string]string{
+		{"kty": "OKP", "kid": "ed25519-key", "crv": "Ed25519", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		{"kty": "EC", "kid": "p384-key", "crv": "P-384", "x": "AA", "y": "AA"},
+		{"kty": "RSA", "kid": "encryption-key", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "RSA", "kid": "broken-key", "n": "!", "e": "AQAB"},
+	}
+	testCases := map[string]struct {
+		onlyUnusable bool
+		expectErr    error
+	}{
+		"alongside signing keys": {},
+		"without signing keys": {
+			onlyUnusable: true,
+			expectErr:    errNoUsableKeys,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			issuer := newIssuer(t)
+			issuer.otherKeys, issuer.onlyOtherKeys = unusable, tc.onlyUnusable
+			v, err := NewVerifier(issuer.config(), lager.NewLogger("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = v.Verify(context.Background(), issuer.sign(t, "RS256", "rsa-key", validClaims()))
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifyDuringKeyFetch(t *testing.T) {
+	issuer := newIssuer(t)
+	v, err := NewVerifier(issuer.config(), lager.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	v.keys.now = func() time.Time { return now }
+	if _, err := v.Verify(context.Background(), issuer.sign(t, "RS256", "rsa-key", validClaims())); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	now = now.Add(keysRefreshInterval)
+	issuer.fetching, issuer.release = make(chan struct{}), make(chan struct{})
+	results := make(chan error)
+	verifyRotated := func() {
+		_, err := v.Verify(context.Background(), issuer.sign(t, "RS256", "rotated-key", validClaims()))
+		results <- err
+	}
+	go verifyRotated()
+	<-issuer.fetching
+
+	// Known keys are verified while the keys are fetched.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := v.Verify(ctx, issuer.sign(t, "ES256", "ec-key", validClaims())); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Unknown keys wait for the fetch in progress.
+	go verifyRotated()
+	close(issuer.release)
+	for range 2 {
+		if err := <-results; !errors.Is(err, errUnknownKey) {
+			t.Errorf("expected unknown key error, got %v", err)
+		}
+	}
+	if issuer.fetches != 2 {
+		t.Errorf("expected the keys to be fetched once more, got %d fetches", issuer.fetches)
+	}
+}